@@ -0,0 +1,34 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: MPL-2.0
+
+package main
+
+import (
+	"fmt"
+	"io"
+	"text/tabwriter"
+	"time"
+
+	"github.com/hashicorp/consul-replicate/replicate"
+)
+
+// renderBench writes a table of the throughput and lag percentiles of every
+// benchmarked prefix, for the bench command.
+func renderBench(w io.Writer, results []*replicate.BenchResult) {
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "PREFIX\tWRITES\tREPLICATED\tSUPERSEDED\tLOST\tCHANGES/S\tP50\tP90\tP99\tMAX\tERROR")
+	for _, result := range results {
+		prefix := fmt.Sprintf("%s@%s:%s", result.Source, result.Datacenter, result.Destination)
+		if result.Err != nil {
+			fmt.Fprintf(tw, "%s\t-\t-\t-\t-\t-\t-\t-\t-\t-\t%s\n", prefix, result.Err)
+			continue
+		}
+
+		r := result.Result
+		fmt.Fprintf(tw, "%s\t%d\t%d\t%d\t%d\t%.1f\t%s\t%s\t%s\t%s\t-\n",
+			prefix, r.Writes, r.Replicated, r.Superseded, r.Lost, r.Throughput,
+			r.P50.Round(time.Millisecond), r.P90.Round(time.Millisecond),
+			r.P99.Round(time.Millisecond), r.Max.Round(time.Millisecond))
+	}
+	tw.Flush()
+}
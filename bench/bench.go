@@ -0,0 +1,325 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: MPL-2.0
+
+// Package bench measures how fast a running replicator copies changes from
+// its source into its destination. It writes synthetic churn into a scratch
+// folder of the source, at a fixed rate, and watches the destination for every
+// change, so performance regressions show up as lower throughput or higher lag
+// percentiles:
+//
+//	result, err := bench.Run(ctx, source, destination, "global/bench/", "global/bench/", &bench.Options{
+//		Keys:      1000,
+//		ValueSize: 512,
+//		Rate:      100,
+//		Duration:  time.Minute,
+//		Drain:     30 * time.Second,
+//	})
+//
+// It is meant for dev and staging clusters, as the churn adds load to both.
+package bench
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"log"
+	"math"
+	"math/rand"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/hashicorp/consul/api"
+)
+
+// Source is the KV store the churn is written into.
+type Source interface {
+	// Put writes the pair.
+	Put(ctx context.Context, pair *api.KVPair) error
+
+	// DeleteTree removes every key under the prefix.
+	DeleteTree(ctx context.Context, prefix string) error
+}
+
+// Destination is the KV store the churn is replicated into.
+type Destination interface {
+	// List returns the pairs under the prefix and their index. It blocks
+	// while the index is waitIndex, until a wait chosen by the store expires.
+	List(ctx context.Context, prefix string, waitIndex uint64) ([]*api.KVPair, uint64, error)
+}
+
+// Options configures the churn of a benchmark.
+type Options struct {
+	// Keys is the number of distinct keys the churn writes.
+	Keys int
+
+	// ValueSize is the size of every value written, in bytes.
+	ValueSize int
+
+	// Rate is the number of changes written per second.
+	Rate float64
+
+	// Duration is how long the churn runs.
+	Duration time.Duration
+
+	// Drain is how long the benchmark waits for the last changes to be
+	// replicated once the churn stops.
+	Drain time.Duration
+}
+
+// Validate returns an error if the options cannot generate churn.
+func (o *Options) Validate() error {
+	switch {
+	case o.Keys < 1:
+		return fmt.Errorf("bench: expected at least one key, got %d", o.Keys)
+	case o.ValueSize < 0:
+		return fmt.Errorf("bench: expected a value size of at least zero, got %d", o.ValueSize)
+	case o.Rate <= 0:
+		return fmt.Errorf("bench: expected a positive rate, got %g", o.Rate)
+	case o.Duration <= 0:
+		return fmt.Errorf("bench: expected a positive duration, got %s", o.Duration)
+	}
+	return nil
+}
+
+// Result is the outcome of a benchmark.
+type Result struct {
+	// Writes is the number of changes written into the source.
+	Writes int
+
+	// Replicated is the number of changes seen at the destination, and
+	// Superseded the number of changes overwritten in the source before they
+	// were seen, which a replicator is free to skip.
+	Replicated int
+	Superseded int
+
+	// Lost is the number of changes not seen at the destination within the
+	// drain.
+	Lost int
+
+	// Elapsed is the time from the first write to the last change seen at the
+	// destination, and Throughput the number of changes seen per second over
+	// it.
+	Elapsed    time.Duration
+	Throughput float64
+
+	// P50, P90 and P99 are the percentiles of the time between the write of a
+	// change and the moment it was seen at the destination, and Max the
+	// longest of them.
+	P50, P90, P99, Max time.Duration
+}
+
+// write is a change written into the source and not seen at the destination
+// yet.
+type write struct {
+	seq uint64
+	at  time.Time
+}
+
+// tracker matches the changes seen at the destination with the writes.
+type tracker struct {
+	sync.Mutex
+	pending    map[string]*write
+	lags       []time.Duration
+	superseded int
+	last       time.Time
+	drainedCh  chan struct{}
+}
+
+// wrote records the write of a change of the key.
+func (t *tracker) wrote(key string, w *write) {
+	t.Lock()
+	defer t.Unlock()
+	if _, ok := t.pending[key]; ok {
+		t.superseded++
+	}
+	t.pending[key] = w
+}
+
+// saw records the change of the key with the sequence number seen at the
+// destination.
+func (t *tracker) saw(key string, seq uint64, now time.Time) {
+	t.Lock()
+	defer t.Unlock()
+	w, ok := t.pending[key]
+	if !ok || w.seq != seq {
+		return
+	}
+	delete(t.pending, key)
+	t.lags = append(t.lags, now.Sub(w.at))
+	t.last = now
+	if len(t.pending) == 0 && t.drainedCh != nil {
+		close(t.drainedCh)
+		t.drainedCh = nil
+	}
+}
+
+// drained returns a channel closed once every write was seen.
+func (t *tracker) drained() <-chan struct{} {
+	t.Lock()
+	defer t.Unlock()
+	ch := make(chan struct{})
+	if len(t.pending) == 0 {
+		close(ch)
+		return ch
+	}
+	t.drainedCh = ch
+	return ch
+}
+
+// Run writes churn under the source prefix for the duration of the options,
+// watches the destination prefix the source prefix is replicated into, and
+// returns the throughput and lag percentiles of the replication. Every key
+// under the source prefix is removed when it returns.
+func Run(ctx context.Context, source Source, destination Destination, sourcePrefix, destinationPrefix string, o *Options) (*Result, error) {
+	if err := o.Validate(); err != nil {
+		return nil, err
+	}
+
+	t := &tracker{pending: make(map[string]*write)}
+	watchCtx, cancel := context.WithCancel(ctx)
+	doneCh := make(chan struct{})
+	var watchErr error
+	go func() {
+		defer close(doneCh)
+		watchErr = watch(watchCtx, destination, destinationPrefix, t)
+	}()
+	defer func() {
+		cancel()
+		<-doneCh
+	}()
+
+	defer func() {
+		if err := source.DeleteTree(context.Background(), sourcePrefix); err != nil {
+			log.Printf("[WARN] (bench) failed to remove %q: %s", sourcePrefix, err)
+		}
+	}()
+
+	interval := time.Duration(float64(time.Second) / o.Rate)
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	start := time.Now()
+	stop := time.NewTimer(o.Duration)
+	defer stop.Stop()
+
+	var seq uint64
+	result := &Result{}
+churn:
+	for {
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-doneCh:
+			return nil, fmt.Errorf("bench: watching %q: %s", destinationPrefix, watchErr)
+		case <-stop.C:
+			break churn
+		case <-ticker.C:
+		}
+
+		seq++
+		key := fmt.Sprintf("%s%d", sourcePrefix, rand.Intn(o.Keys))
+		t.wrote(destinationPrefix+strings.TrimPrefix(key, sourcePrefix), &write{seq: seq, at: time.Now()})
+		if err := source.Put(ctx, &api.KVPair{Key: key, Value: value(seq, o.ValueSize)}); err != nil {
+			return nil, fmt.Errorf("bench: writing %q: %s", key, err)
+		}
+		result.Writes++
+	}
+
+	// Give the replicator time to catch up with the last changes
+	drain := time.NewTimer(o.Drain)
+	defer drain.Stop()
+	select {
+	case <-t.drained():
+	case <-drain.C:
+	case <-doneCh:
+		return nil, fmt.Errorf("bench: watching %q: %s", destinationPrefix, watchErr)
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+
+	t.Lock()
+	defer t.Unlock()
+	result.Replicated = len(t.lags)
+	result.Superseded = t.superseded
+	result.Lost = len(t.pending)
+	if !t.last.IsZero() {
+		result.Elapsed = t.last.Sub(start)
+	}
+	if result.Elapsed > 0 {
+		result.Throughput = float64(result.Replicated) / result.Elapsed.Seconds()
+	}
+
+	lags := append([]time.Duration(nil), t.lags...)
+	sort.Slice(lags, func(i, j int) bool { return lags[i] < lags[j] })
+	result.P50 = percentile(lags, 0.50)
+	result.P90 = percentile(lags, 0.90)
+	result.P99 = percentile(lags, 0.99)
+	if len(lags) > 0 {
+		result.Max = lags[len(lags)-1]
+	}
+	return result, nil
+}
+
+// watch lists the destination prefix every time it changes, and records the
+// changes seen, until the context is cancelled.
+func watch(ctx context.Context, destination Destination, prefix string, t *tracker) error {
+	var index uint64
+	for {
+		pairs, next, err := destination.List(ctx, prefix, index)
+		if ctx.Err() != nil {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+
+		now := time.Now()
+		for _, pair := range pairs {
+			if seq, ok := parseSeq(pair.Value); ok {
+				t.saw(pair.Key, seq, now)
+			}
+		}
+
+		// Like a blocking query, an index going backwards starts over
+		if next < index {
+			next = 0
+		}
+		index = next
+	}
+}
+
+// value returns a value of the given size which starts with the sequence
+// number of the change, on a line of its own. It is longer if the sequence
+// number does not fit.
+func value(seq uint64, size int) []byte {
+	v := []byte(strconv.FormatUint(seq, 10) + "\n")
+	if len(v) < size {
+		v = append(v, bytes.Repeat([]byte("x"), size-len(v))...)
+	}
+	return v
+}
+
+// parseSeq returns the sequence number the value starts with.
+func parseSeq(value []byte) (uint64, bool) {
+	s := string(value)
+	if i := strings.IndexByte(s, '\n'); i >= 0 {
+		s = s[:i]
+	}
+	seq, err := strconv.ParseUint(s, 10, 64)
+	return seq, err == nil
+}
+
+// percentile returns the p-th percentile of the sorted durations.
+func percentile(sorted []time.Duration, p float64) time.Duration {
+	if len(sorted) == 0 {
+		return 0
+	}
+	i := int(math.Ceil(p*float64(len(sorted)))) - 1
+	if i < 0 {
+		i = 0
+	}
+	return sorted[i]
+}
@@ -0,0 +1,178 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: MPL-2.0
+
+package bench
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/hashicorp/consul/api"
+)
+
+// fakeKV is a source which copies the keys written under "global/" into
+// "backup/" when it replicates, like a running replicator would, and the
+// destination it copies them into.
+type fakeKV struct {
+	sync.Mutex
+	replicate bool
+	pairs     map[string][]byte
+	index     uint64
+	changedCh chan struct{}
+}
+
+func newFakeKV(replicate bool) *fakeKV {
+	return &fakeKV{replicate: replicate, pairs: make(map[string][]byte), changedCh: make(chan struct{})}
+}
+
+func (kv *fakeKV) set(key string, value []byte) {
+	kv.pairs[key] = value
+	kv.index++
+	close(kv.changedCh)
+	kv.changedCh = make(chan struct{})
+}
+
+func (kv *fakeKV) Put(ctx context.Context, pair *api.KVPair) error {
+	kv.Lock()
+	defer kv.Unlock()
+	kv.set(pair.Key, pair.Value)
+	if kv.replicate {
+		kv.set("backup/"+strings.TrimPrefix(pair.Key, "global/"), pair.Value)
+	}
+	return nil
+}
+
+func (kv *fakeKV) DeleteTree(ctx context.Context, prefix string) error {
+	kv.Lock()
+	defer kv.Unlock()
+	for key := range kv.pairs {
+		if strings.HasPrefix(key, prefix) {
+			delete(kv.pairs, key)
+		}
+	}
+	return nil
+}
+
+func (kv *fakeKV) List(ctx context.Context, prefix string, waitIndex uint64) ([]*api.KVPair, uint64, error) {
+	kv.Lock()
+	for kv.index == waitIndex {
+		changedCh := kv.changedCh
+		kv.Unlock()
+		select {
+		case <-changedCh:
+		case <-ctx.Done():
+			return nil, 0, ctx.Err()
+		}
+		kv.Lock()
+	}
+	defer kv.Unlock()
+
+	var pairs []*api.KVPair
+	for key, value := range kv.pairs {
+		if strings.HasPrefix(key, prefix) {
+			pairs = append(pairs, &api.KVPair{Key: key, Value: value})
+		}
+	}
+	return pairs, kv.index, nil
+}
+
+func TestRun(t *testing.T) {
+	cases := []struct {
+		name      string
+		replicate bool
+	}{
+		{
+			"replicated",
+			true,
+		},
+		{
+			"not_replicated",
+			false,
+		},
+	}
+
+	for i, tc := range cases {
+		t.Run(fmt.Sprintf("%d_%s", i, tc.name), func(t *testing.T) {
+			kv := newFakeKV(tc.replicate)
+			result, err := Run(context.Background(), kv, kv, "global/", "backup/", &Options{
+				Keys:      5,
+				ValueSize: 64,
+				Rate:      200,
+				Duration:  100 * time.Millisecond,
+				Drain:     100 * time.Millisecond,
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if result.Writes == 0 {
+				t.Fatal("expected churn to be written")
+			}
+			if result.Replicated+result.Superseded+result.Lost != result.Writes {
+				t.Errorf("expected every write to be accounted for, got %#v", result)
+			}
+			if tc.replicate && (result.Lost != 0 || result.Throughput <= 0) {
+				t.Errorf("expected every change to be replicated, got %#v", result)
+			}
+			if !tc.replicate && result.Replicated != 0 {
+				t.Errorf("expected no change to be replicated, got %#v", result)
+			}
+			if result.P50 > result.P90 || result.P90 > result.P99 || result.P99 > result.Max {
+				t.Errorf("expected ordered percentiles, got %#v", result)
+			}
+
+			// The scratch keys are removed from the source
+			if pairs, _, _ := kv.List(context.Background(), "global/", 0); len(pairs) != 0 {
+				t.Errorf("expected the churn to be removed, got %d keys", len(pairs))
+			}
+		})
+	}
+}
+
+func TestPercentile(t *testing.T) {
+	lags := []time.Duration{1, 2, 3, 4, 5, 6, 7, 8, 9, 10}
+	exp := []time.Duration{5, 9, 10}
+	act := []time.Duration{percentile(lags, 0.50), percentile(lags, 0.90), percentile(lags, 0.99)}
+	if !reflect.DeepEqual(exp, act) {
+		t.Errorf("\nexp: %#v\nact: %#v", exp, act)
+	}
+}
+
+func TestValue(t *testing.T) {
+	cases := []struct {
+		name string
+		seq  uint64
+		size int
+		exp  string
+	}{
+		{
+			"padded",
+			42,
+			8,
+			"42\nxxxxx",
+		},
+		{
+			"too_small",
+			1234,
+			2,
+			"1234\n",
+		},
+	}
+
+	for i, tc := range cases {
+		t.Run(fmt.Sprintf("%d_%s", i, tc.name), func(t *testing.T) {
+			act := string(value(tc.seq, tc.size))
+			if act != tc.exp {
+				t.Errorf("\nexp: %#v\nact: %#v", tc.exp, act)
+			}
+			if seq, ok := parseSeq([]byte(act)); !ok || seq != tc.seq {
+				t.Errorf("expected sequence %d, got %d", tc.seq, seq)
+			}
+		})
+	}
+}
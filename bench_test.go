@@ -0,0 +1,51 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: MPL-2.0
+
+package main
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/hashicorp/consul-replicate/bench"
+	"github.com/hashicorp/consul-replicate/replicate"
+)
+
+func TestRenderBench(t *testing.T) {
+	results := []*replicate.BenchResult{
+		{
+			Source:      "global",
+			Datacenter:  "dc1",
+			Destination: "global",
+			Result: &bench.Result{
+				Writes:     600,
+				Replicated: 590,
+				Superseded: 8,
+				Lost:       2,
+				Throughput: 9.8,
+				P50:        120 * time.Millisecond,
+				P90:        450 * time.Millisecond,
+				P99:        1200 * time.Millisecond,
+				Max:        1500 * time.Millisecond,
+			},
+		},
+		{
+			Source:      "edge",
+			Datacenter:  "dc2",
+			Destination: "edge",
+			Err:         errors.New(`the "kubernetes" backend cannot be benchmarked`),
+		},
+	}
+
+	var buf bytes.Buffer
+	renderBench(&buf, results)
+
+	exp := "PREFIX             WRITES  REPLICATED  SUPERSEDED  LOST  CHANGES/S  P50    P90    P99   MAX   ERROR\n" +
+		"global@dc1:global  600     590         8           2     9.8        120ms  450ms  1.2s  1.5s  -\n" +
+		"edge@dc2:edge      -       -           -           -     -          -      -      -     -     the \"kubernetes\" backend cannot be benchmarked\n"
+	if act := buf.String(); act != exp {
+		t.Errorf("\nexp: %#v\nact: %#v", exp, act)
+	}
+}
@@ -8,11 +8,13 @@ import (
 	"fmt"
 	"io"
 	"log"
+	"net"
 	"os"
 	"os/signal"
 	"sync"
 	"time"
 
+	"github.com/hashicorp/consul-replicate/replicate"
 	"github.com/hashicorp/consul-replicate/version"
 	"github.com/hashicorp/consul-template/config"
 	"github.com/hashicorp/consul-template/logging"
@@ -64,6 +66,38 @@ func NewCLI(out, err io.Writer) *CLI {
 // Run accepts a slice of arguments and returns an int representing the exit
 // status from the command.
 func (cli *CLI) Run(args []string) int {
+	// Dispatch subcommands
+	if len(args) > 1 {
+		switch args[1] {
+		case "bench":
+			return cli.runBench(args[2:])
+		case "cost":
+			return cli.runCost(args[2:])
+		case "export":
+			return cli.runExport(args[2:])
+		case "import":
+			return cli.runImport(args[2:])
+		case "migrate":
+			return cli.runMigrate(args[2:])
+		case "ready":
+			return cli.runReady(args[2:])
+		case "replay":
+			return cli.runReplay(args[2:])
+		case "rollback":
+			return cli.runRollback(args[2:])
+		case "selftest":
+			return cli.runSelfTest(args[2:])
+		case "stats":
+			return cli.runStats(args[2:])
+		case "status":
+			return cli.runStatus(args[2:])
+		case "top":
+			return cli.runTop(args[2:])
+		case "upgrade":
+			return cli.runUpgrade(args[2:])
+		}
+	}
+
 	// Parse the flags and args
 	cfg, paths, once, isVersion, err := cli.ParseFlags(args[1:])
 	if err != nil {
@@ -78,16 +112,8 @@ func (cli *CLI) Run(args []string) int {
 	// Save original config (defaults + parsed flags) for handling reloads
 	cliConfig := cfg.Copy()
 
-	// Load configuration paths, with CLI taking precendence
-	cfg, err = loadConfigs(paths, cliConfig)
-	if err != nil {
-		return logError(err, ExitCodeConfigError)
-	}
-
-	cfg.Finalize()
-
-	// Setup the config and logging
-	cfg, err = cli.setup(cfg)
+	// Load configuration paths, with CLI taking precendence, and setup logging
+	cfg, err = cli.reload(paths, cliConfig)
 	if err != nil {
 		return logError(err, ExitCodeConfigError)
 	}
@@ -104,19 +130,75 @@ func (cli *CLI) Run(args []string) int {
 		return ExitCodeOK
 	}
 
-	// Initial runner
-	runner, err := NewRunner(cfg, once)
+	// Initial supervisor, which runs a runner for every replication group
+	supervisor := replicate.NewSupervisor(cfg, once)
+
+	// A process started by an upgrade serves the listeners of the process it
+	// replaces
+	inherited, err := inheritListeners()
 	if err != nil {
-		return logError(err, ExitCodeRunnerError)
+		log.Printf("[WARN] (cli) listening again: %s", err)
+	}
+	listeners := make(map[string]net.Listener)
+
+	// Serve the control API. Its configuration is not reloaded.
+	if config.BoolVal(cfg.Control.Enabled) {
+		control, err := replicate.NewControlServerWithListener(cfg.Control, supervisor, inherited["control"])
+		if err != nil {
+			return logError(err, ExitCodeConfigError)
+		}
+		delete(inherited, "control")
+		listeners["control"] = control.Listener()
+		go control.Start()
+		defer control.Stop()
+	}
+
+	// Serve the debug endpoints. Their configuration is not reloaded.
+	if config.BoolVal(cfg.Debug.Enabled) {
+		debug, err := replicate.NewDebugServerWithListener(cfg.Debug, supervisor, inherited["debug"])
+		if err != nil {
+			return logError(err, ExitCodeConfigError)
+		}
+		delete(inherited, "debug")
+		listeners["debug"] = debug.Listener()
+		go debug.Start()
+		defer debug.Stop()
+	}
+
+	// Listeners of servers which are no longer enabled are not served
+	for _, l := range inherited {
+		l.Close()
+	}
+
+	if state := completeHandover(); state != nil {
+		supervisor.Inherit(state)
+	}
+	go supervisor.Start()
+
+	// Watch the configuration stored in Consul, reloading when it changes
+	configCh := make(chan struct{}, 1)
+	stopWatch := func() {}
+	if !once {
+		stopWatch = watchConsulConfig(cfg, configCh)
 	}
-	go runner.Start()
+	defer func() { stopWatch() }()
+
+	// Warn when the configuration no longer matches the running one. The
+	// interval is not reloaded.
+	var driftCh <-chan time.Time
+	if interval := config.TimeDurationVal(cfg.ConfigDriftInterval); interval > 0 && !once {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		driftCh = ticker.C
+	}
+	var drifted string
 
 	// Listen for signals
 	signal.Notify(cli.signalCh)
 
 	for {
 		select {
-		case err := <-runner.ErrCh:
+		case err := <-supervisor.ErrCh:
 			// Check if the runner's error returned a specific exit status, and return
 			// that value. If no value was given, return a generic exit status.
 			code := ExitCodeRunnerError
@@ -124,38 +206,59 @@ func (cli *CLI) Run(args []string) int {
 				code = typed.ExitStatus()
 			}
 			return logError(err, code)
-		case <-runner.DoneCh:
+		case <-supervisor.DoneCh:
 			return ExitCodeOK
+		case <-configCh:
+			// A broken configuration in Consul must not stop replication, so
+			// the current configuration is kept
+			newCfg, err := cli.reload(paths, cliConfig)
+			if err != nil {
+				log.Printf("[ERR] (cli) keeping the current configuration: %s", err)
+				continue
+			}
+			cfg = newCfg
+			supervisor.Reload(cfg)
+			stopWatch()
+			stopWatch = watchConsulConfig(cfg, configCh)
+		case <-driftCh:
+			drifted = checkDrift(paths, cliConfig, cfg, drifted)
 		case s := <-cli.signalCh:
 			log.Printf("[DEBUG] (cli) receiving signal %q", s)
 
 			switch s {
 			case *cfg.ReloadSignal:
 				fmt.Fprintf(cli.errStream, "Reloading configuration...\n")
-				runner.Stop()
-
-				// Re-parse any configuration files or paths
-				cfg, err = loadConfigs(paths, cliConfig)
-				if err != nil {
-					return logError(err, ExitCodeConfigError)
-				}
-				cfg.Finalize()
 
-				// Load the new configuration from disk
-				cfg, err = cli.setup(cfg)
+				cfg, err = cli.reload(paths, cliConfig)
 				if err != nil {
 					return logError(err, ExitCodeConfigError)
 				}
 
-				runner, err = NewRunner(cfg, once)
-				if err != nil {
-					return logError(err, ExitCodeRunnerError)
-				}
-				go runner.Start()
+				// Only replication groups whose configuration changed are
+				// restarted
+				supervisor.Reload(cfg)
+				stopWatch()
+				stopWatch = watchConsulConfig(cfg, configCh)
 			case *cfg.KillSignal:
 				fmt.Fprintf(cli.errStream, "Cleaning up...\n")
-				runner.Stop()
+				supervisor.Stop()
 				return ExitCodeInterrupt
+			case *cfg.DumpSignal:
+				supervisor.DumpState()
+			case *cfg.RotateSignal:
+				if err := supervisor.RotateTokens(); err != nil {
+					log.Printf("[ERR] (cli) failed to rotate the tokens: %s", err)
+				}
+				if err := supervisor.RotateCerts(); err != nil {
+					log.Printf("[ERR] (cli) failed to rotate the certificates: %s", err)
+				}
+			case *cfg.UpgradeSignal:
+				fmt.Fprintf(cli.errStream, "Upgrading...\n")
+				if err := cli.upgrade(supervisor, listeners); err != nil {
+					log.Printf("[ERR] (cli) upgrade failed, keeping this process: %s", err)
+					continue
+				}
+				return ExitCodeOK
 			case signals.SignalLookup["SIGCHLD"]:
 				// The SIGCHLD signal is sent to the parent of a child process when it
 				// exits, is interrupted, or resumes after being interrupted. We ignore
@@ -176,23 +279,87 @@ func (cli *CLI) Run(args []string) int {
 // Flag library. This is extracted into a helper to keep the main function
 // small, but it also makes writing tests for parsing command line arguments
 // much easier and cleaner.
-func (cli *CLI) ParseFlags(args []string) (*Config, []string, bool, bool, error) {
+func (cli *CLI) ParseFlags(args []string) (*replicate.Config, []configSource, bool, bool, error) {
+	return cli.parseFlags(args, nil)
+}
+
+// parseFlags parses the common command line flags, and the flags defined by
+// the optional extra function for subcommands.
+func (cli *CLI) parseFlags(args []string, extra func(*flag.FlagSet)) (*replicate.Config, []configSource, bool, bool, error) {
 	var once, isVersion bool
-	var c = DefaultConfig()
+	var c = replicate.DefaultConfig()
 
-	// configPaths stores the list of configuration paths on disk
-	configPaths := make([]string, 0, 6)
+	// configPaths stores the list of configuration paths on disk, in the order
+	// they were given
+	configPaths := make([]configSource, 0, 6)
 
 	// Parse the flags and options
 	flags := flag.NewFlagSet(version.Name, flag.ContinueOnError)
 	flags.SetOutput(io.Discard)
 	flags.Usage = func() {}
 
+	flags.Var((funcBoolVar)(func(b bool) error {
+		c.AllowOverlap = config.Bool(b)
+		return nil
+	}), "allow-overlap", "")
+
+	flags.Var((funcIntVar)(func(i int) error {
+		c.Bandwidth.DestinationLimit = config.Int(i)
+		return nil
+	}), "bandwidth-destination-limit", "")
+
+	flags.Var((funcIntVar)(func(i int) error {
+		c.Bandwidth.SourceLimit = config.Int(i)
+		return nil
+	}), "bandwidth-source-limit", "")
+
+	flags.Var((funcDurationVar)(func(d time.Duration) error {
+		c.BlockQuery.Jitter = config.TimeDuration(d)
+		return nil
+	}), "block-query-jitter", "")
+
+	flags.Var((funcDurationVar)(func(d time.Duration) error {
+		c.BlockQuery.Wait = config.TimeDuration(d)
+		return nil
+	}), "block-query-wait", "")
+
+	// Chaos injects failures for testing in staging, so it is left out of the
+	// usage on purpose
+	flags.Var((funcBoolVar)(func(b bool) error {
+		c.Chaos.Enabled = config.Bool(b)
+		return nil
+	}), "chaos", "")
+
+	flags.Var((funcBoolVar)(func(b bool) error {
+		c.ClaimDestinations = config.Bool(b)
+		return nil
+	}), "claim-destinations", "")
+
+	flags.Var((funcBoolVar)(func(b bool) error {
+		c.CoalesceWatches = config.Bool(b)
+		return nil
+	}), "coalesce-watches", "")
+
 	flags.Var((funcVar)(func(s string) error {
-		configPaths = append(configPaths, s)
+		configPaths = append(configPaths, configSource{configSourcePath, s})
 		return nil
 	}), "config", "")
 
+	flags.Var((funcVar)(func(s string) error {
+		c.ConfigConsulPath = config.String(s)
+		return nil
+	}), "config-consul-path", "")
+
+	flags.Var((funcVar)(func(s string) error {
+		configPaths = append(configPaths, configSource{configSourceDir, s})
+		return nil
+	}), "config-dir", "")
+
+	flags.Var((funcVar)(func(s string) error {
+		configPaths = append(configPaths, configSource{configSourceFile, s})
+		return nil
+	}), "config-file", "")
+
 	// TODO: Add all consul flags for destination-consul
 	flags.Var((funcVar)(func(s string) error {
 		c.DestinationConsul.Address = config.String(s)
@@ -200,10 +367,25 @@ func (cli *CLI) ParseFlags(args []string) (*Config, []string, bool, bool, error)
 	}), "destination-consul-addr", "")
 
 	flags.Var((funcVar)(func(s string) error {
-		c.Consul.Token = config.String(s)
+		c.DestinationConsul.Token = config.String(s)
 		return nil
 	}), "destination-consul-token", "")
 
+	flags.Var((funcIntVar)(func(i int) error {
+		c.DestinationConsul.Transport.MaxIdleConns = config.Int(i)
+		return nil
+	}), "destination-consul-transport-max-idle-conns", "")
+
+	flags.Var((funcIntVar)(func(i int) error {
+		c.DestinationConsul.Transport.MaxIdleConnsPerHost = config.Int(i)
+		return nil
+	}), "destination-consul-transport-max-idle-conns-per-host", "")
+
+	flags.Var((funcDurationVar)(func(d time.Duration) error {
+		c.DestinationConsul.Transport.TLSHandshakeTimeout = config.TimeDuration(d)
+		return nil
+	}), "destination-consul-transport-tls-handshake-timeout", "")
+
 	flags.Var((funcVar)(func(s string) error {
 		c.Consul.Address = config.String(s)
 		return nil
@@ -293,6 +475,11 @@ func (cli *CLI) ParseFlags(args []string) (*Config, []string, bool, bool, error)
 		return nil
 	}), "consul-transport-disable-keep-alives", "")
 
+	flags.Var((funcIntVar)(func(i int) error {
+		c.Consul.Transport.MaxIdleConns = config.Int(i)
+		return nil
+	}), "consul-transport-max-idle-conns", "")
+
 	flags.Var((funcIntVar)(func(i int) error {
 		c.Consul.Transport.MaxIdleConnsPerHost = config.Int(i)
 		return nil
@@ -304,7 +491,66 @@ func (cli *CLI) ParseFlags(args []string) (*Config, []string, bool, bool, error)
 	}), "consul-transport-tls-handshake-timeout", "")
 
 	flags.Var((funcVar)(func(s string) error {
-		e, err := ParseExcludeConfig(s)
+		c.Control.Address = config.String(s)
+		return nil
+	}), "control-addr", "")
+
+	flags.Var((funcBoolVar)(func(b bool) error {
+		c.Control.Insecure = config.Bool(b)
+		return nil
+	}), "control-insecure", "")
+
+	flags.Var((funcBoolVar)(func(b bool) error {
+		c.CreateFolders = config.Bool(b)
+		return nil
+	}), "create-folders", "")
+
+	flags.Var((funcVar)(func(s string) error {
+		c.Debug.Address = config.String(s)
+		return nil
+	}), "debug-addr", "")
+
+	flags.Var((funcVar)(func(s string) error {
+		c.Destination.Datacenter = config.String(s)
+		return nil
+	}), "destination-datacenter", "")
+
+	flags.Var((funcDurationVar)(func(d time.Duration) error {
+		c.Destination.MaxStale = config.TimeDuration(d)
+		return nil
+	}), "destination-max-stale", "")
+
+	flags.Var((funcVar)(func(s string) error {
+		c.DestinationRoot = config.String(s)
+		return nil
+	}), "destination-root", "")
+
+	flags.Var((funcVar)(func(s string) error {
+		c.Destination.Service = config.String(s)
+		return nil
+	}), "destination-service", "")
+
+	flags.Var((funcVar)(func(s string) error {
+		c.Destination.Writes = config.String(s)
+		return nil
+	}), "destination-writes", "")
+
+	flags.Var((funcDurationVar)(func(d time.Duration) error {
+		c.DiscoveryInterval = config.TimeDuration(d)
+		return nil
+	}), "discovery-interval", "")
+
+	flags.Var((funcVar)(func(s string) error {
+		sig, err := signals.Parse(s)
+		if err != nil {
+			return err
+		}
+		c.DumpSignal = config.Signal(sig)
+		return nil
+	}), "dump-signal", "")
+
+	flags.Var((funcVar)(func(s string) error {
+		e, err := replicate.ParseExcludeConfig(s)
 		if err != nil {
 			return err
 		}
@@ -312,6 +558,41 @@ func (cli *CLI) ParseFlags(args []string) (*Config, []string, bool, bool, error)
 		return nil
 	}), "exclude", "")
 
+	flags.Var((funcBoolVar)(func(b bool) error {
+		c.HA.Enabled = config.Bool(b)
+		return nil
+	}), "ha", "")
+
+	flags.Var((funcBoolVar)(func(b bool) error {
+		c.HTTP.Destination.HTTP2 = config.Bool(b)
+		return nil
+	}), "http-destination-http2", "")
+
+	flags.Var((funcIntVar)(func(i int) error {
+		c.HTTP.Destination.MaxConnsPerHost = config.Int(i)
+		return nil
+	}), "http-destination-max-conns-per-host", "")
+
+	flags.Var((funcVar)(func(s string) error {
+		c.HTTP.Destination.Proxy = config.String(s)
+		return nil
+	}), "http-destination-proxy", "")
+
+	flags.Var((funcBoolVar)(func(b bool) error {
+		c.HTTP.Source.HTTP2 = config.Bool(b)
+		return nil
+	}), "http-source-http2", "")
+
+	flags.Var((funcIntVar)(func(i int) error {
+		c.HTTP.Source.MaxConnsPerHost = config.Int(i)
+		return nil
+	}), "http-source-max-conns-per-host", "")
+
+	flags.Var((funcVar)(func(s string) error {
+		c.HTTP.Source.Proxy = config.String(s)
+		return nil
+	}), "http-source-proxy", "")
+
 	flags.Var((funcVar)(func(s string) error {
 		sig, err := signals.Parse(s)
 		if err != nil {
@@ -326,6 +607,16 @@ func (cli *CLI) ParseFlags(args []string) (*Config, []string, bool, bool, error)
 		return nil
 	}), "log-level", "")
 
+	flags.Var((funcBoolVar)(func(b bool) error {
+		c.LoopDetection = config.Bool(b)
+		return nil
+	}), "loop-detection", "")
+
+	flags.Var((funcDurationVar)(func(d time.Duration) error {
+		c.MaxBatchDelay = config.TimeDuration(d)
+		return nil
+	}), "max-batch-delay", "")
+
 	flags.Var((funcDurationVar)(func(d time.Duration) error {
 		c.MaxStale = config.TimeDuration(d)
 		return nil
@@ -338,8 +629,18 @@ func (cli *CLI) ParseFlags(args []string) (*Config, []string, bool, bool, error)
 		return nil
 	}), "pid-file", "")
 
+	flags.Var((funcIntVar)(func(i int) error {
+		c.Pipeline.Window = config.Int(i)
+		return nil
+	}), "pipeline-window", "")
+
+	flags.Var((funcBoolVar)(func(b bool) error {
+		c.Preflight = config.Bool(b)
+		return nil
+	}), "preflight", "")
+
 	flags.Var((funcVar)(func(s string) error {
-		p, err := ParsePrefixConfig(s)
+		p, err := replicate.ParsePrefixConfig(s)
 		if err != nil {
 			return err
 		}
@@ -347,6 +648,16 @@ func (cli *CLI) ParseFlags(args []string) (*Config, []string, bool, bool, error)
 		return nil
 	}), "prefix", "")
 
+	flags.Var((funcVar)(func(s string) error {
+		c.PrefixesConsulPath = config.String(s)
+		return nil
+	}), "prefixes-consul-path", "")
+
+	flags.Var((funcVar)(func(s string) error {
+		c.RecordFile = config.String(s)
+		return nil
+	}), "record-file", "")
+
 	flags.Var((funcVar)(func(s string) error {
 		sig, err := signals.Parse(s)
 		if err != nil {
@@ -356,11 +667,45 @@ func (cli *CLI) ParseFlags(args []string) (*Config, []string, bool, bool, error)
 		return nil
 	}), "reload-signal", "")
 
+	flags.Var((funcDurationVar)(func(d time.Duration) error {
+		c.ReplicationTimeout = config.TimeDuration(d)
+		return nil
+	}), "replication-timeout", "")
+
+	flags.Var((funcVar)(func(s string) error {
+		sig, err := signals.Parse(s)
+		if err != nil {
+			return err
+		}
+		c.RotateSignal = config.Signal(sig)
+		return nil
+	}), "rotate-signal", "")
+
+	flags.Var((funcVar)(func(s string) error {
+		c.Snapshot = config.String(s)
+		return nil
+	}), "snapshot", "")
+
+	flags.Var((funcIntVar)(func(i int) error {
+		c.StatsHistory = config.Int(i)
+		return nil
+	}), "stats-history", "")
+
+	flags.Var((funcVar)(func(s string) error {
+		c.StatusBackend = config.String(s)
+		return nil
+	}), "status-backend", "")
+
 	flags.Var((funcVar)(func(s string) error {
 		c.StatusDir = config.String(s)
 		return nil
 	}), "status-dir", "")
 
+	flags.Var((funcVar)(func(s string) error {
+		c.StatusPath = config.String(s)
+		return nil
+	}), "status-path", "")
+
 	flags.Var((funcBoolVar)(func(b bool) error {
 		c.Syslog.Enabled = config.Bool(b)
 		return nil
@@ -371,6 +716,25 @@ func (cli *CLI) ParseFlags(args []string) (*Config, []string, bool, bool, error)
 		return nil
 	}), "syslog-facility", "")
 
+	flags.Var((funcVar)(func(s string) error {
+		sig, err := signals.Parse(s)
+		if err != nil {
+			return err
+		}
+		c.UpgradeSignal = config.Signal(sig)
+		return nil
+	}), "upgrade-signal", "")
+
+	flags.Var((funcBoolVar)(func(b bool) error {
+		c.VerifyBeforeWrite = config.Bool(b)
+		return nil
+	}), "verify-before-write", "")
+
+	flags.Var((funcBoolVar)(func(b bool) error {
+		c.VersionCheck.Enabled = config.Bool(b)
+		return nil
+	}), "version-check", "")
+
 	flags.Var((funcVar)(func(s string) error {
 		w, err := config.ParseWaitConfig(s)
 		if err != nil {
@@ -380,8 +744,10 @@ func (cli *CLI) ParseFlags(args []string) (*Config, []string, bool, bool, error)
 		return nil
 	}), "wait", "")
 
-	flags.BoolVar(&isVersion, "v", false, "")
-	flags.BoolVar(&isVersion, "version", false, "")
+	flags.Var((funcDurationVar)(func(d time.Duration) error {
+		c.WaitForClusters.Timeout = config.TimeDuration(d)
+		return nil
+	}), "wait-for-clusters", "")
 
 	// Deprecations
 	// TODO remove in 0.5.0
@@ -443,6 +809,16 @@ func (cli *CLI) ParseFlags(args []string) (*Config, []string, bool, bool, error)
 	// End deprecations
 	// TODO remove in 0.5.0
 
+	if extra != nil {
+		extra(flags)
+	}
+
+	// Subcommands may define their own -version flag
+	flags.BoolVar(&isVersion, "v", false, "")
+	if flags.Lookup("version") == nil {
+		flags.BoolVar(&isVersion, "version", false, "")
+	}
+
 	// If there was a parser error, stop
 	if err := flags.Parse(args); err != nil {
 		return nil, nil, false, false, err
@@ -458,26 +834,159 @@ func (cli *CLI) ParseFlags(args []string) (*Config, []string, bool, bool, error)
 	return c, configPaths, once, isVersion, nil
 }
 
-// handleError outputs the given error's Error() to the errStream and returns
-// loadConfigs loads the configuration from the list of paths. The optional
-// configuration is the list of overrides to apply at the very end, taking
-// precendence over any configurations that were loaded from the paths. If any
-// errors occur when reading or parsing those sub-configs, it is returned.
-func loadConfigs(paths []string, o *Config) (*Config, error) {
-	finalC := DefaultConfig()
+// configSource is a configuration file or directory given on the command line.
+type configSource struct {
+	kind, path string
+}
+
+const (
+	// configSourcePath is a file, or a directory whose files are all merged.
+	configSourcePath = "path"
+
+	// configSourceFile is a file.
+	configSourceFile = "file"
 
+	// configSourceDir is a directory whose .hcl and .json files are merged.
+	configSourceDir = "dir"
+)
+
+// handleError outputs the given error's Error() to the errStream and returns
+// loadConfigs loads the configuration from the list of paths, in order. The
+// optional configuration is the list of overrides to apply at the very end,
+// taking precendence over any configurations that were loaded from the paths.
+// If any errors occur when reading or parsing those sub-configs, it is
+// returned. The files which were merged are returned in the order they were
+// merged.
+func loadConfigs(paths []configSource, o *replicate.Config) (*replicate.Config, []string, error) {
+	finalC := replicate.DefaultConfig()
+
+	// A file is merged at most once, even when given or imported again
+	loader := replicate.NewConfigLoader()
 	for _, path := range paths {
-		c, err := FromPath(path)
+		var c *replicate.Config
+		var err error
+		switch path.kind {
+		case configSourceFile:
+			c, err = loader.File(path.path)
+		case configSourceDir:
+			c, err = loader.Dir(path.path)
+		default:
+			c, err = loader.Path(path.path)
+		}
 		if err != nil {
-			return nil, err
+			return nil, nil, err
 		}
 
 		finalC = finalC.Merge(c)
 	}
 
 	finalC = finalC.Merge(o)
+
+	// The configuration stored in Consul takes precedence over files, and the
+	// CLI over both
+	if path := config.StringVal(finalC.ConfigConsulPath); path != "" {
+		consul := finalC.DestinationConsul.Copy()
+		consul.Finalize()
+
+		c, err := replicate.FromConsul(consul, path)
+		if err != nil {
+			return nil, nil, err
+		}
+		finalC = finalC.Merge(c).Merge(o)
+	}
+
+	// The prefixes stored in Consul are replicated along with the configured
+	// ones
+	if path := config.StringVal(finalC.PrefixesConsulPath); path != "" {
+		consul := finalC.DestinationConsul.Copy()
+		consul.Finalize()
+
+		prefixes, err := replicate.PrefixesFromConsul(consul, path)
+		if err != nil {
+			return nil, nil, err
+		}
+		finalC = finalC.Merge(&replicate.Config{Prefixes: prefixes})
+	}
+
 	finalC.Finalize()
-	return finalC, nil
+	return finalC, loader.Files, nil
+}
+
+// reload loads the configuration again and sets up logging.
+func (cli *CLI) reload(paths []configSource, o *replicate.Config) (*replicate.Config, error) {
+	// Re-parse any configuration files or paths
+	cfg, files, err := loadConfigs(paths, o)
+	if err != nil {
+		return nil, err
+	}
+	cfg.Finalize()
+
+	if err := checkUpgradeSignal(cfg); err != nil {
+		return nil, err
+	}
+
+	// Load the new configuration from disk
+	cfg, err = cli.setup(cfg)
+	if err != nil {
+		return nil, err
+	}
+
+	// Files are only listed once logging is set up with the configured level
+	for i, file := range files {
+		log.Printf("[DEBUG] (cli) merged configuration file %d: %s", i+1, file)
+	}
+
+	checksum, err := cfg.Checksum()
+	if err != nil {
+		return nil, err
+	}
+	log.Printf("[INFO] (cli) configuration checksum %s", checksum)
+	return cfg, nil
+}
+
+// checkDrift loads the configuration again, and warns if it no longer matches
+// the running one because it changed without a reload. It returns the checksum
+// of the drifted configuration, which is given back as warned so every change
+// is only reported once.
+func checkDrift(paths []configSource, o, running *replicate.Config, warned string) string {
+	c, _, err := loadConfigs(paths, o)
+	if err != nil {
+		log.Printf("[WARN] (cli) failed to check the configuration for changes: %s", err)
+		return warned
+	}
+
+	current, err := c.Checksum()
+	if err != nil {
+		log.Printf("[WARN] (cli) failed to check the configuration for changes: %s", err)
+		return warned
+	}
+	expected, err := running.Checksum()
+	if err != nil {
+		log.Printf("[WARN] (cli) failed to check the configuration for changes: %s", err)
+		return warned
+	}
+
+	if current == expected {
+		return ""
+	}
+	if current != warned {
+		log.Printf("[WARN] (cli) configuration changed without a reload "+
+			"(running %s, on disk %s)", expected, current)
+	}
+	return current
+}
+
+// watchConsulConfig watches the configuration and the prefixes stored in
+// Consul, if any, and returns a function which stops watching.
+func watchConsulConfig(c *replicate.Config, changeCh chan<- struct{}) func() {
+	stopCh := make(chan struct{})
+	if path := config.StringVal(c.ConfigConsulPath); path != "" {
+		go replicate.WatchConsul(c.DestinationConsul, path, changeCh, stopCh)
+	}
+	if path := config.StringVal(c.PrefixesConsulPath); path != "" {
+		go replicate.WatchConsulPrefixes(c.DestinationConsul, path, changeCh, stopCh)
+	}
+	return func() { close(stopCh) }
 }
 
 // logError logs an error message and then returns the given status.
@@ -486,7 +995,7 @@ func logError(err error, status int) int {
 	return status
 }
 
-func (cli *CLI) setup(conf *Config) (*Config, error) {
+func (cli *CLI) setup(conf *replicate.Config) (*replicate.Config, error) {
 	if err := logging.Setup(&logging.Config{
 		SyslogName:     version.Name,
 		Level:          config.StringVal(conf.LogLevel),
@@ -500,21 +1009,240 @@ func (cli *CLI) setup(conf *Config) (*Config, error) {
 	return conf, nil
 }
 
-const usage = `Usage: %s [options]
+const usage = `Usage: %[1]s [options]
+       %[1]s bench [options] [-keys=<int>] [-value-size=<int>] [-rate=<float>]
+           [-duration=<duration>] [-drain=<duration>]
+       %[1]s cost [options] [-json]
+       %[1]s export [options] -out=<path>
+       %[1]s import [options] -in=<path>
+       %[1]s migrate [options] [-catch-up=<duration>]
+       %[1]s ready [options] [-wait=<duration>]
+       %[1]s replay [options] -in=<path>
+       %[1]s rollback [options] -prefix=<prefix> [-passes=<int>]
+       %[1]s selftest [options] [-timeout=<duration>]
+       %[1]s stats [options] [-json]
+       %[1]s status prune [options] [-max-age=<duration>] [-dry-run]
+       %[1]s top [options] [-interval=<duration>]
+       %[1]s upgrade [options] -version=<version> -key=<path>
+           [-releases-url=<url>] [-force]
 
   Replicates key-value data from a source datacenter to the datacenter(s) of a
   Consul agent.
 
+  The bench command measures the performance of a running replicator against
+  dev clusters. It writes synthetic churn into a scratch folder of the source
+  of every configured prefix, changing -rate random keys out of -keys per
+  second with values of -value-size bytes for -duration, and watches the
+  destination for every change. It prints the changes replicated per second
+  and the percentiles of the lag between the write of a change and its
+  arrival at the destination. Changes overwritten before they arrived may be
+  skipped by the replicator. Changes which did not arrive within -drain after
+  the churn stopped are lost, and fail the command. The scratch folders are
+  removed from the source at the end, even when interrupted.
+
+  The cost command prints how many blocking queries, index changes, bytes read
+  and written and write requests every prefix of a running instance cost the
+  Consul servers per hour since it started, so the load of the servers can be
+  attributed to replication flows. It reads them through the control API at
+  the configured control address, which must be enabled.
+
+  The export and import commands replicate between clusters without a network
+  path. Export writes the source keys of the configured prefixes, less any
+  excluded keys, to a bundle. Import replays a bundle through the configured
+  prefixes, excludes and middleware into the destination, like a single pass
+  of replication. A path of "-" is standard output or input.
+
+  With -state, export records what it exported and the next export is a delta
+  bundle with only the keys changed or deleted since. Import refuses a delta
+  unless the previous bundle was the last one imported.
+
+  The migrate command runs a one-time migration of the configured prefixes,
+  then exits. It replicates a full pass, catches up with passes until one
+  changes nothing, and verifies that the destination of every prefix holds
+  exactly what was replicated and that the source has not changed since. If
+  every prefix is verified, it cuts over by writing a sentinel next to the
+  status of every prefix, which marks the destination authoritative. Stop the
+  writers of the source first, or the source never stops changing. Prefixes
+  which were cut over are never migrated again.
+
+  The ready command checks that the initial sync of every prefix of a running
+  instance is complete, for deployment automation which starts applications
+  in the destination once replication is primed. It prints the pending
+  prefixes and exits with an error until every prefix of every replicator
+  which is not paused replicated every key once. With -wait, it asks again
+  until the instance is ready or the wait times out. It reads the readiness
+  through the control API, like stats.
+
+  The replay command reproduces replication bugs from recorded traffic. It
+  reads a file written with -record-file and runs a pass of the prefix of
+  every recorded change, in order, against the configured destination, which
+  should be a test cluster.
+
+  The rollback command restores the destination of every given prefix to its
+  state before its last passes, after a bad upstream change was replicated.
+  It replays the undo log the replicator keeps when undo is enabled, and
+  fails before changing anything if a prefix has fewer passes in its log.
+  Pause the replicator or fix the source first, or the next pass replicates
+  the source again, such as by deleting keys restored by the rollback.
+
+  The selftest command checks a deployed replicator end to end. It writes a
+  scratch key into the source of every configured prefix, waits for it to
+  appear at the destination, deletes it and waits for the delete to be
+  replicated too. Scratch keys are cleaned up even if the test fails, and the
+  command exits with an error if any prefix failed.
+
+  The stats command prints the summaries of the last passes of every prefix
+  of a running instance, which keeps stats_history passes per prefix in
+  memory, for quick triage without a metrics stack. It reads them through the
+  control API, like cost.
+
+  The status prune command removes stale entries from the status dir: the
+  status, manifest, undo log and initial sync barrier of prefixes which are no
+  longer configured and were not updated within the maximum age, and the shard
+  membership keys of instances whose session is gone. It prints every removed
+  key.
+
+  The top command redraws a dashboard of the prefixes of a running instance
+  every interval, with their health, last replicated index, lag, keys
+  replicated in the last minute, throughput and last error, until it is
+  interrupted. Like stats, it reads them through the control API.
+
+  The upgrade command installs another release in place of the running binary,
+  for hosts without configuration management. It downloads the checksums of
+  the release and their signature, verifies the signature with the public key
+  at -key, downloads the archive for the operating system and architecture of
+  the running binary and verifies its checksum. The new binary must run on the
+  host and report the version before it replaces the old one, which is kept
+  next to it with the .old suffix. If pid_file and upgrade_signal are set, the
+  running replicator is signaled to hand over to the new binary; otherwise it
+  must be restarted.
+
+Bench, cost, export, import, migrate, ready, replay, rollback, selftest, stats,
+status, top and upgrade options:
+
+  -keys=<int>
+      Sets how many distinct keys bench changes (default 100)
+
+  -value-size=<int>
+      Sets the size in bytes of the values bench writes (default 256)
+
+  -rate=<float>
+      Sets how many changes bench writes per second (default 10)
+
+  -duration=<duration>
+      Sets how long bench writes changes (default 1m)
+
+  -drain=<duration>
+      Sets how long bench waits for the last changes to be replicated once
+      it stops writing them (default 30s)
+
+  -out=<path>
+      Sets the path of the bundle written by export
+
+  -in=<path>
+      Sets the path of the bundle read by import, or of the record file read
+      by replay
+
+  -state=<path>
+      Sets the path where export records the exported keys. If the file
+      exists, only the changes since the recorded export are exported.
+
+  -catch-up=<duration>
+      Sets how long migrate catches up with a source which still changes
+      before giving up without cutting over (default 5m)
+
+  -dry-run
+      Prints the keys status prune would remove, without removing them
+
+  -interval=<duration>
+      Sets how often top redraws the dashboard (default 2s)
+
+  -json
+      Prints the cost or stats as JSON instead of tables
+
+  -passes=<int>
+      Sets how many of the last passes of each prefix rollback restores the
+      destination from (default 1)
+
+  -max-age=<duration>
+      Sets how long status prune keeps the status of a prefix which is no
+      longer configured after its last update, which defaults to the max_age
+      of the status_gc configuration
+
+  -wait=<duration>
+      Sets how long ready waits for the instance to be ready before failing
+      (default 0, which checks once)
+
+  -timeout=<duration>
+      Sets how long selftest waits for each write and delete to be
+      replicated (default 30s)
+
+  -version=<version>
+      Sets the release upgrade installs, such as "0.5.0"
+
+  -key=<path>
+      Sets the path of the armored PGP public key upgrade verifies the
+      signature of the release with
+
+  -releases-url=<url>
+      Sets the server upgrade downloads releases from (default
+      "https://releases.hashicorp.com")
+
+  -force
+      Installs the release with upgrade even if it is the running version
+
 Options:
 
+  -allow-overlap
+      Allows replicating a prefix into a destination which overlaps its source
+      on the same cluster.
+
+  -bandwidth-destination-limit=<int>
+      Caps the bytes per second written into the destination. 0 means no
+      limit.
+
+  -bandwidth-source-limit=<int>
+      Caps the bytes per second read from the source. 0 means no limit.
+
+  -block-query-jitter=<duration>
+      Sets the maximum random amount of time added to the wait of every
+      blocking query, which defaults to "0s".
+
+  -block-query-wait=<duration>
+      Sets how long blocking queries wait for a change, which defaults to "5m".
+
+  -claim-destinations
+      Records the prefix which owns each destination in the status dir, and
+      fails the prefixes whose destination is owned by another one.
+
+  -coalesce-watches
+      Watches the parent folder of prefixes which share one, instead of each
+      prefix on its own.
+
   -config=<path>
       Sets the path to a configuration file or folder on disk. This can be
       specified multiple times to load multiple files or folders. If multiple
       values are given, they are merged left-to-right, and CLI arguments take
       the top-most precedence.
 
+  -config-consul-path=<key>
+      Loads configuration from the given key in the destination Consul,
+      which takes precedence over configuration files, and reloads it every
+      time the key changes.
+
+  -config-dir=<path>
+      Merges the .hcl and .json files of the folder on disk, and of its
+      subfolders, in lexical order. Symlinks are followed. This can be
+      specified multiple times, and is merged in order with -config and
+      -config-file.
+
+  -config-file=<path>
+      Sets the path to a configuration file on disk. This can be specified
+      multiple times, and is merged in order with -config and -config-dir.
+
   -consul-addr=<address>
-      Sets the address of the Consul instance
+      Sets the address of the Consul instance, which may be the path of the
+      unix socket of the agent, such as "unix:///var/run/consul.sock"
 
   -consul-auth=<username[:password]>
       Set the basic authentication username and password for communicating
@@ -567,21 +1295,121 @@ Options:
   -consul-transport-disable-keep-alives
       Disables keep-alives (this will impact performance)
 
+  -consul-transport-max-idle-conns=<int>
+      Sets the maximum number of idle connections to permit in total
+
   -consul-transport-max-idle-conns-per-host=<int>
-      Sets the maximum number of idle connections to permit per host
+      Sets the maximum number of idle connections to permit per host, which
+      defaults to 100
 
   -consul-transport-tls-handshake-timeout=<duration>
       Sets the handshake timeout
 
+  -control-addr=<address>
+      Serves the gRPC control API on the given address, through which a
+      central controller can manage the replicators. Clients must present a
+      certificate, unless the API is insecure.
+
+  -control-insecure
+      Serves the control API without authenticating its clients, which is
+      only permitted on a loopback address.
+
+  -create-folders
+      Maintains an empty folder key for every intermediate folder of the
+      replicated keys, and deletes it once the folder is empty.
+
+  -debug-addr=<address>
+      Serves the pprof profiles, goroutine dumps, GC statistics and internal
+      state on the given address. Only listen on a trusted interface.
+
+  -destination-consul-transport-max-idle-conns=<int>
+      Sets the maximum number of idle connections to the destination Consul
+      to permit in total
+
+  -destination-consul-transport-max-idle-conns-per-host=<int>
+      Sets the maximum number of idle connections to the destination Consul
+      to permit per host, which defaults to 100
+
+  -destination-consul-transport-tls-handshake-timeout=<duration>
+      Sets the handshake timeout of the destination Consul
+
+  -destination-datacenter=<name>
+      Writes the keys and statuses into the datacenter through the dc query
+      parameter, instead of the datacenter of the destination agent
+
+  -destination-max-stale=<duration>
+      Lets any destination server answer the reads which compare the
+      destination with the source, as long as it is within the duration of
+      the leader. Writes still go to the leader.
+
+  -destination-root=<path>
+      Prepends the path to the destination of every prefix, for example
+      "mirror/" to replicate "global@dc1" into "mirror/global".
+
+  -destination-service=<name[@datacenter]>
+      Resolves the destination Consul to the healthy instances of the service
+      in the catalog of the source, failing over to the next instance when
+      one cannot be reached, instead of -destination-consul-addr
+
+  -destination-writes=<agent|servers>
+      Sets how writes of keys reach the destination: "agent" (the default)
+      sends them to the destination agent, which forwards them, and "servers"
+      sends them directly to the servers of the destination datacenter,
+      leader first
+
+  -discovery-interval=<duration>
+      Sets how often the source is listed to find the folders matching
+      wildcard prefixes, which defaults to "1m".
+
+  -dump-signal=<signal>
+      Signal to listen to dump the internal state of the runners to the log,
+      which defaults to SIGUSR1
+
   -exclude=<src>
       Provides a prefix to exclude from replication.
 
+  -ha
+      Only replicate while holding a lock in the destination Consul, so
+      several instances can run for high availability. Standby instances keep
+      watching the source so they can take over immediately.
+
+  -http-destination-http2
+      Negotiates HTTP/2 with the destination Consul over TLS, so every
+      prefix writes through a single connection
+
+  -http-destination-max-conns-per-host=<int>
+      Sets the maximum number of connections to the destination Consul per
+      host, above which requests wait. 0 means no limit.
+
+  -http-destination-proxy=<url>
+      Reaches the destination Consul through an HTTP or SOCKS5 proxy, such as
+      "socks5://bastion:1080"
+
+  -http-source-http2
+      Negotiates HTTP/2 with the source Consul over TLS
+
+  -http-source-max-conns-per-host=<int>
+      Sets the maximum number of connections to the source Consul per host,
+      above which requests wait. 0 means no limit.
+
+  -http-source-proxy=<url>
+      Reaches the source Consul through an HTTP or SOCKS5 proxy
+
   -kill-signal=<signal>
       Signal to listen to gracefully terminate the process
 
   -log-level=<level>
       Set the logging level - values are "debug", "info", "warn", and "err"
 
+  -loop-detection
+      Records the datacenter replicated keys were first written in in their
+      metadata keys, and skips keys which originated in the destination
+      datacenter
+
+  -max-batch-delay=<duration>
+      Caps how long the wait timers may delay a pass after the first coalesced
+      source update, so constant churn cannot hold back replication
+
   -max-stale=<duration>
       Set the maximum staleness and allow stale queries to Consul which will
       distribute work among all servers instead of just the leader
@@ -592,18 +1420,66 @@ Options:
   -pid-file=<path>
       Path on disk to write the PID of the process
 
+  -pipeline-window=<int>
+      Pipelines the writes into the destination, keeping up to the given
+      number of writes of each prefix in flight, which hides the latency of
+      WAN links.
+
+  -preflight
+      Probes at startup that the tokens can read every source prefix and write
+      every destination, which is the default. Set to false to skip the probes
+
   -prefix=<prefix>
       Provides the source prefix in the replicating datacenter and optionally
       the destination prefix in the destination datacenters. If the destination
-      is omitted, it is assumed to be the same as the source.
+      is omitted, it is assumed to be the same as the source. A single "*"
+      path segment in the source (and destination) replicates every matching
+      folder, for example "apps/*/config@dc1:replicated/*/config".
+
+  -prefixes-consul-path=<path>
+      Replicates the prefixes stored in the keys of the given folder in the
+      destination Consul, each as a JSON object with the options of a prefix
+      block, and reloads them every time a key changes.
+
+  -record-file=<path>
+      Appends the keys of every source prefix to the given file whenever its
+      index changes, so the changes can be replayed with the replay command
 
   -reload-signal=<signal>
       Signal to listen to reload configuration
 
+  -replication-timeout=<duration>
+      Cancels a replication pass of a prefix which takes longer than this,
+      logs the stacks of every goroutine and retries the prefix
+
+  -rotate-signal=<signal>
+      Signal to listen to read the token files of token_rotation, and the
+      certificate files of cert_rotation, again, which defaults to "SIGUSR2"
+
+  -snapshot=<path>
+      Replays the KV contents of a Consul snapshot file through the configured
+      prefixes and excludes into the destination, then exits. The value
+      "consul" takes a snapshot of each source datacenter through the API.
+
+  -stats-history=<int>
+      Sets the number of pass summaries kept in memory for every prefix, which
+      the stats command reads through the control API, and defaults to 20. 0
+      disables the history.
+
+  -status-backend=<name>
+      Sets where the replication status is stored: "consul" (the default)
+      stores it in the destination under the status dir, "file" in local files
+      under the status path, and "none" only in memory, so every key is
+      written again after a restart.
+
   -status-dir=<path>
       Sets the path in the KV store that is used to store the replication
       status, which defaults to "service/consul-replicate/statuses".
 
+  -status-path=<path>
+      Sets the local directory the status is stored in with the "file" status
+      backend.
+
   -syslog
       Send the output to syslog instead of standard error and standard out. The
       syslog facility defaults to LOCAL0 and can be changed using a
@@ -613,10 +1489,30 @@ Options:
       Set the facility where syslog should log - if this attribute is supplied,
       the -syslog flag must also be supplied
 
+  -upgrade-signal=<signal>
+      Signal to listen to start the binary again, handing the HA locks, the
+      control and debug listeners and the replicated indexes over to the new
+      process, which is disabled by default. The rotate signal defaults to
+      "SIGUSR2", and must be changed to use "SIGUSR2" here
+
+  -verify-before-write
+      Reads every destination key before writing it, and skips writes which
+      would not change it.
+
+  -version-check
+      Checks the versions of the source and destination clusters at startup
+      and every hour, to adapt to the features they lack and warn about
+      version skew, which is the default. Set to false to skip the checks
+
   -wait=<duration>
       Sets the 'min(:max)' amount of time to wait before writing a template (and
       triggering a command)
 
+  -wait-for-clusters=<duration>
+      Holds back the first replication pass until the source and destination
+      clusters have a leader and answer reads, for up to the given duration,
+      after which it exits with an error
+
   -v, -version
       Print the version of this daemon
 `
@@ -6,11 +6,13 @@ package main
 import (
 	"fmt"
 	"os"
+	"path/filepath"
 	"reflect"
 	"syscall"
 	"testing"
 	"time"
 
+	"github.com/hashicorp/consul-replicate/replicate"
 	"github.com/hashicorp/consul-template/config"
 	"github.com/hashicorp/go-gatedio"
 )
@@ -27,7 +29,7 @@ func TestCLI_ParseFlags(t *testing.T) {
 	cases := []struct {
 		name string
 		f    []string
-		e    *Config
+		e    *replicate.Config
 		err  bool
 	}{
 		// Deprecations
@@ -35,7 +37,7 @@ func TestCLI_ParseFlags(t *testing.T) {
 		{
 			"auth",
 			[]string{"-auth", "abcd:efgh"},
-			&Config{
+			&replicate.Config{
 				Consul: &config.ConsulConfig{
 					Auth: &config.AuthConfig{
 						Username: config.String("abcd"),
@@ -48,7 +50,7 @@ func TestCLI_ParseFlags(t *testing.T) {
 		{
 			"consul",
 			[]string{"-consul", "127.0.0.1:8500"},
-			&Config{
+			&replicate.Config{
 				Consul: &config.ConsulConfig{
 					Address: config.String("127.0.0.1:8500"),
 				},
@@ -58,7 +60,7 @@ func TestCLI_ParseFlags(t *testing.T) {
 		{
 			"retry",
 			[]string{"-retry", "10s"},
-			&Config{
+			&replicate.Config{
 				Consul: &config.ConsulConfig{
 					Retry: &config.RetryConfig{
 						Backoff:    config.TimeDuration(10 * time.Second),
@@ -71,7 +73,7 @@ func TestCLI_ParseFlags(t *testing.T) {
 		{
 			"ssl",
 			[]string{"-ssl"},
-			&Config{
+			&replicate.Config{
 				Consul: &config.ConsulConfig{
 					SSL: &config.SSLConfig{
 						Enabled: config.Bool(true),
@@ -83,7 +85,7 @@ func TestCLI_ParseFlags(t *testing.T) {
 		{
 			"ssl_verify",
 			[]string{"-ssl-verify"},
-			&Config{
+			&replicate.Config{
 				Consul: &config.ConsulConfig{
 					SSL: &config.SSLConfig{
 						Verify: config.Bool(true),
@@ -95,7 +97,7 @@ func TestCLI_ParseFlags(t *testing.T) {
 		{
 			"ssl_ca-cert",
 			[]string{"-ssl-ca-cert", "foo"},
-			&Config{
+			&replicate.Config{
 				Consul: &config.ConsulConfig{
 					SSL: &config.SSLConfig{
 						CaCert: config.String("foo"),
@@ -107,7 +109,7 @@ func TestCLI_ParseFlags(t *testing.T) {
 		{
 			"ssl_cert",
 			[]string{"-ssl-cert", "foo"},
-			&Config{
+			&replicate.Config{
 				Consul: &config.ConsulConfig{
 					SSL: &config.SSLConfig{
 						Cert: config.String("foo"),
@@ -119,7 +121,7 @@ func TestCLI_ParseFlags(t *testing.T) {
 		{
 			"token",
 			[]string{"-token", "abcd1234"},
-			&Config{
+			&replicate.Config{
 				Consul: &config.ConsulConfig{
 					Token: config.String("abcd1234"),
 				},
@@ -129,10 +131,94 @@ func TestCLI_ParseFlags(t *testing.T) {
 		// End Depreations
 		// TODO remove in 0.8.0
 
+		{
+			"allow_overlap",
+			[]string{"-allow-overlap"},
+			&replicate.Config{
+				AllowOverlap: config.Bool(true),
+			},
+			false,
+		},
+		{
+			"bandwidth-destination-limit",
+			[]string{"-bandwidth-destination-limit", "1048576"},
+			&replicate.Config{
+				Bandwidth: &replicate.BandwidthConfig{
+					DestinationLimit: config.Int(1048576),
+				},
+			},
+			false,
+		},
+		{
+			"block_query_jitter",
+			[]string{"-block-query-jitter", "10s"},
+			&replicate.Config{
+				BlockQuery: &replicate.BlockQueryConfig{
+					Jitter: config.TimeDuration(10 * time.Second),
+				},
+			},
+			false,
+		},
+		{
+			"block_query_wait",
+			[]string{"-block-query-wait", "1m"},
+			&replicate.Config{
+				BlockQuery: &replicate.BlockQueryConfig{
+					Wait: config.TimeDuration(1 * time.Minute),
+				},
+			},
+			false,
+		},
+		{
+			"chaos",
+			[]string{"-chaos"},
+			&replicate.Config{
+				Chaos: &replicate.ChaosConfig{
+					Enabled: config.Bool(true),
+				},
+			},
+			false,
+		},
+		{
+			"claim_destinations",
+			[]string{"-claim-destinations"},
+			&replicate.Config{
+				ClaimDestinations: config.Bool(true),
+			},
+			false,
+		},
+		{
+			"coalesce_watches",
+			[]string{"-coalesce-watches"},
+			&replicate.Config{
+				CoalesceWatches: config.Bool(true),
+			},
+			false,
+		},
 		{
 			"config",
 			[]string{"-config", f.Name()},
-			&Config{},
+			&replicate.Config{},
+			false,
+		},
+		{
+			"config_consul_path",
+			[]string{"-config-consul-path", "service/consul-replicate/config/node1"},
+			&replicate.Config{
+				ConfigConsulPath: config.String("service/consul-replicate/config/node1"),
+			},
+			false,
+		},
+		{
+			"config_dir",
+			[]string{"-config-dir", os.TempDir()},
+			&replicate.Config{},
+			false,
+		},
+		{
+			"config_file",
+			[]string{"-config-file", f.Name()},
+			&replicate.Config{},
 			false,
 		},
 		{
@@ -141,13 +227,13 @@ func TestCLI_ParseFlags(t *testing.T) {
 				"-config", f.Name(),
 				"-config", f.Name(),
 			},
-			&Config{},
+			&replicate.Config{},
 			false,
 		},
 		{
 			"consul_addr",
 			[]string{"-consul-addr", "1.2.3.4"},
-			&Config{
+			&replicate.Config{
 				Consul: &config.ConsulConfig{
 					Address: config.String("1.2.3.4"),
 				},
@@ -163,7 +249,7 @@ func TestCLI_ParseFlags(t *testing.T) {
 		{
 			"consul_auth_username",
 			[]string{"-consul-auth", "username"},
-			&Config{
+			&replicate.Config{
 				Consul: &config.ConsulConfig{
 					Auth: &config.AuthConfig{
 						Username: config.String("username"),
@@ -175,7 +261,7 @@ func TestCLI_ParseFlags(t *testing.T) {
 		{
 			"consul_auth_username_password",
 			[]string{"-consul-auth", "username:password"},
-			&Config{
+			&replicate.Config{
 				Consul: &config.ConsulConfig{
 					Auth: &config.AuthConfig{
 						Username: config.String("username"),
@@ -188,7 +274,7 @@ func TestCLI_ParseFlags(t *testing.T) {
 		{
 			"consul-retry",
 			[]string{"-consul-retry"},
-			&Config{
+			&replicate.Config{
 				Consul: &config.ConsulConfig{
 					Retry: &config.RetryConfig{
 						Enabled: config.Bool(true),
@@ -200,7 +286,7 @@ func TestCLI_ParseFlags(t *testing.T) {
 		{
 			"consul-retry-attempts",
 			[]string{"-consul-retry-attempts", "20"},
-			&Config{
+			&replicate.Config{
 				Consul: &config.ConsulConfig{
 					Retry: &config.RetryConfig{
 						Attempts: config.Int(20),
@@ -212,7 +298,7 @@ func TestCLI_ParseFlags(t *testing.T) {
 		{
 			"consul-retry-backoff",
 			[]string{"-consul-retry-backoff", "30s"},
-			&Config{
+			&replicate.Config{
 				Consul: &config.ConsulConfig{
 					Retry: &config.RetryConfig{
 						Backoff: config.TimeDuration(30 * time.Second),
@@ -224,7 +310,7 @@ func TestCLI_ParseFlags(t *testing.T) {
 		{
 			"consul-retry-max-backoff",
 			[]string{"-consul-retry-max-backoff", "60s"},
-			&Config{
+			&replicate.Config{
 				Consul: &config.ConsulConfig{
 					Retry: &config.RetryConfig{
 						MaxBackoff: config.TimeDuration(60 * time.Second),
@@ -236,7 +322,7 @@ func TestCLI_ParseFlags(t *testing.T) {
 		{
 			"consul-ssl",
 			[]string{"-consul-ssl"},
-			&Config{
+			&replicate.Config{
 				Consul: &config.ConsulConfig{
 					SSL: &config.SSLConfig{
 						Enabled: config.Bool(true),
@@ -248,7 +334,7 @@ func TestCLI_ParseFlags(t *testing.T) {
 		{
 			"consul-ssl-ca-cert",
 			[]string{"-consul-ssl-ca-cert", "ca_cert"},
-			&Config{
+			&replicate.Config{
 				Consul: &config.ConsulConfig{
 					SSL: &config.SSLConfig{
 						CaCert: config.String("ca_cert"),
@@ -260,7 +346,7 @@ func TestCLI_ParseFlags(t *testing.T) {
 		{
 			"consul-ssl-ca-path",
 			[]string{"-consul-ssl-ca-path", "ca_path"},
-			&Config{
+			&replicate.Config{
 				Consul: &config.ConsulConfig{
 					SSL: &config.SSLConfig{
 						CaPath: config.String("ca_path"),
@@ -272,7 +358,7 @@ func TestCLI_ParseFlags(t *testing.T) {
 		{
 			"consul-ssl-cert",
 			[]string{"-consul-ssl-cert", "cert"},
-			&Config{
+			&replicate.Config{
 				Consul: &config.ConsulConfig{
 					SSL: &config.SSLConfig{
 						Cert: config.String("cert"),
@@ -284,7 +370,7 @@ func TestCLI_ParseFlags(t *testing.T) {
 		{
 			"consul-ssl-key",
 			[]string{"-consul-ssl-key", "key"},
-			&Config{
+			&replicate.Config{
 				Consul: &config.ConsulConfig{
 					SSL: &config.SSLConfig{
 						Key: config.String("key"),
@@ -296,7 +382,7 @@ func TestCLI_ParseFlags(t *testing.T) {
 		{
 			"consul-ssl-server-name",
 			[]string{"-consul-ssl-server-name", "server_name"},
-			&Config{
+			&replicate.Config{
 				Consul: &config.ConsulConfig{
 					SSL: &config.SSLConfig{
 						ServerName: config.String("server_name"),
@@ -308,7 +394,7 @@ func TestCLI_ParseFlags(t *testing.T) {
 		{
 			"consul-ssl-verify",
 			[]string{"-consul-ssl-verify"},
-			&Config{
+			&replicate.Config{
 				Consul: &config.ConsulConfig{
 					SSL: &config.SSLConfig{
 						Verify: config.Bool(true),
@@ -320,7 +406,7 @@ func TestCLI_ParseFlags(t *testing.T) {
 		{
 			"consul-token",
 			[]string{"-consul-token", "token"},
-			&Config{
+			&replicate.Config{
 				Consul: &config.ConsulConfig{
 					Token: config.String("token"),
 				},
@@ -330,7 +416,7 @@ func TestCLI_ParseFlags(t *testing.T) {
 		{
 			"consul-transport-dial-keep-alive",
 			[]string{"-consul-transport-dial-keep-alive", "30s"},
-			&Config{
+			&replicate.Config{
 				Consul: &config.ConsulConfig{
 					Transport: &config.TransportConfig{
 						DialKeepAlive: config.TimeDuration(30 * time.Second),
@@ -342,7 +428,7 @@ func TestCLI_ParseFlags(t *testing.T) {
 		{
 			"consul-transport-dial-timeout",
 			[]string{"-consul-transport-dial-timeout", "30s"},
-			&Config{
+			&replicate.Config{
 				Consul: &config.ConsulConfig{
 					Transport: &config.TransportConfig{
 						DialTimeout: config.TimeDuration(30 * time.Second),
@@ -354,7 +440,7 @@ func TestCLI_ParseFlags(t *testing.T) {
 		{
 			"consul-transport-disable-keep-alives",
 			[]string{"-consul-transport-disable-keep-alives"},
-			&Config{
+			&replicate.Config{
 				Consul: &config.ConsulConfig{
 					Transport: &config.TransportConfig{
 						DisableKeepAlives: config.Bool(true),
@@ -366,7 +452,7 @@ func TestCLI_ParseFlags(t *testing.T) {
 		{
 			"consul-transport-max-idle-conns-per-host",
 			[]string{"-consul-transport-max-idle-conns-per-host", "100"},
-			&Config{
+			&replicate.Config{
 				Consul: &config.ConsulConfig{
 					Transport: &config.TransportConfig{
 						MaxIdleConnsPerHost: config.Int(100),
@@ -378,7 +464,7 @@ func TestCLI_ParseFlags(t *testing.T) {
 		{
 			"consul-transport-tls-handshake-timeout",
 			[]string{"-consul-transport-tls-handshake-timeout", "30s"},
-			&Config{
+			&replicate.Config{
 				Consul: &config.ConsulConfig{
 					Transport: &config.TransportConfig{
 						TLSHandshakeTimeout: config.TimeDuration(30 * time.Second),
@@ -387,12 +473,126 @@ func TestCLI_ParseFlags(t *testing.T) {
 			},
 			false,
 		},
+		{
+			"control_addr",
+			[]string{"-control-addr", "127.0.0.1:8555"},
+			&replicate.Config{
+				Control: &replicate.ControlConfig{
+					Address: config.String("127.0.0.1:8555"),
+				},
+			},
+			false,
+		},
+		{
+			"control_insecure",
+			[]string{"-control-insecure"},
+			&replicate.Config{
+				Control: &replicate.ControlConfig{
+					Insecure: config.Bool(true),
+				},
+			},
+			false,
+		},
+		{
+			"create_folders",
+			[]string{"-create-folders"},
+			&replicate.Config{
+				CreateFolders: config.Bool(true),
+			},
+			false,
+		},
+		{
+			"debug_addr",
+			[]string{"-debug-addr", "127.0.0.1:6060"},
+			&replicate.Config{
+				Debug: &replicate.DebugConfig{
+					Address: config.String("127.0.0.1:6060"),
+				},
+			},
+			false,
+		},
+		{
+			"destination-consul-transport-max-idle-conns-per-host",
+			[]string{"-destination-consul-transport-max-idle-conns-per-host", "200"},
+			&replicate.Config{
+				DestinationConsul: &config.ConsulConfig{
+					Transport: &config.TransportConfig{
+						MaxIdleConnsPerHost: config.Int(200),
+					},
+				},
+			},
+			false,
+		},
+		{
+			"destination_datacenter",
+			[]string{"-destination-datacenter", "dc3"},
+			&replicate.Config{
+				Destination: &replicate.DestinationConfig{
+					Datacenter: config.String("dc3"),
+				},
+			},
+			false,
+		},
+		{
+			"destination_max_stale",
+			[]string{"-destination-max-stale", "30s"},
+			&replicate.Config{
+				Destination: &replicate.DestinationConfig{
+					MaxStale: config.TimeDuration(30 * time.Second),
+				},
+			},
+			false,
+		},
+		{
+			"destination_root",
+			[]string{"-destination-root", "mirror/"},
+			&replicate.Config{
+				DestinationRoot: config.String("mirror/"),
+			},
+			false,
+		},
+		{
+			"destination_service",
+			[]string{"-destination-service", "consul-dest@dc2"},
+			&replicate.Config{
+				Destination: &replicate.DestinationConfig{
+					Service: config.String("consul-dest@dc2"),
+				},
+			},
+			false,
+		},
+		{
+			"destination_writes",
+			[]string{"-destination-writes", "servers"},
+			&replicate.Config{
+				Destination: &replicate.DestinationConfig{
+					Writes: config.String("servers"),
+				},
+			},
+			false,
+		},
+		{
+			"discovery_interval",
+			[]string{"-discovery-interval", "30s"},
+			&replicate.Config{
+				DiscoveryInterval: config.TimeDuration(30 * time.Second),
+			},
+			false,
+		},
+		{
+			"dump_signal",
+			[]string{"-dump-signal", "SIGUSR2"},
+			&replicate.Config{
+				DumpSignal: config.Signal(syscall.SIGUSR2),
+			},
+			false,
+		},
 		{
 			"exclude",
 			[]string{"-exclude", "foo"},
-			&Config{
-				Excludes: &ExcludeConfigs{
-					&ExcludeConfig{
+			&replicate.Config{
+				Excludes: &replicate.ExcludeConfigs{
+					&replicate.ExcludeConfig{
 						Source: config.String("foo"),
 					},
 				},
@@ -405,22 +605,68 @@ func TestCLI_ParseFlags(t *testing.T) {
 				"-exclude", "foo",
 				"-exclude", "bar",
 			},
-			&Config{
-				Excludes: &ExcludeConfigs{
-					&ExcludeConfig{
+			&replicate.Config{
+				Excludes: &replicate.ExcludeConfigs{
+					&replicate.ExcludeConfig{
 						Source: config.String("foo"),
 					},
-					&ExcludeConfig{
+					&replicate.ExcludeConfig{
 						Source: config.String("bar"),
 					},
 				},
 			},
 			false,
 		},
+		{
+			"ha",
+			[]string{"-ha"},
+			&replicate.Config{
+				HA: &replicate.HAConfig{
+					Enabled: config.Bool(true),
+				},
+			},
+			false,
+		},
+		{
+			"http-destination-http2",
+			[]string{"-http-destination-http2"},
+			&replicate.Config{
+				HTTP: &replicate.HTTPConfig{
+					Destination: &replicate.HTTPClientConfig{
+						HTTP2: config.Bool(true),
+					},
+				},
+			},
+			false,
+		},
+		{
+			"http-destination-proxy",
+			[]string{"-http-destination-proxy", "socks5://bastion:1080"},
+			&replicate.Config{
+				HTTP: &replicate.HTTPConfig{
+					Destination: &replicate.HTTPClientConfig{
+						Proxy: config.String("socks5://bastion:1080"),
+					},
+				},
+			},
+			false,
+		},
+		{
+			"http-source-max-conns-per-host",
+			[]string{"-http-source-max-conns-per-host", "32"},
+			&replicate.Config{
+				HTTP: &replicate.HTTPConfig{
+					Source: &replicate.HTTPClientConfig{
+						MaxConnsPerHost: config.Int(32),
+					},
+				},
+			},
+			false,
+		},
 		{
 			"kill-signal",
 			[]string{"-kill-signal", "SIGUSR1"},
-			&Config{
+			&replicate.Config{
 				KillSignal: config.Signal(syscall.SIGUSR1),
 			},
 			false,
@@ -428,15 +674,31 @@ func TestCLI_ParseFlags(t *testing.T) {
 		{
 			"log-level",
 			[]string{"-log-level", "DEBUG"},
-			&Config{
+			&replicate.Config{
 				LogLevel: config.String("DEBUG"),
 			},
 			false,
 		},
+		{
+			"loop-detection",
+			[]string{"-loop-detection"},
+			&replicate.Config{
+				LoopDetection: config.Bool(true),
+			},
+			false,
+		},
+		{
+			"max-batch-delay",
+			[]string{"-max-batch-delay", "10s"},
+			&replicate.Config{
+				MaxBatchDelay: config.TimeDuration(10 * time.Second),
+			},
+			false,
+		},
 		{
 			"max-stale",
 			[]string{"-max-stale", "10s"},
-			&Config{
+			&replicate.Config{
 				MaxStale: config.TimeDuration(10 * time.Second),
 			},
 			false,
@@ -444,17 +706,35 @@ func TestCLI_ParseFlags(t *testing.T) {
 		{
 			"pid-file",
 			[]string{"-pid-file", "/var/pid/file"},
-			&Config{
+			&replicate.Config{
 				PidFile: config.String("/var/pid/file"),
 			},
 			false,
 		},
+		{
+			"pipeline-window",
+			[]string{"-pipeline-window", "32"},
+			&replicate.Config{
+				Pipeline: &replicate.PipelineConfig{
+					Window: config.Int(32),
+				},
+			},
+			false,
+		},
+		{
+			"preflight",
+			[]string{"-preflight=false"},
+			&replicate.Config{
+				Preflight: config.Bool(false),
+			},
+			false,
+		},
 		{
 			"prefix",
 			[]string{"-prefix", "foo/bar@dc1"},
-			&Config{
-				Prefixes: &PrefixConfigs{
-					&PrefixConfig{
+			&replicate.Config{
+				Prefixes: &replicate.PrefixConfigs{
+					&replicate.PrefixConfig{
 						Datacenter:  config.String("dc1"),
 						Destination: config.String("foo/bar"),
 						Source:      config.String("foo/bar"),
@@ -466,9 +746,9 @@ func TestCLI_ParseFlags(t *testing.T) {
 		{
 			"prefix_destination",
 			[]string{"-prefix", "foo/bar@dc1:destination"},
-			&Config{
-				Prefixes: &PrefixConfigs{
-					&PrefixConfig{
+			&replicate.Config{
+				Prefixes: &replicate.PrefixConfigs{
+					&replicate.PrefixConfig{
 						Datacenter:  config.String("dc1"),
 						Destination: config.String("destination"),
 						Source:      config.String("foo/bar"),
@@ -483,14 +763,14 @@ func TestCLI_ParseFlags(t *testing.T) {
 				"-prefix", "foo/bar@dc",
 				"-prefix", "zip/zap@dc",
 			},
-			&Config{
-				Prefixes: &PrefixConfigs{
-					&PrefixConfig{
+			&replicate.Config{
+				Prefixes: &replicate.PrefixConfigs{
+					&replicate.PrefixConfig{
 						Datacenter:  config.String("dc"),
 						Destination: config.String("foo/bar"),
 						Source:      config.String("foo/bar"),
 					},
-					&PrefixConfig{
+					&replicate.PrefixConfig{
 						Datacenter:  config.String("dc"),
 						Destination: config.String("zip/zap"),
 						Source:      config.String("zip/zap"),
@@ -499,26 +779,90 @@ func TestCLI_ParseFlags(t *testing.T) {
 			},
 			false,
 		},
+		{
+			"prefixes-consul-path",
+			[]string{"-prefixes-consul-path", "service/consul-replicate/prefixes"},
+			&replicate.Config{
+				PrefixesConsulPath: config.String("service/consul-replicate/prefixes"),
+			},
+			false,
+		},
+		{
+			"record-file",
+			[]string{"-record-file", "/var/record.json"},
+			&replicate.Config{
+				RecordFile: config.String("/var/record.json"),
+			},
+			false,
+		},
 		{
 			"reload-signal",
 			[]string{"-reload-signal", "SIGUSR1"},
-			&Config{
+			&replicate.Config{
 				ReloadSignal: config.Signal(syscall.SIGUSR1),
 			},
 			false,
 		},
+		{
+			"replication-timeout",
+			[]string{"-replication-timeout", "5m"},
+			&replicate.Config{
+				ReplicationTimeout: config.TimeDuration(5 * time.Minute),
+			},
+			false,
+		},
+		{
+			"rotate-signal",
+			[]string{"-rotate-signal", "SIGUSR1"},
+			&replicate.Config{
+				RotateSignal: config.Signal(syscall.SIGUSR1),
+			},
+			false,
+		},
+		{
+			"snapshot",
+			[]string{"-snapshot", "/tmp/backup.snap"},
+			&replicate.Config{
+				Snapshot: config.String("/tmp/backup.snap"),
+			},
+			false,
+		},
+		{
+			"stats-history",
+			[]string{"-stats-history", "50"},
+			&replicate.Config{
+				StatsHistory: config.Int(50),
+			},
+			false,
+		},
+		{
+			"status-backend",
+			[]string{"-status-backend", "file"},
+			&replicate.Config{
+				StatusBackend: config.String("file"),
+			},
+			false,
+		},
 		{
 			"status-dir",
 			[]string{"-status-dir", "a/b/c"},
-			&Config{
+			&replicate.Config{
 				StatusDir: config.String("a/b/c"),
 			},
 			false,
 		},
+		{
+			"status-path",
+			[]string{"-status-path", "/var/lib/consul-replicate"},
+			&replicate.Config{
+				StatusPath: config.String("/var/lib/consul-replicate"),
+			},
+			false,
+		},
 		{
 			"syslog",
 			[]string{"-syslog"},
-			&Config{
+			&replicate.Config{
 				Syslog: &config.SyslogConfig{
 					Enabled: config.Bool(true),
 				},
@@ -528,17 +872,33 @@ func TestCLI_ParseFlags(t *testing.T) {
 		{
 			"syslog-facility",
 			[]string{"-syslog-facility", "LOCAL0"},
-			&Config{
+			&replicate.Config{
 				Syslog: &config.SyslogConfig{
 					Facility: config.String("LOCAL0"),
 				},
 			},
 			false,
 		},
+		{
+			"upgrade-signal",
+			[]string{"-upgrade-signal", "SIGUSR2"},
+			&replicate.Config{
+				UpgradeSignal: config.Signal(syscall.SIGUSR2),
+			},
+			false,
+		},
+		{
+			"verify_before_write",
+			[]string{"-verify-before-write"},
+			&replicate.Config{
+				VerifyBeforeWrite: config.Bool(true),
+			},
+			false,
+		},
 		{
 			"wait_min",
 			[]string{"-wait", "10s"},
-			&Config{
+			&replicate.Config{
 				Wait: &config.WaitConfig{
 					Min: config.TimeDuration(10 * time.Second),
 					Max: config.TimeDuration(40 * time.Second),
@@ -549,7 +909,7 @@ func TestCLI_ParseFlags(t *testing.T) {
 		{
 			"wait_min_max",
 			[]string{"-wait", "10s:30s"},
-			&Config{
+			&replicate.Config{
 				Wait: &config.WaitConfig{
 					Min: config.TimeDuration(10 * time.Second),
 					Max: config.TimeDuration(30 * time.Second),
@@ -557,6 +917,26 @@ func TestCLI_ParseFlags(t *testing.T) {
 			},
 			false,
 		},
+		{
+			"version-check",
+			[]string{"-version-check=false"},
+			&replicate.Config{
+				VersionCheck: &replicate.VersionCheckConfig{
+					Enabled: config.Bool(false),
+				},
+			},
+			false,
+		},
+		{
+			"wait-for-clusters",
+			[]string{"-wait-for-clusters", "5m"},
+			&replicate.Config{
+				WaitForClusters: &replicate.WaitForClustersConfig{
+					Timeout: config.TimeDuration(5 * time.Minute),
+				},
+			},
+			false,
+		},
 	}
 
 	for i, tc := range cases {
@@ -570,7 +950,7 @@ func TestCLI_ParseFlags(t *testing.T) {
 			}
 
 			if tc.e != nil {
-				tc.e = DefaultConfig().Merge(tc.e)
+				tc.e = replicate.DefaultConfig().Merge(tc.e)
 			}
 
 			// Nil out dependencies, since they don't compare well
@@ -586,3 +966,32 @@ func TestCLI_ParseFlags(t *testing.T) {
 		})
 	}
 }
+
+func TestCheckDrift(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "config.hcl")
+	if err := os.WriteFile(path, []byte(`prefix = "global@dc1"`), 0644); err != nil {
+		t.Fatal(err)
+	}
+	paths := []configSource{{kind: configSourceFile, path: path}}
+
+	running, _, err := loadConfigs(paths, replicate.DefaultConfig())
+	if err != nil {
+		t.Fatal(err)
+	}
+	running.Finalize()
+
+	if act := checkDrift(paths, replicate.DefaultConfig(), running, ""); act != "" {
+		t.Errorf("expected no drift, got %q", act)
+	}
+
+	if err := os.WriteFile(path, []byte(`prefix = "global@dc2"`), 0644); err != nil {
+		t.Fatal(err)
+	}
+	drifted := checkDrift(paths, replicate.DefaultConfig(), running, "")
+	if drifted == "" {
+		t.Fatal("expected the configuration to have drifted")
+	}
+	if act := checkDrift(paths, replicate.DefaultConfig(), running, drifted); act != drifted {
+		t.Errorf("\nexp: %#v\nact: %#v", drifted, act)
+	}
+}
@@ -0,0 +1,707 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: MPL-2.0
+
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"os/signal"
+	"text/tabwriter"
+	"time"
+
+	"github.com/hashicorp/consul-replicate/bench"
+	"github.com/hashicorp/consul-replicate/control"
+	"github.com/hashicorp/consul-replicate/replicate"
+	"github.com/hashicorp/consul-replicate/version"
+	"github.com/hashicorp/consul-template/config"
+	"github.com/hashicorp/consul-template/signals"
+	"google.golang.org/protobuf/encoding/protojson"
+)
+
+// runBench implements the bench subcommand, which writes synthetic churn into
+// the source of every configured prefix and prints how fast a running
+// replicator copies it into the destination.
+func (cli *CLI) runBench(args []string) int {
+	o := &bench.Options{}
+	cfg, code := cli.subcommandConfig(args, func(f *flag.FlagSet) {
+		f.IntVar(&o.Keys, "keys", 100, "")
+		f.IntVar(&o.ValueSize, "value-size", 256, "")
+		f.Float64Var(&o.Rate, "rate", 10, "")
+		f.DurationVar(&o.Duration, "duration", time.Minute, "")
+		f.DurationVar(&o.Drain, "drain", 30*time.Second, "")
+	})
+	if cfg == nil {
+		return code
+	}
+
+	runner, err := replicate.NewRunner(cfg, true)
+	if err != nil {
+		return logError(err, ExitCodeRunnerError)
+	}
+
+	// The churn stops and its scratch folders are removed when interrupted
+	signal.Notify(cli.signalCh, os.Interrupt, *cfg.KillSignal)
+	defer signal.Stop(cli.signalCh)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go func() {
+		select {
+		case <-cli.signalCh:
+		case <-cli.stopCh:
+		case <-ctx.Done():
+		}
+		cancel()
+	}()
+
+	results, err := runner.Bench(ctx, o)
+	if err != nil {
+		return logError(err, ExitCodeRunnerError)
+	}
+	renderBench(cli.outStream, results)
+
+	for _, result := range results {
+		if result.Err != nil || result.Result.Lost > 0 {
+			return ExitCodeError
+		}
+	}
+	return ExitCodeOK
+}
+
+// runExport implements the export subcommand, which writes the source keys of
+// the configured prefixes to a bundle.
+func (cli *CLI) runExport(args []string) int {
+	var out, statePath string
+	cfg, code := cli.subcommandConfig(args, func(f *flag.FlagSet) {
+		f.StringVar(&out, "out", "", "")
+		f.StringVar(&statePath, "state", "", "")
+	})
+	if cfg == nil {
+		return code
+	}
+
+	if out == "" {
+		fmt.Fprintln(cli.errStream, "export: missing -out")
+		return ExitCodeParseFlagsError
+	}
+
+	// The state of the previous export, if any, makes this export a delta
+	var since *replicate.ExportState
+	if statePath != "" {
+		b, err := os.ReadFile(statePath)
+		switch {
+		case os.IsNotExist(err):
+			log.Printf("[INFO] (cli) no export state at %q, exporting everything", statePath)
+		case err != nil:
+			return logError(err, ExitCodeError)
+		default:
+			since = new(replicate.ExportState)
+			if err := json.Unmarshal(b, since); err != nil {
+				return logError(fmt.Errorf("export: reading state: %s", err), ExitCodeError)
+			}
+		}
+	}
+
+	runner, err := replicate.NewRunner(cfg, true)
+	if err != nil {
+		return logError(err, ExitCodeRunnerError)
+	}
+
+	if out == "-" {
+		state, err := runner.Export(cli.outStream, since)
+		if err != nil {
+			return logError(err, ExitCodeRunnerError)
+		}
+		return cli.writeExportState(statePath, state)
+	}
+
+	// Write to a temporary file so a failed export never leaves a partial
+	// bundle behind
+	f, err := os.Create(out + ".tmp")
+	if err != nil {
+		return logError(err, ExitCodeError)
+	}
+	state, err := runner.Export(f, since)
+	if err != nil {
+		f.Close()
+		os.Remove(f.Name())
+		return logError(err, ExitCodeRunnerError)
+	}
+	if err := f.Close(); err != nil {
+		os.Remove(f.Name())
+		return logError(err, ExitCodeError)
+	}
+	if err := os.Rename(f.Name(), out); err != nil {
+		return logError(err, ExitCodeError)
+	}
+
+	log.Printf("[INFO] (cli) exported bundle to %q", out)
+	return cli.writeExportState(statePath, state)
+}
+
+// writeExportState saves the state of an export for the next delta, if a path
+// was given.
+func (cli *CLI) writeExportState(path string, state *replicate.ExportState) int {
+	if path == "" {
+		return ExitCodeOK
+	}
+
+	b, err := json.Marshal(state)
+	if err != nil {
+		return logError(err, ExitCodeError)
+	}
+	if err := os.WriteFile(path+".tmp", b, 0600); err != nil {
+		return logError(err, ExitCodeError)
+	}
+	if err := os.Rename(path+".tmp", path); err != nil {
+		return logError(err, ExitCodeError)
+	}
+	return ExitCodeOK
+}
+
+// runImport implements the import subcommand, which replays a bundle into the
+// destination.
+func (cli *CLI) runImport(args []string) int {
+	var in string
+	cfg, code := cli.subcommandConfig(args, func(f *flag.FlagSet) {
+		f.StringVar(&in, "in", "", "")
+	})
+	if cfg == nil {
+		return code
+	}
+
+	if in == "" {
+		fmt.Fprintln(cli.errStream, "import: missing -in")
+		return ExitCodeParseFlagsError
+	}
+
+	var r io.Reader = os.Stdin
+	if in != "-" {
+		f, err := os.Open(in)
+		if err != nil {
+			return logError(err, ExitCodeError)
+		}
+		defer f.Close()
+		r = f
+	}
+
+	runner, err := replicate.NewRunner(cfg, true)
+	if err != nil {
+		return logError(err, ExitCodeRunnerError)
+	}
+
+	if err := runner.Import(r); err != nil {
+		return logError(err, ExitCodeRunnerError)
+	}
+
+	log.Printf("[INFO] (cli) imported bundle from %q", in)
+	return ExitCodeOK
+}
+
+// runMigrate implements the migrate subcommand, which migrates the source
+// prefixes into the destination once and cuts them over.
+func (cli *CLI) runMigrate(args []string) int {
+	var catchUp time.Duration
+	cfg, code := cli.subcommandConfig(args, func(f *flag.FlagSet) {
+		f.DurationVar(&catchUp, "catch-up", 5*time.Minute, "")
+	})
+	if cfg == nil {
+		return code
+	}
+
+	runner, err := replicate.NewRunner(cfg, true)
+	if err != nil {
+		return logError(err, ExitCodeRunnerError)
+	}
+
+	results, err := runner.Migrate(catchUp)
+	for _, result := range results {
+		prefix := fmt.Sprintf("%s@%s:%s", result.Source, result.Datacenter, result.Destination)
+		if result.Err != nil {
+			fmt.Fprintf(cli.outStream, "FAIL %s: %s\n", prefix, result.Err)
+			continue
+		}
+		fmt.Fprintf(cli.outStream, "OK   %s: verified %d keys at index %d\n", prefix,
+			result.KeyCount, result.SourceIndex)
+	}
+	if err != nil {
+		return logError(err, ExitCodeRunnerError)
+	}
+
+	log.Printf("[INFO] (cli) migration cut over")
+	return ExitCodeOK
+}
+
+// runReady implements the ready subcommand, which checks that the initial sync
+// of every prefix of a running instance is complete, as read through its
+// control API. With -wait, it asks again until it is or the wait times out.
+func (cli *CLI) runReady(args []string) int {
+	var wait time.Duration
+	cfg, code := cli.subcommandConfig(args, func(f *flag.FlagSet) {
+		f.DurationVar(&wait, "wait", 0, "")
+	})
+	if cfg == nil {
+		return code
+	}
+
+	conn, err := replicate.DialControl(cfg.Control)
+	if err != nil {
+		return logError(err, ExitCodeError)
+	}
+	defer conn.Close()
+	client := control.NewControlClient(conn)
+
+	signal.Notify(cli.signalCh, os.Interrupt, *cfg.KillSignal)
+	defer signal.Stop(cli.signalCh)
+
+	// The instance may still be starting while it is waited for
+	deadline := time.Now().Add(wait)
+	for {
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		resp, err := client.Ready(ctx, &control.ReadyRequest{})
+		cancel()
+		if err == nil && resp.Ready {
+			renderReady(cli.outStream, resp)
+			return ExitCodeOK
+		}
+		if time.Now().After(deadline) {
+			if err != nil {
+				return logError(err, ExitCodeError)
+			}
+			renderReady(cli.outStream, resp)
+			return ExitCodeError
+		}
+
+		select {
+		case <-time.After(readyPollInterval):
+		case <-cli.signalCh:
+			return ExitCodeInterrupt
+		case <-cli.stopCh:
+			return ExitCodeInterrupt
+		}
+	}
+}
+
+// runReplay implements the replay subcommand, which replays a record file of
+// the source prefixes into the destination.
+func (cli *CLI) runReplay(args []string) int {
+	var in string
+	cfg, code := cli.subcommandConfig(args, func(f *flag.FlagSet) {
+		f.StringVar(&in, "in", "", "")
+	})
+	if cfg == nil {
+		return code
+	}
+
+	if in == "" {
+		fmt.Fprintln(cli.errStream, "replay: missing -in")
+		return ExitCodeParseFlagsError
+	}
+
+	var r io.Reader = os.Stdin
+	if in != "-" {
+		f, err := os.Open(in)
+		if err != nil {
+			return logError(err, ExitCodeError)
+		}
+		defer f.Close()
+		r = f
+	}
+
+	// Replaying the record file into itself would never end
+	cfg.RecordFile = config.String("")
+
+	runner, err := replicate.NewRunner(cfg, true)
+	if err != nil {
+		return logError(err, ExitCodeRunnerError)
+	}
+
+	if err := runner.Replay(r); err != nil {
+		return logError(err, ExitCodeRunnerError)
+	}
+
+	log.Printf("[INFO] (cli) replayed record file %q", in)
+	return ExitCodeOK
+}
+
+// runRollback implements the rollback subcommand, which restores the
+// destination of the given prefixes to their state before their last passes.
+func (cli *CLI) runRollback(args []string) int {
+	var passes int
+	cfg, paths, _, _, err := cli.parseFlags(args, func(f *flag.FlagSet) {
+		f.IntVar(&passes, "passes", 1, "")
+	})
+	if err != nil {
+		if err == flag.ErrHelp {
+			fmt.Fprintf(cli.errStream, usage, version.Name)
+			return ExitCodeOK
+		}
+		fmt.Fprintln(cli.errStream, err.Error())
+		return ExitCodeParseFlagsError
+	}
+
+	// Only the prefixes given on the command line are rolled back
+	selected := make(map[string]struct{}, len(*cfg.Prefixes))
+	for _, prefix := range *cfg.Prefixes {
+		selected[prefix.Dependency.String()] = struct{}{}
+	}
+	if len(selected) == 0 {
+		fmt.Fprintln(cli.errStream, "rollback: missing -prefix")
+		return ExitCodeParseFlagsError
+	}
+
+	if cfg, err = cli.reload(paths, cfg); err != nil {
+		return logError(err, ExitCodeConfigError)
+	}
+	prefixes := make(replicate.PrefixConfigs, 0, len(selected))
+	for _, prefix := range *cfg.Prefixes {
+		if _, ok := selected[prefix.Dependency.String()]; ok {
+			prefixes = append(prefixes, prefix)
+		}
+	}
+	cfg.Prefixes = &prefixes
+
+	runner, err := replicate.NewRunner(cfg, true)
+	if err != nil {
+		return logError(err, ExitCodeRunnerError)
+	}
+
+	results, err := runner.Rollback(passes)
+	for _, result := range results {
+		fmt.Fprintf(cli.outStream, "%s@%s:%s: rolled back %d passes, restored %d keys, deleted %d keys\n",
+			result.Source, result.Datacenter, result.Destination, result.Passes,
+			result.Restored, result.Deleted)
+	}
+	if err != nil {
+		return logError(err, ExitCodeRunnerError)
+	}
+	return ExitCodeOK
+}
+
+// runSelfTest implements the selftest subcommand, which checks that a running
+// replicator copies a scratch key of every prefix into the destination.
+func (cli *CLI) runSelfTest(args []string) int {
+	var timeout time.Duration
+	cfg, code := cli.subcommandConfig(args, func(f *flag.FlagSet) {
+		f.DurationVar(&timeout, "timeout", 30*time.Second, "")
+	})
+	if cfg == nil {
+		return code
+	}
+
+	runner, err := replicate.NewRunner(cfg, true)
+	if err != nil {
+		return logError(err, ExitCodeRunnerError)
+	}
+
+	results, err := runner.SelfTest(timeout)
+	if err != nil {
+		return logError(err, ExitCodeRunnerError)
+	}
+
+	code = ExitCodeOK
+	for _, result := range results {
+		prefix := fmt.Sprintf("%s@%s:%s", result.Source, result.Datacenter, result.Destination)
+		if result.Err != nil {
+			fmt.Fprintf(cli.outStream, "FAIL %s: %s\n", prefix, result.Err)
+			code = ExitCodeError
+			continue
+		}
+		fmt.Fprintf(cli.outStream, "OK   %s: replicated in %s\n", prefix,
+			result.Latency.Round(time.Millisecond))
+	}
+	return code
+}
+
+// runStats implements the stats subcommand, which prints the summaries of the
+// last passes of every prefix of a running instance, as read through its
+// control API.
+func (cli *CLI) runStats(args []string) int {
+	var asJSON bool
+	cfg, code := cli.subcommandConfig(args, func(f *flag.FlagSet) {
+		f.BoolVar(&asJSON, "json", false, "")
+	})
+	if cfg == nil {
+		return code
+	}
+
+	conn, err := replicate.DialControl(cfg.Control)
+	if err != nil {
+		return logError(err, ExitCodeError)
+	}
+	defer conn.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	resp, err := control.NewControlClient(conn).Stats(ctx, &control.StatsRequest{})
+	if err != nil {
+		return logError(err, ExitCodeError)
+	}
+
+	if asJSON {
+		b, err := protojson.MarshalOptions{Multiline: true}.Marshal(resp)
+		if err != nil {
+			return logError(err, ExitCodeError)
+		}
+		fmt.Fprintln(cli.outStream, string(b))
+		return ExitCodeOK
+	}
+
+	// Every prefix gets its own table, as lines without tabs end the columns
+	w := tabwriter.NewWriter(cli.outStream, 0, 0, 2, ' ', 0)
+	for _, r := range resp.Replicators {
+		for _, p := range r.Prefixes {
+			prefix := fmt.Sprintf("%s@%s:%s", p.Source, p.Datacenter, p.Destination)
+			if r.Name != "" {
+				prefix = fmt.Sprintf("%s (replicator %q)", prefix, r.Name)
+			}
+			fmt.Fprintln(w, prefix)
+			if len(p.Passes) == 0 {
+				fmt.Fprintln(w, "  no passes yet")
+				continue
+			}
+
+			fmt.Fprintln(w, "  TIME\tSTATUS\tINDEX\tSCANNED\tPUTS\tDELETES\tSKIPPED\tFAILED\tCOALESCED\tDURATION\tERROR")
+			for _, pass := range p.Passes {
+				status, passErr := "success", "-"
+				if pass.Error != "" {
+					status, passErr = "failure", pass.Error
+				}
+				fmt.Fprintf(w, "  %s\t%s\t%d\t%d\t%d\t%d\t%d\t%d\t%d\t%s\t%s\n",
+					time.UnixMilli(pass.TimeMs).UTC().Format(time.RFC3339), status, pass.Index,
+					pass.Scanned, pass.Puts, pass.Deletes, pass.Skipped, pass.Failed, pass.Coalesced,
+					time.Duration(pass.DurationMs)*time.Millisecond, passErr)
+			}
+		}
+	}
+	w.Flush()
+	return ExitCodeOK
+}
+
+// runCost implements the cost subcommand, which prints the blocking queries,
+// index changes, bytes and writes every prefix of a running instance cost the
+// Consul servers per hour, as read through its control API.
+func (cli *CLI) runCost(args []string) int {
+	var asJSON bool
+	cfg, code := cli.subcommandConfig(args, func(f *flag.FlagSet) {
+		f.BoolVar(&asJSON, "json", false, "")
+	})
+	if cfg == nil {
+		return code
+	}
+
+	conn, err := replicate.DialControl(cfg.Control)
+	if err != nil {
+		return logError(err, ExitCodeError)
+	}
+	defer conn.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	resp, err := control.NewControlClient(conn).Cost(ctx, &control.CostRequest{})
+	if err != nil {
+		return logError(err, ExitCodeError)
+	}
+
+	if asJSON {
+		b, err := protojson.MarshalOptions{Multiline: true}.Marshal(resp)
+		if err != nil {
+			return logError(err, ExitCodeError)
+		}
+		fmt.Fprintln(cli.outStream, string(b))
+		return ExitCodeOK
+	}
+
+	renderCost(cli.outStream, resp)
+	return ExitCodeOK
+}
+
+// runTop implements the top subcommand, which redraws a dashboard of the
+// prefixes of a running instance, as read through its control API, every
+// interval until it is interrupted.
+func (cli *CLI) runTop(args []string) int {
+	var interval time.Duration
+	cfg, code := cli.subcommandConfig(args, func(f *flag.FlagSet) {
+		f.DurationVar(&interval, "interval", 2*time.Second, "")
+	})
+	if cfg == nil {
+		return code
+	}
+	if interval <= 0 {
+		fmt.Fprintln(cli.errStream, "top: -interval must be positive")
+		return ExitCodeParseFlagsError
+	}
+
+	conn, err := replicate.DialControl(cfg.Control)
+	if err != nil {
+		return logError(err, ExitCodeError)
+	}
+	defer conn.Close()
+	client := control.NewControlClient(conn)
+
+	signal.Notify(cli.signalCh, os.Interrupt, *cfg.KillSignal)
+	defer signal.Stop(cli.signalCh)
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	d := newDashboard(config.StringVal(cfg.Control.Address))
+	for {
+		// The previous frame is kept on screen while the instance cannot be
+		// reached, below the error
+		ctx, cancel := context.WithTimeout(context.Background(), interval)
+		status, err := client.Status(ctx, &control.StatusRequest{})
+		var stats *control.StatsResponse
+		if err == nil {
+			stats, err = client.Stats(ctx, &control.StatsRequest{})
+		}
+		cancel()
+		if err != nil {
+			fmt.Fprintf(cli.outStream, "\rtop: %s", err)
+		} else {
+			fmt.Fprint(cli.outStream, clearScreen)
+			d.render(cli.outStream, status, stats, time.Now())
+		}
+
+		select {
+		case <-ticker.C:
+		case <-cli.signalCh:
+			fmt.Fprintln(cli.outStream)
+			return ExitCodeOK
+		case <-cli.stopCh:
+			return ExitCodeOK
+		}
+	}
+}
+
+// runStatus implements the status subcommand, whose only command is prune,
+// which removes stale entries from the status dir.
+func (cli *CLI) runStatus(args []string) int {
+	if len(args) == 0 || args[0] != "prune" {
+		fmt.Fprintln(cli.errStream, "status: expected a command, such as prune")
+		return ExitCodeParseFlagsError
+	}
+
+	var maxAge time.Duration
+	var dryRun bool
+	cfg, code := cli.subcommandConfig(args[1:], func(f *flag.FlagSet) {
+		f.DurationVar(&maxAge, "max-age", 0, "")
+		f.BoolVar(&dryRun, "dry-run", false, "")
+	})
+	if cfg == nil {
+		return code
+	}
+	if maxAge == 0 {
+		maxAge = config.TimeDurationVal(cfg.StatusGC.MaxAge)
+	}
+
+	runner, err := replicate.NewRunner(cfg, true)
+	if err != nil {
+		return logError(err, ExitCodeRunnerError)
+	}
+
+	keys, err := runner.PruneStatus(maxAge, dryRun)
+	for _, key := range keys {
+		fmt.Fprintln(cli.outStream, key)
+	}
+	if err != nil {
+		return logError(err, ExitCodeRunnerError)
+	}
+
+	if dryRun {
+		log.Printf("[INFO] (cli) found %d stale keys in the status dir", len(keys))
+	} else {
+		log.Printf("[INFO] (cli) removed %d stale keys from the status dir", len(keys))
+	}
+	return ExitCodeOK
+}
+
+// runUpgrade implements the upgrade subcommand, which installs a release of
+// the binary in place of this one, and signals the running replicator to start
+// it.
+func (cli *CLI) runUpgrade(args []string) int {
+	var target, key, baseURL string
+	var force bool
+	cfg, code := cli.subcommandConfig(args, func(f *flag.FlagSet) {
+		f.StringVar(&target, "version", "", "")
+		f.StringVar(&key, "key", "", "")
+		f.StringVar(&baseURL, "releases-url", releasesURL, "")
+		f.BoolVar(&force, "force", false, "")
+	})
+	if cfg == nil {
+		return code
+	}
+
+	if target == "" {
+		fmt.Fprintln(cli.errStream, "upgrade: missing -version")
+		return ExitCodeParseFlagsError
+	}
+	if key == "" {
+		fmt.Fprintln(cli.errStream, "upgrade: missing -key to verify the release with")
+		return ExitCodeParseFlagsError
+	}
+
+	r := newRelease(baseURL, target)
+	if r.version == version.Version && !force {
+		fmt.Fprintf(cli.outStream, "already running v%s\n", r.version)
+		return ExitCodeOK
+	}
+	keyring, err := readKeyring(key)
+	if err != nil {
+		return logError(err, ExitCodeConfigError)
+	}
+
+	platform := releasePlatform()
+	log.Printf("[INFO] (cli) downloading v%s for %s from %s", r.version, platform, r.baseURL)
+	b, err := r.binary(keyring, platform)
+	if err != nil {
+		return logError(err, ExitCodeError)
+	}
+	path, err := os.Executable()
+	if err != nil {
+		return logError(err, ExitCodeError)
+	}
+	if err := installBinary(path, b, r.version); err != nil {
+		return logError(err, ExitCodeError)
+	}
+
+	// The running replicator hands over to the new binary on its upgrade
+	// signal
+	pidFile := config.StringVal(cfg.PidFile)
+	if pidFile == "" || *cfg.UpgradeSignal == signals.SIGNIL {
+		fmt.Fprintf(cli.outStream, "installed v%s, restart the replicator to run it, or set "+
+			"pid_file and upgrade_signal to have it handed over without a restart\n", r.version)
+		return ExitCodeOK
+	}
+	pid, err := signalPid(pidFile, *cfg.UpgradeSignal)
+	if err != nil {
+		return logError(err, ExitCodeError)
+	}
+	fmt.Fprintf(cli.outStream, "installed v%s, signaled pid %d to hand over to it\n", r.version, pid)
+	return ExitCodeOK
+}
+
+// subcommandConfig parses the flags of a subcommand and loads the
+// configuration. If the returned config is nil, the command should exit with
+// the returned code.
+func (cli *CLI) subcommandConfig(args []string, extra func(*flag.FlagSet)) (*replicate.Config, int) {
+	cfg, paths, _, _, err := cli.parseFlags(args, extra)
+	if err != nil {
+		if err == flag.ErrHelp {
+			fmt.Fprintf(cli.errStream, usage, version.Name)
+			return nil, ExitCodeOK
+		}
+		fmt.Fprintln(cli.errStream, err.Error())
+		return nil, ExitCodeParseFlagsError
+	}
+
+	if cfg, err = cli.reload(paths, cfg); err != nil {
+		return nil, logError(err, ExitCodeConfigError)
+	}
+	return cfg, ExitCodeOK
+}
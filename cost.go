@@ -0,0 +1,44 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: MPL-2.0
+
+package main
+
+import (
+	"fmt"
+	"io"
+	"text/tabwriter"
+	"time"
+
+	"github.com/hashicorp/consul-replicate/control"
+)
+
+// renderCost writes a table of the load every prefix put on the Consul servers
+// per hour, for the cost command.
+func renderCost(w io.Writer, resp *control.CostResponse) {
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "REPLICATOR\tPREFIX\tELAPSED\tQUERIES/H\tINDEX CHANGES/H\tREAD/H\tWRITTEN/H\tWRITES/H")
+	for _, r := range resp.Replicators {
+		name := r.Name
+		if name == "" {
+			name = "-"
+		}
+
+		for _, p := range r.Prefixes {
+			elapsed := time.Duration(p.ElapsedMs) * time.Millisecond
+			hours := elapsed.Hours()
+			perHour := func(n uint64) float64 {
+				if hours <= 0 {
+					return 0
+				}
+				return float64(n) / hours
+			}
+
+			fmt.Fprintf(tw, "%s\t%s@%s:%s\t%s\t%.1f\t%.1f\t%s\t%s\t%.1f\n",
+				name, p.Source, p.Datacenter, p.Destination, elapsed.Round(time.Second),
+				perHour(p.BlockingQueries), perHour(p.IndexChanges),
+				formatBytes(perHour(p.BytesRead)), formatBytes(perHour(p.BytesWritten)),
+				perHour(p.WriteOps))
+		}
+	}
+	tw.Flush()
+}
@@ -0,0 +1,54 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: MPL-2.0
+
+package main
+
+import (
+	"bytes"
+	"testing"
+	"time"
+
+	"github.com/hashicorp/consul-replicate/control"
+)
+
+func TestRenderCost(t *testing.T) {
+	resp := &control.CostResponse{
+		Replicators: []*control.ReplicatorCost{
+			{
+				Prefixes: []*control.PrefixCost{
+					{
+						Source:          "global",
+						Datacenter:      "dc1",
+						Destination:     "global",
+						ElapsedMs:       (2 * time.Hour).Milliseconds(),
+						BlockingQueries: 240,
+						IndexChanges:    30,
+						BytesRead:       4 * 1024 * 1024,
+						BytesWritten:    3072,
+						WriteOps:        45,
+					},
+				},
+			},
+			{
+				Name: "edge",
+				Prefixes: []*control.PrefixCost{
+					{
+						Source:      "edge",
+						Datacenter:  "dc2",
+						Destination: "edge",
+					},
+				},
+			},
+		},
+	}
+
+	var buf bytes.Buffer
+	renderCost(&buf, resp)
+
+	exp := "REPLICATOR  PREFIX             ELAPSED  QUERIES/H  INDEX CHANGES/H  READ/H  WRITTEN/H  WRITES/H\n" +
+		"-           global@dc1:global  2h0m0s   120.0      15.0             2.0 MB  1.5 KB     22.5\n" +
+		"edge        edge@dc2:edge      0s       0.0        0.0              0.0 B   0.0 B      0.0\n"
+	if act := buf.String(); act != exp {
+		t.Errorf("\nexp: %#v\nact: %#v", exp, act)
+	}
+}
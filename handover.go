@@ -0,0 +1,215 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: MPL-2.0
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net"
+	"os"
+	"os/exec"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/hashicorp/consul-replicate/replicate"
+	"github.com/hashicorp/consul-template/signals"
+)
+
+const (
+	// handoverEnv is set in the environment of a process started by an
+	// upgrade. Its value lists the names of the listeners handed over, in the
+	// order of their file descriptors.
+	handoverEnv = "CONSUL_REPLICATE_HANDOVER"
+
+	// handoverStateFd is the file descriptor the new process reads the handed
+	// over state from, handoverReadyFd the one it writes to once it is ready to
+	// take over, and handoverListenersFd the first of the listeners.
+	handoverStateFd     = 3
+	handoverReadyFd     = 4
+	handoverListenersFd = 5
+
+	// handoverTimeout is how long the new process has to get ready before the
+	// upgrade is given up.
+	handoverTimeout = time.Minute
+)
+
+// fileListener is a listener whose file descriptor can be handed over.
+type fileListener interface {
+	File() (*os.File, error)
+}
+
+// upgrade starts the binary again with the same arguments, and hands the
+// listeners and the state of the supervisor over to the new process once it is
+// ready to take over. This process keeps replicating if the new one fails to
+// get ready, such as when its configuration is broken.
+func (cli *CLI) upgrade(supervisor *replicate.Supervisor, listeners map[string]net.Listener) error {
+	path, err := os.Executable()
+	if err != nil {
+		return fmt.Errorf("upgrade: %s", err)
+	}
+
+	stateR, stateW, err := os.Pipe()
+	if err != nil {
+		return fmt.Errorf("upgrade: %s", err)
+	}
+	defer stateW.Close()
+	readyR, readyW, err := os.Pipe()
+	if err != nil {
+		stateR.Close()
+		return fmt.Errorf("upgrade: %s", err)
+	}
+	defer readyR.Close()
+
+	// The files of the new process are closed here once it started, or failed
+	// to
+	files := []*os.File{stateR, readyW}
+	defer func() {
+		for _, f := range files {
+			f.Close()
+		}
+	}()
+
+	names := make([]string, 0, len(listeners))
+	for name := range listeners {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		l, ok := listeners[name].(fileListener)
+		if !ok {
+			return fmt.Errorf("upgrade: the %s listener cannot be handed over", name)
+		}
+		f, err := l.File()
+		if err != nil {
+			return fmt.Errorf("upgrade: %s listener: %s", name, err)
+		}
+		files = append(files, f)
+	}
+
+	cmd := exec.Command(path, os.Args[1:]...)
+	cmd.Env = append(os.Environ(), handoverEnv+"="+strings.Join(names, ","))
+	cmd.Stdin, cmd.Stdout, cmd.Stderr = os.Stdin, os.Stdout, os.Stderr
+	cmd.ExtraFiles = files
+	if err := cmd.Start(); err != nil {
+		return fmt.Errorf("upgrade: %s", err)
+	}
+	log.Printf("[INFO] (cli) started %s (pid %d), waiting for it to be ready", path, cmd.Process.Pid)
+
+	// Reading the ready pipe returns an error if the new process exits first,
+	// as it no longer holds the write end
+	for _, f := range files {
+		f.Close()
+	}
+	files = nil
+	readyCh := make(chan error, 1)
+	go func() {
+		_, err := readyR.Read(make([]byte, 1))
+		readyCh <- err
+	}()
+
+	select {
+	case err := <-readyCh:
+		if err != nil {
+			cmd.Process.Kill()
+			cmd.Wait()
+			return fmt.Errorf("upgrade: new process exited before it was ready")
+		}
+	case <-time.After(handoverTimeout):
+		cmd.Process.Kill()
+		cmd.Wait()
+		return fmt.Errorf("upgrade: new process not ready after %s", handoverTimeout)
+	}
+
+	// Past this point the groups are stopped, so the new process replicates
+	// even if it starts fresh
+	state := supervisor.Handover()
+	if err := json.NewEncoder(stateW).Encode(state); err != nil {
+		log.Printf("[WARN] (cli) failed to hand the state over, the new process starts fresh: %s", err)
+
+		// The new process cannot take over the sessions holding the locks, so
+		// they are released instead of expiring once this one exits
+		state.Release()
+	}
+	log.Printf("[INFO] (cli) handed over to pid %d", cmd.Process.Pid)
+	return cmd.Process.Release()
+}
+
+// inheritListeners returns the listeners handed over by the process this one
+// replaces, keyed by name, if it was started by an upgrade.
+func inheritListeners() (map[string]net.Listener, error) {
+	names := os.Getenv(handoverEnv)
+	if names == "" {
+		return nil, nil
+	}
+
+	listeners := make(map[string]net.Listener)
+	for i, name := range strings.Split(names, ",") {
+		f := os.NewFile(uintptr(handoverListenersFd+i), name)
+		l, err := net.FileListener(f)
+		f.Close()
+		if err != nil {
+			for _, l := range listeners {
+				l.Close()
+			}
+			return nil, fmt.Errorf("handover: %s listener: %s", name, err)
+		}
+		listeners[name] = l
+	}
+	return listeners, nil
+}
+
+// completeHandover tells the process this one replaces that it is ready to
+// take over, and returns the state handed over once that process stopped
+// replicating. It returns nil if this process was not started by an upgrade,
+// or if the state cannot be read, in which case this process starts fresh.
+func completeHandover() *replicate.HandoverState {
+	if os.Getenv(handoverEnv) == "" {
+		return nil
+	}
+	os.Unsetenv(handoverEnv)
+
+	stateR := os.NewFile(handoverStateFd, "handover-state")
+	defer stateR.Close()
+	readyW := os.NewFile(handoverReadyFd, "handover-ready")
+	_, err := readyW.Write([]byte{1})
+	readyW.Close()
+	if err != nil {
+		log.Printf("[WARN] (cli) failed to signal the replaced process, starting fresh: %s", err)
+		return nil
+	}
+
+	var state replicate.HandoverState
+	if err := json.NewDecoder(stateR).Decode(&state); err != nil {
+		log.Printf("[WARN] (cli) failed to read the handed over state, starting fresh: %s", err)
+		return nil
+	}
+	log.Printf("[INFO] (cli) took over %d replication group(s)", len(state.Groups))
+	return &state
+}
+
+// checkUpgradeSignal returns an error if the upgrade signal is also one of the
+// other signals, which would take precedence over it.
+func checkUpgradeSignal(c *replicate.Config) error {
+	upgrade := *c.UpgradeSignal
+	if upgrade == signals.SIGNIL {
+		return nil
+	}
+
+	for _, other := range []struct {
+		name string
+		sig  *os.Signal
+	}{
+		{"dump_signal", c.DumpSignal},
+		{"kill_signal", c.KillSignal},
+		{"reload_signal", c.ReloadSignal},
+		{"rotate_signal", c.RotateSignal},
+	} {
+		if other.sig != nil && *other.sig == upgrade {
+			return fmt.Errorf("upgrade_signal %s is also the %s", upgrade, other.name)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,222 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: MPL-2.0
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/hashicorp/consul-replicate/replicate"
+	"github.com/hashicorp/consul-replicate/replicate/replicatetest"
+	"github.com/hashicorp/consul-template/config"
+)
+
+func TestCheckUpgradeSignal(t *testing.T) {
+	cases := []struct {
+		name string
+		c    *replicate.Config
+		err  bool
+	}{
+		{
+			"disabled",
+			&replicate.Config{},
+			false,
+		},
+		{
+			"distinct",
+			&replicate.Config{
+				UpgradeSignal: config.Signal(syscall.SIGUSR2),
+				RotateSignal:  config.Signal(syscall.SIGUSR1),
+				DumpSignal:    config.Signal(syscall.SIGQUIT),
+			},
+			false,
+		},
+		{
+			"default_rotate_signal",
+			&replicate.Config{
+				UpgradeSignal: config.Signal(syscall.SIGUSR2),
+			},
+			true,
+		},
+		{
+			"reload_signal",
+			&replicate.Config{
+				UpgradeSignal: config.Signal(syscall.SIGHUP),
+			},
+			true,
+		},
+	}
+
+	for i, tc := range cases {
+		t.Run(fmt.Sprintf("%d_%s", i, tc.name), func(t *testing.T) {
+			c := replicate.DefaultConfig().Merge(tc.c)
+			c.Finalize()
+
+			err := checkUpgradeSignal(c)
+			if (err != nil) != tc.err {
+				t.Fatal(err)
+			}
+		})
+	}
+}
+
+// handoverChildEnv selects what TestHandoverChild does in the process started
+// by an upgrade: "ready" takes over and writes the state it was handed over to
+// the first connection of the listener, "exit" exits before it is ready.
+const handoverChildEnv = "CONSUL_REPLICATE_TEST_HANDOVER_CHILD"
+
+// TestHandoverChild is not a test, but the process started by the upgrades of
+// TestCLI_upgrade.
+func TestHandoverChild(t *testing.T) {
+	switch os.Getenv(handoverChildEnv) {
+	case "":
+		return
+	case "exit":
+		os.Exit(1)
+	}
+
+	listeners, err := inheritListeners()
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+	state := completeHandover()
+
+	l := listeners["control"].(*net.TCPListener)
+	l.SetDeadline(time.Now().Add(10 * time.Second))
+	conn, err := l.Accept()
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+	json.NewEncoder(conn).Encode(state)
+	conn.Close()
+	os.Exit(0)
+}
+
+func TestCLI_upgrade(t *testing.T) {
+	cases := []struct {
+		name  string
+		child string
+		err   bool
+	}{
+		{
+			"ready",
+			"ready",
+			false,
+		},
+		{
+			"exits_before_ready",
+			"exit",
+			true,
+		},
+	}
+
+	for i, tc := range cases {
+		t.Run(fmt.Sprintf("%d_%s", i, tc.name), func(t *testing.T) {
+			s := replicatetest.NewServer(t)
+			source := s.Consul.Datacenter("dc1")
+			destination := s.Consul.Datacenter(replicatetest.Datacenter)
+			source.Set("global/a", "1")
+
+			supervisor := replicate.NewSupervisor(replicate.TestConfig(replicate.Must(fmt.Sprintf(`
+				consul {
+					address = %q
+				}
+				destination_consul {
+					address = %q
+				}
+				destination {
+					datacenter = %q
+				}
+				wait {
+					min = "0s"
+					max = "0s"
+				}
+				ha {
+					enabled  = true
+					lock_key = "replicate/leader"
+				}
+				prefix {
+					source      = "global"
+					datacenter  = "dc1"
+					destination = "replica"
+				}
+			`, s.Address(), s.Address(), replicatetest.Datacenter))), false)
+			t.Cleanup(supervisor.Stop)
+			go supervisor.Start()
+
+			waitFor(t, func() bool {
+				v, _ := destination.Value("replica/a")
+				return v == "1"
+			})
+			session := s.Holder("replicate/leader")
+
+			l, err := net.Listen("tcp", "127.0.0.1:0")
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer l.Close()
+
+			// The new process runs this test binary again, only for
+			// TestHandoverChild
+			args := os.Args
+			os.Args = []string{args[0], "-test.run=^TestHandoverChild$"}
+			defer func() { os.Args = args }()
+			t.Setenv(handoverChildEnv, tc.child)
+
+			err = NewCLI(io.Discard, io.Discard).upgrade(supervisor, map[string]net.Listener{"control": l})
+			if (err != nil) != tc.err {
+				t.Fatal(err)
+			}
+
+			// Either way, the lock is held by the same session
+			if act := s.Holder("replicate/leader"); act != session {
+				t.Errorf("\nexp: %#v\nact: %#v", session, act)
+			}
+
+			if tc.err {
+				// The old process keeps replicating
+				source.Set("global/b", "2")
+				waitFor(t, func() bool {
+					v, _ := destination.Value("replica/b")
+					return v == "2"
+				})
+				return
+			}
+
+			conn, err := net.Dial("tcp", l.Addr().String())
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer conn.Close()
+			var state replicate.HandoverState
+			if err := json.NewDecoder(conn).Decode(&state); err != nil {
+				t.Fatal(err)
+			}
+			if h := state.Groups[""]; h == nil || h.Session != session {
+				t.Errorf("\nexp: %#v\nact: %#v", session, h)
+			}
+		})
+	}
+}
+
+// waitFor fails the test unless the condition holds within a few seconds.
+func waitFor(t *testing.T, cond func() bool) {
+	t.Helper()
+
+	deadline := time.Now().Add(5 * time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatal("timed out")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
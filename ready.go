@@ -0,0 +1,37 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: MPL-2.0
+
+package main
+
+import (
+	"fmt"
+	"io"
+	"time"
+
+	"github.com/hashicorp/consul-replicate/control"
+)
+
+// readyPollInterval is how often ready asks the instance again while it waits.
+const readyPollInterval = 2 * time.Second
+
+// renderReady writes whether the instance is ready, and every pending prefix,
+// for the ready command.
+func renderReady(w io.Writer, resp *control.ReadyResponse) {
+	if resp.Ready {
+		fmt.Fprintln(w, "ready")
+		return
+	}
+
+	for _, p := range resp.Pending {
+		replicator := "top-level prefixes"
+		if p.Replicator != "" {
+			replicator = fmt.Sprintf("replicator %q", p.Replicator)
+		}
+		if p.Source == "" {
+			fmt.Fprintf(w, "pending: %s not started\n", replicator)
+			continue
+		}
+		fmt.Fprintf(w, "pending: %s@%s:%s (%s)\n", p.Source, p.Datacenter, p.Destination,
+			replicator)
+	}
+}
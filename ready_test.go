@@ -0,0 +1,47 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: MPL-2.0
+
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"testing"
+
+	"github.com/hashicorp/consul-replicate/control"
+)
+
+func TestRenderReady(t *testing.T) {
+	cases := []struct {
+		name string
+		resp *control.ReadyResponse
+		exp  string
+	}{
+		{
+			"ready",
+			&control.ReadyResponse{Ready: true},
+			"ready\n",
+		},
+		{
+			"pending",
+			&control.ReadyResponse{
+				Pending: []*control.PendingPrefix{
+					{Source: "global", Datacenter: "dc1", Destination: "global"},
+					{Replicator: "edge"},
+				},
+			},
+			"pending: global@dc1:global (top-level prefixes)\n" +
+				"pending: replicator \"edge\" not started\n",
+		},
+	}
+
+	for i, tc := range cases {
+		t.Run(fmt.Sprintf("%d_%s", i, tc.name), func(t *testing.T) {
+			var buf bytes.Buffer
+			renderReady(&buf, tc.resp)
+			if act := buf.String(); act != tc.exp {
+				t.Errorf("\nexp: %#v\nact: %#v", tc.exp, act)
+			}
+		})
+	}
+}
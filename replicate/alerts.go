@@ -0,0 +1,366 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: MPL-2.0
+
+package replicate
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"os"
+	"os/exec"
+	"strings"
+	"sync"
+	"text/template"
+	"time"
+
+	"github.com/hashicorp/consul-template/config"
+	multierror "github.com/hashicorp/go-multierror"
+	"github.com/mattn/go-shellwords"
+	"github.com/pkg/errors"
+)
+
+const (
+	// AlertLag, AlertErrors, AlertIdle, AlertLimit and AlertEmpty are the
+	// rules of an Alert.
+	AlertLag    = "lag"
+	AlertErrors = "errors"
+	AlertIdle   = "idle"
+	AlertLimit  = "limit"
+	AlertEmpty  = "empty"
+
+	// AlertFiring and AlertResolved are the statuses of an Alert.
+	AlertFiring   = "firing"
+	AlertResolved = "resolved"
+)
+
+// Alert is sent to the alert command and URL when a rule fires or resolves
+// for a prefix.
+type Alert struct {
+	// Rule is the rule which fired or resolved, and Status whether it did.
+	Rule   string `json:"rule"`
+	Status string `json:"status"`
+
+	// Replicator is the replication group of the prefix, if any.
+	Replicator string `json:"replicator,omitempty"`
+
+	// Source, Datacenter and Destination identify the prefix.
+	Source      string `json:"source"`
+	Datacenter  string `json:"datacenter,omitempty"`
+	Destination string `json:"destination"`
+
+	// Message describes the alert.
+	Message string `json:"message"`
+
+	// Pass is the summary of the last pass of the prefix, if any.
+	Pass string `json:"pass,omitempty"`
+
+	// Time is when the rule was evaluated.
+	Time time.Time `json:"time"`
+}
+
+// alertState is what the rules know of the passes of a prefix.
+type alertState struct {
+	// failingSince is the time of the first failed pass after the last
+	// successful one, and lastKeys the time of the last pass which replicated
+	// keys.
+	failingSince time.Time
+	lastKeys     time.Time
+
+	// errors are the times of the failed passes within the error window.
+	errors []time.Time
+
+	// limit is the error of the last pass refused because the source tree
+	// was over a limit of the prefix, and empty the error of the last pass
+	// refused because the source was empty, until a pass succeeds.
+	limit string
+	empty string
+
+	// pass is the summary of the last pass.
+	pass string
+
+	// firing are the rules which fired and did not resolve yet.
+	firing map[string]bool
+}
+
+// alerter evaluates the alert rules against the passes of every prefix.
+type alerter struct {
+	config *AlertsConfig
+	start  time.Time
+
+	// body is the template of the body POSTed to the URL, or nil to POST the
+	// alert as JSON.
+	body *template.Template
+
+	sync.Mutex
+	prefixes map[string]*alertState
+}
+
+// newAlerter returns the alerter of the alerts config, or nil if alerts are
+// disabled.
+func newAlerter(c *AlertsConfig) (*alerter, error) {
+	if !config.BoolVal(c.Enabled) {
+		return nil, nil
+	}
+	if config.IntVal(c.MaxErrors) < 0 {
+		return nil, fmt.Errorf("alerts: max_errors must be positive, got %d",
+			config.IntVal(c.MaxErrors))
+	}
+	if config.IntVal(c.MaxErrors) == 0 && config.TimeDurationVal(c.MaxIdle) <= 0 &&
+		config.TimeDurationVal(c.MaxLag) <= 0 {
+		return nil, fmt.Errorf("alerts: no rule is set, expected max_errors, " +
+			"max_idle or max_lag")
+	}
+	body, err := parseAlertTemplate(c)
+	if err != nil {
+		return nil, err
+	}
+	return &alerter{config: c, start: time.Now(), body: body, prefixes: make(map[string]*alertState)}, nil
+}
+
+// state returns the state of the prefix with the given id. The caller must
+// hold the lock.
+func (a *alerter) state(id string) *alertState {
+	s, ok := a.prefixes[id]
+	if !ok {
+		s = &alertState{lastKeys: a.start, firing: make(map[string]bool)}
+		a.prefixes[id] = s
+	}
+	return s
+}
+
+// observe records the outcome of a pass of the prefix with the given id.
+func (a *alerter) observe(id string, e *Event) {
+	if a == nil {
+		return
+	}
+	a.Lock()
+	defer a.Unlock()
+
+	s := a.state(id)
+	s.pass = e.Summary()
+	if e.Err != nil {
+		s.errors = append(s.errors, e.Time)
+		if s.failingSince.IsZero() {
+			s.failingSince = e.Time
+		}
+		var limit *limitError
+		if errors.As(e.Err, &limit) {
+			s.limit = limit.Error()
+		}
+		var empty *emptySourceError
+		if errors.As(e.Err, &empty) {
+			s.empty = empty.Error()
+		}
+		return
+	}
+	s.failingSince = time.Time{}
+	s.limit = ""
+	s.empty = ""
+	if e.Updates > 0 || e.Deletes > 0 {
+		s.lastKeys = e.Time
+	}
+}
+
+// evaluate evaluates the rules against every prefix, and returns the alerts
+// which fired or resolved since the last evaluation.
+func (a *alerter) evaluate(now time.Time, prefixes []*PrefixConfig, replicator string) []*Alert {
+	a.Lock()
+	defer a.Unlock()
+
+	maxErrors := config.IntVal(a.config.MaxErrors)
+	maxIdle := config.TimeDurationVal(a.config.MaxIdle)
+	maxLag := config.TimeDurationVal(a.config.MaxLag)
+	window := config.TimeDurationVal(a.config.ErrorWindow)
+
+	var alerts []*Alert
+	for _, prefix := range prefixes {
+		id := prefix.Dependency.String()
+		s := a.state(id)
+
+		errs := s.errors[:0]
+		for _, t := range s.errors {
+			if now.Sub(t) <= window {
+				errs = append(errs, t)
+			}
+		}
+		s.errors = errs
+
+		lag := now.Sub(s.failingSince)
+		idle := now.Sub(s.lastKeys)
+		for _, rule := range []struct {
+			name     string
+			enabled  bool
+			firing   bool
+			message  string
+			resolved string
+		}{
+			{
+				AlertLag,
+				maxLag > 0,
+				!s.failingSince.IsZero() && lag > maxLag,
+				fmt.Sprintf("%s has been behind its source for %s", id, lag.Round(time.Second)),
+				fmt.Sprintf("%s caught up with its source", id),
+			},
+			{
+				AlertErrors,
+				maxErrors > 0,
+				len(s.errors) > maxErrors,
+				fmt.Sprintf("%d passes of %s failed within %s", len(s.errors), id, window),
+				fmt.Sprintf("%d passes of %s failed within %s", len(s.errors), id, window),
+			},
+			{
+				AlertIdle,
+				maxIdle > 0,
+				idle > maxIdle,
+				fmt.Sprintf("%s replicated zero keys for %s", id, idle.Round(time.Second)),
+				fmt.Sprintf("%s replicated keys again", id),
+			},
+			{
+				AlertLimit,
+				true,
+				s.limit != "",
+				s.limit,
+				fmt.Sprintf("%s is within its limits again", id),
+			},
+			{
+				AlertEmpty,
+				true,
+				s.empty != "",
+				s.empty + ", refusing to delete every destination key",
+				fmt.Sprintf("%s was replicated again", id),
+			},
+		} {
+			if !rule.enabled || rule.firing == s.firing[rule.name] {
+				continue
+			}
+			s.firing[rule.name] = rule.firing
+
+			status, message := AlertFiring, rule.message
+			if !rule.firing {
+				status, message = AlertResolved, rule.resolved
+			}
+			alerts = append(alerts, &Alert{
+				Rule:        rule.name,
+				Status:      status,
+				Replicator:  replicator,
+				Source:      config.StringVal(prefix.Source),
+				Datacenter:  config.StringVal(prefix.Datacenter),
+				Destination: config.StringVal(prefix.Destination),
+				Message:     message,
+				Pass:        s.pass,
+				Time:        now.UTC(),
+			})
+		}
+	}
+	return alerts
+}
+
+// notify sends the alert to the alert command and URL.
+func (a *alerter) notify(alert *Alert) error {
+	body, err := json.Marshal(alert)
+	if err != nil {
+		return err
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), config.TimeDurationVal(a.config.Timeout))
+	defer cancel()
+
+	var errs *multierror.Error
+	if command := config.StringVal(a.config.Command); command != "" {
+		if err := runAlertCommand(ctx, command, alert, body); err != nil {
+			errs = multierror.Append(errs, err)
+		}
+	}
+	if url := config.StringVal(a.config.URL); url != "" {
+		if a.body != nil {
+			if body, err = renderAlert(a.body, alert); err != nil {
+				return err
+			}
+		}
+		if err := postAlert(ctx, url, body); err != nil {
+			errs = multierror.Append(errs, err)
+		}
+	}
+	return errs.ErrorOrNil()
+}
+
+// runAlertCommand runs the alert command with the alert on its standard
+// input, and its rule, status and prefix in its environment.
+func runAlertCommand(ctx context.Context, command string, alert *Alert, body []byte) error {
+	args, err := shellwords.Parse(command)
+	if err != nil {
+		return errors.Wrap(err, "alerts: parsing command")
+	}
+	if len(args) == 0 {
+		return fmt.Errorf("alerts: missing command")
+	}
+
+	cmd := exec.CommandContext(ctx, args[0], args[1:]...)
+	cmd.Stdin = bytes.NewReader(body)
+	cmd.Env = append(os.Environ(),
+		"CONSUL_REPLICATE_ALERT="+alert.Rule,
+		"CONSUL_REPLICATE_ALERT_STATUS="+alert.Status,
+		"CONSUL_REPLICATE_SOURCE="+alert.Source,
+		"CONSUL_REPLICATE_DATACENTER="+alert.Datacenter,
+		"CONSUL_REPLICATE_DESTINATION="+alert.Destination,
+	)
+
+	if out, err := cmd.CombinedOutput(); err != nil {
+		return fmt.Errorf("alerts: command: %s: %s", err, strings.TrimSpace(string(out)))
+	}
+	return nil
+}
+
+// postAlert POSTs the alert to the alert URL.
+func postAlert(ctx context.Context, url string, body []byte) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return errors.Wrap(err, "alerts")
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		b, _ := ioutil.ReadAll(resp.Body)
+		return fmt.Errorf("alerts: unexpected response code %d: %s",
+			resp.StatusCode, strings.TrimSpace(string(b)))
+	}
+	return nil
+}
+
+// watchAlerts evaluates the alert rules every interval, and notifies the
+// alerts which fired or resolved. This function blocks until the runner is
+// stopped.
+func (r *Runner) watchAlerts() {
+	ticker := time.NewTicker(config.TimeDurationVal(r.config.Alerts.Interval))
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+		case <-r.stopCh:
+			return
+		}
+
+		for _, alert := range r.alerts.evaluate(time.Now(), r.activePrefixes(), r.replicator) {
+			if alert.Status == AlertFiring {
+				log.Printf("[WARN] (runner) alert %s fired: %s", alert.Rule, alert.Message)
+			} else {
+				log.Printf("[INFO] (runner) alert %s resolved: %s", alert.Rule, alert.Message)
+			}
+			if err := r.alerts.notify(alert); err != nil {
+				log.Printf("[WARN] (runner) could not notify alert %s: %s", alert.Rule, err)
+			}
+		}
+	}
+}
@@ -0,0 +1,110 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: MPL-2.0
+
+package replicate
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"text/template"
+
+	"github.com/hashicorp/consul-template/config"
+	"github.com/pkg/errors"
+)
+
+const (
+	// AlertFormatJSON, AlertFormatSlack and AlertFormatTeams are the formats
+	// of the body of an alert POSTed to the URL.
+	AlertFormatJSON  = "json"
+	AlertFormatSlack = "slack"
+	AlertFormatTeams = "teams"
+)
+
+// alertTemplates are the templates of the bodies of the formats, which render
+// the alert as a message of an incoming webhook.
+var alertTemplates = map[string]string{
+	AlertFormatSlack: `{
+  "text": {{json (printf "%s alert %s: %s" .Rule .Status .Message)}},
+  "attachments": [
+    {
+      "color": "{{if eq .Status "firing"}}danger{{else}}good{{end}}",
+      "fields": [
+        {"title": "Source", "value": {{json .Source}}, "short": true},
+        {"title": "Destination", "value": {{json .Destination}}, "short": true}{{if .Datacenter}},
+        {"title": "Datacenter", "value": {{json .Datacenter}}, "short": true}{{end}}{{if .Replicator}},
+        {"title": "Replicator", "value": {{json .Replicator}}, "short": true}{{end}}{{if .Pass}},
+        {"title": "Last pass", "value": {{json .Pass}}, "short": false}{{end}}
+      ],
+      "ts": {{.Time.Unix}}
+    }
+  ]
+}
+`,
+	AlertFormatTeams: `{
+  "@type": "MessageCard",
+  "@context": "https://schema.org/extensions",
+  "themeColor": "{{if eq .Status "firing"}}D13438{{else}}2EB67D{{end}}",
+  "summary": {{json (printf "%s alert %s" .Rule .Status)}},
+  "title": {{json (printf "%s alert %s" .Rule .Status)}},
+  "text": {{json .Message}},
+  "sections": [
+    {
+      "facts": [
+        {"name": "Source", "value": {{json .Source}}},
+        {"name": "Destination", "value": {{json .Destination}}}{{if .Datacenter}},
+        {"name": "Datacenter", "value": {{json .Datacenter}}}{{end}}{{if .Replicator}},
+        {"name": "Replicator", "value": {{json .Replicator}}}{{end}}{{if .Pass}},
+        {"name": "Last pass", "value": {{json .Pass}}}{{end}},
+        {"name": "Time", "value": {{json .Time}}}
+      ]
+    }
+  ]
+}
+`,
+}
+
+// alertFuncs are the functions of the templates of alerts.
+var alertFuncs = template.FuncMap{
+	// json encodes the value as JSON, such as a quoted string
+	"json": func(v interface{}) (string, error) {
+		b, err := json.Marshal(v)
+		return string(b), err
+	},
+	"lower": strings.ToLower,
+	"upper": strings.ToUpper,
+}
+
+// parseAlertTemplate returns the template of the body POSTed to the URL, which
+// is the template of the alerts config, or else the template of its format.
+// It returns nil for the JSON format.
+func parseAlertTemplate(c *AlertsConfig) (*template.Template, error) {
+	format := config.StringVal(c.Format)
+	text, ok := alertTemplates[format]
+	if !ok && format != AlertFormatJSON {
+		return nil, fmt.Errorf("alerts: unknown format %q, expected %q, %q or %q",
+			format, AlertFormatJSON, AlertFormatSlack, AlertFormatTeams)
+	}
+	if t := config.StringVal(c.Template); t != "" {
+		text = t
+	}
+	if text == "" {
+		return nil, nil
+	}
+
+	t, err := template.New("alert").Funcs(alertFuncs).Parse(text)
+	if err != nil {
+		return nil, errors.Wrap(err, "alerts: parsing template")
+	}
+	return t, nil
+}
+
+// renderAlert renders the body of the alert with the template.
+func renderAlert(t *template.Template, alert *Alert) ([]byte, error) {
+	var b bytes.Buffer
+	if err := t.Execute(&b, alert); err != nil {
+		return nil, errors.Wrap(err, "alerts: rendering template")
+	}
+	return b.Bytes(), nil
+}
@@ -0,0 +1,340 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: MPL-2.0
+
+package replicate
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/hashicorp/consul-template/config"
+)
+
+func TestAlerter_evaluate(t *testing.T) {
+	prefix, err := ParsePrefixConfig("global@dc1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	prefix.Finalize()
+	id := prefix.Dependency.String()
+
+	failed := func(at time.Duration) *Event {
+		return &Event{Err: errors.New("boom"), Time: time.Unix(0, 0).Add(at)}
+	}
+	replicated := func(at time.Duration, updates int) *Event {
+		return &Event{Updates: updates, Time: time.Unix(0, 0).Add(at)}
+	}
+
+	// Every step observes its events, then evaluates the rules at its time
+	type step struct {
+		events []*Event
+		at     time.Duration
+		exp    []string
+	}
+
+	cases := []struct {
+		name  string
+		c     *AlertsConfig
+		steps []step
+	}{
+		{
+			"lag",
+			&AlertsConfig{MaxLag: config.TimeDuration(time.Minute)},
+			[]step{
+				{[]*Event{failed(0)}, 30 * time.Second, nil},
+				{[]*Event{failed(time.Minute)}, 2 * time.Minute, []string{"lag:firing"}},
+				{nil, 3 * time.Minute, nil},
+				{[]*Event{replicated(3*time.Minute, 0)}, 4 * time.Minute, []string{"lag:resolved"}},
+			},
+		},
+		{
+			"errors",
+			&AlertsConfig{
+				ErrorWindow: config.TimeDuration(time.Minute),
+				MaxErrors:   config.Int(1),
+			},
+			[]step{
+				{[]*Event{failed(0)}, 0, nil},
+				{[]*Event{failed(10 * time.Second)}, 20 * time.Second, []string{"errors:firing"}},
+				{nil, 65 * time.Second, []string{"errors:resolved"}},
+			},
+		},
+		{
+			"idle",
+			&AlertsConfig{MaxIdle: config.TimeDuration(time.Minute)},
+			[]step{
+				{[]*Event{replicated(0, 1)}, 30 * time.Second, nil},
+				{[]*Event{replicated(45*time.Second, 0)}, 2 * time.Minute, []string{"idle:firing"}},
+				{[]*Event{replicated(3*time.Minute, 2)}, 3 * time.Minute, []string{"idle:resolved"}},
+			},
+		},
+		{
+			"limit",
+			&AlertsConfig{MaxLag: config.TimeDuration(time.Hour)},
+			[]step{
+				{[]*Event{failed(0)}, 0, nil},
+				{[]*Event{{Err: &limitError{"over max_keys"}, Time: time.Unix(10, 0)}}, 10 * time.Second, []string{"limit:firing"}},
+				{[]*Event{failed(20 * time.Second)}, 20 * time.Second, nil},
+				{[]*Event{replicated(30*time.Second, 0)}, 30 * time.Second, []string{"limit:resolved"}},
+			},
+		},
+		{
+			"empty",
+			&AlertsConfig{MaxLag: config.TimeDuration(time.Hour)},
+			[]step{
+				{[]*Event{{Err: &emptySourceError{"source prefix is empty"}, Time: time.Unix(10, 0)}}, 10 * time.Second, []string{"empty:firing"}},
+				{[]*Event{failed(20 * time.Second)}, 20 * time.Second, nil},
+				{[]*Event{replicated(30*time.Second, 0)}, 30 * time.Second, []string{"empty:resolved"}},
+			},
+		},
+	}
+
+	for i, tc := range cases {
+		t.Run(fmt.Sprintf("%d_%s", i, tc.name), func(t *testing.T) {
+			tc.c.Command = config.String("true")
+			tc.c.Finalize()
+			a, err := newAlerter(tc.c)
+			if err != nil {
+				t.Fatal(err)
+			}
+			a.start = time.Unix(0, 0)
+
+			for j, s := range tc.steps {
+				for _, e := range s.events {
+					a.observe(id, e)
+				}
+
+				var act []string
+				for _, alert := range a.evaluate(time.Unix(0, 0).Add(s.at), []*PrefixConfig{prefix}, "") {
+					act = append(act, alert.Rule+":"+alert.Status)
+				}
+				if !reflect.DeepEqual(s.exp, act) {
+					t.Errorf("step %d\nexp: %#v\nact: %#v", j, s.exp, act)
+				}
+			}
+		})
+	}
+}
+
+func TestNewAlerter(t *testing.T) {
+	cases := []struct {
+		name string
+		c    *AlertsConfig
+		nil  bool
+		err  bool
+	}{
+		{
+			"disabled",
+			&AlertsConfig{MaxLag: config.TimeDuration(time.Minute)},
+			true,
+			false,
+		},
+		{
+			"enabled",
+			&AlertsConfig{URL: config.String("http://alerts"), MaxIdle: config.TimeDuration(time.Hour)},
+			false,
+			false,
+		},
+		{
+			"no_rule",
+			&AlertsConfig{URL: config.String("http://alerts")},
+			true,
+			true,
+		},
+		{
+			"negative_max_errors",
+			&AlertsConfig{URL: config.String("http://alerts"), MaxErrors: config.Int(-1)},
+			true,
+			true,
+		},
+		{
+			"slack",
+			&AlertsConfig{URL: config.String("http://alerts"), MaxLag: config.TimeDuration(time.Minute),
+				Format: config.String("slack")},
+			false,
+			false,
+		},
+		{
+			"unknown_format",
+			&AlertsConfig{URL: config.String("http://alerts"), MaxLag: config.TimeDuration(time.Minute),
+				Format: config.String("pagerduty")},
+			true,
+			true,
+		},
+		{
+			"invalid_template",
+			&AlertsConfig{URL: config.String("http://alerts"), MaxLag: config.TimeDuration(time.Minute),
+				Template: config.String("{{.Rule")},
+			true,
+			true,
+		},
+	}
+
+	for i, tc := range cases {
+		t.Run(fmt.Sprintf("%d_%s", i, tc.name), func(t *testing.T) {
+			tc.c.Finalize()
+			a, err := newAlerter(tc.c)
+			if (err != nil) != tc.err {
+				t.Fatal(err)
+			}
+			if (a == nil) != tc.nil {
+				t.Errorf("\nexp: %#v\nact: %#v", tc.nil, a == nil)
+			}
+		})
+	}
+}
+
+func TestAlerter_notify(t *testing.T) {
+	var posted Alert
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if err := json.NewDecoder(r.Body).Decode(&posted); err != nil {
+			t.Error(err)
+		}
+	}))
+	defer srv.Close()
+
+	path := filepath.Join(t.TempDir(), "alert")
+	c := &AlertsConfig{
+		Command: config.String(fmt.Sprintf("tee %q", path)),
+		MaxLag:  config.TimeDuration(time.Minute),
+		URL:     config.String(srv.URL),
+	}
+	c.Finalize()
+	a, err := newAlerter(c)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	exp := Alert{
+		Rule:        AlertLag,
+		Status:      AlertFiring,
+		Source:      "global",
+		Datacenter:  "dc1",
+		Destination: "global",
+		Message:     "global@dc1 has been behind its source for 2m0s",
+		Time:        time.Unix(120, 0).UTC(),
+	}
+	if err := a.notify(&exp); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(exp, posted) {
+		t.Errorf("\nexp: %#v\nact: %#v", exp, posted)
+	}
+
+	b, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var act Alert
+	if err := json.Unmarshal(b, &act); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(exp, act) {
+		t.Errorf("\nexp: %#v\nact: %#v", exp, act)
+	}
+}
+
+func TestRenderAlert(t *testing.T) {
+	alert := &Alert{
+		Rule:        AlertLag,
+		Status:      AlertFiring,
+		Replicator:  "east",
+		Source:      "global",
+		Datacenter:  "dc1",
+		Destination: "global",
+		Message:     `global@dc1 has been behind its source for 2m0s`,
+		Pass:        `source="global" datacenter="dc1" destination="global" status=failure`,
+		Time:        time.Unix(120, 0).UTC(),
+	}
+
+	cases := []struct {
+		name string
+		c    *AlertsConfig
+		exp  map[string]interface{}
+	}{
+		{
+			"slack",
+			&AlertsConfig{Format: config.String(AlertFormatSlack)},
+			map[string]interface{}{
+				"text": "lag alert firing: global@dc1 has been behind its source for 2m0s",
+				"attachments": []interface{}{
+					map[string]interface{}{
+						"color": "danger",
+						"fields": []interface{}{
+							map[string]interface{}{"title": "Source", "value": "global", "short": true},
+							map[string]interface{}{"title": "Destination", "value": "global", "short": true},
+							map[string]interface{}{"title": "Datacenter", "value": "dc1", "short": true},
+							map[string]interface{}{"title": "Replicator", "value": "east", "short": true},
+							map[string]interface{}{"title": "Last pass", "value": alert.Pass, "short": false},
+						},
+						"ts": float64(120),
+					},
+				},
+			},
+		},
+		{
+			"teams",
+			&AlertsConfig{Format: config.String(AlertFormatTeams)},
+			map[string]interface{}{
+				"@type":      "MessageCard",
+				"@context":   "https://schema.org/extensions",
+				"themeColor": "D13438",
+				"summary":    "lag alert firing",
+				"title":      "lag alert firing",
+				"text":       "global@dc1 has been behind its source for 2m0s",
+				"sections": []interface{}{
+					map[string]interface{}{
+						"facts": []interface{}{
+							map[string]interface{}{"name": "Source", "value": "global"},
+							map[string]interface{}{"name": "Destination", "value": "global"},
+							map[string]interface{}{"name": "Datacenter", "value": "dc1"},
+							map[string]interface{}{"name": "Replicator", "value": "east"},
+							map[string]interface{}{"name": "Last pass", "value": alert.Pass},
+							map[string]interface{}{"name": "Time", "value": "1970-01-01T00:02:00Z"},
+						},
+					},
+				},
+			},
+		},
+		{
+			"template",
+			&AlertsConfig{
+				Format:   config.String(AlertFormatSlack),
+				Template: config.String(`{"content": {{json (printf "%s %s" (upper .Status) .Message)}}}`),
+			},
+			map[string]interface{}{
+				"content": "FIRING global@dc1 has been behind its source for 2m0s",
+			},
+		},
+	}
+
+	for i, tc := range cases {
+		t.Run(fmt.Sprintf("%d_%s", i, tc.name), func(t *testing.T) {
+			tc.c.Finalize()
+			tmpl, err := parseAlertTemplate(tc.c)
+			if err != nil {
+				t.Fatal(err)
+			}
+			b, err := renderAlert(tmpl, alert)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			var act map[string]interface{}
+			if err := json.Unmarshal(b, &act); err != nil {
+				t.Fatalf("%s: %s", err, b)
+			}
+			if !reflect.DeepEqual(tc.exp, act) {
+				t.Errorf("\nexp: %#v\nact: %#v", tc.exp, act)
+			}
+		})
+	}
+}
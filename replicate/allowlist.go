@@ -0,0 +1,174 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: MPL-2.0
+
+package replicate
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	"github.com/hashicorp/consul-template/config"
+	"github.com/hashicorp/consul/api"
+)
+
+// allowListRetryInterval is how long to wait before watching the allow list
+// again after a failed read.
+const allowListRetryInterval = 5 * time.Second
+
+// emptyAllowListError is the error of an allow list without keys, unless an
+// empty allow list is allowed.
+type emptyAllowListError struct {
+	path string
+}
+
+func (e *emptyAllowListError) Error() string {
+	return fmt.Sprintf("allow list %q is empty, which holds replication back "+
+		"unless allow_empty is set", e.path)
+}
+
+// allowListEnabled returns true if only the keys of the allow list are
+// replicated.
+func (r *Runner) allowListEnabled() bool {
+	return config.BoolVal(r.config.AllowList.Enabled)
+}
+
+// allowListPath returns the folder of the allow list, without a trailing
+// slash.
+func (r *Runner) allowListPath() string {
+	return strings.TrimRight(config.StringVal(r.config.AllowList.Path), "/")
+}
+
+// readAllowList returns the source keys of the allow list and its index. It
+// blocks while the index is waitIndex, until the wait of the destination
+// expires. An empty allow list is an error unless it is allowed, as a folder
+// which was deleted, or which the ACLs filter out of the listing, looks empty
+// too, and would delete the destination copies of every key.
+func (r *Runner) readAllowList(waitIndex uint64) (map[string]struct{}, uint64, error) {
+	path := r.allowListPath() + "/"
+	opts := &api.QueryOptions{WaitIndex: waitIndex}
+	keys, meta, err := r.destinationClients.Consul().KV().Keys(path, "", opts.WithContext(r.ctx))
+	if err != nil {
+		return nil, 0, fmt.Errorf("failed to read allow list %q: %s", path, err)
+	}
+	allowed := allowListKeys(path, keys)
+	if len(allowed) == 0 && !config.BoolVal(r.config.AllowList.AllowEmpty) {
+		return nil, meta.LastIndex, &emptyAllowListError{path: path}
+	}
+	return allowed, meta.LastIndex, nil
+}
+
+// allowListKeys returns the source keys allowed by the keys of the allow list
+// under the path. Folder keys allow nothing.
+func allowListKeys(path string, keys []string) map[string]struct{} {
+	allowed := make(map[string]struct{}, len(keys))
+	for _, key := range keys {
+		if strings.HasSuffix(key, "/") {
+			continue
+		}
+		if source := strings.TrimPrefix(key, path); source != "" {
+			allowed[source] = struct{}{}
+		}
+	}
+	return allowed
+}
+
+// allowedKeys returns the source keys of the allow list, which is read if it
+// was not yet, or nil if the allow list is disabled.
+func (r *Runner) allowedKeys() (map[string]struct{}, error) {
+	if !r.allowListEnabled() {
+		return nil, nil
+	}
+
+	r.allowedLock.RLock()
+	allowed := r.allowed
+	r.allowedLock.RUnlock()
+	if allowed != nil {
+		return allowed, nil
+	}
+
+	allowed, _, err := r.readAllowList(0)
+	if err != nil {
+		return nil, err
+	}
+	r.allowedLock.Lock()
+	if r.allowed == nil {
+		r.allowed = allowed
+	}
+	allowed = r.allowed
+	r.allowedLock.Unlock()
+	return allowed, nil
+}
+
+// watchAllowList reads the allow list every time it changes, until the runner
+// is stopped. Every key is replicated again after a change, so newly allowed
+// keys are written even if they were not modified since the last pass, and the
+// copies of keys no longer allowed are deleted. While the allow list is empty
+// and must not be, passes fail.
+func (r *Runner) watchAllowList() {
+	path := r.allowListPath() + "/"
+	log.Printf("[DEBUG] (runner) watching allow list %q", path)
+
+	var index uint64
+	var held bool
+	for {
+		allowed, next, err := r.readAllowList(index)
+		select {
+		case <-r.stopCh:
+			return
+		default:
+		}
+
+		var empty *emptyAllowListError
+		if errors.As(err, &empty) {
+			log.Printf("[ERR] (runner) %s", err)
+			r.allowedLock.Lock()
+			r.allowed = nil
+			r.allowedLock.Unlock()
+			held, index = true, next
+			continue
+		}
+		if err != nil {
+			log.Printf("[WARN] (runner) %s", err)
+			select {
+			case <-time.After(allowListRetryInterval):
+			case <-r.stopCh:
+				return
+			}
+			continue
+		}
+
+		// Like a blocking query, an index going backwards starts over
+		if next < index {
+			index = 0
+			continue
+		}
+
+		r.allowedLock.Lock()
+		changed := held || (r.allowed != nil && !sameKeys(r.allowed, allowed))
+		r.allowed = allowed
+		r.allowedLock.Unlock()
+		held = false
+		if changed {
+			log.Printf("[INFO] (runner) allow list %q changed to %d key(s), resyncing",
+				path, len(allowed))
+			r.Resync()
+		}
+		index = next
+	}
+}
+
+// sameKeys returns true if both sets hold the same keys.
+func sameKeys(a, b map[string]struct{}) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for key := range a {
+		if _, ok := b[key]; !ok {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,55 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: MPL-2.0
+
+package replicate
+
+import (
+	"fmt"
+	"reflect"
+	"testing"
+)
+
+func TestAllowListKeys(t *testing.T) {
+	cases := []struct {
+		name string
+		keys []string
+		exp  map[string]struct{}
+	}{
+		{
+			"empty",
+			nil,
+			map[string]struct{}{},
+		},
+		{
+			"keys",
+			[]string{
+				"security/allow/global/a",
+				"security/allow/service/web/port",
+			},
+			map[string]struct{}{
+				"global/a":         {},
+				"service/web/port": {},
+			},
+		},
+		{
+			"folders",
+			[]string{
+				"security/allow/",
+				"security/allow/service/",
+				"security/allow/service/web",
+			},
+			map[string]struct{}{
+				"service/web": {},
+			},
+		},
+	}
+
+	for i, tc := range cases {
+		t.Run(fmt.Sprintf("%d_%s", i, tc.name), func(t *testing.T) {
+			act := allowListKeys("security/allow/", tc.keys)
+			if !reflect.DeepEqual(tc.exp, act) {
+				t.Errorf("\nexp: %#v\nact: %#v", tc.exp, act)
+			}
+		})
+	}
+}
@@ -0,0 +1,126 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: MPL-2.0
+
+package replicate
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"path"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/hashicorp/consul/api"
+	multierror "github.com/hashicorp/go-multierror"
+	"github.com/pkg/errors"
+)
+
+const (
+	// archiveTimeFormat is the format of the time which starts the name of an
+	// archived document, so documents sort in the order they were written.
+	archiveTimeFormat = "20060102T150405.000Z"
+
+	// archivePruneInterval is the minimum time between two removals of the
+	// expired documents of an archive.
+	archivePruneInterval = time.Minute
+)
+
+// archive keeps documents, such as the diffs of passes, in a folder on disk
+// and a folder of the destination Consul until they expire.
+type archive struct {
+	// dir and consulPath are the folders of the documents, without a
+	// trailing slash, if they are written there.
+	dir, consulPath string
+
+	// retention is the amount of time a document is kept.
+	retention time.Duration
+
+	// pruned is the last time the expired documents were removed.
+	sync.Mutex
+	pruned time.Time
+}
+
+// archiveName returns the name of the document of the prefix written at the
+// given time.
+func (r *Runner) archiveName(prefix *PrefixConfig, t time.Time, ext string) string {
+	return fmt.Sprintf("%s-%s%s", t.UTC().Format(archiveTimeFormat), path.Base(r.statusPath(prefix)), ext)
+}
+
+// writeArchive writes the document into the folders of the archive, then
+// removes its expired documents, at most once every prune interval.
+func (r *Runner) writeArchive(a *archive, name string, enc []byte) error {
+	var errs *multierror.Error
+	if a.dir != "" {
+		if err := os.MkdirAll(a.dir, 0o700); err != nil {
+			errs = multierror.Append(errs, err)
+		} else if err := os.WriteFile(filepath.Join(a.dir, name), enc, 0o600); err != nil {
+			errs = multierror.Append(errs, err)
+		}
+	}
+	if a.consulPath != "" {
+		if err := r.backends[BackendConsul].Put(&api.KVPair{Key: a.consulPath + "/" + name, Value: enc}); err != nil {
+			errs = multierror.Append(errs, errors.Wrapf(err, "writing %q", a.consulPath+"/"+name))
+		}
+	}
+
+	r.pruneArchive(a, time.Now())
+	return errs.ErrorOrNil()
+}
+
+// archiveExpired returns true if the document with the given name is older
+// than the retention.
+func archiveExpired(name string, now time.Time, retention time.Duration) bool {
+	if len(name) < len(archiveTimeFormat) {
+		return false
+	}
+	t, err := time.Parse(archiveTimeFormat, name[:len(archiveTimeFormat)])
+	if err != nil {
+		return false
+	}
+	return now.Sub(t) > retention
+}
+
+// pruneArchive removes the documents of the archive older than its
+// retention, at most once every prune interval. Failures are logged.
+func (r *Runner) pruneArchive(a *archive, now time.Time) {
+	a.Lock()
+	if now.Sub(a.pruned) < archivePruneInterval {
+		a.Unlock()
+		return
+	}
+	a.pruned = now
+	a.Unlock()
+
+	if a.dir != "" {
+		entries, err := os.ReadDir(a.dir)
+		if err != nil {
+			log.Printf("[WARN] (runner) failed to list %q: %s", a.dir, err)
+		}
+		for _, entry := range entries {
+			if entry.IsDir() || !archiveExpired(entry.Name(), now, a.retention) {
+				continue
+			}
+			if err := os.Remove(filepath.Join(a.dir, entry.Name())); err != nil {
+				log.Printf("[WARN] (runner) failed to remove %q: %s", entry.Name(), err)
+			}
+		}
+	}
+
+	if a.consulPath != "" {
+		backend := r.backends[BackendConsul]
+		keys, err := backend.Keys(a.consulPath + "/")
+		if err != nil {
+			log.Printf("[WARN] (runner) failed to list %q: %s", a.consulPath, err)
+		}
+		for _, key := range keys {
+			if !archiveExpired(path.Base(key), now, a.retention) {
+				continue
+			}
+			if err := backend.Delete(key); err != nil {
+				log.Printf("[WARN] (runner) failed to remove %q: %s", key, err)
+			}
+		}
+	}
+}
@@ -0,0 +1,33 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: MPL-2.0
+
+package replicate
+
+import (
+	"fmt"
+	"testing"
+	"time"
+)
+
+func TestArchiveExpired(t *testing.T) {
+	now := time.Date(2026, 10, 16, 14, 32, 0, 0, time.UTC)
+
+	cases := []struct {
+		name string
+		file string
+		exp  bool
+	}{
+		{"expired", "20261014T143159.999Z-6f0e4e0c.json", true},
+		{"kept", "20261015T143200.000Z-6f0e4e0c.json", false},
+		{"not_a_diff", "notes.txt", false},
+		{"invalid_time", "2026101XT143200.000Z-6f0e4e0c.json", false},
+	}
+
+	for i, tc := range cases {
+		t.Run(fmt.Sprintf("%d_%s", i, tc.name), func(t *testing.T) {
+			if act := archiveExpired(tc.file, now, 48*time.Hour); act != tc.exp {
+				t.Errorf("\nexp: %#v\nact: %#v", tc.exp, act)
+			}
+		})
+	}
+}
@@ -0,0 +1,246 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: MPL-2.0
+
+package replicate
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/hashicorp/consul/api"
+)
+
+// Backend is a destination that replicated key-value pairs are written into.
+// Keys are always given as full destination paths.
+type Backend interface {
+	// Get returns the pair at the given key, or nil if it does not exist.
+	Get(key string) (*api.KVPair, error)
+
+	// Keys returns the list of keys under the given prefix.
+	Keys(prefix string) ([]string, error)
+
+	// Put writes the given pair.
+	Put(pair *api.KVPair) error
+
+	// Delete removes the given key.
+	Delete(key string) error
+}
+
+// contextBackend is a Backend whose requests can be bound to a context, so they
+// are aborted when it is cancelled.
+type contextBackend interface {
+	Backend
+
+	// withContext returns a copy of the backend bound to the context.
+	withContext(ctx context.Context) Backend
+}
+
+// bindContext returns the backend bound to the context, if it supports it.
+func bindContext(backend Backend, ctx context.Context) Backend {
+	if b, ok := backend.(contextBackend); ok {
+		return b.withContext(ctx)
+	}
+	return backend
+}
+
+// staleBackend is a Backend whose reads can be served by any server, instead of
+// only the leader.
+type staleBackend interface {
+	Backend
+
+	// withStale returns a copy of the backend whose reads are served by any
+	// server whose last contact with the leader is within maxStale.
+	withStale(maxStale time.Duration) Backend
+}
+
+// allowStale returns the backend reading stale data, if it supports it.
+func allowStale(backend Backend, maxStale time.Duration) Backend {
+	if b, ok := backend.(staleBackend); ok {
+		return b.withStale(maxStale)
+	}
+	return backend
+}
+
+// listBackend is a Backend whose changes can be watched.
+type listBackend interface {
+	Backend
+
+	// list returns the pairs under the prefix and their index. It blocks
+	// while the index is waitIndex, until the wait of the store expires.
+	list(prefix string, waitIndex uint64) ([]*api.KVPair, uint64, error)
+}
+
+// casBackend is a Backend which writes a key only if it was not modified since
+// it was read.
+type casBackend interface {
+	Backend
+
+	// cas writes the pair if the key is at the ModifyIndex of the pair, or
+	// does not exist if it is zero, and returns false otherwise.
+	cas(pair *api.KVPair) (bool, error)
+}
+
+// putCAS writes the pair only if the key is at the ModifyIndex of the pair, if
+// the backend supports it, or writes it anyway. It returns false if the key
+// was modified.
+func putCAS(backend Backend, pair *api.KVPair) (bool, error) {
+	if b, ok := backend.(casBackend); ok {
+		return b.cas(pair)
+	}
+	return true, backend.Put(pair)
+}
+
+// consulBackend is a Backend that writes to the KV store of a Consul cluster.
+// Requests use the token of the client, unless the backend has its own, and go
+// to the datacenter of the agent, unless the backend has one.
+type consulBackend struct {
+	kv         *api.KV
+	txn        *api.Txn
+	token      string
+	partition  string
+	datacenter string
+	ctx        context.Context
+
+	// maxStale lets any server answer reads, as long as its last contact with
+	// the leader is within it. Zero reads from the leader.
+	maxStale time.Duration
+}
+
+func newConsulBackend(client *api.Client) *consulBackend {
+	return &consulBackend{kv: client.KV(), txn: client.Txn()}
+}
+
+// withToken returns a copy of the backend which uses the given token.
+func (b *consulBackend) withToken(token string) *consulBackend {
+	c := *b
+	c.token = token
+	return &c
+}
+
+// inPartition returns a copy of the backend which writes into the given admin
+// partition.
+func (b *consulBackend) inPartition(partition string) *consulBackend {
+	c := *b
+	c.partition = partition
+	return &c
+}
+
+// inDatacenter returns a copy of the backend which writes into the given
+// datacenter.
+func (b *consulBackend) inDatacenter(dc string) *consulBackend {
+	c := *b
+	c.datacenter = dc
+	return &c
+}
+
+func (b *consulBackend) withContext(ctx context.Context) Backend {
+	c := *b
+	c.ctx = ctx
+	return &c
+}
+
+func (b *consulBackend) withStale(maxStale time.Duration) Backend {
+	c := *b
+	c.maxStale = maxStale
+	return &c
+}
+
+// queryOptions returns the options of a read with the token, partition,
+// datacenter and context of the backend.
+func (b *consulBackend) queryOptions() *api.QueryOptions {
+	q := &api.QueryOptions{Token: b.token, Datacenter: b.datacenter, AllowStale: b.maxStale > 0}
+	if ctx := withPartition(b.ctx, b.partition); ctx != nil {
+		q = q.WithContext(ctx)
+	}
+	return q
+}
+
+// writeOptions returns the options of a write with the token, partition,
+// datacenter and context of the backend.
+func (b *consulBackend) writeOptions() *api.WriteOptions {
+	w := &api.WriteOptions{Token: b.token, Datacenter: b.datacenter}
+	if ctx := withPartition(b.ctx, b.partition); ctx != nil {
+		w = w.WithContext(ctx)
+	}
+	return w
+}
+
+// Get reads the key again from the leader if the server which answered is too
+// far behind it, and Keys likewise.
+func (b *consulBackend) Get(key string) (*api.KVPair, error) {
+	q := b.queryOptions()
+	pair, meta, err := b.kv.Get(key, q)
+	if err == nil && q.AllowStale && meta.LastContact > b.maxStale {
+		q.AllowStale = false
+		pair, _, err = b.kv.Get(key, q)
+	}
+	return pair, err
+}
+
+func (b *consulBackend) Keys(prefix string) ([]string, error) {
+	q := b.queryOptions()
+	keys, meta, err := b.kv.Keys(prefix, "", q)
+	if err == nil && q.AllowStale && meta.LastContact > b.maxStale {
+		q.AllowStale = false
+		keys, _, err = b.kv.Keys(prefix, "", q)
+	}
+	return keys, err
+}
+
+func (b *consulBackend) list(prefix string, waitIndex uint64) ([]*api.KVPair, uint64, error) {
+	q := b.queryOptions()
+	q.WaitIndex = waitIndex
+	pairs, meta, err := b.kv.List(prefix, q)
+	if err != nil {
+		return nil, 0, err
+	}
+	return pairs, meta.LastIndex, nil
+}
+
+func (b *consulBackend) Put(pair *api.KVPair) error {
+	_, err := b.kv.Put(pair, b.writeOptions())
+	return err
+}
+
+func (b *consulBackend) cas(pair *api.KVPair) (bool, error) {
+	ok, _, err := b.kv.CAS(pair, b.writeOptions())
+	return ok, err
+}
+
+func (b *consulBackend) Delete(key string) error {
+	_, err := b.kv.Delete(key, b.writeOptions())
+	return err
+}
+
+func (b *consulBackend) Txn(puts []*api.KVPair, deletes []string) error {
+	ops := make(api.TxnOps, 0, len(puts)+len(deletes))
+	for _, pair := range puts {
+		ops = append(ops, &api.TxnOp{KV: &api.KVTxnOp{
+			Verb:  api.KVSet,
+			Key:   pair.Key,
+			Flags: pair.Flags,
+			Value: pair.Value,
+		}})
+	}
+	for _, key := range deletes {
+		ops = append(ops, &api.TxnOp{KV: &api.KVTxnOp{
+			Verb: api.KVDelete,
+			Key:  key,
+		}})
+	}
+
+	ok, resp, _, err := b.txn.Txn(ops, b.queryOptions())
+	if err != nil {
+		return err
+	}
+	if !ok {
+		var errs []string
+		for _, e := range resp.Errors {
+			errs = append(errs, e.What)
+		}
+		return fmt.Errorf("transaction rolled back: %s", strings.Join(errs, ", "))
+	}
+	return nil
+}
@@ -0,0 +1,470 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: MPL-2.0
+
+package replicate
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"hash/fnv"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"regexp"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/hashicorp/consul-template/config"
+	"github.com/hashicorp/consul/api"
+	"github.com/pkg/errors"
+)
+
+const (
+	// kubernetesManagedByLabel is the label set on every object written by the
+	// Kubernetes backend, used to find them again when listing.
+	kubernetesManagedByLabel = "app.kubernetes.io/managed-by"
+
+	// kubernetesPathAnnotation and kubernetesKeysAnnotation record the Consul
+	// path an object was materialized from and the mapping of data keys back to
+	// full Consul keys, since object names and data keys must be sanitized.
+	kubernetesPathAnnotation = "consul-replicate.hashicorp.com/path"
+	kubernetesKeysAnnotation = "consul-replicate.hashicorp.com/keys"
+)
+
+var (
+	// kubernetesInvalidNameRe and kubernetesInvalidDataKeyRe match characters
+	// that are not permitted in object names and data keys respectively.
+	kubernetesInvalidNameRe    = regexp.MustCompile(`[^a-z0-9.-]+`)
+	kubernetesInvalidDataKeyRe = regexp.MustCompile(`[^-._a-zA-Z0-9]+`)
+)
+
+// kubernetesObject is the subset of a ConfigMap or Secret that the backend
+// reads and writes. Values in Data and BinaryData are kept in their wire
+// format.
+type kubernetesObject struct {
+	APIVersion string                   `json:"apiVersion"`
+	Kind       string                   `json:"kind"`
+	Metadata   kubernetesObjectMetadata `json:"metadata"`
+	Type       string                   `json:"type,omitempty"`
+	Data       map[string]string        `json:"data,omitempty"`
+	BinaryData map[string]string        `json:"binaryData,omitempty"`
+}
+
+type kubernetesObjectMetadata struct {
+	Name            string            `json:"name"`
+	Namespace       string            `json:"namespace,omitempty"`
+	ResourceVersion string            `json:"resourceVersion,omitempty"`
+	Labels          map[string]string `json:"labels,omitempty"`
+	Annotations     map[string]string `json:"annotations,omitempty"`
+}
+
+type kubernetesObjectList struct {
+	Items []*kubernetesObject `json:"items"`
+}
+
+// kubernetesBackend is a Backend that materializes keys into ConfigMaps or
+// Secrets in a Kubernetes namespace.
+type kubernetesBackend struct {
+	address   string
+	namespace string
+	kind      string
+	mode      string
+	tokenFile string
+	userAgent string
+
+	client *http.Client
+	ctx    context.Context
+}
+
+// newKubernetesBackend creates a new Kubernetes backend from the given config.
+func newKubernetesBackend(c *KubernetesConfig) (*kubernetesBackend, error) {
+	address := strings.TrimRight(config.StringVal(c.Address), "/")
+	if address == "" {
+		return nil, fmt.Errorf("kubernetes: missing address")
+	}
+
+	kind := strings.ToLower(config.StringVal(c.Kind))
+	switch kind {
+	case KubernetesKindConfigMap, KubernetesKindSecret:
+	default:
+		return nil, fmt.Errorf("kubernetes: invalid kind %q", kind)
+	}
+
+	mode := strings.ToLower(config.StringVal(c.Mode))
+	switch mode {
+	case KubernetesModeSubtree, KubernetesModeKey:
+	default:
+		return nil, fmt.Errorf("kubernetes: invalid mode %q", mode)
+	}
+
+	tlsConfig := &tls.Config{}
+	if ca := config.StringVal(c.CaCert); ca != "" {
+		pem, err := os.ReadFile(ca)
+		if err != nil && !os.IsNotExist(err) {
+			return nil, errors.Wrap(err, "kubernetes: reading ca_cert")
+		}
+		if len(pem) > 0 {
+			pool := x509.NewCertPool()
+			if !pool.AppendCertsFromPEM(pem) {
+				return nil, fmt.Errorf("kubernetes: no certificates found in %q", ca)
+			}
+			tlsConfig.RootCAs = pool
+		}
+	}
+
+	return &kubernetesBackend{
+		address:   address,
+		namespace: strings.TrimSpace(config.StringVal(c.Namespace)),
+		kind:      kind,
+		mode:      mode,
+		tokenFile: config.StringVal(c.TokenFile),
+		client: &http.Client{
+			Timeout: 30 * time.Second,
+			Transport: &http.Transport{
+				Proxy:           http.ProxyFromEnvironment,
+				TLSClientConfig: tlsConfig,
+			},
+		},
+	}, nil
+}
+
+func (b *kubernetesBackend) withContext(ctx context.Context) Backend {
+	c := *b
+	c.ctx = ctx
+	return &c
+}
+
+func (b *kubernetesBackend) Get(key string) (*api.KVPair, error) {
+	objPath, dataKey := b.locate(key)
+	if dataKey == "" {
+		return nil, nil
+	}
+
+	obj, err := b.getObject(kubernetesObjectName(objPath))
+	if err != nil || obj == nil {
+		return nil, err
+	}
+
+	value, ok, err := b.decodeValue(obj, dataKey)
+	if err != nil || !ok {
+		return nil, err
+	}
+	return &api.KVPair{Key: key, Value: value}, nil
+}
+
+func (b *kubernetesBackend) Keys(prefix string) ([]string, error) {
+	q := url.Values{}
+	q.Set("labelSelector", kubernetesManagedByLabel+"=consul-replicate")
+
+	var list kubernetesObjectList
+	if _, err := b.do(http.MethodGet, b.collectionPath()+"?"+q.Encode(), nil, &list); err != nil {
+		return nil, err
+	}
+
+	var keys []string
+	for _, obj := range list.Items {
+		for _, key := range objectKeys(obj) {
+			if strings.HasPrefix(key, prefix) {
+				keys = append(keys, key)
+			}
+		}
+	}
+	return keys, nil
+}
+
+func (b *kubernetesBackend) Put(pair *api.KVPair) error {
+	objPath, dataKey := b.locate(pair.Key)
+	if dataKey == "" {
+		// Folder placeholders have no representation in an object
+		return nil
+	}
+
+	name := kubernetesObjectName(objPath)
+	obj, err := b.getObject(name)
+	if err != nil {
+		return err
+	}
+
+	create := obj == nil
+	if create {
+		obj = b.newObject(name, objPath)
+	}
+
+	keys := objectKeyMap(obj)
+	keys[dataKey] = pair.Key
+	if err := setObjectKeys(obj, keys); err != nil {
+		return err
+	}
+	b.encodeValue(obj, dataKey, pair.Value)
+
+	if create {
+		_, err = b.do(http.MethodPost, b.collectionPath(), obj, nil)
+	} else {
+		_, err = b.do(http.MethodPut, b.objectPath(name), obj, nil)
+	}
+	return err
+}
+
+func (b *kubernetesBackend) Delete(key string) error {
+	objPath, dataKey := b.locate(key)
+	if dataKey == "" {
+		return nil
+	}
+
+	name := kubernetesObjectName(objPath)
+	obj, err := b.getObject(name)
+	if err != nil || obj == nil {
+		return err
+	}
+
+	delete(obj.Data, dataKey)
+	delete(obj.BinaryData, dataKey)
+
+	keys := objectKeyMap(obj)
+	delete(keys, dataKey)
+	if len(keys) == 0 {
+		_, err = b.do(http.MethodDelete, b.objectPath(name), nil, nil)
+		return err
+	}
+
+	if err := setObjectKeys(obj, keys); err != nil {
+		return err
+	}
+	_, err = b.do(http.MethodPut, b.objectPath(name), obj, nil)
+	return err
+}
+
+// locate returns the Consul path of the object the key belongs to and the data
+// key inside that object.
+func (b *kubernetesBackend) locate(key string) (string, string) {
+	base := path.Base(key)
+	if key == "" || strings.HasSuffix(key, "/") {
+		return "", ""
+	}
+
+	objPath := key
+	if b.mode == KubernetesModeSubtree {
+		objPath = strings.TrimSuffix(key, base)
+		objPath = strings.TrimSuffix(objPath, "/")
+	}
+	return objPath, kubernetesDataKey(base)
+}
+
+func (b *kubernetesBackend) newObject(name, objPath string) *kubernetesObject {
+	obj := &kubernetesObject{
+		APIVersion: "v1",
+		Kind:       "ConfigMap",
+		Metadata: kubernetesObjectMetadata{
+			Name:      name,
+			Namespace: b.namespace,
+			Labels: map[string]string{
+				kubernetesManagedByLabel: "consul-replicate",
+			},
+			Annotations: map[string]string{
+				kubernetesPathAnnotation: objPath,
+			},
+		},
+	}
+	if b.kind == KubernetesKindSecret {
+		obj.Kind = "Secret"
+		obj.Type = "Opaque"
+	}
+	return obj
+}
+
+// encodeValue stores the value in the object, using binaryData for values in
+// ConfigMaps which are not valid UTF-8 so they are replicated byte-for-byte.
+func (b *kubernetesBackend) encodeValue(obj *kubernetesObject, dataKey string, value []byte) {
+	if obj.Data == nil {
+		obj.Data = make(map[string]string)
+	}
+
+	if b.kind == KubernetesKindSecret {
+		obj.Data[dataKey] = base64.StdEncoding.EncodeToString(value)
+		return
+	}
+
+	if utf8.Valid(value) {
+		delete(obj.BinaryData, dataKey)
+		obj.Data[dataKey] = string(value)
+		return
+	}
+
+	if obj.BinaryData == nil {
+		obj.BinaryData = make(map[string]string)
+	}
+	delete(obj.Data, dataKey)
+	obj.BinaryData[dataKey] = base64.StdEncoding.EncodeToString(value)
+}
+
+// decodeValue returns the raw value stored under the data key.
+func (b *kubernetesBackend) decodeValue(obj *kubernetesObject, dataKey string) ([]byte, bool, error) {
+	if v, ok := obj.Data[dataKey]; ok {
+		if b.kind == KubernetesKindSecret {
+			value, err := base64.StdEncoding.DecodeString(v)
+			return value, true, err
+		}
+		return []byte(v), true, nil
+	}
+
+	if v, ok := obj.BinaryData[dataKey]; ok {
+		value, err := base64.StdEncoding.DecodeString(v)
+		return value, true, err
+	}
+
+	return nil, false, nil
+}
+
+func (b *kubernetesBackend) getObject(name string) (*kubernetesObject, error) {
+	var obj kubernetesObject
+	code, err := b.do(http.MethodGet, b.objectPath(name), nil, &obj)
+	if code == http.StatusNotFound {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &obj, nil
+}
+
+func (b *kubernetesBackend) collectionPath() string {
+	resource := "configmaps"
+	if b.kind == KubernetesKindSecret {
+		resource = "secrets"
+	}
+	return fmt.Sprintf("/api/v1/namespaces/%s/%s", url.PathEscape(b.namespace), resource)
+}
+
+func (b *kubernetesBackend) objectPath(name string) string {
+	return b.collectionPath() + "/" + url.PathEscape(name)
+}
+
+// do performs a request against the API server, decoding the response into
+// out if given. The status code is returned alongside any error.
+func (b *kubernetesBackend) do(method, p string, in, out interface{}) (int, error) {
+	var body io.Reader
+	if in != nil {
+		enc, err := json.Marshal(in)
+		if err != nil {
+			return 0, err
+		}
+		body = bytes.NewReader(enc)
+	}
+
+	ctx := b.ctx
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	req, err := http.NewRequestWithContext(ctx, method, b.address+p, body)
+	if err != nil {
+		return 0, err
+	}
+	req.Header.Set("Accept", "application/json")
+	if b.userAgent != "" {
+		req.Header.Set("User-Agent", b.userAgent)
+	}
+	if in != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+
+	if b.tokenFile != "" {
+		token, err := os.ReadFile(b.tokenFile)
+		if err != nil && !os.IsNotExist(err) {
+			return 0, errors.Wrap(err, "kubernetes: reading token")
+		}
+		if t := strings.TrimSpace(string(token)); t != "" {
+			req.Header.Set("Authorization", "Bearer "+t)
+		}
+	}
+
+	resp, err := b.client.Do(req)
+	if err != nil {
+		return 0, errors.Wrap(err, "kubernetes")
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 4096))
+		return resp.StatusCode, fmt.Errorf("kubernetes: %s %s: %s: %s",
+			method, p, resp.Status, strings.TrimSpace(string(msg)))
+	}
+
+	if out != nil {
+		if err := json.NewDecoder(resp.Body).Decode(out); err != nil {
+			return resp.StatusCode, errors.Wrap(err, "kubernetes: decoding response")
+		}
+	}
+	return resp.StatusCode, nil
+}
+
+// objectKeyMap returns the mapping of data keys to full Consul keys recorded
+// on the object.
+func objectKeyMap(obj *kubernetesObject) map[string]string {
+	keys := make(map[string]string)
+	if raw, ok := obj.Metadata.Annotations[kubernetesKeysAnnotation]; ok {
+		json.Unmarshal([]byte(raw), &keys)
+	}
+	return keys
+}
+
+func setObjectKeys(obj *kubernetesObject, keys map[string]string) error {
+	enc, err := json.Marshal(keys)
+	if err != nil {
+		return err
+	}
+	if obj.Metadata.Annotations == nil {
+		obj.Metadata.Annotations = make(map[string]string)
+	}
+	obj.Metadata.Annotations[kubernetesKeysAnnotation] = string(enc)
+	return nil
+}
+
+func objectKeys(obj *kubernetesObject) []string {
+	m := objectKeyMap(obj)
+	keys := make([]string, 0, len(m))
+	for _, key := range m {
+		keys = append(keys, key)
+	}
+	return keys
+}
+
+// kubernetesObjectName converts a Consul path into a valid object name. A hash
+// of the original path is appended so distinct paths never collide after
+// sanitizing.
+func kubernetesObjectName(p string) string {
+	name := strings.ToLower(strings.Trim(p, "/"))
+	name = strings.Replace(name, "/", ".", -1)
+	name = kubernetesInvalidNameRe.ReplaceAllString(name, "-")
+	name = strings.Trim(name, ".-")
+	if len(name) > 240 {
+		name = strings.Trim(name[:240], ".-")
+	}
+	if name == "" {
+		name = "root"
+	}
+
+	h := fnv.New32a()
+	h.Write([]byte(p))
+	return fmt.Sprintf("%s-%08x", name, h.Sum32())
+}
+
+// kubernetesDataKey converts the last segment of a Consul key into a valid data
+// key, appending a hash when characters had to be replaced.
+func kubernetesDataKey(s string) string {
+	clean := kubernetesInvalidDataKeyRe.ReplaceAllString(s, "_")
+	if clean == s && s != "." && s != ".." {
+		return s
+	}
+
+	h := fnv.New32a()
+	h.Write([]byte(s))
+	return fmt.Sprintf("%s.%08x", clean, h.Sum32())
+}
@@ -0,0 +1,120 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: MPL-2.0
+
+package replicate
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/hashicorp/consul-template/config"
+	"github.com/hashicorp/consul/api"
+)
+
+// testKubernetesServer is a minimal in-memory API server for ConfigMaps and
+// Secrets in a single namespace.
+func testKubernetesServer() *httptest.Server {
+	var mu sync.Mutex
+	objects := make(map[string][]byte)
+
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+
+		parts := strings.Split(strings.Trim(r.URL.Path, "/"), "/")
+		name := ""
+		if len(parts) == 6 {
+			name = parts[5]
+		}
+
+		switch {
+		case r.Method == http.MethodGet && name == "":
+			var items []json.RawMessage
+			for _, o := range objects {
+				items = append(items, o)
+			}
+			json.NewEncoder(w).Encode(map[string]interface{}{"items": items})
+		case r.Method == http.MethodGet:
+			o, ok := objects[name]
+			if !ok {
+				w.WriteHeader(http.StatusNotFound)
+				return
+			}
+			w.Write(o)
+		case r.Method == http.MethodPost, r.Method == http.MethodPut:
+			var obj kubernetesObject
+			if err := json.NewDecoder(r.Body).Decode(&obj); err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+			enc, _ := json.Marshal(obj)
+			objects[obj.Metadata.Name] = enc
+			w.Write(enc)
+		case r.Method == http.MethodDelete:
+			delete(objects, name)
+		}
+	}))
+}
+
+func TestKubernetesBackend(t *testing.T) {
+	for _, kind := range []string{KubernetesKindConfigMap, KubernetesKindSecret} {
+		for _, mode := range []string{KubernetesModeSubtree, KubernetesModeKey} {
+			t.Run(kind+"_"+mode, func(t *testing.T) {
+				srv := testKubernetesServer()
+				defer srv.Close()
+
+				b, err := newKubernetesBackend(&KubernetesConfig{
+					Address:   config.String(srv.URL),
+					Kind:      config.String(kind),
+					Mode:      config.String(mode),
+					Namespace: config.String("default"),
+				})
+				if err != nil {
+					t.Fatal(err)
+				}
+
+				values := map[string][]byte{
+					"global/a":           []byte("a"),
+					"global/nested/b":    []byte("b"),
+					"global/nested/c@d!": {0xff, 0x00, 0xfe},
+				}
+				for k, v := range values {
+					if err := b.Put(&api.KVPair{Key: k, Value: v}); err != nil {
+						t.Fatal(err)
+					}
+				}
+
+				for k, v := range values {
+					pair, err := b.Get(k)
+					if err != nil {
+						t.Fatal(err)
+					}
+					if pair == nil || !bytes.Equal(pair.Value, v) {
+						t.Errorf("%s: expected %q to be %q", k, pair, v)
+					}
+				}
+
+				if err := b.Delete("global/nested/b"); err != nil {
+					t.Fatal(err)
+				}
+
+				keys, err := b.Keys("global/")
+				if err != nil {
+					t.Fatal(err)
+				}
+				sort.Strings(keys)
+				expected := []string{"global/a", "global/nested/c@d!"}
+				if !reflect.DeepEqual(expected, keys) {
+					t.Errorf("\nexp: %#v\nact: %#v", expected, keys)
+				}
+			})
+		}
+	}
+}
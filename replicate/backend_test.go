@@ -0,0 +1,73 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: MPL-2.0
+
+package replicate
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/hashicorp/consul/api"
+)
+
+func TestConsulBackend_stale(t *testing.T) {
+	cases := []struct {
+		name     string
+		maxStale time.Duration
+		exp      []string
+	}{
+		{
+			"leader",
+			0,
+			[]string{"leader"},
+		},
+		{
+			"stale",
+			10 * time.Second,
+			[]string{"stale"},
+		},
+		{
+			"too_stale",
+			time.Second,
+			[]string{"stale", "leader"},
+		},
+	}
+
+	for i, tc := range cases {
+		t.Run(fmt.Sprintf("%d_%s", i, tc.name), func(t *testing.T) {
+			// Followers are 5s behind the leader
+			var reads []string
+			srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				if _, ok := r.URL.Query()["stale"]; ok {
+					reads = append(reads, "stale")
+					w.Header().Set("X-Consul-LastContact", "5000")
+				} else {
+					reads = append(reads, "leader")
+				}
+				fmt.Fprint(w, `[{"Key":"global/a","Value":"MQ=="}]`)
+			}))
+			defer srv.Close()
+
+			client, err := api.NewClient(&api.Config{Address: srv.URL})
+			if err != nil {
+				t.Fatal(err)
+			}
+			backend := allowStale(newConsulBackend(client), tc.maxStale)
+
+			pair, err := backend.Get("global/a")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if pair == nil || string(pair.Value) != "1" {
+				t.Fatalf("expected global/a, got %#v", pair)
+			}
+			if !reflect.DeepEqual(tc.exp, reads) {
+				t.Errorf("\nexp: %#v\nact: %#v", tc.exp, reads)
+			}
+		})
+	}
+}
@@ -0,0 +1,146 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: MPL-2.0
+
+package replicate
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"log"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/hashicorp/consul-template/config"
+	"github.com/hashicorp/consul/api"
+)
+
+// backupEntry is a key of a backup, in the format of "consul kv export", so a
+// backup can be restored with "consul kv import".
+type backupEntry struct {
+	Key   string `json:"key"`
+	Flags uint64 `json:"flags"`
+	Value string `json:"value"`
+}
+
+// backupEnabled returns true if the destination keys of a prefix are backed
+// up before a pass deletes any of them.
+func (r *Runner) backupEnabled() bool {
+	return config.BoolVal(r.config.Backup.Enabled)
+}
+
+// backupConsulPath returns the folder of the backups in the destination
+// Consul, without a trailing slash, or an empty string if they are not written
+// there.
+func (r *Runner) backupConsulPath() string {
+	if !r.backupEnabled() {
+		return ""
+	}
+	return strings.TrimRight(config.StringVal(r.config.Backup.ConsulPath), "/")
+}
+
+// backupPartSize is the size a part of a backup is kept under, so each part
+// fits in a single value of the destination Consul, whose limit is 512 KiB.
+const backupPartSize = 256 * 1024
+
+// backup writes the current values of the destination keys of the prefix
+// which the pass deletes into the backup folder and the destination Consul. A
+// backup larger than backupPartSize is written in several parts, each of them
+// importable on its own.
+func (r *Runner) backup(backend Backend, prefix *PrefixConfig, keys []string, t time.Time) error {
+	pairs, err := r.backupPairs(backend, prefix, keys)
+	if err != nil {
+		return err
+	}
+
+	var parts [][]*backupEntry
+	var part []*backupEntry
+	size, entries := 0, 0
+	for _, pair := range pairs {
+		// Keys written by hand are not deleted either
+		if config.BoolVal(prefix.DeleteOwnedOnly) {
+			ok, err := r.owned(backend, pair)
+			if err != nil {
+				return fmt.Errorf("failed to read metadata of %q: %s", pair.Key, err)
+			}
+			if !ok {
+				continue
+			}
+		}
+
+		entry := &backupEntry{
+			Key:   pair.Key,
+			Flags: pair.Flags,
+			Value: base64.StdEncoding.EncodeToString(pair.Value),
+		}
+		entrySize := len(entry.Key) + len(entry.Value) + 64
+		if len(part) > 0 && size+entrySize > backupPartSize {
+			parts = append(parts, part)
+			part, size = nil, 0
+		}
+		part = append(part, entry)
+		size += entrySize
+		entries++
+	}
+	if len(part) > 0 {
+		parts = append(parts, part)
+	}
+
+	for i, part := range parts {
+		enc, err := json.MarshalIndent(part, "", "  ")
+		if err != nil {
+			return err
+		}
+		ext := ".json"
+		if len(parts) > 1 {
+			ext = fmt.Sprintf(".%d.json", i+1)
+		}
+		if err := r.writeArchive(r.backups, r.archiveName(prefix, t, ext), enc); err != nil {
+			return err
+		}
+	}
+
+	log.Printf("[DEBUG] (runner) backed up %d keys of %s in %d part(s)",
+		entries, prefix.Dependency, len(parts))
+	return nil
+}
+
+// backupPairs returns the pairs of the destination keys, sorted by key. They
+// are read with a single listing of every destination of the prefix if the
+// backend can list pairs, or else one by one.
+func (r *Runner) backupPairs(backend Backend, prefix *PrefixConfig, keys []string) ([]*api.KVPair, error) {
+	wanted := make(map[string]struct{}, len(keys))
+	for _, key := range keys {
+		wanted[key] = struct{}{}
+	}
+
+	var pairs []*api.KVPair
+	if lb, ok := backend.(listBackend); ok {
+		for _, destination := range routeDestinations(prefix) {
+			list, _, err := lb.list(destination, 0)
+			if err != nil {
+				return nil, err
+			}
+			for _, pair := range list {
+				if _, ok := wanted[pair.Key]; ok {
+					delete(wanted, pair.Key)
+					pairs = append(pairs, pair)
+				}
+			}
+		}
+	} else {
+		for _, key := range keys {
+			pair, err := backend.Get(key)
+			if err != nil {
+				return nil, err
+			}
+			if pair != nil {
+				pairs = append(pairs, pair)
+			}
+		}
+	}
+
+	sort.Slice(pairs, func(i, j int) bool { return pairs[i].Key < pairs[j].Key })
+	return pairs, nil
+}
@@ -0,0 +1,183 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: MPL-2.0
+
+package replicate
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"sync/atomic"
+	"time"
+
+	"golang.org/x/time/rate"
+)
+
+// meter counts the bytes read from the source and written into the
+// destination for a prefix, and the requests which cost the Consul servers the
+// most, since it was created.
+type meter struct {
+	read    uint64
+	written uint64
+
+	// queries is the number of blocking queries of a watch, and indexChanges
+	// the number of times they returned a new index after lastIndex.
+	queries      uint64
+	indexChanges uint64
+	lastIndex    uint64
+
+	// writeOps is the number of writes of keys and transactions of a prefix.
+	writeOps uint64
+
+	since time.Time
+}
+
+// meterKey is the key of the meter in the context of a request.
+type meterKey struct{}
+
+// withMeter returns the context of requests whose bytes are counted by the
+// meter. Like the admin partition, the meter is carried by the context to the
+// transport, which counts the bytes of the request.
+func withMeter(ctx context.Context, m *meter) context.Context {
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	return context.WithValue(ctx, meterKey{}, m)
+}
+
+// meter returns the meter with the given id, which is the String() of the
+// dependency of a prefix or of a watch.
+func (r *Runner) meter(id string) *meter {
+	r.metersLock.Lock()
+	defer r.metersLock.Unlock()
+
+	if r.meters == nil {
+		r.meters = make(map[string]*meter)
+	}
+	m, ok := r.meters[id]
+	if !ok {
+		m = &meter{since: time.Now()}
+		r.meters[id] = m
+	}
+	return m
+}
+
+// bytesOf returns the number of bytes read from the source by the watch of the
+// prefix, which prefixes sharing a coalesced watch have in common, and the
+// number of bytes written into the destination by its passes.
+func (r *Runner) bytesOf(prefix *PrefixConfig) (uint64, uint64) {
+	id := prefix.Dependency.String()
+	r.RLock()
+	if d, ok := r.watches[id]; ok {
+		id = d.String()
+	}
+	r.RUnlock()
+
+	return atomic.LoadUint64(&r.meter(id).read),
+		atomic.LoadUint64(&r.meter(prefix.Dependency.String()).written)
+}
+
+// bandwidthTransport counts and caps the bytes of a client, which are the
+// bytes of the responses of the source, or of the requests to the
+// destination.
+type bandwidthTransport struct {
+	base    http.RoundTripper
+	limiter *rate.Limiter
+	writes  bool
+}
+
+func (t *bandwidthTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	m, _ := req.Context().Value(meterKey{}).(*meter)
+
+	if t.writes {
+		if req.ContentLength > 0 {
+			if err := waitBytes(req.Context(), t.limiter, int(req.ContentLength)); err != nil {
+				return nil, err
+			}
+		}
+		resp, err := t.base.RoundTrip(req)
+		if err == nil && m != nil {
+			if req.ContentLength > 0 {
+				atomic.AddUint64(&m.written, uint64(req.ContentLength))
+			}
+			if isKVWrite(req) {
+				atomic.AddUint64(&m.writeOps, 1)
+			}
+		}
+		return resp, err
+	}
+
+	resp, err := t.base.RoundTrip(req)
+	if err != nil || (m == nil && t.limiter == nil) {
+		return resp, err
+	}
+	resp.Body = &meteredBody{
+		ReadCloser: resp.Body,
+		ctx:        req.Context(),
+		limiter:    t.limiter,
+		meter:      m,
+	}
+	return resp, nil
+}
+
+// meteredBody counts the bytes read from the body of a response, and slows
+// down reading it to the cap.
+type meteredBody struct {
+	io.ReadCloser
+	ctx     context.Context
+	limiter *rate.Limiter
+	meter   *meter
+}
+
+func (b *meteredBody) Read(p []byte) (int, error) {
+	n, err := b.ReadCloser.Read(p)
+	if n > 0 {
+		if b.meter != nil {
+			atomic.AddUint64(&b.meter.read, uint64(n))
+		}
+		if werr := waitBytes(b.ctx, b.limiter, n); werr != nil {
+			return n, werr
+		}
+	}
+	return n, err
+}
+
+// waitBytes waits until the limiter allows n bytes. A nil limiter allows any
+// number of bytes.
+func waitBytes(ctx context.Context, limiter *rate.Limiter, n int) error {
+	if limiter == nil {
+		return nil
+	}
+	for n > 0 {
+		chunk := n
+		if burst := limiter.Burst(); chunk > burst {
+			chunk = burst
+		}
+		if err := limiter.WaitN(ctx, chunk); err != nil {
+			return err
+		}
+		n -= chunk
+	}
+	return nil
+}
+
+// setBandwidth makes the client count the bytes of its requests, or of its
+// responses, for the meters of their contexts, capped to limit bytes per
+// second unless it is zero.
+func setBandwidth(hc *http.Client, limit int, writes bool) {
+	if _, ok := hc.Transport.(*bandwidthTransport); ok {
+		return
+	}
+	base := hc.Transport
+	if base == nil {
+		base = http.DefaultTransport
+	}
+
+	t := &bandwidthTransport{base: base, writes: writes}
+	if limit > 0 {
+		// A burst of a second of traffic lets a request go without waiting on
+		// an idle link
+		t.limiter = rate.NewLimiter(rate.Limit(limit), limit)
+	}
+	hc.Transport = t
+}
@@ -0,0 +1,106 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: MPL-2.0
+
+package replicate
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/hashicorp/consul-template/config"
+	"github.com/hashicorp/consul/api"
+)
+
+func TestSetBandwidth(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.Copy(io.Discard, r.Body)
+		if r.Method == http.MethodPut {
+			fmt.Fprint(w, "true")
+			return
+		}
+		fmt.Fprintf(w, `[{"Key":"global/a","Value":"%s"}]`, strings.Repeat("A", 12000))
+	}))
+	defer srv.Close()
+
+	cases := []struct {
+		name    string
+		limit   int
+		writes  bool
+		minTime time.Duration
+	}{
+		{
+			"reads",
+			0,
+			false,
+			0,
+		},
+		{
+			"reads_capped",
+			10000,
+			false,
+			150 * time.Millisecond,
+		},
+		{
+			"writes",
+			0,
+			true,
+			0,
+		},
+		{
+			"writes_capped",
+			10000,
+			true,
+			150 * time.Millisecond,
+		},
+	}
+
+	for i, tc := range cases {
+		t.Run(fmt.Sprintf("%d_%s", i, tc.name), func(t *testing.T) {
+			c := config.DefaultConsulConfig()
+			c.Address = config.String(srv.URL)
+			c.Finalize()
+			clients, err := newClientSet(c, nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			setBandwidth(clients.httpClient, tc.limit, tc.writes)
+
+			m := &meter{}
+			ctx := withMeter(context.Background(), m)
+			start := time.Now()
+			if tc.writes {
+				_, err = clients.Consul().KV().Put(&api.KVPair{
+					Key:   "global/a",
+					Value: []byte(strings.Repeat("A", 12000)),
+				}, (&api.WriteOptions{}).WithContext(ctx))
+			} else {
+				_, _, err = clients.Consul().KV().List("global",
+					(&api.QueryOptions{}).WithContext(ctx))
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if elapsed := time.Since(start); elapsed < tc.minTime {
+				t.Errorf("expected the transfer to take at least %s, took %s",
+					tc.minTime, elapsed)
+			}
+			act := m.read
+			if tc.writes {
+				act = m.written
+			}
+			if act < 12000 {
+				t.Errorf("expected at least 12000 bytes, got %d", act)
+			}
+			if tc.writes && m.writeOps != 1 {
+				t.Errorf("expected 1 write, got %d", m.writeOps)
+			}
+		})
+	}
+}
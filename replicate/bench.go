@@ -0,0 +1,139 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: MPL-2.0
+
+package replicate
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/hashicorp/consul-replicate/bench"
+	"github.com/hashicorp/consul-template/config"
+	"github.com/hashicorp/consul/api"
+)
+
+// benchWaitTime is how long a blocking query of the destination waits for a
+// change of the churn of a benchmark.
+const benchWaitTime = 30 * time.Second
+
+// BenchResult is the outcome of the benchmark of a prefix.
+type BenchResult struct {
+	// Source, Datacenter and Destination identify the prefix.
+	Source, Datacenter, Destination string
+
+	// Folder is the scratch folder of the source the churn was written into.
+	Folder string
+
+	// Result is the throughput and lag of the replication of the churn.
+	Result *bench.Result
+
+	// Err is why the benchmark failed, if it did.
+	Err error
+}
+
+// Bench writes synthetic churn into a scratch folder of the source of every
+// configured prefix, and measures the throughput and lag of a running
+// replicator copying it into the destination. The scratch folders are removed
+// from the source when the benchmark finishes, and the replicator removes them
+// from the destination. Prefixes are benchmarked in parallel, until the
+// context is cancelled.
+func (r *Runner) Bench(ctx context.Context, o *bench.Options) ([]*BenchResult, error) {
+	if err := o.Validate(); err != nil {
+		return nil, err
+	}
+
+	prefixes, err := r.concretePrefixes()
+	if err != nil {
+		return nil, err
+	}
+
+	name := fmt.Sprintf("consul-replicate-bench-%d", time.Now().UnixNano())
+	results := make([]*BenchResult, len(prefixes))
+
+	var wg sync.WaitGroup
+	for i, prefix := range prefixes {
+		wg.Add(1)
+		go func(i int, prefix *PrefixConfig) {
+			defer wg.Done()
+			results[i] = r.benchPrefix(ctx, prefix, name, o)
+		}(i, prefix)
+	}
+	wg.Wait()
+
+	return results, nil
+}
+
+// benchPrefix runs the benchmark of a single prefix.
+func (r *Runner) benchPrefix(ctx context.Context, prefix *PrefixConfig, name string, o *bench.Options) *BenchResult {
+	source := config.StringVal(prefix.Source)
+	dc := config.StringVal(prefix.Datacenter)
+
+	folder := name + "/"
+	if f := strings.TrimSuffix(source, "/"); f != "" {
+		folder = f + "/" + folder
+	}
+
+	result := &BenchResult{
+		Source:      source,
+		Datacenter:  dc,
+		Destination: config.StringVal(prefix.Destination),
+		Folder:      folder,
+	}
+	if r.excluded(folder) {
+		result.Err = fmt.Errorf("scratch folder %q is excluded", folder)
+		return result
+	}
+	if name := config.StringVal(prefix.Backend); name != BackendConsul {
+		result.Err = fmt.Errorf("the %q backend cannot be benchmarked", name)
+		return result
+	}
+
+	destinationDC := config.StringVal(prefix.DestinationDatacenter)
+	if destinationDC == "" {
+		destinationDC = config.StringVal(r.config.Destination.Datacenter)
+	}
+
+	log.Printf("[INFO] (runner) benchmarking %s for %s", prefix.Dependency, o.Duration)
+	result.Result, result.Err = bench.Run(ctx,
+		&benchKV{kv: r.clients.Consul().KV(), datacenter: dc, partition: config.StringVal(prefix.Partition)},
+		&benchKV{kv: r.destinationClients.Consul().KV(), datacenter: destinationDC,
+			partition: config.StringVal(prefix.DestinationPartition)},
+		folder, config.StringVal(prefix.Destination)+strings.TrimPrefix(folder, source), o)
+	return result
+}
+
+// benchKV is the source or destination of a benchmark in a Consul datacenter.
+type benchKV struct {
+	kv         *api.KV
+	datacenter string
+	partition  string
+}
+
+func (b *benchKV) Put(ctx context.Context, pair *api.KVPair) error {
+	w := (&api.WriteOptions{Datacenter: b.datacenter}).WithContext(withPartition(ctx, b.partition))
+	_, err := b.kv.Put(pair, w)
+	return err
+}
+
+func (b *benchKV) DeleteTree(ctx context.Context, prefix string) error {
+	w := (&api.WriteOptions{Datacenter: b.datacenter}).WithContext(withPartition(ctx, b.partition))
+	_, err := b.kv.DeleteTree(prefix, w)
+	return err
+}
+
+func (b *benchKV) List(ctx context.Context, prefix string, waitIndex uint64) ([]*api.KVPair, uint64, error) {
+	q := (&api.QueryOptions{
+		Datacenter: b.datacenter,
+		WaitIndex:  waitIndex,
+		WaitTime:   benchWaitTime,
+	}).WithContext(withPartition(ctx, b.partition))
+	pairs, meta, err := b.kv.List(prefix, q)
+	if err != nil {
+		return nil, 0, err
+	}
+	return pairs, meta.LastIndex, nil
+}
@@ -0,0 +1,157 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: MPL-2.0
+
+package replicate
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/hashicorp/consul-template/config"
+)
+
+// cronSchedule is a parsed cron expression of five fields: minute, hour, day
+// of month, month and day of week. Every field accepts "*", single values,
+// ranges such as "1-5", steps such as "*/15" or "0-30/10", and lists of those
+// separated by commas. Day of week 0 and 7 are both Sunday.
+type cronSchedule struct {
+	minute, hour, dom, month, dow map[int]bool
+
+	// anyDOM and anyDOW are set if the day fields are "*". When both are
+	// restricted, a day matching either of them matches, as in cron.
+	anyDOM, anyDOW bool
+}
+
+// parseCronSchedule parses the cron expression.
+func parseCronSchedule(s string) (*cronSchedule, error) {
+	fields := strings.Fields(s)
+	if len(fields) != 5 {
+		return nil, fmt.Errorf("invalid schedule %q: expected 5 fields", s)
+	}
+	dom, dow := fields[2], fields[4]
+
+	var c cronSchedule
+	var err error
+	for _, f := range []struct {
+		values   *map[int]bool
+		min, max int
+	}{
+		{&c.minute, 0, 59},
+		{&c.hour, 0, 23},
+		{&c.dom, 1, 31},
+		{&c.month, 1, 12},
+		{&c.dow, 0, 7},
+	} {
+		field := fields[0]
+		fields = fields[1:]
+		if *f.values, err = parseCronField(field, f.min, f.max); err != nil {
+			return nil, fmt.Errorf("invalid schedule %q: %s", s, err)
+		}
+	}
+
+	if c.dow[7] {
+		c.dow[0] = true
+	}
+	c.anyDOM, c.anyDOW = strings.HasPrefix(dom, "*"), strings.HasPrefix(dow, "*")
+	return &c, nil
+}
+
+// parseCronField parses a field of a cron expression into the set of values
+// it matches.
+func parseCronField(field string, min, max int) (map[int]bool, error) {
+	values := make(map[int]bool)
+	for _, part := range strings.Split(field, ",") {
+		step := 1
+		if i := strings.Index(part, "/"); i >= 0 {
+			var err error
+			if step, err = strconv.Atoi(part[i+1:]); err != nil || step < 1 {
+				return nil, fmt.Errorf("invalid step %q", part)
+			}
+			part = part[:i]
+		}
+
+		lo, hi := min, max
+		if part != "*" {
+			var err error
+			bounds := strings.SplitN(part, "-", 2)
+			if lo, err = strconv.Atoi(bounds[0]); err != nil {
+				return nil, fmt.Errorf("invalid value %q", part)
+			}
+			hi = lo
+			if len(bounds) == 2 {
+				if hi, err = strconv.Atoi(bounds[1]); err != nil {
+					return nil, fmt.Errorf("invalid value %q", part)
+				}
+			} else if step > 1 {
+				hi = max
+			}
+		}
+		if lo < min || hi > max || lo > hi {
+			return nil, fmt.Errorf("value %q out of range %d-%d", part, min, max)
+		}
+
+		for v := lo; v <= hi; v += step {
+			values[v] = true
+		}
+	}
+	return values, nil
+}
+
+// matches returns true if the schedule fires at the minute of the time.
+func (c *cronSchedule) matches(t time.Time) bool {
+	if !c.minute[t.Minute()] || !c.hour[t.Hour()] || !c.month[int(t.Month())] {
+		return false
+	}
+
+	dom, dow := c.dom[t.Day()], c.dow[int(t.Weekday())]
+	switch {
+	case c.anyDOM && c.anyDOW:
+		return true
+	case c.anyDOM:
+		return dow
+	case c.anyDOW:
+		return dom
+	default:
+		return dom || dow
+	}
+}
+
+// checkBlackouts returns an error if a blackout window of the prefix is
+// invalid.
+func checkBlackouts(prefix *PrefixConfig) error {
+	for _, b := range *prefix.Blackouts {
+		if _, err := parseCronSchedule(config.StringVal(b.Schedule)); err != nil {
+			return fmt.Errorf("blackout of prefix %q: %s", config.StringVal(prefix.Source), err)
+		}
+		if config.TimeDurationVal(b.Duration) <= 0 {
+			return fmt.Errorf("blackout of prefix %q: missing duration", config.StringVal(prefix.Source))
+		}
+	}
+	return nil
+}
+
+// blackoutEnd returns when the open blackout windows of the prefix close, and
+// false if none is open at the given time. Windows are opened at minute
+// boundaries, so every minute within the longest duration is checked.
+func blackoutEnd(prefix *PrefixConfig, now time.Time) (time.Time, bool) {
+	var end time.Time
+	for _, b := range *prefix.Blackouts {
+		schedule, err := parseCronSchedule(config.StringVal(b.Schedule))
+		if err != nil {
+			continue
+		}
+
+		duration := config.TimeDurationVal(b.Duration)
+		for start := now.Truncate(time.Minute); now.Sub(start) < duration; start = start.Add(-time.Minute) {
+			if !schedule.matches(start) {
+				continue
+			}
+			if e := start.Add(duration); e.After(end) {
+				end = e
+			}
+		}
+	}
+	return end, !end.IsZero()
+}
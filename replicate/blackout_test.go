@@ -0,0 +1,117 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: MPL-2.0
+
+package replicate
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/hashicorp/consul-template/config"
+)
+
+func TestParseCronSchedule(t *testing.T) {
+	cases := []struct {
+		name     string
+		schedule string
+		err      bool
+	}{
+		{"every_minute", "* * * * *", false},
+		{"weekdays", "0 9 * * 1-5", false},
+		{"steps_and_lists", "*/15 0,12 1-10/2 * 7", false},
+		{"missing_field", "0 9 * *", true},
+		{"out_of_range", "60 * * * *", true},
+		{"inverted_range", "* 10-9 * * *", true},
+		{"invalid_step", "*/0 * * * *", true},
+		{"invalid_value", "a * * * *", true},
+	}
+
+	for i, tc := range cases {
+		t.Run(fmt.Sprintf("%d_%s", i, tc.name), func(t *testing.T) {
+			_, err := parseCronSchedule(tc.schedule)
+			if (err != nil) != tc.err {
+				t.Fatal(err)
+			}
+		})
+	}
+}
+
+func TestBlackoutEnd(t *testing.T) {
+	// Monday, January 6th 2020
+	monday := time.Date(2020, time.January, 6, 0, 0, 0, 0, time.Local)
+
+	cases := []struct {
+		name      string
+		blackouts *BlackoutConfigs
+		now       time.Time
+		exp       time.Time
+	}{
+		{
+			"none",
+			&BlackoutConfigs{},
+			monday.Add(10 * time.Hour),
+			time.Time{},
+		},
+		{
+			"open",
+			&BlackoutConfigs{{
+				Schedule: config.String("0 9 * * 1-5"),
+				Duration: config.TimeDuration(8 * time.Hour),
+			}},
+			monday.Add(10 * time.Hour),
+			monday.Add(17 * time.Hour),
+		},
+		{
+			"closed",
+			&BlackoutConfigs{{
+				Schedule: config.String("0 9 * * 1-5"),
+				Duration: config.TimeDuration(8 * time.Hour),
+			}},
+			monday.Add(17 * time.Hour),
+			time.Time{},
+		},
+		{
+			"other_day",
+			&BlackoutConfigs{{
+				Schedule: config.String("0 9 * * 6"),
+				Duration: config.TimeDuration(8 * time.Hour),
+			}},
+			monday.Add(10 * time.Hour),
+			time.Time{},
+		},
+		{
+			"overnight",
+			&BlackoutConfigs{{
+				Schedule: config.String("0 22 * * *"),
+				Duration: config.TimeDuration(4 * time.Hour),
+			}},
+			monday.Add(time.Hour),
+			monday.Add(2 * time.Hour),
+		},
+		{
+			"overlapping",
+			&BlackoutConfigs{
+				{
+					Schedule: config.String("0 9 * * *"),
+					Duration: config.TimeDuration(2 * time.Hour),
+				},
+				{
+					Schedule: config.String("30 9 * * *"),
+					Duration: config.TimeDuration(2 * time.Hour),
+				},
+			},
+			monday.Add(10 * time.Hour),
+			monday.Add(11*time.Hour + 30*time.Minute),
+		},
+	}
+
+	for i, tc := range cases {
+		t.Run(fmt.Sprintf("%d_%s", i, tc.name), func(t *testing.T) {
+			end, ok := blackoutEnd(&PrefixConfig{Blackouts: tc.blackouts}, tc.now)
+			if ok != !tc.exp.IsZero() || !end.Equal(tc.exp) {
+				t.Errorf("\nexp: %#v\nact: %#v", tc.exp, end)
+			}
+		})
+	}
+}
@@ -0,0 +1,251 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: MPL-2.0
+
+package replicate
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"math"
+	"math/rand"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/hashicorp/consul-template/config"
+	dep "github.com/hashicorp/consul-template/dependency"
+	"github.com/hashicorp/consul/api"
+	"github.com/pkg/errors"
+)
+
+// blockingQuery is a KV list query which waits for the configured amount of
+// time plus jitter, and which backs off while the source is under load. Its
+// request is cancelled as soon as it is stopped, instead of running until the
+// blocking query returns. A result larger than maxBytes is dropped and
+// reported as an error, so it is not held in memory.
+type blockingQuery struct {
+	*dep.KVListQuery
+
+	config    *BlockQueryConfig
+	backoff   *backoff
+	maxBytes  int
+	partition string
+	meter     *meter
+	client    *api.Client
+	ctx       context.Context
+	cancel    context.CancelFunc
+
+	// inherited is the index the prefixes of the watch were replicated up to
+	// by the process this one replaced, which its first query waits on.
+	inherited uint64
+}
+
+// blockingQuery wraps the query with the blocking query configuration, the
+// given maximum size of its result and the admin partition it reads. It reads
+// with the source client of the runner. Queries of the same datacenter share
+// their backoff.
+func (r *Runner) blockingQuery(d *dep.KVListQuery, maxBytes int, partition string) *blockingQuery {
+	r.backoffLock.Lock()
+	defer r.backoffLock.Unlock()
+
+	if r.backoffs == nil {
+		r.backoffs = make(map[string]*backoff)
+	}
+
+	// The datacenter is not exported by the query, but is part of its string
+	dc := kvListDatacenter(d)
+	b, ok := r.backoffs[dc]
+	if !ok {
+		b = &backoff{
+			dc:  dc,
+			min: config.TimeDurationVal(r.config.BlockQuery.Backoff),
+			max: config.TimeDurationVal(r.config.BlockQuery.MaxBackoff),
+		}
+		r.backoffs[dc] = b
+	}
+
+	m := r.meter(d.String())
+	ctx, cancel := context.WithCancel(withMeter(r.ctx, m))
+	return &blockingQuery{
+		KVListQuery: d,
+		config:      r.config.BlockQuery,
+		backoff:     b,
+		maxBytes:    maxBytes,
+		partition:   partition,
+		meter:       m,
+		client:      r.clients.Consul(),
+		ctx:         ctx,
+		cancel:      cancel,
+		inherited:   r.inheritedIndex(d.String()),
+	}
+}
+
+// Fetch waits out the backoff of the datacenter, then queries the source with
+// the configured wait time plus jitter. The client set of the watcher is not
+// used, as the query reads with the client of the runner.
+func (q *blockingQuery) Fetch(_ *dep.ClientSet, opts *dep.QueryOptions) (interface{}, *dep.ResponseMetadata, error) {
+	if delay := q.backoff.delay(); delay > 0 {
+		select {
+		case <-time.After(delay):
+		case <-q.ctx.Done():
+			return nil, nil, dep.ErrStopped
+		}
+	}
+
+	wait := config.TimeDurationVal(q.config.Wait)
+	if jitter := config.TimeDurationVal(q.config.Jitter); jitter > 0 {
+		wait += time.Duration(rand.Int63n(int64(jitter)))
+	}
+
+	// The first query after a handover only returns once the source changed
+	// since it was replicated, or the wait expires
+	next := &dep.QueryOptions{
+		Datacenter: kvListDatacenter(q.KVListQuery),
+		WaitTime:   wait,
+	}
+	if opts.WaitIndex == 0 && q.inherited > 0 {
+		next.WaitIndex = q.inherited
+		q.inherited = 0
+	}
+
+	data, rm, err := q.list(opts.Merge(next))
+	if err != nil && q.ctx.Err() != nil {
+		return nil, nil, dep.ErrStopped
+	}
+	if err == nil {
+		q.meter.query(rm.LastIndex)
+	} else {
+		q.meter.query(0)
+	}
+	q.backoff.observe(err)
+	return data, rm, err
+}
+
+// list lists the prefix of the query like the query itself, but bound to the
+// context and admin partition of the blocking query.
+func (q *blockingQuery) list(opts *dep.QueryOptions) ([]*dep.KeyPair, *dep.ResponseMetadata, error) {
+	prefix := kvListPrefix(q.KVListQuery)
+	list, qm, err := q.client.KV().List(prefix, opts.ToConsulOpts().WithContext(withPartition(q.ctx, q.partition)))
+	if err != nil {
+		return nil, nil, errors.Wrap(err, q.String())
+	}
+
+	pairs := make([]*dep.KeyPair, 0, len(list))
+	for _, pair := range list {
+		pairs = append(pairs, &dep.KeyPair{
+			Path:        pair.Key,
+			Key:         strings.TrimLeft(strings.TrimPrefix(pair.Key, prefix), "/"),
+			Value:       string(pair.Value),
+			CreateIndex: pair.CreateIndex,
+			ModifyIndex: pair.ModifyIndex,
+			LockIndex:   pair.LockIndex,
+			Flags:       pair.Flags,
+			Session:     pair.Session,
+		})
+	}
+	if size := treeBytes(pairs); q.maxBytes > 0 && size > q.maxBytes {
+		return nil, nil, errors.Wrap(fmt.Errorf("tree is %d bytes, over the max_tree_bytes of %d",
+			size, q.maxBytes), q.String())
+	}
+	return pairs, &dep.ResponseMetadata{
+		LastIndex:   qm.LastIndex,
+		LastContact: qm.LastContact,
+	}, nil
+}
+
+// Stop halts the query, including any backoff or request in progress.
+func (q *blockingQuery) Stop() {
+	q.cancel()
+	q.KVListQuery.Stop()
+}
+
+// kvListPrefix returns the prefix of the query.
+func kvListPrefix(d *dep.KVListQuery) string {
+	s := strings.TrimSuffix(strings.TrimPrefix(d.String(), "kv.list("), ")")
+	if i := strings.LastIndex(s, "@"); i >= 0 {
+		return s[:i]
+	}
+	return s
+}
+
+// kvListDatacenter returns the datacenter of the query.
+func kvListDatacenter(d *dep.KVListQuery) string {
+	s := strings.TrimSuffix(d.String(), ")")
+	if i := strings.LastIndex(s, "@"); i >= 0 {
+		return s[i+1:]
+	}
+	return ""
+}
+
+// checkBlockQuery returns an error if the maximum backoff is below the backoff
+// it caps.
+func checkBlockQuery(c *BlockQueryConfig) error {
+	backoff := config.TimeDurationVal(c.Backoff)
+	if max := config.TimeDurationVal(c.MaxBackoff); max != 0 && max < backoff {
+		return fmt.Errorf("block_query: max_backoff must be zero or at least backoff, "+
+			"got %s and %s", max, backoff)
+	}
+	return nil
+}
+
+// backoff is an adaptive delay which doubles every time the source reports it
+// is under load, and halves every time a query succeeds. A zero max leaves the
+// delay without an upper limit.
+type backoff struct {
+	sync.Mutex
+
+	dc       string
+	min, max time.Duration
+	current  time.Duration
+}
+
+// delay returns the current delay.
+func (b *backoff) delay() time.Duration {
+	b.Lock()
+	defer b.Unlock()
+	return b.current
+}
+
+// observe adjusts the delay to the outcome of a query.
+func (b *backoff) observe(err error) {
+	if b.min <= 0 {
+		return
+	}
+
+	b.Lock()
+	defer b.Unlock()
+
+	if err == nil {
+		if b.current /= 2; b.current < b.min {
+			if b.current > 0 {
+				log.Printf("[INFO] (runner) source %q recovered, backoff cleared", b.dc)
+			}
+			b.current = 0
+		}
+		return
+	}
+
+	if !underLoad(err) {
+		return
+	}
+
+	switch {
+	case b.current == 0:
+		b.current = b.min
+	case b.max > 0 && b.current*2 > b.max:
+		b.current = b.max
+	case b.current > math.MaxInt64/2:
+		// Without a max, the delay stops doubling before it overflows
+	default:
+		b.current *= 2
+	}
+	log.Printf("[WARN] (runner) source %q is under load, backing off %s: %s",
+		b.dc, b.current, err)
+}
+
+// underLoad returns true if the error is a 429 or 5xx response.
+func underLoad(err error) bool {
+	code := responseCode(err)
+	return code == 429 || code >= 500
+}
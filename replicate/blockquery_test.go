@@ -0,0 +1,222 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: MPL-2.0
+
+package replicate
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/hashicorp/consul-template/config"
+	dep "github.com/hashicorp/consul-template/dependency"
+)
+
+func TestBackoff_observe(t *testing.T) {
+	overloaded := errors.New("kv.list(foo@dc1): Unexpected response code: 429 (Too Many Requests)")
+	failed := errors.New("kv.list(foo@dc1): Unexpected response code: 500 (rpc error)")
+	denied := errors.New("kv.list(foo@dc1): Unexpected response code: 403 (Permission denied)")
+
+	cases := []struct {
+		name string
+		min  time.Duration
+		max  time.Duration
+		errs []error
+		exp  time.Duration
+	}{
+		{
+			"success",
+			time.Second,
+			10 * time.Second,
+			[]error{nil},
+			0,
+		},
+		{
+			"too_many_requests",
+			time.Second,
+			10 * time.Second,
+			[]error{overloaded},
+			time.Second,
+		},
+		{
+			"doubles",
+			time.Second,
+			10 * time.Second,
+			[]error{overloaded, failed, overloaded},
+			4 * time.Second,
+		},
+		{
+			"max",
+			time.Second,
+			10 * time.Second,
+			[]error{overloaded, overloaded, overloaded, overloaded, overloaded},
+			10 * time.Second,
+		},
+		{
+			"halves",
+			time.Second,
+			10 * time.Second,
+			[]error{overloaded, overloaded, overloaded, nil},
+			2 * time.Second,
+		},
+		{
+			"clears",
+			time.Second,
+			10 * time.Second,
+			[]error{overloaded, overloaded, nil, nil},
+			0,
+		},
+		{
+			"other_errors",
+			time.Second,
+			10 * time.Second,
+			[]error{denied},
+			0,
+		},
+		{
+			"disabled",
+			0,
+			10 * time.Second,
+			[]error{overloaded},
+			0,
+		},
+		{
+			"no_max",
+			time.Second,
+			0,
+			[]error{overloaded, overloaded, overloaded, overloaded, overloaded},
+			16 * time.Second,
+		},
+	}
+
+	for i, tc := range cases {
+		t.Run(fmt.Sprintf("%d_%s", i, tc.name), func(t *testing.T) {
+			b := &backoff{dc: "dc1", min: tc.min, max: tc.max}
+			for _, err := range tc.errs {
+				b.observe(err)
+			}
+
+			if act := b.delay(); act != tc.exp {
+				t.Errorf("\nexp: %#v\nact: %#v", tc.exp, act)
+			}
+		})
+	}
+}
+
+func TestCheckBlockQuery(t *testing.T) {
+	cases := []struct {
+		name    string
+		backoff time.Duration
+		max     time.Duration
+		err     bool
+	}{
+		{"valid", time.Second, time.Minute, false},
+		{"equal", time.Second, time.Second, false},
+		{"no_max", time.Second, 0, false},
+		{"below_backoff", time.Minute, time.Second, true},
+	}
+
+	for i, tc := range cases {
+		t.Run(fmt.Sprintf("%d_%s", i, tc.name), func(t *testing.T) {
+			err := checkBlockQuery(&BlockQueryConfig{
+				Backoff:    config.TimeDuration(tc.backoff),
+				MaxBackoff: config.TimeDuration(tc.max),
+			})
+			if (err != nil) != tc.err {
+				t.Fatal(err)
+			}
+		})
+	}
+}
+
+func TestBlockingQuery_Stop(t *testing.T) {
+	// The server holds every query until it is cancelled
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-r.Context().Done()
+	}))
+	defer srv.Close()
+
+	c := config.DefaultConsulConfig()
+	c.Address = config.String(srv.URL)
+	c.Finalize()
+	clients, err := newClientSet(c, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	d, err := dep.NewKVListQuery("global@dc1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	bq := DefaultBlockQueryConfig()
+	bq.Finalize()
+	r := &Runner{config: &Config{BlockQuery: bq}, ctx: context.Background(), clients: clients}
+	q := r.blockingQuery(d, 0, "")
+
+	errCh := make(chan error, 1)
+	go func() {
+		_, _, err := q.Fetch(nil, &dep.QueryOptions{})
+		errCh <- err
+	}()
+	time.Sleep(50 * time.Millisecond)
+	q.Stop()
+
+	select {
+	case err := <-errCh:
+		if err != dep.ErrStopped {
+			t.Errorf("\nexp: %#v\nact: %#v", dep.ErrStopped, err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("query was not cancelled")
+	}
+}
+
+func TestBlockingQuery_inherited(t *testing.T) {
+	// The server records the index of every query
+	indexCh := make(chan string, 3)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		indexCh <- r.URL.Query().Get("index")
+		w.Header().Set("X-Consul-Index", "10")
+		w.Write([]byte("[]"))
+	}))
+	defer srv.Close()
+
+	c := config.DefaultConsulConfig()
+	c.Address = config.String(srv.URL)
+	c.Finalize()
+	clients, err := newClientSet(c, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	d, err := dep.NewKVListQuery("global@dc1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	bq := DefaultBlockQueryConfig()
+	bq.Finalize()
+	r := &Runner{config: &Config{BlockQuery: bq}, ctx: context.Background(), clients: clients}
+	r.inherit(&GroupHandover{Indexes: map[string]uint64{d.String(): 7}})
+	q := r.blockingQuery(d, 0, "")
+
+	// Only the first query waits on the inherited index, later ones on the
+	// index of the previous response
+	for _, wait := range []uint64{0, 10, 0} {
+		if _, _, err := q.Fetch(nil, &dep.QueryOptions{WaitIndex: wait}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	close(indexCh)
+
+	var act []string
+	for index := range indexCh {
+		act = append(act, index)
+	}
+	if exp := []string{"7", "10", ""}; fmt.Sprint(exp) != fmt.Sprint(act) {
+		t.Errorf("\nexp: %#v\nact: %#v", exp, act)
+	}
+}
@@ -0,0 +1,116 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: MPL-2.0
+
+package replicate
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/hashicorp/consul-template/config"
+	dep "github.com/hashicorp/consul-template/dependency"
+)
+
+// limitError is returned when the source tree of a prefix is over one of its
+// limits, so the pass is refused and the limit alert fires.
+type limitError struct {
+	msg string
+}
+
+func (e *limitError) Error() string {
+	return e.msg
+}
+
+// emptySourceError is returned when the source of a prefix whose
+// on_source_empty is "fail" is empty, so the pass is refused instead of
+// deleting every destination key, and the empty alert fires.
+type emptySourceError struct {
+	msg string
+}
+
+func (e *emptySourceError) Error() string {
+	return e.msg
+}
+
+// checkLimits returns an error if the tree of the pairs is over one of the
+// limits of the prefix.
+func checkLimits(prefix *PrefixConfig, pairs []*dep.KeyPair) error {
+	if max := config.IntVal(prefix.MaxKeys); max > 0 && len(pairs) > max {
+		return &limitError{fmt.Sprintf("source tree of %s has %d keys, over the max_keys of %d",
+			prefix.Dependency, len(pairs), max)}
+	}
+
+	if max := config.IntVal(prefix.MaxDepth); max > 0 {
+		source := config.StringVal(prefix.Source)
+		for _, pair := range pairs {
+			if depth := keyDepth(source, pair.Path); depth > max {
+				return &limitError{fmt.Sprintf("source tree of %s has key %q at depth %d, "+
+					"over the max_depth of %d", prefix.Dependency, pair.Path, depth, max)}
+			}
+		}
+	}
+
+	return checkTreeBytes(prefix, pairs)
+}
+
+// keyDepth returns the number of path segments of the key below the source.
+// The trailing slash of folder keys is not a segment.
+func keyDepth(source, key string) int {
+	rel := strings.Trim(strings.TrimPrefix(key, source), "/")
+	if rel == "" {
+		return 0
+	}
+	return strings.Count(rel, "/") + 1
+}
+
+// treeBytes returns the size of the tree of the pairs, which is the sum of the
+// lengths of their keys and values.
+func treeBytes(pairs []*dep.KeyPair) int {
+	size := 0
+	for _, pair := range pairs {
+		size += len(pair.Path) + len(pair.Value)
+	}
+	return size
+}
+
+// checkTreeBytes returns an error if the tree of the pairs is larger than the
+// budget of the prefix.
+func checkTreeBytes(prefix *PrefixConfig, pairs []*dep.KeyPair) error {
+	max := config.IntVal(prefix.MaxTreeBytes)
+	if max <= 0 {
+		return nil
+	}
+	if size := treeBytes(pairs); size > max {
+		return &limitError{fmt.Sprintf("source tree of %s is %d bytes, over the max_tree_bytes of %d",
+			prefix.Dependency, size, max)}
+	}
+	return nil
+}
+
+// watchBudget returns the maximum size of the tree held by the watch, which is
+// the largest budget of the prefixes it watches. Prefixes within another
+// prefix are covered by the budget of the outer one. The watch is not limited
+// if one of its prefixes has no budget, or if it watches the parent folder of
+// coalesced prefixes, which may also hold keys of no prefix.
+func watchBudget(d *dep.KVListQuery, prefixes []*PrefixConfig, watches map[string]*dep.KVListQuery) int {
+	budget, found := 0, false
+	for _, prefix := range prefixes {
+		id := prefix.Dependency.String()
+		if w, ok := watches[id]; !ok || w.String() != d.String() || id != d.String() {
+			continue
+		}
+
+		max := config.IntVal(prefix.MaxTreeBytes)
+		if max <= 0 {
+			return 0
+		}
+		if max > budget {
+			budget = max
+		}
+		found = true
+	}
+	if !found {
+		return 0
+	}
+	return budget
+}
@@ -0,0 +1,161 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: MPL-2.0
+
+package replicate
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/hashicorp/consul-template/config"
+	dep "github.com/hashicorp/consul-template/dependency"
+)
+
+func TestCheckLimits(t *testing.T) {
+	cases := []struct {
+		name     string
+		keys     []string
+		maxKeys  int
+		maxDepth int
+		err      string
+	}{
+		{
+			"unlimited",
+			[]string{"global/a", "global/b/c/d"},
+			0,
+			0,
+			"",
+		},
+		{
+			"max_keys",
+			[]string{"global/a", "global/b", "global/c"},
+			2,
+			0,
+			"has 3 keys, over the max_keys of 2",
+		},
+		{
+			"max_keys_equal",
+			[]string{"global/a", "global/b"},
+			2,
+			0,
+			"",
+		},
+		{
+			"max_depth",
+			[]string{"global/a", "global/b/c/d"},
+			0,
+			2,
+			`has key "global/b/c/d" at depth 3, over the max_depth of 2`,
+		},
+		{
+			"max_depth_folder",
+			[]string{"global/a/b/"},
+			0,
+			2,
+			"",
+		},
+	}
+
+	for i, tc := range cases {
+		t.Run(fmt.Sprintf("%d_%s", i, tc.name), func(t *testing.T) {
+			prefix, err := ParsePrefixConfig("global@dc1")
+			if err != nil {
+				t.Fatal(err)
+			}
+			prefix.MaxKeys = config.Int(tc.maxKeys)
+			prefix.MaxDepth = config.Int(tc.maxDepth)
+			prefix.Finalize()
+
+			var pairs []*dep.KeyPair
+			for _, key := range tc.keys {
+				pairs = append(pairs, &dep.KeyPair{Path: key})
+			}
+
+			err = checkLimits(prefix, pairs)
+			if tc.err == "" {
+				if err != nil {
+					t.Fatal(err)
+				}
+				return
+			}
+			if _, ok := err.(*limitError); !ok || !strings.Contains(err.Error(), tc.err) {
+				t.Errorf("\nexp: %#v\nact: %#v", tc.err, err)
+			}
+		})
+	}
+}
+
+func TestWatchBudget(t *testing.T) {
+	cases := []struct {
+		name     string
+		prefixes []string
+		budgets  []int
+		coalesce bool
+		exp      int
+	}{
+		{
+			"unlimited",
+			[]string{"global@dc1"},
+			[]int{0},
+			false,
+			0,
+		},
+		{
+			"limited",
+			[]string{"global@dc1"},
+			[]int{100},
+			false,
+			100,
+		},
+		{
+			"nested",
+			[]string{"global@dc1", "global/a@dc1"},
+			[]int{100, 0},
+			true,
+			100,
+		},
+		{
+			"shared_largest",
+			[]string{"global@dc1", "global@dc1:copy"},
+			[]int{100, 200},
+			false,
+			200,
+		},
+		{
+			"shared_unlimited",
+			[]string{"global@dc1", "global@dc1:copy"},
+			[]int{100, 0},
+			false,
+			0,
+		},
+		{
+			"coalesced_parent",
+			[]string{"global/a@dc1", "global/b@dc1"},
+			[]int{100, 100},
+			true,
+			0,
+		},
+	}
+
+	for i, tc := range cases {
+		t.Run(fmt.Sprintf("%d_%s", i, tc.name), func(t *testing.T) {
+			var prefixes []*PrefixConfig
+			for j, s := range tc.prefixes {
+				prefix, err := ParsePrefixConfig(s)
+				if err != nil {
+					t.Fatal(err)
+				}
+				prefix.MaxTreeBytes = config.Int(tc.budgets[j])
+				prefixes = append(prefixes, prefix)
+			}
+
+			r := &Runner{config: &Config{CoalesceWatches: config.Bool(tc.coalesce)}}
+			watches := r.watchesFor(prefixes)
+			d := watches[prefixes[0].Dependency.String()]
+			if act := watchBudget(d, prefixes, watches); act != tc.exp {
+				t.Errorf("\nexp: %#v\nact: %#v", tc.exp, act)
+			}
+		})
+	}
+}
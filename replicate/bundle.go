@@ -0,0 +1,469 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: MPL-2.0
+
+package replicate
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/hashicorp/consul-replicate/version"
+	"github.com/hashicorp/consul-template/config"
+	dep "github.com/hashicorp/consul-template/dependency"
+	"github.com/hashicorp/consul/api"
+	"github.com/pkg/errors"
+)
+
+const (
+	// bundleMeta and bundleKV are the names of the archive members of a bundle.
+	bundleMeta = "meta.json"
+	bundleKV   = "kv.json"
+)
+
+// BundleMeta describes an exported bundle.
+type BundleMeta struct {
+	// Version is the version of Consul Replicate which wrote the bundle.
+	Version string
+
+	// Timestamp is when the bundle was exported.
+	Timestamp time.Time
+
+	// Indexes are the source indexes the bundle was exported at, keyed by
+	// datacenter.
+	Indexes map[string]uint64
+
+	// Base are the indexes of the previous export a delta bundle applies on
+	// top of, keyed by datacenter. It is nil for full bundles.
+	Base map[string]uint64 `json:",omitempty"`
+}
+
+// ExportState records what was exported, so the next export can be a delta.
+type ExportState struct {
+	// Indexes are the source indexes of the export, keyed by datacenter.
+	Indexes map[string]uint64
+
+	// Keys are the modify indexes of the exported keys, keyed by datacenter
+	// and path.
+	Keys map[string]map[string]uint64
+}
+
+// bundleEntry is a single exported source key.
+type bundleEntry struct {
+	Datacenter  string `json:",omitempty"`
+	Key         string
+	Value       []byte
+	Flags       uint64
+	CreateIndex uint64
+	ModifyIndex uint64
+
+	// Deleted is true if the key was deleted since the previous export.
+	Deleted bool `json:",omitempty"`
+}
+
+// Export writes a bundle with the source keys of every configured prefix,
+// less excluded keys, so it can be imported where there is no network path to
+// the source. If the state of a previous export is given, the bundle is a
+// delta which only contains the keys changed or deleted since. The state of
+// this export is returned.
+func (r *Runner) Export(w io.Writer, since *ExportState) (*ExportState, error) {
+	meta := &BundleMeta{
+		Version:   version.Version,
+		Timestamp: time.Now().UTC(),
+		Indexes:   make(map[string]uint64),
+	}
+	state := &ExportState{
+		Indexes: meta.Indexes,
+		Keys:    make(map[string]map[string]uint64),
+	}
+	if since != nil {
+		meta.Base = since.Indexes
+	}
+
+	seen := make(map[string]struct{})
+	var entries []*bundleEntry
+	for _, prefix := range *r.config.Prefixes {
+		prefixes := []*PrefixConfig{prefix}
+		if prefix.IsWildcard() {
+			var err error
+			if prefixes, err = r.expand(prefix); err != nil {
+				return nil, err
+			}
+		}
+
+		for _, prefix := range prefixes {
+			dc := config.StringVal(prefix.Datacenter)
+			pairs, index, err := r.sourceFor(prefix).List(config.StringVal(prefix.Source), dc)
+			if err != nil {
+				return nil, errors.Wrapf(err, "exporting %q", prefix.Dependency)
+			}
+
+			if index > meta.Indexes[dc] {
+				meta.Indexes[dc] = index
+			}
+
+			for _, pair := range pairs {
+				if r.excluded(pair.Path) {
+					continue
+				}
+
+				id := dc + "\x00" + pair.Path
+				if _, ok := seen[id]; ok {
+					continue
+				}
+				seen[id] = struct{}{}
+
+				if state.Keys[dc] == nil {
+					state.Keys[dc] = make(map[string]uint64)
+				}
+				state.Keys[dc][pair.Path] = pair.ModifyIndex
+
+				// Unchanged keys are left out of deltas
+				if since != nil {
+					if index, ok := since.Keys[dc][pair.Path]; ok && index == pair.ModifyIndex {
+						continue
+					}
+				}
+
+				entries = append(entries, &bundleEntry{
+					Datacenter:  dc,
+					Key:         pair.Path,
+					Value:       []byte(pair.Value),
+					Flags:       pair.Flags,
+					CreateIndex: pair.CreateIndex,
+					ModifyIndex: pair.ModifyIndex,
+				})
+			}
+			log.Printf("[INFO] (runner) exported %d keys of %q", len(pairs), prefix.Dependency)
+		}
+	}
+
+	// Keys which were exported before but no longer exist were deleted
+	if since != nil {
+		for dc, keys := range since.Keys {
+			for key := range keys {
+				if _, ok := state.Keys[dc][key]; ok {
+					continue
+				}
+				entries = append(entries, &bundleEntry{
+					Datacenter: dc,
+					Key:        key,
+					Deleted:    true,
+				})
+			}
+		}
+
+		// Datacenters which are no longer exported keep their index, so the
+		// base of the next delta matches what was imported
+		for dc, index := range since.Indexes {
+			if _, ok := meta.Indexes[dc]; !ok {
+				meta.Indexes[dc] = index
+			}
+		}
+	}
+
+	if err := writeBundle(w, meta, entries); err != nil {
+		return nil, err
+	}
+	return state, nil
+}
+
+// Import replays a bundle written by Export through the configured prefixes,
+// excludes and middleware into the destination, in a single pass.
+func (r *Runner) Import(rd io.Reader) error {
+	meta, snapshots, err := readBundle(rd)
+	if err != nil {
+		return err
+	}
+	log.Printf("[INFO] (runner) importing bundle exported by %s at %s",
+		meta.Version, meta.Timestamp)
+
+	// A datacenter missing from the bundle would look like an empty source
+	for _, prefix := range *r.config.Prefixes {
+		if prefix.IsDatacenterWildcard() {
+			continue
+		}
+		if _, ok := snapshots[config.StringVal(prefix.Datacenter)]; !ok {
+			return fmt.Errorf("bundle: no keys exported for %q", config.StringVal(prefix.Source))
+		}
+	}
+
+	// A delta must apply on top of exactly what was last imported
+	imported, err := r.getImported()
+	if err != nil {
+		return errors.Wrap(err, "bundle: reading last import")
+	}
+	if meta.Base != nil {
+		for dc, index := range meta.Indexes {
+			if imported[dc] == index && index != meta.Base[dc] {
+				return fmt.Errorf("bundle: delta for %q at index %d was already imported", dc, index)
+			}
+			if imported[dc] != meta.Base[dc] {
+				return fmt.Errorf("bundle: delta for %q applies on top of index %d, "+
+					"but the last import was at index %d", dc, meta.Base[dc], imported[dc])
+			}
+		}
+	}
+
+	r.snapshots = snapshots
+	if err := r.discover(); err != nil {
+		return err
+	}
+	if err := r.Run(); err != nil {
+		return err
+	}
+
+	for dc, index := range meta.Indexes {
+		imported[dc] = index
+	}
+	if err := r.setImported(imported); err != nil {
+		return errors.Wrap(err, "bundle: recording import")
+	}
+	return nil
+}
+
+// importedPath is the key where the indexes of the last imported bundle are
+// stored.
+func (r *Runner) importedPath() string {
+	return strings.TrimRight(config.StringVal(r.config.StatusDir), "/") + "/imports"
+}
+
+// importedBackend returns the backend the indexes of the last imported bundle
+// are stored in, which is the destination Consul unless the statuses are
+// stored elsewhere.
+func (r *Runner) importedBackend() Backend {
+	if r.statusStore != nil {
+		return r.statusStore
+	}
+	return r.backends[BackendConsul]
+}
+
+// getImported reads the indexes of the last imported bundle, keyed by
+// datacenter.
+func (r *Runner) getImported() (map[string]uint64, error) {
+	imported := make(map[string]uint64)
+	pair, err := r.importedBackend().Get(r.importedPath())
+	if err != nil {
+		return nil, err
+	}
+	if pair != nil {
+		if err := json.Unmarshal(pair.Value, &imported); err != nil {
+			return nil, err
+		}
+	}
+	return imported, nil
+}
+
+// setImported records the indexes of the last imported bundle.
+func (r *Runner) setImported(imported map[string]uint64) error {
+	enc, err := json.Marshal(imported)
+	if err != nil {
+		return err
+	}
+	return r.importedBackend().Put(&api.KVPair{
+		Key:   r.importedPath(),
+		Value: enc,
+	})
+}
+
+// deltaDeletes returns the destination keys of the source keys of the prefix
+// which a delta bundle deleted.
+func (r *Runner) deltaDeletes(prefix *PrefixConfig, s *snapshot) ([]string, error) {
+	source := config.StringVal(prefix.Source)
+
+	var keys []string
+	for _, path := range s.deleted {
+		if !strings.HasPrefix(path, source) {
+			continue
+		}
+
+		key := config.StringVal(prefix.Destination) + strings.TrimPrefix(path, source)
+		if len(*prefix.Middlewares) > 0 {
+			newKey, _, skip, err := r.process(prefix, key, nil, &Meta{
+				Source:      source,
+				Datacenter:  config.StringVal(prefix.Datacenter),
+				Destination: config.StringVal(prefix.Destination),
+				Path:        path,
+			})
+			if err != nil {
+				return nil, fmt.Errorf("failed to process %q: %s", path, err)
+			}
+			if skip {
+				continue
+			}
+			key = newKey
+		}
+		keys = append(keys, key)
+	}
+	return keys, nil
+}
+
+// destinationTree reads the keys and values of the destination prefix, less
+// excluded keys.
+func (r *Runner) destinationTree(backend Backend, prefix *PrefixConfig) (map[string][]byte, error) {
+	keys, err := r.destinationKeys(backend, prefix)
+	if err != nil {
+		return nil, err
+	}
+
+	tree := make(map[string][]byte, len(keys))
+	for _, key := range keys {
+		sourceKey := strings.Replace(key, config.StringVal(prefix.Destination), config.StringVal(prefix.Source), -1)
+		if r.excluded(sourceKey) || r.isTombstone(key) {
+			continue
+		}
+
+		pair, err := backend.Get(key)
+		if err != nil {
+			return nil, err
+		}
+		if pair != nil {
+			tree[key] = pair.Value
+		}
+	}
+	return tree, nil
+}
+
+// writeBundle writes the bundle archive, with a checksum of every member.
+func writeBundle(w io.Writer, meta *BundleMeta, entries []*bundleEntry) error {
+	metaJSON, err := json.MarshalIndent(meta, "", "  ")
+	if err != nil {
+		return err
+	}
+	kvJSON, err := json.Marshal(entries)
+	if err != nil {
+		return err
+	}
+
+	files := []struct {
+		name string
+		data []byte
+	}{
+		{bundleMeta, metaJSON},
+		{bundleKV, kvJSON},
+	}
+
+	var sums strings.Builder
+	for _, f := range files {
+		fmt.Fprintf(&sums, "%x  %s\n", sha256.Sum256(f.data), f.name)
+	}
+	files = append(files, struct {
+		name string
+		data []byte
+	}{snapshotSums, []byte(sums.String())})
+
+	gz := gzip.NewWriter(w)
+	archive := tar.NewWriter(gz)
+	for _, f := range files {
+		if err := archive.WriteHeader(&tar.Header{
+			Name:    f.name,
+			Mode:    0600,
+			Size:    int64(len(f.data)),
+			ModTime: meta.Timestamp,
+		}); err != nil {
+			return err
+		}
+		if _, err := archive.Write(f.data); err != nil {
+			return err
+		}
+	}
+	if err := archive.Close(); err != nil {
+		return err
+	}
+	return gz.Close()
+}
+
+// readBundle reads and verifies a bundle archive, returning the exported keys
+// as a snapshot per datacenter.
+func readBundle(r io.Reader) (*BundleMeta, map[string]*snapshot, error) {
+	gz, err := gzip.NewReader(r)
+	if err != nil {
+		return nil, nil, errors.Wrap(err, "bundle: decompressing")
+	}
+	defer gz.Close()
+
+	files := make(map[string][]byte)
+	archive := tar.NewReader(gz)
+	for {
+		hdr, err := archive.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, nil, errors.Wrap(err, "bundle: reading archive")
+		}
+
+		if files[hdr.Name], err = ioutil.ReadAll(archive); err != nil {
+			return nil, nil, errors.Wrapf(err, "bundle: reading %s", hdr.Name)
+		}
+	}
+
+	// Every member must be present with a matching checksum
+	sums := make(map[string]string)
+	for _, line := range strings.Split(string(files[snapshotSums]), "\n") {
+		if f := strings.Fields(line); len(f) == 2 {
+			sums[f[1]] = f[0]
+		}
+	}
+	for _, name := range []string{bundleMeta, bundleKV} {
+		data, ok := files[name]
+		if !ok {
+			return nil, nil, fmt.Errorf("bundle: missing %s", name)
+		}
+		sum := sha256.Sum256(data)
+		if sums[name] != hex.EncodeToString(sum[:]) {
+			return nil, nil, fmt.Errorf("bundle: checksum mismatch for %s", name)
+		}
+	}
+
+	var meta BundleMeta
+	if err := json.Unmarshal(files[bundleMeta], &meta); err != nil {
+		return nil, nil, errors.Wrap(err, "bundle: decoding metadata")
+	}
+
+	var entries []*bundleEntry
+	if err := json.Unmarshal(files[bundleKV], &entries); err != nil {
+		return nil, nil, errors.Wrap(err, "bundle: decoding keys")
+	}
+
+	snapshots := make(map[string]*snapshot)
+	for dc, index := range meta.Indexes {
+		snapshots[dc] = &snapshot{index: index}
+	}
+	for _, e := range entries {
+		s, ok := snapshots[e.Datacenter]
+		if !ok {
+			return nil, nil, fmt.Errorf("bundle: no index for datacenter %q", e.Datacenter)
+		}
+		if e.Deleted {
+			s.deleted = append(s.deleted, e.Key)
+			continue
+		}
+		s.pairs = append(s.pairs, &dep.KeyPair{
+			Path:        e.Key,
+			Key:         e.Key,
+			Value:       string(e.Value),
+			CreateIndex: e.CreateIndex,
+			ModifyIndex: e.ModifyIndex,
+			Flags:       e.Flags,
+		})
+	}
+	for _, s := range snapshots {
+		s.delta = meta.Base != nil
+		sort.Strings(s.deleted)
+		sort.Slice(s.pairs, func(i, j int) bool {
+			return s.pairs[i].Path < s.pairs[j].Path
+		})
+	}
+
+	return &meta, snapshots, nil
+}
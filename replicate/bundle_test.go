@@ -0,0 +1,108 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: MPL-2.0
+
+package replicate
+
+import (
+	"bytes"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	dep "github.com/hashicorp/consul-template/dependency"
+)
+
+func TestBundle(t *testing.T) {
+	meta := &BundleMeta{
+		Version:   "1.2.3",
+		Timestamp: time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC),
+		Indexes:   map[string]uint64{"": 10, "dc2": 20},
+	}
+
+	var buf bytes.Buffer
+	if err := writeBundle(&buf, meta, []*bundleEntry{
+		{Key: "foo/b", Value: []byte("2"), ModifyIndex: 9},
+		{Key: "foo/a", Value: []byte("\x00\x01"), Flags: 4},
+		{Datacenter: "dc2", Key: "bar", Value: []byte("3")},
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	actMeta, snapshots, err := readBundle(bytes.NewReader(buf.Bytes()))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !reflect.DeepEqual(meta, actMeta) {
+		t.Errorf("\nexp: %#v\nact: %#v", meta, actMeta)
+	}
+
+	expected := map[string]*snapshot{
+		"": {index: 10, pairs: []*dep.KeyPair{
+			{Path: "foo/a", Key: "foo/a", Value: "\x00\x01", Flags: 4},
+			{Path: "foo/b", Key: "foo/b", Value: "2", ModifyIndex: 9},
+		}},
+		"dc2": {index: 20, pairs: []*dep.KeyPair{
+			{Path: "bar", Key: "bar", Value: "3"},
+		}},
+	}
+	if !reflect.DeepEqual(expected, snapshots) {
+		t.Errorf("\nexp: %#v\nact: %#v", expected, snapshots)
+	}
+
+	// Truncated bundles must be rejected
+	if _, _, err := readBundle(bytes.NewReader(buf.Bytes()[:buf.Len()/2])); err == nil {
+		t.Error("expected error reading truncated bundle")
+	}
+
+	// An entry for a datacenter without an index is invalid
+	buf.Reset()
+	if err := writeBundle(&buf, meta, []*bundleEntry{
+		{Datacenter: "dc3", Key: "baz"},
+	}); err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err := readBundle(&buf); err == nil || !strings.Contains(err.Error(), "dc3") {
+		t.Errorf("expected missing datacenter error, got %v", err)
+	}
+}
+
+func TestBundle_delta(t *testing.T) {
+	meta := &BundleMeta{
+		Version:   "1.2.3",
+		Timestamp: time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC),
+		Indexes:   map[string]uint64{"dc1": 30},
+		Base:      map[string]uint64{"dc1": 20},
+	}
+
+	var buf bytes.Buffer
+	if err := writeBundle(&buf, meta, []*bundleEntry{
+		{Datacenter: "dc1", Key: "apps/a/config", Value: []byte("1"), ModifyIndex: 25},
+		{Datacenter: "dc1", Key: "apps/b/config", Deleted: true},
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	_, snapshots, err := readBundle(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := &snapshot{
+		index: 30,
+		pairs: []*dep.KeyPair{
+			{Path: "apps/a/config", Key: "apps/a/config", Value: "1", ModifyIndex: 25},
+		},
+		delta:   true,
+		deleted: []string{"apps/b/config"},
+	}
+	if !reflect.DeepEqual(expected, snapshots["dc1"]) {
+		t.Errorf("\nexp: %#v\nact: %#v", expected, snapshots["dc1"])
+	}
+
+	// Folders which only had deletions are still discovered
+	if exp, act := []string{"apps/a/", "apps/b/"}, snapshots["dc1"].keys("apps/"); !reflect.DeepEqual(exp, act) {
+		t.Errorf("\nexp: %#v\nact: %#v", exp, act)
+	}
+}
@@ -0,0 +1,62 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: MPL-2.0
+
+package replicate
+
+import (
+	"sync"
+	"time"
+)
+
+// cache holds the results of non-blocking reads for a while, which spares the
+// servers when there is no local agent to answer them. A nil cache or a zero
+// TTL always reads through.
+type cache struct {
+	sync.Mutex
+
+	ttl     time.Duration
+	entries map[string]*cacheEntry
+
+	// now returns the current time, and is replaced in tests.
+	now func() time.Time
+}
+
+// cacheEntry is a cached result and when it expires.
+type cacheEntry struct {
+	value   interface{}
+	expires time.Time
+}
+
+// newCache creates a cache whose entries live for the given TTL.
+func newCache(ttl time.Duration) *cache {
+	return &cache{
+		ttl:     ttl,
+		entries: make(map[string]*cacheEntry),
+		now:     time.Now,
+	}
+}
+
+// get returns the cached result for the key, or calls fn and caches its
+// result. Errors are not cached.
+func (c *cache) get(key string, fn func() (interface{}, error)) (interface{}, error) {
+	if c == nil || c.ttl <= 0 {
+		return fn()
+	}
+
+	c.Lock()
+	defer c.Unlock()
+
+	if e, ok := c.entries[key]; ok && c.now().Before(e.expires) {
+		return e.value, nil
+	}
+
+	value, err := fn()
+	if err != nil {
+		return nil, err
+	}
+	c.entries[key] = &cacheEntry{
+		value:   value,
+		expires: c.now().Add(c.ttl),
+	}
+	return value, nil
+}
@@ -0,0 +1,48 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: MPL-2.0
+
+package replicate
+
+import (
+	"fmt"
+	"testing"
+	"time"
+)
+
+func TestCache_get(t *testing.T) {
+	now := time.Now()
+	c := newCache(time.Minute)
+	c.now = func() time.Time { return now }
+
+	calls := 0
+	fn := func() (interface{}, error) {
+		calls++
+		return calls, nil
+	}
+
+	if v, _ := c.get("key", fn); v != 1 {
+		t.Errorf("expected 1, got %v", v)
+	}
+	if v, _ := c.get("key", fn); v != 1 {
+		t.Errorf("expected cached 1, got %v", v)
+	}
+
+	now = now.Add(time.Minute)
+	if v, _ := c.get("key", fn); v != 2 {
+		t.Errorf("expected expired entry to be read again, got %v", v)
+	}
+
+	if _, err := c.get("error", func() (interface{}, error) {
+		return nil, fmt.Errorf("boom")
+	}); err == nil {
+		t.Errorf("expected error")
+	}
+	if v, _ := c.get("error", fn); v != 3 {
+		t.Errorf("expected error not to be cached, got %v", v)
+	}
+
+	var disabled *cache
+	if v, _ := disabled.get("key", fn); v != 4 {
+		t.Errorf("expected nil cache to read through, got %v", v)
+	}
+}
@@ -0,0 +1,339 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: MPL-2.0
+
+package replicate
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"os"
+	"os/exec"
+	"strings"
+
+	"github.com/hashicorp/consul-template/config"
+	"github.com/hashicorp/consul/api"
+	"github.com/mattn/go-shellwords"
+	"github.com/pkg/errors"
+)
+
+// canaryTxnOps is the maximum number of operations Consul accepts in a single
+// transaction.
+const canaryTxnOps = 64
+
+// txnBackend is a Backend which can apply several writes atomically.
+type txnBackend interface {
+	Backend
+
+	// Txn writes the pairs and deletes the keys in a single transaction.
+	Txn(puts []*api.KVPair, deletes []string) error
+}
+
+// canaryRequest describes the staged changes of a prefix to the validation
+// hooks. It is the body POSTed to the URL, and the standard input of the
+// command.
+type canaryRequest struct {
+	Source      string    `json:"source"`
+	Datacenter  string    `json:"datacenter"`
+	Destination string    `json:"destination"`
+	Staging     string    `json:"staging"`
+	Changes     []*Change `json:"changes"`
+}
+
+// stagingDestination returns the destination the changes of the prefix are
+// staged into.
+func stagingDestination(prefix *PrefixConfig) string {
+	if staging := config.StringVal(prefix.Canary.Staging); staging != "" {
+		return staging
+	}
+
+	destination := config.StringVal(prefix.Destination)
+	staging := strings.TrimRight(destination, "/") + canaryStagingSuffix
+	if strings.HasSuffix(destination, "/") {
+		staging += "/"
+	}
+	return staging
+}
+
+// checkCanary returns an error if the staging destination of a canary prefix
+// would list the keys of its live destination. The staging destination itself
+// may be within the live one, as its keys are never promoted.
+func checkCanary(prefix *PrefixConfig) error {
+	if !config.BoolVal(prefix.Canary.Enabled) {
+		return nil
+	}
+
+	if prefix.IsWildcard() {
+		if config.StringVal(prefix.Canary.Staging) != "" {
+			return fmt.Errorf("canary staging of wildcard prefix %q cannot be set",
+				config.StringVal(prefix.Source))
+		}
+		return nil
+	}
+
+	staging := stagingDestination(prefix)
+	if strings.HasPrefix(config.StringVal(prefix.Destination), staging) {
+		return fmt.Errorf("canary staging %q of prefix %q contains its destination",
+			staging, config.StringVal(prefix.Source))
+	}
+	return nil
+}
+
+// replicateCanary replicates the prefix into its staging destination and, if
+// the staged tree differs from the live one, runs the validation hooks and
+// promotes the staged tree. The event reports the promoted changes.
+func (r *Runner) replicateCanary(ctx context.Context, prefix *PrefixConfig, excludes *ExcludeConfigs, event *Event) error {
+	staged := prefix.Copy()
+	staged.Destination = config.String(stagingDestination(prefix))
+	if err := r.replicatePrefix(ctx, staged, excludes, event); err != nil {
+		return err
+	}
+
+	backend := bindContext(r.backend(prefix), ctx)
+	if !r.destinationSupports(featureTxn) {
+		backend = &nonTxnBackend{backend}
+	}
+	puts, deletes, changes, err := r.canaryDiff(backend, prefix, event.Index)
+	if err != nil {
+		return err
+	}
+	event.Changes, event.Updates, event.Deletes = nil, 0, 0
+	if len(changes) == 0 {
+		return nil
+	}
+
+	req := &canaryRequest{
+		Source:      config.StringVal(prefix.Source),
+		Datacenter:  config.StringVal(prefix.Datacenter),
+		Destination: config.StringVal(prefix.Destination),
+		Staging:     config.StringVal(staged.Destination),
+		Changes:     changes,
+	}
+	if err := validateCanary(prefix.Canary, req); err != nil {
+		return fmt.Errorf("%d staged changes rejected: %s", len(changes), err)
+	}
+
+	if err := r.claimDestination(prefix, req.Destination); err != nil {
+		return err
+	}
+	if err := promote(backend, puts, deletes); err != nil {
+		return fmt.Errorf("failed to promote %q: %s", req.Staging, err)
+	}
+	log.Printf("[INFO] (runner) promoted %d updates, %d deletes from %q",
+		len(puts), len(deletes), req.Staging)
+
+	event.Changes, event.Updates, event.Deletes = changes, len(puts), len(deletes)
+	return nil
+}
+
+// canaryDiff compares the staged tree of the prefix with its live tree, and
+// returns the writes and deletes which make the live tree match.
+func (r *Runner) canaryDiff(backend Backend, prefix *PrefixConfig, index uint64) ([]*api.KVPair, []string, []*Change, error) {
+	staging := stagingDestination(prefix)
+	destination := config.StringVal(prefix.Destination)
+
+	stagedKeys, err := backend.Keys(staging)
+	if err != nil {
+		return nil, nil, nil, fmt.Errorf("failed to list keys: %s", err)
+	}
+	liveKeys, err := backend.Keys(destination)
+	if err != nil {
+		return nil, nil, nil, fmt.Errorf("failed to list keys: %s", err)
+	}
+
+	var puts []*api.KVPair
+	var deletes []string
+	var changes []*Change
+
+	staged := make(map[string]struct{}, len(stagedKeys))
+	for _, stagedKey := range stagedKeys {
+		key := destination + strings.TrimPrefix(stagedKey, staging)
+		if r.reserved(key) {
+			continue
+		}
+		staged[key] = struct{}{}
+
+		pair, err := backend.Get(stagedKey)
+		if err != nil {
+			return nil, nil, nil, fmt.Errorf("failed to read %q: %s", stagedKey, err)
+		}
+		if pair == nil {
+			continue
+		}
+
+		current, err := backend.Get(key)
+		if err != nil {
+			return nil, nil, nil, fmt.Errorf("failed to read %q: %s", key, err)
+		}
+
+		change := &Change{
+			Source:     config.StringVal(prefix.Source),
+			Datacenter: config.StringVal(prefix.Datacenter),
+			Key:        key,
+			Op:         ChangePut,
+			NewHash:    valueHash(pair.Value),
+			Index:      index,
+		}
+		if current != nil {
+			if current.Flags == pair.Flags && bytes.Equal(current.Value, pair.Value) {
+				continue
+			}
+			change.OldHash = valueHash(current.Value)
+		}
+
+		puts = append(puts, &api.KVPair{Key: key, Flags: pair.Flags, Value: pair.Value})
+		changes = append(changes, change)
+	}
+
+	for _, key := range liveKeys {
+		if _, ok := staged[key]; ok || strings.HasPrefix(key, staging) || r.reserved(key) {
+			continue
+		}
+
+		current, err := backend.Get(key)
+		if err != nil {
+			return nil, nil, nil, fmt.Errorf("failed to read %q: %s", key, err)
+		}
+		if current == nil {
+			continue
+		}
+		if config.BoolVal(prefix.DeleteOwnedOnly) {
+			ok, err := r.owned(backend, current)
+			if err != nil {
+				return nil, nil, nil, fmt.Errorf("failed to read metadata of %q: %s", key, err)
+			}
+			if !ok {
+				continue
+			}
+		}
+
+		deletes = append(deletes, key)
+		changes = append(changes, &Change{
+			Source:     config.StringVal(prefix.Source),
+			Datacenter: config.StringVal(prefix.Datacenter),
+			Key:        key,
+			Op:         ChangeDelete,
+			OldHash:    valueHash(current.Value),
+			Index:      index,
+		})
+	}
+
+	return puts, deletes, changes, nil
+}
+
+// validateCanary runs the validation hooks of the prefix. The changes are
+// rejected if any of them fails.
+func validateCanary(c *CanaryConfig, req *canaryRequest) error {
+	body, err := json.Marshal(req)
+	if err != nil {
+		return err
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), config.TimeDurationVal(c.Timeout))
+	defer cancel()
+
+	if command := config.StringVal(c.Command); command != "" {
+		if err := runCanaryCommand(ctx, command, req, body); err != nil {
+			return err
+		}
+	}
+
+	if url := config.StringVal(c.URL); url != "" {
+		if err := postCanary(ctx, url, body); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// runCanaryCommand runs the validation command with the request on its
+// standard input, and the paths of the prefix in its environment.
+func runCanaryCommand(ctx context.Context, command string, req *canaryRequest, body []byte) error {
+	args, err := shellwords.Parse(command)
+	if err != nil {
+		return errors.Wrap(err, "canary: parsing command")
+	}
+	if len(args) == 0 {
+		return fmt.Errorf("canary: missing command")
+	}
+
+	cmd := exec.CommandContext(ctx, args[0], args[1:]...)
+	cmd.Stdin = bytes.NewReader(body)
+	cmd.Env = append(os.Environ(),
+		"CONSUL_REPLICATE_SOURCE="+req.Source,
+		"CONSUL_REPLICATE_DATACENTER="+req.Datacenter,
+		"CONSUL_REPLICATE_DESTINATION="+req.Destination,
+		"CONSUL_REPLICATE_STAGING="+req.Staging,
+	)
+
+	if out, err := cmd.CombinedOutput(); err != nil {
+		return fmt.Errorf("canary: command: %s: %s", err, strings.TrimSpace(string(out)))
+	}
+	return nil
+}
+
+// postCanary POSTs the request to the validation URL.
+func postCanary(ctx context.Context, url string, body []byte) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return errors.Wrap(err, "canary")
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		b, _ := ioutil.ReadAll(resp.Body)
+		return fmt.Errorf("canary: unexpected response code %d: %s",
+			resp.StatusCode, strings.TrimSpace(string(b)))
+	}
+	return nil
+}
+
+// promote applies the writes and deletes to the backend, in transactions of
+// up to canaryTxnOps operations if it supports them. Promotions which fit in
+// a single transaction are atomic.
+func promote(backend Backend, puts []*api.KVPair, deletes []string) error {
+	if txn, ok := backend.(txnBackend); ok {
+		for len(puts)+len(deletes) > 0 {
+			n := canaryTxnOps
+			if n > len(puts) {
+				n = len(puts)
+			}
+			batchPuts := puts[:n]
+			puts = puts[n:]
+
+			m := canaryTxnOps - n
+			if m > len(deletes) {
+				m = len(deletes)
+			}
+			batchDeletes := deletes[:m]
+			deletes = deletes[m:]
+
+			if err := txn.Txn(batchPuts, batchDeletes); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	for _, pair := range puts {
+		if err := backend.Put(pair); err != nil {
+			return fmt.Errorf("failed to write %q: %s", pair.Key, err)
+		}
+	}
+	for _, key := range deletes {
+		if err := backend.Delete(key); err != nil {
+			return fmt.Errorf("failed to delete %q: %s", key, err)
+		}
+	}
+	return nil
+}
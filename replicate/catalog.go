@@ -0,0 +1,261 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: MPL-2.0
+
+package replicate
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"log"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/hashicorp/consul-template/config"
+	"github.com/hashicorp/consul/api"
+	"github.com/pkg/errors"
+)
+
+// CatalogSource is a Source which can also read the catalog of its
+// datacenters.
+type CatalogSource interface {
+	// Services returns the names of the services registered in the datacenter.
+	Services(datacenter string) ([]string, error)
+
+	// ServiceInstances returns the instances of the service in the datacenter,
+	// with their node and health checks.
+	ServiceInstances(service, datacenter string) ([]*api.ServiceEntry, error)
+
+	// Nodes returns the nodes of the datacenter.
+	Nodes(datacenter string) ([]*api.Node, error)
+}
+
+func (s *consulSource) Services(datacenter string) ([]string, error) {
+	services, _, err := s.client.Catalog().Services((&api.QueryOptions{
+		AllowStale: true,
+		Datacenter: datacenter,
+	}).WithContext(s.ctx))
+	if err != nil {
+		return nil, err
+	}
+
+	names := make([]string, 0, len(services))
+	for name := range services {
+		names = append(names, name)
+	}
+	return names, nil
+}
+
+func (s *consulSource) ServiceInstances(service, datacenter string) ([]*api.ServiceEntry, error) {
+	entries, _, err := s.client.Health().Service(service, "", false, (&api.QueryOptions{
+		AllowStale: true,
+		Datacenter: datacenter,
+	}).WithContext(s.ctx))
+	return entries, err
+}
+
+func (s *consulSource) Nodes(datacenter string) ([]*api.Node, error) {
+	nodes, _, err := s.client.Catalog().Nodes((&api.QueryOptions{
+		AllowStale: true,
+		Datacenter: datacenter,
+	}).WithContext(s.ctx))
+	return nodes, err
+}
+
+// CatalogInstance is the JSON layout of a service instance in a materialized
+// catalog. The instances of a service are written as a list under
+// "<destination>/services/<service>", sorted by node and ID.
+type CatalogInstance struct {
+	ID      string
+	Node    string
+	Address string
+	Port    int
+	Tags    []string
+	Meta    map[string]string
+
+	// Health is the aggregated state of the checks of the instance and its
+	// node: "passing", "warning" or "critical".
+	Health string `json:",omitempty"`
+}
+
+// CatalogNode is the JSON layout of a node in a materialized catalog, written
+// under "<destination>/nodes/<node>".
+type CatalogNode struct {
+	Node    string
+	Address string
+	Meta    map[string]string
+}
+
+// checkCatalogs returns an error if a catalog cannot be materialized.
+func (r *Runner) checkCatalogs() error {
+	if len(*r.config.Catalogs) == 0 {
+		return nil
+	}
+	if _, ok := r.source.(CatalogSource); !ok {
+		return fmt.Errorf("the source cannot read the catalog")
+	}
+	for _, c := range *r.config.Catalogs {
+		if config.StringVal(c.Destination) == "" {
+			return fmt.Errorf("catalog of %q: missing destination", config.StringVal(c.Datacenter))
+		}
+	}
+	return nil
+}
+
+// watchCatalog periodically materializes the catalog. This function blocks
+// until the runner is stopped.
+func (r *Runner) watchCatalog(c *CatalogConfig) {
+	ticker := time.NewTicker(config.TimeDurationVal(c.Interval))
+	defer ticker.Stop()
+
+	for {
+		// Only the leader writes to the destination
+		if !r.haEnabled() || r.isLeader() {
+			if err := r.materializeCatalog(c); err != nil {
+				log.Printf("[WARN] (runner) %s", err)
+			}
+		}
+
+		select {
+		case <-ticker.C:
+		case <-r.stopCh:
+			return
+		}
+	}
+}
+
+// materializeCatalogs materializes every catalog once.
+func (r *Runner) materializeCatalogs() error {
+	for _, c := range *r.config.Catalogs {
+		if err := r.materializeCatalog(c); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// materializeCatalog reads the catalog of the datacenter and writes it under
+// the destination. Keys whose value did not change are not written again, and
+// keys of services and nodes which are gone are removed.
+func (r *Runner) materializeCatalog(c *CatalogConfig) error {
+	dc := config.StringVal(c.Datacenter)
+	desired, err := r.readCatalog(c)
+	if err != nil {
+		return errors.Wrapf(err, "catalog of %q", dc)
+	}
+
+	backend := r.backends[BackendConsul]
+	destination := strings.TrimRight(config.StringVal(c.Destination), "/") + "/"
+	keys, err := backend.Keys(destination)
+	if err != nil {
+		return errors.Wrapf(err, "catalog of %q: listing %q", dc, destination)
+	}
+
+	var updates, deletes int
+	for _, key := range keys {
+		if _, ok := desired[key]; ok {
+			continue
+		}
+		if err := backend.Delete(key); err != nil {
+			return errors.Wrapf(err, "catalog of %q: deleting %q", dc, key)
+		}
+		deletes++
+	}
+
+	for key, value := range desired {
+		pair, err := backend.Get(key)
+		if err != nil {
+			return errors.Wrapf(err, "catalog of %q: reading %q", dc, key)
+		}
+		if pair != nil && bytes.Equal(pair.Value, value) {
+			continue
+		}
+		if err := backend.Put(&api.KVPair{Key: key, Value: value}); err != nil {
+			return errors.Wrapf(err, "catalog of %q: writing %q", dc, key)
+		}
+		updates++
+	}
+
+	if updates > 0 || deletes > 0 {
+		log.Printf("[INFO] (runner) materialized catalog of %q: %d updates, %d deletes",
+			dc, updates, deletes)
+	}
+	return nil
+}
+
+// readCatalog returns the keys and values of the materialized catalog.
+func (r *Runner) readCatalog(c *CatalogConfig) (map[string][]byte, error) {
+	source := r.source.(CatalogSource)
+	dc := config.StringVal(c.Datacenter)
+	destination := strings.TrimRight(config.StringVal(c.Destination), "/") + "/"
+
+	names := c.Services
+	if len(names) == 0 {
+		var err error
+		if names, err = source.Services(dc); err != nil {
+			return nil, err
+		}
+	}
+
+	desired := make(map[string][]byte)
+	for _, name := range names {
+		entries, err := source.ServiceInstances(name, dc)
+		if err != nil {
+			return nil, err
+		}
+		if len(entries) == 0 {
+			continue
+		}
+
+		instances := make([]*CatalogInstance, 0, len(entries))
+		for _, entry := range entries {
+			instance := &CatalogInstance{
+				ID:      entry.Service.ID,
+				Node:    entry.Node.Node,
+				Address: entry.Service.Address,
+				Port:    entry.Service.Port,
+				Tags:    entry.Service.Tags,
+				Meta:    entry.Service.Meta,
+			}
+			if instance.Address == "" {
+				instance.Address = entry.Node.Address
+			}
+			if config.BoolVal(c.Health) {
+				instance.Health = entry.Checks.AggregatedStatus()
+			}
+			instances = append(instances, instance)
+		}
+		sort.Slice(instances, func(i, j int) bool {
+			if instances[i].Node != instances[j].Node {
+				return instances[i].Node < instances[j].Node
+			}
+			return instances[i].ID < instances[j].ID
+		})
+
+		enc, err := json.MarshalIndent(instances, "", "  ")
+		if err != nil {
+			return nil, err
+		}
+		desired[destination+"services/"+name] = enc
+	}
+
+	if config.BoolVal(c.Nodes) {
+		nodes, err := source.Nodes(dc)
+		if err != nil {
+			return nil, err
+		}
+		for _, node := range nodes {
+			enc, err := json.MarshalIndent(&CatalogNode{
+				Node:    node.Node,
+				Address: node.Address,
+				Meta:    node.Meta,
+			}, "", "  ")
+			if err != nil {
+				return nil, err
+			}
+			desired[destination+"nodes/"+node.Node] = enc
+		}
+	}
+	return desired, nil
+}
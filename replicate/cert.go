@@ -0,0 +1,245 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: MPL-2.0
+
+package replicate
+
+import (
+	"crypto/sha256"
+	"crypto/tls"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/hashicorp/consul-template/config"
+	multierror "github.com/hashicorp/go-multierror"
+	rootcerts "github.com/hashicorp/go-rootcerts"
+	"github.com/pkg/errors"
+)
+
+// certTransport sends the requests of a client through a transport with the
+// current certificates of the ssl config, so rotated certificates, keys and
+// CAs are picked up without creating a new client, which would drop the
+// watches and sessions made with it. Connections made with the previous
+// certificates are closed once idle.
+type certTransport struct {
+	sync.RWMutex
+
+	ssl      *config.SSLConfig
+	current  *http.Transport
+	checksum string
+}
+
+func (t *certTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	t.RLock()
+	current := t.current
+	t.RUnlock()
+	return current.RoundTrip(req)
+}
+
+// reload reads the certificate files again, and swaps the transport if they
+// changed. It returns true if they did.
+func (t *certTransport) reload() (bool, error) {
+	checksum, err := certChecksum(t.ssl)
+	if err != nil {
+		return false, err
+	}
+
+	t.RLock()
+	unchanged := checksum == t.checksum
+	t.RUnlock()
+	if unchanged {
+		return false, nil
+	}
+
+	tlsConfig, err := newTLSConfig(t.ssl)
+	if err != nil {
+		return false, err
+	}
+
+	t.Lock()
+	previous := t.current
+	t.current = previous.Clone()
+	t.current.TLSClientConfig = tlsConfig
+	t.checksum = checksum
+	t.Unlock()
+
+	previous.CloseIdleConnections()
+	return true, nil
+}
+
+// certFiles returns the certificate, key and CA files of the ssl config,
+// including the files of the CA directory.
+func certFiles(ssl *config.SSLConfig) ([]string, error) {
+	var files []string
+	for _, path := range []string{
+		config.StringVal(ssl.Cert),
+		config.StringVal(ssl.Key),
+		config.StringVal(ssl.CaCert),
+	} {
+		if path != "" {
+			files = append(files, path)
+		}
+	}
+
+	if dir := config.StringVal(ssl.CaPath); dir != "" {
+		entries, err := os.ReadDir(dir)
+		if err != nil {
+			return nil, err
+		}
+		for _, entry := range entries {
+			if !entry.IsDir() {
+				files = append(files, filepath.Join(dir, entry.Name()))
+			}
+		}
+	}
+	sort.Strings(files)
+	return files, nil
+}
+
+// certChecksum returns the checksum of the contents of the certificate files
+// of the ssl config.
+func certChecksum(ssl *config.SSLConfig) (string, error) {
+	files, err := certFiles(ssl)
+	if err != nil {
+		return "", err
+	}
+
+	h := sha256.New()
+	for _, path := range files {
+		f, err := os.Open(path)
+		if err != nil {
+			return "", err
+		}
+		fmt.Fprintf(h, "%s\x00", path)
+		_, err = io.Copy(h, f)
+		f.Close()
+		if err != nil {
+			return "", err
+		}
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// newTLSConfig builds the TLS config of the ssl config, for the transports of
+// the clients.
+func newTLSConfig(ssl *config.SSLConfig) (*tls.Config, error) {
+	var tlsConfig tls.Config
+
+	certFile, keyFile := config.StringVal(ssl.Cert), config.StringVal(ssl.Key)
+	if keyFile == "" {
+		keyFile = certFile
+	}
+	if certFile != "" {
+		cert, err := tls.LoadX509KeyPair(certFile, keyFile)
+		if err != nil {
+			return nil, errors.Wrap(err, "loading certificate")
+		}
+		tlsConfig.Certificates = []tls.Certificate{cert}
+	}
+
+	if caFile, caPath := config.StringVal(ssl.CaCert), config.StringVal(ssl.CaPath); caFile != "" || caPath != "" {
+		if err := rootcerts.ConfigureTLS(&tlsConfig, &rootcerts.Config{
+			CAFile: caFile,
+			CAPath: caPath,
+		}); err != nil {
+			return nil, errors.Wrap(err, "loading CA")
+		}
+	}
+
+	tlsConfig.ServerName = config.StringVal(ssl.ServerName)
+	tlsConfig.InsecureSkipVerify = !config.BoolVal(ssl.Verify)
+	return &tlsConfig, nil
+}
+
+// setCerts makes the client use the certificates of the ssl config as they
+// are rotated. The transport of the client must not be wrapped yet.
+func setCerts(hc *http.Client, ssl *config.SSLConfig) (*certTransport, error) {
+	if t, ok := hc.Transport.(*certTransport); ok {
+		return t, nil
+	}
+	base, ok := hc.Transport.(*http.Transport)
+	if !ok {
+		return nil, fmt.Errorf("cannot rotate the certificates of the consul client: "+
+			"unexpected transport %T", hc.Transport)
+	}
+
+	checksum, err := certChecksum(ssl)
+	if err != nil {
+		return nil, errors.Wrap(err, "reading certificates")
+	}
+	t := &certTransport{ssl: ssl, current: base, checksum: checksum}
+	hc.Transport = t
+	return t, nil
+}
+
+// certRotationEnabled returns true if the certificates are reloaded when they
+// are rotated.
+func (r *Runner) certRotationEnabled() bool {
+	return config.BoolVal(r.config.CertRotation.Enabled)
+}
+
+// initCerts makes the client rotate the certificates of the ssl config, if
+// certificate rotation is enabled and the client uses TLS. It must be called
+// before the transport of the client is wrapped.
+func (r *Runner) initCerts(hc *http.Client, ssl *config.SSLConfig) (*certTransport, error) {
+	if !r.certRotationEnabled() || !config.BoolVal(ssl.Enabled) {
+		return nil, nil
+	}
+	return setCerts(hc, ssl)
+}
+
+// RotateCerts reads the certificate files again, and swaps the transports of
+// the clients if they changed. Watches, sessions and the HA lock are kept, as
+// requests in flight finish with the previous certificates.
+func (r *Runner) RotateCerts() error {
+	var errs *multierror.Error
+	for _, t := range []struct {
+		name      string
+		transport *certTransport
+	}{
+		{"source", r.sourceCerts},
+		{"destination", r.destinationCerts},
+	} {
+		if t.transport == nil {
+			continue
+		}
+
+		// The previous certificates are kept until the files can be read
+		// again, as they may be half written
+		changed, err := t.transport.reload()
+		if err != nil {
+			errs = multierror.Append(errs, errors.Wrapf(err, "%s certificates", t.name))
+			continue
+		}
+		if changed {
+			log.Printf("[INFO] (runner) rotated the %s certificates", t.name)
+		}
+	}
+	return errs.ErrorOrNil()
+}
+
+// rotateCerts periodically reads the certificate files again. This function
+// blocks until the runner is stopped.
+func (r *Runner) rotateCerts() {
+	ticker := time.NewTicker(config.TimeDurationVal(r.config.CertRotation.Interval))
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+		case <-r.stopCh:
+			return
+		}
+
+		if err := r.RotateCerts(); err != nil {
+			log.Printf("[WARN] (runner) failed to rotate the certificates: %s", err)
+		}
+	}
+}
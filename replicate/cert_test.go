@@ -0,0 +1,126 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: MPL-2.0
+
+package replicate
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"fmt"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/hashicorp/consul-template/config"
+)
+
+// writeCert writes a self-signed client certificate with the common name, and
+// its key, into the files.
+func writeCert(t *testing.T, cn, certFile, keyFile string) {
+	t.Helper()
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	der, err := x509.CreateCertificate(rand.Reader, &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: cn},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	}, &x509.Certificate{SerialNumber: big.NewInt(1), Subject: pkix.Name{CommonName: cn}},
+		&key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := os.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{
+		Type: "CERTIFICATE", Bytes: der}), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{
+		Type: "EC PRIVATE KEY", Bytes: keyDER}), 0o600); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestSetCerts(t *testing.T) {
+	srv := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprintf(w, `{"Config":{"NodeName":%q}}`, r.TLS.PeerCertificates[0].Subject.CommonName)
+	}))
+	srv.TLS = &tls.Config{ClientAuth: tls.RequireAnyClientCert}
+	srv.StartTLS()
+	defer srv.Close()
+
+	dir := t.TempDir()
+	certFile, keyFile := filepath.Join(dir, "cert.pem"), filepath.Join(dir, "key.pem")
+	writeCert(t, "first", certFile, keyFile)
+
+	c := config.DefaultConsulConfig()
+	c.Address = config.String(srv.Listener.Addr().String())
+	c.SSL = &config.SSLConfig{
+		Enabled: config.Bool(true),
+		Verify:  config.Bool(false),
+		Cert:    config.String(certFile),
+		Key:     config.String(keyFile),
+	}
+	c.Finalize()
+	clients, err := newClientSet(c, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	transport, err := setCerts(clients.httpClient, c.SSL)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	nodeName := func() string {
+		self, err := clients.Consul().Agent().Self()
+		if err != nil {
+			t.Fatal(err)
+		}
+		return self["Config"]["NodeName"].(string)
+	}
+	if act := nodeName(); act != "first" {
+		t.Errorf("\nexp: %#v\nact: %#v", "first", act)
+	}
+
+	// Unchanged files keep the transport
+	if changed, err := transport.reload(); err != nil || changed {
+		t.Errorf("expected no change, got %t: %v", changed, err)
+	}
+
+	// A half written key keeps the previous certificate
+	if err := os.WriteFile(keyFile, []byte("-----BEGIN"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := transport.reload(); err == nil {
+		t.Errorf("expected an error")
+	}
+	if act := nodeName(); act != "first" {
+		t.Errorf("\nexp: %#v\nact: %#v", "first", act)
+	}
+
+	// New connections are made with the rotated certificate
+	writeCert(t, "second", certFile, keyFile)
+	if changed, err := transport.reload(); err != nil || !changed {
+		t.Fatalf("expected a change, got %t: %v", changed, err)
+	}
+	if act := nodeName(); act != "second" {
+		t.Errorf("\nexp: %#v\nact: %#v", "second", act)
+	}
+}
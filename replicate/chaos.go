@@ -0,0 +1,114 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: MPL-2.0
+
+package replicate
+
+import (
+	"fmt"
+	"io"
+	"log"
+	"math/rand"
+	"net/http"
+	"strings"
+
+	"github.com/hashicorp/consul-template/config"
+)
+
+// chaosEnabled returns true if failures are injected.
+func (r *Runner) chaosEnabled() bool {
+	return config.BoolVal(r.config.Chaos.Enabled)
+}
+
+// checkChaos returns an error if a percentage of the chaos config is out of
+// range.
+func checkChaos(c *ChaosConfig) error {
+	for name, percent := range map[string]*int{
+		"index_reset_percent": c.IndexResetPercent,
+		"read_error_percent":  c.ReadErrorPercent,
+		"write_error_percent": c.WriteErrorPercent,
+	} {
+		if p := config.IntVal(percent); p < 0 || p > 100 {
+			return fmt.Errorf("chaos: %s must be between 0 and 100, got %d", name, p)
+		}
+	}
+	return nil
+}
+
+// chance returns true with the given percentage of probability.
+func chance(percent int) bool {
+	return percent > 0 && rand.Intn(100) < percent
+}
+
+// chaosTransport fails a percentage of the requests of a client with a 500
+// response, like a Consul server which lost its leader.
+type chaosTransport struct {
+	base    http.RoundTripper
+	percent int
+	writes  bool
+}
+
+func (t *chaosTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	write := req.Method == http.MethodPut || req.Method == http.MethodDelete
+	if write != t.writes || !chance(t.percent) {
+		return t.base.RoundTrip(req)
+	}
+
+	log.Printf("[WARN] (runner) chaos: failing %s %s", req.Method, req.URL.Path)
+	body := "chaos: injected failure"
+	return &http.Response{
+		Status:        "500 Internal Server Error",
+		StatusCode:    http.StatusInternalServerError,
+		Proto:         "HTTP/1.1",
+		ProtoMajor:    1,
+		ProtoMinor:    1,
+		Header:        make(http.Header),
+		Body:          io.NopCloser(strings.NewReader(body)),
+		ContentLength: int64(len(body)),
+		Request:       req,
+	}, nil
+}
+
+// setChaos makes the client fail a percentage of its reads, or of its writes
+// and deletes.
+func setChaos(hc *http.Client, percent int, writes bool) {
+	if _, ok := hc.Transport.(*chaosTransport); ok {
+		return
+	}
+	base := hc.Transport
+	if base == nil {
+		base = http.DefaultTransport
+	}
+	hc.Transport = &chaosTransport{base: base, percent: percent, writes: writes}
+}
+
+// initChaos injects failures into the clients, if chaos is enabled.
+func (r *Runner) initChaos() error {
+	if !r.chaosEnabled() {
+		return nil
+	}
+	if err := checkChaos(r.config.Chaos); err != nil {
+		return err
+	}
+
+	log.Printf("[WARN] (runner) chaos is enabled, failing %d%% of reads, "+
+		"%d%% of writes and %d%% of passes with an index reset. Never enable "+
+		"it in production", config.IntVal(r.config.Chaos.ReadErrorPercent),
+		config.IntVal(r.config.Chaos.WriteErrorPercent),
+		config.IntVal(r.config.Chaos.IndexResetPercent))
+
+	setChaos(r.clients.httpClient, config.IntVal(r.config.Chaos.ReadErrorPercent), false)
+	setChaos(r.destinationClients.httpClient, config.IntVal(r.config.Chaos.WriteErrorPercent), true)
+	return nil
+}
+
+// chaosIndex returns the index of the source of the prefix, or an index which
+// went backwards, as after a snapshot restore of the source, for a percentage
+// of the passes while chaos is enabled.
+func (r *Runner) chaosIndex(prefix *PrefixConfig, index uint64) uint64 {
+	if !r.chaosEnabled() || index <= 1 || !chance(config.IntVal(r.config.Chaos.IndexResetPercent)) {
+		return index
+	}
+	log.Printf("[WARN] (runner) chaos: resetting the index of %s from %d to 1",
+		prefix.Dependency, index)
+	return 1
+}
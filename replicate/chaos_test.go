@@ -0,0 +1,77 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: MPL-2.0
+
+package replicate
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/hashicorp/consul-template/config"
+	"github.com/hashicorp/consul/api"
+)
+
+func TestSetChaos(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodPut {
+			fmt.Fprint(w, "true")
+			return
+		}
+		fmt.Fprint(w, `[]`)
+	}))
+	defer srv.Close()
+
+	cases := []struct {
+		name     string
+		percent  int
+		writes   bool
+		readErr  bool
+		writeErr bool
+	}{
+		{
+			"none",
+			0,
+			true,
+			false,
+			false,
+		},
+		{
+			"reads",
+			100,
+			false,
+			true,
+			false,
+		},
+		{
+			"writes",
+			100,
+			true,
+			false,
+			true,
+		},
+	}
+
+	for i, tc := range cases {
+		t.Run(fmt.Sprintf("%d_%s", i, tc.name), func(t *testing.T) {
+			c := config.DefaultConsulConfig()
+			c.Address = config.String(srv.URL)
+			c.Finalize()
+			clients, err := newClientSet(c, nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			setChaos(clients.httpClient, tc.percent, tc.writes)
+
+			_, _, err = clients.Consul().KV().List("global", nil)
+			if act := err != nil; act != tc.readErr {
+				t.Errorf("\nexp: %#v\nact: %#v (%v)", tc.readErr, act, err)
+			}
+			_, err = clients.Consul().KV().Put(&api.KVPair{Key: "global/a"}, nil)
+			if act := err != nil; act != tc.writeErr {
+				t.Errorf("\nexp: %#v\nact: %#v (%v)", tc.writeErr, act, err)
+			}
+		})
+	}
+}
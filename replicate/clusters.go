@@ -0,0 +1,100 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: MPL-2.0
+
+package replicate
+
+import (
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/hashicorp/consul-template/config"
+	"github.com/hashicorp/consul/api"
+	"github.com/pkg/errors"
+)
+
+// waitForClustersEnabled returns true if the first pass waits for the clusters
+// to be healthy.
+func (r *Runner) waitForClustersEnabled() bool {
+	return config.BoolVal(r.config.WaitForClusters.Enabled)
+}
+
+// waitForClusters checks the clusters every interval until they are healthy,
+// and fails once the timeout has passed. It returns false if the runner was
+// stopped while waiting.
+func (r *Runner) waitForClusters() (bool, error) {
+	c := r.config.WaitForClusters
+	timeout := config.TimeDurationVal(c.Timeout)
+	deadline := time.Now().Add(timeout)
+
+	for attempt := 1; ; attempt++ {
+		err := r.clustersReady()
+		if err == nil {
+			if attempt > 1 {
+				log.Printf("[INFO] (runner) clusters are ready after %d attempts", attempt)
+			}
+			return true, nil
+		}
+
+		left := time.Until(deadline)
+		if left <= 0 {
+			return false, fmt.Errorf("clusters not ready after %s: %s", timeout, err)
+		}
+		log.Printf("[INFO] (runner) waiting for clusters (attempt %d, %s left): %s",
+			attempt, left.Round(time.Second), err)
+
+		select {
+		case <-time.After(config.TimeDurationVal(c.Interval)):
+		case <-r.stopCh:
+			return false, nil
+		}
+	}
+}
+
+// clustersReady returns an error describing the first cluster which is not
+// healthy. A Consul cluster must have a leader, and the datacenter of every
+// prefix must answer a read of its source and destination. Snapshots are
+// replayed without reading the source.
+func (r *Runner) clustersReady() error {
+	if s, ok := r.source.(*consulSource); ok && r.snapshots == nil {
+		if err := leaderElected(s.client, ""); err != nil {
+			return errors.Wrap(err, "source")
+		}
+	}
+	if _, ok := r.backends[BackendConsul].(*consulBackend); ok {
+		if err := leaderElected(r.destinationClients.Consul(),
+			config.StringVal(r.config.Destination.Datacenter)); err != nil {
+			return errors.Wrap(err, "destination")
+		}
+	}
+
+	datacenters := make(map[string]struct{})
+	for _, prefix := range *r.config.Prefixes {
+		dc := config.StringVal(prefix.Datacenter)
+		if _, ok := datacenters[dc]; !ok && r.snapshots == nil {
+			datacenters[dc] = struct{}{}
+			if _, err := r.sourceFor(prefix).Keys(config.StringVal(prefix.Source), dc); err != nil {
+				return errors.Wrapf(err, "source datacenter %q", dc)
+			}
+		}
+
+		destination := config.StringVal(prefix.Destination)
+		if _, err := r.backend(prefix).Get(destination); err != nil {
+			return errors.Wrapf(err, "destination %q", destination)
+		}
+	}
+	return nil
+}
+
+// leaderElected returns an error if the given datacenter of the cluster of the
+// client, or the datacenter of its agent if empty, has no leader.
+func leaderElected(client *api.Client, dc string) error {
+	leader, err := client.Status().LeaderWithQueryOptions(&api.QueryOptions{Datacenter: dc})
+	if err != nil {
+		return err
+	}
+	if leader == "" {
+		return fmt.Errorf("no cluster leader")
+	}
+	return nil
+}
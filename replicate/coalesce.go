@@ -0,0 +1,174 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: MPL-2.0
+
+package replicate
+
+import (
+	"log"
+	"sort"
+	"strings"
+
+	"github.com/hashicorp/consul-template/config"
+	dep "github.com/hashicorp/consul-template/dependency"
+)
+
+// watch starts the watches needed by the given prefixes and stops every other
+// watch. It returns the prefixes whose watch could be started.
+func (r *Runner) watch(prefixes []*PrefixConfig) []*PrefixConfig {
+	watches := r.watchesFor(prefixes)
+
+	current := make(map[string]*dep.KVListQuery, len(r.watches))
+	for _, d := range r.watches {
+		current[d.String()] = d
+	}
+
+	failed := make(map[string]struct{})
+	active := make([]*PrefixConfig, 0, len(prefixes))
+	for _, prefix := range prefixes {
+		id := prefix.Dependency.String()
+		d := watches[id]
+		if _, ok := failed[d.String()]; ok {
+			delete(watches, id)
+			continue
+		}
+
+		if _, ok := current[d.String()]; !ok {
+			if _, err := r.watcher.Add(r.blockingQuery(d, watchBudget(d, prefixes, watches), watchPartition(d, prefixes))); err != nil {
+				log.Printf("[ERR] (runner) failed to add watch: %v", err)
+				failed[d.String()] = struct{}{}
+				delete(watches, id)
+				continue
+			}
+			current[d.String()] = d
+			log.Printf("[DEBUG] (runner) watching %s", d)
+		}
+
+		if d.String() != id {
+			log.Printf("[DEBUG] (runner) %s is coalesced into %s", id, d)
+		}
+		active = append(active, prefix)
+	}
+
+	used := make(map[string]struct{}, len(watches))
+	for _, d := range watches {
+		used[d.String()] = struct{}{}
+	}
+	for key, d := range current {
+		if _, ok := used[key]; ok {
+			continue
+		}
+		r.watcher.Remove(d)
+		delete(r.data, key)
+		log.Printf("[DEBUG] (runner) stopped watching %s", d)
+	}
+
+	r.watches = watches
+	return active
+}
+
+// watchesFor returns the dependency watched for each prefix, keyed by the ID
+// of the prefix. When watches are coalesced, a prefix within another prefix of
+// the same datacenter is served by the watch of the outer prefix, and prefixes
+// which share a parent folder are served by a single watch of the parent.
+// Prefixes in an admin partition are always watched on their own.
+func (r *Runner) watchesFor(prefixes []*PrefixConfig) map[string]*dep.KVListQuery {
+	watches := make(map[string]*dep.KVListQuery, len(prefixes))
+	if !config.BoolVal(r.config.CoalesceWatches) {
+		for _, prefix := range prefixes {
+			watches[prefix.Dependency.String()] = prefix.Dependency
+		}
+		return watches
+	}
+
+	byDatacenter := make(map[string][]*PrefixConfig)
+	for _, prefix := range prefixes {
+		// The keys of other partitions cannot be watched together
+		if config.StringVal(prefix.Partition) != "" {
+			watches[prefix.Dependency.String()] = prefix.Dependency
+			continue
+		}
+
+		dc := config.StringVal(prefix.Datacenter)
+		byDatacenter[dc] = append(byDatacenter[dc], prefix)
+	}
+
+	for dc, prefixes := range byDatacenter {
+		// Shorter sources first, so outer prefixes are seen before the
+		// prefixes within them
+		sort.SliceStable(prefixes, func(i, j int) bool {
+			return len(config.StringVal(prefixes[i].Source)) < len(config.StringVal(prefixes[j].Source))
+		})
+
+		// A list query matches every key starting with the source, so a
+		// prefix whose source starts with the source of another is within it
+		var roots []*PrefixConfig
+		nested := make(map[*PrefixConfig][]*PrefixConfig)
+	PREFIX:
+		for _, prefix := range prefixes {
+			for _, root := range roots {
+				if strings.HasPrefix(config.StringVal(prefix.Source), config.StringVal(root.Source)) {
+					nested[root] = append(nested[root], prefix)
+					continue PREFIX
+				}
+			}
+			roots = append(roots, prefix)
+		}
+
+		byParent := make(map[string][]*PrefixConfig)
+		for _, root := range roots {
+			parent := parentFolder(config.StringVal(root.Source))
+			byParent[parent] = append(byParent[parent], root)
+		}
+
+		for parent, roots := range byParent {
+			for _, root := range roots {
+				d := root.Dependency
+
+				// Never coalesce into a watch of the entire KV store
+				if parent != "" && len(roots) > 1 {
+					s := parent
+					if dc != "" {
+						s += "@" + dc
+					}
+					if coalesced, err := dep.NewKVListQuery(s); err == nil {
+						d = coalesced
+					}
+				}
+
+				watches[root.Dependency.String()] = d
+				for _, prefix := range nested[root] {
+					watches[prefix.Dependency.String()] = d
+				}
+			}
+		}
+	}
+
+	return watches
+}
+
+// parentFolder returns the folder containing the given source, with a trailing
+// slash, or the empty string for top-level sources.
+func parentFolder(source string) string {
+	source = strings.TrimRight(source, "/")
+	i := strings.LastIndex(source, "/")
+	if i < 0 {
+		return ""
+	}
+	return source[:i+1]
+}
+
+// splitPairs returns the pairs of a coalesced watch which are within the
+// source, with their keys made relative to it as if it was watched directly.
+func splitPairs(pairs []*dep.KeyPair, source string) []*dep.KeyPair {
+	split := make([]*dep.KeyPair, 0, len(pairs))
+	for _, pair := range pairs {
+		if !strings.HasPrefix(pair.Path, source) {
+			continue
+		}
+
+		p := *pair
+		p.Key = strings.TrimLeft(strings.TrimPrefix(pair.Path, source), "/")
+		split = append(split, &p)
+	}
+	return split
+}
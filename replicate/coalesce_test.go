@@ -0,0 +1,109 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: MPL-2.0
+
+package replicate
+
+import (
+	"fmt"
+	"reflect"
+	"testing"
+
+	"github.com/hashicorp/consul-template/config"
+	dep "github.com/hashicorp/consul-template/dependency"
+)
+
+func TestRunner_watchesFor(t *testing.T) {
+	cases := []struct {
+		name     string
+		coalesce bool
+		prefixes []string
+		exp      map[string]string
+	}{
+		{
+			"disabled",
+			false,
+			[]string{"global/a@dc1", "global/b@dc1"},
+			map[string]string{
+				"kv.list(global/a@dc1)": "kv.list(global/a@dc1)",
+				"kv.list(global/b@dc1)": "kv.list(global/b@dc1)",
+			},
+		},
+		{
+			"siblings",
+			true,
+			[]string{"global/a@dc1", "global/b@dc1", "other/c@dc1"},
+			map[string]string{
+				"kv.list(global/a@dc1)": "kv.list(global/@dc1)",
+				"kv.list(global/b@dc1)": "kv.list(global/@dc1)",
+				"kv.list(other/c@dc1)":  "kv.list(other/c@dc1)",
+			},
+		},
+		{
+			"datacenters",
+			true,
+			[]string{"global/a@dc1", "global/b@dc2"},
+			map[string]string{
+				"kv.list(global/a@dc1)": "kv.list(global/a@dc1)",
+				"kv.list(global/b@dc2)": "kv.list(global/b@dc2)",
+			},
+		},
+		{
+			"nested",
+			true,
+			[]string{"global/a/b@dc1", "global/a@dc1"},
+			map[string]string{
+				"kv.list(global/a@dc1)":   "kv.list(global/a@dc1)",
+				"kv.list(global/a/b@dc1)": "kv.list(global/a@dc1)",
+			},
+		},
+		{
+			"top_level",
+			true,
+			[]string{"a@dc1", "b@dc1"},
+			map[string]string{
+				"kv.list(a@dc1)": "kv.list(a@dc1)",
+				"kv.list(b@dc1)": "kv.list(b@dc1)",
+			},
+		},
+	}
+
+	for i, tc := range cases {
+		t.Run(fmt.Sprintf("%d_%s", i, tc.name), func(t *testing.T) {
+			var prefixes []*PrefixConfig
+			for _, s := range tc.prefixes {
+				prefix, err := ParsePrefixConfig(s)
+				if err != nil {
+					t.Fatal(err)
+				}
+				prefixes = append(prefixes, prefix)
+			}
+
+			r := &Runner{config: &Config{CoalesceWatches: config.Bool(tc.coalesce)}}
+			act := make(map[string]string)
+			for id, d := range r.watchesFor(prefixes) {
+				act[id] = d.String()
+			}
+
+			if !reflect.DeepEqual(tc.exp, act) {
+				t.Errorf("\nexp: %#v\nact: %#v", tc.exp, act)
+			}
+		})
+	}
+}
+
+func TestSplitPairs(t *testing.T) {
+	pairs := []*dep.KeyPair{
+		{Path: "global/a/one", Key: "a/one"},
+		{Path: "global/ab", Key: "ab"},
+		{Path: "global/b/two", Key: "b/two"},
+	}
+
+	exp := []*dep.KeyPair{
+		{Path: "global/a/one", Key: "one"},
+		{Path: "global/ab", Key: "b"},
+	}
+	act := splitPairs(pairs, "global/a")
+	if !reflect.DeepEqual(exp, act) {
+		t.Errorf("\nexp: %#v\nact: %#v", exp, act)
+	}
+}
@@ -0,0 +1,1730 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: MPL-2.0
+
+package replicate
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"regexp"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/hashicorp/consul-template/config"
+	"github.com/hashicorp/consul-template/signals"
+	"github.com/hashicorp/hcl"
+	"github.com/mitchellh/mapstructure"
+	"github.com/pkg/errors"
+)
+
+const (
+	// DefaultLogLevel is the default logging level.
+	DefaultLogLevel = "WARN"
+
+	// DefaultMaxStale is the default staleness permitted. This enables stale
+	// queries by default for performance reasons.
+	DefaultMaxStale = 2 * time.Second
+
+	// DefaultDiscoveryInterval is the default interval at which wildcard
+	// prefixes are expanded.
+	DefaultDiscoveryInterval = 1 * time.Minute
+
+	// DefaultReloadSignal is the default signal for reload.
+	DefaultReloadSignal = syscall.SIGHUP
+
+	// DefaultKillSignal is the default signal for termination.
+	DefaultKillSignal = syscall.SIGINT
+
+	// DefaultConfigDriftInterval is the default interval at which the
+	// configuration is checked for changes which were not reloaded.
+	DefaultConfigDriftInterval = 1 * time.Minute
+
+	// DefaultStatsHistory is the default number of pass summaries kept in
+	// memory for every prefix.
+	DefaultStatsHistory = 20
+
+	// DefaultStatusDir is the default directory to post status information.
+	DefaultStatusDir = "service/consul-replicate/statuses"
+
+	// StatusBackendConsul, StatusBackendFile and StatusBackendNone are the
+	// supported status backends.
+	StatusBackendConsul = "consul"
+	StatusBackendFile   = "file"
+	StatusBackendNone   = "none"
+)
+
+// Config is used to configure Consul ENV
+type Config struct {
+	// Alerts is the configuration of the threshold rules which notify a command
+	// or a webhook when replication falls behind or fails.
+	Alerts *AlertsConfig `mapstructure:"alerts"`
+
+	// AllowList replicates only the source keys enumerated under a folder of
+	// the destination, which is watched for changes.
+	AllowList *AllowListConfig `mapstructure:"allow_list"`
+
+	// AllowOverlap permits replicating a prefix into a destination which overlaps
+	// its source on the same cluster. Without it, the runner refuses to start,
+	// since every write would be replicated again.
+	AllowOverlap *bool `mapstructure:"allow_overlap"`
+
+	// Backup backs up the destination keys a pass deletes before it deletes
+	// them.
+	Backup *BackupConfig `mapstructure:"backup"`
+
+	// Bandwidth is the configuration for capping the bandwidth used with the
+	// source and destination.
+	Bandwidth *BandwidthConfig `mapstructure:"bandwidth"`
+
+	// BlockQuery is the configuration of the blocking queries which watch the
+	// source.
+	BlockQuery *BlockQueryConfig `mapstructure:"block_query"`
+
+	// Catalogs is the list of source datacenters whose catalog is materialized
+	// into the destination.
+	Catalogs *CatalogConfigs `mapstructure:"catalog"`
+
+	// CertRotation is the configuration of the reload of rotated certificates.
+	CertRotation *CertRotationConfig `mapstructure:"cert_rotation"`
+
+	// Chaos is the configuration for injecting failures in staging.
+	Chaos *ChaosConfig `mapstructure:"chaos"`
+
+	// ClaimDestinations records the prefix which owns each destination in the
+	// status dir, and fails the pass of a prefix whose destination is owned by
+	// another one, such as a prefix of another replicator.
+	ClaimDestinations *bool `mapstructure:"claim_destinations"`
+
+	// CoalesceWatches replaces the watches of prefixes which share a parent folder
+	// in the same datacenter with a single watch of the parent, whose data is
+	// split between the prefixes.
+	CoalesceWatches *bool `mapstructure:"coalesce_watches"`
+
+	// ConfigConsulPath is a key in the destination Consul whose value is loaded as
+	// configuration, taking precedence over files, and watched for changes.
+	ConfigConsulPath *string `mapstructure:"config_consul_path"`
+
+	// ConfigDriftInterval is how often the configuration is loaded again, to warn
+	// if it changed without a reload. Zero disables the check.
+	ConfigDriftInterval *time.Duration `mapstructure:"config_drift_interval"`
+
+	// Consul is the configuration for connecting to a Consul cluster.
+	Consul *config.ConsulConfig `mapstructure:"consul"`
+
+	// ConsulTokens are the tokens the source client sends to the datacenters
+	// they are keyed by, in place of its token. They are set with tokens in
+	// the consul block.
+	ConsulTokens DatacenterTokens `mapstructure:"consul_tokens"`
+
+	// Control is the configuration of the gRPC control API.
+	Control *ControlConfig `mapstructure:"control"`
+
+	// CreateFolders maintains an empty folder key, ending in a slash, for every
+	// intermediate folder of the keys replicated into the destination, and deletes
+	// it once its folder is empty.
+	CreateFolders *bool `mapstructure:"create_folders"`
+
+	// Debug is the configuration of the debug listener.
+	Debug *DebugConfig `mapstructure:"debug"`
+
+	// Denied is the credentials invalid state, which holds back every prefix when
+	// the destination denies the ACL token.
+	Denied *DeniedConfig `mapstructure:"denied"`
+
+	// Destination is the configuration for discovering the destination cluster
+	// in the catalog of the source.
+	Destination *DestinationConfig `mapstructure:"destination"`
+
+	// DestinationConsul is the configuration for connecting to the Consul
+	// cluster that data is replicated into.
+	DestinationConsul *config.ConsulConfig `mapstructure:"destination_consul"`
+
+	// DestinationConsulTokens are the tokens the destination client sends to
+	// the datacenters they are keyed by, in place of its token. They are set
+	// with tokens in the destination_consul block.
+	DestinationConsulTokens DatacenterTokens `mapstructure:"destination_consul_tokens"`
+
+	// DestinationRoot is prepended to the destination of every prefix, so the
+	// whole replicated tree can be moved by changing a single option.
+	DestinationRoot *string `mapstructure:"destination_root"`
+
+	// Diff writes the diff of every pass which changed the destination.
+	Diff *DiffConfig `mapstructure:"diff"`
+
+	// DiscoveryInterval is how often the source is listed to find the folders
+	// matching wildcard prefixes.
+	DiscoveryInterval *time.Duration `mapstructure:"discovery_interval"`
+
+	// Drift watches the destination prefixes and reports keys modified by
+	// anything but the replicator.
+	Drift *DriftConfig `mapstructure:"drift"`
+
+	// DumpSignal is the signal to listen for to dump the internal state to the
+	// log.
+	DumpSignal *os.Signal `mapstructure:"dump_signal"`
+
+	// Excludes is the list of key prefixes to exclude from replication.
+	Excludes *ExcludeConfigs `mapstructure:"exclude"`
+
+	// HA is the configuration for running several instances with leader election.
+	HA *HAConfig `mapstructure:"ha"`
+
+	// HTTP is the configuration of the HTTP transports of the source and
+	// destination clients.
+	HTTP *HTTPConfig `mapstructure:"http"`
+
+	// Identity is the identity the replicator presents to the clusters.
+	Identity *IdentityConfig `mapstructure:"identity"`
+
+	// KillSignal is the signal to listen for a graceful terminate event.
+	KillSignal *os.Signal `mapstructure:"kill_signal"`
+
+	// Kubernetes is the configuration for the Kubernetes destination backend.
+	Kubernetes *KubernetesConfig `mapstructure:"kubernetes"`
+
+	// LogLevel is the level with which to log for this config.
+	LogLevel *string `mapstructure:"log_level"`
+
+	// Login is the configuration of the auth methods the source and
+	// destination clients log in to, to obtain their ACL tokens.
+	Login *LoginConfig `mapstructure:"login"`
+
+	// LoopDetection records the datacenter replicated keys were first written in
+	// in their metadata keys, and skips keys which originated in the destination
+	// datacenter, so chained replicators in a ring or mesh do not replicate keys
+	// back.
+	LoopDetection *bool `mapstructure:"loop_detection"`
+
+	// MaxBatchDelay caps how long quiescence may delay a pass after the first
+	// coalesced source update, so constant churn cannot hold back replication for
+	// up to wait.max. Zero leaves the delay to the wait timers.
+	MaxBatchDelay *time.Duration `mapstructure:"max_batch_delay"`
+
+	// MaxStale is the maximum amount of time for staleness from Consul as given
+	// by LastContact.
+	MaxStale *time.Duration `mapstructure:"max_stale"`
+
+	// Metadata writes a sidecar metadata key for every key replicated into the
+	// destination.
+	Metadata *MetadataConfig `mapstructure:"metadata"`
+
+	// Mirror keeps a local copy of the destination keys of every prefix, so
+	// restarts do not read the whole destination again.
+	Mirror *MirrorConfig `mapstructure:"mirror"`
+
+	// PidFile is the path on disk where a PID file should be written containing
+	// this processes PID.
+	PidFile *string `mapstructure:"pid_file"`
+
+	// Pipeline is the configuration for pipelining the writes into the
+	// destination.
+	Pipeline *PipelineConfig `mapstructure:"pipeline"`
+
+	// Prefixes is the list of key prefix dependencies.
+	Prefixes *PrefixConfigs `mapstructure:"prefix"`
+
+	// PrefixesConsulPath is a folder in the destination Consul whose keys each
+	// hold the options of prefixes to replicate as JSON, which are added to the
+	// configured prefixes and watched for changes.
+	PrefixesConsulPath *string `mapstructure:"prefixes_consul_path"`
+
+	// Preflight probes at startup that the source token can read every prefix and
+	// the destination token can write every destination and status key, so missing
+	// permissions are reported before replication starts.
+	Preflight *bool `mapstructure:"preflight"`
+
+	// RecordFile is the path of a file the snapshots of the source prefixes are
+	// appended to whenever their index changes, so the traffic can be replayed
+	// later with the replay command.
+	RecordFile *string `mapstructure:"record_file"`
+
+	// ReloadSignal is the signal to listen for a reload event.
+	ReloadSignal *os.Signal `mapstructure:"reload_signal"`
+
+	// ReplicationTimeout is the maximum amount of time a replication pass of a
+	// prefix may take, after which it is cancelled and retried. Zero disables it.
+	ReplicationTimeout *time.Duration `mapstructure:"replication_timeout"`
+
+	// Replicators are named replication groups, each replicated in isolation by
+	// its own runner.
+	Replicators *ReplicatorConfigs `mapstructure:"replicator"`
+
+	// Restart is the supervision of prefixes whose watch dies or whose replication
+	// fails, which are retried with an exponential backoff while the other
+	// prefixes keep replicating.
+	Restart *RestartConfig `mapstructure:"restart"`
+
+	// RotateSignal is the signal to listen for to read the token files again.
+	RotateSignal *os.Signal `mapstructure:"rotate_signal"`
+
+	// Servers is the configuration for talking to Consul servers directly,
+	// bypassing the local agent.
+	Servers *ServersConfig `mapstructure:"servers"`
+
+	// Shard is the configuration for spreading prefixes across several instances.
+	Shard *ShardConfig `mapstructure:"shard"`
+
+	// Sinks is the list of streams where every change applied to a destination
+	// is published.
+	Sinks *SinkConfigs `mapstructure:"sink"`
+
+	// Snapshot is the path of a Consul snapshot to replay into the destinations
+	// instead of watching the source, or "consul" to take snapshots of the source
+	// datacenters through the API.
+	Snapshot *string `mapstructure:"snapshot"`
+
+	// StatsHistory is the number of pass summaries kept in memory for every
+	// prefix, which the stats command reads through the control API. 0 disables
+	// the history.
+	StatsHistory *int `mapstructure:"stats_history"`
+
+	// StatusBackend is where the replication statuses and manifests are stored:
+	// "consul" (the default) stores them in the destination under the status dir,
+	// "file" in local files under the status path, and "none" only in memory.
+	StatusBackend *string `mapstructure:"status_backend"`
+
+	// StatusDir is the path in the KV store that is used to store the replication
+	// statuses (default: "service/consul-replicate/statuses").
+	StatusDir *string `mapstructure:"status_dir"`
+
+	// StatusGC is the garbage collection of stale entries in the status dir.
+	StatusGC *StatusGCConfig `mapstructure:"status_gc"`
+
+	// StatusPath is the local directory the statuses are stored in with the "file"
+	// status backend.
+	StatusPath *string `mapstructure:"status_path"`
+
+	// Syslog is the configuration for syslog.
+	Syslog *config.SyslogConfig `mapstructure:"syslog"`
+
+	// Telemetry is the configuration of the metrics of the replication passes.
+	Telemetry *TelemetryConfig `mapstructure:"telemetry"`
+
+	// TokenRotation is the configuration of the files the source and destination
+	// tokens are read from at runtime.
+	TokenRotation *TokenRotationConfig `mapstructure:"token_rotation"`
+
+	// Tombstone writes a tombstone next to every key deleted from the destination.
+	Tombstone *TombstoneConfig `mapstructure:"tombstone"`
+
+	// Undo keeps the previous values of the keys changed by the last passes of
+	// every prefix, so they can be rolled back.
+	Undo *UndoConfig `mapstructure:"undo"`
+
+	// UpgradeSignal is the signal to listen for to start the binary again, handing
+	// the HA locks, the listeners of the control and debug servers and the
+	// replicated source indexes over to the new process. It is disabled by
+	// default.
+	UpgradeSignal *os.Signal `mapstructure:"upgrade_signal"`
+
+	// VerifyBeforeWrite reads every destination key before writing it, and skips
+	// the write if the value and flags are identical. This reduces Raft churn at
+	// the cost of a read per changed key. Otherwise changed keys are written
+	// through blindly, for minimum latency.
+	VerifyBeforeWrite *bool `mapstructure:"verify_before_write"`
+
+	// VersionCheck is the detection of the versions and features of the source
+	// and destination clusters.
+	VersionCheck *VersionCheckConfig `mapstructure:"version_check"`
+
+	// Wait is the quiescence timers.
+	Wait *config.WaitConfig `mapstructure:"wait"`
+
+	// WaitForClusters holds back the first replication pass until the source and
+	// destination clusters are healthy.
+	WaitForClusters *WaitForClustersConfig `mapstructure:"wait_for_clusters"`
+}
+
+// Copy returns a deep copy of the current configuration. This is useful because
+// the nested data structures may be shared.
+func (c *Config) Copy() *Config {
+	var o Config
+
+	if c.Alerts != nil {
+		o.Alerts = c.Alerts.Copy()
+	}
+
+	if c.AllowList != nil {
+		o.AllowList = c.AllowList.Copy()
+	}
+
+	o.AllowOverlap = c.AllowOverlap
+
+	if c.Backup != nil {
+		o.Backup = c.Backup.Copy()
+	}
+
+	if c.Bandwidth != nil {
+		o.Bandwidth = c.Bandwidth.Copy()
+	}
+
+	if c.BlockQuery != nil {
+		o.BlockQuery = c.BlockQuery.Copy()
+	}
+
+	if c.Catalogs != nil {
+		o.Catalogs = c.Catalogs.Copy()
+	}
+
+	if c.CertRotation != nil {
+		o.CertRotation = c.CertRotation.Copy()
+	}
+
+	if c.Chaos != nil {
+		o.Chaos = c.Chaos.Copy()
+	}
+
+	o.ClaimDestinations = c.ClaimDestinations
+
+	o.CoalesceWatches = c.CoalesceWatches
+
+	o.ConfigConsulPath = c.ConfigConsulPath
+
+	o.ConfigDriftInterval = c.ConfigDriftInterval
+
+	if c.Consul != nil {
+		o.Consul = c.Consul.Copy()
+	}
+
+	o.ConsulTokens = c.ConsulTokens.Copy()
+
+	if c.Control != nil {
+		o.Control = c.Control.Copy()
+	}
+
+	o.CreateFolders = c.CreateFolders
+
+	if c.Debug != nil {
+		o.Debug = c.Debug.Copy()
+	}
+
+	if c.Denied != nil {
+		o.Denied = c.Denied.Copy()
+	}
+
+	if c.Destination != nil {
+		o.Destination = c.Destination.Copy()
+	}
+
+	if c.DestinationConsul != nil {
+		o.DestinationConsul = c.DestinationConsul.Copy()
+	}
+
+	o.DestinationConsulTokens = c.DestinationConsulTokens.Copy()
+
+	o.DestinationRoot = c.DestinationRoot
+
+	if c.Diff != nil {
+		o.Diff = c.Diff.Copy()
+	}
+
+	o.DiscoveryInterval = c.DiscoveryInterval
+
+	if c.Drift != nil {
+		o.Drift = c.Drift.Copy()
+	}
+
+	o.DumpSignal = c.DumpSignal
+
+	if c.Excludes != nil {
+		o.Excludes = c.Excludes.Copy()
+	}
+
+	if c.HA != nil {
+		o.HA = c.HA.Copy()
+	}
+
+	if c.HTTP != nil {
+		o.HTTP = c.HTTP.Copy()
+	}
+
+	if c.Identity != nil {
+		o.Identity = c.Identity.Copy()
+	}
+
+	o.KillSignal = c.KillSignal
+
+	if c.Kubernetes != nil {
+		o.Kubernetes = c.Kubernetes.Copy()
+	}
+
+	o.LogLevel = c.LogLevel
+
+	if c.Login != nil {
+		o.Login = c.Login.Copy()
+	}
+
+	o.LoopDetection = c.LoopDetection
+
+	o.MaxBatchDelay = c.MaxBatchDelay
+
+	o.MaxStale = c.MaxStale
+
+	if c.Metadata != nil {
+		o.Metadata = c.Metadata.Copy()
+	}
+
+	if c.Mirror != nil {
+		o.Mirror = c.Mirror.Copy()
+	}
+
+	o.PidFile = c.PidFile
+
+	if c.Pipeline != nil {
+		o.Pipeline = c.Pipeline.Copy()
+	}
+
+	if c.Prefixes != nil {
+		o.Prefixes = c.Prefixes.Copy()
+	}
+
+	o.PrefixesConsulPath = c.PrefixesConsulPath
+
+	o.Preflight = c.Preflight
+
+	o.RecordFile = c.RecordFile
+
+	o.ReloadSignal = c.ReloadSignal
+
+	o.ReplicationTimeout = c.ReplicationTimeout
+
+	if c.Replicators != nil {
+		o.Replicators = c.Replicators.Copy()
+	}
+
+	if c.Restart != nil {
+		o.Restart = c.Restart.Copy()
+	}
+
+	o.RotateSignal = c.RotateSignal
+
+	if c.Servers != nil {
+		o.Servers = c.Servers.Copy()
+	}
+
+	if c.Shard != nil {
+		o.Shard = c.Shard.Copy()
+	}
+
+	if c.Sinks != nil {
+		o.Sinks = c.Sinks.Copy()
+	}
+
+	o.Snapshot = c.Snapshot
+
+	o.StatsHistory = c.StatsHistory
+
+	o.StatusBackend = c.StatusBackend
+
+	o.StatusDir = c.StatusDir
+
+	if c.StatusGC != nil {
+		o.StatusGC = c.StatusGC.Copy()
+	}
+
+	o.StatusPath = c.StatusPath
+
+	if c.Syslog != nil {
+		o.Syslog = c.Syslog.Copy()
+	}
+
+	if c.Telemetry != nil {
+		o.Telemetry = c.Telemetry.Copy()
+	}
+
+	if c.TokenRotation != nil {
+		o.TokenRotation = c.TokenRotation.Copy()
+	}
+
+	if c.Tombstone != nil {
+		o.Tombstone = c.Tombstone.Copy()
+	}
+
+	if c.Undo != nil {
+		o.Undo = c.Undo.Copy()
+	}
+
+	o.UpgradeSignal = c.UpgradeSignal
+
+	o.VerifyBeforeWrite = c.VerifyBeforeWrite
+
+	if c.VersionCheck != nil {
+		o.VersionCheck = c.VersionCheck.Copy()
+	}
+
+	if c.Wait != nil {
+		o.Wait = c.Wait.Copy()
+	}
+
+	if c.WaitForClusters != nil {
+		o.WaitForClusters = c.WaitForClusters.Copy()
+	}
+
+	return &o
+}
+
+func (c *Config) Merge(o *Config) *Config {
+	if c == nil {
+		if o == nil {
+			return nil
+		}
+		return o.Copy()
+	}
+
+	if o == nil {
+		return c.Copy()
+	}
+
+	r := c.Copy()
+
+	if o.Alerts != nil {
+		r.Alerts = r.Alerts.Merge(o.Alerts)
+	}
+
+	if o.AllowList != nil {
+		r.AllowList = r.AllowList.Merge(o.AllowList)
+	}
+
+	if o.AllowOverlap != nil {
+		r.AllowOverlap = o.AllowOverlap
+	}
+
+	if o.Backup != nil {
+		r.Backup = r.Backup.Merge(o.Backup)
+	}
+
+	if o.Bandwidth != nil {
+		r.Bandwidth = r.Bandwidth.Merge(o.Bandwidth)
+	}
+
+	if o.BlockQuery != nil {
+		r.BlockQuery = r.BlockQuery.Merge(o.BlockQuery)
+	}
+
+	if o.Catalogs != nil {
+		r.Catalogs = r.Catalogs.Merge(o.Catalogs)
+	}
+
+	if o.CertRotation != nil {
+		r.CertRotation = r.CertRotation.Merge(o.CertRotation)
+	}
+
+	if o.Chaos != nil {
+		r.Chaos = r.Chaos.Merge(o.Chaos)
+	}
+
+	if o.ClaimDestinations != nil {
+		r.ClaimDestinations = o.ClaimDestinations
+	}
+
+	if o.CoalesceWatches != nil {
+		r.CoalesceWatches = o.CoalesceWatches
+	}
+
+	if o.ConfigConsulPath != nil {
+		r.ConfigConsulPath = o.ConfigConsulPath
+	}
+
+	if o.ConfigDriftInterval != nil {
+		r.ConfigDriftInterval = o.ConfigDriftInterval
+	}
+
+	if o.Consul != nil {
+		r.Consul = r.Consul.Merge(o.Consul)
+	}
+
+	if o.ConsulTokens != nil {
+		r.ConsulTokens = r.ConsulTokens.Merge(o.ConsulTokens)
+	}
+
+	if o.Control != nil {
+		r.Control = r.Control.Merge(o.Control)
+	}
+
+	if o.CreateFolders != nil {
+		r.CreateFolders = o.CreateFolders
+	}
+
+	if o.Debug != nil {
+		r.Debug = r.Debug.Merge(o.Debug)
+	}
+
+	if o.Denied != nil {
+		r.Denied = r.Denied.Merge(o.Denied)
+	}
+
+	if o.Destination != nil {
+		r.Destination = r.Destination.Merge(o.Destination)
+	}
+
+	if o.DestinationConsul != nil {
+		r.DestinationConsul = r.DestinationConsul.Merge(o.DestinationConsul)
+	}
+
+	if o.DestinationConsulTokens != nil {
+		r.DestinationConsulTokens = r.DestinationConsulTokens.Merge(o.DestinationConsulTokens)
+	}
+
+	if o.DestinationRoot != nil {
+		r.DestinationRoot = o.DestinationRoot
+	}
+
+	if o.Diff != nil {
+		r.Diff = r.Diff.Merge(o.Diff)
+	}
+
+	if o.DiscoveryInterval != nil {
+		r.DiscoveryInterval = o.DiscoveryInterval
+	}
+
+	if o.Drift != nil {
+		r.Drift = r.Drift.Merge(o.Drift)
+	}
+
+	if o.DumpSignal != nil {
+		r.DumpSignal = o.DumpSignal
+	}
+
+	if o.Excludes != nil {
+		r.Excludes = r.Excludes.Merge(o.Excludes)
+	}
+
+	if o.HA != nil {
+		r.HA = r.HA.Merge(o.HA)
+	}
+
+	if o.HTTP != nil {
+		r.HTTP = r.HTTP.Merge(o.HTTP)
+	}
+
+	if o.Identity != nil {
+		r.Identity = r.Identity.Merge(o.Identity)
+	}
+
+	if o.KillSignal != nil {
+		r.KillSignal = o.KillSignal
+	}
+
+	if o.Kubernetes != nil {
+		r.Kubernetes = r.Kubernetes.Merge(o.Kubernetes)
+	}
+
+	if o.LogLevel != nil {
+		r.LogLevel = o.LogLevel
+	}
+
+	if o.Login != nil {
+		r.Login = r.Login.Merge(o.Login)
+	}
+
+	if o.LoopDetection != nil {
+		r.LoopDetection = o.LoopDetection
+	}
+
+	if o.MaxBatchDelay != nil {
+		r.MaxBatchDelay = o.MaxBatchDelay
+	}
+
+	if o.MaxStale != nil {
+		r.MaxStale = o.MaxStale
+	}
+
+	if o.Metadata != nil {
+		r.Metadata = r.Metadata.Merge(o.Metadata)
+	}
+
+	if o.Mirror != nil {
+		r.Mirror = r.Mirror.Merge(o.Mirror)
+	}
+
+	if o.PidFile != nil {
+		r.PidFile = o.PidFile
+	}
+
+	if o.Pipeline != nil {
+		r.Pipeline = r.Pipeline.Merge(o.Pipeline)
+	}
+
+	if o.Prefixes != nil {
+		r.Prefixes = r.Prefixes.Merge(o.Prefixes)
+	}
+
+	if o.PrefixesConsulPath != nil {
+		r.PrefixesConsulPath = o.PrefixesConsulPath
+	}
+
+	if o.Preflight != nil {
+		r.Preflight = o.Preflight
+	}
+
+	if o.RecordFile != nil {
+		r.RecordFile = o.RecordFile
+	}
+
+	if o.ReloadSignal != nil {
+		r.ReloadSignal = o.ReloadSignal
+	}
+
+	if o.ReplicationTimeout != nil {
+		r.ReplicationTimeout = o.ReplicationTimeout
+	}
+
+	if o.Replicators != nil {
+		r.Replicators = r.Replicators.Merge(o.Replicators)
+	}
+
+	if o.Restart != nil {
+		r.Restart = r.Restart.Merge(o.Restart)
+	}
+
+	if o.RotateSignal != nil {
+		r.RotateSignal = o.RotateSignal
+	}
+
+	if o.Servers != nil {
+		r.Servers = r.Servers.Merge(o.Servers)
+	}
+
+	if o.Shard != nil {
+		r.Shard = r.Shard.Merge(o.Shard)
+	}
+
+	if o.Sinks != nil {
+		r.Sinks = r.Sinks.Merge(o.Sinks)
+	}
+
+	if o.Snapshot != nil {
+		r.Snapshot = o.Snapshot
+	}
+
+	if o.StatsHistory != nil {
+		r.StatsHistory = o.StatsHistory
+	}
+
+	if o.StatusBackend != nil {
+		r.StatusBackend = o.StatusBackend
+	}
+
+	if o.StatusDir != nil {
+		r.StatusDir = o.StatusDir
+	}
+
+	if o.StatusGC != nil {
+		r.StatusGC = r.StatusGC.Merge(o.StatusGC)
+	}
+
+	if o.StatusPath != nil {
+		r.StatusPath = o.StatusPath
+	}
+
+	if o.Syslog != nil {
+		r.Syslog = r.Syslog.Merge(o.Syslog)
+	}
+
+	if o.Telemetry != nil {
+		r.Telemetry = r.Telemetry.Merge(o.Telemetry)
+	}
+
+	if o.TokenRotation != nil {
+		r.TokenRotation = r.TokenRotation.Merge(o.TokenRotation)
+	}
+
+	if o.Tombstone != nil {
+		r.Tombstone = r.Tombstone.Merge(o.Tombstone)
+	}
+
+	if o.Undo != nil {
+		r.Undo = r.Undo.Merge(o.Undo)
+	}
+
+	if o.UpgradeSignal != nil {
+		r.UpgradeSignal = o.UpgradeSignal
+	}
+
+	if o.VerifyBeforeWrite != nil {
+		r.VerifyBeforeWrite = o.VerifyBeforeWrite
+	}
+
+	if o.VersionCheck != nil {
+		r.VersionCheck = r.VersionCheck.Merge(o.VersionCheck)
+	}
+
+	if o.Wait != nil {
+		r.Wait = r.Wait.Merge(o.Wait)
+	}
+
+	if o.WaitForClusters != nil {
+		r.WaitForClusters = r.WaitForClusters.Merge(o.WaitForClusters)
+	}
+
+	return r
+}
+
+// Checksum returns a hash of the configuration, so instances can be checked to
+// run the same one. It is "sha256:" followed by the hex SHA-256 of the JSON
+// encoding of the configuration.
+func (c *Config) Checksum() (string, error) {
+	enc, err := json.Marshal(c)
+	if err != nil {
+		return "", err
+	}
+	sum := sha256.Sum256(enc)
+	return "sha256:" + hex.EncodeToString(sum[:]), nil
+}
+
+// GoString defines the printable version of this struct.
+func (c *Config) GoString() string {
+	if c == nil {
+		return "(*Config)(nil)"
+	}
+
+	return fmt.Sprintf("&Config{"+
+		"Alerts:%s, "+
+		"AllowList:%s, "+
+		"AllowOverlap:%s, "+
+		"Backup:%s, "+
+		"Bandwidth:%s, "+
+		"BlockQuery:%s, "+
+		"Catalogs:%s, "+
+		"CertRotation:%s, "+
+		"Chaos:%s, "+
+		"ClaimDestinations:%s, "+
+		"CoalesceWatches:%s, "+
+		"ConfigConsulPath:%s, "+
+		"ConfigDriftInterval:%s, "+
+		"Consul:%s, "+
+		"ConsulTokens:%s, "+
+		"Control:%s, "+
+		"CreateFolders:%s, "+
+		"Debug:%s, "+
+		"Denied:%s, "+
+		"Destination:%s, "+
+		"DestinationConsul:%s, "+
+		"DestinationConsulTokens:%s, "+
+		"DestinationRoot:%s, "+
+		"Diff:%s, "+
+		"DiscoveryInterval:%s, "+
+		"Drift:%s, "+
+		"DumpSignal:%s, "+
+		"Excludes:%s, "+
+		"HA:%s, "+
+		"HTTP:%s, "+
+		"Identity:%s, "+
+		"KillSignal:%s, "+
+		"Kubernetes:%s, "+
+		"LogLevel:%s, "+
+		"Login:%s, "+
+		"LoopDetection:%s, "+
+		"MaxBatchDelay:%s, "+
+		"MaxStale:%s, "+
+		"Metadata:%s, "+
+		"Mirror:%s, "+
+		"PidFile:%s, "+
+		"Pipeline:%s, "+
+		"Prefixes:%s, "+
+		"PrefixesConsulPath:%s, "+
+		"Preflight:%s, "+
+		"RecordFile:%s, "+
+		"ReloadSignal:%s, "+
+		"ReplicationTimeout:%s, "+
+		"Replicators:%s, "+
+		"Restart:%s, "+
+		"RotateSignal:%s, "+
+		"Servers:%s, "+
+		"Shard:%s, "+
+		"Sinks:%s, "+
+		"Snapshot:%s, "+
+		"StatsHistory:%s, "+
+		"StatusBackend:%s, "+
+		"StatusDir:%s, "+
+		"StatusGC:%s, "+
+		"StatusPath:%s, "+
+		"Syslog:%s, "+
+		"Telemetry:%s, "+
+		"TokenRotation:%s, "+
+		"Tombstone:%s, "+
+		"Undo:%s, "+
+		"UpgradeSignal:%s, "+
+		"VerifyBeforeWrite:%s, "+
+		"VersionCheck:%s, "+
+		"Wait:%s, "+
+		"WaitForClusters:%s"+
+		"}",
+		c.Alerts.GoString(),
+		c.AllowList.GoString(),
+		config.BoolGoString(c.AllowOverlap),
+		c.Backup.GoString(),
+		c.Bandwidth.GoString(),
+		c.BlockQuery.GoString(),
+		c.Catalogs.GoString(),
+		c.CertRotation.GoString(),
+		c.Chaos.GoString(),
+		config.BoolGoString(c.ClaimDestinations),
+		config.BoolGoString(c.CoalesceWatches),
+		config.StringGoString(c.ConfigConsulPath),
+		config.TimeDurationGoString(c.ConfigDriftInterval),
+		c.Consul.GoString(),
+		c.ConsulTokens.GoString(),
+		c.Control.GoString(),
+		config.BoolGoString(c.CreateFolders),
+		c.Debug.GoString(),
+		c.Denied.GoString(),
+		c.Destination.GoString(),
+		c.DestinationConsul.GoString(),
+		c.DestinationConsulTokens.GoString(),
+		config.StringGoString(c.DestinationRoot),
+		c.Diff.GoString(),
+		config.TimeDurationGoString(c.DiscoveryInterval),
+		c.Drift.GoString(),
+		config.SignalGoString(c.DumpSignal),
+		c.Excludes.GoString(),
+		c.HA.GoString(),
+		c.HTTP.GoString(),
+		c.Identity.GoString(),
+		config.SignalGoString(c.KillSignal),
+		c.Kubernetes.GoString(),
+		config.StringGoString(c.LogLevel),
+		c.Login.GoString(),
+		config.BoolGoString(c.LoopDetection),
+		config.TimeDurationGoString(c.MaxBatchDelay),
+		config.TimeDurationGoString(c.MaxStale),
+		c.Metadata.GoString(),
+		c.Mirror.GoString(),
+		config.StringGoString(c.PidFile),
+		c.Pipeline.GoString(),
+		c.Prefixes.GoString(),
+		config.StringGoString(c.PrefixesConsulPath),
+		config.BoolGoString(c.Preflight),
+		config.StringGoString(c.RecordFile),
+		config.SignalGoString(c.ReloadSignal),
+		config.TimeDurationGoString(c.ReplicationTimeout),
+		c.Replicators.GoString(),
+		c.Restart.GoString(),
+		config.SignalGoString(c.RotateSignal),
+		c.Servers.GoString(),
+		c.Shard.GoString(),
+		c.Sinks.GoString(),
+		config.StringGoString(c.Snapshot),
+		config.IntGoString(c.StatsHistory),
+		config.StringGoString(c.StatusBackend),
+		config.StringGoString(c.StatusDir),
+		c.StatusGC.GoString(),
+		config.StringGoString(c.StatusPath),
+		c.Syslog.GoString(),
+		c.Telemetry.GoString(),
+		c.TokenRotation.GoString(),
+		c.Tombstone.GoString(),
+		c.Undo.GoString(),
+		config.SignalGoString(c.UpgradeSignal),
+		config.BoolGoString(c.VerifyBeforeWrite),
+		c.VersionCheck.GoString(),
+		c.Wait.GoString(),
+		c.WaitForClusters.GoString(),
+	)
+}
+
+// DefaultConfig returns the default configuration struct. Certain environment
+// variables may be set which control the values for the default configuration.
+func DefaultConfig() *Config {
+	return &Config{
+		Alerts:            DefaultAlertsConfig(),
+		AllowList:         DefaultAllowListConfig(),
+		Backup:            DefaultBackupConfig(),
+		Bandwidth:         DefaultBandwidthConfig(),
+		BlockQuery:        DefaultBlockQueryConfig(),
+		Catalogs:          DefaultCatalogConfigs(),
+		CertRotation:      DefaultCertRotationConfig(),
+		Chaos:             DefaultChaosConfig(),
+		Consul:            config.DefaultConsulConfig(),
+		Control:           DefaultControlConfig(),
+		Debug:             DefaultDebugConfig(),
+		Denied:            DefaultDeniedConfig(),
+		Destination:       DefaultDestinationConfig(),
+		DestinationConsul: config.DefaultConsulConfig(),
+		Diff:              DefaultDiffConfig(),
+		Drift:             DefaultDriftConfig(),
+		Excludes:          DefaultExcludeConfigs(),
+		HA:                DefaultHAConfig(),
+		HTTP:              DefaultHTTPConfig(),
+		Identity:          DefaultIdentityConfig(),
+		Kubernetes:        DefaultKubernetesConfig(),
+		Login:             DefaultLoginConfig(),
+		Metadata:          DefaultMetadataConfig(),
+		Mirror:            DefaultMirrorConfig(),
+		Pipeline:          DefaultPipelineConfig(),
+		Prefixes:          DefaultPrefixConfigs(),
+		Replicators:       DefaultReplicatorConfigs(),
+		Restart:           DefaultRestartConfig(),
+		Servers:           DefaultServersConfig(),
+		Shard:             DefaultShardConfig(),
+		Sinks:             DefaultSinkConfigs(),
+		StatusDir:         config.String(DefaultStatusDir),
+		StatusGC:          DefaultStatusGCConfig(),
+		Syslog:            config.DefaultSyslogConfig(),
+		Telemetry:         DefaultTelemetryConfig(),
+		TokenRotation:     DefaultTokenRotationConfig(),
+		Tombstone:         DefaultTombstoneConfig(),
+		Undo:              DefaultUndoConfig(),
+		VersionCheck:      DefaultVersionCheckConfig(),
+		Wait:              config.DefaultWaitConfig(),
+		WaitForClusters:   DefaultWaitForClustersConfig(),
+	}
+}
+
+// Finalize ensures all configuration options have the default values, so it
+// is safe to dereference the pointers later down the line. It also
+// intelligently tries to activate stanzas that should be "enabled" because
+// data was given, but the user did not explicitly add "Enabled: true" to the
+// configuration.
+func (c *Config) Finalize() {
+	if c == nil {
+		return
+	}
+
+	if c.Alerts == nil {
+		c.Alerts = DefaultAlertsConfig()
+	}
+	c.Alerts.Finalize()
+
+	if c.AllowList == nil {
+		c.AllowList = DefaultAllowListConfig()
+	}
+	c.AllowList.Finalize()
+
+	if c.AllowOverlap == nil {
+		c.AllowOverlap = config.Bool(false)
+	}
+
+	if c.Backup == nil {
+		c.Backup = DefaultBackupConfig()
+	}
+	c.Backup.Finalize()
+
+	if c.Bandwidth == nil {
+		c.Bandwidth = DefaultBandwidthConfig()
+	}
+	c.Bandwidth.Finalize()
+
+	if c.BlockQuery == nil {
+		c.BlockQuery = DefaultBlockQueryConfig()
+	}
+	c.BlockQuery.Finalize()
+
+	if c.Catalogs == nil {
+		c.Catalogs = DefaultCatalogConfigs()
+	}
+	c.Catalogs.Finalize()
+
+	if c.CertRotation == nil {
+		c.CertRotation = DefaultCertRotationConfig()
+	}
+	c.CertRotation.Finalize()
+
+	if c.Chaos == nil {
+		c.Chaos = DefaultChaosConfig()
+	}
+	c.Chaos.Finalize()
+
+	if c.ClaimDestinations == nil {
+		c.ClaimDestinations = config.Bool(false)
+	}
+
+	if c.CoalesceWatches == nil {
+		c.CoalesceWatches = config.Bool(false)
+	}
+
+	if c.ConfigConsulPath == nil {
+		c.ConfigConsulPath = config.String("")
+	}
+
+	if c.ConfigDriftInterval == nil {
+		c.ConfigDriftInterval = config.TimeDuration(DefaultConfigDriftInterval)
+	}
+
+	if c.Consul == nil {
+		c.Consul = config.DefaultConsulConfig()
+	}
+	finalizeTransport(c.Consul)
+	c.Consul.Finalize()
+
+	if c.ConsulTokens == nil {
+		c.ConsulTokens = make(DatacenterTokens)
+	}
+
+	if c.Control == nil {
+		c.Control = DefaultControlConfig()
+	}
+	c.Control.Finalize()
+
+	if c.CreateFolders == nil {
+		c.CreateFolders = config.Bool(false)
+	}
+
+	if c.Debug == nil {
+		c.Debug = DefaultDebugConfig()
+	}
+	c.Debug.Finalize()
+
+	if c.Denied == nil {
+		c.Denied = DefaultDeniedConfig()
+	}
+	c.Denied.Finalize()
+
+	if c.Destination == nil {
+		c.Destination = DefaultDestinationConfig()
+	}
+	c.Destination.Finalize()
+
+	if c.DestinationConsul == nil {
+		c.DestinationConsul = config.DefaultConsulConfig()
+	}
+	finalizeTransport(c.DestinationConsul)
+	c.DestinationConsul.Finalize()
+
+	if c.DestinationConsulTokens == nil {
+		c.DestinationConsulTokens = make(DatacenterTokens)
+	}
+
+	if c.DestinationRoot == nil {
+		c.DestinationRoot = config.String("")
+	}
+
+	if c.Diff == nil {
+		c.Diff = DefaultDiffConfig()
+	}
+	c.Diff.Finalize()
+
+	if c.DiscoveryInterval == nil {
+		c.DiscoveryInterval = config.TimeDuration(DefaultDiscoveryInterval)
+	}
+
+	if c.Drift == nil {
+		c.Drift = DefaultDriftConfig()
+	}
+	c.Drift.Finalize()
+
+	// SIGUSR1 is looked up by name, as it does not exist on every platform
+	if c.DumpSignal == nil {
+		c.DumpSignal = config.Signal(signals.SignalLookup["SIGUSR1"])
+	}
+
+	if c.Excludes == nil {
+		c.Excludes = DefaultExcludeConfigs()
+	}
+	c.Excludes.Finalize()
+
+	if c.HA == nil {
+		c.HA = DefaultHAConfig()
+	}
+	c.HA.Finalize()
+
+	if c.HTTP == nil {
+		c.HTTP = DefaultHTTPConfig()
+	}
+	c.HTTP.Finalize()
+
+	if c.Identity == nil {
+		c.Identity = DefaultIdentityConfig()
+	}
+	c.Identity.Finalize()
+
+	if c.KillSignal == nil {
+		c.KillSignal = config.Signal(DefaultKillSignal)
+	}
+
+	if c.Kubernetes == nil {
+		c.Kubernetes = DefaultKubernetesConfig()
+	}
+	c.Kubernetes.Finalize()
+
+	if c.LogLevel == nil {
+		c.LogLevel = stringFromEnv([]string{
+			"CR_LOG",
+			"CONSUL_REPLICATE_LOG",
+		}, DefaultLogLevel)
+	}
+
+	if c.Login == nil {
+		c.Login = DefaultLoginConfig()
+	}
+	c.Login.Finalize()
+
+	if c.LoopDetection == nil {
+		c.LoopDetection = config.Bool(false)
+	}
+
+	if c.MaxBatchDelay == nil {
+		c.MaxBatchDelay = config.TimeDuration(0)
+	}
+
+	if c.MaxStale == nil {
+		c.MaxStale = config.TimeDuration(DefaultMaxStale)
+	}
+
+	if c.Pipeline == nil {
+		c.Pipeline = DefaultPipelineConfig()
+	}
+	c.Pipeline.Finalize()
+
+	if c.Prefixes == nil {
+		c.Prefixes = DefaultPrefixConfigs()
+	}
+	c.Prefixes.Finalize()
+
+	if c.Metadata == nil {
+		c.Metadata = DefaultMetadataConfig()
+	}
+	c.Metadata.Finalize()
+
+	if c.Mirror == nil {
+		c.Mirror = DefaultMirrorConfig()
+	}
+	c.Mirror.Finalize()
+
+	if c.PidFile == nil {
+		c.PidFile = config.String("")
+	}
+
+	if c.PrefixesConsulPath == nil {
+		c.PrefixesConsulPath = config.String("")
+	}
+
+	if c.Preflight == nil {
+		c.Preflight = config.Bool(true)
+	}
+
+	if c.RecordFile == nil {
+		c.RecordFile = config.String("")
+	}
+
+	if c.ReloadSignal == nil {
+		c.ReloadSignal = config.Signal(DefaultReloadSignal)
+	}
+
+	if c.ReplicationTimeout == nil {
+		c.ReplicationTimeout = config.TimeDuration(0)
+	}
+
+	if c.Replicators == nil {
+		c.Replicators = DefaultReplicatorConfigs()
+	}
+	c.Replicators.Finalize()
+
+	if c.Restart == nil {
+		c.Restart = DefaultRestartConfig()
+	}
+	c.Restart.Finalize()
+
+	// SIGUSR2 is looked up by name, as it does not exist on every platform
+	if c.RotateSignal == nil {
+		c.RotateSignal = config.Signal(signals.SignalLookup["SIGUSR2"])
+	}
+
+	if c.Servers == nil {
+		c.Servers = DefaultServersConfig()
+	}
+	c.Servers.Finalize()
+
+	if c.Shard == nil {
+		c.Shard = DefaultShardConfig()
+	}
+	c.Shard.Finalize()
+
+	if c.Sinks == nil {
+		c.Sinks = DefaultSinkConfigs()
+	}
+	c.Sinks.Finalize()
+
+	if c.Snapshot == nil {
+		c.Snapshot = config.String("")
+	}
+
+	if c.StatsHistory == nil {
+		c.StatsHistory = config.Int(DefaultStatsHistory)
+	}
+
+	if c.StatusBackend == nil {
+		c.StatusBackend = config.String(StatusBackendConsul)
+	}
+
+	if c.StatusDir == nil {
+		c.StatusDir = config.String(DefaultStatusDir)
+	}
+
+	if c.StatusGC == nil {
+		c.StatusGC = DefaultStatusGCConfig()
+	}
+	c.StatusGC.Finalize()
+
+	if c.StatusPath == nil {
+		c.StatusPath = config.String("")
+	}
+
+	if c.Syslog == nil {
+		c.Syslog = config.DefaultSyslogConfig()
+	}
+	c.Syslog.Finalize()
+
+	if c.Telemetry == nil {
+		c.Telemetry = DefaultTelemetryConfig()
+	}
+	c.Telemetry.Finalize()
+
+	if c.TokenRotation == nil {
+		c.TokenRotation = DefaultTokenRotationConfig()
+	}
+	c.TokenRotation.Finalize()
+
+	if c.Tombstone == nil {
+		c.Tombstone = DefaultTombstoneConfig()
+	}
+	c.Tombstone.Finalize()
+
+	if c.Undo == nil {
+		c.Undo = DefaultUndoConfig()
+	}
+	c.Undo.Finalize()
+
+	if c.UpgradeSignal == nil {
+		c.UpgradeSignal = config.Signal(signals.SIGNIL)
+	}
+
+	if c.VerifyBeforeWrite == nil {
+		c.VerifyBeforeWrite = config.Bool(false)
+	}
+
+	if c.VersionCheck == nil {
+		c.VersionCheck = DefaultVersionCheckConfig()
+	}
+	c.VersionCheck.Finalize()
+
+	if c.Wait == nil {
+		c.Wait = config.DefaultWaitConfig()
+	}
+	c.Wait.Finalize()
+
+	if c.WaitForClusters == nil {
+		c.WaitForClusters = DefaultWaitForClustersConfig()
+	}
+	c.WaitForClusters.Finalize()
+}
+
+// Parse parses the given string contents as a config. Imports are resolved
+// relative to the working directory.
+func Parse(s string) (*Config, error) {
+	return NewConfigLoader().parse(s, ".")
+}
+
+// parseHCL parses the given string contents as a config, without imports.
+func parseHCL(s string) (*Config, error) {
+	var shadow interface{}
+	if err := hcl.Decode(&shadow, s); err != nil {
+		return nil, errors.Wrap(err, "error decoding config")
+	}
+
+	// Convert to a map and flatten the keys we want to flatten
+	parsed, ok := shadow.(map[string]interface{})
+	if !ok {
+		return nil, errors.New("error converting config")
+	}
+
+	return decode(parsed)
+}
+
+// decode decodes the parsed configuration, which is also the body of a
+// replicator block.
+func decode(parsed map[string]interface{}) (*Config, error) {
+	// Replicator blocks are decoded recursively
+	var replicators *ReplicatorConfigs
+	if raw, ok := parsed["replicator"]; ok {
+		var err error
+		if replicators, err = decodeReplicators(raw); err != nil {
+			return nil, err
+		}
+		delete(parsed, "replicator")
+	}
+
+	// Nested stanzas of sinks are decoded by HCL as lists of maps
+	if sinks, ok := parsed["sink"].([]map[string]interface{}); ok {
+		for _, s := range sinks {
+			flattenKeys(s, []string{"auth", "ssl"})
+		}
+	}
+
+	flattenKeys(parsed, []string{
+		"alerts",
+		"allow_list",
+		"backup",
+		"bandwidth",
+		"block_query",
+		"cert_rotation",
+		"chaos",
+		"consul",
+		"consul.auth",
+		"consul.retry",
+		"consul.ssl",
+		"consul.tokens",
+		"consul.transport",
+		"control",
+		"control.ssl",
+		"debug",
+		"denied",
+		"destination",
+		"destination_consul",
+		"destination_consul.auth",
+		"destination_consul.retry",
+		"destination_consul.ssl",
+		"destination_consul.tokens",
+		"destination_consul.transport",
+		"diff",
+		"drift",
+		"ha",
+		"http",
+		"http.destination",
+		"http.source",
+		"identity",
+		"kubernetes",
+		"login",
+		"login.destination",
+		"login.destination.meta",
+		"login.source",
+		"login.source.meta",
+		"metadata",
+		"mirror",
+		"pipeline",
+		"restart",
+		"servers",
+		"shard",
+		"status_gc",
+		"syslog",
+		"telemetry",
+		"token_rotation",
+		"tombstone",
+		"undo",
+		"version_check",
+		"wait",
+		"wait_for_clusters",
+	})
+
+	// Deprecations
+	// TODO remove in 0.5.0
+	flattenKeys(parsed, []string{
+		"auth",
+		"ssl",
+	})
+	if auth, ok := parsed["auth"]; ok {
+		log.Printf("[WARN] auth is now a child stanza inside consul instead of a " +
+			"top-level stanza. Update your configuration files and change auth {} " +
+			"to consul { auth { ... } } instead.")
+		consul, ok := parsed["consul"].(map[string]interface{})
+		if !ok {
+			consul = map[string]interface{}{}
+		}
+		consul["auth"] = auth
+		parsed["consul"] = consul
+		delete(parsed, "auth")
+	}
+	if _, ok := parsed["path"]; ok {
+		log.Printf("[WARN] path is no longer a key in the configuration. Please " +
+			"remove it and use the CLI option instead.")
+		delete(parsed, "path")
+	}
+	if retry, ok := parsed["retry"]; ok {
+		log.Printf("[WARN] retry is now a child stanza for consul instead of a " +
+			"top-level stanza. Update your configuration files and change retry {} " +
+			"to consul { retry { ... } } instead.")
+
+		consul, ok := parsed["consul"].(map[string]interface{})
+		if !ok {
+			consul = map[string]interface{}{}
+		}
+
+		r := map[string]interface{}{
+			"backoff":     retry,
+			"max_backoff": retry,
+		}
+
+		consul["retry"] = r
+		parsed["consul"] = consul
+
+		delete(parsed, "retry")
+	}
+	if ssl, ok := parsed["ssl"]; ok {
+		log.Printf("[WARN] ssl is now a child stanza for consul instead of a " +
+			"top-level stanza. Update your configuration files and change ssl {} " +
+			"to consul { ssl { ... } } instead.")
+
+		consul, ok := parsed["consul"].(map[string]interface{})
+		if !ok {
+			consul = map[string]interface{}{}
+		}
+
+		consul["ssl"] = ssl
+		parsed["consul"] = consul
+
+		delete(parsed, "ssl")
+	}
+	if token, ok := parsed["token"]; ok {
+		log.Printf("[WARN] token is now a child stanza inside consul instead of a " +
+			"top-level key. Update your configuration files and change token = \"...\" " +
+			"to consul { token = \"...\" } instead.")
+		consul, ok := parsed["consul"].(map[string]interface{})
+		if !ok {
+			consul = map[string]interface{}{}
+		}
+		consul["token"] = token
+		parsed["consul"] = consul
+		delete(parsed, "token")
+	}
+
+	// The tokens of the datacenters are not part of the client configuration
+	// of consul-template, so they are decoded next to it
+	for _, block := range []string{"consul", "destination_consul"} {
+		if consul, ok := parsed[block].(map[string]interface{}); ok {
+			if tokens, ok := consul["tokens"]; ok {
+				parsed[block+"_tokens"] = tokens
+				delete(consul, "tokens")
+			}
+		}
+	}
+
+	// Create a new, empty config
+	var c Config
+
+	// Use mapstructure to populate the basic config fields
+	var md mapstructure.Metadata
+	decoder, err := mapstructure.NewDecoder(&mapstructure.DecoderConfig{
+		DecodeHook: mapstructure.ComposeDecodeHookFunc(
+			StringToPrefixConfigFunc(),
+			MapToPrefixConfigFunc(),
+			StringToExcludeConfigFunc(),
+			config.ConsulStringToStructFunc(),
+			config.StringToFileModeFunc(),
+			signals.StringToSignalFunc(),
+			config.StringToWaitDurationHookFunc(),
+			mapstructure.StringToSliceHookFunc(","),
+			mapstructure.StringToTimeDurationHookFunc(),
+		),
+		ErrorUnused: true,
+		Metadata:    &md,
+		Result:      &c,
+	})
+	if err != nil {
+		return nil, errors.Wrap(err, "mapstructure decoder creation failed")
+	}
+	if err := decoder.Decode(parsed); err != nil {
+		return nil, errors.Wrap(err, "mapstructure decode failed")
+	}
+	c.Replicators = replicators
+
+	return &c, nil
+}
+
+// Must returns a config object that must compile. If there are any errors, this
+// function will panic. This is most useful in testing or constants.
+func Must(s string) *Config {
+	c, err := Parse(s)
+	if err != nil {
+		log.Fatal(err)
+	}
+	return c
+}
+
+// TestConfig returns a default, finalized config, with the provided
+// configuration taking precedence.
+func TestConfig(c *Config) *Config {
+	d := DefaultConfig().Merge(c)
+	d.Finalize()
+	return d
+}
+
+// FromFile reads the configuration file at the given path and returns a new
+// Config struct with the data populated. Imports are resolved relative to the
+// directory of the file.
+func FromFile(path string) (*Config, error) {
+	return NewConfigLoader().File(path)
+}
+
+// FromPath reads the configuration file at the given path, or merges every
+// file in the given directory, returning the resulting config. See
+// ConfigLoader.Path for the order files are merged in.
+func FromPath(path string) (*Config, error) {
+	return NewConfigLoader().Path(path)
+}
+
+func stringFromEnv(list []string, def string) *string {
+	for _, s := range list {
+		if v := os.Getenv(s); v != "" {
+			return config.String(strings.TrimSpace(v))
+		}
+	}
+	return config.String(def)
+}
+
+// flattenKeys is a function that takes a map[string]interface{} and recursively
+// flattens any keys that are a []map[string]interface{} where the key is in the
+// given list of keys.
+func flattenKeys(m map[string]interface{}, keys []string) {
+	keyMap := make(map[string]struct{})
+	for _, key := range keys {
+		keyMap[key] = struct{}{}
+	}
+
+	var flatten func(map[string]interface{}, string)
+	flatten = func(m map[string]interface{}, parent string) {
+		for k, v := range m {
+			// Calculate the map key, since it could include a parent.
+			mapKey := k
+			if parent != "" {
+				mapKey = parent + "." + k
+			}
+
+			if _, ok := keyMap[mapKey]; !ok {
+				continue
+			}
+
+			switch typed := v.(type) {
+			case []map[string]interface{}:
+				if len(typed) > 0 {
+					last := typed[len(typed)-1]
+					flatten(last, mapKey)
+					m[k] = last
+				} else {
+					m[k] = nil
+				}
+			case map[string]interface{}:
+				flatten(typed, mapKey)
+				m[k] = typed
+			default:
+				m[k] = v
+			}
+		}
+	}
+
+	flatten(m, "")
+}
+
+// regexpMatch matches the given regexp and extracts the match groups into a
+// named map.
+func regexpMatch(re *regexp.Regexp, q string) map[string]string {
+	names := re.SubexpNames()
+	match := re.FindAllStringSubmatch(q, -1)
+
+	if len(match) == 0 {
+		return map[string]string{}
+	}
+
+	m := map[string]string{}
+	for i, n := range match[0] {
+		if names[i] != "" {
+			m[names[i]] = n
+		}
+	}
+
+	return m
+}
@@ -0,0 +1,249 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: MPL-2.0
+
+package replicate
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/hashicorp/consul-template/config"
+)
+
+const (
+	// DefaultAlertsErrorWindow is the default window failed passes are counted
+	// in.
+	DefaultAlertsErrorWindow = 10 * time.Minute
+
+	// DefaultAlertsInterval is the default interval between evaluations of the
+	// alert rules.
+	DefaultAlertsInterval = 30 * time.Second
+
+	// DefaultAlertsTimeout is the default maximum amount of time a
+	// notification may take.
+	DefaultAlertsTimeout = 10 * time.Second
+)
+
+// AlertsConfig is the configuration of the threshold rules evaluated for
+// every prefix, which notify a command or a webhook when they fire and when
+// they resolve.
+type AlertsConfig struct {
+	// Command is run with the alert on its standard input whenever an alert
+	// fires or resolves.
+	Command *string `mapstructure:"command"`
+
+	// Enabled turns on the alert rules. It defaults to true if a command or
+	// URL is given.
+	Enabled *bool `mapstructure:"enabled"`
+
+	// ErrorWindow is the window the failed passes of max_errors are counted in.
+	ErrorWindow *time.Duration `mapstructure:"error_window"`
+
+	// Format is the body POSTed to the URL: "json" for the alert as JSON, or
+	// "slack" or "teams" for a message of an incoming webhook of Slack or
+	// Microsoft Teams.
+	Format *string `mapstructure:"format"`
+
+	// Interval is the time between evaluations of the rules.
+	Interval *time.Duration `mapstructure:"interval"`
+
+	// MaxErrors fires an alert when more passes of a prefix than this failed
+	// within the error window. Zero disables the rule.
+	MaxErrors *int `mapstructure:"max_errors"`
+
+	// MaxIdle fires an alert when the passes of a prefix replicated zero keys
+	// for longer than this, for sources which are expected to change steadily.
+	// Zero disables the rule.
+	MaxIdle *time.Duration `mapstructure:"max_idle"`
+
+	// MaxLag fires an alert when the destination of a prefix is behind its
+	// source for longer than this, which is the time since its first failed
+	// pass after the last successful one. Zero disables the rule.
+	MaxLag *time.Duration `mapstructure:"max_lag"`
+
+	// Template is a Go template of the body POSTed to the URL, rendered with the
+	// alert, which replaces the body of the format.
+	Template *string `mapstructure:"template"`
+
+	// Timeout is the maximum amount of time a notification may take.
+	Timeout *time.Duration `mapstructure:"timeout"`
+
+	// URL receives a POST of the alert whenever an alert fires or resolves.
+	URL *string `mapstructure:"url"`
+}
+
+func DefaultAlertsConfig() *AlertsConfig {
+	return &AlertsConfig{}
+}
+
+func (c *AlertsConfig) Copy() *AlertsConfig {
+	if c == nil {
+		return nil
+	}
+
+	var o AlertsConfig
+
+	o.Command = c.Command
+
+	o.Enabled = c.Enabled
+
+	o.ErrorWindow = c.ErrorWindow
+
+	o.Format = c.Format
+
+	o.Interval = c.Interval
+
+	o.MaxErrors = c.MaxErrors
+
+	o.MaxIdle = c.MaxIdle
+
+	o.MaxLag = c.MaxLag
+
+	o.Template = c.Template
+
+	o.Timeout = c.Timeout
+
+	o.URL = c.URL
+
+	return &o
+}
+
+func (c *AlertsConfig) Merge(o *AlertsConfig) *AlertsConfig {
+	if c == nil {
+		if o == nil {
+			return nil
+		}
+		return o.Copy()
+	}
+
+	if o == nil {
+		return c.Copy()
+	}
+
+	r := c.Copy()
+
+	if o.Command != nil {
+		r.Command = o.Command
+	}
+
+	if o.Enabled != nil {
+		r.Enabled = o.Enabled
+	}
+
+	if o.ErrorWindow != nil {
+		r.ErrorWindow = o.ErrorWindow
+	}
+
+	if o.Format != nil {
+		r.Format = o.Format
+	}
+
+	if o.Interval != nil {
+		r.Interval = o.Interval
+	}
+
+	if o.MaxErrors != nil {
+		r.MaxErrors = o.MaxErrors
+	}
+
+	if o.MaxIdle != nil {
+		r.MaxIdle = o.MaxIdle
+	}
+
+	if o.MaxLag != nil {
+		r.MaxLag = o.MaxLag
+	}
+
+	if o.Template != nil {
+		r.Template = o.Template
+	}
+
+	if o.Timeout != nil {
+		r.Timeout = o.Timeout
+	}
+
+	if o.URL != nil {
+		r.URL = o.URL
+	}
+
+	return r
+}
+
+func (c *AlertsConfig) Finalize() {
+	if c.Command == nil {
+		c.Command = config.String("")
+	}
+
+	if c.ErrorWindow == nil {
+		c.ErrorWindow = config.TimeDuration(DefaultAlertsErrorWindow)
+	}
+
+	if c.Format == nil {
+		c.Format = config.String(AlertFormatJSON)
+	}
+
+	if c.Interval == nil {
+		c.Interval = config.TimeDuration(DefaultAlertsInterval)
+	}
+
+	if c.MaxErrors == nil {
+		c.MaxErrors = config.Int(0)
+	}
+
+	if c.MaxIdle == nil {
+		c.MaxIdle = config.TimeDuration(0)
+	}
+
+	if c.MaxLag == nil {
+		c.MaxLag = config.TimeDuration(0)
+	}
+
+	if c.Template == nil {
+		c.Template = config.String("")
+	}
+
+	if c.Timeout == nil {
+		c.Timeout = config.TimeDuration(DefaultAlertsTimeout)
+	}
+
+	if c.URL == nil {
+		c.URL = config.String("")
+	}
+
+	if c.Enabled == nil {
+		c.Enabled = config.Bool(config.StringPresent(c.Command) ||
+			config.StringPresent(c.URL))
+	}
+}
+
+func (c *AlertsConfig) GoString() string {
+	if c == nil {
+		return "(*AlertsConfig)(nil)"
+	}
+
+	return fmt.Sprintf("&AlertsConfig{"+
+		"Command:%s, "+
+		"Enabled:%s, "+
+		"ErrorWindow:%s, "+
+		"Format:%s, "+
+		"Interval:%s, "+
+		"MaxErrors:%s, "+
+		"MaxIdle:%s, "+
+		"MaxLag:%s, "+
+		"Template:%s, "+
+		"Timeout:%s, "+
+		"URL:%s"+
+		"}",
+		config.StringGoString(c.Command),
+		config.BoolGoString(c.Enabled),
+		config.TimeDurationGoString(c.ErrorWindow),
+		config.StringGoString(c.Format),
+		config.TimeDurationGoString(c.Interval),
+		config.IntGoString(c.MaxErrors),
+		config.TimeDurationGoString(c.MaxIdle),
+		config.TimeDurationGoString(c.MaxLag),
+		config.StringGoString(c.Template),
+		config.TimeDurationGoString(c.Timeout),
+		config.StringGoString(c.URL),
+	)
+}
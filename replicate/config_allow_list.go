@@ -0,0 +1,109 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: MPL-2.0
+
+package replicate
+
+import (
+	"fmt"
+
+	"github.com/hashicorp/consul-template/config"
+)
+
+// AllowListConfig replicates only the source keys enumerated under a folder of
+// the destination Consul, the inverse of excluding keys. The folder is watched,
+// so keys added to or removed from it take effect without a reload.
+type AllowListConfig struct {
+	// AllowEmpty lets an empty allow list delete the destination copies of
+	// every key. Otherwise an empty allow list is an error, since the folder
+	// also looks empty when it was deleted or the token cannot list it.
+	AllowEmpty *bool `mapstructure:"allow_empty"`
+
+	// Enabled replicates only the allowed keys. Specifying a path also enables
+	// it.
+	Enabled *bool `mapstructure:"enabled"`
+
+	// Path is the folder of the allow list in the destination Consul. Every
+	// key under it allows the source key with its path relative to the folder,
+	// whatever its value, such as "allow/service/web/port" for the folder
+	// "allow" allowing "service/web/port".
+	Path *string `mapstructure:"path"`
+}
+
+func DefaultAllowListConfig() *AllowListConfig {
+	return &AllowListConfig{}
+}
+
+func (c *AllowListConfig) Copy() *AllowListConfig {
+	if c == nil {
+		return nil
+	}
+
+	var o AllowListConfig
+
+	o.AllowEmpty = c.AllowEmpty
+
+	o.Enabled = c.Enabled
+
+	o.Path = c.Path
+
+	return &o
+}
+
+func (c *AllowListConfig) Merge(o *AllowListConfig) *AllowListConfig {
+	if c == nil {
+		if o == nil {
+			return nil
+		}
+		return o.Copy()
+	}
+
+	if o == nil {
+		return c.Copy()
+	}
+
+	r := c.Copy()
+
+	if o.AllowEmpty != nil {
+		r.AllowEmpty = o.AllowEmpty
+	}
+
+	if o.Enabled != nil {
+		r.Enabled = o.Enabled
+	}
+
+	if o.Path != nil {
+		r.Path = o.Path
+	}
+
+	return r
+}
+
+func (c *AllowListConfig) Finalize() {
+	if c.AllowEmpty == nil {
+		c.AllowEmpty = config.Bool(false)
+	}
+
+	if c.Enabled == nil {
+		c.Enabled = config.Bool(config.StringPresent(c.Path))
+	}
+
+	if c.Path == nil {
+		c.Path = config.String("")
+	}
+}
+
+func (c *AllowListConfig) GoString() string {
+	if c == nil {
+		return "(*AllowListConfig)(nil)"
+	}
+
+	return fmt.Sprintf("&AllowListConfig{"+
+		"AllowEmpty:%s, "+
+		"Enabled:%s, "+
+		"Path:%s"+
+		"}",
+		config.BoolGoString(c.AllowEmpty),
+		config.BoolGoString(c.Enabled),
+		config.StringGoString(c.Path),
+	)
+}
@@ -0,0 +1,126 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: MPL-2.0
+
+package replicate
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/hashicorp/consul-template/config"
+)
+
+// DefaultBackupRetention is the default amount of time a backup is kept.
+const DefaultBackupRetention = 7 * 24 * time.Hour
+
+// BackupConfig backs up the destination keys a pass deletes before it deletes
+// them, which gives a cheap way to restore deleted keys without snapshots of
+// the whole cluster.
+type BackupConfig struct {
+	// ConsulPath is the folder of the destination Consul the backups are
+	// written into, one key per backup.
+	ConsulPath *string `mapstructure:"consul_path"`
+
+	// Dir is the folder on disk the backups are written into, one file per
+	// backup.
+	Dir *string `mapstructure:"dir"`
+
+	// Enabled writes backups. It defaults to true if a folder or Consul path is
+	// given.
+	Enabled *bool `mapstructure:"enabled"`
+
+	// Retention is the amount of time a backup is kept, after which it is
+	// removed when a later backup is written.
+	Retention *time.Duration `mapstructure:"retention"`
+}
+
+func DefaultBackupConfig() *BackupConfig {
+	return &BackupConfig{}
+}
+
+func (c *BackupConfig) Copy() *BackupConfig {
+	if c == nil {
+		return nil
+	}
+
+	var o BackupConfig
+
+	o.ConsulPath = c.ConsulPath
+
+	o.Dir = c.Dir
+
+	o.Enabled = c.Enabled
+
+	o.Retention = c.Retention
+
+	return &o
+}
+
+func (c *BackupConfig) Merge(o *BackupConfig) *BackupConfig {
+	if c == nil {
+		if o == nil {
+			return nil
+		}
+		return o.Copy()
+	}
+
+	if o == nil {
+		return c.Copy()
+	}
+
+	r := c.Copy()
+
+	if o.ConsulPath != nil {
+		r.ConsulPath = o.ConsulPath
+	}
+
+	if o.Dir != nil {
+		r.Dir = o.Dir
+	}
+
+	if o.Enabled != nil {
+		r.Enabled = o.Enabled
+	}
+
+	if o.Retention != nil {
+		r.Retention = o.Retention
+	}
+
+	return r
+}
+
+func (c *BackupConfig) Finalize() {
+	if c.ConsulPath == nil {
+		c.ConsulPath = config.String("")
+	}
+
+	if c.Dir == nil {
+		c.Dir = config.String("")
+	}
+
+	if c.Enabled == nil {
+		c.Enabled = config.Bool(config.StringVal(c.ConsulPath) != "" || config.StringVal(c.Dir) != "")
+	}
+
+	if c.Retention == nil {
+		c.Retention = config.TimeDuration(DefaultBackupRetention)
+	}
+}
+
+func (c *BackupConfig) GoString() string {
+	if c == nil {
+		return "(*BackupConfig)(nil)"
+	}
+
+	return fmt.Sprintf("&BackupConfig{"+
+		"ConsulPath:%s, "+
+		"Dir:%s, "+
+		"Enabled:%s, "+
+		"Retention:%s"+
+		"}",
+		config.StringGoString(c.ConsulPath),
+		config.StringGoString(c.Dir),
+		config.BoolGoString(c.Enabled),
+		config.TimeDurationGoString(c.Retention),
+	)
+}
@@ -0,0 +1,89 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: MPL-2.0
+
+package replicate
+
+import (
+	"fmt"
+
+	"github.com/hashicorp/consul-template/config"
+)
+
+// BandwidthConfig caps the bandwidth used to read from the source and to write
+// into the destination, for WAN links with transfer costs or little capacity.
+type BandwidthConfig struct {
+	// DestinationLimit is the maximum number of bytes per second written into
+	// the destination. Zero means no limit.
+	DestinationLimit *int `mapstructure:"destination_limit"`
+
+	// SourceLimit is the maximum number of bytes per second read from the
+	// source. Zero means no limit.
+	SourceLimit *int `mapstructure:"source_limit"`
+}
+
+func DefaultBandwidthConfig() *BandwidthConfig {
+	return &BandwidthConfig{}
+}
+
+func (c *BandwidthConfig) Copy() *BandwidthConfig {
+	if c == nil {
+		return nil
+	}
+
+	var o BandwidthConfig
+
+	o.DestinationLimit = c.DestinationLimit
+
+	o.SourceLimit = c.SourceLimit
+
+	return &o
+}
+
+func (c *BandwidthConfig) Merge(o *BandwidthConfig) *BandwidthConfig {
+	if c == nil {
+		if o == nil {
+			return nil
+		}
+		return o.Copy()
+	}
+
+	if o == nil {
+		return c.Copy()
+	}
+
+	r := c.Copy()
+
+	if o.DestinationLimit != nil {
+		r.DestinationLimit = o.DestinationLimit
+	}
+
+	if o.SourceLimit != nil {
+		r.SourceLimit = o.SourceLimit
+	}
+
+	return r
+}
+
+func (c *BandwidthConfig) Finalize() {
+	if c.DestinationLimit == nil {
+		c.DestinationLimit = config.Int(0)
+	}
+
+	if c.SourceLimit == nil {
+		c.SourceLimit = config.Int(0)
+	}
+}
+
+func (c *BandwidthConfig) GoString() string {
+	if c == nil {
+		return "(*BandwidthConfig)(nil)"
+	}
+
+	return fmt.Sprintf("&BandwidthConfig{"+
+		"DestinationLimit:%s, "+
+		"SourceLimit:%s"+
+		"}",
+		config.IntGoString(c.DestinationLimit),
+		config.IntGoString(c.SourceLimit),
+	)
+}
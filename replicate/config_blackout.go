@@ -0,0 +1,151 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: MPL-2.0
+
+package replicate
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/hashicorp/consul-template/config"
+)
+
+// BlackoutConfig is a recurring window during which a prefix is not written
+// to the destination.
+type BlackoutConfig struct {
+	// Duration is how long the window stays open from every time matching the
+	// schedule.
+	Duration *time.Duration `mapstructure:"duration"`
+
+	// Schedule is the cron expression of the times the window opens, in the
+	// local time of the replicator, such as "0 9 * * 1-5".
+	Schedule *string `mapstructure:"schedule"`
+}
+
+func DefaultBlackoutConfig() *BlackoutConfig {
+	return &BlackoutConfig{}
+}
+
+func (c *BlackoutConfig) Copy() *BlackoutConfig {
+	if c == nil {
+		return nil
+	}
+
+	var o BlackoutConfig
+
+	o.Duration = c.Duration
+
+	o.Schedule = c.Schedule
+
+	return &o
+}
+
+func (c *BlackoutConfig) Merge(o *BlackoutConfig) *BlackoutConfig {
+	if c == nil {
+		if o == nil {
+			return nil
+		}
+		return o.Copy()
+	}
+
+	if o == nil {
+		return c.Copy()
+	}
+
+	r := c.Copy()
+
+	if o.Duration != nil {
+		r.Duration = o.Duration
+	}
+
+	if o.Schedule != nil {
+		r.Schedule = o.Schedule
+	}
+
+	return r
+}
+
+func (c *BlackoutConfig) Finalize() {
+	if c.Duration == nil {
+		c.Duration = config.TimeDuration(0)
+	}
+
+	if c.Schedule == nil {
+		c.Schedule = config.String("")
+	}
+}
+
+func (c *BlackoutConfig) GoString() string {
+	if c == nil {
+		return "(*BlackoutConfig)(nil)"
+	}
+
+	return fmt.Sprintf("&BlackoutConfig{"+
+		"Duration:%s, "+
+		"Schedule:%s"+
+		"}",
+		config.TimeDurationGoString(c.Duration),
+		config.StringGoString(c.Schedule),
+	)
+}
+
+type BlackoutConfigs []*BlackoutConfig
+
+func DefaultBlackoutConfigs() *BlackoutConfigs {
+	return &BlackoutConfigs{}
+}
+
+func (c *BlackoutConfigs) Copy() *BlackoutConfigs {
+	if c == nil {
+		return nil
+	}
+
+	o := make(BlackoutConfigs, len(*c))
+	for i, t := range *c {
+		o[i] = t.Copy()
+	}
+	return &o
+}
+
+func (c *BlackoutConfigs) Merge(o *BlackoutConfigs) *BlackoutConfigs {
+	if c == nil {
+		if o == nil {
+			return nil
+		}
+		return o.Copy()
+	}
+
+	if o == nil {
+		return c.Copy()
+	}
+
+	r := c.Copy()
+
+	*r = append(*r, *o...)
+
+	return r
+}
+
+func (c *BlackoutConfigs) Finalize() {
+	if c == nil {
+		*c = *DefaultBlackoutConfigs()
+	}
+
+	for _, t := range *c {
+		t.Finalize()
+	}
+}
+
+func (c *BlackoutConfigs) GoString() string {
+	if c == nil {
+		return "(*BlackoutConfigs)(nil)"
+	}
+
+	s := make([]string, len(*c))
+	for i, t := range *c {
+		s[i] = t.GoString()
+	}
+
+	return "{" + strings.Join(s, ", ") + "}"
+}
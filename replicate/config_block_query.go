@@ -0,0 +1,137 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: MPL-2.0
+
+package replicate
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/hashicorp/consul-template/config"
+)
+
+const (
+	// DefaultBlockQueryWait is the default amount of time a blocking query
+	// waits for a change, which is the default of Consul.
+	DefaultBlockQueryWait = 5 * time.Minute
+
+	// DefaultBlockQueryBackoff is the default delay added before queries once
+	// the source reports it is under load.
+	DefaultBlockQueryBackoff = 1 * time.Second
+
+	// DefaultBlockQueryMaxBackoff is the default maximum delay added before
+	// queries while the source is under load.
+	DefaultBlockQueryMaxBackoff = 1 * time.Minute
+)
+
+// BlockQueryConfig is the configuration of the blocking queries which watch
+// the source.
+type BlockQueryConfig struct {
+	// Backoff is the delay added before every query once the source responds
+	// with 429 or 5xx, which doubles with every such response up to MaxBackoff
+	// and halves with every successful one. Zero disables the backoff.
+	Backoff *time.Duration `mapstructure:"backoff"`
+
+	// Jitter is the maximum random amount of time added to the wait of every
+	// query, so watches started together do not return together.
+	Jitter *time.Duration `mapstructure:"jitter"`
+
+	// MaxBackoff is the maximum delay added before every query. Zero leaves
+	// the delay without an upper limit.
+	MaxBackoff *time.Duration `mapstructure:"max_backoff"`
+
+	// Wait is the amount of time a blocking query waits for a change before
+	// returning.
+	Wait *time.Duration `mapstructure:"wait"`
+}
+
+func DefaultBlockQueryConfig() *BlockQueryConfig {
+	return &BlockQueryConfig{}
+}
+
+func (c *BlockQueryConfig) Copy() *BlockQueryConfig {
+	if c == nil {
+		return nil
+	}
+
+	var o BlockQueryConfig
+
+	o.Backoff = c.Backoff
+
+	o.Jitter = c.Jitter
+
+	o.MaxBackoff = c.MaxBackoff
+
+	o.Wait = c.Wait
+
+	return &o
+}
+
+func (c *BlockQueryConfig) Merge(o *BlockQueryConfig) *BlockQueryConfig {
+	if c == nil {
+		if o == nil {
+			return nil
+		}
+		return o.Copy()
+	}
+
+	if o == nil {
+		return c.Copy()
+	}
+
+	r := c.Copy()
+
+	if o.Backoff != nil {
+		r.Backoff = o.Backoff
+	}
+
+	if o.Jitter != nil {
+		r.Jitter = o.Jitter
+	}
+
+	if o.MaxBackoff != nil {
+		r.MaxBackoff = o.MaxBackoff
+	}
+
+	if o.Wait != nil {
+		r.Wait = o.Wait
+	}
+
+	return r
+}
+
+func (c *BlockQueryConfig) Finalize() {
+	if c.Backoff == nil {
+		c.Backoff = config.TimeDuration(DefaultBlockQueryBackoff)
+	}
+
+	if c.Jitter == nil {
+		c.Jitter = config.TimeDuration(0)
+	}
+
+	if c.MaxBackoff == nil {
+		c.MaxBackoff = config.TimeDuration(DefaultBlockQueryMaxBackoff)
+	}
+
+	if c.Wait == nil {
+		c.Wait = config.TimeDuration(DefaultBlockQueryWait)
+	}
+}
+
+func (c *BlockQueryConfig) GoString() string {
+	if c == nil {
+		return "(*BlockQueryConfig)(nil)"
+	}
+
+	return fmt.Sprintf("&BlockQueryConfig{"+
+		"Backoff:%s, "+
+		"Jitter:%s, "+
+		"MaxBackoff:%s, "+
+		"Wait:%s"+
+		"}",
+		config.TimeDurationGoString(c.Backoff),
+		config.TimeDurationGoString(c.Jitter),
+		config.TimeDurationGoString(c.MaxBackoff),
+		config.TimeDurationGoString(c.Wait),
+	)
+}
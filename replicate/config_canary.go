@@ -0,0 +1,147 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: MPL-2.0
+
+package replicate
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/hashicorp/consul-template/config"
+)
+
+const (
+	// DefaultCanaryTimeout is the default maximum amount of time a validation
+	// hook may take.
+	DefaultCanaryTimeout = 30 * time.Second
+
+	// canaryStagingSuffix is appended to the destination of a prefix to name
+	// its default staging destination.
+	canaryStagingSuffix = "-staging"
+)
+
+// CanaryConfig stages the changes of a prefix before they are promoted into
+// the live destination, so they can be validated before consumers see them.
+type CanaryConfig struct {
+	// Command is run once the staged tree differs from the live one. A non-zero
+	// exit status rejects the changes, which are not promoted.
+	Command *string `mapstructure:"command"`
+
+	// Enabled replicates the prefix into the staging destination, and promotes
+	// the staged tree once validated.
+	Enabled *bool `mapstructure:"enabled"`
+
+	// Staging is the destination changes are staged into. It defaults to the
+	// destination of the prefix followed by "-staging".
+	Staging *string `mapstructure:"staging"`
+
+	// Timeout is the maximum amount of time a validation hook may take.
+	Timeout *time.Duration `mapstructure:"timeout"`
+
+	// URL receives a POST once the staged tree differs from the live one. A
+	// response code other than 2xx rejects the changes.
+	URL *string `mapstructure:"url"`
+}
+
+func DefaultCanaryConfig() *CanaryConfig {
+	return &CanaryConfig{}
+}
+
+func (c *CanaryConfig) Copy() *CanaryConfig {
+	if c == nil {
+		return nil
+	}
+
+	var o CanaryConfig
+
+	o.Command = c.Command
+
+	o.Enabled = c.Enabled
+
+	o.Staging = c.Staging
+
+	o.Timeout = c.Timeout
+
+	o.URL = c.URL
+
+	return &o
+}
+
+func (c *CanaryConfig) Merge(o *CanaryConfig) *CanaryConfig {
+	if c == nil {
+		if o == nil {
+			return nil
+		}
+		return o.Copy()
+	}
+
+	if o == nil {
+		return c.Copy()
+	}
+
+	r := c.Copy()
+
+	if o.Command != nil {
+		r.Command = o.Command
+	}
+
+	if o.Enabled != nil {
+		r.Enabled = o.Enabled
+	}
+
+	if o.Staging != nil {
+		r.Staging = o.Staging
+	}
+
+	if o.Timeout != nil {
+		r.Timeout = o.Timeout
+	}
+
+	if o.URL != nil {
+		r.URL = o.URL
+	}
+
+	return r
+}
+
+func (c *CanaryConfig) Finalize() {
+	if c.Command == nil {
+		c.Command = config.String("")
+	}
+
+	if c.Enabled == nil {
+		c.Enabled = config.Bool(false)
+	}
+
+	if c.Staging == nil {
+		c.Staging = config.String("")
+	}
+
+	if c.Timeout == nil {
+		c.Timeout = config.TimeDuration(DefaultCanaryTimeout)
+	}
+
+	if c.URL == nil {
+		c.URL = config.String("")
+	}
+}
+
+func (c *CanaryConfig) GoString() string {
+	if c == nil {
+		return "(*CanaryConfig)(nil)"
+	}
+
+	return fmt.Sprintf("&CanaryConfig{"+
+		"Command:%s, "+
+		"Enabled:%s, "+
+		"Staging:%s, "+
+		"Timeout:%s, "+
+		"URL:%s"+
+		"}",
+		config.StringGoString(c.Command),
+		config.BoolGoString(c.Enabled),
+		config.StringGoString(c.Staging),
+		config.TimeDurationGoString(c.Timeout),
+		config.StringGoString(c.URL),
+	)
+}
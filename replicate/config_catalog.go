@@ -0,0 +1,217 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: MPL-2.0
+
+package replicate
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/hashicorp/consul-template/config"
+)
+
+// DefaultCatalogInterval is the default interval between reads of the catalog
+// of a datacenter.
+const DefaultCatalogInterval = 1 * time.Minute
+
+// CatalogConfig materializes the catalog of a source datacenter into a
+// destination KV subtree.
+type CatalogConfig struct {
+	// Datacenter is the source datacenter whose catalog is read. It defaults to
+	// the datacenter of the source agent.
+	Datacenter *string `mapstructure:"datacenter"`
+
+	// Destination is the path the catalog is written under.
+	Destination *string `mapstructure:"destination"`
+
+	// Health adds the aggregated health state of every service instance.
+	Health *bool `mapstructure:"health"`
+
+	// Interval is the time between reads of the catalog.
+	Interval *time.Duration `mapstructure:"interval"`
+
+	// Nodes also writes the address and metadata of every node.
+	Nodes *bool `mapstructure:"nodes"`
+
+	// Services is the list of services which are written. Every service is
+	// written if it is empty.
+	Services []string `mapstructure:"services"`
+}
+
+func DefaultCatalogConfig() *CatalogConfig {
+	return &CatalogConfig{}
+}
+
+func (c *CatalogConfig) Copy() *CatalogConfig {
+	if c == nil {
+		return nil
+	}
+
+	var o CatalogConfig
+
+	o.Datacenter = c.Datacenter
+
+	o.Destination = c.Destination
+
+	o.Health = c.Health
+
+	o.Interval = c.Interval
+
+	o.Nodes = c.Nodes
+
+	if c.Services != nil {
+		o.Services = append([]string{}, c.Services...)
+	}
+
+	return &o
+}
+
+func (c *CatalogConfig) Merge(o *CatalogConfig) *CatalogConfig {
+	if c == nil {
+		if o == nil {
+			return nil
+		}
+		return o.Copy()
+	}
+
+	if o == nil {
+		return c.Copy()
+	}
+
+	r := c.Copy()
+
+	if o.Datacenter != nil {
+		r.Datacenter = o.Datacenter
+	}
+
+	if o.Destination != nil {
+		r.Destination = o.Destination
+	}
+
+	if o.Health != nil {
+		r.Health = o.Health
+	}
+
+	if o.Interval != nil {
+		r.Interval = o.Interval
+	}
+
+	if o.Nodes != nil {
+		r.Nodes = o.Nodes
+	}
+
+	if o.Services != nil {
+		r.Services = append([]string{}, o.Services...)
+	}
+
+	return r
+}
+
+func (c *CatalogConfig) Finalize() {
+	if c.Datacenter == nil {
+		c.Datacenter = config.String("")
+	}
+
+	if c.Destination == nil {
+		c.Destination = config.String("")
+	}
+
+	if c.Health == nil {
+		c.Health = config.Bool(true)
+	}
+
+	if c.Interval == nil {
+		c.Interval = config.TimeDuration(DefaultCatalogInterval)
+	}
+
+	if c.Nodes == nil {
+		c.Nodes = config.Bool(false)
+	}
+
+	if c.Services == nil {
+		c.Services = []string{}
+	}
+}
+
+func (c *CatalogConfig) GoString() string {
+	if c == nil {
+		return "(*CatalogConfig)(nil)"
+	}
+
+	return fmt.Sprintf("&CatalogConfig{"+
+		"Datacenter:%s, "+
+		"Destination:%s, "+
+		"Health:%s, "+
+		"Interval:%s, "+
+		"Nodes:%s, "+
+		"Services:%q"+
+		"}",
+		config.StringGoString(c.Datacenter),
+		config.StringGoString(c.Destination),
+		config.BoolGoString(c.Health),
+		config.TimeDurationGoString(c.Interval),
+		config.BoolGoString(c.Nodes),
+		c.Services,
+	)
+}
+
+type CatalogConfigs []*CatalogConfig
+
+func DefaultCatalogConfigs() *CatalogConfigs {
+	return &CatalogConfigs{}
+}
+
+func (c *CatalogConfigs) Copy() *CatalogConfigs {
+	if c == nil {
+		return nil
+	}
+
+	o := make(CatalogConfigs, len(*c))
+	for i, t := range *c {
+		o[i] = t.Copy()
+	}
+	return &o
+}
+
+func (c *CatalogConfigs) Merge(o *CatalogConfigs) *CatalogConfigs {
+	if c == nil {
+		if o == nil {
+			return nil
+		}
+		return o.Copy()
+	}
+
+	if o == nil {
+		return c.Copy()
+	}
+
+	r := c.Copy()
+
+	*r = append(*r, *o...)
+
+	return r
+}
+
+func (c *CatalogConfigs) Finalize() {
+	if c == nil {
+		*c = *DefaultCatalogConfigs()
+	}
+
+	for _, t := range *c {
+		t.Finalize()
+	}
+}
+
+func (c *CatalogConfigs) GoString() string {
+	if c == nil {
+		return "(*CatalogConfigs)(nil)"
+	}
+
+	s := make([]string, len(*c))
+	for i, t := range *c {
+		s[i] = t.GoString()
+	}
+
+	return "{" + strings.Join(s, ", ") + "}"
+}
@@ -0,0 +1,96 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: MPL-2.0
+
+package replicate
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/hashicorp/consul-template/config"
+)
+
+const (
+	// DefaultCertRotationInterval is the default interval between checks of
+	// the certificate files.
+	DefaultCertRotationInterval = 1 * time.Minute
+)
+
+// CertRotationConfig is the configuration of the reload of the TLS
+// certificates, keys and CAs of the ssl blocks of the source and destination,
+// so rotated certificates are picked up without a restart.
+type CertRotationConfig struct {
+	// Enabled turns on certificate rotation. It defaults to true if an
+	// interval is given.
+	Enabled *bool `mapstructure:"enabled"`
+
+	// Interval is the time between checks of the certificate files.
+	Interval *time.Duration `mapstructure:"interval"`
+}
+
+func DefaultCertRotationConfig() *CertRotationConfig {
+	return &CertRotationConfig{}
+}
+
+func (c *CertRotationConfig) Copy() *CertRotationConfig {
+	if c == nil {
+		return nil
+	}
+
+	var o CertRotationConfig
+
+	o.Enabled = c.Enabled
+
+	o.Interval = c.Interval
+
+	return &o
+}
+
+func (c *CertRotationConfig) Merge(o *CertRotationConfig) *CertRotationConfig {
+	if c == nil {
+		if o == nil {
+			return nil
+		}
+		return o.Copy()
+	}
+
+	if o == nil {
+		return c.Copy()
+	}
+
+	r := c.Copy()
+
+	if o.Enabled != nil {
+		r.Enabled = o.Enabled
+	}
+
+	if o.Interval != nil {
+		r.Interval = o.Interval
+	}
+
+	return r
+}
+
+func (c *CertRotationConfig) Finalize() {
+	if c.Enabled == nil {
+		c.Enabled = config.Bool(c.Interval != nil)
+	}
+
+	if c.Interval == nil {
+		c.Interval = config.TimeDuration(DefaultCertRotationInterval)
+	}
+}
+
+func (c *CertRotationConfig) GoString() string {
+	if c == nil {
+		return "(*CertRotationConfig)(nil)"
+	}
+
+	return fmt.Sprintf("&CertRotationConfig{"+
+		"Enabled:%s, "+
+		"Interval:%s"+
+		"}",
+		config.BoolGoString(c.Enabled),
+		config.TimeDurationGoString(c.Interval),
+	)
+}
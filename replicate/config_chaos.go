@@ -0,0 +1,124 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: MPL-2.0
+
+package replicate
+
+import (
+	"fmt"
+
+	"github.com/hashicorp/consul-template/config"
+)
+
+// DefaultChaosPercent is the default percentage of the operations of each kind
+// which fail while chaos is enabled.
+const DefaultChaosPercent = 5
+
+// ChaosConfig randomly injects failures, so alerting and recovery can be
+// validated in staging. It must never be enabled in production.
+type ChaosConfig struct {
+	// Enabled injects failures. Specifying any other option also enables it.
+	Enabled *bool `mapstructure:"enabled"`
+
+	// IndexResetPercent is the percentage of passes which see the index of
+	// the source go backwards, as after a snapshot restore.
+	IndexResetPercent *int `mapstructure:"index_reset_percent"`
+
+	// ReadErrorPercent is the percentage of reads from the source which fail.
+	ReadErrorPercent *int `mapstructure:"read_error_percent"`
+
+	// WriteErrorPercent is the percentage of writes and deletes in the
+	// destination which fail.
+	WriteErrorPercent *int `mapstructure:"write_error_percent"`
+}
+
+func DefaultChaosConfig() *ChaosConfig {
+	return &ChaosConfig{}
+}
+
+func (c *ChaosConfig) Copy() *ChaosConfig {
+	if c == nil {
+		return nil
+	}
+
+	var o ChaosConfig
+
+	o.Enabled = c.Enabled
+
+	o.IndexResetPercent = c.IndexResetPercent
+
+	o.ReadErrorPercent = c.ReadErrorPercent
+
+	o.WriteErrorPercent = c.WriteErrorPercent
+
+	return &o
+}
+
+func (c *ChaosConfig) Merge(o *ChaosConfig) *ChaosConfig {
+	if c == nil {
+		if o == nil {
+			return nil
+		}
+		return o.Copy()
+	}
+
+	if o == nil {
+		return c.Copy()
+	}
+
+	r := c.Copy()
+
+	if o.Enabled != nil {
+		r.Enabled = o.Enabled
+	}
+
+	if o.IndexResetPercent != nil {
+		r.IndexResetPercent = o.IndexResetPercent
+	}
+
+	if o.ReadErrorPercent != nil {
+		r.ReadErrorPercent = o.ReadErrorPercent
+	}
+
+	if o.WriteErrorPercent != nil {
+		r.WriteErrorPercent = o.WriteErrorPercent
+	}
+
+	return r
+}
+
+func (c *ChaosConfig) Finalize() {
+	if c.Enabled == nil {
+		c.Enabled = config.Bool(c.IndexResetPercent != nil ||
+			c.ReadErrorPercent != nil || c.WriteErrorPercent != nil)
+	}
+
+	if c.IndexResetPercent == nil {
+		c.IndexResetPercent = config.Int(DefaultChaosPercent)
+	}
+
+	if c.ReadErrorPercent == nil {
+		c.ReadErrorPercent = config.Int(DefaultChaosPercent)
+	}
+
+	if c.WriteErrorPercent == nil {
+		c.WriteErrorPercent = config.Int(DefaultChaosPercent)
+	}
+}
+
+func (c *ChaosConfig) GoString() string {
+	if c == nil {
+		return "(*ChaosConfig)(nil)"
+	}
+
+	return fmt.Sprintf("&ChaosConfig{"+
+		"Enabled:%s, "+
+		"IndexResetPercent:%s, "+
+		"ReadErrorPercent:%s, "+
+		"WriteErrorPercent:%s"+
+		"}",
+		config.BoolGoString(c.Enabled),
+		config.IntGoString(c.IndexResetPercent),
+		config.IntGoString(c.ReadErrorPercent),
+		config.IntGoString(c.WriteErrorPercent),
+	)
+}
@@ -0,0 +1,234 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: MPL-2.0
+
+package replicate
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	"github.com/hashicorp/consul-template/config"
+	"github.com/hashicorp/consul/api"
+	"github.com/pkg/errors"
+)
+
+// configWatchRetryInterval is how long to wait before watching a configuration
+// key in Consul again after an error.
+const configWatchRetryInterval = 5 * time.Second
+
+// FromConsul reads the configuration stored at the key in Consul, which is in
+// the same format as configuration files.
+func FromConsul(c *config.ConsulConfig, path string) (*Config, error) {
+	clients, err := newClientSet(c, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	pair, _, err := clients.Consul().KV().Get(path, nil)
+	if err != nil {
+		return nil, errors.Wrapf(err, "reading config from consul at %q", path)
+	}
+	if pair == nil {
+		return nil, fmt.Errorf("no config in consul at %q", path)
+	}
+
+	result, err := Parse(string(pair.Value))
+	if err != nil {
+		return nil, errors.Wrapf(err, "parsing config from consul at %q", path)
+	}
+	return result, nil
+}
+
+// WatchConsul notifies changeCh every time the configuration stored at the key
+// in Consul changes, until stopCh is closed. A deleted key is not a change, so
+// the current configuration is kept.
+func WatchConsul(c *config.ConsulConfig, path string, changeCh chan<- struct{}, stopCh <-chan struct{}) {
+	clients, err := newClientSet(c, nil)
+	if err != nil {
+		log.Printf("[ERR] (config) cannot watch config in consul at %q: %s", path, err)
+		return
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go func() {
+		select {
+		case <-stopCh:
+			cancel()
+		case <-ctx.Done():
+		}
+	}()
+
+	var index, modifyIndex uint64
+	first := true
+	for {
+		opts := &api.QueryOptions{WaitIndex: index}
+		pair, meta, err := clients.Consul().KV().Get(path, opts.WithContext(ctx))
+		if err != nil {
+			select {
+			case <-stopCh:
+				return
+			default:
+			}
+
+			log.Printf("[WARN] (config) failed to watch config in consul at %q: %s", path, err)
+			select {
+			case <-time.After(configWatchRetryInterval):
+			case <-stopCh:
+				return
+			}
+			continue
+		}
+
+		// Start over if the index went backwards, such as after a restore
+		if meta.LastIndex < index {
+			index = 0
+			continue
+		}
+		index = meta.LastIndex
+
+		switch {
+		case first:
+			// The first read is the loaded configuration
+			first = false
+			if pair != nil {
+				modifyIndex = pair.ModifyIndex
+			}
+		case pair == nil:
+			if modifyIndex != 0 {
+				log.Printf("[WARN] (config) config in consul at %q was deleted, "+
+					"keeping the current config", path)
+				modifyIndex = 0
+			}
+		case pair.ModifyIndex != modifyIndex:
+			modifyIndex = pair.ModifyIndex
+			log.Printf("[INFO] (config) config in consul at %q changed", path)
+			select {
+			case changeCh <- struct{}{}:
+			default:
+			}
+		}
+	}
+}
+
+// PrefixesFromConsul reads the prefixes stored in the folder in Consul. Every
+// key holds a prefix, or a list of prefixes, as JSON objects with the options
+// of a prefix block. Keys which cannot be parsed are skipped, so a broken
+// entry does not stop the others from being replicated.
+func PrefixesFromConsul(c *config.ConsulConfig, path string) (*PrefixConfigs, error) {
+	clients, err := newClientSet(c, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	pairs, _, err := clients.Consul().KV().List(path, nil)
+	if err != nil {
+		return nil, errors.Wrapf(err, "reading prefixes from consul at %q", path)
+	}
+
+	prefixes := DefaultPrefixConfigs()
+	for _, pair := range pairs {
+		// Folders and empty keys hold nothing
+		if strings.HasSuffix(pair.Key, "/") || len(pair.Value) == 0 {
+			continue
+		}
+
+		parsed, err := parsePrefixesJSON(pair.Value)
+		if err != nil {
+			log.Printf("[WARN] (config) skipping prefixes in consul at %q: %s", pair.Key, err)
+			continue
+		}
+		log.Printf("[DEBUG] (config) read %d prefix(es) from consul at %q", len(*parsed), pair.Key)
+		prefixes = prefixes.Merge(parsed)
+	}
+	return prefixes, nil
+}
+
+// parsePrefixesJSON parses a prefix, or a list of prefixes, as JSON objects
+// with the options of a prefix block.
+func parsePrefixesJSON(b []byte) (*PrefixConfigs, error) {
+	// The value is wrapped into a prefix block, so it must be a JSON object or
+	// a list of them, and cannot set anything else
+	var v interface{}
+	if err := json.Unmarshal(b, &v); err != nil {
+		return nil, errors.Wrap(err, "invalid JSON")
+	}
+	switch v.(type) {
+	case map[string]interface{}, []interface{}:
+	default:
+		return nil, fmt.Errorf("expected a JSON object or a list of them")
+	}
+
+	wrapped, err := json.Marshal(map[string]interface{}{"prefix": v})
+	if err != nil {
+		return nil, err
+	}
+	c, err := parseHCL(string(wrapped))
+	if err != nil {
+		return nil, err
+	}
+	if c.Prefixes == nil || len(*c.Prefixes) == 0 {
+		return nil, fmt.Errorf("no prefix")
+	}
+	return c.Prefixes, nil
+}
+
+// WatchConsulPrefixes notifies changeCh every time a key of the folder of
+// prefixes in Consul is written or deleted, until stopCh is closed.
+func WatchConsulPrefixes(c *config.ConsulConfig, path string, changeCh chan<- struct{}, stopCh <-chan struct{}) {
+	clients, err := newClientSet(c, nil)
+	if err != nil {
+		log.Printf("[ERR] (config) cannot watch prefixes in consul at %q: %s", path, err)
+		return
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go func() {
+		select {
+		case <-stopCh:
+			cancel()
+		case <-ctx.Done():
+		}
+	}()
+
+	var index uint64
+	for {
+		opts := &api.QueryOptions{WaitIndex: index}
+		_, meta, err := clients.Consul().KV().Keys(path, "", opts.WithContext(ctx))
+		if err != nil {
+			select {
+			case <-stopCh:
+				return
+			default:
+			}
+
+			log.Printf("[WARN] (config) failed to watch prefixes in consul at %q: %s", path, err)
+			select {
+			case <-time.After(configWatchRetryInterval):
+			case <-stopCh:
+				return
+			}
+			continue
+		}
+
+		// Start over if the index went backwards, such as after a restore. The
+		// first read is the loaded prefixes.
+		switch {
+		case meta.LastIndex < index:
+			index = 0
+			continue
+		case index != 0 && meta.LastIndex != index:
+			log.Printf("[INFO] (config) prefixes in consul at %q changed", path)
+			select {
+			case changeCh <- struct{}{}:
+			default:
+			}
+		}
+		index = meta.LastIndex
+	}
+}
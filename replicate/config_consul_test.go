@@ -0,0 +1,62 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: MPL-2.0
+
+package replicate
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+
+	"github.com/hashicorp/consul-template/config"
+)
+
+func TestPrefixesFromConsul(t *testing.T) {
+	values := map[string]string{
+		"prefixes/":              "",
+		"prefixes/team-a":        `{"source": "team-a/config", "datacenter": "dc1"}`,
+		"prefixes/team-b":        `[{"source": "team-b", "datacenter": "dc1", "destination": "b"}, {"source": "team-b", "datacenter": "dc2", "destination": "b2"}]`,
+		"prefixes/broken":        `{"source": "broken"`,
+		"prefixes/not-an-object": `"team-c@dc1"`,
+		"prefixes/no-datacenter": `{"source": "team-d"}`,
+		"prefixes/other-options": `{"source": "team-e", "datacenter": "dc1", "log_level": "debug"}`,
+	}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var pairs []map[string]interface{}
+		for _, key := range []string{
+			"prefixes/", "prefixes/broken", "prefixes/no-datacenter",
+			"prefixes/not-an-object", "prefixes/other-options", "prefixes/team-a",
+			"prefixes/team-b",
+		} {
+			pairs = append(pairs, map[string]interface{}{
+				"Key":   key,
+				"Value": base64.StdEncoding.EncodeToString([]byte(values[key])),
+			})
+		}
+		json.NewEncoder(w).Encode(pairs)
+	}))
+	defer srv.Close()
+
+	c := config.DefaultConsulConfig()
+	c.Address = config.String(srv.URL)
+	c.Finalize()
+
+	prefixes, err := PrefixesFromConsul(c, "prefixes/")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	exp := []string{"team-a/config@dc1:team-a/config", "team-b@dc1:b", "team-b@dc2:b2"}
+	var act []string
+	for _, p := range *prefixes {
+		act = append(act, fmt.Sprintf("%s@%s:%s", config.StringVal(p.Source),
+			config.StringVal(p.Datacenter), config.StringVal(p.Destination)))
+	}
+	if !reflect.DeepEqual(exp, act) {
+		t.Errorf("\nexp: %#v\nact: %#v", exp, act)
+	}
+}
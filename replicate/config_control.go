@@ -0,0 +1,126 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: MPL-2.0
+
+package replicate
+
+import (
+	"fmt"
+
+	"github.com/hashicorp/consul-template/config"
+)
+
+// ControlConfig is the configuration of the gRPC control API, through which a
+// central controller manages the replicators of the instance.
+type ControlConfig struct {
+	// Address is the address the API listens on.
+	Address *string `mapstructure:"address"`
+
+	// Enabled turns on the API. It defaults to true if an address is given.
+	Enabled *bool `mapstructure:"enabled"`
+
+	// Insecure serves the API without authenticating its clients, which is
+	// only permitted on a loopback address. Otherwise the API refuses to start
+	// unless SSL is enabled with Verify.
+	Insecure *bool `mapstructure:"insecure"`
+
+	// SSL is the TLS configuration of the API. Cert and Key are the server
+	// certificate, and with Verify, clients must present a certificate signed
+	// by CaCert or CaPath.
+	SSL *config.SSLConfig `mapstructure:"ssl"`
+}
+
+func DefaultControlConfig() *ControlConfig {
+	return &ControlConfig{
+		SSL: config.DefaultSSLConfig(),
+	}
+}
+
+func (c *ControlConfig) Copy() *ControlConfig {
+	if c == nil {
+		return nil
+	}
+
+	var o ControlConfig
+
+	o.Address = c.Address
+
+	o.Enabled = c.Enabled
+
+	o.Insecure = c.Insecure
+
+	if c.SSL != nil {
+		o.SSL = c.SSL.Copy()
+	}
+
+	return &o
+}
+
+func (c *ControlConfig) Merge(o *ControlConfig) *ControlConfig {
+	if c == nil {
+		if o == nil {
+			return nil
+		}
+		return o.Copy()
+	}
+
+	if o == nil {
+		return c.Copy()
+	}
+
+	r := c.Copy()
+
+	if o.Address != nil {
+		r.Address = o.Address
+	}
+
+	if o.Enabled != nil {
+		r.Enabled = o.Enabled
+	}
+
+	if o.Insecure != nil {
+		r.Insecure = o.Insecure
+	}
+
+	if o.SSL != nil {
+		r.SSL = r.SSL.Merge(o.SSL)
+	}
+
+	return r
+}
+
+func (c *ControlConfig) Finalize() {
+	if c.Address == nil {
+		c.Address = config.String("")
+	}
+
+	if c.Enabled == nil {
+		c.Enabled = config.Bool(config.StringPresent(c.Address))
+	}
+
+	if c.Insecure == nil {
+		c.Insecure = config.Bool(false)
+	}
+
+	if c.SSL == nil {
+		c.SSL = config.DefaultSSLConfig()
+	}
+	c.SSL.Finalize()
+}
+
+func (c *ControlConfig) GoString() string {
+	if c == nil {
+		return "(*ControlConfig)(nil)"
+	}
+
+	return fmt.Sprintf("&ControlConfig{"+
+		"Address:%s, "+
+		"Enabled:%s, "+
+		"Insecure:%s, "+
+		"SSL:%s"+
+		"}",
+		config.StringGoString(c.Address),
+		config.BoolGoString(c.Enabled),
+		config.BoolGoString(c.Insecure),
+		c.SSL.GoString(),
+	)
+}
@@ -0,0 +1,89 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: MPL-2.0
+
+package replicate
+
+import (
+	"fmt"
+
+	"github.com/hashicorp/consul-template/config"
+)
+
+// DebugConfig is the configuration of the debug listener, which serves the
+// pprof profiles, goroutine dumps, GC statistics and internal state of the
+// replicator.
+type DebugConfig struct {
+	// Address is the address the listener listens on.
+	Address *string `mapstructure:"address"`
+
+	// Enabled turns on the listener. It defaults to true if an address is
+	// given.
+	Enabled *bool `mapstructure:"enabled"`
+}
+
+func DefaultDebugConfig() *DebugConfig {
+	return &DebugConfig{}
+}
+
+func (c *DebugConfig) Copy() *DebugConfig {
+	if c == nil {
+		return nil
+	}
+
+	var o DebugConfig
+
+	o.Address = c.Address
+
+	o.Enabled = c.Enabled
+
+	return &o
+}
+
+func (c *DebugConfig) Merge(o *DebugConfig) *DebugConfig {
+	if c == nil {
+		if o == nil {
+			return nil
+		}
+		return o.Copy()
+	}
+
+	if o == nil {
+		return c.Copy()
+	}
+
+	r := c.Copy()
+
+	if o.Address != nil {
+		r.Address = o.Address
+	}
+
+	if o.Enabled != nil {
+		r.Enabled = o.Enabled
+	}
+
+	return r
+}
+
+func (c *DebugConfig) Finalize() {
+	if c.Address == nil {
+		c.Address = config.String("")
+	}
+
+	if c.Enabled == nil {
+		c.Enabled = config.Bool(config.StringPresent(c.Address))
+	}
+}
+
+func (c *DebugConfig) GoString() string {
+	if c == nil {
+		return "(*DebugConfig)(nil)"
+	}
+
+	return fmt.Sprintf("&DebugConfig{"+
+		"Address:%s, "+
+		"Enabled:%s"+
+		"}",
+		config.StringGoString(c.Address),
+		config.BoolGoString(c.Enabled),
+	)
+}
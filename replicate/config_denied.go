@@ -0,0 +1,118 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: MPL-2.0
+
+package replicate
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/hashicorp/consul-template/config"
+)
+
+const (
+	// DefaultDeniedBackoff is the default delay before the prefixes are
+	// retried for the first time after the destination denied the token.
+	DefaultDeniedBackoff = 1 * time.Minute
+
+	// DefaultDeniedMaxBackoff is the default maximum delay before the prefixes
+	// are retried while the destination denies the token.
+	DefaultDeniedMaxBackoff = 30 * time.Minute
+)
+
+// DeniedConfig is the configuration of the credentials invalid state, which a
+// runner enters when the destination denies its ACL token, such as a token
+// which was deleted or expired. Every prefix then waits for a long backoff
+// instead of retrying its writes, until the token works again.
+type DeniedConfig struct {
+	// Backoff is the delay before the prefixes are retried, which doubles with
+	// every consecutive denied pass up to MaxBackoff. A rotated destination
+	// token is tried right away.
+	Backoff *time.Duration `mapstructure:"backoff"`
+
+	// Enabled enters the credentials invalid state when the destination
+	// denies the token. Otherwise a denied pass fails like any other.
+	Enabled *bool `mapstructure:"enabled"`
+
+	// MaxBackoff is the maximum delay before the prefixes are retried.
+	MaxBackoff *time.Duration `mapstructure:"max_backoff"`
+}
+
+func DefaultDeniedConfig() *DeniedConfig {
+	return &DeniedConfig{}
+}
+
+func (c *DeniedConfig) Copy() *DeniedConfig {
+	if c == nil {
+		return nil
+	}
+
+	var o DeniedConfig
+
+	o.Backoff = c.Backoff
+
+	o.Enabled = c.Enabled
+
+	o.MaxBackoff = c.MaxBackoff
+
+	return &o
+}
+
+func (c *DeniedConfig) Merge(o *DeniedConfig) *DeniedConfig {
+	if c == nil {
+		if o == nil {
+			return nil
+		}
+		return o.Copy()
+	}
+
+	if o == nil {
+		return c.Copy()
+	}
+
+	r := c.Copy()
+
+	if o.Backoff != nil {
+		r.Backoff = o.Backoff
+	}
+
+	if o.Enabled != nil {
+		r.Enabled = o.Enabled
+	}
+
+	if o.MaxBackoff != nil {
+		r.MaxBackoff = o.MaxBackoff
+	}
+
+	return r
+}
+
+func (c *DeniedConfig) Finalize() {
+	if c.Backoff == nil {
+		c.Backoff = config.TimeDuration(DefaultDeniedBackoff)
+	}
+
+	if c.Enabled == nil {
+		c.Enabled = config.Bool(true)
+	}
+
+	if c.MaxBackoff == nil {
+		c.MaxBackoff = config.TimeDuration(DefaultDeniedMaxBackoff)
+	}
+}
+
+func (c *DeniedConfig) GoString() string {
+	if c == nil {
+		return "(*DeniedConfig)(nil)"
+	}
+
+	return fmt.Sprintf("&DeniedConfig{"+
+		"Backoff:%s, "+
+		"Enabled:%s, "+
+		"MaxBackoff:%s"+
+		"}",
+		config.TimeDurationGoString(c.Backoff),
+		config.BoolGoString(c.Enabled),
+		config.TimeDurationGoString(c.MaxBackoff),
+	)
+}
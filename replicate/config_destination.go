@@ -0,0 +1,158 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: MPL-2.0
+
+package replicate
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/hashicorp/consul-template/config"
+)
+
+// DestinationWritesAgent and DestinationWritesServers are the supported ways
+// writes reach the destination.
+const (
+	DestinationWritesAgent   = "agent"
+	DestinationWritesServers = "servers"
+)
+
+// DestinationConfig is the configuration for discovering the destination
+// cluster in the catalog of the source, instead of at a static address, and
+// for how its datacenters are reached.
+type DestinationConfig struct {
+	// Datacenter is the datacenter the keys and statuses are written into and
+	// read from, through the dc query parameter, so a replicator talking to an
+	// agent of a WAN-federated cluster can target any of its datacenters.
+	// Empty is the datacenter of the agent.
+	Datacenter *string `mapstructure:"datacenter"`
+
+	// MaxStale lets any server of the destination answer the reads which compare
+	// it with the source during a pass, as long as its last contact with the
+	// leader is within it, so anti-entropy passes do not load the leader of a busy
+	// destination. Writes still go to the leader. Zero reads from the leader.
+	MaxStale *time.Duration `mapstructure:"max_stale"`
+
+	// SameCluster connects to the destination with the consul block, in place
+	// of destination_consul, for a destination which is a datacenter of the
+	// WAN-federated cluster of the source, so a single address and token are
+	// configured.
+	SameCluster *bool `mapstructure:"same_cluster"`
+
+	// Service is the name of the service of the destination Consul HTTP API,
+	// optionally followed by "@" and the datacenter it is registered in, such
+	// as "consul-dest@dc2". When set, its healthy instances replace the
+	// destination_consul address, and requests fail over to another instance
+	// when the current one cannot be reached.
+	Service *string `mapstructure:"service"`
+
+	// Writes is how writes of keys reach the destination: "agent" sends them to
+	// the destination_consul address, whose agent forwards them to the leader
+	// of the destination datacenter, and "servers" sends them directly to the
+	// HTTP API of the servers of the destination datacenter, leader first, at
+	// the port of the destination_consul address.
+	Writes *string `mapstructure:"writes"`
+}
+
+func DefaultDestinationConfig() *DestinationConfig {
+	return &DestinationConfig{}
+}
+
+func (c *DestinationConfig) Copy() *DestinationConfig {
+	if c == nil {
+		return nil
+	}
+
+	var o DestinationConfig
+
+	o.Datacenter = c.Datacenter
+
+	o.MaxStale = c.MaxStale
+
+	o.SameCluster = c.SameCluster
+
+	o.Service = c.Service
+
+	o.Writes = c.Writes
+
+	return &o
+}
+
+func (c *DestinationConfig) Merge(o *DestinationConfig) *DestinationConfig {
+	if c == nil {
+		if o == nil {
+			return nil
+		}
+		return o.Copy()
+	}
+
+	if o == nil {
+		return c.Copy()
+	}
+
+	r := c.Copy()
+
+	if o.Datacenter != nil {
+		r.Datacenter = o.Datacenter
+	}
+
+	if o.MaxStale != nil {
+		r.MaxStale = o.MaxStale
+	}
+
+	if o.SameCluster != nil {
+		r.SameCluster = o.SameCluster
+	}
+
+	if o.Service != nil {
+		r.Service = o.Service
+	}
+
+	if o.Writes != nil {
+		r.Writes = o.Writes
+	}
+
+	return r
+}
+
+func (c *DestinationConfig) Finalize() {
+	if c.Datacenter == nil {
+		c.Datacenter = config.String("")
+	}
+
+	if c.MaxStale == nil {
+		c.MaxStale = config.TimeDuration(0)
+	}
+
+	if c.SameCluster == nil {
+		c.SameCluster = config.Bool(false)
+	}
+
+	if c.Service == nil {
+		c.Service = config.String("")
+	}
+
+	if c.Writes == nil {
+		c.Writes = config.String(DestinationWritesAgent)
+	}
+}
+
+func (c *DestinationConfig) GoString() string {
+	if c == nil {
+		return "(*DestinationConfig)(nil)"
+	}
+
+	return fmt.Sprintf("&DestinationConfig{"+
+		"Datacenter:%s, "+
+		"MaxStale:%s, "+
+		"SameCluster:%s, "+
+		"Service:%s, "+
+		"Writes:%s"+
+		"}",
+		config.StringGoString(c.Datacenter),
+		config.TimeDurationGoString(c.MaxStale),
+		config.BoolGoString(c.SameCluster),
+		config.StringGoString(c.Service),
+		config.StringGoString(c.Writes),
+	)
+}
@@ -0,0 +1,126 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: MPL-2.0
+
+package replicate
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/hashicorp/consul-template/config"
+)
+
+// DefaultDiffRetention is the default amount of time the diff of a pass is
+// kept.
+const DefaultDiffRetention = 7 * 24 * time.Hour
+
+// DiffConfig writes the diff of every pass which changed the destination, with
+// the keys it put and deleted and the hashes of their values, so incident
+// reviews can tell exactly what changed and when.
+type DiffConfig struct {
+	// ConsulPath is the folder of the destination Consul the diffs are written
+	// into, one key per pass.
+	ConsulPath *string `mapstructure:"consul_path"`
+
+	// Dir is the folder on disk the diffs are written into, one file per pass.
+	Dir *string `mapstructure:"dir"`
+
+	// Enabled writes diffs. It defaults to true if a folder or Consul path is
+	// given.
+	Enabled *bool `mapstructure:"enabled"`
+
+	// Retention is the amount of time a diff is kept, after which it is
+	// removed when the diff of a later pass is written.
+	Retention *time.Duration `mapstructure:"retention"`
+}
+
+func DefaultDiffConfig() *DiffConfig {
+	return &DiffConfig{}
+}
+
+func (c *DiffConfig) Copy() *DiffConfig {
+	if c == nil {
+		return nil
+	}
+
+	var o DiffConfig
+
+	o.ConsulPath = c.ConsulPath
+
+	o.Dir = c.Dir
+
+	o.Enabled = c.Enabled
+
+	o.Retention = c.Retention
+
+	return &o
+}
+
+func (c *DiffConfig) Merge(o *DiffConfig) *DiffConfig {
+	if c == nil {
+		if o == nil {
+			return nil
+		}
+		return o.Copy()
+	}
+
+	if o == nil {
+		return c.Copy()
+	}
+
+	r := c.Copy()
+
+	if o.ConsulPath != nil {
+		r.ConsulPath = o.ConsulPath
+	}
+
+	if o.Dir != nil {
+		r.Dir = o.Dir
+	}
+
+	if o.Enabled != nil {
+		r.Enabled = o.Enabled
+	}
+
+	if o.Retention != nil {
+		r.Retention = o.Retention
+	}
+
+	return r
+}
+
+func (c *DiffConfig) Finalize() {
+	if c.ConsulPath == nil {
+		c.ConsulPath = config.String("")
+	}
+
+	if c.Dir == nil {
+		c.Dir = config.String("")
+	}
+
+	if c.Enabled == nil {
+		c.Enabled = config.Bool(config.StringVal(c.ConsulPath) != "" || config.StringVal(c.Dir) != "")
+	}
+
+	if c.Retention == nil {
+		c.Retention = config.TimeDuration(DefaultDiffRetention)
+	}
+}
+
+func (c *DiffConfig) GoString() string {
+	if c == nil {
+		return "(*DiffConfig)(nil)"
+	}
+
+	return fmt.Sprintf("&DiffConfig{"+
+		"ConsulPath:%s, "+
+		"Dir:%s, "+
+		"Enabled:%s, "+
+		"Retention:%s"+
+		"}",
+		config.StringGoString(c.ConsulPath),
+		config.StringGoString(c.Dir),
+		config.BoolGoString(c.Enabled),
+		config.TimeDurationGoString(c.Retention),
+	)
+}
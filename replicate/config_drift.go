@@ -0,0 +1,73 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: MPL-2.0
+
+package replicate
+
+import (
+	"fmt"
+
+	"github.com/hashicorp/consul-template/config"
+)
+
+// DriftConfig watches the destination of every prefix, read-only, and reports
+// the keys modified by anything but the replicator, such as an operator
+// editing replicated data directly.
+type DriftConfig struct {
+	// Enabled watches the destinations for drift.
+	Enabled *bool `mapstructure:"enabled"`
+}
+
+func DefaultDriftConfig() *DriftConfig {
+	return &DriftConfig{}
+}
+
+func (c *DriftConfig) Copy() *DriftConfig {
+	if c == nil {
+		return nil
+	}
+
+	var o DriftConfig
+
+	o.Enabled = c.Enabled
+
+	return &o
+}
+
+func (c *DriftConfig) Merge(o *DriftConfig) *DriftConfig {
+	if c == nil {
+		if o == nil {
+			return nil
+		}
+		return o.Copy()
+	}
+
+	if o == nil {
+		return c.Copy()
+	}
+
+	r := c.Copy()
+
+	if o.Enabled != nil {
+		r.Enabled = o.Enabled
+	}
+
+	return r
+}
+
+func (c *DriftConfig) Finalize() {
+	if c.Enabled == nil {
+		c.Enabled = config.Bool(false)
+	}
+}
+
+func (c *DriftConfig) GoString() string {
+	if c == nil {
+		return "(*DriftConfig)(nil)"
+	}
+
+	return fmt.Sprintf("&DriftConfig{"+
+		"Enabled:%s"+
+		"}",
+		config.BoolGoString(c.Enabled),
+	)
+}
@@ -0,0 +1,104 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: MPL-2.0
+
+package replicate
+
+import (
+	"fmt"
+
+	"github.com/hashicorp/consul-template/config"
+)
+
+// HAConfig is the configuration for running several instances, of which only
+// the holder of a lock in the destination Consul replicates.
+type HAConfig struct {
+	// Enabled turns on leader election.
+	Enabled *bool `mapstructure:"enabled"`
+
+	// LockKey is the key of the lock. It defaults to "leader" within the status
+	// dir.
+	LockKey *string `mapstructure:"lock_key"`
+
+	// WarmStandby keeps the watches of standby instances running, so a new
+	// leader can start writing immediately instead of reading every prefix.
+	WarmStandby *bool `mapstructure:"warm_standby"`
+}
+
+func DefaultHAConfig() *HAConfig {
+	return &HAConfig{}
+}
+
+func (c *HAConfig) Copy() *HAConfig {
+	if c == nil {
+		return nil
+	}
+
+	var o HAConfig
+
+	o.Enabled = c.Enabled
+
+	o.LockKey = c.LockKey
+
+	o.WarmStandby = c.WarmStandby
+
+	return &o
+}
+
+func (c *HAConfig) Merge(o *HAConfig) *HAConfig {
+	if c == nil {
+		if o == nil {
+			return nil
+		}
+		return o.Copy()
+	}
+
+	if o == nil {
+		return c.Copy()
+	}
+
+	r := c.Copy()
+
+	if o.Enabled != nil {
+		r.Enabled = o.Enabled
+	}
+
+	if o.LockKey != nil {
+		r.LockKey = o.LockKey
+	}
+
+	if o.WarmStandby != nil {
+		r.WarmStandby = o.WarmStandby
+	}
+
+	return r
+}
+
+func (c *HAConfig) Finalize() {
+	if c.Enabled == nil {
+		c.Enabled = config.Bool(false)
+	}
+
+	if c.LockKey == nil {
+		c.LockKey = config.String("")
+	}
+
+	if c.WarmStandby == nil {
+		c.WarmStandby = config.Bool(true)
+	}
+}
+
+func (c *HAConfig) GoString() string {
+	if c == nil {
+		return "(*HAConfig)(nil)"
+	}
+
+	return fmt.Sprintf("&HAConfig{"+
+		"Enabled:%s, "+
+		"LockKey:%s, "+
+		"WarmStandby:%s"+
+		"}",
+		config.BoolGoString(c.Enabled),
+		config.StringGoString(c.LockKey),
+		config.BoolGoString(c.WarmStandby),
+	)
+}
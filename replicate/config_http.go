@@ -0,0 +1,213 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: MPL-2.0
+
+package replicate
+
+import (
+	"fmt"
+
+	"github.com/hashicorp/consul-template/config"
+)
+
+// DefaultMaxIdleConnsPerHost is the default number of idle connections kept
+// to each Consul agent or server. Every prefix writes through the same client,
+// so the default of Consul Template, one more than the number of CPUs, closes
+// most connections after each write of a busy initial sync.
+const DefaultMaxIdleConnsPerHost = config.DefaultMaxIdleConns
+
+// HTTPConfig is the configuration of the HTTP transports of the source and
+// destination clients, beyond the transport blocks of consul and
+// destination_consul.
+type HTTPConfig struct {
+	// Destination is the configuration of the transport of the destination
+	// client.
+	Destination *HTTPClientConfig `mapstructure:"destination"`
+
+	// Source is the configuration of the transport of the source client.
+	Source *HTTPClientConfig `mapstructure:"source"`
+}
+
+func DefaultHTTPConfig() *HTTPConfig {
+	return &HTTPConfig{
+		Destination: DefaultHTTPClientConfig(),
+		Source:      DefaultHTTPClientConfig(),
+	}
+}
+
+func (c *HTTPConfig) Copy() *HTTPConfig {
+	if c == nil {
+		return nil
+	}
+
+	var o HTTPConfig
+
+	if c.Destination != nil {
+		o.Destination = c.Destination.Copy()
+	}
+
+	if c.Source != nil {
+		o.Source = c.Source.Copy()
+	}
+
+	return &o
+}
+
+func (c *HTTPConfig) Merge(o *HTTPConfig) *HTTPConfig {
+	if c == nil {
+		if o == nil {
+			return nil
+		}
+		return o.Copy()
+	}
+
+	if o == nil {
+		return c.Copy()
+	}
+
+	r := c.Copy()
+
+	if o.Destination != nil {
+		r.Destination = r.Destination.Merge(o.Destination)
+	}
+
+	if o.Source != nil {
+		r.Source = r.Source.Merge(o.Source)
+	}
+
+	return r
+}
+
+func (c *HTTPConfig) Finalize() {
+	if c.Destination == nil {
+		c.Destination = DefaultHTTPClientConfig()
+	}
+	c.Destination.Finalize()
+
+	if c.Source == nil {
+		c.Source = DefaultHTTPClientConfig()
+	}
+	c.Source.Finalize()
+}
+
+func (c *HTTPConfig) GoString() string {
+	if c == nil {
+		return "(*HTTPConfig)(nil)"
+	}
+
+	return fmt.Sprintf("&HTTPConfig{"+
+		"Destination:%s, "+
+		"Source:%s"+
+		"}",
+		c.Destination.GoString(),
+		c.Source.GoString(),
+	)
+}
+
+// HTTPClientConfig is the configuration of the transport of a client.
+type HTTPClientConfig struct {
+	// HTTP2 negotiates HTTP/2 with agents and servers served over TLS, so the
+	// requests of every prefix share a single connection.
+	HTTP2 *bool `mapstructure:"http2"`
+
+	// MaxConnsPerHost is the maximum number of connections to each agent or
+	// server, above which requests wait for a connection. Zero means no limit.
+	MaxConnsPerHost *int `mapstructure:"max_conns_per_host"`
+
+	// Proxy is the URL of the proxy requests go through, either an HTTP proxy,
+	// such as "http://bastion:3128", which tunnels TLS with CONNECT, or a SOCKS5
+	// proxy, such as "socks5://bastion:1080". Credentials may be given in the
+	// URL. When empty, the proxy of the HTTP_PROXY, HTTPS_PROXY and NO_PROXY
+	// environment variables is used.
+	Proxy *string `mapstructure:"proxy"`
+}
+
+func DefaultHTTPClientConfig() *HTTPClientConfig {
+	return &HTTPClientConfig{}
+}
+
+func (c *HTTPClientConfig) Copy() *HTTPClientConfig {
+	if c == nil {
+		return nil
+	}
+
+	var o HTTPClientConfig
+
+	o.HTTP2 = c.HTTP2
+
+	o.MaxConnsPerHost = c.MaxConnsPerHost
+
+	o.Proxy = c.Proxy
+
+	return &o
+}
+
+func (c *HTTPClientConfig) Merge(o *HTTPClientConfig) *HTTPClientConfig {
+	if c == nil {
+		if o == nil {
+			return nil
+		}
+		return o.Copy()
+	}
+
+	if o == nil {
+		return c.Copy()
+	}
+
+	r := c.Copy()
+
+	if o.HTTP2 != nil {
+		r.HTTP2 = o.HTTP2
+	}
+
+	if o.MaxConnsPerHost != nil {
+		r.MaxConnsPerHost = o.MaxConnsPerHost
+	}
+
+	if o.Proxy != nil {
+		r.Proxy = o.Proxy
+	}
+
+	return r
+}
+
+func (c *HTTPClientConfig) Finalize() {
+	if c.HTTP2 == nil {
+		c.HTTP2 = config.Bool(false)
+	}
+
+	if c.MaxConnsPerHost == nil {
+		c.MaxConnsPerHost = config.Int(0)
+	}
+
+	if c.Proxy == nil {
+		c.Proxy = config.String("")
+	}
+}
+
+func (c *HTTPClientConfig) GoString() string {
+	if c == nil {
+		return "(*HTTPClientConfig)(nil)"
+	}
+
+	return fmt.Sprintf("&HTTPClientConfig{"+
+		"HTTP2:%s, "+
+		"MaxConnsPerHost:%s, "+
+		"Proxy:%s"+
+		"}",
+		config.BoolGoString(c.HTTP2),
+		config.IntGoString(c.MaxConnsPerHost),
+		config.StringGoString(c.Proxy),
+	)
+}
+
+// finalizeTransport sets the defaults of the transport of the Consul
+// configuration which differ from those of Consul Template.
+func finalizeTransport(c *config.ConsulConfig) {
+	if c.Transport == nil {
+		c.Transport = config.DefaultTransportConfig()
+	}
+
+	if c.Transport.MaxIdleConnsPerHost == nil {
+		c.Transport.MaxIdleConnsPerHost = config.Int(DefaultMaxIdleConnsPerHost)
+	}
+}
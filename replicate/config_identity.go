@@ -0,0 +1,106 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: MPL-2.0
+
+package replicate
+
+import (
+	"fmt"
+
+	"github.com/hashicorp/consul-template/config"
+)
+
+// IdentityConfig is the identity the replicator presents to the clusters, so
+// its traffic and writes can be attributed to it.
+type IdentityConfig struct {
+	// Name is the name of the instance. It defaults to the hostname.
+	Name *string `mapstructure:"name"`
+
+	// StampFlags stamps a marker of the name on the flags of every key written
+	// to the destination, and records the name in its metadata key.
+	StampFlags *bool `mapstructure:"stamp_flags"`
+
+	// UserAgent is the User-Agent header of every request to the clusters. It
+	// defaults to "consul-replicate/<version> (<name>)".
+	UserAgent *string `mapstructure:"user_agent"`
+}
+
+func DefaultIdentityConfig() *IdentityConfig {
+	return &IdentityConfig{}
+}
+
+func (c *IdentityConfig) Copy() *IdentityConfig {
+	if c == nil {
+		return nil
+	}
+
+	var o IdentityConfig
+
+	o.Name = c.Name
+
+	o.StampFlags = c.StampFlags
+
+	o.UserAgent = c.UserAgent
+
+	return &o
+}
+
+func (c *IdentityConfig) Merge(o *IdentityConfig) *IdentityConfig {
+	if c == nil {
+		if o == nil {
+			return nil
+		}
+		return o.Copy()
+	}
+
+	if o == nil {
+		return c.Copy()
+	}
+
+	r := c.Copy()
+
+	if o.Name != nil {
+		r.Name = o.Name
+	}
+
+	if o.StampFlags != nil {
+		r.StampFlags = o.StampFlags
+	}
+
+	if o.UserAgent != nil {
+		r.UserAgent = o.UserAgent
+	}
+
+	return r
+}
+
+// Finalize leaves the name and user agent empty when they are not set, so the
+// configuration is the same on every host. They are resolved by the runner.
+func (c *IdentityConfig) Finalize() {
+	if c.Name == nil {
+		c.Name = config.String("")
+	}
+
+	if c.StampFlags == nil {
+		c.StampFlags = config.Bool(false)
+	}
+
+	if c.UserAgent == nil {
+		c.UserAgent = config.String("")
+	}
+}
+
+func (c *IdentityConfig) GoString() string {
+	if c == nil {
+		return "(*IdentityConfig)(nil)"
+	}
+
+	return fmt.Sprintf("&IdentityConfig{"+
+		"Name:%s, "+
+		"StampFlags:%s, "+
+		"UserAgent:%s"+
+		"}",
+		config.StringGoString(c.Name),
+		config.BoolGoString(c.StampFlags),
+		config.StringGoString(c.UserAgent),
+	)
+}
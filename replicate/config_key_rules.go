@@ -0,0 +1,144 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: MPL-2.0
+
+package replicate
+
+import (
+	"fmt"
+
+	"github.com/hashicorp/consul-template/config"
+)
+
+// KeyRulesConfig is the normalization and validation applied to the keys of a
+// prefix before they are written, since destination consumers may not accept
+// every key name the source permits.
+type KeyRulesConfig struct {
+	// CollapseSlashes replaces runs of slashes with a single slash.
+	CollapseSlashes *bool `mapstructure:"collapse_slashes"`
+
+	// Lowercase converts keys to lower case.
+	Lowercase *bool `mapstructure:"lowercase"`
+
+	// NFC converts keys to the Unicode normalization form C.
+	NFC *bool `mapstructure:"nfc"`
+
+	// RejectControl skips keys which contain control characters or are not
+	// valid UTF-8.
+	RejectControl *bool `mapstructure:"reject_control"`
+
+	// RejectPrefixes skips keys which start with any of these prefixes, relative
+	// to the destination of the prefix.
+	RejectPrefixes []string `mapstructure:"reject_prefixes"`
+}
+
+func DefaultKeyRulesConfig() *KeyRulesConfig {
+	return &KeyRulesConfig{}
+}
+
+func (c *KeyRulesConfig) Copy() *KeyRulesConfig {
+	if c == nil {
+		return nil
+	}
+
+	var o KeyRulesConfig
+
+	o.CollapseSlashes = c.CollapseSlashes
+
+	o.Lowercase = c.Lowercase
+
+	o.NFC = c.NFC
+
+	o.RejectControl = c.RejectControl
+
+	if c.RejectPrefixes != nil {
+		o.RejectPrefixes = append([]string{}, c.RejectPrefixes...)
+	}
+
+	return &o
+}
+
+func (c *KeyRulesConfig) Merge(o *KeyRulesConfig) *KeyRulesConfig {
+	if c == nil {
+		if o == nil {
+			return nil
+		}
+		return o.Copy()
+	}
+
+	if o == nil {
+		return c.Copy()
+	}
+
+	r := c.Copy()
+
+	if o.CollapseSlashes != nil {
+		r.CollapseSlashes = o.CollapseSlashes
+	}
+
+	if o.Lowercase != nil {
+		r.Lowercase = o.Lowercase
+	}
+
+	if o.NFC != nil {
+		r.NFC = o.NFC
+	}
+
+	if o.RejectControl != nil {
+		r.RejectControl = o.RejectControl
+	}
+
+	if o.RejectPrefixes != nil {
+		r.RejectPrefixes = append([]string{}, o.RejectPrefixes...)
+	}
+
+	return r
+}
+
+func (c *KeyRulesConfig) Finalize() {
+	if c.CollapseSlashes == nil {
+		c.CollapseSlashes = config.Bool(false)
+	}
+
+	if c.Lowercase == nil {
+		c.Lowercase = config.Bool(false)
+	}
+
+	if c.NFC == nil {
+		c.NFC = config.Bool(false)
+	}
+
+	if c.RejectControl == nil {
+		c.RejectControl = config.Bool(false)
+	}
+
+	if c.RejectPrefixes == nil {
+		c.RejectPrefixes = []string{}
+	}
+}
+
+// Enabled returns true if any rule applies.
+func (c *KeyRulesConfig) Enabled() bool {
+	return config.BoolVal(c.CollapseSlashes) || config.BoolVal(c.Lowercase) ||
+		config.BoolVal(c.NFC) || config.BoolVal(c.RejectControl) ||
+		len(c.RejectPrefixes) > 0
+}
+
+func (c *KeyRulesConfig) GoString() string {
+	if c == nil {
+		return "(*KeyRulesConfig)(nil)"
+	}
+
+	return fmt.Sprintf("&KeyRulesConfig{"+
+		"CollapseSlashes:%s, "+
+		"Lowercase:%s, "+
+		"NFC:%s, "+
+		"RejectControl:%s, "+
+		"RejectPrefixes:%q"+
+		"}",
+		config.BoolGoString(c.CollapseSlashes),
+		config.BoolGoString(c.Lowercase),
+		config.BoolGoString(c.NFC),
+		config.BoolGoString(c.RejectControl),
+		c.RejectPrefixes,
+	)
+}
@@ -0,0 +1,181 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: MPL-2.0
+
+package replicate
+
+import (
+	"fmt"
+	"net"
+	"os"
+
+	"github.com/hashicorp/consul-template/config"
+)
+
+const (
+	// KubernetesKindConfigMap and KubernetesKindSecret are the kinds of object
+	// replicated data can be materialized into.
+	KubernetesKindConfigMap = "configmap"
+	KubernetesKindSecret    = "secret"
+
+	// KubernetesModeSubtree writes one object per "folder" of keys and
+	// KubernetesModeKey writes one object per key.
+	KubernetesModeSubtree = "subtree"
+	KubernetesModeKey     = "key"
+
+	// DefaultKubernetesTokenFile and DefaultKubernetesCACert are the paths where
+	// the service account credentials are mounted inside a pod.
+	DefaultKubernetesTokenFile = "/var/run/secrets/kubernetes.io/serviceaccount/token"
+	DefaultKubernetesCACert    = "/var/run/secrets/kubernetes.io/serviceaccount/ca.crt"
+
+	// DefaultKubernetesNamespaceFile is the path where the namespace of the pod
+	// is mounted, used when no namespace is configured.
+	DefaultKubernetesNamespaceFile = "/var/run/secrets/kubernetes.io/serviceaccount/namespace"
+)
+
+// KubernetesConfig is the configuration for materializing replicated prefixes
+// into Kubernetes ConfigMaps or Secrets.
+type KubernetesConfig struct {
+	// Address is the URL of the Kubernetes API server. When running inside a
+	// pod, this defaults to the in-cluster service address.
+	Address *string `mapstructure:"address"`
+
+	// CaCert is the path to the CA certificate used to verify the API server.
+	CaCert *string `mapstructure:"ca_cert"`
+
+	// Kind is the type of object to write, either "configmap" or "secret".
+	Kind *string `mapstructure:"kind"`
+
+	// Mode controls how keys are grouped into objects, either "subtree" (one
+	// object per folder) or "key" (one object per key).
+	Mode *string `mapstructure:"mode"`
+
+	// Namespace is the namespace objects are written into.
+	Namespace *string `mapstructure:"namespace"`
+
+	// TokenFile is the path to the bearer token used to authenticate. The file
+	// is re-read on every request so rotated tokens are picked up.
+	TokenFile *string `mapstructure:"token_file"`
+}
+
+func DefaultKubernetesConfig() *KubernetesConfig {
+	return &KubernetesConfig{}
+}
+
+func (c *KubernetesConfig) Copy() *KubernetesConfig {
+	if c == nil {
+		return nil
+	}
+
+	var o KubernetesConfig
+
+	o.Address = c.Address
+
+	o.CaCert = c.CaCert
+
+	o.Kind = c.Kind
+
+	o.Mode = c.Mode
+
+	o.Namespace = c.Namespace
+
+	o.TokenFile = c.TokenFile
+
+	return &o
+}
+
+func (c *KubernetesConfig) Merge(o *KubernetesConfig) *KubernetesConfig {
+	if c == nil {
+		if o == nil {
+			return nil
+		}
+		return o.Copy()
+	}
+
+	if o == nil {
+		return c.Copy()
+	}
+
+	r := c.Copy()
+
+	if o.Address != nil {
+		r.Address = o.Address
+	}
+
+	if o.CaCert != nil {
+		r.CaCert = o.CaCert
+	}
+
+	if o.Kind != nil {
+		r.Kind = o.Kind
+	}
+
+	if o.Mode != nil {
+		r.Mode = o.Mode
+	}
+
+	if o.Namespace != nil {
+		r.Namespace = o.Namespace
+	}
+
+	if o.TokenFile != nil {
+		r.TokenFile = o.TokenFile
+	}
+
+	return r
+}
+
+func (c *KubernetesConfig) Finalize() {
+	if c.Address == nil {
+		addr := ""
+		if host, port := os.Getenv("KUBERNETES_SERVICE_HOST"), os.Getenv("KUBERNETES_SERVICE_PORT"); host != "" && port != "" {
+			addr = "https://" + net.JoinHostPort(host, port)
+		}
+		c.Address = config.String(addr)
+	}
+
+	if c.CaCert == nil {
+		c.CaCert = config.String(DefaultKubernetesCACert)
+	}
+
+	if c.Kind == nil {
+		c.Kind = config.String(KubernetesKindConfigMap)
+	}
+
+	if c.Mode == nil {
+		c.Mode = config.String(KubernetesModeSubtree)
+	}
+
+	if c.Namespace == nil {
+		ns := "default"
+		if b, err := os.ReadFile(DefaultKubernetesNamespaceFile); err == nil {
+			ns = string(b)
+		}
+		c.Namespace = config.String(ns)
+	}
+
+	if c.TokenFile == nil {
+		c.TokenFile = config.String(DefaultKubernetesTokenFile)
+	}
+}
+
+func (c *KubernetesConfig) GoString() string {
+	if c == nil {
+		return "(*KubernetesConfig)(nil)"
+	}
+
+	return fmt.Sprintf("&KubernetesConfig{"+
+		"Address:%s, "+
+		"CaCert:%s, "+
+		"Kind:%s, "+
+		"Mode:%s, "+
+		"Namespace:%s, "+
+		"TokenFile:%s"+
+		"}",
+		config.StringGoString(c.Address),
+		config.StringGoString(c.CaCert),
+		config.StringGoString(c.Kind),
+		config.StringGoString(c.Mode),
+		config.StringGoString(c.Namespace),
+		config.StringGoString(c.TokenFile),
+	)
+}
@@ -0,0 +1,271 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: MPL-2.0
+
+package replicate
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// importRe matches an import directive on a line of its own. HCL has no such
+// syntax, so directives are removed before the rest is decoded, leaving the
+// line empty so line numbers in errors still match.
+var importRe = regexp.MustCompile(`(?m)^[ \t]*import[ \t]+"([^"]*)"[ \t]*$`)
+
+// envRe matches an environment interpolation, such as ${env("TOKEN")} or
+// ${env("DC", "dc1")} with a default value. A leading "$" escapes it.
+var envRe = regexp.MustCompile(`(\$?)\$\{env\("([^"]*)"(?:[ \t]*,[ \t]*"([^"]*)")?\)\}`)
+
+// envEscaper escapes the values of environment variables, so they may be
+// interpolated into quoted HCL and JSON strings without ending them.
+var envEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`, "\r", `\r`, "\t", `\t`)
+
+// configExtensions are the extensions of the files merged from a
+// configuration directory.
+var configExtensions = map[string]struct{}{
+	".hcl":  {},
+	".json": {},
+}
+
+// ConfigLoader loads configuration files and directories, and the files they
+// import, merging every file at most once.
+type ConfigLoader struct {
+	// Files are the absolute paths of the files loaded so far, in the order
+	// they were merged.
+	Files []string
+
+	// loaded are the absolute paths of the files loaded so far.
+	loaded map[string]struct{}
+
+	// stack are the absolute paths of the files being loaded, to detect cycles.
+	stack []string
+}
+
+func NewConfigLoader() *ConfigLoader {
+	return &ConfigLoader{
+		loaded: make(map[string]struct{}),
+	}
+}
+
+// File loads the configuration file at the given path and its imports. A file
+// which was already loaded returns a nil config.
+func (l *ConfigLoader) File(path string) (*Config, error) {
+	abs, err := filepath.Abs(path)
+	if err != nil {
+		return nil, errors.Wrap(err, "from file: "+path)
+	}
+
+	for _, p := range l.stack {
+		if p == abs {
+			return nil, fmt.Errorf("from file: %s: import cycle: %s",
+				path, strings.Join(append(l.stack, abs), " -> "))
+		}
+	}
+	if _, ok := l.loaded[abs]; ok {
+		return nil, nil
+	}
+	l.loaded[abs] = struct{}{}
+
+	l.stack = append(l.stack, abs)
+	defer func() { l.stack = l.stack[:len(l.stack)-1] }()
+
+	contents, err := os.ReadFile(path)
+	if err != nil {
+		return nil, errors.Wrap(err, "from file: "+path)
+	}
+
+	c, err := l.parse(string(contents), filepath.Dir(path))
+	if err != nil {
+		return nil, errors.Wrap(err, "from file: "+path)
+	}
+	l.Files = append(l.Files, abs)
+	return c, nil
+}
+
+// Dir merges the .hcl and .json files in the directory and its
+// subdirectories. See Path for the order they are merged in.
+func (l *ConfigLoader) Dir(path string) (*Config, error) {
+	stat, err := os.Stat(path)
+	if err != nil {
+		return nil, errors.Wrap(err, "missing folder: "+path)
+	}
+	if !stat.IsDir() {
+		return nil, fmt.Errorf("not a folder: %q", path)
+	}
+	return l.dir(path, true)
+}
+
+// Path loads the configuration file at the given path, or merges every file
+// in the given directory and its subdirectories. Symlinks are followed, and
+// files are merged depth-first in lexical order of their names within each
+// directory, so later files take precedence. A file which was already loaded,
+// for example because an earlier file imported it, is not merged again.
+func (l *ConfigLoader) Path(path string) (*Config, error) {
+	stat, err := os.Stat(path)
+	if os.IsNotExist(err) {
+		return nil, errors.Wrap(err, "missing file/folder: "+path)
+	}
+	if err != nil {
+		return nil, errors.Wrap(err, "failed stating file: "+path)
+	}
+
+	switch {
+	case stat.IsDir():
+		return l.dir(path, false)
+	case stat.Mode().IsRegular():
+		return l.File(path)
+	}
+	return nil, fmt.Errorf("unknown filetype: %q", stat.Mode().String())
+}
+
+// dir merges the files in the directory, optionally only those with a
+// configuration extension.
+func (l *ConfigLoader) dir(path string, filter bool) (*Config, error) {
+	files, err := walkConfigDir(path, make(map[string]struct{}))
+	if err != nil {
+		return nil, errors.Wrap(err, "walk error")
+	}
+
+	var c *Config
+	for _, file := range files {
+		if _, ok := configExtensions[filepath.Ext(file)]; filter && !ok {
+			continue
+		}
+
+		newConfig, err := l.File(file)
+		if err != nil {
+			return nil, err
+		}
+		c = c.Merge(newConfig)
+	}
+	return c, nil
+}
+
+// walkConfigDir returns the regular files in the directory and its
+// subdirectories, following symlinks, depth-first in lexical order of their
+// names. Directories already visited through another link are skipped.
+func walkConfigDir(dir string, visited map[string]struct{}) ([]string, error) {
+	real, err := filepath.EvalSymlinks(dir)
+	if err != nil {
+		return nil, err
+	}
+	if _, ok := visited[real]; ok {
+		return nil, nil
+	}
+	visited[real] = struct{}{}
+
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	var files []string
+	for _, entry := range entries {
+		path := filepath.Join(dir, entry.Name())
+		info, err := os.Stat(path)
+		if err != nil {
+			return nil, err
+		}
+
+		switch {
+		case info.IsDir():
+			sub, err := walkConfigDir(path, visited)
+			if err != nil {
+				return nil, err
+			}
+			files = append(files, sub...)
+		case info.Mode().IsRegular():
+			files = append(files, path)
+		}
+	}
+	return files, nil
+}
+
+// parse parses the configuration, resolving imports relative to dir. Imports
+// are merged first, in the order of the directives and then the lexical order
+// of the files matching each pattern, and the configuration itself last, so
+// it takes precedence over what it imports.
+func (l *ConfigLoader) parse(s, dir string) (*Config, error) {
+	s, err := interpolateEnv(s)
+	if err != nil {
+		return nil, err
+	}
+
+	var patterns []string
+	s = importRe.ReplaceAllStringFunc(s, func(m string) string {
+		patterns = append(patterns, importRe.FindStringSubmatch(m)[1])
+		return ""
+	})
+
+	var c *Config
+	for _, pattern := range patterns {
+		if !filepath.IsAbs(pattern) {
+			pattern = filepath.Join(dir, pattern)
+		}
+
+		matches, err := filepath.Glob(pattern)
+		if err != nil {
+			return nil, errors.Wrap(err, "import "+pattern)
+		}
+
+		// A pattern may match nothing, but a plain path must exist
+		if len(matches) == 0 && !strings.ContainsAny(pattern, "*?[") {
+			return nil, fmt.Errorf("import %s: no such file", pattern)
+		}
+		sort.Strings(matches)
+
+		for _, match := range matches {
+			imported, err := l.File(match)
+			if err != nil {
+				return nil, err
+			}
+			c = c.Merge(imported)
+		}
+	}
+
+	own, err := parseHCL(s)
+	if err != nil {
+		return nil, err
+	}
+	return c.Merge(own), nil
+}
+
+// interpolateEnv replaces the environment interpolations of the configuration
+// with the values of the variables, before it is decoded, so a configuration
+// can be deployed across environments which only differ in their environment.
+// A variable which is not set is an error, unless a default value is given.
+// "$${env(...)}" is left as "${env(...)}".
+func interpolateEnv(s string) (string, error) {
+	var missing []string
+	s = envRe.ReplaceAllStringFunc(s, func(m string) string {
+		// The default value is matched by the third group, which may be empty
+		idx := envRe.FindStringSubmatchIndex(m)
+		if idx[3] > idx[2] {
+			return m[1:]
+		}
+
+		name := m[idx[4]:idx[5]]
+		v, ok := os.LookupEnv(name)
+		if !ok {
+			if idx[6] < 0 {
+				missing = append(missing, name)
+				return m
+			}
+			v = m[idx[6]:idx[7]]
+		}
+		return envEscaper.Replace(v)
+	})
+
+	if len(missing) > 0 {
+		return "", fmt.Errorf("env: environment variable(s) not set: %s",
+			strings.Join(missing, ", "))
+	}
+	return s, nil
+}
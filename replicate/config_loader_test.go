@@ -0,0 +1,299 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: MPL-2.0
+
+package replicate
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/hashicorp/consul-template/config"
+)
+
+func TestImport(t *testing.T) {
+	dir, err := os.MkdirTemp("", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	files := map[string]string{
+		"common.hcl": `max_stale = "1s"`,
+		"main.hcl": `
+			import "teams/*.hcl"
+			log_level = "info"
+		`,
+		"teams/a.hcl": `
+			prefix { source = "a@dc1" }
+			log_level = "debug"
+		`,
+		"teams/b.hcl": `
+			import "../common.hcl"
+			prefix { source = "b@dc1" }
+		`,
+		"cycle/x.hcl":   `import "y.hcl"`,
+		"cycle/y.hcl":   `import "x.hcl"`,
+		"missing.hcl":   `import "nope.hcl"`,
+		"unmatched.hcl": `import "nope/*.hcl"`,
+	}
+	for name, contents := range files {
+		path := filepath.Join(dir, name)
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(contents), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	cases := []struct {
+		name     string
+		path     string
+		prefixes []string
+		logLevel string
+		err      bool
+	}{
+		{
+			"file",
+			"main.hcl",
+			[]string{"a", "b"},
+			"info",
+			false,
+		},
+		{
+			"nested",
+			"teams/b.hcl",
+			[]string{"b"},
+			"",
+			false,
+		},
+		{
+			"cycle",
+			"cycle/x.hcl",
+			nil,
+			"",
+			true,
+		},
+		{
+			"missing",
+			"missing.hcl",
+			nil,
+			"",
+			true,
+		},
+		{
+			"unmatched",
+			"unmatched.hcl",
+			nil,
+			"",
+			false,
+		},
+	}
+
+	for i, tc := range cases {
+		t.Run(fmt.Sprintf("%d_%s", i, tc.name), func(t *testing.T) {
+			c, err := FromFile(filepath.Join(dir, tc.path))
+			if (err != nil) != tc.err {
+				t.Fatal(err)
+			}
+			if err != nil {
+				return
+			}
+
+			var prefixes []string
+			if c.Prefixes != nil {
+				for _, p := range *c.Prefixes {
+					prefixes = append(prefixes, config.StringVal(p.Source))
+				}
+			}
+			if !reflect.DeepEqual(tc.prefixes, prefixes) {
+				t.Errorf("\nexp: %#v\nact: %#v", tc.prefixes, prefixes)
+			}
+			if act := config.StringVal(c.LogLevel); act != tc.logLevel {
+				t.Errorf("\nexp: %#v\nact: %#v", tc.logLevel, act)
+			}
+		})
+	}
+}
+
+func TestFromPath_imports(t *testing.T) {
+	dir, err := os.MkdirTemp("", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	files := map[string]string{
+		"main.hcl":    `import "teams/*.hcl"`,
+		"teams/a.hcl": `prefix { source = "a@dc1" }`,
+		"teams/b.hcl": `prefix { source = "b@dc1" }`,
+	}
+	for name, contents := range files {
+		path := filepath.Join(dir, name)
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(contents), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	c, err := FromPath(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Imported files are not merged again by the walk
+	var prefixes []string
+	for _, p := range *c.Prefixes {
+		prefixes = append(prefixes, config.StringVal(p.Source))
+	}
+	exp := []string{"a", "b"}
+	if !reflect.DeepEqual(exp, prefixes) {
+		t.Errorf("\nexp: %#v\nact: %#v", exp, prefixes)
+	}
+}
+
+func TestConfigLoader_Dir(t *testing.T) {
+	dir, err := os.MkdirTemp("", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	files := map[string]string{
+		"conf/b.json":  `{"prefix": [{"source": "b@dc1"}]}`,
+		"conf/a.hcl":   `prefix { source = "a@dc1" }`,
+		"conf/c.txt":   `not a configuration file`,
+		"linked/d.hcl": `prefix { source = "d@dc1" }`,
+	}
+	for name, contents := range files {
+		path := filepath.Join(dir, name)
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(contents), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := os.Symlink(filepath.Join(dir, "linked"), filepath.Join(dir, "conf", "c")); err != nil {
+		t.Fatal(err)
+	}
+
+	l := NewConfigLoader()
+	c, err := l.Dir(filepath.Join(dir, "conf"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var prefixes []string
+	for _, p := range *c.Prefixes {
+		prefixes = append(prefixes, config.StringVal(p.Source))
+	}
+	exp := []string{"a", "b", "d"}
+	if !reflect.DeepEqual(exp, prefixes) {
+		t.Errorf("\nexp: %#v\nact: %#v", exp, prefixes)
+	}
+
+	var merged []string
+	for _, file := range l.Files {
+		merged = append(merged, filepath.Base(file))
+	}
+	exp = []string{"a.hcl", "b.json", "d.hcl"}
+	if !reflect.DeepEqual(exp, merged) {
+		t.Errorf("\nexp: %#v\nact: %#v", exp, merged)
+	}
+
+	if _, err := l.Dir(filepath.Join(dir, "conf", "a.hcl")); err == nil {
+		t.Error("expected an error for a file")
+	}
+}
+
+func TestInterpolateEnv(t *testing.T) {
+	t.Setenv("CR_TEST_TOKEN", "abcd")
+	t.Setenv("CR_TEST_EMPTY", "")
+	t.Setenv("CR_TEST_QUOTED", `a"b\c`)
+
+	cases := []struct {
+		name string
+		s    string
+		exp  string
+		err  bool
+	}{
+		{
+			"none",
+			`token = "abcd"`,
+			`token = "abcd"`,
+			false,
+		},
+		{
+			"set",
+			`token = "${env("CR_TEST_TOKEN")}"`,
+			`token = "abcd"`,
+			false,
+		},
+		{
+			"several",
+			`prefix = "${env("CR_TEST_TOKEN")}@${env("CR_TEST_DC", "dc1")}"`,
+			`prefix = "abcd@dc1"`,
+			false,
+		},
+		{
+			"default_unused",
+			`token = "${env("CR_TEST_TOKEN", "nope")}"`,
+			`token = "abcd"`,
+			false,
+		},
+		{
+			"empty",
+			`token = "${env("CR_TEST_EMPTY", "nope")}"`,
+			`token = ""`,
+			false,
+		},
+		{
+			"empty_default",
+			`token = "${env("CR_TEST_MISSING", "")}"`,
+			`token = ""`,
+			false,
+		},
+		{
+			"escaped_value",
+			`token = "${env("CR_TEST_QUOTED")}"`,
+			`token = "a\"b\\c"`,
+			false,
+		},
+		{
+			"escaped",
+			`command = "echo $${env("CR_TEST_TOKEN")}"`,
+			`command = "echo ${env("CR_TEST_TOKEN")}"`,
+			false,
+		},
+		{
+			"other_interpolation",
+			`command = "echo ${HOME}"`,
+			`command = "echo ${HOME}"`,
+			false,
+		},
+		{
+			"missing",
+			`token = "${env("CR_TEST_MISSING")}"`,
+			"",
+			true,
+		},
+	}
+
+	for i, tc := range cases {
+		t.Run(fmt.Sprintf("%d_%s", i, tc.name), func(t *testing.T) {
+			act, err := interpolateEnv(tc.s)
+			if (err != nil) != tc.err {
+				t.Fatal(err)
+			}
+			if act != tc.exp {
+				t.Errorf("\nexp: %#v\nact: %#v", tc.exp, act)
+			}
+		})
+	}
+}
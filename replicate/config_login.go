@@ -0,0 +1,256 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: MPL-2.0
+
+package replicate
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/hashicorp/consul-template/config"
+)
+
+const (
+	// DefaultBearerTokenFile is the default path of the bearer token presented
+	// to the auth method, which is where Kubernetes mounts the token of the
+	// service account of a pod.
+	DefaultBearerTokenFile = "/var/run/secrets/kubernetes.io/serviceaccount/token"
+
+	// DefaultLoginInterval is the default interval between logins.
+	DefaultLoginInterval = 1 * time.Hour
+)
+
+// LoginConfig is the configuration of the auth methods the source and
+// destination clients log in to, so their ACL tokens are obtained and renewed
+// at runtime instead of being configured.
+type LoginConfig struct {
+	// Destination is the configuration of the login of the destination client.
+	Destination *LoginClientConfig `mapstructure:"destination"`
+
+	// Source is the configuration of the login of the source client.
+	Source *LoginClientConfig `mapstructure:"source"`
+}
+
+func DefaultLoginConfig() *LoginConfig {
+	return &LoginConfig{
+		Destination: DefaultLoginClientConfig(),
+		Source:      DefaultLoginClientConfig(),
+	}
+}
+
+func (c *LoginConfig) Copy() *LoginConfig {
+	if c == nil {
+		return nil
+	}
+
+	var o LoginConfig
+
+	if c.Destination != nil {
+		o.Destination = c.Destination.Copy()
+	}
+
+	if c.Source != nil {
+		o.Source = c.Source.Copy()
+	}
+
+	return &o
+}
+
+func (c *LoginConfig) Merge(o *LoginConfig) *LoginConfig {
+	if c == nil {
+		if o == nil {
+			return nil
+		}
+		return o.Copy()
+	}
+
+	if o == nil {
+		return c.Copy()
+	}
+
+	r := c.Copy()
+
+	if o.Destination != nil {
+		r.Destination = r.Destination.Merge(o.Destination)
+	}
+
+	if o.Source != nil {
+		r.Source = r.Source.Merge(o.Source)
+	}
+
+	return r
+}
+
+func (c *LoginConfig) Finalize() {
+	if c.Destination == nil {
+		c.Destination = DefaultLoginClientConfig()
+	}
+	c.Destination.Finalize()
+
+	if c.Source == nil {
+		c.Source = DefaultLoginClientConfig()
+	}
+	c.Source.Finalize()
+}
+
+func (c *LoginConfig) GoString() string {
+	if c == nil {
+		return "(*LoginConfig)(nil)"
+	}
+
+	return fmt.Sprintf("&LoginConfig{"+
+		"Destination:%s, "+
+		"Source:%s"+
+		"}",
+		c.Destination.GoString(),
+		c.Source.GoString(),
+	)
+}
+
+// LoginClientConfig is the configuration of the login of a client to an auth
+// method of its cluster.
+type LoginClientConfig struct {
+	// AuthMethod is the name of the auth method to log in to, such as a
+	// Kubernetes or JWT auth method.
+	AuthMethod *string `mapstructure:"auth_method"`
+
+	// BearerTokenFile is the path of the file holding the bearer token
+	// presented to the auth method, such as the token of a Kubernetes service
+	// account or an OIDC identity token. It is read again on every login, so
+	// it can be rotated.
+	BearerTokenFile *string `mapstructure:"bearer_token_file"`
+
+	// Enabled turns on login. It defaults to true if an auth method is given.
+	Enabled *bool `mapstructure:"enabled"`
+
+	// Interval is the maximum time between logins. Tokens which expire sooner
+	// are renewed halfway through their lifetime.
+	Interval *time.Duration `mapstructure:"interval"`
+
+	// Meta is attached to the tokens obtained by login.
+	Meta map[string]string `mapstructure:"meta"`
+}
+
+func DefaultLoginClientConfig() *LoginClientConfig {
+	return &LoginClientConfig{}
+}
+
+func (c *LoginClientConfig) Copy() *LoginClientConfig {
+	if c == nil {
+		return nil
+	}
+
+	var o LoginClientConfig
+
+	o.AuthMethod = c.AuthMethod
+
+	o.BearerTokenFile = c.BearerTokenFile
+
+	o.Enabled = c.Enabled
+
+	o.Interval = c.Interval
+
+	if c.Meta != nil {
+		o.Meta = make(map[string]string, len(c.Meta))
+		for k, v := range c.Meta {
+			o.Meta[k] = v
+		}
+	}
+
+	return &o
+}
+
+func (c *LoginClientConfig) Merge(o *LoginClientConfig) *LoginClientConfig {
+	if c == nil {
+		if o == nil {
+			return nil
+		}
+		return o.Copy()
+	}
+
+	if o == nil {
+		return c.Copy()
+	}
+
+	r := c.Copy()
+
+	if o.AuthMethod != nil {
+		r.AuthMethod = o.AuthMethod
+	}
+
+	if o.BearerTokenFile != nil {
+		r.BearerTokenFile = o.BearerTokenFile
+	}
+
+	if o.Enabled != nil {
+		r.Enabled = o.Enabled
+	}
+
+	if o.Interval != nil {
+		r.Interval = o.Interval
+	}
+
+	for k, v := range o.Meta {
+		if r.Meta == nil {
+			r.Meta = make(map[string]string)
+		}
+		r.Meta[k] = v
+	}
+
+	return r
+}
+
+func (c *LoginClientConfig) Finalize() {
+	if c.AuthMethod == nil {
+		c.AuthMethod = config.String("")
+	}
+
+	if c.BearerTokenFile == nil {
+		c.BearerTokenFile = config.String(DefaultBearerTokenFile)
+	}
+
+	if c.Enabled == nil {
+		c.Enabled = config.Bool(config.StringPresent(c.AuthMethod))
+	}
+
+	if c.Interval == nil {
+		c.Interval = config.TimeDuration(DefaultLoginInterval)
+	}
+
+	if c.Meta == nil {
+		c.Meta = make(map[string]string)
+	}
+}
+
+func (c *LoginClientConfig) GoString() string {
+	if c == nil {
+		return "(*LoginClientConfig)(nil)"
+	}
+
+	keys := make([]string, 0, len(c.Meta))
+	for k := range c.Meta {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	meta := make([]string, len(keys))
+	for i, k := range keys {
+		meta[i] = fmt.Sprintf("%s:%q", k, c.Meta[k])
+	}
+
+	return fmt.Sprintf("&LoginClientConfig{"+
+		"AuthMethod:%s, "+
+		"BearerTokenFile:%s, "+
+		"Enabled:%s, "+
+		"Interval:%s, "+
+		"Meta:{%s}"+
+		"}",
+		config.StringGoString(c.AuthMethod),
+		config.StringGoString(c.BearerTokenFile),
+		config.BoolGoString(c.Enabled),
+		config.TimeDurationGoString(c.Interval),
+		strings.Join(meta, ", "),
+	)
+}
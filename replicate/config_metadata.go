@@ -0,0 +1,93 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: MPL-2.0
+
+package replicate
+
+import (
+	"fmt"
+
+	"github.com/hashicorp/consul-template/config"
+)
+
+// DefaultMetadataDir is the default folder of the metadata keys.
+const DefaultMetadataDir = "_meta"
+
+// MetadataConfig writes a sidecar metadata key for every key replicated into
+// the destination, with the provenance of its value, so downstream tooling can
+// audit it without talking to the source.
+type MetadataConfig struct {
+	// Enabled writes metadata keys. Specifying any other option also enables
+	// it.
+	Enabled *bool `mapstructure:"enabled"`
+
+	// Dir is the folder of the metadata keys, in which the metadata key of a
+	// replicated key has the same path as the key itself.
+	Dir *string `mapstructure:"dir"`
+}
+
+func DefaultMetadataConfig() *MetadataConfig {
+	return &MetadataConfig{}
+}
+
+func (c *MetadataConfig) Copy() *MetadataConfig {
+	if c == nil {
+		return nil
+	}
+
+	var o MetadataConfig
+
+	o.Enabled = c.Enabled
+
+	o.Dir = c.Dir
+
+	return &o
+}
+
+func (c *MetadataConfig) Merge(o *MetadataConfig) *MetadataConfig {
+	if c == nil {
+		if o == nil {
+			return nil
+		}
+		return o.Copy()
+	}
+
+	if o == nil {
+		return c.Copy()
+	}
+
+	r := c.Copy()
+
+	if o.Enabled != nil {
+		r.Enabled = o.Enabled
+	}
+
+	if o.Dir != nil {
+		r.Dir = o.Dir
+	}
+
+	return r
+}
+
+func (c *MetadataConfig) Finalize() {
+	if c.Enabled == nil {
+		c.Enabled = config.Bool(c.Dir != nil)
+	}
+
+	if c.Dir == nil {
+		c.Dir = config.String(DefaultMetadataDir)
+	}
+}
+
+func (c *MetadataConfig) GoString() string {
+	if c == nil {
+		return "(*MetadataConfig)(nil)"
+	}
+
+	return fmt.Sprintf("&MetadataConfig{"+
+		"Enabled:%s, "+
+		"Dir:%s"+
+		"}",
+		config.BoolGoString(c.Enabled),
+		config.StringGoString(c.Dir),
+	)
+}
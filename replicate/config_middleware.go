@@ -0,0 +1,163 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: MPL-2.0
+
+package replicate
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/hashicorp/consul-template/config"
+)
+
+// MiddlewareConfig is a middleware applied to every key of a prefix.
+type MiddlewareConfig struct {
+	// Name is the name the middleware was registered with.
+	Name *string `mapstructure:"name"`
+
+	// Options are passed to the middleware's factory.
+	Options map[string]string `mapstructure:"options"`
+}
+
+func DefaultMiddlewareConfig() *MiddlewareConfig {
+	return &MiddlewareConfig{}
+}
+
+func (c *MiddlewareConfig) Copy() *MiddlewareConfig {
+	if c == nil {
+		return nil
+	}
+
+	var o MiddlewareConfig
+
+	o.Name = c.Name
+
+	if c.Options != nil {
+		o.Options = make(map[string]string, len(c.Options))
+		for k, v := range c.Options {
+			o.Options[k] = v
+		}
+	}
+
+	return &o
+}
+
+func (c *MiddlewareConfig) Merge(o *MiddlewareConfig) *MiddlewareConfig {
+	if c == nil {
+		if o == nil {
+			return nil
+		}
+		return o.Copy()
+	}
+
+	if o == nil {
+		return c.Copy()
+	}
+
+	r := c.Copy()
+
+	if o.Name != nil {
+		r.Name = o.Name
+	}
+
+	for k, v := range o.Options {
+		if r.Options == nil {
+			r.Options = make(map[string]string)
+		}
+		r.Options[k] = v
+	}
+
+	return r
+}
+
+func (c *MiddlewareConfig) Finalize() {
+	if c.Name == nil {
+		c.Name = config.String("")
+	}
+
+	if c.Options == nil {
+		c.Options = make(map[string]string)
+	}
+}
+
+func (c *MiddlewareConfig) GoString() string {
+	if c == nil {
+		return "(*MiddlewareConfig)(nil)"
+	}
+
+	keys := make([]string, 0, len(c.Options))
+	for k := range c.Options {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	opts := make([]string, len(keys))
+	for i, k := range keys {
+		opts[i] = fmt.Sprintf("%s:%q", k, c.Options[k])
+	}
+
+	return fmt.Sprintf("&MiddlewareConfig{"+
+		"Name:%s, "+
+		"Options:{%s}"+
+		"}",
+		config.StringGoString(c.Name),
+		strings.Join(opts, ", "),
+	)
+}
+
+type MiddlewareConfigs []*MiddlewareConfig
+
+func DefaultMiddlewareConfigs() *MiddlewareConfigs {
+	return &MiddlewareConfigs{}
+}
+
+func (c *MiddlewareConfigs) Copy() *MiddlewareConfigs {
+	if c == nil {
+		return nil
+	}
+
+	o := make(MiddlewareConfigs, len(*c))
+	for i, t := range *c {
+		o[i] = t.Copy()
+	}
+	return &o
+}
+
+func (c *MiddlewareConfigs) Merge(o *MiddlewareConfigs) *MiddlewareConfigs {
+	if c == nil {
+		if o == nil {
+			return nil
+		}
+		return o.Copy()
+	}
+
+	if o == nil {
+		return c.Copy()
+	}
+
+	r := c.Copy()
+
+	*r = append(*r, *o...)
+
+	return r
+}
+
+func (c *MiddlewareConfigs) Finalize() {
+	for _, t := range *c {
+		t.Finalize()
+	}
+}
+
+func (c *MiddlewareConfigs) GoString() string {
+	if c == nil {
+		return "(*MiddlewareConfigs)(nil)"
+	}
+
+	s := make([]string, len(*c))
+	for i, t := range *c {
+		s[i] = t.GoString()
+	}
+
+	return "{" + strings.Join(s, ", ") + "}"
+}
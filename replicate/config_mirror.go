@@ -0,0 +1,90 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: MPL-2.0
+
+package replicate
+
+import (
+	"fmt"
+
+	"github.com/hashicorp/consul-template/config"
+)
+
+// MirrorConfig keeps a local copy of the destination keys of every prefix on
+// disk, as last written by the replicator, so a restarted replicator compares
+// the source with it instead of reading the whole destination again, and only
+// touches the destination for actual changes.
+type MirrorConfig struct {
+	// Dir is the folder on disk the mirrors are kept in, one journal per
+	// prefix.
+	Dir *string `mapstructure:"dir"`
+
+	// Enabled keeps the mirrors. It defaults to true if a folder is given.
+	Enabled *bool `mapstructure:"enabled"`
+}
+
+func DefaultMirrorConfig() *MirrorConfig {
+	return &MirrorConfig{}
+}
+
+func (c *MirrorConfig) Copy() *MirrorConfig {
+	if c == nil {
+		return nil
+	}
+
+	var o MirrorConfig
+
+	o.Dir = c.Dir
+
+	o.Enabled = c.Enabled
+
+	return &o
+}
+
+func (c *MirrorConfig) Merge(o *MirrorConfig) *MirrorConfig {
+	if c == nil {
+		if o == nil {
+			return nil
+		}
+		return o.Copy()
+	}
+
+	if o == nil {
+		return c.Copy()
+	}
+
+	r := c.Copy()
+
+	if o.Dir != nil {
+		r.Dir = o.Dir
+	}
+
+	if o.Enabled != nil {
+		r.Enabled = o.Enabled
+	}
+
+	return r
+}
+
+func (c *MirrorConfig) Finalize() {
+	if c.Dir == nil {
+		c.Dir = config.String("")
+	}
+
+	if c.Enabled == nil {
+		c.Enabled = config.Bool(config.StringVal(c.Dir) != "")
+	}
+}
+
+func (c *MirrorConfig) GoString() string {
+	if c == nil {
+		return "(*MirrorConfig)(nil)"
+	}
+
+	return fmt.Sprintf("&MirrorConfig{"+
+		"Dir:%s, "+
+		"Enabled:%s"+
+		"}",
+		config.StringGoString(c.Dir),
+		config.BoolGoString(c.Enabled),
+	)
+}
@@ -0,0 +1,117 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: MPL-2.0
+
+package replicate
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/hashicorp/consul-template/config"
+)
+
+const (
+	// DefaultPipelineCheckpointInterval is the default amount of time between
+	// two checkpoints of the applied index during a pass.
+	DefaultPipelineCheckpointInterval = 10 * time.Second
+
+	// DefaultPipelineWindow is the default number of writes of a prefix which
+	// may be outstanding at once.
+	DefaultPipelineWindow = 16
+)
+
+// PipelineConfig pipelines the writes into the destination, so several writes
+// of a prefix are in flight at once, which hides the latency of WAN links.
+type PipelineConfig struct {
+	// CheckpointInterval is the amount of time between two checkpoints of the
+	// applied index during a pass, so an interrupted pass resumes from the last
+	// checkpoint instead of from the start. Zero only checkpoints at the end
+	// of the pass.
+	CheckpointInterval *time.Duration `mapstructure:"checkpoint_interval"`
+
+	// Enabled pipelines the writes. Specifying any other option also enables
+	// it.
+	Enabled *bool `mapstructure:"enabled"`
+
+	// Window is the maximum number of writes of a prefix in flight at once.
+	Window *int `mapstructure:"window"`
+}
+
+func DefaultPipelineConfig() *PipelineConfig {
+	return &PipelineConfig{}
+}
+
+func (c *PipelineConfig) Copy() *PipelineConfig {
+	if c == nil {
+		return nil
+	}
+
+	var o PipelineConfig
+
+	o.CheckpointInterval = c.CheckpointInterval
+
+	o.Enabled = c.Enabled
+
+	o.Window = c.Window
+
+	return &o
+}
+
+func (c *PipelineConfig) Merge(o *PipelineConfig) *PipelineConfig {
+	if c == nil {
+		if o == nil {
+			return nil
+		}
+		return o.Copy()
+	}
+
+	if o == nil {
+		return c.Copy()
+	}
+
+	r := c.Copy()
+
+	if o.CheckpointInterval != nil {
+		r.CheckpointInterval = o.CheckpointInterval
+	}
+
+	if o.Enabled != nil {
+		r.Enabled = o.Enabled
+	}
+
+	if o.Window != nil {
+		r.Window = o.Window
+	}
+
+	return r
+}
+
+func (c *PipelineConfig) Finalize() {
+	if c.Enabled == nil {
+		c.Enabled = config.Bool(c.CheckpointInterval != nil || c.Window != nil)
+	}
+
+	if c.CheckpointInterval == nil {
+		c.CheckpointInterval = config.TimeDuration(DefaultPipelineCheckpointInterval)
+	}
+
+	if c.Window == nil {
+		c.Window = config.Int(DefaultPipelineWindow)
+	}
+}
+
+func (c *PipelineConfig) GoString() string {
+	if c == nil {
+		return "(*PipelineConfig)(nil)"
+	}
+
+	return fmt.Sprintf("&PipelineConfig{"+
+		"CheckpointInterval:%s, "+
+		"Enabled:%s, "+
+		"Window:%s"+
+		"}",
+		config.TimeDurationGoString(c.CheckpointInterval),
+		config.BoolGoString(c.Enabled),
+		config.IntGoString(c.Window),
+	)
+}
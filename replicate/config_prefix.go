@@ -0,0 +1,777 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: MPL-2.0
+
+package replicate
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/hashicorp/consul-template/config"
+	dep "github.com/hashicorp/consul-template/dependency"
+)
+
+// destinationTemplateRe matches the placeholders of destination templates,
+// such as "{{ source_dc }}".
+var destinationTemplateRe = regexp.MustCompile(`{{\s*(\w+)\s*}}`)
+
+const (
+	// BackendConsul and BackendKubernetes are the supported destination
+	// backends for a prefix.
+	BackendConsul     = "consul"
+	BackendKubernetes = "kubernetes"
+
+	// OnSourceEmptyDelete, OnSourceEmptyKeep and OnSourceEmptyFail are the
+	// actions that can be taken when a source prefix has no keys.
+	OnSourceEmptyDelete = "delete"
+	OnSourceEmptyKeep   = "keep"
+	OnSourceEmptyFail   = "fail"
+)
+
+// PrefixConfig is the representation of a key prefix.
+type PrefixConfig struct {
+	// Backend is the destination backend the prefix is replicated into, either
+	// "consul" (default) or "kubernetes".
+	Backend *string `mapstructure:"backend"`
+
+	// Blackouts are the windows during which the prefix is not written to the
+	// destination. Changes made in the meantime are replicated once the window
+	// closes.
+	Blackouts *BlackoutConfigs `mapstructure:"blackout"`
+
+	// Canary stages the changes of the prefix, and promotes them into the
+	// destination once validated.
+	Canary *CanaryConfig `mapstructure:"canary"`
+
+	Datacenter  *string          `mapstructure:"datacenter"`
+	Dependency  *dep.KVListQuery `mapstructure:"-"`
+	Destination *string          `mapstructure:"destination"`
+
+	// DeleteOwnedOnly only deletes keys of the destination whose metadata names
+	// the replicator which wrote their value, so keys written there by hand are
+	// kept.
+	DeleteOwnedOnly *bool `mapstructure:"delete_owned_only"`
+
+	// DestinationDatacenter is the datacenter the prefix is written into,
+	// through the dc query parameter, in place of the destination datacenter.
+	// With a source datacenter, it replicates between two datacenters of a
+	// WAN-federated cluster through a single agent.
+	DestinationDatacenter *string `mapstructure:"destination_dc"`
+
+	// DestinationPartition is the Consul Enterprise admin partition the prefix
+	// is written into. It defaults to the partition of the destination token.
+	DestinationPartition *string `mapstructure:"destination_partition"`
+
+	// Enabled replicates the prefix. A disabled prefix is kept in the
+	// configuration, for documentation or templating, but is skipped at runtime.
+	Enabled *bool `mapstructure:"enabled"`
+
+	// ExcludeFlags is a mask of Consul KV flags. Source keys with any of its bits
+	// set are local-only, and are not replicated.
+	ExcludeFlags *int `mapstructure:"exclude_flags"`
+
+	// IncludeFlags is a mask of Consul KV flags. When it is not zero, only the
+	// source keys with any of its bits set are replicated.
+	IncludeFlags *int `mapstructure:"include_flags"`
+
+	// KeyRules are the normalization and validation rules applied to every key of
+	// the prefix before it is written.
+	KeyRules *KeyRulesConfig `mapstructure:"key_rules"`
+
+	// MaxDepth is the maximum depth of the keys of the source tree of the prefix,
+	// in path segments below its source. A deeper tree fails the prefix instead of
+	// being replicated. Zero disables the limit.
+	MaxDepth *int `mapstructure:"max_depth"`
+
+	// MaxKeys is the maximum number of keys of the source tree of the prefix. A
+	// larger tree fails the prefix instead of being replicated. Zero disables the
+	// limit.
+	MaxKeys *int `mapstructure:"max_keys"`
+
+	// MaxTreeBytes is the maximum size of the source tree of the prefix, the sum
+	// of the lengths of its keys and values. A larger tree fails the prefix
+	// instead of being replicated. Zero disables the limit.
+	MaxTreeBytes *int `mapstructure:"max_tree_bytes"`
+
+	// Merged overlays the prefix with the other merged prefixes of the same
+	// destination into a single tree. Where several of them have a key, the value
+	// of the prefix which comes last in the configuration wins.
+	Merged *bool `mapstructure:"merge"`
+
+	// Middlewares is the ordered list of middleware applied to every key of the
+	// prefix before it is written.
+	Middlewares *MiddlewareConfigs `mapstructure:"middleware"`
+
+	// OnSourceEmpty is the action to take when the source prefix has no keys,
+	// either "delete" (default) to delete every key at the destination, "keep" to
+	// leave the destination untouched, or "fail" to stop replication with an
+	// error.
+	OnSourceEmpty *string `mapstructure:"on_source_empty"`
+
+	// Partition is the Consul Enterprise admin partition the prefix is read
+	// from. It defaults to the partition of the source token.
+	Partition *string `mapstructure:"partition"`
+
+	// Priority orders the replication of prefixes. When several prefixes changed,
+	// those with a higher priority are replicated first, and those with a lower
+	// one only once they are done. The default is zero.
+	Priority *int `mapstructure:"priority"`
+
+	// Routes send the keys matching their pattern to other destinations. The first
+	// matching route is used, and keys matching none are written to the
+	// destination of the prefix.
+	Routes *RouteConfigs `mapstructure:"route"`
+
+	Source *string `mapstructure:"source"`
+
+	// TTL is the maximum amount of time replicated keys may outlive the link to
+	// the source. If the source cannot be reached for longer than this, the keys
+	// are removed from the destination. Zero disables expiry.
+	TTL *time.Duration `mapstructure:"ttl"`
+
+	// Validate is the validation applied to every value of the prefix before it is
+	// written. Invalid values are skipped and reported.
+	Validate *ValidateConfig `mapstructure:"validate"`
+}
+
+// ParsePrefixConfig parses a prefix of the format "source@dc:destination" into
+// the PrefixConfig.
+func ParsePrefixConfig(s string) (*PrefixConfig, error) {
+	if strings.TrimSpace(s) == "" {
+		return nil, fmt.Errorf("missing prefix")
+	}
+
+	parts := strings.SplitN(s, ":", 2)
+
+	var source, destination string
+	switch len(parts) {
+	case 1:
+		source, destination = parts[0], ""
+	case 2:
+		source, destination = parts[0], parts[1]
+	default:
+		return nil, fmt.Errorf("invalid format: %q", s)
+	}
+
+	// The datacenter wildcard is not a valid datacenter name, so it is matched
+	// separately
+	query := strings.TrimSuffix(source, "@*")
+	wildcardDC := query != source
+
+	// The root of the KV store has an empty prefix, which the query format
+	// does not allow, so it is matched as "/"
+	if query == "" || strings.HasPrefix(query, "@") {
+		query = "/" + query
+	}
+	if !dep.KVListQueryRe.MatchString(query) {
+		return nil, fmt.Errorf("invalid source format: %q", source)
+	}
+	m := regexpMatch(dep.KVListQueryRe, query)
+
+	prefix, dc := m["prefix"], m["dc"]
+	if wildcardDC {
+		dc = "*"
+	}
+
+	if dc == "" {
+		return nil, fmt.Errorf("missing datacenter")
+	}
+
+	if prefix == "/" {
+		prefix = ""
+	}
+
+	if destination == "" {
+		destination = prefix
+	}
+
+	if err := validateDestination(destination); err != nil {
+		return nil, err
+	}
+
+	if strings.Contains(prefix, "*") {
+		if err := validateWildcard(prefix, destination); err != nil {
+			return nil, err
+		}
+	}
+
+	// Every source datacenter is replicated into its own subtree, so the
+	// datacenters do not overwrite each other
+	if dc == "*" && !hasPlaceholder(destination, "source_dc") {
+		destination = strings.TrimSuffix(joinDestination("{{ source_dc }}", destination), "/")
+	}
+
+	c := &PrefixConfig{
+		Datacenter:  config.String(dc),
+		Destination: config.String(destination),
+		Source:      config.String(prefix),
+	}
+
+	// Wildcard prefixes are templates which are expanded at runtime, so they do
+	// not have a dependency of their own.
+	if c.IsWildcard() {
+		return c, nil
+	}
+
+	d, err := prefixQuery(prefix, dc)
+	if err != nil {
+		return nil, err
+	}
+	c.Dependency = d
+	c.Destination = config.String(renderDestination(destination, map[string]string{
+		"prefix":    prefix,
+		"source_dc": dc,
+	}))
+	return c, nil
+}
+
+// prefixQuery creates the query of the prefix in the datacenter. The root of
+// the KV store is queried as "/", which the API lists recursively from the top.
+func prefixQuery(prefix, dc string) (*dep.KVListQuery, error) {
+	if prefix == "" {
+		prefix = "/"
+	}
+	return dep.NewKVListQuery(prefix + "@" + dc)
+}
+
+// joinDestination returns the destination within the destination root.
+func joinDestination(root, destination string) string {
+	return strings.TrimRight(root, "/") + "/" + strings.TrimLeft(destination, "/")
+}
+
+// validateDestination ensures the destination only uses known placeholders.
+func validateDestination(destination string) error {
+	for _, m := range destinationTemplateRe.FindAllStringSubmatch(destination, -1) {
+		switch m[1] {
+		case "prefix", "source_dc":
+		default:
+			return fmt.Errorf("invalid destination: %q: unknown placeholder %q", destination, m[0])
+		}
+	}
+	return nil
+}
+
+// hasPlaceholder returns true if the destination uses the named placeholder.
+func hasPlaceholder(destination, name string) bool {
+	for _, m := range destinationTemplateRe.FindAllStringSubmatch(destination, -1) {
+		if m[1] == name {
+			return true
+		}
+	}
+	return false
+}
+
+// renderDestination replaces the placeholders of the destination template
+// which have a value. The prefix placeholder is the source without leading or
+// trailing slashes.
+func renderDestination(destination string, values map[string]string) string {
+	return destinationTemplateRe.ReplaceAllStringFunc(destination, func(s string) string {
+		name := destinationTemplateRe.FindStringSubmatch(s)[1]
+		v, ok := values[name]
+		if !ok {
+			return s
+		}
+		if name == "prefix" {
+			v = strings.Trim(v, "/")
+		}
+		return v
+	})
+}
+
+// validateWildcard ensures the source contains exactly one wildcard, which is
+// an entire path segment, and that the destination contains it too, as an
+// entire path segment as well.
+func validateWildcard(source, destination string) error {
+	if strings.Count(source, "*") != 1 {
+		return fmt.Errorf("invalid wildcard: %q: only one wildcard is permitted", source)
+	}
+	if err := validateWildcardSegment(source); err != nil {
+		return err
+	}
+
+	// The prefix placeholder is unique to every matching folder too
+	if strings.Count(destination, "*") != 1 && !hasPlaceholder(destination, "prefix") {
+		return fmt.Errorf("invalid wildcard: %q: destination must contain exactly one wildcard", destination)
+	}
+	return validateWildcardSegment(destination)
+}
+
+// validateWildcardSegment ensures every wildcard of the path is an entire path
+// segment.
+func validateWildcardSegment(path string) error {
+	for _, segment := range strings.Split(path, "/") {
+		if strings.Contains(segment, "*") && segment != "*" {
+			return fmt.Errorf("invalid wildcard: %q: wildcard must be an entire path segment", path)
+		}
+	}
+	return nil
+}
+
+// IsWildcard returns true if the prefix is a template matching many folders on
+// the source, or many source datacenters.
+func (c *PrefixConfig) IsWildcard() bool {
+	return strings.Contains(config.StringVal(c.Source), "*") || c.IsDatacenterWildcard()
+}
+
+// IsDatacenterWildcard returns true if the prefix is a template matching every
+// source datacenter.
+func (c *PrefixConfig) IsDatacenterWildcard() bool {
+	return config.StringVal(c.Datacenter) == "*"
+}
+
+// Expand returns a copy of a wildcard prefix for the given folder name, with
+// the wildcard replaced in both the source and destination.
+func (c *PrefixConfig) Expand(name string) (*PrefixConfig, error) {
+	o := c.Copy()
+	o.Source = config.String(strings.Replace(config.StringVal(c.Source), "*", name, 1))
+	o.Destination = config.String(strings.Replace(config.StringVal(c.Destination), "*", name, 1))
+	return o, o.render()
+}
+
+// ExpandDatacenter returns a copy of a datacenter wildcard prefix for the given
+// datacenter. The result is still a wildcard if the source is.
+func (c *PrefixConfig) ExpandDatacenter(dc string) (*PrefixConfig, error) {
+	o := c.Copy()
+	o.Datacenter = config.String(dc)
+	return o, o.render()
+}
+
+// render renders the destination template and creates the dependency of a
+// prefix which is no longer a wildcard.
+func (c *PrefixConfig) render() error {
+	c.Destination = config.String(renderDestination(config.StringVal(c.Destination), map[string]string{
+		"source_dc": config.StringVal(c.Datacenter),
+	}))
+	if c.IsWildcard() {
+		return nil
+	}
+
+	d, err := prefixQuery(config.StringVal(c.Source), config.StringVal(c.Datacenter))
+	if err != nil {
+		return err
+	}
+	c.Dependency = d
+	c.Destination = config.String(renderDestination(config.StringVal(c.Destination), map[string]string{
+		"prefix": config.StringVal(c.Source),
+	}))
+	return nil
+}
+
+func DefaultPrefixConfig() *PrefixConfig {
+	return &PrefixConfig{}
+}
+
+func (c *PrefixConfig) Copy() *PrefixConfig {
+	if c == nil {
+		return nil
+	}
+
+	var o PrefixConfig
+
+	o.Backend = c.Backend
+
+	o.DeleteOwnedOnly = c.DeleteOwnedOnly
+
+	o.Dependency = c.Dependency
+
+	o.DestinationDatacenter = c.DestinationDatacenter
+
+	o.DestinationPartition = c.DestinationPartition
+
+	o.Enabled = c.Enabled
+
+	o.ExcludeFlags = c.ExcludeFlags
+
+	o.IncludeFlags = c.IncludeFlags
+
+	if c.KeyRules != nil {
+		o.KeyRules = c.KeyRules.Copy()
+	}
+
+	o.MaxDepth = c.MaxDepth
+
+	o.MaxKeys = c.MaxKeys
+
+	o.MaxTreeBytes = c.MaxTreeBytes
+
+	o.Merged = c.Merged
+
+	if c.Middlewares != nil {
+		o.Middlewares = c.Middlewares.Copy()
+	}
+
+	o.OnSourceEmpty = c.OnSourceEmpty
+
+	o.Partition = c.Partition
+
+	o.Priority = c.Priority
+
+	if c.Routes != nil {
+		o.Routes = c.Routes.Copy()
+	}
+
+	o.Source = c.Source
+
+	if c.Blackouts != nil {
+		o.Blackouts = c.Blackouts.Copy()
+	}
+
+	if c.Canary != nil {
+		o.Canary = c.Canary.Copy()
+	}
+
+	o.Datacenter = c.Datacenter
+
+	o.Destination = c.Destination
+
+	o.TTL = c.TTL
+
+	if c.Validate != nil {
+		o.Validate = c.Validate.Copy()
+	}
+
+	return &o
+}
+
+func (c *PrefixConfig) Merge(o *PrefixConfig) *PrefixConfig {
+	if c == nil {
+		if o == nil {
+			return nil
+		}
+		return o.Copy()
+	}
+
+	if o == nil {
+		return c.Copy()
+	}
+
+	r := c.Copy()
+
+	if o.Backend != nil {
+		r.Backend = o.Backend
+	}
+
+	if o.DeleteOwnedOnly != nil {
+		r.DeleteOwnedOnly = o.DeleteOwnedOnly
+	}
+
+	if o.Dependency != nil {
+		r.Dependency = o.Dependency
+	}
+
+	if o.DestinationDatacenter != nil {
+		r.DestinationDatacenter = o.DestinationDatacenter
+	}
+
+	if o.DestinationPartition != nil {
+		r.DestinationPartition = o.DestinationPartition
+	}
+
+	if o.Enabled != nil {
+		r.Enabled = o.Enabled
+	}
+
+	if o.ExcludeFlags != nil {
+		r.ExcludeFlags = o.ExcludeFlags
+	}
+
+	if o.IncludeFlags != nil {
+		r.IncludeFlags = o.IncludeFlags
+	}
+
+	if o.KeyRules != nil {
+		r.KeyRules = r.KeyRules.Merge(o.KeyRules)
+	}
+
+	if o.MaxDepth != nil {
+		r.MaxDepth = o.MaxDepth
+	}
+
+	if o.MaxKeys != nil {
+		r.MaxKeys = o.MaxKeys
+	}
+
+	if o.MaxTreeBytes != nil {
+		r.MaxTreeBytes = o.MaxTreeBytes
+	}
+
+	if o.Merged != nil {
+		r.Merged = o.Merged
+	}
+
+	if o.Middlewares != nil {
+		r.Middlewares = r.Middlewares.Merge(o.Middlewares)
+	}
+
+	if o.OnSourceEmpty != nil {
+		r.OnSourceEmpty = o.OnSourceEmpty
+	}
+
+	if o.Partition != nil {
+		r.Partition = o.Partition
+	}
+
+	if o.Priority != nil {
+		r.Priority = o.Priority
+	}
+
+	if o.Routes != nil {
+		r.Routes = r.Routes.Merge(o.Routes)
+	}
+
+	if o.Source != nil {
+		r.Source = o.Source
+	}
+
+	if o.Blackouts != nil {
+		r.Blackouts = r.Blackouts.Merge(o.Blackouts)
+	}
+
+	if o.Canary != nil {
+		r.Canary = r.Canary.Merge(o.Canary)
+	}
+
+	if o.Datacenter != nil {
+		r.Datacenter = o.Datacenter
+	}
+
+	if o.Destination != nil {
+		r.Destination = o.Destination
+	}
+
+	if o.TTL != nil {
+		r.TTL = o.TTL
+	}
+
+	if o.Validate != nil {
+		r.Validate = r.Validate.Merge(o.Validate)
+	}
+
+	return r
+}
+
+func (c *PrefixConfig) Finalize() {
+	if c.Backend == nil {
+		c.Backend = config.String(BackendConsul)
+	}
+
+	if c.DestinationDatacenter == nil {
+		c.DestinationDatacenter = config.String("")
+	}
+
+	if c.DestinationPartition == nil {
+		c.DestinationPartition = config.String("")
+	}
+
+	if c.Enabled == nil {
+		c.Enabled = config.Bool(true)
+	}
+
+	if c.ExcludeFlags == nil {
+		c.ExcludeFlags = config.Int(0)
+	}
+
+	if c.IncludeFlags == nil {
+		c.IncludeFlags = config.Int(0)
+	}
+
+	if c.KeyRules == nil {
+		c.KeyRules = DefaultKeyRulesConfig()
+	}
+	c.KeyRules.Finalize()
+
+	if c.MaxDepth == nil {
+		c.MaxDepth = config.Int(0)
+	}
+
+	if c.MaxKeys == nil {
+		c.MaxKeys = config.Int(0)
+	}
+
+	if c.MaxTreeBytes == nil {
+		c.MaxTreeBytes = config.Int(0)
+	}
+
+	if c.Merged == nil {
+		c.Merged = config.Bool(false)
+	}
+
+	if c.Middlewares == nil {
+		c.Middlewares = DefaultMiddlewareConfigs()
+	}
+	c.Middlewares.Finalize()
+
+	if c.OnSourceEmpty == nil {
+		c.OnSourceEmpty = config.String(OnSourceEmptyDelete)
+	}
+
+	if c.Partition == nil {
+		c.Partition = config.String("")
+	}
+
+	if c.Priority == nil {
+		c.Priority = config.Int(0)
+	}
+
+	if c.Routes == nil {
+		c.Routes = DefaultRouteConfigs()
+	}
+	c.Routes.Finalize()
+
+	if c.Source == nil {
+		c.Source = config.String("")
+	}
+
+	if c.Blackouts == nil {
+		c.Blackouts = DefaultBlackoutConfigs()
+	}
+	c.Blackouts.Finalize()
+
+	if c.Canary == nil {
+		c.Canary = DefaultCanaryConfig()
+	}
+	c.Canary.Finalize()
+
+	if c.Datacenter == nil {
+		c.Datacenter = config.String("")
+	}
+
+	if c.Destination == nil {
+		c.Destination = config.String("")
+	}
+
+	if c.TTL == nil {
+		c.TTL = config.TimeDuration(0)
+	}
+
+	if c.Validate == nil {
+		c.Validate = DefaultValidateConfig()
+	}
+	c.Validate.Finalize()
+
+	if c.DeleteOwnedOnly == nil {
+		c.DeleteOwnedOnly = config.Bool(false)
+	}
+}
+
+func (c *PrefixConfig) GoString() string {
+	if c == nil {
+		return "(*PrefixConfig)(nil)"
+	}
+
+	return fmt.Sprintf("&PrefixConfig{"+
+		"Backend:%s, "+
+		"Blackouts:%s, "+
+		"Canary:%s, "+
+		"Datacenter:%s, "+
+		"DeleteOwnedOnly:%s, "+
+		"Dependency:%s, "+
+		"Destination:%s, "+
+		"DestinationDatacenter:%s, "+
+		"DestinationPartition:%s, "+
+		"Enabled:%s, "+
+		"ExcludeFlags:%s, "+
+		"IncludeFlags:%s, "+
+		"KeyRules:%s, "+
+		"MaxDepth:%s, "+
+		"MaxKeys:%s, "+
+		"MaxTreeBytes:%s, "+
+		"Merged:%s, "+
+		"Middlewares:%s, "+
+		"OnSourceEmpty:%s, "+
+		"Partition:%s, "+
+		"Priority:%s, "+
+		"Routes:%s, "+
+		"Source:%s, "+
+		"TTL:%s, "+
+		"Validate:%s"+
+		"}",
+		config.StringGoString(c.Backend),
+		c.Blackouts.GoString(),
+		c.Canary.GoString(),
+		config.StringGoString(c.Datacenter),
+		config.BoolGoString(c.DeleteOwnedOnly),
+		c.Dependency,
+		config.StringGoString(c.Destination),
+		config.StringGoString(c.DestinationDatacenter),
+		config.StringGoString(c.DestinationPartition),
+		config.BoolGoString(c.Enabled),
+		config.IntGoString(c.ExcludeFlags),
+		config.IntGoString(c.IncludeFlags),
+		c.KeyRules.GoString(),
+		config.IntGoString(c.MaxDepth),
+		config.IntGoString(c.MaxKeys),
+		config.IntGoString(c.MaxTreeBytes),
+		config.BoolGoString(c.Merged),
+		c.Middlewares.GoString(),
+		config.StringGoString(c.OnSourceEmpty),
+		config.StringGoString(c.Partition),
+		config.IntGoString(c.Priority),
+		c.Routes.GoString(),
+		config.StringGoString(c.Source),
+		config.TimeDurationGoString(c.TTL),
+		c.Validate.GoString(),
+	)
+}
+
+type PrefixConfigs []*PrefixConfig
+
+func DefaultPrefixConfigs() *PrefixConfigs {
+	return &PrefixConfigs{}
+}
+
+func (c *PrefixConfigs) Copy() *PrefixConfigs {
+	if c == nil {
+		return nil
+	}
+
+	o := make(PrefixConfigs, len(*c))
+	for i, t := range *c {
+		o[i] = t.Copy()
+	}
+	return &o
+}
+
+func (c *PrefixConfigs) Merge(o *PrefixConfigs) *PrefixConfigs {
+	if c == nil {
+		if o == nil {
+			return nil
+		}
+		return o.Copy()
+	}
+
+	if o == nil {
+		return c.Copy()
+	}
+
+	r := c.Copy()
+
+	*r = append(*r, *o...)
+
+	return r
+}
+
+func (c *PrefixConfigs) Finalize() {
+	if c == nil {
+		*c = *DefaultPrefixConfigs()
+	}
+
+	for _, t := range *c {
+		t.Finalize()
+	}
+}
+
+func (c *PrefixConfigs) GoString() string {
+	if c == nil {
+		return "(*PrefixConfigs)(nil)"
+	}
+
+	s := make([]string, len(*c))
+	for i, t := range *c {
+		s[i] = t.GoString()
+	}
+
+	return "{" + strings.Join(s, ", ") + "}"
+}
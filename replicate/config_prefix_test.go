@@ -0,0 +1,296 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: MPL-2.0
+
+package replicate
+
+import (
+	"fmt"
+	"reflect"
+	"testing"
+
+	"github.com/hashicorp/consul-template/config"
+)
+
+func TestPrefixConfig(t *testing.T) {
+	cases := []struct {
+		name string
+		s    string
+		e    *PrefixConfig
+		err  bool
+	}{
+		{
+			"empty",
+			"",
+			nil,
+			true,
+		},
+		{
+			"empty_spaces",
+			" ",
+			nil,
+			true,
+		},
+		{
+			"missing_datacenter",
+			"foo",
+			nil,
+			true,
+		},
+		{
+			"prefix_source",
+			"foo@dc",
+			&PrefixConfig{
+				Datacenter:  config.String("dc"),
+				Destination: config.String("foo"),
+				Source:      config.String("foo"),
+			},
+			false,
+		},
+		{
+			"prefix_source_slash",
+			"/foo@dc",
+			&PrefixConfig{
+				Datacenter:  config.String("dc"),
+				Destination: config.String("foo"),
+				Source:      config.String("foo"),
+			},
+			false,
+		},
+		{
+			"prefix_destination",
+			"foo@dc:bar",
+			&PrefixConfig{
+				Datacenter:  config.String("dc"),
+				Destination: config.String("bar"),
+				Source:      config.String("foo"),
+			},
+			false,
+		},
+		{
+			"root",
+			"@dc",
+			&PrefixConfig{
+				Datacenter:  config.String("dc"),
+				Destination: config.String(""),
+				Source:      config.String(""),
+			},
+			false,
+		},
+		{
+			"root_slash",
+			"/@dc",
+			&PrefixConfig{
+				Datacenter:  config.String("dc"),
+				Destination: config.String(""),
+				Source:      config.String(""),
+			},
+			false,
+		},
+		{
+			"root_destination",
+			"@dc:dc/",
+			&PrefixConfig{
+				Datacenter:  config.String("dc"),
+				Destination: config.String("dc/"),
+				Source:      config.String(""),
+			},
+			false,
+		},
+		{
+			"wildcard",
+			"apps/*/config@dc:replicated/*/config",
+			&PrefixConfig{
+				Datacenter:  config.String("dc"),
+				Destination: config.String("replicated/*/config"),
+				Source:      config.String("apps/*/config"),
+			},
+			false,
+		},
+		{
+			"wildcard_same_destination",
+			"apps/*/config@dc",
+			&PrefixConfig{
+				Datacenter:  config.String("dc"),
+				Destination: config.String("apps/*/config"),
+				Source:      config.String("apps/*/config"),
+			},
+			false,
+		},
+		{
+			"wildcard_partial_segment",
+			"apps/foo*/config@dc",
+			nil,
+			true,
+		},
+		{
+			"wildcard_partial_destination_segment",
+			"apps/*/config@dc:replicated/app-*/config",
+			nil,
+			true,
+		},
+		{
+			"wildcard_multiple",
+			"apps/*/*@dc:apps/*/*",
+			nil,
+			true,
+		},
+		{
+			"wildcard_missing_destination",
+			"apps/*/config@dc:replicated",
+			nil,
+			true,
+		},
+		{
+			"template",
+			"global/config@dc1:replicated/{{ source_dc }}/{{prefix}}",
+			&PrefixConfig{
+				Datacenter:  config.String("dc1"),
+				Destination: config.String("replicated/dc1/global/config"),
+				Source:      config.String("global/config"),
+			},
+			false,
+		},
+		{
+			"template_unknown",
+			"global@dc1:replicated/{{ node }}",
+			nil,
+			true,
+		},
+		{
+			"template_wildcard",
+			"apps/*/config@dc:replicated/{{ prefix }}",
+			&PrefixConfig{
+				Datacenter:  config.String("dc"),
+				Destination: config.String("replicated/{{ prefix }}"),
+				Source:      config.String("apps/*/config"),
+			},
+			false,
+		},
+		{
+			"datacenter_wildcard",
+			"global@*:replicated/{{ source_dc }}/global",
+			&PrefixConfig{
+				Datacenter:  config.String("*"),
+				Destination: config.String("replicated/{{ source_dc }}/global"),
+				Source:      config.String("global"),
+			},
+			false,
+		},
+		{
+			"datacenter_wildcard_default_destination",
+			"global@*:replicated/global",
+			&PrefixConfig{
+				Datacenter:  config.String("*"),
+				Destination: config.String("{{ source_dc }}/replicated/global"),
+				Source:      config.String("global"),
+			},
+			false,
+		},
+		{
+			"datacenter_wildcard_root",
+			"@*",
+			&PrefixConfig{
+				Datacenter:  config.String("*"),
+				Destination: config.String("{{ source_dc }}"),
+				Source:      config.String(""),
+			},
+			false,
+		},
+		{
+			"weird_characters",
+			"@*(#42",
+			nil,
+			true,
+		},
+	}
+
+	for i, tc := range cases {
+		t.Run(fmt.Sprintf("%d_%s", i, tc.name), func(t *testing.T) {
+			p, err := ParsePrefixConfig(tc.s)
+			if (err != nil) != tc.err {
+				t.Fatal(err)
+			}
+
+			if p != nil {
+				p.Dependency = nil
+			}
+
+			if !reflect.DeepEqual(tc.e, p) {
+				t.Errorf("\nexp: %#v\nact: %#v", tc.e, p)
+			}
+		})
+	}
+}
+
+func TestPrefixConfig_Expand(t *testing.T) {
+	p, err := ParsePrefixConfig("apps/*/config@dc:replicated/*/config")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !p.IsWildcard() {
+		t.Fatal("expected wildcard")
+	}
+
+	e, err := p.Expand("foo")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if e.IsWildcard() {
+		t.Error("expected expanded prefix not to be a wildcard")
+	}
+
+	if e.Dependency == nil || e.Dependency.String() != "kv.list(apps/foo/config@dc)" {
+		t.Errorf("bad dependency: %v", e.Dependency)
+	}
+
+	e.Dependency = nil
+	expected := &PrefixConfig{
+		Datacenter:  config.String("dc"),
+		Destination: config.String("replicated/foo/config"),
+		Source:      config.String("apps/foo/config"),
+	}
+	if !reflect.DeepEqual(expected, e) {
+		t.Errorf("\nexp: %#v\nact: %#v", expected, e)
+	}
+}
+
+func TestPrefixConfig_ExpandDatacenter(t *testing.T) {
+	p, err := ParsePrefixConfig("apps/*@*:replicated/{{ source_dc }}/{{ prefix }}")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !p.IsDatacenterWildcard() {
+		t.Fatal("expected datacenter wildcard")
+	}
+
+	d, err := p.ExpandDatacenter("dc2")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if d.IsDatacenterWildcard() || !d.IsWildcard() {
+		t.Fatal("expected folder wildcard only")
+	}
+
+	e, err := d.Expand("foo")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if e.Dependency == nil || e.Dependency.String() != "kv.list(apps/foo@dc2)" {
+		t.Errorf("bad dependency: %v", e.Dependency)
+	}
+
+	e.Dependency = nil
+	expected := &PrefixConfig{
+		Datacenter:  config.String("dc2"),
+		Destination: config.String("replicated/dc2/apps/foo"),
+		Source:      config.String("apps/foo"),
+	}
+	if !reflect.DeepEqual(expected, e) {
+		t.Errorf("\nexp: %#v\nact: %#v", expected, e)
+	}
+}
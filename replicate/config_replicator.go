@@ -0,0 +1,358 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: MPL-2.0
+
+package replicate
+
+import (
+	"fmt"
+	"path"
+	"sort"
+	"strings"
+
+	"github.com/hashicorp/consul-template/config"
+	"github.com/pkg/errors"
+)
+
+// replicatorProcessKeys are the top-level options which apply to the whole
+// process and cannot be set in a replicator block.
+var replicatorProcessKeys = []string{
+	"config_consul_path",
+	"control",
+	"kill_signal",
+	"log_level",
+	"pid_file",
+	"reload_signal",
+	"replicator",
+	"snapshot",
+	"syslog",
+}
+
+// ReplicatorConfig is a named replication group. Each group is replicated by
+// its own runner, so one daemon can serve several independent flows.
+type ReplicatorConfig struct {
+	// Config holds the settings of the group, which take precedence over the
+	// top-level settings. Top-level prefixes, excludes and sinks are not
+	// inherited.
+	Config *Config `mapstructure:"-"`
+
+	// Labels are attached to the events of the group to tell groups apart.
+	Labels map[string]string `mapstructure:"labels"`
+
+	// Name is the unique name of the group.
+	Name *string `mapstructure:"name"`
+
+	// Paused stops replication of the group without removing it.
+	Paused *bool `mapstructure:"paused"`
+}
+
+func DefaultReplicatorConfig() *ReplicatorConfig {
+	return &ReplicatorConfig{}
+}
+
+func (c *ReplicatorConfig) Copy() *ReplicatorConfig {
+	if c == nil {
+		return nil
+	}
+
+	var o ReplicatorConfig
+
+	if c.Config != nil {
+		o.Config = c.Config.Copy()
+	}
+
+	if c.Labels != nil {
+		o.Labels = make(map[string]string, len(c.Labels))
+		for k, v := range c.Labels {
+			o.Labels[k] = v
+		}
+	}
+
+	o.Name = c.Name
+
+	o.Paused = c.Paused
+
+	return &o
+}
+
+func (c *ReplicatorConfig) Merge(o *ReplicatorConfig) *ReplicatorConfig {
+	if c == nil {
+		if o == nil {
+			return nil
+		}
+		return o.Copy()
+	}
+
+	if o == nil {
+		return c.Copy()
+	}
+
+	r := c.Copy()
+
+	if o.Config != nil {
+		r.Config = r.Config.Merge(o.Config)
+	}
+
+	for k, v := range o.Labels {
+		if r.Labels == nil {
+			r.Labels = make(map[string]string)
+		}
+		r.Labels[k] = v
+	}
+
+	if o.Name != nil {
+		r.Name = o.Name
+	}
+
+	if o.Paused != nil {
+		r.Paused = o.Paused
+	}
+
+	return r
+}
+
+// Finalize ensures there no nil pointers. The settings of the group are only
+// finalized once merged with the top-level settings, by Config.Replicator.
+func (c *ReplicatorConfig) Finalize() {
+	if c.Config == nil {
+		c.Config = &Config{}
+	}
+
+	if c.Labels == nil {
+		c.Labels = make(map[string]string)
+	}
+
+	if c.Name == nil {
+		c.Name = config.String("")
+	}
+
+	if c.Paused == nil {
+		c.Paused = config.Bool(false)
+	}
+}
+
+func (c *ReplicatorConfig) GoString() string {
+	if c == nil {
+		return "(*ReplicatorConfig)(nil)"
+	}
+
+	keys := make([]string, 0, len(c.Labels))
+	for k := range c.Labels {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	labels := make([]string, len(keys))
+	for i, k := range keys {
+		labels[i] = fmt.Sprintf("%s:%q", k, c.Labels[k])
+	}
+
+	return fmt.Sprintf("&ReplicatorConfig{"+
+		"Config:%s, "+
+		"Labels:{%s}, "+
+		"Name:%s, "+
+		"Paused:%s"+
+		"}",
+		c.Config.GoString(),
+		strings.Join(labels, ", "),
+		config.StringGoString(c.Name),
+		config.BoolGoString(c.Paused),
+	)
+}
+
+type ReplicatorConfigs []*ReplicatorConfig
+
+func DefaultReplicatorConfigs() *ReplicatorConfigs {
+	return &ReplicatorConfigs{}
+}
+
+func (c *ReplicatorConfigs) Copy() *ReplicatorConfigs {
+	if c == nil {
+		return nil
+	}
+
+	o := make(ReplicatorConfigs, len(*c))
+	for i, t := range *c {
+		o[i] = t.Copy()
+	}
+	return &o
+}
+
+// Merge merges groups with the same name, so a group can be split across
+// configuration files, and appends the others.
+func (c *ReplicatorConfigs) Merge(o *ReplicatorConfigs) *ReplicatorConfigs {
+	if c == nil {
+		if o == nil {
+			return nil
+		}
+		return o.Copy()
+	}
+
+	if o == nil {
+		return c.Copy()
+	}
+
+	r := c.Copy()
+
+OUTER:
+	for _, t := range *o {
+		for i, existing := range *r {
+			if config.StringVal(existing.Name) == config.StringVal(t.Name) {
+				(*r)[i] = existing.Merge(t)
+				continue OUTER
+			}
+		}
+		*r = append(*r, t.Copy())
+	}
+
+	return r
+}
+
+func (c *ReplicatorConfigs) Finalize() {
+	for _, t := range *c {
+		t.Finalize()
+	}
+}
+
+func (c *ReplicatorConfigs) GoString() string {
+	if c == nil {
+		return "(*ReplicatorConfigs)(nil)"
+	}
+
+	s := make([]string, len(*c))
+	for i, t := range *c {
+		s[i] = t.GoString()
+	}
+
+	return "{" + strings.Join(s, ", ") + "}"
+}
+
+// Replicator returns the finalized configuration of the named group: the
+// top-level settings, less prefixes, excludes and sinks, overridden by the
+// settings of the group. Unless set by the group, the status dir is a folder
+// named after the group in the top-level status dir so groups never share
+// statuses. The configuration must be finalized.
+func (c *Config) Replicator(rc *ReplicatorConfig) *Config {
+	base := c.Copy()
+	base.Excludes = DefaultExcludeConfigs()
+	base.PidFile = config.String("")
+	base.Prefixes = DefaultPrefixConfigs()
+	base.Replicators = DefaultReplicatorConfigs()
+	base.Sinks = DefaultSinkConfigs()
+	base.StatusDir = config.String(path.Join(config.StringVal(c.StatusDir), config.StringVal(rc.Name)))
+
+	r := base.Merge(rc.Config)
+	r.Finalize()
+	return r
+}
+
+// decodeReplicators decodes the replicator blocks of a parsed configuration.
+// HCL decodes labeled blocks as a list of maps from name to body, while JSON
+// uses a map from name to body.
+func decodeReplicators(raw interface{}) (*ReplicatorConfigs, error) {
+	var named []map[string]interface{}
+	switch t := raw.(type) {
+	case []map[string]interface{}:
+		named = t
+	case map[string]interface{}:
+		named = []map[string]interface{}{t}
+	default:
+		return nil, fmt.Errorf("replicator: expected named blocks, got %T", raw)
+	}
+
+	result := DefaultReplicatorConfigs()
+	for _, m := range named {
+		names := make([]string, 0, len(m))
+		for name := range m {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+
+		for _, name := range names {
+			var bodies []map[string]interface{}
+			switch t := m[name].(type) {
+			case []map[string]interface{}:
+				bodies = t
+			case map[string]interface{}:
+				bodies = []map[string]interface{}{t}
+			default:
+				return nil, fmt.Errorf("replicator %q: expected a block, got %T", name, m[name])
+			}
+
+			for _, body := range bodies {
+				rc, err := decodeReplicator(name, body)
+				if err != nil {
+					return nil, errors.Wrapf(err, "replicator %q", name)
+				}
+				result = result.Merge(&ReplicatorConfigs{rc})
+			}
+		}
+	}
+	return result, nil
+}
+
+// decodeReplicator decodes the body of a single replicator block.
+func decodeReplicator(name string, body map[string]interface{}) (*ReplicatorConfig, error) {
+	if name == "" {
+		return nil, fmt.Errorf("missing name")
+	}
+
+	for _, key := range replicatorProcessKeys {
+		if _, ok := body[key]; ok {
+			return nil, fmt.Errorf("%q applies to the whole process and must be "+
+				"set at the top level", key)
+		}
+	}
+
+	rc := &ReplicatorConfig{Name: config.String(name)}
+
+	if raw, ok := body["paused"]; ok {
+		paused, ok := raw.(bool)
+		if !ok {
+			return nil, fmt.Errorf("paused: expected a bool, got %T", raw)
+		}
+		rc.Paused = config.Bool(paused)
+		delete(body, "paused")
+	}
+
+	if raw, ok := body["labels"]; ok {
+		labels, err := decodeLabels(raw)
+		if err != nil {
+			return nil, err
+		}
+		rc.Labels = labels
+		delete(body, "labels")
+	}
+
+	c, err := decode(body)
+	if err != nil {
+		return nil, err
+	}
+	rc.Config = c
+
+	return rc, nil
+}
+
+// decodeLabels decodes a labels block into a map of strings.
+func decodeLabels(raw interface{}) (map[string]string, error) {
+	var maps []map[string]interface{}
+	switch t := raw.(type) {
+	case []map[string]interface{}:
+		maps = t
+	case map[string]interface{}:
+		maps = []map[string]interface{}{t}
+	default:
+		return nil, fmt.Errorf("labels: expected a block, got %T", raw)
+	}
+
+	labels := make(map[string]string)
+	for _, m := range maps {
+		for k, v := range m {
+			s, ok := v.(string)
+			if !ok {
+				return nil, fmt.Errorf("labels: %q: expected a string, got %T", k, v)
+			}
+			labels[k] = s
+		}
+	}
+	return labels, nil
+}
@@ -0,0 +1,76 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: MPL-2.0
+
+package replicate
+
+import (
+	"testing"
+
+	"github.com/hashicorp/consul-template/config"
+)
+
+func TestConfig_Replicator(t *testing.T) {
+	c := TestConfig(&Config{
+		Consul: &config.ConsulConfig{
+			Address: config.String("1.2.3.4"),
+		},
+		Excludes: &ExcludeConfigs{
+			&ExcludeConfig{Source: config.String("top/secret")},
+		},
+		PidFile: config.String("/var/run/replicate.pid"),
+		Replicators: &ReplicatorConfigs{
+			&ReplicatorConfig{
+				Name: config.String("team-a"),
+				Config: &Config{
+					Prefixes: &PrefixConfigs{
+						&PrefixConfig{Source: config.String("foo")},
+					},
+				},
+			},
+			&ReplicatorConfig{
+				Name: config.String("team-b"),
+				Config: &Config{
+					Consul: &config.ConsulConfig{
+						Address: config.String("5.6.7.8"),
+					},
+					StatusDir: config.String("team-b"),
+				},
+			},
+		},
+	})
+
+	a := c.Replicator((*c.Replicators)[0])
+	if act := config.StringVal(a.Consul.Address); act != "1.2.3.4" {
+		t.Errorf("expected consul address to be inherited, got %q", act)
+	}
+	if len(*a.Excludes) != 0 || len(*a.Replicators) != 0 {
+		t.Errorf("expected lists not to be inherited: %#v", a)
+	}
+	if len(*a.Prefixes) != 1 {
+		t.Errorf("expected group prefixes: %#v", a.Prefixes)
+	}
+	if act := config.StringVal(a.PidFile); act != "" {
+		t.Errorf("expected no pid file, got %q", act)
+	}
+	if exp, act := DefaultStatusDir+"/team-a", config.StringVal(a.StatusDir); exp != act {
+		t.Errorf("\nexp: %q\nact: %q", exp, act)
+	}
+
+	b := c.Replicator((*c.Replicators)[1])
+	if act := config.StringVal(b.Consul.Address); act != "5.6.7.8" {
+		t.Errorf("expected consul address to be overridden, got %q", act)
+	}
+	if act := config.StringVal(b.StatusDir); act != "team-b" {
+		t.Errorf("expected status dir to be overridden, got %q", act)
+	}
+
+	// Top-level prefixes are only replicated as a group of their own
+	groups := newGroups(c)
+	if _, ok := groups[""]; ok || len(groups) != 2 {
+		t.Errorf("bad groups: %#v", groups)
+	}
+	c.Prefixes = &PrefixConfigs{&PrefixConfig{Source: config.String("bar")}}
+	if groups := newGroups(c); len(groups) != 3 || !groups[""].isolated {
+		t.Errorf("bad groups: %#v", groups)
+	}
+}
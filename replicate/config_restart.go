@@ -0,0 +1,115 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: MPL-2.0
+
+package replicate
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/hashicorp/consul-template/config"
+)
+
+const (
+	// DefaultRestartBackoff is the default delay before a failed prefix is
+	// retried for the first time.
+	DefaultRestartBackoff = 1 * time.Second
+
+	// DefaultRestartMaxBackoff is the default maximum delay before a failed
+	// prefix is retried.
+	DefaultRestartMaxBackoff = 5 * time.Minute
+)
+
+// RestartConfig is the configuration of the supervision of prefixes whose
+// watch dies or whose replication fails.
+type RestartConfig struct {
+	// Backoff is the delay before a failed prefix is retried, which doubles
+	// with every consecutive failure up to MaxBackoff.
+	Backoff *time.Duration `mapstructure:"backoff"`
+
+	// Enabled restarts failed prefixes while the others keep replicating.
+	// Otherwise the runner stops at the first failure.
+	Enabled *bool `mapstructure:"enabled"`
+
+	// MaxBackoff is the maximum delay before a failed prefix is retried.
+	MaxBackoff *time.Duration `mapstructure:"max_backoff"`
+}
+
+func DefaultRestartConfig() *RestartConfig {
+	return &RestartConfig{}
+}
+
+func (c *RestartConfig) Copy() *RestartConfig {
+	if c == nil {
+		return nil
+	}
+
+	var o RestartConfig
+
+	o.Backoff = c.Backoff
+
+	o.Enabled = c.Enabled
+
+	o.MaxBackoff = c.MaxBackoff
+
+	return &o
+}
+
+func (c *RestartConfig) Merge(o *RestartConfig) *RestartConfig {
+	if c == nil {
+		if o == nil {
+			return nil
+		}
+		return o.Copy()
+	}
+
+	if o == nil {
+		return c.Copy()
+	}
+
+	r := c.Copy()
+
+	if o.Backoff != nil {
+		r.Backoff = o.Backoff
+	}
+
+	if o.Enabled != nil {
+		r.Enabled = o.Enabled
+	}
+
+	if o.MaxBackoff != nil {
+		r.MaxBackoff = o.MaxBackoff
+	}
+
+	return r
+}
+
+func (c *RestartConfig) Finalize() {
+	if c.Backoff == nil {
+		c.Backoff = config.TimeDuration(DefaultRestartBackoff)
+	}
+
+	if c.Enabled == nil {
+		c.Enabled = config.Bool(true)
+	}
+
+	if c.MaxBackoff == nil {
+		c.MaxBackoff = config.TimeDuration(DefaultRestartMaxBackoff)
+	}
+}
+
+func (c *RestartConfig) GoString() string {
+	if c == nil {
+		return "(*RestartConfig)(nil)"
+	}
+
+	return fmt.Sprintf("&RestartConfig{"+
+		"Backoff:%s, "+
+		"Enabled:%s, "+
+		"MaxBackoff:%s"+
+		"}",
+		config.TimeDurationGoString(c.Backoff),
+		config.BoolGoString(c.Enabled),
+		config.TimeDurationGoString(c.MaxBackoff),
+	)
+}
@@ -0,0 +1,166 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: MPL-2.0
+
+package replicate
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/hashicorp/consul-template/config"
+)
+
+// RouteConfig sends the keys of a prefix which match a pattern to another
+// destination.
+type RouteConfig struct {
+	// Destination is the path the matching keys are written under, in place of
+	// the destination of the prefix.
+	Destination *string `mapstructure:"destination"`
+
+	// Pattern is the regular expression matched against the key, relative to
+	// the source of the prefix.
+	Pattern *string `mapstructure:"pattern"`
+
+	// Token is the ACL token used to write the matching keys to a Consul
+	// destination, in place of the destination token.
+	Token *string `mapstructure:"token" json:"-"`
+}
+
+func DefaultRouteConfig() *RouteConfig {
+	return &RouteConfig{}
+}
+
+func (c *RouteConfig) Copy() *RouteConfig {
+	if c == nil {
+		return nil
+	}
+
+	var o RouteConfig
+
+	o.Destination = c.Destination
+
+	o.Pattern = c.Pattern
+
+	o.Token = c.Token
+
+	return &o
+}
+
+func (c *RouteConfig) Merge(o *RouteConfig) *RouteConfig {
+	if c == nil {
+		if o == nil {
+			return nil
+		}
+		return o.Copy()
+	}
+
+	if o == nil {
+		return c.Copy()
+	}
+
+	r := c.Copy()
+
+	if o.Destination != nil {
+		r.Destination = o.Destination
+	}
+
+	if o.Pattern != nil {
+		r.Pattern = o.Pattern
+	}
+
+	if o.Token != nil {
+		r.Token = o.Token
+	}
+
+	return r
+}
+
+func (c *RouteConfig) Finalize() {
+	if c.Destination == nil {
+		c.Destination = config.String("")
+	}
+
+	if c.Pattern == nil {
+		c.Pattern = config.String("")
+	}
+
+	if c.Token == nil {
+		c.Token = config.String("")
+	}
+}
+
+func (c *RouteConfig) GoString() string {
+	if c == nil {
+		return "(*RouteConfig)(nil)"
+	}
+
+	return fmt.Sprintf("&RouteConfig{"+
+		"Destination:%s, "+
+		"Pattern:%s, "+
+		"Token:%t"+
+		"}",
+		config.StringGoString(c.Destination),
+		config.StringGoString(c.Pattern),
+		config.StringPresent(c.Token),
+	)
+}
+
+type RouteConfigs []*RouteConfig
+
+func DefaultRouteConfigs() *RouteConfigs {
+	return &RouteConfigs{}
+}
+
+func (c *RouteConfigs) Copy() *RouteConfigs {
+	if c == nil {
+		return nil
+	}
+
+	o := make(RouteConfigs, len(*c))
+	for i, t := range *c {
+		o[i] = t.Copy()
+	}
+	return &o
+}
+
+func (c *RouteConfigs) Merge(o *RouteConfigs) *RouteConfigs {
+	if c == nil {
+		if o == nil {
+			return nil
+		}
+		return o.Copy()
+	}
+
+	if o == nil {
+		return c.Copy()
+	}
+
+	r := c.Copy()
+
+	*r = append(*r, *o...)
+
+	return r
+}
+
+func (c *RouteConfigs) Finalize() {
+	if c == nil {
+		*c = *DefaultRouteConfigs()
+	}
+
+	for _, t := range *c {
+		t.Finalize()
+	}
+}
+
+func (c *RouteConfigs) GoString() string {
+	if c == nil {
+		return "(*RouteConfigs)(nil)"
+	}
+
+	s := make([]string, len(*c))
+	for i, t := range *c {
+		s[i] = t.GoString()
+	}
+
+	return "{" + strings.Join(s, ", ") + "}"
+}
@@ -0,0 +1,122 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: MPL-2.0
+
+package replicate
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/hashicorp/consul-template/config"
+)
+
+// DefaultServersCacheTTL is the default amount of time the results of
+// non-blocking reads are cached when talking to servers directly.
+const DefaultServersCacheTTL = 1 * time.Minute
+
+// ServersConfig is the configuration for talking to Consul servers directly,
+// bypassing the local agent.
+type ServersConfig struct {
+	// CacheTTL is the amount of time the results of non-blocking reads, such as
+	// the list of datacenters, are cached while servers are used. Zero disables
+	// the cache.
+	CacheTTL *time.Duration `mapstructure:"cache_ttl"`
+
+	// Destination is the list of HTTP addresses of the destination servers.
+	// When set, they replace the destination_consul address, and the first
+	// server which has a leader is used.
+	Destination []string `mapstructure:"destination"`
+
+	// Source is the list of HTTP addresses of the source servers. When set,
+	// they replace the consul address, and the first server which has a leader
+	// is used.
+	Source []string `mapstructure:"source"`
+}
+
+func DefaultServersConfig() *ServersConfig {
+	return &ServersConfig{}
+}
+
+func (c *ServersConfig) Copy() *ServersConfig {
+	if c == nil {
+		return nil
+	}
+
+	var o ServersConfig
+
+	o.CacheTTL = c.CacheTTL
+
+	if c.Destination != nil {
+		o.Destination = append([]string{}, c.Destination...)
+	}
+
+	if c.Source != nil {
+		o.Source = append([]string{}, c.Source...)
+	}
+
+	return &o
+}
+
+func (c *ServersConfig) Merge(o *ServersConfig) *ServersConfig {
+	if c == nil {
+		if o == nil {
+			return nil
+		}
+		return o.Copy()
+	}
+
+	if o == nil {
+		return c.Copy()
+	}
+
+	r := c.Copy()
+
+	if o.CacheTTL != nil {
+		r.CacheTTL = o.CacheTTL
+	}
+
+	if o.Destination != nil {
+		r.Destination = append([]string{}, o.Destination...)
+	}
+
+	if o.Source != nil {
+		r.Source = append([]string{}, o.Source...)
+	}
+
+	return r
+}
+
+func (c *ServersConfig) Finalize() {
+	if c.CacheTTL == nil {
+		c.CacheTTL = config.TimeDuration(DefaultServersCacheTTL)
+	}
+
+	if c.Destination == nil {
+		c.Destination = []string{}
+	}
+
+	if c.Source == nil {
+		c.Source = []string{}
+	}
+}
+
+// Enabled returns true if any servers are talked to directly.
+func (c *ServersConfig) Enabled() bool {
+	return len(c.Source) > 0 || len(c.Destination) > 0
+}
+
+func (c *ServersConfig) GoString() string {
+	if c == nil {
+		return "(*ServersConfig)(nil)"
+	}
+
+	return fmt.Sprintf("&ServersConfig{"+
+		"CacheTTL:%s, "+
+		"Destination:%q, "+
+		"Source:%q"+
+		"}",
+		config.TimeDurationGoString(c.CacheTTL),
+		c.Destination,
+		c.Source,
+	)
+}
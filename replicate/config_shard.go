@@ -0,0 +1,107 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: MPL-2.0
+
+package replicate
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/hashicorp/consul-template/config"
+)
+
+// ShardConfig is the configuration for spreading prefixes across a pool of
+// instances, which coordinate through membership keys in the destination
+// Consul.
+type ShardConfig struct {
+	// Enabled turns on sharding.
+	Enabled *bool `mapstructure:"enabled"`
+
+	// ID is the unique name of the instance in the pool. It defaults to the
+	// hostname.
+	ID *string `mapstructure:"id"`
+
+	// MembersPrefix is the prefix of the membership keys. It defaults to
+	// "members/" within the status dir.
+	MembersPrefix *string `mapstructure:"members_prefix"`
+}
+
+func DefaultShardConfig() *ShardConfig {
+	return &ShardConfig{}
+}
+
+func (c *ShardConfig) Copy() *ShardConfig {
+	if c == nil {
+		return nil
+	}
+
+	var o ShardConfig
+
+	o.Enabled = c.Enabled
+
+	o.ID = c.ID
+
+	o.MembersPrefix = c.MembersPrefix
+
+	return &o
+}
+
+func (c *ShardConfig) Merge(o *ShardConfig) *ShardConfig {
+	if c == nil {
+		if o == nil {
+			return nil
+		}
+		return o.Copy()
+	}
+
+	if o == nil {
+		return c.Copy()
+	}
+
+	r := c.Copy()
+
+	if o.Enabled != nil {
+		r.Enabled = o.Enabled
+	}
+
+	if o.ID != nil {
+		r.ID = o.ID
+	}
+
+	if o.MembersPrefix != nil {
+		r.MembersPrefix = o.MembersPrefix
+	}
+
+	return r
+}
+
+func (c *ShardConfig) Finalize() {
+	if c.Enabled == nil {
+		c.Enabled = config.Bool(false)
+	}
+
+	if c.ID == nil {
+		hostname, _ := os.Hostname()
+		c.ID = config.String(hostname)
+	}
+
+	if c.MembersPrefix == nil {
+		c.MembersPrefix = config.String("")
+	}
+}
+
+func (c *ShardConfig) GoString() string {
+	if c == nil {
+		return "(*ShardConfig)(nil)"
+	}
+
+	return fmt.Sprintf("&ShardConfig{"+
+		"Enabled:%s, "+
+		"ID:%s, "+
+		"MembersPrefix:%s"+
+		"}",
+		config.BoolGoString(c.Enabled),
+		config.StringGoString(c.ID),
+		config.StringGoString(c.MembersPrefix),
+	)
+}
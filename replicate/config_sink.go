@@ -0,0 +1,224 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: MPL-2.0
+
+package replicate
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/hashicorp/consul-template/config"
+)
+
+const (
+	// SinkKafka publishes changes to a Kafka topic through a Kafka REST proxy.
+	SinkKafka = "kafka"
+
+	// SinkNATS publishes changes to a NATS subject.
+	SinkNATS = "nats"
+)
+
+// SinkConfig is a stream where every applied change is published.
+type SinkConfig struct {
+	// Address is the address of the sink: the URL of the Kafka REST proxy, or
+	// the host:port of the NATS server.
+	Address *string `mapstructure:"address"`
+
+	// Auth is the username and password of the sink: the user of the NATS
+	// server, or the HTTP basic authentication of the Kafka REST proxy.
+	Auth *config.AuthConfig `mapstructure:"auth" json:"-"`
+
+	// SSL is the TLS configuration of the connection to the sink. The NATS
+	// connection is upgraded to TLS after the server greeting.
+	SSL *config.SSLConfig `mapstructure:"ssl"`
+
+	// Token is the authentication token of the NATS server, or the bearer
+	// token of the Kafka REST proxy.
+	Token *string `mapstructure:"token" json:"-"`
+
+	// Topic is the Kafka topic or NATS subject changes are published to.
+	Topic *string `mapstructure:"topic"`
+
+	// Type is the kind of sink, either "kafka" or "nats".
+	Type *string `mapstructure:"type"`
+}
+
+func DefaultSinkConfig() *SinkConfig {
+	return &SinkConfig{
+		Auth: config.DefaultAuthConfig(),
+		SSL:  config.DefaultSSLConfig(),
+	}
+}
+
+func (c *SinkConfig) Copy() *SinkConfig {
+	if c == nil {
+		return nil
+	}
+
+	var o SinkConfig
+
+	o.Address = c.Address
+
+	if c.Auth != nil {
+		o.Auth = c.Auth.Copy()
+	}
+
+	if c.SSL != nil {
+		o.SSL = c.SSL.Copy()
+	}
+
+	o.Token = c.Token
+
+	o.Topic = c.Topic
+
+	o.Type = c.Type
+
+	return &o
+}
+
+func (c *SinkConfig) Merge(o *SinkConfig) *SinkConfig {
+	if c == nil {
+		if o == nil {
+			return nil
+		}
+		return o.Copy()
+	}
+
+	if o == nil {
+		return c.Copy()
+	}
+
+	r := c.Copy()
+
+	if o.Address != nil {
+		r.Address = o.Address
+	}
+
+	if o.Auth != nil {
+		r.Auth = r.Auth.Merge(o.Auth)
+	}
+
+	if o.SSL != nil {
+		r.SSL = r.SSL.Merge(o.SSL)
+	}
+
+	if o.Token != nil {
+		r.Token = o.Token
+	}
+
+	if o.Topic != nil {
+		r.Topic = o.Topic
+	}
+
+	if o.Type != nil {
+		r.Type = o.Type
+	}
+
+	return r
+}
+
+func (c *SinkConfig) Finalize() {
+	if c.Address == nil {
+		c.Address = config.String("")
+	}
+
+	if c.Auth == nil {
+		c.Auth = config.DefaultAuthConfig()
+	}
+	c.Auth.Finalize()
+
+	if c.SSL == nil {
+		c.SSL = config.DefaultSSLConfig()
+	}
+	c.SSL.Finalize()
+
+	if c.Token == nil {
+		c.Token = config.String("")
+	}
+
+	if c.Topic == nil {
+		c.Topic = config.String("consul-replicate")
+	}
+
+	if c.Type == nil {
+		c.Type = config.String("")
+	}
+}
+
+func (c *SinkConfig) GoString() string {
+	if c == nil {
+		return "(*SinkConfig)(nil)"
+	}
+
+	return fmt.Sprintf("&SinkConfig{"+
+		"Address:%s, "+
+		"Auth:%s, "+
+		"SSL:%s, "+
+		"Token:%t, "+
+		"Topic:%s, "+
+		"Type:%s"+
+		"}",
+		config.StringGoString(c.Address),
+		c.Auth.GoString(),
+		c.SSL.GoString(),
+		config.StringPresent(c.Token),
+		config.StringGoString(c.Topic),
+		config.StringGoString(c.Type),
+	)
+}
+
+type SinkConfigs []*SinkConfig
+
+func DefaultSinkConfigs() *SinkConfigs {
+	return &SinkConfigs{}
+}
+
+func (c *SinkConfigs) Copy() *SinkConfigs {
+	if c == nil {
+		return nil
+	}
+
+	o := make(SinkConfigs, len(*c))
+	for i, t := range *c {
+		o[i] = t.Copy()
+	}
+	return &o
+}
+
+func (c *SinkConfigs) Merge(o *SinkConfigs) *SinkConfigs {
+	if c == nil {
+		if o == nil {
+			return nil
+		}
+		return o.Copy()
+	}
+
+	if o == nil {
+		return c.Copy()
+	}
+
+	r := c.Copy()
+
+	*r = append(*r, *o...)
+
+	return r
+}
+
+func (c *SinkConfigs) Finalize() {
+	for _, t := range *c {
+		t.Finalize()
+	}
+}
+
+func (c *SinkConfigs) GoString() string {
+	if c == nil {
+		return "(*SinkConfigs)(nil)"
+	}
+
+	s := make([]string, len(*c))
+	for i, t := range *c {
+		s[i] = t.GoString()
+	}
+
+	return "{" + strings.Join(s, ", ") + "}"
+}
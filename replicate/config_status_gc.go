@@ -0,0 +1,114 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: MPL-2.0
+
+package replicate
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/hashicorp/consul-template/config"
+)
+
+const (
+	// DefaultStatusGCInterval is the default interval between collections of
+	// the status dir.
+	DefaultStatusGCInterval = 1 * time.Hour
+
+	// DefaultStatusGCMaxAge is the default age after which the status of a
+	// prefix which is no longer configured is removed.
+	DefaultStatusGCMaxAge = 7 * 24 * time.Hour
+)
+
+// StatusGCConfig is the configuration of the garbage collection of the status
+// dir.
+type StatusGCConfig struct {
+	// Enabled periodically removes stale entries from the status dir.
+	Enabled *bool `mapstructure:"enabled"`
+
+	// Interval is the time between collections.
+	Interval *time.Duration `mapstructure:"interval"`
+
+	// MaxAge is how long the status of a prefix which is no longer configured
+	// is kept after its last update.
+	MaxAge *time.Duration `mapstructure:"max_age"`
+}
+
+func DefaultStatusGCConfig() *StatusGCConfig {
+	return &StatusGCConfig{}
+}
+
+func (c *StatusGCConfig) Copy() *StatusGCConfig {
+	if c == nil {
+		return nil
+	}
+
+	var o StatusGCConfig
+
+	o.Enabled = c.Enabled
+
+	o.Interval = c.Interval
+
+	o.MaxAge = c.MaxAge
+
+	return &o
+}
+
+func (c *StatusGCConfig) Merge(o *StatusGCConfig) *StatusGCConfig {
+	if c == nil {
+		if o == nil {
+			return nil
+		}
+		return o.Copy()
+	}
+
+	if o == nil {
+		return c.Copy()
+	}
+
+	r := c.Copy()
+
+	if o.Enabled != nil {
+		r.Enabled = o.Enabled
+	}
+
+	if o.Interval != nil {
+		r.Interval = o.Interval
+	}
+
+	if o.MaxAge != nil {
+		r.MaxAge = o.MaxAge
+	}
+
+	return r
+}
+
+func (c *StatusGCConfig) Finalize() {
+	if c.Enabled == nil {
+		c.Enabled = config.Bool(false)
+	}
+
+	if c.Interval == nil {
+		c.Interval = config.TimeDuration(DefaultStatusGCInterval)
+	}
+
+	if c.MaxAge == nil {
+		c.MaxAge = config.TimeDuration(DefaultStatusGCMaxAge)
+	}
+}
+
+func (c *StatusGCConfig) GoString() string {
+	if c == nil {
+		return "(*StatusGCConfig)(nil)"
+	}
+
+	return fmt.Sprintf("&StatusGCConfig{"+
+		"Enabled:%s, "+
+		"Interval:%s, "+
+		"MaxAge:%s"+
+		"}",
+		config.BoolGoString(c.Enabled),
+		config.TimeDurationGoString(c.Interval),
+		config.TimeDurationGoString(c.MaxAge),
+	)
+}
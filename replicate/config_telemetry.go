@@ -0,0 +1,163 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: MPL-2.0
+
+package replicate
+
+import (
+	"fmt"
+
+	"github.com/hashicorp/consul-template/config"
+)
+
+// DefaultMetricsPrefix is the default prefix of the names of the metrics.
+const DefaultMetricsPrefix = "consul_replicate"
+
+// TelemetryConfig is the configuration of the metrics of the replication
+// passes, which are emitted to statsd and dogstatsd.
+type TelemetryConfig struct {
+	// DisableHostname stops prefixing the names of gauges with the hostname.
+	DisableHostname *bool `mapstructure:"disable_hostname"`
+
+	// DogstatsdAddr is the address of the dogstatsd agent metrics are sent to,
+	// with their labels as tags.
+	DogstatsdAddr *string `mapstructure:"dogstatsd_addr"`
+
+	// DogstatsdTags are the tags, of the form "key:value", added to every
+	// metric sent to dogstatsd, such as the datacenter, environment or
+	// instance.
+	DogstatsdTags []string `mapstructure:"dogstatsd_tags"`
+
+	// MetricsPrefix is the prefix of the names of the metrics.
+	MetricsPrefix *string `mapstructure:"metrics_prefix"`
+
+	// PrefixLabels labels the metrics of every prefix with its source,
+	// datacenter and destination. Disabling it aggregates the metrics of
+	// every prefix, which keeps their cardinality down.
+	PrefixLabels *bool `mapstructure:"prefix_labels"`
+
+	// StatsdAddress is the address of the statsd server metrics are sent to,
+	// with the values of their labels appended to their names.
+	StatsdAddress *string `mapstructure:"statsd_address"`
+}
+
+func DefaultTelemetryConfig() *TelemetryConfig {
+	return &TelemetryConfig{}
+}
+
+func (c *TelemetryConfig) Copy() *TelemetryConfig {
+	if c == nil {
+		return nil
+	}
+
+	var o TelemetryConfig
+
+	o.DisableHostname = c.DisableHostname
+
+	o.DogstatsdAddr = c.DogstatsdAddr
+
+	if c.DogstatsdTags != nil {
+		o.DogstatsdTags = append([]string{}, c.DogstatsdTags...)
+	}
+
+	o.MetricsPrefix = c.MetricsPrefix
+
+	o.PrefixLabels = c.PrefixLabels
+
+	o.StatsdAddress = c.StatsdAddress
+
+	return &o
+}
+
+func (c *TelemetryConfig) Merge(o *TelemetryConfig) *TelemetryConfig {
+	if c == nil {
+		if o == nil {
+			return nil
+		}
+		return o.Copy()
+	}
+
+	if o == nil {
+		return c.Copy()
+	}
+
+	r := c.Copy()
+
+	if o.DisableHostname != nil {
+		r.DisableHostname = o.DisableHostname
+	}
+
+	if o.DogstatsdAddr != nil {
+		r.DogstatsdAddr = o.DogstatsdAddr
+	}
+
+	if o.DogstatsdTags != nil {
+		r.DogstatsdTags = append([]string{}, o.DogstatsdTags...)
+	}
+
+	if o.MetricsPrefix != nil {
+		r.MetricsPrefix = o.MetricsPrefix
+	}
+
+	if o.PrefixLabels != nil {
+		r.PrefixLabels = o.PrefixLabels
+	}
+
+	if o.StatsdAddress != nil {
+		r.StatsdAddress = o.StatsdAddress
+	}
+
+	return r
+}
+
+func (c *TelemetryConfig) Finalize() {
+	if c.DisableHostname == nil {
+		c.DisableHostname = config.Bool(false)
+	}
+
+	if c.DogstatsdAddr == nil {
+		c.DogstatsdAddr = config.String("")
+	}
+
+	if c.DogstatsdTags == nil {
+		c.DogstatsdTags = []string{}
+	}
+
+	if c.MetricsPrefix == nil {
+		c.MetricsPrefix = config.String(DefaultMetricsPrefix)
+	}
+
+	if c.PrefixLabels == nil {
+		c.PrefixLabels = config.Bool(true)
+	}
+
+	if c.StatsdAddress == nil {
+		c.StatsdAddress = config.String("")
+	}
+}
+
+// Enabled returns true if metrics are sent anywhere.
+func (c *TelemetryConfig) Enabled() bool {
+	return config.StringPresent(c.DogstatsdAddr) || config.StringPresent(c.StatsdAddress)
+}
+
+func (c *TelemetryConfig) GoString() string {
+	if c == nil {
+		return "(*TelemetryConfig)(nil)"
+	}
+
+	return fmt.Sprintf("&TelemetryConfig{"+
+		"DisableHostname:%s, "+
+		"DogstatsdAddr:%s, "+
+		"DogstatsdTags:%q, "+
+		"MetricsPrefix:%s, "+
+		"PrefixLabels:%s, "+
+		"StatsdAddress:%s"+
+		"}",
+		config.BoolGoString(c.DisableHostname),
+		config.StringGoString(c.DogstatsdAddr),
+		c.DogstatsdTags,
+		config.StringGoString(c.MetricsPrefix),
+		config.BoolGoString(c.PrefixLabels),
+		config.StringGoString(c.StatsdAddress),
+	)
+}
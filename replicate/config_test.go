@@ -0,0 +1,2351 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: MPL-2.0
+
+package replicate
+
+import (
+	"fmt"
+	"os"
+	"reflect"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/hashicorp/consul-template/config"
+)
+
+func TestParse(t *testing.T) {
+	cases := []struct {
+		name string
+		i    string
+		e    *Config
+		err  bool
+	}{
+		// Deprecations
+		// TODO: remove this in 0.5.0
+		{
+			"auth",
+			`auth {
+				enabled  = true
+				username = "foo"
+				password = "bar"
+			}`,
+			&Config{
+				Consul: &config.ConsulConfig{
+					Auth: &config.AuthConfig{
+						Enabled:  config.Bool(true),
+						Username: config.String("foo"),
+						Password: config.String("bar"),
+					},
+				},
+			},
+			false,
+		},
+		{
+			"consul_top_level",
+			`consul = "127.0.0.1:8500"`,
+			&Config{
+				Consul: &config.ConsulConfig{
+					Address: config.String("127.0.0.1:8500"),
+				},
+			},
+			false,
+		},
+		{
+			"path_top_level",
+			`path = "/foo/bar"`,
+			&Config{},
+			false,
+		},
+		{
+			"retry_top_level",
+			`retry = "5s"`,
+			&Config{
+				Consul: &config.ConsulConfig{
+					Retry: &config.RetryConfig{
+						Backoff:    config.TimeDuration(5 * time.Second),
+						MaxBackoff: config.TimeDuration(5 * time.Second),
+					},
+				},
+			},
+			false,
+		},
+		{
+			"retry_top_level_int",
+			`retry = 5`,
+			&Config{
+				Consul: &config.ConsulConfig{
+					Retry: &config.RetryConfig{
+						Backoff:    config.TimeDuration(5 * time.Nanosecond),
+						MaxBackoff: config.TimeDuration(5 * time.Nanosecond),
+					},
+				},
+			},
+			false,
+		},
+		{
+			"ssl",
+			`ssl {
+				enabled = true
+				verify  = false
+				cert    = "cert"
+				key     = "key"
+				ca_cert = "ca_cert"
+			}`,
+			&Config{
+				Consul: &config.ConsulConfig{
+					SSL: &config.SSLConfig{
+						Enabled: config.Bool(true),
+						Verify:  config.Bool(false),
+						CaCert:  config.String("ca_cert"),
+						Cert:    config.String("cert"),
+						Key:     config.String("key"),
+					},
+				},
+			},
+			false,
+		},
+		{
+			"token_top_level",
+			`token = "abcd1234"`,
+			&Config{
+				Consul: &config.ConsulConfig{
+					Token: config.String("abcd1234"),
+				},
+			},
+			false,
+		},
+		// End Depreations
+		// TODO remove in 0.5.0
+
+		{
+			"alerts",
+			`alerts {
+				command      = "page-oncall"
+				error_window = "5m"
+				format       = "slack"
+				interval     = "10s"
+				max_errors   = 3
+				max_idle     = "1h"
+				max_lag      = "2m"
+				template     = "{\"text\": {{json .Message}}}"
+				timeout      = "5s"
+				url          = "https://alerts.example.com"
+			}`,
+			&Config{
+				Alerts: &AlertsConfig{
+					Command:     config.String("page-oncall"),
+					ErrorWindow: config.TimeDuration(5 * time.Minute),
+					Format:      config.String("slack"),
+					Interval:    config.TimeDuration(10 * time.Second),
+					MaxErrors:   config.Int(3),
+					MaxIdle:     config.TimeDuration(time.Hour),
+					MaxLag:      config.TimeDuration(2 * time.Minute),
+					Template:    config.String(`{"text": {{json .Message}}}`),
+					Timeout:     config.TimeDuration(5 * time.Second),
+					URL:         config.String("https://alerts.example.com"),
+				},
+			},
+			false,
+		},
+		{
+			"allow_list",
+			`allow_list {
+				path = "security/allow"
+			}`,
+			&Config{
+				AllowList: &AllowListConfig{
+					Path: config.String("security/allow"),
+				},
+			},
+			false,
+		},
+		{
+			"allow_list_allow_empty",
+			`allow_list {
+				allow_empty = true
+			}`,
+			&Config{
+				AllowList: &AllowListConfig{
+					AllowEmpty: config.Bool(true),
+				},
+			},
+			false,
+		},
+		{
+			"allow_overlap",
+			`allow_overlap = true`,
+			&Config{
+				AllowOverlap: config.Bool(true),
+			},
+			false,
+		},
+		{
+			"bandwidth",
+			`bandwidth {
+				destination_limit = 1048576
+				source_limit      = 524288
+			}`,
+			&Config{
+				Bandwidth: &BandwidthConfig{
+					DestinationLimit: config.Int(1048576),
+					SourceLimit:      config.Int(524288),
+				},
+			},
+			false,
+		},
+		{
+			"block_query",
+			`block_query {
+				backoff     = "2s"
+				jitter      = "10s"
+				max_backoff = "30s"
+				wait        = "1m"
+			}`,
+			&Config{
+				BlockQuery: &BlockQueryConfig{
+					Backoff:    config.TimeDuration(2 * time.Second),
+					Jitter:     config.TimeDuration(10 * time.Second),
+					MaxBackoff: config.TimeDuration(30 * time.Second),
+					Wait:       config.TimeDuration(1 * time.Minute),
+				},
+			},
+			false,
+		},
+		{
+			"catalog",
+			`catalog {
+				datacenter  = "dc1"
+				destination = "catalog/dc1"
+				health      = false
+				interval    = "30s"
+				nodes       = true
+				services    = ["web", "db"]
+			}`,
+			&Config{
+				Catalogs: &CatalogConfigs{
+					&CatalogConfig{
+						Datacenter:  config.String("dc1"),
+						Destination: config.String("catalog/dc1"),
+						Health:      config.Bool(false),
+						Interval:    config.TimeDuration(30 * time.Second),
+						Nodes:       config.Bool(true),
+						Services:    []string{"web", "db"},
+					},
+				},
+			},
+			false,
+		},
+		{
+			"cert_rotation",
+			`cert_rotation {
+				interval = "10s"
+			}`,
+			&Config{
+				CertRotation: &CertRotationConfig{
+					Interval: config.TimeDuration(10 * time.Second),
+				},
+			},
+			false,
+		},
+		{
+			"chaos",
+			`chaos {
+				index_reset_percent = 1
+				read_error_percent  = 10
+				write_error_percent = 20
+			}`,
+			&Config{
+				Chaos: &ChaosConfig{
+					IndexResetPercent: config.Int(1),
+					ReadErrorPercent:  config.Int(10),
+					WriteErrorPercent: config.Int(20),
+				},
+			},
+			false,
+		},
+		{
+			"claim_destinations",
+			`claim_destinations = true`,
+			&Config{
+				ClaimDestinations: config.Bool(true),
+			},
+			false,
+		},
+		{
+			"coalesce_watches",
+			`coalesce_watches = true`,
+			&Config{
+				CoalesceWatches: config.Bool(true),
+			},
+			false,
+		},
+		{
+			"config_consul_path",
+			`config_consul_path = "service/consul-replicate/config/node1"`,
+			&Config{
+				ConfigConsulPath: config.String("service/consul-replicate/config/node1"),
+			},
+			false,
+		},
+		{
+			"config_drift_interval",
+			`config_drift_interval = "5m"`,
+			&Config{
+				ConfigDriftInterval: config.TimeDuration(5 * time.Minute),
+			},
+			false,
+		},
+		{
+			"consul_address",
+			`consul {
+				address = "1.2.3.4"
+			}`,
+			&Config{
+				Consul: &config.ConsulConfig{
+					Address: config.String("1.2.3.4"),
+				},
+			},
+			false,
+		},
+		{
+			"consul_auth",
+			`consul {
+				auth {
+					username = "username"
+					password = "password"
+				}
+			}`,
+			&Config{
+				Consul: &config.ConsulConfig{
+					Auth: &config.AuthConfig{
+						Username: config.String("username"),
+						Password: config.String("password"),
+					},
+				},
+			},
+			false,
+		},
+		{
+			"consul_retry",
+			`consul {
+				retry {
+					backoff  = "2s"
+					attempts = 10
+				}
+			}`,
+			&Config{
+				Consul: &config.ConsulConfig{
+					Retry: &config.RetryConfig{
+						Attempts: config.Int(10),
+						Backoff:  config.TimeDuration(2 * time.Second),
+					},
+				},
+			},
+			false,
+		},
+		{
+			"consul_ssl",
+			`consul {
+				ssl {}
+			}`,
+			&Config{
+				Consul: &config.ConsulConfig{
+					SSL: &config.SSLConfig{},
+				},
+			},
+			false,
+		},
+		{
+			"consul_ssl_enabled",
+			`consul {
+				ssl {
+					enabled = true
+				}
+			}`,
+			&Config{
+				Consul: &config.ConsulConfig{
+					SSL: &config.SSLConfig{
+						Enabled: config.Bool(true),
+					},
+				},
+			},
+			false,
+		},
+		{
+			"consul_ssl_verify",
+			`consul {
+				ssl {
+					verify = true
+				}
+			}`,
+			&Config{
+				Consul: &config.ConsulConfig{
+					SSL: &config.SSLConfig{
+						Verify: config.Bool(true),
+					},
+				},
+			},
+			false,
+		},
+		{
+			"consul_ssl_cert",
+			`consul {
+				ssl {
+					cert = "cert"
+				}
+			}`,
+			&Config{
+				Consul: &config.ConsulConfig{
+					SSL: &config.SSLConfig{
+						Cert: config.String("cert"),
+					},
+				},
+			},
+			false,
+		},
+		{
+			"consul_ssl_key",
+			`consul {
+				ssl {
+					key = "key"
+				}
+			}`,
+			&Config{
+				Consul: &config.ConsulConfig{
+					SSL: &config.SSLConfig{
+						Key: config.String("key"),
+					},
+				},
+			},
+			false,
+		},
+		{
+			"consul_ssl_ca_cert",
+			`consul {
+				ssl {
+					ca_cert = "ca_cert"
+				}
+			}`,
+			&Config{
+				Consul: &config.ConsulConfig{
+					SSL: &config.SSLConfig{
+						CaCert: config.String("ca_cert"),
+					},
+				},
+			},
+			false,
+		},
+		{
+			"consul_ssl_ca_path",
+			`consul {
+				ssl {
+					ca_path = "ca_path"
+				}
+			}`,
+			&Config{
+				Consul: &config.ConsulConfig{
+					SSL: &config.SSLConfig{
+						CaPath: config.String("ca_path"),
+					},
+				},
+			},
+			false,
+		},
+		{
+			"consul_ssl_server_name",
+			`consul {
+				ssl {
+					server_name = "server_name"
+				}
+			}`,
+			&Config{
+				Consul: &config.ConsulConfig{
+					SSL: &config.SSLConfig{
+						ServerName: config.String("server_name"),
+					},
+				},
+			},
+			false,
+		},
+		{
+			"consul_token",
+			`consul {
+				token = "token"
+			}`,
+			&Config{
+				Consul: &config.ConsulConfig{
+					Token: config.String("token"),
+				},
+			},
+			false,
+		},
+		{
+			"consul_tokens",
+			`consul {
+				token = "token"
+				tokens = {
+					dc1 = "dc1-token"
+					dc2 = "dc2-token"
+				}
+			}
+			destination_consul {
+				tokens {
+					dc3 = "dc3-token"
+				}
+			}`,
+			&Config{
+				Consul: &config.ConsulConfig{
+					Token: config.String("token"),
+				},
+				ConsulTokens: DatacenterTokens{
+					"dc1": "dc1-token",
+					"dc2": "dc2-token",
+				},
+				DestinationConsul: &config.ConsulConfig{},
+				DestinationConsulTokens: DatacenterTokens{
+					"dc3": "dc3-token",
+				},
+			},
+			false,
+		},
+		{
+			"consul_transport_dial_keep_alive",
+			`consul {
+				transport {
+					dial_keep_alive = "10s"
+				}
+			}`,
+			&Config{
+				Consul: &config.ConsulConfig{
+					Transport: &config.TransportConfig{
+						DialKeepAlive: config.TimeDuration(10 * time.Second),
+					},
+				},
+			},
+			false,
+		},
+		{
+			"consul_transport_dial_timeout",
+			`consul {
+				transport {
+					dial_timeout = "10s"
+				}
+			}`,
+			&Config{
+				Consul: &config.ConsulConfig{
+					Transport: &config.TransportConfig{
+						DialTimeout: config.TimeDuration(10 * time.Second),
+					},
+				},
+			},
+			false,
+		},
+		{
+			"consul_transport_disable_keep_alives",
+			`consul {
+				transport {
+					disable_keep_alives = true
+				}
+			}`,
+			&Config{
+				Consul: &config.ConsulConfig{
+					Transport: &config.TransportConfig{
+						DisableKeepAlives: config.Bool(true),
+					},
+				},
+			},
+			false,
+		},
+		{
+			"consul_transport_max_idle_conns_per_host",
+			`consul {
+				transport {
+					max_idle_conns_per_host = 100
+				}
+			}`,
+			&Config{
+				Consul: &config.ConsulConfig{
+					Transport: &config.TransportConfig{
+						MaxIdleConnsPerHost: config.Int(100),
+					},
+				},
+			},
+			false,
+		},
+		{
+			"consul_transport_tls_handshake_timeout",
+			`consul {
+				transport {
+					tls_handshake_timeout = "30s"
+				}
+			}`,
+			&Config{
+				Consul: &config.ConsulConfig{
+					Transport: &config.TransportConfig{
+						TLSHandshakeTimeout: config.TimeDuration(30 * time.Second),
+					},
+				},
+			},
+			false,
+		},
+		{
+			"control",
+			`control {
+				address = "0.0.0.0:8555"
+				ssl {
+					cert    = "server.crt"
+					key     = "server.key"
+					ca_cert = "ca.crt"
+				}
+			}`,
+			&Config{
+				Control: &ControlConfig{
+					Address: config.String("0.0.0.0:8555"),
+					SSL: &config.SSLConfig{
+						Cert:   config.String("server.crt"),
+						Key:    config.String("server.key"),
+						CaCert: config.String("ca.crt"),
+					},
+				},
+			},
+			false,
+		},
+		{
+			"control_insecure",
+			`control {
+				address  = "127.0.0.1:8555"
+				insecure = true
+			}`,
+			&Config{
+				Control: &ControlConfig{
+					Address:  config.String("127.0.0.1:8555"),
+					Insecure: config.Bool(true),
+				},
+			},
+			false,
+		},
+		{
+			"debug",
+			`debug {
+				address = "127.0.0.1:6060"
+			}`,
+			&Config{
+				Debug: &DebugConfig{
+					Address: config.String("127.0.0.1:6060"),
+				},
+			},
+			false,
+		},
+		{
+			"destination",
+			`destination {
+				service = "consul-dest@dc2"
+			}`,
+			&Config{
+				Destination: &DestinationConfig{
+					Service: config.String("consul-dest@dc2"),
+				},
+			},
+			false,
+		},
+		{
+			"destination_datacenter",
+			`destination {
+				datacenter   = "dc3"
+				max_stale    = "30s"
+				same_cluster = true
+				writes       = "servers"
+			}`,
+			&Config{
+				Destination: &DestinationConfig{
+					Datacenter:  config.String("dc3"),
+					MaxStale:    config.TimeDuration(30 * time.Second),
+					SameCluster: config.Bool(true),
+					Writes:      config.String("servers"),
+				},
+			},
+			false,
+		},
+		{
+			"destination_root",
+			`destination_root = "mirror/"`,
+			&Config{
+				DestinationRoot: config.String("mirror/"),
+			},
+			false,
+		},
+		{
+			"discovery_interval",
+			`discovery_interval = "30s"`,
+			&Config{
+				DiscoveryInterval: config.TimeDuration(30 * time.Second),
+			},
+			false,
+		},
+		{
+			"dump_signal",
+			`dump_signal = "SIGUSR2"`,
+			&Config{
+				DumpSignal: config.Signal(syscall.SIGUSR2),
+			},
+			false,
+		},
+		{
+			"exclude",
+			`exclude {
+				source = "foo/bar"
+			}`,
+			&Config{
+				Excludes: &ExcludeConfigs{
+					&ExcludeConfig{
+						Source: config.String("foo/bar"),
+					},
+				},
+			},
+			false,
+		},
+		{
+			"exclude_multi",
+			`exclude {
+				source = "foo/bar"
+			}
+
+			exclude {
+				source = "zip/zap"
+			}`,
+			&Config{
+				Excludes: &ExcludeConfigs{
+					&ExcludeConfig{
+						Source: config.String("foo/bar"),
+					},
+					&ExcludeConfig{
+						Source: config.String("zip/zap"),
+					},
+				},
+			},
+			false,
+		},
+		{
+			"exclude_string",
+			`exclude = "foo/bar"`,
+			&Config{
+				Excludes: &ExcludeConfigs{
+					&ExcludeConfig{
+						Source: config.String("foo/bar"),
+					},
+				},
+			},
+			false,
+		},
+		{
+			"ha",
+			`ha {
+				enabled      = true
+				lock_key     = "service/consul-replicate/lock"
+				warm_standby = false
+			}`,
+			&Config{
+				HA: &HAConfig{
+					Enabled:     config.Bool(true),
+					LockKey:     config.String("service/consul-replicate/lock"),
+					WarmStandby: config.Bool(false),
+				},
+			},
+			false,
+		},
+		{
+			"http",
+			`http {
+				destination {
+					http2              = true
+					max_conns_per_host = 64
+					proxy              = "socks5://bastion:1080"
+				}
+				source {
+					max_conns_per_host = 8
+				}
+			}`,
+			&Config{
+				HTTP: &HTTPConfig{
+					Destination: &HTTPClientConfig{
+						HTTP2:           config.Bool(true),
+						MaxConnsPerHost: config.Int(64),
+						Proxy:           config.String("socks5://bastion:1080"),
+					},
+					Source: &HTTPClientConfig{
+						MaxConnsPerHost: config.Int(8),
+					},
+				},
+			},
+			false,
+		},
+		{
+			"identity",
+			`identity {
+				name        = "replicator-1"
+				stamp_flags = true
+				user_agent  = "replicator/1"
+			}`,
+			&Config{
+				Identity: &IdentityConfig{
+					Name:       config.String("replicator-1"),
+					StampFlags: config.Bool(true),
+					UserAgent:  config.String("replicator/1"),
+				},
+			},
+			false,
+		},
+		{
+			"kill_signal",
+			`kill_signal = "SIGUSR1"`,
+			&Config{
+				KillSignal: config.Signal(syscall.SIGUSR1),
+			},
+			false,
+		},
+		{
+			"kubernetes",
+			`kubernetes {
+				address    = "https://10.0.0.1:443"
+				ca_cert    = "/path/to/ca"
+				kind       = "secret"
+				mode       = "key"
+				namespace  = "apps"
+				token_file = "/path/to/token"
+			}`,
+			&Config{
+				Kubernetes: &KubernetesConfig{
+					Address:   config.String("https://10.0.0.1:443"),
+					CaCert:    config.String("/path/to/ca"),
+					Kind:      config.String("secret"),
+					Mode:      config.String("key"),
+					Namespace: config.String("apps"),
+					TokenFile: config.String("/path/to/token"),
+				},
+			},
+			false,
+		},
+		{
+			"log_level",
+			`log_level = "WARN"`,
+			&Config{
+				LogLevel: config.String("WARN"),
+			},
+			false,
+		},
+		{
+			"log_level_env",
+			`log_level = "${env("CONSUL_REPLICATE_TEST_UNSET", "WARN")}"`,
+			&Config{
+				LogLevel: config.String("WARN"),
+			},
+			false,
+		},
+		{
+			"login",
+			`login {
+				destination {
+					auth_method = "kubernetes"
+					interval    = "30m"
+					meta {
+						pod = "replicator-0"
+					}
+				}
+				source {
+					auth_method       = "oidc"
+					bearer_token_file = "/path/to/jwt"
+				}
+			}`,
+			&Config{
+				Login: &LoginConfig{
+					Destination: &LoginClientConfig{
+						AuthMethod: config.String("kubernetes"),
+						Interval:   config.TimeDuration(30 * time.Minute),
+						Meta:       map[string]string{"pod": "replicator-0"},
+					},
+					Source: &LoginClientConfig{
+						AuthMethod:      config.String("oidc"),
+						BearerTokenFile: config.String("/path/to/jwt"),
+					},
+				},
+			},
+			false,
+		},
+		{
+			"loop_detection",
+			`loop_detection = true`,
+			&Config{
+				LoopDetection: config.Bool(true),
+			},
+			false,
+		},
+		{
+			"max_batch_delay",
+			`max_batch_delay = "10s"`,
+			&Config{
+				MaxBatchDelay: config.TimeDuration(10 * time.Second),
+			},
+			false,
+		},
+		{
+			"max_stale",
+			`max_stale = "10s"`,
+			&Config{
+				MaxStale: config.TimeDuration(10 * time.Second),
+			},
+			false,
+		},
+		{
+			"pid_file",
+			`pid_file = "/var/pid"`,
+			&Config{
+				PidFile: config.String("/var/pid"),
+			},
+			false,
+		},
+		{
+			"pipeline",
+			`pipeline {
+				checkpoint_interval = "30s"
+				window              = 32
+			}`,
+			&Config{
+				Pipeline: &PipelineConfig{
+					CheckpointInterval: config.TimeDuration(30 * time.Second),
+					Window:             config.Int(32),
+				},
+			},
+			false,
+		},
+		{
+			"preflight",
+			`preflight = false`,
+			&Config{
+				Preflight: config.Bool(false),
+			},
+			false,
+		},
+		{
+			"prefix",
+			`prefix {}`,
+			&Config{
+				Prefixes: &PrefixConfigs{
+					&PrefixConfig{},
+				},
+			},
+			false,
+		},
+		{
+			"prefix_multi",
+			`prefix {}
+			prefix{}`,
+			&Config{
+				Prefixes: &PrefixConfigs{
+					&PrefixConfig{},
+					&PrefixConfig{},
+				},
+			},
+			false,
+		},
+		{
+			"prefix_string",
+			`prefix = "foo/bar@dc"`,
+			&Config{
+				Prefixes: &PrefixConfigs{
+					&PrefixConfig{
+						Datacenter:  config.String("dc"),
+						Destination: config.String("foo/bar"),
+						Source:      config.String("foo/bar"),
+					},
+				},
+			},
+			false,
+		},
+		{
+			"prefix_stanza",
+			`prefix {
+				source = "foo/bar@dc"
+				destination = "default"
+			}`,
+			&Config{
+				Prefixes: &PrefixConfigs{
+					&PrefixConfig{
+						Datacenter:  config.String("dc"),
+						Destination: config.String("default"),
+						Source:      config.String("foo/bar"),
+					},
+				},
+			},
+			false,
+		},
+		{
+			"prefix_stanza_inline",
+			`prefix {
+				source = "foo/bar@dc:default"
+			}`,
+			&Config{
+				Prefixes: &PrefixConfigs{
+					&PrefixConfig{
+						Datacenter:  config.String("dc"),
+						Destination: config.String("default"),
+						Source:      config.String("foo/bar"),
+					},
+				},
+			},
+			false,
+		},
+		{
+			"prefix_stanza_datacenters",
+			`prefix {
+				source         = "foo/bar"
+				source_dc      = "dc1"
+				destination_dc = "dc2"
+			}`,
+			&Config{
+				Prefixes: &PrefixConfigs{
+					&PrefixConfig{
+						Datacenter:            config.String("dc1"),
+						Destination:           config.String("foo/bar"),
+						DestinationDatacenter: config.String("dc2"),
+						Source:                config.String("foo/bar"),
+					},
+				},
+			},
+			false,
+		},
+		{
+			"prefix_stanza_backend",
+			`prefix {
+				source  = "foo/bar@dc"
+				backend = "kubernetes"
+			}`,
+			&Config{
+				Prefixes: &PrefixConfigs{
+					&PrefixConfig{
+						Backend:     config.String("kubernetes"),
+						Datacenter:  config.String("dc"),
+						Destination: config.String("foo/bar"),
+						Source:      config.String("foo/bar"),
+					},
+				},
+			},
+			false,
+		},
+		{
+			"prefix_stanza_blackout",
+			`prefix {
+				source = "foo/bar@dc"
+				blackout {
+					schedule = "0 9 * * 1-5"
+					duration = "8h"
+				}
+			}`,
+			&Config{
+				Prefixes: &PrefixConfigs{
+					&PrefixConfig{
+						Blackouts: &BlackoutConfigs{
+							&BlackoutConfig{
+								Duration: config.TimeDuration(8 * time.Hour),
+								Schedule: config.String("0 9 * * 1-5"),
+							},
+						},
+						Datacenter:  config.String("dc"),
+						Destination: config.String("foo/bar"),
+						Source:      config.String("foo/bar"),
+					},
+				},
+			},
+			false,
+		},
+		{
+			"prefix_stanza_canary",
+			`prefix {
+				source = "foo/bar@dc"
+				canary {
+					enabled = true
+					command = "validate.sh"
+					timeout = "5s"
+				}
+			}`,
+			&Config{
+				Prefixes: &PrefixConfigs{
+					&PrefixConfig{
+						Canary: &CanaryConfig{
+							Command: config.String("validate.sh"),
+							Enabled: config.Bool(true),
+							Timeout: config.TimeDuration(5 * time.Second),
+						},
+						Datacenter:  config.String("dc"),
+						Destination: config.String("foo/bar"),
+						Source:      config.String("foo/bar"),
+					},
+				},
+			},
+			false,
+		},
+		{
+			"prefix_stanza_key_rules",
+			`prefix {
+				source = "foo/bar@dc"
+				key_rules {
+					lowercase       = true
+					reject_prefixes = ["tmp/"]
+				}
+			}`,
+			&Config{
+				Prefixes: &PrefixConfigs{
+					&PrefixConfig{
+						Datacenter:  config.String("dc"),
+						Destination: config.String("foo/bar"),
+						KeyRules: &KeyRulesConfig{
+							Lowercase:      config.Bool(true),
+							RejectPrefixes: []string{"tmp/"},
+						},
+						Source: config.String("foo/bar"),
+					},
+				},
+			},
+			false,
+		},
+		{
+			"prefix_stanza_on_source_empty",
+			`prefix {
+				source          = "foo/bar@dc"
+				on_source_empty = "keep"
+			}`,
+			&Config{
+				Prefixes: &PrefixConfigs{
+					&PrefixConfig{
+						Datacenter:    config.String("dc"),
+						Destination:   config.String("foo/bar"),
+						OnSourceEmpty: config.String("keep"),
+						Source:        config.String("foo/bar"),
+					},
+				},
+			},
+			false,
+		},
+		{
+			"prefix_stanza_enabled",
+			`prefix {
+				source  = "foo/bar@dc"
+				enabled = false
+			}`,
+			&Config{
+				Prefixes: &PrefixConfigs{
+					&PrefixConfig{
+						Datacenter:  config.String("dc"),
+						Destination: config.String("foo/bar"),
+						Enabled:     config.Bool(false),
+						Source:      config.String("foo/bar"),
+					},
+				},
+			},
+			false,
+		},
+		{
+			"prefix_stanza_delete_owned_only",
+			`prefix {
+				source            = "foo/bar@dc"
+				delete_owned_only = true
+			}`,
+			&Config{
+				Prefixes: &PrefixConfigs{
+					&PrefixConfig{
+						Datacenter:      config.String("dc"),
+						DeleteOwnedOnly: config.Bool(true),
+						Destination:     config.String("foo/bar"),
+						Source:          config.String("foo/bar"),
+					},
+				},
+			},
+			false,
+		},
+		{
+			"prefix_stanza_flags",
+			`prefix {
+				source        = "foo/bar@dc"
+				include_flags = 0x10
+				exclude_flags = 1
+			}`,
+			&Config{
+				Prefixes: &PrefixConfigs{
+					&PrefixConfig{
+						Datacenter:   config.String("dc"),
+						Destination:  config.String("foo/bar"),
+						ExcludeFlags: config.Int(1),
+						IncludeFlags: config.Int(16),
+						Source:       config.String("foo/bar"),
+					},
+				},
+			},
+			false,
+		},
+		{
+			"prefix_stanza_limits",
+			`prefix {
+				source    = "foo/bar@dc"
+				max_keys  = 10000
+				max_depth = 8
+			}`,
+			&Config{
+				Prefixes: &PrefixConfigs{
+					&PrefixConfig{
+						Datacenter:  config.String("dc"),
+						Destination: config.String("foo/bar"),
+						MaxDepth:    config.Int(8),
+						MaxKeys:     config.Int(10000),
+						Source:      config.String("foo/bar"),
+					},
+				},
+			},
+			false,
+		},
+		{
+			"prefix_stanza_max_tree_bytes",
+			`prefix {
+				source         = "foo/bar@dc"
+				max_tree_bytes = 1048576
+			}`,
+			&Config{
+				Prefixes: &PrefixConfigs{
+					&PrefixConfig{
+						Datacenter:   config.String("dc"),
+						Destination:  config.String("foo/bar"),
+						MaxTreeBytes: config.Int(1048576),
+						Source:       config.String("foo/bar"),
+					},
+				},
+			},
+			false,
+		},
+		{
+			"prefix_stanza_partition",
+			`prefix {
+				source                = "foo/bar@dc"
+				partition             = "default"
+				destination_partition = "edge-apps"
+			}`,
+			&Config{
+				Prefixes: &PrefixConfigs{
+					&PrefixConfig{
+						Datacenter:           config.String("dc"),
+						Destination:          config.String("foo/bar"),
+						DestinationPartition: config.String("edge-apps"),
+						Partition:            config.String("default"),
+						Source:               config.String("foo/bar"),
+					},
+				},
+			},
+			false,
+		},
+		{
+			"prefix_stanza_priority",
+			`prefix {
+				source   = "foo/bar@dc"
+				priority = 10
+			}`,
+			&Config{
+				Prefixes: &PrefixConfigs{
+					&PrefixConfig{
+						Datacenter:  config.String("dc"),
+						Destination: config.String("foo/bar"),
+						Priority:    config.Int(10),
+						Source:      config.String("foo/bar"),
+					},
+				},
+			},
+			false,
+		},
+		{
+			"prefix_stanza_ttl",
+			`prefix {
+				source = "foo/bar@dc"
+				ttl    = "1h"
+			}`,
+			&Config{
+				Prefixes: &PrefixConfigs{
+					&PrefixConfig{
+						Datacenter:  config.String("dc"),
+						Destination: config.String("foo/bar"),
+						Source:      config.String("foo/bar"),
+						TTL:         config.TimeDuration(1 * time.Hour),
+					},
+				},
+			},
+			false,
+		},
+		{
+			"prefix_stanza_middleware",
+			`prefix {
+				source = "foo/bar@dc"
+				middleware {
+					name = "exec"
+					options {
+						command = "redact --all"
+					}
+				}
+				middleware {
+					name = "upper"
+				}
+			}`,
+			&Config{
+				Prefixes: &PrefixConfigs{
+					&PrefixConfig{
+						Datacenter:  config.String("dc"),
+						Destination: config.String("foo/bar"),
+						Middlewares: &MiddlewareConfigs{
+							&MiddlewareConfig{
+								Name:    config.String("exec"),
+								Options: map[string]string{"command": "redact --all"},
+							},
+							&MiddlewareConfig{
+								Name: config.String("upper"),
+							},
+						},
+						Source: config.String("foo/bar"),
+					},
+				},
+			},
+			false,
+		},
+		{
+			"prefix_stanza_validate",
+			`prefix {
+				source = "foo/bar@dc"
+				validate {
+					json_schema = "schema.json"
+				}
+			}`,
+			&Config{
+				Prefixes: &PrefixConfigs{
+					&PrefixConfig{
+						Datacenter:  config.String("dc"),
+						Destination: config.String("foo/bar"),
+						Source:      config.String("foo/bar"),
+						Validate: &ValidateConfig{
+							JSONSchema: config.String("schema.json"),
+						},
+					},
+				},
+			},
+			false,
+		},
+		{
+			"prefix_stanza_merge",
+			`prefix {
+				source = "foo/bar@dc"
+				destination = "baz"
+				merge = true
+			}`,
+			&Config{
+				Prefixes: &PrefixConfigs{
+					&PrefixConfig{
+						Datacenter:  config.String("dc"),
+						Destination: config.String("baz"),
+						Merged:      config.Bool(true),
+						Source:      config.String("foo/bar"),
+					},
+				},
+			},
+			false,
+		},
+		{
+			"prefix_stanza_route",
+			`prefix {
+				source = "config@dc"
+				route {
+					pattern = "^secret/"
+					destination = "config-secret"
+					token = "abcd1234"
+				}
+			}`,
+			&Config{
+				Prefixes: &PrefixConfigs{
+					&PrefixConfig{
+						Datacenter:  config.String("dc"),
+						Destination: config.String("config"),
+						Routes: &RouteConfigs{
+							&RouteConfig{
+								Destination: config.String("config-secret"),
+								Pattern:     config.String("^secret/"),
+								Token:       config.String("abcd1234"),
+							},
+						},
+						Source: config.String("config"),
+					},
+				},
+			},
+			false,
+		},
+		{
+			"prefix_stanza_invalid_option",
+			`prefix {
+				source = "foo/bar@dc"
+				not_a_valid_key = "hello"
+			}`,
+			nil,
+			true,
+		},
+		{
+			"prefix_stanza_datacenter",
+			`prefix {
+				source = "foo/bar"
+				datacenter = "dc"
+			}`,
+			&Config{
+				Prefixes: &PrefixConfigs{
+					&PrefixConfig{
+						Datacenter:  config.String("dc"),
+						Destination: config.String("foo/bar"),
+						Source:      config.String("foo/bar"),
+					},
+				},
+			},
+			false,
+		},
+		{
+			"prefixes_consul_path",
+			`prefixes_consul_path = "service/consul-replicate/prefixes"`,
+			&Config{
+				PrefixesConsulPath: config.String("service/consul-replicate/prefixes"),
+			},
+			false,
+		},
+		{
+			"record_file",
+			`record_file = "/var/record.json"`,
+			&Config{
+				RecordFile: config.String("/var/record.json"),
+			},
+			false,
+		},
+		{
+			"reload_signal",
+			`reload_signal = "SIGUSR1"`,
+			&Config{
+				ReloadSignal: config.Signal(syscall.SIGUSR1),
+			},
+			false,
+		},
+		{
+			"replication_timeout",
+			`replication_timeout = "5m"`,
+			&Config{
+				ReplicationTimeout: config.TimeDuration(5 * time.Minute),
+			},
+			false,
+		},
+		{
+			"denied",
+			`denied {
+				backoff     = "5m"
+				enabled     = false
+				max_backoff = "1h"
+			}`,
+			&Config{
+				Denied: &DeniedConfig{
+					Backoff:    config.TimeDuration(5 * time.Minute),
+					Enabled:    config.Bool(false),
+					MaxBackoff: config.TimeDuration(time.Hour),
+				},
+			},
+			false,
+		},
+		{
+			"restart",
+			`restart {
+				backoff     = "5s"
+				enabled     = false
+				max_backoff = "10m"
+			}`,
+			&Config{
+				Restart: &RestartConfig{
+					Backoff:    config.TimeDuration(5 * time.Second),
+					Enabled:    config.Bool(false),
+					MaxBackoff: config.TimeDuration(10 * time.Minute),
+				},
+			},
+			false,
+		},
+		{
+			"rotate_signal",
+			`rotate_signal = "SIGUSR1"`,
+			&Config{
+				RotateSignal: config.Signal(syscall.SIGUSR1),
+			},
+			false,
+		},
+		{
+			"servers",
+			`servers {
+				cache_ttl   = "30s"
+				destination = ["10.0.2.10:8500"]
+				source      = ["10.0.1.10:8500", "10.0.1.11:8500"]
+			}`,
+			&Config{
+				Servers: &ServersConfig{
+					CacheTTL:    config.TimeDuration(30 * time.Second),
+					Destination: []string{"10.0.2.10:8500"},
+					Source:      []string{"10.0.1.10:8500", "10.0.1.11:8500"},
+				},
+			},
+			false,
+		},
+		{
+			"shard",
+			`shard {
+				enabled        = true
+				id             = "replicate-1"
+				members_prefix = "service/consul-replicate/members"
+			}`,
+			&Config{
+				Shard: &ShardConfig{
+					Enabled:       config.Bool(true),
+					ID:            config.String("replicate-1"),
+					MembersPrefix: config.String("service/consul-replicate/members"),
+				},
+			},
+			false,
+		},
+		{
+			"status_gc",
+			`status_gc {
+				enabled  = true
+				interval = "30m"
+				max_age  = "72h"
+			}`,
+			&Config{
+				StatusGC: &StatusGCConfig{
+					Enabled:  config.Bool(true),
+					Interval: config.TimeDuration(30 * time.Minute),
+					MaxAge:   config.TimeDuration(72 * time.Hour),
+				},
+			},
+			false,
+		},
+		{
+			"sink",
+			`sink {
+				type    = "nats"
+				address = "127.0.0.1:4222"
+				topic   = "config.changes"
+				token   = "s3cr3t"
+
+				auth {
+					username = "replicate"
+					password = "secret"
+				}
+
+				ssl {
+					enabled = true
+					ca_cert = "ca.pem"
+				}
+			}
+			sink {
+				type    = "kafka"
+				address = "http://127.0.0.1:8082"
+			}`,
+			&Config{
+				Sinks: &SinkConfigs{
+					&SinkConfig{
+						Address: config.String("127.0.0.1:4222"),
+						Auth: &config.AuthConfig{
+							Username: config.String("replicate"),
+							Password: config.String("secret"),
+						},
+						SSL: &config.SSLConfig{
+							Enabled: config.Bool(true),
+							CaCert:  config.String("ca.pem"),
+						},
+						Token: config.String("s3cr3t"),
+						Topic: config.String("config.changes"),
+						Type:  config.String("nats"),
+					},
+					&SinkConfig{
+						Address: config.String("http://127.0.0.1:8082"),
+						Type:    config.String("kafka"),
+					},
+				},
+			},
+			false,
+		},
+		{
+			"replicator",
+			`replicator "team-a" {
+				paused     = true
+				status_dir = "team-a/statuses"
+
+				consul {
+					address = "1.2.3.4"
+				}
+
+				labels {
+					team = "a"
+				}
+
+				prefix {
+					source = "foo@dc1"
+				}
+			}
+			replicator "team-b" {
+				prefix {
+					source = "bar@dc2"
+				}
+			}`,
+			&Config{
+				Replicators: &ReplicatorConfigs{
+					&ReplicatorConfig{
+						Config: &Config{
+							Consul: &config.ConsulConfig{
+								Address: config.String("1.2.3.4"),
+							},
+							Prefixes: &PrefixConfigs{
+								&PrefixConfig{
+									Datacenter:  config.String("dc1"),
+									Destination: config.String("foo"),
+									Source:      config.String("foo"),
+								},
+							},
+							StatusDir: config.String("team-a/statuses"),
+						},
+						Labels: map[string]string{"team": "a"},
+						Name:   config.String("team-a"),
+						Paused: config.Bool(true),
+					},
+					&ReplicatorConfig{
+						Config: &Config{
+							Prefixes: &PrefixConfigs{
+								&PrefixConfig{
+									Datacenter:  config.String("dc2"),
+									Destination: config.String("bar"),
+									Source:      config.String("bar"),
+								},
+							},
+						},
+						Name: config.String("team-b"),
+					},
+				},
+			},
+			false,
+		},
+		{
+			"replicator_process_option",
+			`replicator "team-a" {
+				log_level = "debug"
+			}`,
+			nil,
+			true,
+		},
+		{
+			"snapshot",
+			`snapshot = "/tmp/backup.snap"`,
+			&Config{
+				Snapshot: config.String("/tmp/backup.snap"),
+			},
+			false,
+		},
+		{
+			"stats_history",
+			`stats_history = 50`,
+			&Config{
+				StatsHistory: config.Int(50),
+			},
+			false,
+		},
+		{
+			"status_backend",
+			`status_backend = "file"
+			status_path = "/var/lib/consul-replicate"`,
+			&Config{
+				StatusBackend: config.String("file"),
+				StatusPath:    config.String("/var/lib/consul-replicate"),
+			},
+			false,
+		},
+		{
+			"status_dir",
+			`status_dir = "foo/bar/baz"`,
+			&Config{
+				StatusDir: config.String("foo/bar/baz"),
+			},
+			false,
+		},
+		{
+			"syslog",
+			`syslog {}`,
+			&Config{
+				Syslog: &config.SyslogConfig{},
+			},
+			false,
+		},
+		{
+			"syslog_enabled",
+			`syslog {
+				enabled = true
+			}`,
+			&Config{
+				Syslog: &config.SyslogConfig{
+					Enabled: config.Bool(true),
+				},
+			},
+			false,
+		},
+		{
+			"syslog_facility",
+			`syslog {
+				facility = "facility"
+			}`,
+			&Config{
+				Syslog: &config.SyslogConfig{
+					Facility: config.String("facility"),
+				},
+			},
+			false,
+		},
+		{
+			"telemetry",
+			`telemetry {
+				disable_hostname = true
+				dogstatsd_addr   = "127.0.0.1:8125"
+				dogstatsd_tags   = ["environment:production", "instance:replicator-0"]
+				metrics_prefix   = "replicate"
+				prefix_labels    = false
+				statsd_address   = "127.0.0.1:9125"
+			}`,
+			&Config{
+				Telemetry: &TelemetryConfig{
+					DisableHostname: config.Bool(true),
+					DogstatsdAddr:   config.String("127.0.0.1:8125"),
+					DogstatsdTags:   []string{"environment:production", "instance:replicator-0"},
+					MetricsPrefix:   config.String("replicate"),
+					PrefixLabels:    config.Bool(false),
+					StatsdAddress:   config.String("127.0.0.1:9125"),
+				},
+			},
+			false,
+		},
+		{
+			"token_rotation",
+			`token_rotation {
+				source_file      = "/etc/consul-replicate/source-token"
+				destination_file = "/etc/consul-replicate/destination-token"
+				interval         = "10s"
+			}`,
+			&Config{
+				TokenRotation: &TokenRotationConfig{
+					DestinationFile: config.String("/etc/consul-replicate/destination-token"),
+					Interval:        config.TimeDuration(10 * time.Second),
+					SourceFile:      config.String("/etc/consul-replicate/source-token"),
+				},
+			},
+			false,
+		},
+		{
+			"create_folders",
+			`create_folders = true`,
+			&Config{
+				CreateFolders: config.Bool(true),
+			},
+			false,
+		},
+		{
+			"backup",
+			`backup {
+				dir         = "/var/lib/consul-replicate/backups"
+				consul_path = "service/consul-replicate/backups"
+				retention   = "72h"
+			}`,
+			&Config{
+				Backup: &BackupConfig{
+					ConsulPath: config.String("service/consul-replicate/backups"),
+					Dir:        config.String("/var/lib/consul-replicate/backups"),
+					Retention:  config.TimeDuration(72 * time.Hour),
+				},
+			},
+			false,
+		},
+		{
+			"diff",
+			`diff {
+				dir         = "/var/lib/consul-replicate/diffs"
+				consul_path = "service/consul-replicate/diffs"
+				retention   = "72h"
+			}`,
+			&Config{
+				Diff: &DiffConfig{
+					ConsulPath: config.String("service/consul-replicate/diffs"),
+					Dir:        config.String("/var/lib/consul-replicate/diffs"),
+					Retention:  config.TimeDuration(72 * time.Hour),
+				},
+			},
+			false,
+		},
+		{
+			"drift",
+			`drift {
+				enabled = true
+			}`,
+			&Config{
+				Drift: &DriftConfig{
+					Enabled: config.Bool(true),
+				},
+			},
+			false,
+		},
+		{
+			"metadata",
+			`metadata {
+				dir = "audit/meta"
+			}`,
+			&Config{
+				Metadata: &MetadataConfig{
+					Dir: config.String("audit/meta"),
+				},
+			},
+			false,
+		},
+		{
+			"mirror",
+			`mirror {
+				dir = "/var/lib/consul-replicate/mirror"
+			}`,
+			&Config{
+				Mirror: &MirrorConfig{
+					Dir: config.String("/var/lib/consul-replicate/mirror"),
+				},
+			},
+			false,
+		},
+		{
+			"tombstone",
+			`tombstone {
+				retention = "48h"
+				suffix    = ".gone"
+			}`,
+			&Config{
+				Tombstone: &TombstoneConfig{
+					Retention: config.TimeDuration(48 * time.Hour),
+					Suffix:    config.String(".gone"),
+				},
+			},
+			false,
+		},
+		{
+			"undo",
+			`undo {
+				max_bytes = 65536
+				passes    = 3
+			}`,
+			&Config{
+				Undo: &UndoConfig{
+					MaxBytes: config.Int(65536),
+					Passes:   config.Int(3),
+				},
+			},
+			false,
+		},
+		{
+			"upgrade_signal",
+			`upgrade_signal = "SIGUSR2"`,
+			&Config{
+				UpgradeSignal: config.Signal(syscall.SIGUSR2),
+			},
+			false,
+		},
+		{
+			"verify_before_write",
+			`verify_before_write = true`,
+			&Config{
+				VerifyBeforeWrite: config.Bool(true),
+			},
+			false,
+		},
+		{
+			"version_check",
+			`version_check {
+				enabled = false
+				interval = "10m"
+			}`,
+			&Config{
+				VersionCheck: &VersionCheckConfig{
+					Enabled:  config.Bool(false),
+					Interval: config.TimeDuration(10 * time.Minute),
+				},
+			},
+			false,
+		},
+		{
+			"wait",
+			`wait {
+				min = "10s"
+				max = "20s"
+			}`,
+			&Config{
+				Wait: &config.WaitConfig{
+					Min: config.TimeDuration(10 * time.Second),
+					Max: config.TimeDuration(20 * time.Second),
+				},
+			},
+			false,
+		},
+		{
+			"wait_for_clusters",
+			`wait_for_clusters {
+				interval = "10s"
+				timeout  = "5m"
+			}`,
+			&Config{
+				WaitForClusters: &WaitForClustersConfig{
+					Interval: config.TimeDuration(10 * time.Second),
+					Timeout:  config.TimeDuration(5 * time.Minute),
+				},
+			},
+			false,
+		},
+		{
+			// Previous wait declarations used this syntax, but now use the stanza
+			// syntax. Keep this around for backwards-compat.
+			"wait_as_string",
+			`wait = "10s:20s"`,
+			&Config{
+				Wait: &config.WaitConfig{
+					Min: config.TimeDuration(10 * time.Second),
+					Max: config.TimeDuration(20 * time.Second),
+				},
+			},
+			false,
+		},
+
+		// General validation
+		{
+			"invalid_key",
+			`not_a_valid_key = "hello"`,
+			nil,
+			true,
+		},
+		{
+			"invalid_stanza",
+			`not_a_valid_stanza {
+				a = "b"
+			}`,
+			nil,
+			true,
+		},
+		{
+			"mapstructure_error",
+			`consul = true`,
+			nil,
+			true,
+		},
+	}
+
+	for i, tc := range cases {
+		t.Run(fmt.Sprintf("%d_%s", i, tc.name), func(t *testing.T) {
+			c, err := Parse(tc.i)
+			if (err != nil) != tc.err {
+				t.Fatal(err)
+			}
+
+			if c != nil && c.Prefixes != nil {
+				for _, p := range *c.Prefixes {
+					p.Dependency = nil
+				}
+			}
+			if c != nil && c.Replicators != nil {
+				for _, r := range *c.Replicators {
+					for _, p := range *r.Config.Prefixes {
+						p.Dependency = nil
+					}
+				}
+			}
+
+			if !reflect.DeepEqual(tc.e, c) {
+				t.Errorf("\nexp: %#v\nact: %#v", tc.e, c)
+			}
+		})
+	}
+}
+
+func TestConfig_Merge(t *testing.T) {
+	cases := []struct {
+		name string
+		a    *Config
+		b    *Config
+		r    *Config
+	}{
+		{
+			"nil_a",
+			nil,
+			&Config{},
+			&Config{},
+		},
+		{
+			"nil_b",
+			&Config{},
+			nil,
+			&Config{},
+		},
+		{
+			"nil_both",
+			nil,
+			nil,
+			nil,
+		},
+		{
+			"empty",
+			&Config{},
+			&Config{},
+			&Config{},
+		},
+		{
+			"consul",
+			&Config{
+				Consul: &config.ConsulConfig{
+					Address: config.String("consul"),
+				},
+			},
+			&Config{
+				Consul: &config.ConsulConfig{
+					Address: config.String("consul-diff"),
+				},
+			},
+			&Config{
+				Consul: &config.ConsulConfig{
+					Address: config.String("consul-diff"),
+				},
+			},
+		},
+		{
+			"exclude",
+			&Config{
+				Excludes: &ExcludeConfigs{
+					&ExcludeConfig{
+						Source: config.String("foo/bar"),
+					},
+				},
+			},
+			&Config{
+				Excludes: &ExcludeConfigs{
+					&ExcludeConfig{
+						Source: config.String("zip/zap"),
+					},
+				},
+			},
+			&Config{
+				Excludes: &ExcludeConfigs{
+					&ExcludeConfig{
+						Source: config.String("foo/bar"),
+					},
+					&ExcludeConfig{
+						Source: config.String("zip/zap"),
+					},
+				},
+			},
+		},
+		{
+			"kill_signal",
+			&Config{
+				KillSignal: config.Signal(syscall.SIGUSR1),
+			},
+			&Config{
+				KillSignal: config.Signal(syscall.SIGUSR2),
+			},
+			&Config{
+				KillSignal: config.Signal(syscall.SIGUSR2),
+			},
+		},
+		{
+			"log_level",
+			&Config{
+				LogLevel: config.String("log_level"),
+			},
+			&Config{
+				LogLevel: config.String("log_level-diff"),
+			},
+			&Config{
+				LogLevel: config.String("log_level-diff"),
+			},
+		},
+		{
+			"max_stale",
+			&Config{
+				MaxStale: config.TimeDuration(10 * time.Second),
+			},
+			&Config{
+				MaxStale: config.TimeDuration(20 * time.Second),
+			},
+			&Config{
+				MaxStale: config.TimeDuration(20 * time.Second),
+			},
+		},
+		{
+			"pid_file",
+			&Config{
+				PidFile: config.String("pid_file"),
+			},
+			&Config{
+				PidFile: config.String("pid_file-diff"),
+			},
+			&Config{
+				PidFile: config.String("pid_file-diff"),
+			},
+		},
+		{
+			"prefix",
+			&Config{
+				Prefixes: &PrefixConfigs{
+					&PrefixConfig{
+						Source: config.String("foo/bar"),
+					},
+				},
+			},
+			&Config{
+				Prefixes: &PrefixConfigs{
+					&PrefixConfig{
+						Source: config.String("zip/zap"),
+					},
+				},
+			},
+			&Config{
+				Prefixes: &PrefixConfigs{
+					&PrefixConfig{
+						Source: config.String("foo/bar"),
+					},
+					&PrefixConfig{
+						Source: config.String("zip/zap"),
+					},
+				},
+			},
+		},
+		{
+			"reload_signal",
+			&Config{
+				ReloadSignal: config.Signal(syscall.SIGUSR1),
+			},
+			&Config{
+				ReloadSignal: config.Signal(syscall.SIGUSR2),
+			},
+			&Config{
+				ReloadSignal: config.Signal(syscall.SIGUSR2),
+			},
+		},
+		{
+			"status_dir",
+			&Config{
+				StatusDir: config.String("foo"),
+			},
+			&Config{
+				StatusDir: config.String("bar"),
+			},
+			&Config{
+				StatusDir: config.String("bar"),
+			},
+		},
+		{
+			"syslog",
+			&Config{
+				Syslog: &config.SyslogConfig{
+					Enabled: config.Bool(true),
+				},
+			},
+			&Config{
+				Syslog: &config.SyslogConfig{
+					Enabled: config.Bool(false),
+				},
+			},
+			&Config{
+				Syslog: &config.SyslogConfig{
+					Enabled: config.Bool(false),
+				},
+			},
+		},
+		{
+			"wait",
+			&Config{
+				Wait: &config.WaitConfig{
+					Min: config.TimeDuration(10 * time.Second),
+					Max: config.TimeDuration(20 * time.Second),
+				},
+			},
+			&Config{
+				Wait: &config.WaitConfig{
+					Min: config.TimeDuration(20 * time.Second),
+					Max: config.TimeDuration(50 * time.Second),
+				},
+			},
+			&Config{
+				Wait: &config.WaitConfig{
+					Min: config.TimeDuration(20 * time.Second),
+					Max: config.TimeDuration(50 * time.Second),
+				},
+			},
+		},
+	}
+
+	for i, tc := range cases {
+		t.Run(fmt.Sprintf("%d_%s", i, tc.name), func(t *testing.T) {
+			r := tc.a.Merge(tc.b)
+			if !reflect.DeepEqual(tc.r, r) {
+				t.Errorf("\nexp: %#v\nact: %#v", tc.r, r)
+			}
+		})
+	}
+}
+
+func TestFromPath(t *testing.T) {
+	f, err := os.CreateTemp("", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(f.Name())
+
+	emptyDir, err := os.MkdirTemp(os.TempDir(), "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(emptyDir)
+
+	configDir, err := os.MkdirTemp(os.TempDir(), "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(configDir)
+	cf1, err := os.CreateTemp(configDir, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	d := []byte(`
+		consul {
+			address = "1.2.3.4"
+		}
+	`)
+	if err = os.WriteFile(cf1.Name(), d, 0644); err != nil {
+		t.Fatal(err)
+	}
+	cf2, err := os.CreateTemp(configDir, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	d = []byte(`
+		consul {
+			token = "token"
+		}
+	`)
+	if err := os.WriteFile(cf2.Name(), d, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	cases := []struct {
+		name string
+		path string
+		e    *Config
+		err  bool
+	}{
+		{
+			"missing_dir",
+			"/not/a/real/dir",
+			nil,
+			true,
+		},
+		{
+			"file",
+			f.Name(),
+			&Config{},
+			false,
+		},
+		{
+			"empty_dir",
+			emptyDir,
+			nil,
+			false,
+		},
+		{
+			"config_dir",
+			configDir,
+			&Config{
+				Consul: &config.ConsulConfig{
+					Address: config.String("1.2.3.4"),
+					Token:   config.String("token"),
+				},
+			},
+			false,
+		},
+	}
+
+	for i, tc := range cases {
+		t.Run(fmt.Sprintf("%d_%s", i, tc.name), func(t *testing.T) {
+			c, err := FromPath(tc.path)
+			if (err != nil) != tc.err {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(tc.e, c) {
+				t.Errorf("\nexp: %#v\nact: %#v", tc.e, c)
+			}
+		})
+	}
+}
+
+func TestDefaultConfig(t *testing.T) {
+	cases := []struct {
+		env string
+		val string
+		e   *Config
+		err bool
+	}{
+		{
+			"CONSUL_HTTP_ADDR",
+			"1.2.3.4",
+			&Config{
+				Consul: &config.ConsulConfig{
+					Address: config.String("1.2.3.4"),
+				},
+			},
+			false,
+		},
+		{
+			"CONSUL_REPLICATE_LOG",
+			"DEBUG",
+			&Config{
+				LogLevel: config.String("DEBUG"),
+			},
+			false,
+		},
+		{
+			"CR_LOG",
+			"DEBUG",
+			&Config{
+				LogLevel: config.String("DEBUG"),
+			},
+			false,
+		},
+		{
+			"CONSUL_TOKEN",
+			"token",
+			&Config{
+				Consul: &config.ConsulConfig{
+					Token: config.String("token"),
+				},
+			},
+			false,
+		},
+	}
+
+	for i, tc := range cases {
+		t.Run(fmt.Sprintf("%d_%s", i, tc.env), func(t *testing.T) {
+			if err := os.Setenv(tc.env, tc.val); err != nil {
+				t.Fatal(err)
+			}
+			defer os.Unsetenv(tc.env)
+
+			r := DefaultConfig()
+			r.Merge(tc.e)
+
+			c := DefaultConfig()
+			if !reflect.DeepEqual(r, c) {
+				t.Errorf("\nexp: %#v\nact: %#v", r, c)
+			}
+		})
+	}
+}
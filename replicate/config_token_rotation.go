@@ -0,0 +1,126 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: MPL-2.0
+
+package replicate
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/hashicorp/consul-template/config"
+)
+
+const (
+	// DefaultTokenRotationInterval is the default interval between reads of
+	// the token files.
+	DefaultTokenRotationInterval = 1 * time.Minute
+)
+
+// TokenRotationConfig is the configuration of the files the ACL tokens of the
+// source and destination are read from, so rotated tokens are picked up
+// without a restart.
+type TokenRotationConfig struct {
+	// DestinationFile is the path of the file holding the destination token.
+	DestinationFile *string `mapstructure:"destination_file"`
+
+	// Enabled turns on token rotation. It defaults to true if a file is given.
+	Enabled *bool `mapstructure:"enabled"`
+
+	// Interval is the time between reads of the token files.
+	Interval *time.Duration `mapstructure:"interval"`
+
+	// SourceFile is the path of the file holding the source token.
+	SourceFile *string `mapstructure:"source_file"`
+}
+
+func DefaultTokenRotationConfig() *TokenRotationConfig {
+	return &TokenRotationConfig{}
+}
+
+func (c *TokenRotationConfig) Copy() *TokenRotationConfig {
+	if c == nil {
+		return nil
+	}
+
+	var o TokenRotationConfig
+
+	o.DestinationFile = c.DestinationFile
+
+	o.Enabled = c.Enabled
+
+	o.Interval = c.Interval
+
+	o.SourceFile = c.SourceFile
+
+	return &o
+}
+
+func (c *TokenRotationConfig) Merge(o *TokenRotationConfig) *TokenRotationConfig {
+	if c == nil {
+		if o == nil {
+			return nil
+		}
+		return o.Copy()
+	}
+
+	if o == nil {
+		return c.Copy()
+	}
+
+	r := c.Copy()
+
+	if o.DestinationFile != nil {
+		r.DestinationFile = o.DestinationFile
+	}
+
+	if o.Enabled != nil {
+		r.Enabled = o.Enabled
+	}
+
+	if o.Interval != nil {
+		r.Interval = o.Interval
+	}
+
+	if o.SourceFile != nil {
+		r.SourceFile = o.SourceFile
+	}
+
+	return r
+}
+
+func (c *TokenRotationConfig) Finalize() {
+	if c.DestinationFile == nil {
+		c.DestinationFile = config.String("")
+	}
+
+	if c.Interval == nil {
+		c.Interval = config.TimeDuration(DefaultTokenRotationInterval)
+	}
+
+	if c.SourceFile == nil {
+		c.SourceFile = config.String("")
+	}
+
+	if c.Enabled == nil {
+		c.Enabled = config.Bool(config.StringPresent(c.SourceFile) ||
+			config.StringPresent(c.DestinationFile))
+	}
+}
+
+func (c *TokenRotationConfig) GoString() string {
+	if c == nil {
+		return "(*TokenRotationConfig)(nil)"
+	}
+
+	return fmt.Sprintf("&TokenRotationConfig{"+
+		"DestinationFile:%s, "+
+		"Enabled:%s, "+
+		"Interval:%s, "+
+		"SourceFile:%s"+
+		"}",
+		config.StringGoString(c.DestinationFile),
+		config.BoolGoString(c.Enabled),
+		config.TimeDurationGoString(c.Interval),
+		config.StringGoString(c.SourceFile),
+	)
+}
@@ -0,0 +1,58 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: MPL-2.0
+
+package replicate
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// DatacenterTokens are ACL tokens keyed by datacenter. A client sends the
+// token of a datacenter in place of its own token with the requests it makes
+// in that datacenter, so a single client configuration authenticates
+// differently in every datacenter of WAN-federated clusters.
+type DatacenterTokens map[string]string
+
+func (t DatacenterTokens) Copy() DatacenterTokens {
+	if t == nil {
+		return nil
+	}
+
+	o := make(DatacenterTokens, len(t))
+	for dc, token := range t {
+		o[dc] = token
+	}
+	return o
+}
+
+func (t DatacenterTokens) Merge(o DatacenterTokens) DatacenterTokens {
+	if t == nil {
+		return o.Copy()
+	}
+
+	r := t.Copy()
+	for dc, token := range o {
+		r[dc] = token
+	}
+	return r
+}
+
+func (t DatacenterTokens) GoString() string {
+	if t == nil {
+		return "DatacenterTokens(nil)"
+	}
+
+	dcs := make([]string, 0, len(t))
+	for dc := range t {
+		dcs = append(dcs, dc)
+	}
+	sort.Strings(dcs)
+
+	tokens := make([]string, len(dcs))
+	for i, dc := range dcs {
+		tokens[i] = fmt.Sprintf("%s:%q", dc, t[dc])
+	}
+	return "DatacenterTokens{" + strings.Join(tokens, ", ") + "}"
+}
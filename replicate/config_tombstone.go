@@ -0,0 +1,114 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: MPL-2.0
+
+package replicate
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/hashicorp/consul-template/config"
+)
+
+const (
+	// DefaultTombstoneRetention is the default amount of time a tombstone is
+	// kept in the destination.
+	DefaultTombstoneRetention = 24 * time.Hour
+
+	// DefaultTombstoneSuffix is the default suffix of the key of a tombstone.
+	DefaultTombstoneSuffix = ".deleted"
+)
+
+// TombstoneConfig writes a tombstone next to every key deleted from the
+// destination, so consumers can tell a key deleted upstream from a key which
+// never existed.
+type TombstoneConfig struct {
+	// Enabled writes tombstones. Specifying any other option also enables it.
+	Enabled *bool `mapstructure:"enabled"`
+
+	// Retention is the amount of time a tombstone is kept, after which it is
+	// removed by the next replication pass of its prefix.
+	Retention *time.Duration `mapstructure:"retention"`
+
+	// Suffix is appended to the deleted key to form the key of its tombstone.
+	Suffix *string `mapstructure:"suffix"`
+}
+
+func DefaultTombstoneConfig() *TombstoneConfig {
+	return &TombstoneConfig{}
+}
+
+func (c *TombstoneConfig) Copy() *TombstoneConfig {
+	if c == nil {
+		return nil
+	}
+
+	var o TombstoneConfig
+
+	o.Enabled = c.Enabled
+
+	o.Retention = c.Retention
+
+	o.Suffix = c.Suffix
+
+	return &o
+}
+
+func (c *TombstoneConfig) Merge(o *TombstoneConfig) *TombstoneConfig {
+	if c == nil {
+		if o == nil {
+			return nil
+		}
+		return o.Copy()
+	}
+
+	if o == nil {
+		return c.Copy()
+	}
+
+	r := c.Copy()
+
+	if o.Enabled != nil {
+		r.Enabled = o.Enabled
+	}
+
+	if o.Retention != nil {
+		r.Retention = o.Retention
+	}
+
+	if o.Suffix != nil {
+		r.Suffix = o.Suffix
+	}
+
+	return r
+}
+
+func (c *TombstoneConfig) Finalize() {
+	if c.Enabled == nil {
+		c.Enabled = config.Bool(c.Retention != nil || c.Suffix != nil)
+	}
+
+	if c.Retention == nil {
+		c.Retention = config.TimeDuration(DefaultTombstoneRetention)
+	}
+
+	if c.Suffix == nil {
+		c.Suffix = config.String(DefaultTombstoneSuffix)
+	}
+}
+
+func (c *TombstoneConfig) GoString() string {
+	if c == nil {
+		return "(*TombstoneConfig)(nil)"
+	}
+
+	return fmt.Sprintf("&TombstoneConfig{"+
+		"Enabled:%s, "+
+		"Retention:%s, "+
+		"Suffix:%s"+
+		"}",
+		config.BoolGoString(c.Enabled),
+		config.TimeDurationGoString(c.Retention),
+		config.StringGoString(c.Suffix),
+	)
+}
@@ -0,0 +1,114 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: MPL-2.0
+
+package replicate
+
+import (
+	"fmt"
+
+	"github.com/hashicorp/consul-template/config"
+)
+
+const (
+	// DefaultUndoMaxBytes is the default maximum size of the undo log of a
+	// prefix, which stays under the default maximum size of a Consul value.
+	DefaultUndoMaxBytes = 256 * 1024
+
+	// DefaultUndoPasses is the default number of passes of a prefix which can
+	// be rolled back.
+	DefaultUndoPasses = 10
+)
+
+// UndoConfig keeps the previous values of the keys overwritten and deleted by
+// the last passes of every prefix, so the rollback command can restore the
+// destination after a bad upstream change was replicated.
+type UndoConfig struct {
+	// Enabled keeps the undo log. Specifying any other option also enables it.
+	Enabled *bool `mapstructure:"enabled"`
+
+	// MaxBytes is the maximum size of the undo log of a prefix. The oldest
+	// passes are dropped to stay under it.
+	MaxBytes *int `mapstructure:"max_bytes"`
+
+	// Passes is the maximum number of passes of a prefix kept in its undo log.
+	Passes *int `mapstructure:"passes"`
+}
+
+func DefaultUndoConfig() *UndoConfig {
+	return &UndoConfig{}
+}
+
+func (c *UndoConfig) Copy() *UndoConfig {
+	if c == nil {
+		return nil
+	}
+
+	var o UndoConfig
+
+	o.Enabled = c.Enabled
+
+	o.MaxBytes = c.MaxBytes
+
+	o.Passes = c.Passes
+
+	return &o
+}
+
+func (c *UndoConfig) Merge(o *UndoConfig) *UndoConfig {
+	if c == nil {
+		if o == nil {
+			return nil
+		}
+		return o.Copy()
+	}
+
+	if o == nil {
+		return c.Copy()
+	}
+
+	r := c.Copy()
+
+	if o.Enabled != nil {
+		r.Enabled = o.Enabled
+	}
+
+	if o.MaxBytes != nil {
+		r.MaxBytes = o.MaxBytes
+	}
+
+	if o.Passes != nil {
+		r.Passes = o.Passes
+	}
+
+	return r
+}
+
+func (c *UndoConfig) Finalize() {
+	if c.Enabled == nil {
+		c.Enabled = config.Bool(c.MaxBytes != nil || c.Passes != nil)
+	}
+
+	if c.MaxBytes == nil {
+		c.MaxBytes = config.Int(DefaultUndoMaxBytes)
+	}
+
+	if c.Passes == nil {
+		c.Passes = config.Int(DefaultUndoPasses)
+	}
+}
+
+func (c *UndoConfig) GoString() string {
+	if c == nil {
+		return "(*UndoConfig)(nil)"
+	}
+
+	return fmt.Sprintf("&UndoConfig{"+
+		"Enabled:%s, "+
+		"MaxBytes:%s, "+
+		"Passes:%s"+
+		"}",
+		config.BoolGoString(c.Enabled),
+		config.IntGoString(c.MaxBytes),
+		config.IntGoString(c.Passes),
+	)
+}
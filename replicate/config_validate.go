@@ -0,0 +1,93 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: MPL-2.0
+
+package replicate
+
+import (
+	"fmt"
+
+	"github.com/hashicorp/consul-template/config"
+)
+
+// ValidateConfig is the validation applied to the values of a prefix before
+// they are written, so corrupt values are not propagated downstream.
+type ValidateConfig struct {
+	// JSON skips values which are not valid JSON.
+	JSON *bool `mapstructure:"json"`
+
+	// JSONSchema is the path of a JSON Schema file values must match. It
+	// implies JSON.
+	JSONSchema *string `mapstructure:"json_schema"`
+}
+
+func DefaultValidateConfig() *ValidateConfig {
+	return &ValidateConfig{}
+}
+
+func (c *ValidateConfig) Copy() *ValidateConfig {
+	if c == nil {
+		return nil
+	}
+
+	var o ValidateConfig
+
+	o.JSON = c.JSON
+
+	o.JSONSchema = c.JSONSchema
+
+	return &o
+}
+
+func (c *ValidateConfig) Merge(o *ValidateConfig) *ValidateConfig {
+	if c == nil {
+		if o == nil {
+			return nil
+		}
+		return o.Copy()
+	}
+
+	if o == nil {
+		return c.Copy()
+	}
+
+	r := c.Copy()
+
+	if o.JSON != nil {
+		r.JSON = o.JSON
+	}
+
+	if o.JSONSchema != nil {
+		r.JSONSchema = o.JSONSchema
+	}
+
+	return r
+}
+
+func (c *ValidateConfig) Finalize() {
+	if c.JSON == nil {
+		c.JSON = config.Bool(false)
+	}
+
+	if c.JSONSchema == nil {
+		c.JSONSchema = config.String("")
+	}
+}
+
+// Enabled returns true if values are validated.
+func (c *ValidateConfig) Enabled() bool {
+	return config.BoolVal(c.JSON) || config.StringVal(c.JSONSchema) != ""
+}
+
+func (c *ValidateConfig) GoString() string {
+	if c == nil {
+		return "(*ValidateConfig)(nil)"
+	}
+
+	return fmt.Sprintf("&ValidateConfig{"+
+		"JSON:%s, "+
+		"JSONSchema:%s"+
+		"}",
+		config.BoolGoString(c.JSON),
+		config.StringGoString(c.JSONSchema),
+	)
+}
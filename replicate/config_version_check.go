@@ -0,0 +1,95 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: MPL-2.0
+
+package replicate
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/hashicorp/consul-template/config"
+)
+
+const (
+	// DefaultVersionCheckInterval is the default interval between checks of
+	// the versions of the clusters.
+	DefaultVersionCheckInterval = 1 * time.Hour
+)
+
+// VersionCheckConfig is the configuration of the detection of the versions and
+// features of the source and destination clusters.
+type VersionCheckConfig struct {
+	// Enabled checks the versions of the clusters at startup and every
+	// interval.
+	Enabled *bool `mapstructure:"enabled"`
+
+	// Interval is the time between checks. Zero only checks at startup.
+	Interval *time.Duration `mapstructure:"interval"`
+}
+
+func DefaultVersionCheckConfig() *VersionCheckConfig {
+	return &VersionCheckConfig{}
+}
+
+func (c *VersionCheckConfig) Copy() *VersionCheckConfig {
+	if c == nil {
+		return nil
+	}
+
+	var o VersionCheckConfig
+
+	o.Enabled = c.Enabled
+
+	o.Interval = c.Interval
+
+	return &o
+}
+
+func (c *VersionCheckConfig) Merge(o *VersionCheckConfig) *VersionCheckConfig {
+	if c == nil {
+		if o == nil {
+			return nil
+		}
+		return o.Copy()
+	}
+
+	if o == nil {
+		return c.Copy()
+	}
+
+	r := c.Copy()
+
+	if o.Enabled != nil {
+		r.Enabled = o.Enabled
+	}
+
+	if o.Interval != nil {
+		r.Interval = o.Interval
+	}
+
+	return r
+}
+
+func (c *VersionCheckConfig) Finalize() {
+	if c.Enabled == nil {
+		c.Enabled = config.Bool(true)
+	}
+
+	if c.Interval == nil {
+		c.Interval = config.TimeDuration(DefaultVersionCheckInterval)
+	}
+}
+
+func (c *VersionCheckConfig) GoString() string {
+	if c == nil {
+		return "(*VersionCheckConfig)(nil)"
+	}
+
+	return fmt.Sprintf("&VersionCheckConfig{"+
+		"Enabled:%s, "+
+		"Interval:%s"+
+		"}",
+		config.BoolGoString(c.Enabled),
+		config.TimeDurationGoString(c.Interval),
+	)
+}
@@ -0,0 +1,115 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: MPL-2.0
+
+package replicate
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/hashicorp/consul-template/config"
+)
+
+const (
+	// DefaultWaitForClustersInterval is the default delay between checks of
+	// the clusters.
+	DefaultWaitForClustersInterval = 5 * time.Second
+
+	// DefaultWaitForClustersTimeout is the default maximum amount of time the
+	// first pass waits for the clusters.
+	DefaultWaitForClustersTimeout = 5 * time.Minute
+)
+
+// WaitForClustersConfig holds back the first replication pass until the
+// source and destination clusters are healthy.
+type WaitForClustersConfig struct {
+	// Enabled waits for the clusters at startup. Specifying any other option
+	// also enables it.
+	Enabled *bool `mapstructure:"enabled"`
+
+	// Interval is the delay between checks of the clusters.
+	Interval *time.Duration `mapstructure:"interval"`
+
+	// Timeout is the maximum amount of time to wait, after which the runner
+	// fails.
+	Timeout *time.Duration `mapstructure:"timeout"`
+}
+
+func DefaultWaitForClustersConfig() *WaitForClustersConfig {
+	return &WaitForClustersConfig{}
+}
+
+func (c *WaitForClustersConfig) Copy() *WaitForClustersConfig {
+	if c == nil {
+		return nil
+	}
+
+	var o WaitForClustersConfig
+
+	o.Enabled = c.Enabled
+
+	o.Interval = c.Interval
+
+	o.Timeout = c.Timeout
+
+	return &o
+}
+
+func (c *WaitForClustersConfig) Merge(o *WaitForClustersConfig) *WaitForClustersConfig {
+	if c == nil {
+		if o == nil {
+			return nil
+		}
+		return o.Copy()
+	}
+
+	if o == nil {
+		return c.Copy()
+	}
+
+	r := c.Copy()
+
+	if o.Enabled != nil {
+		r.Enabled = o.Enabled
+	}
+
+	if o.Interval != nil {
+		r.Interval = o.Interval
+	}
+
+	if o.Timeout != nil {
+		r.Timeout = o.Timeout
+	}
+
+	return r
+}
+
+func (c *WaitForClustersConfig) Finalize() {
+	if c.Enabled == nil {
+		c.Enabled = config.Bool(c.Interval != nil || c.Timeout != nil)
+	}
+
+	if c.Interval == nil {
+		c.Interval = config.TimeDuration(DefaultWaitForClustersInterval)
+	}
+
+	if c.Timeout == nil {
+		c.Timeout = config.TimeDuration(DefaultWaitForClustersTimeout)
+	}
+}
+
+func (c *WaitForClustersConfig) GoString() string {
+	if c == nil {
+		return "(*WaitForClustersConfig)(nil)"
+	}
+
+	return fmt.Sprintf("&WaitForClustersConfig{"+
+		"Enabled:%s, "+
+		"Interval:%s, "+
+		"Timeout:%s"+
+		"}",
+		config.BoolGoString(c.Enabled),
+		config.TimeDurationGoString(c.Interval),
+		config.TimeDurationGoString(c.Timeout),
+	)
+}
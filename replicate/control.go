@@ -0,0 +1,332 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: MPL-2.0
+
+package replicate
+
+import (
+	"context"
+	"crypto/tls"
+	"fmt"
+	"log"
+	"net"
+
+	"github.com/hashicorp/consul-replicate/control"
+	"github.com/hashicorp/consul-template/config"
+	rootcerts "github.com/hashicorp/go-rootcerts"
+	"github.com/pkg/errors"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/status"
+)
+
+// ControlServer serves the gRPC control API, through which a central
+// controller manages the replicators of a supervisor.
+type ControlServer struct {
+	control.UnimplementedControlServer
+
+	supervisor *Supervisor
+	server     *grpc.Server
+	listener   net.Listener
+}
+
+// NewControlServer creates a control server for the supervisor and starts
+// listening on the configured address.
+func NewControlServer(c *ControlConfig, s *Supervisor) (*ControlServer, error) {
+	return NewControlServerWithListener(c, s, nil)
+}
+
+// NewControlServerWithListener creates a control server for the supervisor
+// which serves the given listener, such as one handed over by the process this
+// one replaced. Without a listener, it listens on the configured address.
+func NewControlServerWithListener(c *ControlConfig, s *Supervisor, listener net.Listener) (*ControlServer, error) {
+	address := config.StringVal(c.Address)
+	if listener != nil {
+		address = listener.Addr().String()
+	}
+	if err := checkControlAuth(c, address); err != nil {
+		return nil, err
+	}
+
+	var opts []grpc.ServerOption
+	if config.BoolVal(c.SSL.Enabled) {
+		tlsConfig, err := controlTLSConfig(c.SSL)
+		if err != nil {
+			return nil, errors.Wrap(err, "control")
+		}
+		opts = append(opts, grpc.Creds(credentials.NewTLS(tlsConfig)))
+	}
+
+	if listener == nil {
+		var err error
+		if listener, err = net.Listen("tcp", config.StringVal(c.Address)); err != nil {
+			return nil, errors.Wrap(err, "control")
+		}
+	}
+
+	cs := &ControlServer{
+		supervisor: s,
+		server:     grpc.NewServer(opts...),
+		listener:   listener,
+	}
+	control.RegisterControlServer(cs.server, cs)
+	return cs, nil
+}
+
+// checkControlAuth returns an error unless the clients of the API at the
+// address must present a certificate, or the API is explicitly insecure and
+// only reachable from the host. Every client of the API may replace prefixes
+// and trigger resyncs.
+func checkControlAuth(c *ControlConfig, address string) error {
+	if config.BoolVal(c.SSL.Enabled) && config.BoolVal(c.SSL.Verify) {
+		return nil
+	}
+	if !config.BoolVal(c.Insecure) {
+		return fmt.Errorf("control: the control API requires ssl with verify, " +
+			"or insecure on a loopback address")
+	}
+
+	host, _, err := net.SplitHostPort(address)
+	if err != nil {
+		return errors.Wrap(err, "control")
+	}
+	if ip := net.ParseIP(host); host != "localhost" && (ip == nil || !ip.IsLoopback()) {
+		return fmt.Errorf("control: an insecure control API must listen on a "+
+			"loopback address, not %q", address)
+	}
+	log.Printf("[WARN] (control) control API does not authenticate its clients")
+	return nil
+}
+
+// controlTLSConfig returns the TLS configuration of the server. With verify,
+// clients must present a certificate signed by the configured CA.
+func controlTLSConfig(c *config.SSLConfig) (*tls.Config, error) {
+	cert, err := tls.LoadX509KeyPair(config.StringVal(c.Cert), config.StringVal(c.Key))
+	if err != nil {
+		return nil, errors.Wrap(err, "loading certificate")
+	}
+
+	tlsConfig := &tls.Config{
+		Certificates: []tls.Certificate{cert},
+		MinVersion:   tls.VersionTLS12,
+	}
+
+	if config.BoolVal(c.Verify) {
+		pool, err := rootcerts.LoadCACerts(&rootcerts.Config{
+			CAFile: config.StringVal(c.CaCert),
+			CAPath: config.StringVal(c.CaPath),
+		})
+		if err != nil {
+			return nil, errors.Wrap(err, "loading ca")
+		}
+		tlsConfig.ClientCAs = pool
+		tlsConfig.ClientAuth = tls.RequireAndVerifyClientCert
+	}
+	return tlsConfig, nil
+}
+
+// DialControl connects to the control API of a running instance, with the
+// address and TLS configuration of its control config. An unspecified host, as
+// in ":8600" or "0.0.0.0:8600", is reached on the loopback address.
+func DialControl(c *ControlConfig) (*grpc.ClientConn, error) {
+	if !config.BoolVal(c.Enabled) {
+		return nil, fmt.Errorf("control: the control API is not enabled")
+	}
+
+	address := config.StringVal(c.Address)
+	host, port, err := net.SplitHostPort(address)
+	if err != nil {
+		return nil, errors.Wrap(err, "control")
+	}
+	if ip := net.ParseIP(host); host == "" || (ip != nil && ip.IsUnspecified()) {
+		address = net.JoinHostPort("127.0.0.1", port)
+	}
+
+	creds := insecure.NewCredentials()
+	if config.BoolVal(c.SSL.Enabled) {
+		tlsConfig, err := newTLSConfig(c.SSL)
+		if err != nil {
+			return nil, errors.Wrap(err, "control")
+		}
+		creds = credentials.NewTLS(tlsConfig)
+	}
+
+	conn, err := grpc.Dial(address, grpc.WithTransportCredentials(creds))
+	if err != nil {
+		return nil, errors.Wrap(err, "control")
+	}
+	return conn, nil
+}
+
+// Addr returns the address the server listens on.
+func (cs *ControlServer) Addr() net.Addr {
+	return cs.listener.Addr()
+}
+
+// Listener returns the listener of the server.
+func (cs *ControlServer) Listener() net.Listener {
+	return cs.listener
+}
+
+// Start serves the API until the server is stopped.
+func (cs *ControlServer) Start() {
+	log.Printf("[INFO] (control) listening on %s", cs.listener.Addr())
+	if err := cs.server.Serve(cs.listener); err != nil {
+		log.Printf("[ERR] (control) %s", err)
+	}
+}
+
+// Stop stops the server, waiting for pending requests to finish.
+func (cs *ControlServer) Stop() {
+	cs.server.GracefulStop()
+}
+
+// SetPrefixes implements control.ControlServer.
+func (cs *ControlServer) SetPrefixes(ctx context.Context, req *control.SetPrefixesRequest) (*control.SetPrefixesResponse, error) {
+	prefixes := DefaultPrefixConfigs()
+	for _, s := range req.Prefixes {
+		prefix, err := ParsePrefixConfig(s)
+		if err != nil {
+			return nil, status.Error(codes.InvalidArgument, err.Error())
+		}
+		*prefixes = append(*prefixes, prefix)
+	}
+
+	if err := cs.supervisor.SetPrefixes(req.Replicator, prefixes); err != nil {
+		return nil, status.Error(codes.NotFound, err.Error())
+	}
+	return &control.SetPrefixesResponse{}, nil
+}
+
+// Status implements control.ControlServer.
+func (cs *ControlServer) Status(ctx context.Context, req *control.StatusRequest) (*control.StatusResponse, error) {
+	replicators, err := cs.supervisor.Status()
+	if err != nil {
+		return nil, status.Error(codes.Unavailable, err.Error())
+	}
+
+	resp := &control.StatusResponse{}
+	for _, r := range replicators {
+		rs := &control.ReplicatorStatus{
+			Name:           r.Name,
+			Labels:         r.Labels,
+			Paused:         r.Paused,
+			ConfigChecksum: r.ConfigChecksum,
+		}
+		for _, p := range r.Prefixes {
+			rs.Prefixes = append(rs.Prefixes, &control.PrefixStatus{
+				Source:              p.Source,
+				Datacenter:          p.Datacenter,
+				Destination:         p.Destination,
+				LastReplicated:      p.LastReplicated,
+				Failures:            p.Failures,
+				Healthy:             p.Healthy,
+				ConsecutiveFailures: uint32(p.ConsecutiveFailures),
+				LastError:           p.LastError,
+				BytesRead:           p.BytesRead,
+				BytesWritten:        p.BytesWritten,
+				InitialSyncComplete: p.InitialSyncComplete,
+				CredentialsInvalid:  p.CredentialsInvalid,
+			})
+		}
+		resp.Replicators = append(resp.Replicators, rs)
+	}
+	return resp, nil
+}
+
+// Stats implements control.ControlServer.
+func (cs *ControlServer) Stats(ctx context.Context, req *control.StatsRequest) (*control.StatsResponse, error) {
+	resp := &control.StatsResponse{}
+	for _, r := range cs.supervisor.Stats() {
+		rs := &control.ReplicatorStats{
+			Name:   r.Name,
+			Labels: r.Labels,
+		}
+		for _, p := range r.Prefixes {
+			ps := &control.PrefixStats{
+				Source:      p.Source,
+				Datacenter:  p.Datacenter,
+				Destination: p.Destination,
+			}
+			for _, pass := range p.Passes {
+				ps.Passes = append(ps.Passes, &control.PassStats{
+					TimeMs:     pass.Time.UnixMilli(),
+					DurationMs: pass.Duration.Milliseconds(),
+					Index:      pass.Index,
+					Scanned:    uint32(pass.Scanned),
+					Puts:       uint32(pass.Updates),
+					Deletes:    uint32(pass.Deletes),
+					Skipped:    uint32(pass.Skipped),
+					Failed:     uint32(pass.Failed),
+					Coalesced:  uint32(pass.Coalesced),
+					Error:      pass.Error,
+				})
+			}
+			rs.Prefixes = append(rs.Prefixes, ps)
+		}
+		resp.Replicators = append(resp.Replicators, rs)
+	}
+	return resp, nil
+}
+
+// Cost implements control.ControlServer.
+func (cs *ControlServer) Cost(ctx context.Context, req *control.CostRequest) (*control.CostResponse, error) {
+	resp := &control.CostResponse{}
+	for _, r := range cs.supervisor.Cost() {
+		rc := &control.ReplicatorCost{
+			Name:   r.Name,
+			Labels: r.Labels,
+		}
+		for _, p := range r.Prefixes {
+			rc.Prefixes = append(rc.Prefixes, &control.PrefixCost{
+				Source:          p.Source,
+				Datacenter:      p.Datacenter,
+				Destination:     p.Destination,
+				ElapsedMs:       p.Elapsed.Milliseconds(),
+				BlockingQueries: p.BlockingQueries,
+				IndexChanges:    p.IndexChanges,
+				BytesRead:       p.BytesRead,
+				BytesWritten:    p.BytesWritten,
+				WriteOps:        p.WriteOps,
+			})
+		}
+		resp.Replicators = append(resp.Replicators, rc)
+	}
+	return resp, nil
+}
+
+// Resync implements control.ControlServer.
+func (cs *ControlServer) Resync(ctx context.Context, req *control.ResyncRequest) (*control.ResyncResponse, error) {
+	if err := cs.supervisor.Resync(req.Replicator); err != nil {
+		return nil, status.Error(codes.FailedPrecondition, err.Error())
+	}
+	return &control.ResyncResponse{}, nil
+}
+
+// RotateTokens implements control.ControlServer.
+func (cs *ControlServer) RotateTokens(ctx context.Context, req *control.RotateTokensRequest) (*control.RotateTokensResponse, error) {
+	if err := cs.supervisor.RotateTokens(); err != nil {
+		return nil, status.Error(codes.FailedPrecondition, err.Error())
+	}
+	return &control.RotateTokensResponse{}, nil
+}
+
+// Ready implements control.ControlServer.
+func (cs *ControlServer) Ready(ctx context.Context, req *control.ReadyRequest) (*control.ReadyResponse, error) {
+	pending, err := cs.supervisor.Pending()
+	if err != nil {
+		return nil, status.Error(codes.Unavailable, err.Error())
+	}
+
+	resp := &control.ReadyResponse{Ready: len(pending) == 0}
+	for _, p := range pending {
+		resp.Pending = append(resp.Pending, &control.PendingPrefix{
+			Replicator:  p.Replicator,
+			Source:      p.Source,
+			Datacenter:  p.Datacenter,
+			Destination: p.Destination,
+		})
+	}
+	return resp, nil
+}
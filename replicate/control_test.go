@@ -0,0 +1,313 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: MPL-2.0
+
+package replicate
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"fmt"
+	"math/big"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/hashicorp/consul-replicate/control"
+	"github.com/hashicorp/consul-template/config"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/status"
+)
+
+func TestControlServer(t *testing.T) {
+	c := DefaultConfig().Merge(&Config{
+		Control: &ControlConfig{
+			Address:  config.String("127.0.0.1:0"),
+			Insecure: config.Bool(true),
+		},
+	})
+	c.Finalize()
+
+	cs, err := NewControlServer(c.Control, NewSupervisor(c, false))
+	if err != nil {
+		t.Fatal(err)
+	}
+	go cs.Start()
+	defer cs.Stop()
+
+	conn, err := grpc.Dial(cs.Addr().String(),
+		grpc.WithTransportCredentials(insecure.NewCredentials()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	client := control.NewControlClient(conn)
+
+	cases := []struct {
+		name string
+		call func() error
+		exp  codes.Code
+	}{
+		{
+			"status",
+			func() error {
+				_, err := client.Status(context.Background(), &control.StatusRequest{})
+				return err
+			},
+			codes.OK,
+		},
+		{
+			"stats",
+			func() error {
+				_, err := client.Stats(context.Background(), &control.StatsRequest{})
+				return err
+			},
+			codes.OK,
+		},
+		{
+			"cost",
+			func() error {
+				_, err := client.Cost(context.Background(), &control.CostRequest{})
+				return err
+			},
+			codes.OK,
+		},
+		{
+			"ready",
+			func() error {
+				_, err := client.Ready(context.Background(), &control.ReadyRequest{})
+				return err
+			},
+			codes.OK,
+		},
+		{
+			"set_prefixes_invalid",
+			func() error {
+				_, err := client.SetPrefixes(context.Background(), &control.SetPrefixesRequest{
+					Prefixes: []string{""},
+				})
+				return err
+			},
+			codes.InvalidArgument,
+		},
+		{
+			"set_prefixes_unknown_replicator",
+			func() error {
+				_, err := client.SetPrefixes(context.Background(), &control.SetPrefixesRequest{
+					Replicator: "nope",
+					Prefixes:   []string{"global@dc1"},
+				})
+				return err
+			},
+			codes.NotFound,
+		},
+		{
+			"resync_unknown_replicator",
+			func() error {
+				_, err := client.Resync(context.Background(), &control.ResyncRequest{
+					Replicator: "nope",
+				})
+				return err
+			},
+			codes.FailedPrecondition,
+		},
+		{
+			"rotate_tokens",
+			func() error {
+				_, err := client.RotateTokens(context.Background(), &control.RotateTokensRequest{})
+				return err
+			},
+			codes.OK,
+		},
+	}
+
+	for i, tc := range cases {
+		t.Run(fmt.Sprintf("%d_%s", i, tc.name), func(t *testing.T) {
+			if act := status.Code(tc.call()); act != tc.exp {
+				t.Errorf("\nexp: %#v\nact: %#v", tc.exp, act)
+			}
+		})
+	}
+}
+
+func TestNewControlServer_auth(t *testing.T) {
+	cases := []struct {
+		name string
+		c    *ControlConfig
+		err  bool
+	}{
+		{
+			"no_tls",
+			&ControlConfig{
+				Address: config.String("127.0.0.1:0"),
+			},
+			true,
+		},
+		{
+			"no_verify",
+			&ControlConfig{
+				Address: config.String("127.0.0.1:0"),
+				SSL: &config.SSLConfig{
+					Enabled: config.Bool(true),
+					Verify:  config.Bool(false),
+				},
+			},
+			true,
+		},
+		{
+			"insecure_loopback",
+			&ControlConfig{
+				Address:  config.String("127.0.0.1:0"),
+				Insecure: config.Bool(true),
+			},
+			false,
+		},
+		{
+			"insecure_unspecified",
+			&ControlConfig{
+				Address:  config.String(":0"),
+				Insecure: config.Bool(true),
+			},
+			true,
+		},
+	}
+
+	for i, tc := range cases {
+		t.Run(fmt.Sprintf("%d_%s", i, tc.name), func(t *testing.T) {
+			c := DefaultConfig().Merge(&Config{Control: tc.c})
+			c.Finalize()
+
+			cs, err := NewControlServer(c.Control, NewSupervisor(c, false))
+			if (err != nil) != tc.err {
+				t.Fatal(err)
+			}
+			if cs != nil {
+				cs.Stop()
+			}
+		})
+	}
+}
+
+func TestControlServer_mTLS(t *testing.T) {
+	dir := t.TempDir()
+	certFile := filepath.Join(dir, "server.crt")
+	keyFile := filepath.Join(dir, "server.key")
+	writeServerCert(t, certFile, keyFile)
+
+	c := DefaultConfig().Merge(&Config{
+		Control: &ControlConfig{
+			Address: config.String("127.0.0.1:0"),
+			SSL: &config.SSLConfig{
+				Enabled: config.Bool(true),
+				Cert:    config.String(certFile),
+				Key:     config.String(keyFile),
+				CaCert:  config.String(certFile),
+			},
+		},
+	})
+	c.Finalize()
+
+	cs, err := NewControlServer(c.Control, NewSupervisor(c, false))
+	if err != nil {
+		t.Fatal(err)
+	}
+	go cs.Start()
+	defer cs.Stop()
+
+	ca, err := os.ReadFile(certFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pool := x509.NewCertPool()
+	pool.AppendCertsFromPEM(ca)
+	cert, err := tls.LoadX509KeyPair(certFile, keyFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cases := []struct {
+		name  string
+		certs []tls.Certificate
+		exp   codes.Code
+	}{
+		{
+			"client_certificate",
+			[]tls.Certificate{cert},
+			codes.OK,
+		},
+		{
+			"no_client_certificate",
+			nil,
+			codes.Unavailable,
+		},
+	}
+
+	for i, tc := range cases {
+		t.Run(fmt.Sprintf("%d_%s", i, tc.name), func(t *testing.T) {
+			creds := credentials.NewTLS(&tls.Config{
+				RootCAs:      pool,
+				Certificates: tc.certs,
+			})
+			conn, err := grpc.Dial(cs.Addr().String(), grpc.WithTransportCredentials(creds))
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer conn.Close()
+
+			_, err = control.NewControlClient(conn).Status(context.Background(), &control.StatusRequest{})
+			if act := status.Code(err); act != tc.exp {
+				t.Errorf("\nexp: %#v\nact: %#v", tc.exp, act)
+			}
+		})
+	}
+}
+
+// writeServerCert writes a self-signed certificate for the loopback address,
+// which is also its own CA, and its key, into the files.
+func writeServerCert(t *testing.T, certFile, keyFile string) {
+	t.Helper()
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "consul-replicate"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		IPAddresses:           []net.IP{net.ParseIP("127.0.0.1")},
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage: []x509.ExtKeyUsage{
+			x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := os.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{
+		Type: "CERTIFICATE", Bytes: der}), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{
+		Type: "EC PRIVATE KEY", Bytes: keyDER}), 0o600); err != nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,84 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: MPL-2.0
+
+package replicate
+
+import (
+	"sync/atomic"
+	"time"
+
+	"github.com/hashicorp/consul-template/config"
+)
+
+// PrefixCost is the load a prefix put on the Consul servers since it started
+// replicating, so it can be attributed to the replication flow.
+type PrefixCost struct {
+	Source, Datacenter, Destination string
+
+	// Elapsed is how long the requests of the prefix were counted for.
+	Elapsed time.Duration
+
+	// BlockingQueries is the number of blocking queries of the watch of the
+	// prefix, and IndexChanges the number of times they returned a new index.
+	// Like BytesRead, they are shared by the prefixes of a coalesced watch.
+	BlockingQueries uint64
+	IndexChanges    uint64
+
+	// BytesRead is the number of bytes read from the source by the watch of
+	// the prefix, and BytesWritten the number of bytes written into the
+	// destination.
+	BytesRead    uint64
+	BytesWritten uint64
+
+	// WriteOps is the number of write and delete requests of keys and
+	// transactions sent to the destination.
+	WriteOps uint64
+}
+
+// Cost returns the load every active prefix put on the Consul servers since
+// it started replicating.
+func (r *Runner) Cost() []*PrefixCost {
+	now := time.Now()
+
+	var result []*PrefixCost
+	for _, prefix := range r.activePrefixes() {
+		id := prefix.Dependency.String()
+		r.RLock()
+		watchID := id
+		if d, ok := r.watches[id]; ok {
+			watchID = d.String()
+		}
+		r.RUnlock()
+
+		w, p := r.meter(watchID), r.meter(id)
+		since := p.since
+		if w.since.Before(since) {
+			since = w.since
+		}
+
+		result = append(result, &PrefixCost{
+			Source:          config.StringVal(prefix.Source),
+			Datacenter:      config.StringVal(prefix.Datacenter),
+			Destination:     config.StringVal(prefix.Destination),
+			Elapsed:         now.Sub(since),
+			BlockingQueries: atomic.LoadUint64(&w.queries),
+			IndexChanges:    atomic.LoadUint64(&w.indexChanges),
+			BytesRead:       atomic.LoadUint64(&w.read),
+			BytesWritten:    atomic.LoadUint64(&p.written),
+			WriteOps:        atomic.LoadUint64(&p.writeOps),
+		})
+	}
+	return result
+}
+
+// query counts a blocking query which returned the given index. The first
+// index returned is not a change.
+func (m *meter) query(index uint64) {
+	atomic.AddUint64(&m.queries, 1)
+	if index == 0 {
+		return
+	}
+	if last := atomic.SwapUint64(&m.lastIndex, index); last != 0 && last != index {
+		atomic.AddUint64(&m.indexChanges, 1)
+	}
+}
@@ -0,0 +1,58 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: MPL-2.0
+
+package replicate
+
+import (
+	"fmt"
+	"testing"
+)
+
+func TestMeter_query(t *testing.T) {
+	cases := []struct {
+		name    string
+		indexes []uint64
+		queries uint64
+		changes uint64
+	}{
+		{
+			"first",
+			[]uint64{10},
+			1,
+			0,
+		},
+		{
+			"unchanged",
+			[]uint64{10, 10, 10},
+			3,
+			0,
+		},
+		{
+			"changed",
+			[]uint64{10, 12, 12, 15},
+			4,
+			2,
+		},
+		{
+			"failed",
+			[]uint64{10, 0, 12},
+			3,
+			1,
+		},
+	}
+
+	for i, tc := range cases {
+		t.Run(fmt.Sprintf("%d_%s", i, tc.name), func(t *testing.T) {
+			m := &meter{}
+			for _, index := range tc.indexes {
+				m.query(index)
+			}
+			if m.queries != tc.queries {
+				t.Errorf("\nexp: %#v\nact: %#v", tc.queries, m.queries)
+			}
+			if m.indexChanges != tc.changes {
+				t.Errorf("\nexp: %#v\nact: %#v", tc.changes, m.indexChanges)
+			}
+		})
+	}
+}
@@ -0,0 +1,249 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: MPL-2.0
+
+package replicate
+
+import (
+	"encoding/json"
+	"log"
+	"net"
+	"net/http"
+	"net/http/pprof"
+	"runtime"
+	"runtime/debug"
+	"sort"
+	"time"
+
+	"github.com/hashicorp/consul-template/config"
+	dep "github.com/hashicorp/consul-template/dependency"
+	"github.com/pkg/errors"
+)
+
+// RunnerState is a snapshot of the internal state of a runner, for debugging.
+type RunnerState struct {
+	Replicator string
+	Leader     bool
+	Prefixes   []string
+
+	// Watches are the blocking queries of the runner and the data they hold.
+	Watches []*WatchState
+
+	// Failing is the number of consecutive failures of the failing prefixes.
+	Failing map[string]int `json:",omitempty"`
+
+	// Stuck are the prefixes whose pass timed out and is still running.
+	Stuck []string `json:",omitempty"`
+}
+
+// WatchState is the state of a blocking query of a runner.
+type WatchState struct {
+	Dependency string
+	Received   bool
+	Pairs      int
+	Bytes      int
+	LastIndex  uint64
+}
+
+// State returns a snapshot of the internal state of the runner.
+func (r *Runner) State() *RunnerState {
+	state := &RunnerState{
+		Replicator: r.replicator,
+		Leader:     r.isLeader(),
+	}
+	for _, prefix := range r.activePrefixes() {
+		state.Prefixes = append(state.Prefixes, prefix.Dependency.String())
+	}
+
+	r.RLock()
+	seen := make(map[string]struct{}, len(r.watches))
+	for _, d := range r.watches {
+		id := d.String()
+		if _, ok := seen[id]; ok {
+			continue
+		}
+		seen[id] = struct{}{}
+
+		w := &WatchState{Dependency: id}
+		if view, ok := r.data[id]; ok {
+			data, lastIndex := view.DataAndLastIndex()
+			pairs, _ := data.([]*dep.KeyPair)
+			w.Received, w.Pairs, w.LastIndex = true, len(pairs), lastIndex
+			for _, pair := range pairs {
+				w.Bytes += len(pair.Path) + len(pair.Value)
+			}
+		}
+		state.Watches = append(state.Watches, w)
+	}
+	r.RUnlock()
+	sort.Slice(state.Watches, func(i, j int) bool {
+		return state.Watches[i].Dependency < state.Watches[j].Dependency
+	})
+
+	r.healthLock.Lock()
+	for id, h := range r.health {
+		if h.failures > 0 {
+			if state.Failing == nil {
+				state.Failing = make(map[string]int)
+			}
+			state.Failing[id] = h.failures
+		}
+	}
+	r.healthLock.Unlock()
+
+	r.stuckLock.Lock()
+	for id := range r.stuck {
+		state.Stuck = append(state.Stuck, id)
+	}
+	r.stuckLock.Unlock()
+	sort.Strings(state.Stuck)
+
+	return state
+}
+
+// State returns a snapshot of the internal state of the running runners,
+// sorted by replicator.
+func (s *Supervisor) State() []*RunnerState {
+	s.Lock()
+	runners := make([]*Runner, 0, len(s.groups))
+	for _, g := range s.groups {
+		if runner := g.currentRunner(); runner != nil {
+			runners = append(runners, runner)
+		}
+	}
+	s.Unlock()
+
+	states := make([]*RunnerState, 0, len(runners))
+	for _, runner := range runners {
+		states = append(states, runner.State())
+	}
+	sort.Slice(states, func(i, j int) bool {
+		return states[i].Replicator < states[j].Replicator
+	})
+	return states
+}
+
+// DumpState logs the internal state of the runners and of the memory.
+func (s *Supervisor) DumpState() {
+	enc, err := json.MarshalIndent(struct {
+		Runners []*RunnerState
+		Memory  *MemoryStats
+	}{s.State(), memoryStats()}, "", "  ")
+	if err != nil {
+		log.Printf("[ERR] (supervisor) failed to encode state: %s", err)
+		return
+	}
+	log.Printf("[INFO] (supervisor) state:\n\n%s\n\n", enc)
+}
+
+// MemoryStats are the statistics of the memory and garbage collector.
+type MemoryStats struct {
+	Goroutines  int
+	HeapAlloc   uint64
+	HeapInuse   uint64
+	HeapObjects uint64
+	Sys         uint64
+	NumGC       int64
+	LastGC      time.Time
+	PauseTotal  time.Duration
+}
+
+// memoryStats returns the current memory statistics.
+func memoryStats() *MemoryStats {
+	var m runtime.MemStats
+	runtime.ReadMemStats(&m)
+
+	var gc debug.GCStats
+	debug.ReadGCStats(&gc)
+
+	return &MemoryStats{
+		Goroutines:  runtime.NumGoroutine(),
+		HeapAlloc:   m.HeapAlloc,
+		HeapInuse:   m.HeapInuse,
+		HeapObjects: m.HeapObjects,
+		Sys:         m.Sys,
+		NumGC:       gc.NumGC,
+		LastGC:      gc.LastGC,
+		PauseTotal:  gc.PauseTotal,
+	}
+}
+
+// DebugServer serves the pprof profiles, goroutine dumps, GC statistics and
+// internal state of the runners of a supervisor.
+type DebugServer struct {
+	server   *http.Server
+	listener net.Listener
+}
+
+// NewDebugServer creates a debug server for the supervisor and starts
+// listening on the configured address.
+func NewDebugServer(c *DebugConfig, s *Supervisor) (*DebugServer, error) {
+	return NewDebugServerWithListener(c, s, nil)
+}
+
+// NewDebugServerWithListener creates a debug server for the supervisor which
+// serves the given listener, such as one handed over by the process this one
+// replaced. Without a listener, it listens on the configured address.
+func NewDebugServerWithListener(c *DebugConfig, s *Supervisor, listener net.Listener) (*DebugServer, error) {
+	if listener == nil {
+		var err error
+		if listener, err = net.Listen("tcp", config.StringVal(c.Address)); err != nil {
+			return nil, errors.Wrap(err, "debug")
+		}
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/debug/pprof/", pprof.Index)
+	mux.HandleFunc("/debug/pprof/cmdline", pprof.Cmdline)
+	mux.HandleFunc("/debug/pprof/profile", pprof.Profile)
+	mux.HandleFunc("/debug/pprof/symbol", pprof.Symbol)
+	mux.HandleFunc("/debug/pprof/trace", pprof.Trace)
+	mux.HandleFunc("/debug/goroutines", func(w http.ResponseWriter, req *http.Request) {
+		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		w.Write(stacks())
+	})
+	mux.HandleFunc("/debug/gc", func(w http.ResponseWriter, req *http.Request) {
+		writeDebugJSON(w, memoryStats())
+	})
+	mux.HandleFunc("/debug/state", func(w http.ResponseWriter, req *http.Request) {
+		writeDebugJSON(w, s.State())
+	})
+
+	return &DebugServer{
+		server:   &http.Server{Handler: mux},
+		listener: listener,
+	}, nil
+}
+
+// writeDebugJSON writes the value as indented JSON.
+func writeDebugJSON(w http.ResponseWriter, v interface{}) {
+	enc, err := json.MarshalIndent(v, "", "  ")
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(enc)
+}
+
+// Addr returns the address the server listens on.
+func (ds *DebugServer) Addr() net.Addr {
+	return ds.listener.Addr()
+}
+
+// Listener returns the listener of the server.
+func (ds *DebugServer) Listener() net.Listener {
+	return ds.listener
+}
+
+// Start serves the endpoints until the server is stopped.
+func (ds *DebugServer) Start() {
+	log.Printf("[WARN] (debug) debug endpoints listening on %s", ds.listener.Addr())
+	if err := ds.server.Serve(ds.listener); err != nil && err != http.ErrServerClosed {
+		log.Printf("[ERR] (debug) %s", err)
+	}
+}
+
+// Stop stops the server.
+func (ds *DebugServer) Stop() {
+	ds.server.Close()
+}
@@ -0,0 +1,75 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: MPL-2.0
+
+package replicate
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/hashicorp/consul-template/config"
+)
+
+func TestDebugServer(t *testing.T) {
+	c := DefaultConfig().Merge(&Config{
+		Debug: &DebugConfig{
+			Address: config.String("127.0.0.1:0"),
+		},
+	})
+	c.Finalize()
+
+	ds, err := NewDebugServer(c.Debug, NewSupervisor(c, false))
+	if err != nil {
+		t.Fatal(err)
+	}
+	go ds.Start()
+	defer ds.Stop()
+
+	cases := []struct {
+		name string
+		path string
+		exp  string
+	}{
+		{
+			"pprof",
+			"/debug/pprof/",
+			"goroutine",
+		},
+		{
+			"goroutines",
+			"/debug/goroutines",
+			"goroutine ",
+		},
+		{
+			"gc",
+			"/debug/gc",
+			`"NumGC"`,
+		},
+		{
+			"state",
+			"/debug/state",
+			"[]",
+		},
+	}
+
+	for i, tc := range cases {
+		t.Run(fmt.Sprintf("%d_%s", i, tc.name), func(t *testing.T) {
+			resp, err := http.Get("http://" + ds.Addr().String() + tc.path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer resp.Body.Close()
+
+			body, err := io.ReadAll(resp.Body)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if resp.StatusCode != http.StatusOK || !strings.Contains(string(body), tc.exp) {
+				t.Errorf("\nexp: %#v\nact: %d %s", tc.exp, resp.StatusCode, body)
+			}
+		})
+	}
+}
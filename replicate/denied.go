@@ -0,0 +1,167 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: MPL-2.0
+
+package replicate
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/hashicorp/consul-template/config"
+)
+
+// deniedState is the credentials invalid state of a runner, which it is in
+// while the destination denies its ACL token.
+type deniedState struct {
+	sync.Mutex
+
+	// passes is the number of consecutive denied passes, and err the last
+	// denial.
+	passes int
+	err    error
+
+	// retryAt is when the prefixes are replicated again.
+	retryAt time.Time
+}
+
+// deniedEnabled returns true if the runner enters the credentials invalid
+// state when the destination denies its token. Single passes always stop at
+// the first failure, so it is reported.
+func (r *Runner) deniedEnabled() bool {
+	return config.BoolVal(r.config.Denied.Enabled) && !r.once && r.watched()
+}
+
+// deniedError returns true if the destination denied the token of the runner
+// itself, rather than the write of a single key, such as a token which was
+// deleted or expired, or a token whose policies no longer grant any write.
+func deniedError(err error) bool {
+	var passErr *deniedPassError
+	if errors.As(err, &passErr) {
+		return true
+	}
+	return responseCode(err) == 403 && !keyError(err)
+}
+
+// deniedPassError is the error of a pass whose every new write was denied,
+// such as after the policy of the token was revoked. Each of them is a key
+// error on its own, but together they deny the token like an unknown one.
+type deniedPassError struct {
+	writes int
+	key    string
+	reason string
+}
+
+func (e *deniedPassError) Error() string {
+	return fmt.Sprintf("the destination denied all %d write(s) of the pass, "+
+		"such as of %q: %s", e.writes, e.key, e.reason)
+}
+
+// passDenied returns a deniedPassError if the pass wrote nothing and every key
+// which failed was denied with a 403. The keys which already failed in the
+// previous pass are not counted, so a single key the token may not write does
+// not deny the token once the rest of the prefix is replicated.
+func passDenied(failures, previous map[string]string, writes int) error {
+	if writes > 0 {
+		return nil
+	}
+
+	var keys []string
+	for key, reason := range failures {
+		if _, ok := previous[key]; ok {
+			continue
+		}
+		if responseCode(errors.New(reason)) != 403 {
+			return nil
+		}
+		keys = append(keys, key)
+	}
+	if len(keys) == 0 {
+		return nil
+	}
+	sort.Strings(keys)
+	return &deniedPassError{writes: len(keys), key: keys[0], reason: failures[keys[0]]}
+}
+
+// deny enters the credentials invalid state after a denied pass, or stays in
+// it with a longer backoff. The prefixes which were denied in the same pass
+// are only counted once.
+func (r *Runner) deny(err error) {
+	s := r.denied
+	s.Lock()
+	defer s.Unlock()
+
+	s.err = err
+	if time.Now().Before(s.retryAt) {
+		return
+	}
+	s.passes++
+
+	delay, max := config.TimeDurationVal(r.config.Denied.Backoff),
+		config.TimeDurationVal(r.config.Denied.MaxBackoff)
+	for i := 1; i < s.passes && delay < max; i++ {
+		delay *= 2
+	}
+	if delay > max {
+		delay = max
+	}
+	s.retryAt = time.Now().Add(delay)
+
+	log.Printf("[ERR] (runner) the destination denied the token %d time(s) in a row, "+
+		"holding back every prefix for %s: %s", s.passes, delay, err)
+	r.retryAfter(delay)
+}
+
+// allow leaves the credentials invalid state once a pass was not denied.
+func (r *Runner) allow() {
+	s := r.denied
+	s.Lock()
+	defer s.Unlock()
+
+	if s.passes == 0 {
+		return
+	}
+	log.Printf("[INFO] (runner) the destination accepts the token again after %d "+
+		"denied pass(es)", s.passes)
+	s.passes, s.err, s.retryAt = 0, nil, time.Time{}
+}
+
+// retryDenied retries the prefixes right away if the runner is in the
+// credentials invalid state, such as after the token was rotated.
+func (r *Runner) retryDenied() {
+	s := r.denied
+	s.Lock()
+	defer s.Unlock()
+
+	if s.passes == 0 {
+		return
+	}
+	s.retryAt = time.Time{}
+	r.retryAfter(0)
+}
+
+// deniedOf returns the last denial if the runner is in the credentials invalid
+// state, or nil.
+func (r *Runner) deniedOf() error {
+	s := r.denied
+	s.Lock()
+	defer s.Unlock()
+
+	if s.passes == 0 {
+		return nil
+	}
+	return s.err
+}
+
+// holdingBack returns true if the runner is in the credentials invalid state
+// and the prefixes are not due to be retried.
+func (r *Runner) holdingBack() bool {
+	s := r.denied
+	s.Lock()
+	defer s.Unlock()
+
+	return s.passes > 0 && time.Now().Before(s.retryAt)
+}
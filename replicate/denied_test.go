@@ -0,0 +1,129 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: MPL-2.0
+
+package replicate
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+)
+
+func TestDeniedError(t *testing.T) {
+	cases := []struct {
+		name string
+		err  error
+		exp  bool
+	}{
+		{"acl_not_found", errors.New("Unexpected response code: 403 (ACL not found)"), true},
+		{"permission_denied", errors.New("Unexpected response code: 403 (Permission denied)"), false},
+		{"permission_denied_pass", fmt.Errorf("pass: %w", &deniedPassError{writes: 2, key: "global/a",
+			reason: "Unexpected response code: 403 (Permission denied)"}), true},
+		{"server_error", errors.New("Unexpected response code: 500 (rpc error)"), false},
+		{"not_a_response", errors.New("connection refused"), false},
+	}
+
+	for i, tc := range cases {
+		t.Run(fmt.Sprintf("%d_%s", i, tc.name), func(t *testing.T) {
+			if act := deniedError(tc.err); act != tc.exp {
+				t.Errorf("\nexp: %#v\nact: %#v", tc.exp, act)
+			}
+		})
+	}
+}
+
+func TestPassDenied(t *testing.T) {
+	denied := "Unexpected response code: 403 (Permission denied)"
+	cases := []struct {
+		name     string
+		failures map[string]string
+		previous map[string]string
+		writes   int
+		exp      bool
+	}{
+		{
+			"no_failures",
+			nil,
+			nil,
+			0,
+			false,
+		},
+		{
+			"every_write_denied",
+			map[string]string{"global/a": denied, "global/b": denied},
+			nil,
+			0,
+			true,
+		},
+		{
+			"some_writes",
+			map[string]string{"global/a": denied},
+			nil,
+			1,
+			false,
+		},
+		{
+			"other_failure",
+			map[string]string{"global/a": denied,
+				"global/b": "Unexpected response code: 413 (Value exceeds 524288 byte limit)"},
+			nil,
+			0,
+			false,
+		},
+		{
+			"retried_key",
+			map[string]string{"global/a": denied},
+			map[string]string{"global/a": denied},
+			0,
+			false,
+		},
+	}
+
+	for i, tc := range cases {
+		t.Run(fmt.Sprintf("%d_%s", i, tc.name), func(t *testing.T) {
+			err := passDenied(tc.failures, tc.previous, tc.writes)
+			if act := err != nil && deniedError(err); act != tc.exp {
+				t.Errorf("\nexp: %#v\nact: %#v", tc.exp, act)
+			}
+		})
+	}
+}
+
+func TestRunner_deny(t *testing.T) {
+	c := DefaultConfig()
+	c.Finalize()
+	r := &Runner{config: c, denied: &deniedState{}}
+	err := errors.New("Unexpected response code: 403 (ACL not found)")
+
+	r.deny(err)
+	if !r.holdingBack() || r.deniedOf() != err {
+		t.Fatal("expected the runner to hold back the prefixes")
+	}
+	first := r.denied.retryAt
+
+	// Prefixes denied in the same pass are counted once
+	r.deny(err)
+	if r.denied.passes != 1 || r.denied.retryAt != first {
+		t.Errorf("expected a single denied pass, got %d", r.denied.passes)
+	}
+
+	// The backoff doubles with every denied pass
+	r.denied.retryAt = time.Time{}
+	r.deny(err)
+	exp := 2 * DefaultDeniedBackoff
+	if act := time.Until(r.denied.retryAt).Round(time.Minute); act != exp {
+		t.Errorf("\nexp: %#v\nact: %#v", exp, act)
+	}
+
+	// A rotated token is retried right away
+	r.retryDenied()
+	if r.holdingBack() {
+		t.Error("expected the prefixes to be retried")
+	}
+
+	r.allow()
+	if r.holdingBack() || r.deniedOf() != nil {
+		t.Error("expected the runner to leave the credentials invalid state")
+	}
+}
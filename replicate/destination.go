@@ -0,0 +1,314 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: MPL-2.0
+
+package replicate
+
+import (
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/hashicorp/consul-template/config"
+	"github.com/hashicorp/consul/api"
+	"github.com/pkg/errors"
+)
+
+// parseService splits a service of the form "name@dc" into its name and
+// datacenter, which is empty for the datacenter of the agent.
+func parseService(s string) (string, string) {
+	if i := strings.LastIndex(s, "@"); i >= 0 {
+		return s[:i], s[i+1:]
+	}
+	return s, ""
+}
+
+// serviceAddresses returns the addresses of the healthy instances of the
+// service in the catalog of the client, sorted so every replicator tries them
+// in the same order.
+func serviceAddresses(client *api.Client, service string) ([]string, error) {
+	name, dc := parseService(service)
+	entries, _, err := client.Health().Service(name, "", true, &api.QueryOptions{
+		Datacenter: dc,
+	})
+	if err != nil {
+		return nil, errors.Wrapf(err, "resolving service %q", service)
+	}
+
+	addresses := make([]string, 0, len(entries))
+	for _, entry := range entries {
+		host := entry.Service.Address
+		if host == "" {
+			host = entry.Node.Address
+		}
+		addresses = append(addresses, net.JoinHostPort(host, strconv.Itoa(entry.Service.Port)))
+	}
+	if len(addresses) == 0 {
+		return nil, fmt.Errorf("service %q has no healthy instance", service)
+	}
+	sort.Strings(addresses)
+	return addresses, nil
+}
+
+// serverAddresses returns the HTTP addresses of the servers of the datacenter,
+// which are their Raft addresses with the given HTTP port, leader first so
+// writes sent to them are not forwarded again. The other servers are sorted so
+// every replicator tries them in the same order.
+func serverAddresses(client *api.Client, dc, port string) ([]string, error) {
+	q := &api.QueryOptions{Datacenter: dc}
+	peers, err := client.Status().PeersWithQueryOptions(q)
+	if err != nil {
+		return nil, errors.Wrapf(err, "resolving the servers of datacenter %q", dc)
+	}
+	leader, err := client.Status().LeaderWithQueryOptions(q)
+	if err != nil {
+		return nil, errors.Wrapf(err, "resolving the leader of datacenter %q", dc)
+	}
+
+	var first string
+	addresses := make([]string, 0, len(peers))
+	for _, peer := range peers {
+		host, _, err := net.SplitHostPort(peer)
+		if err != nil {
+			return nil, fmt.Errorf("invalid server address %q: %s", peer, err)
+		}
+		address := net.JoinHostPort(host, port)
+		if peer == leader {
+			first = address
+			continue
+		}
+		addresses = append(addresses, address)
+	}
+	sort.Strings(addresses)
+	if first != "" {
+		addresses = append([]string{first}, addresses...)
+	}
+	if len(addresses) == 0 {
+		return nil, fmt.Errorf("datacenter %q has no server", dc)
+	}
+	return addresses, nil
+}
+
+// isKVWrite returns true if the request writes or deletes keys.
+func isKVWrite(req *http.Request) bool {
+	if req.Method != http.MethodPut && req.Method != http.MethodDelete {
+		return false
+	}
+	return strings.HasPrefix(req.URL.Path, "/v1/kv/") || req.URL.Path == "/v1/txn"
+}
+
+// failoverTransport sends the requests of a client to an instance of a
+// service, and fails over to the next healthy instance when the current one
+// cannot be reached. The instances are resolved again on every failover, so
+// instances which come and go are picked up. With writes, only the writes of
+// keys are sent to the instances, and other requests to the client address.
+type failoverTransport struct {
+	base    http.RoundTripper
+	service string
+	resolve func() ([]string, error)
+	writes  bool
+
+	sync.Mutex
+	addresses []string
+	current   int
+}
+
+func (t *failoverTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if t.writes && !isKVWrite(req) {
+		return t.base.RoundTrip(req)
+	}
+
+	for attempt := 0; ; attempt++ {
+		address, count := t.address()
+
+		r := req.Clone(req.Context())
+		r.URL.Host = address
+		r.Host = ""
+		if attempt > 0 && req.Body != nil && req.Body != http.NoBody {
+			if req.GetBody == nil {
+				return nil, fmt.Errorf("cannot fail over %s %s: body cannot be replayed",
+					req.Method, req.URL.Path)
+			}
+			body, err := req.GetBody()
+			if err != nil {
+				return nil, err
+			}
+			r.Body = body
+		}
+
+		// Only failures to reach the instance fail over, an error response
+		// comes from a reachable cluster
+		resp, err := t.base.RoundTrip(r)
+		if err == nil || req.Context().Err() != nil || attempt+1 >= count {
+			return resp, err
+		}
+		t.failover(address, err)
+	}
+}
+
+// address returns the address of the current instance and the number of known
+// instances.
+func (t *failoverTransport) address() (string, int) {
+	t.Lock()
+	defer t.Unlock()
+	return t.addresses[t.current], len(t.addresses)
+}
+
+// failover moves on from the failed instance to the next healthy one, unless
+// another request already did.
+func (t *failoverTransport) failover(failed string, reason error) {
+	t.Lock()
+	defer t.Unlock()
+	if t.addresses[t.current] != failed {
+		return
+	}
+
+	if addresses, err := t.resolve(); err != nil {
+		log.Printf("[WARN] (runner) cannot resolve the instances of %q again, "+
+			"keeping the known ones: %s", t.service, err)
+	} else {
+		t.addresses = addresses
+	}
+
+	t.current = 0
+	for i, address := range t.addresses {
+		if address == failed {
+			t.current = (i + 1) % len(t.addresses)
+			break
+		}
+	}
+	log.Printf("[WARN] (runner) instance %q of %q cannot be reached, failing over "+
+		"to %q: %s", failed, t.service, t.addresses[t.current], reason)
+}
+
+// setFailover makes the client talk to the given instances of the service,
+// starting with the first one, which are resolved again with resolve whenever
+// an instance cannot be reached. With writes, only the writes of keys are sent
+// to the instances.
+func setFailover(hc *http.Client, service string, addresses []string, resolve func() ([]string, error), writes bool) {
+	if _, ok := hc.Transport.(*failoverTransport); ok {
+		return
+	}
+	base := hc.Transport
+	if base == nil {
+		base = http.DefaultTransport
+	}
+	hc.Transport = &failoverTransport{
+		base:      base,
+		service:   service,
+		resolve:   resolve,
+		writes:    writes,
+		addresses: addresses,
+	}
+}
+
+// newDestinationClientSet creates the client set of the destination. If a
+// destination service is configured, its healthy instances are resolved in
+// the catalog of the source, the first one is talked to, and requests fail
+// over to the next one when it cannot be reached.
+func (r *Runner) newDestinationClientSet(source *api.Client) (*clientSet, error) {
+	service := config.StringVal(r.config.Destination.Service)
+	if service == "" {
+		clients, err := newServerClientSet(r.config.DestinationConsul,
+			r.config.HTTP.Destination, r.config.Servers.Destination)
+		if err != nil {
+			return nil, err
+		}
+		if r.destinationCerts, err = r.initCerts(clients.httpClient,
+			r.config.DestinationConsul.SSL); err != nil {
+			return nil, err
+		}
+		if err := r.initServerWrites(clients); err != nil {
+			return nil, err
+		}
+		return clients, nil
+	}
+	if len(r.config.Servers.Destination) > 0 {
+		return nil, fmt.Errorf("destination service %q cannot be combined with "+
+			"destination servers", service)
+	}
+
+	resolve := func() ([]string, error) {
+		return serviceAddresses(source, service)
+	}
+	addresses, err := resolve()
+	if err != nil {
+		return nil, err
+	}
+	log.Printf("[INFO] (runner) destination service %q resolved to %q", service, addresses)
+
+	if writes := config.StringVal(r.config.Destination.Writes); writes != DestinationWritesAgent {
+		return nil, fmt.Errorf("destination service %q cannot be combined with "+
+			"destination writes %q", service, writes)
+	}
+
+	c := r.config.DestinationConsul.Copy()
+	c.Address = config.String(addresses[0])
+	clients, err := newClientSet(c, r.config.HTTP.Destination)
+	if err != nil {
+		return nil, err
+	}
+
+	// The certificates are rotated for every instance
+	if r.destinationCerts, err = r.initCerts(clients.httpClient,
+		r.config.DestinationConsul.SSL); err != nil {
+		return nil, err
+	}
+	setFailover(clients.httpClient, service, addresses, resolve, false)
+	return clients, nil
+}
+
+// initServerWrites makes the client send the writes of keys directly to the
+// servers of the destination datacenter, if the destination writes are sent to
+// the servers. The servers are resolved through the agent, and again whenever
+// the current one cannot be reached, so the new leader is found after an
+// election. Other requests keep going to the agent.
+func (r *Runner) initServerWrites(clients *clientSet) error {
+	switch writes := config.StringVal(r.config.Destination.Writes); writes {
+	case DestinationWritesAgent:
+		return nil
+	case DestinationWritesServers:
+	default:
+		return fmt.Errorf("destination writes must be %q or %q, got %q",
+			DestinationWritesAgent, DestinationWritesServers, writes)
+	}
+	if len(r.config.Servers.Destination) > 0 {
+		return fmt.Errorf("destination writes %q cannot be combined with "+
+			"destination servers, which already receive every request",
+			DestinationWritesServers)
+	}
+
+	address := config.StringVal(r.config.DestinationConsul.Address)
+	if i := strings.Index(address, "://"); i >= 0 {
+		if address[:i] == "unix" {
+			return fmt.Errorf("destination writes %q cannot be combined with the "+
+				"unix socket %q", DestinationWritesServers, address)
+		}
+		address = address[i+len("://"):]
+	}
+	port := "8500"
+	if _, p, err := net.SplitHostPort(address); err == nil && p != "" {
+		port = p
+	}
+
+	dc := config.StringVal(r.config.Destination.Datacenter)
+	resolve := func() ([]string, error) {
+		return serverAddresses(clients.Consul(), dc, port)
+	}
+	addresses, err := resolve()
+	if err != nil {
+		return err
+	}
+	// Servers are registered as the consul service
+	service := "consul"
+	if dc != "" {
+		service += "@" + dc
+	}
+	log.Printf("[INFO] (runner) sending destination writes to the servers %q", addresses)
+	setFailover(clients.httpClient, service, addresses, resolve, true)
+	return nil
+}
@@ -0,0 +1,212 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: MPL-2.0
+
+package replicate
+
+import (
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"reflect"
+	"testing"
+
+	"github.com/hashicorp/consul-template/config"
+	"github.com/hashicorp/consul/api"
+)
+
+func TestServiceAddresses(t *testing.T) {
+	var query url.Values
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		query = r.URL.Query()
+		fmt.Fprint(w, `[
+			{"Node":{"Address":"10.0.0.2"},"Service":{"Address":"","Port":8500}},
+			{"Node":{"Address":"10.0.0.3"},"Service":{"Address":"10.0.1.1","Port":8501}}
+		]`)
+	}))
+	defer srv.Close()
+
+	c := config.DefaultConsulConfig()
+	c.Address = config.String(srv.URL)
+	c.Finalize()
+	clients, err := newClientSet(c, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	act, err := serviceAddresses(clients.Consul(), "consul-dest@dc2")
+	if err != nil {
+		t.Fatal(err)
+	}
+	exp := []string{"10.0.0.2:8500", "10.0.1.1:8501"}
+	if !reflect.DeepEqual(exp, act) {
+		t.Errorf("\nexp: %#v\nact: %#v", exp, act)
+	}
+	if dc, passing := query.Get("dc"), query.Has("passing"); dc != "dc2" || !passing {
+		t.Errorf("expected healthy instances in dc2, got %q", query.Encode())
+	}
+}
+
+func TestSetFailover(t *testing.T) {
+	var body string
+	healthy := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		b, _ := io.ReadAll(r.Body)
+		body = string(b)
+		fmt.Fprint(w, "true")
+	}))
+	defer healthy.Close()
+	down := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	down.Close()
+
+	addresses := []string{down.Listener.Addr().String(), healthy.Listener.Addr().String()}
+	c := config.DefaultConsulConfig()
+	c.Address = config.String(addresses[0])
+	c.Finalize()
+	clients, err := newClientSet(c, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resolved := 0
+	setFailover(clients.httpClient, "consul-dest", addresses, func() ([]string, error) {
+		resolved++
+		return addresses, nil
+	}, false)
+
+	// The write fails over to the healthy instance with its body
+	if _, err := clients.Consul().KV().Put(&api.KVPair{Key: "global/a", Value: []byte("1")}, nil); err != nil {
+		t.Fatal(err)
+	}
+	if body != "1" {
+		t.Errorf("\nexp: %#v\nact: %#v", "1", body)
+	}
+
+	// Later requests stick to the healthy instance
+	if _, err := clients.Consul().KV().Put(&api.KVPair{Key: "global/a", Value: []byte("2")}, nil); err != nil {
+		t.Fatal(err)
+	}
+	if resolved != 1 {
+		t.Errorf("\nexp: %#v\nact: %#v", 1, resolved)
+	}
+}
+
+func TestServerAddresses(t *testing.T) {
+	var query url.Values
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		query = r.URL.Query()
+		switch r.URL.Path {
+		case "/v1/status/peers":
+			fmt.Fprint(w, `["10.0.0.3:8300","10.0.0.1:8300","10.0.0.2:8300"]`)
+		case "/v1/status/leader":
+			fmt.Fprint(w, `"10.0.0.2:8300"`)
+		}
+	}))
+	defer srv.Close()
+
+	c := config.DefaultConsulConfig()
+	c.Address = config.String(srv.URL)
+	c.Finalize()
+	clients, err := newClientSet(c, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	act, err := serverAddresses(clients.Consul(), "dc2", "8501")
+	if err != nil {
+		t.Fatal(err)
+	}
+	exp := []string{"10.0.0.2:8501", "10.0.0.1:8501", "10.0.0.3:8501"}
+	if !reflect.DeepEqual(exp, act) {
+		t.Errorf("\nexp: %#v\nact: %#v", exp, act)
+	}
+	if dc := query.Get("dc"); dc != "dc2" {
+		t.Errorf("expected the servers of dc2, got %q", query.Encode())
+	}
+}
+
+func TestInitServerWrites(t *testing.T) {
+	// The servers are reached by name and the agent by address, so the host
+	// of every request tells which one it was sent to
+	hosts := make(map[string]string)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		hosts[r.Method+" "+r.URL.Path] = r.Host
+		switch r.URL.Path {
+		case "/v1/status/peers":
+			fmt.Fprint(w, `["localhost:8300"]`)
+		case "/v1/status/leader":
+			fmt.Fprint(w, `"localhost:8300"`)
+		case "/v1/kv/global/a":
+			if r.Method == http.MethodPut {
+				fmt.Fprint(w, `true`)
+			} else {
+				http.NotFound(w, r)
+			}
+		}
+	}))
+	defer srv.Close()
+	_, port, _ := net.SplitHostPort(srv.Listener.Addr().String())
+
+	c := DefaultConfig().Merge(&Config{
+		Destination: &DestinationConfig{
+			Writes: config.String(DestinationWritesServers),
+		},
+		DestinationConsul: &config.ConsulConfig{
+			Address: config.String(srv.URL),
+		},
+	})
+	c.Finalize()
+	r := &Runner{config: c}
+
+	clients, err := newClientSet(c.DestinationConsul, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := r.initServerWrites(clients); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := clients.Consul().KV().Put(&api.KVPair{Key: "global/a"}, nil); err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err := clients.Consul().KV().Get("global/a", nil); err != nil {
+		t.Fatal(err)
+	}
+
+	exp := map[string]string{
+		"GET /v1/status/peers":  srv.Listener.Addr().String(),
+		"GET /v1/status/leader": srv.Listener.Addr().String(),
+		"PUT /v1/kv/global/a":   "localhost:" + port,
+		"GET /v1/kv/global/a":   srv.Listener.Addr().String(),
+	}
+	if !reflect.DeepEqual(exp, hosts) {
+		t.Errorf("\nexp: %#v\nact: %#v", exp, hosts)
+	}
+}
+
+func TestRunner_sameCluster(t *testing.T) {
+	c := DefaultConfig().Merge(&Config{
+		Consul: &config.ConsulConfig{
+			Address: config.String("consul.dc1.example.com:8500"),
+			Token:   config.String("source-token"),
+		},
+		Destination: &DestinationConfig{
+			SameCluster: config.Bool(true),
+		},
+		DestinationConsul: &config.ConsulConfig{
+			Address: config.String("127.0.0.1:8500"),
+		},
+	})
+	c.Finalize()
+
+	r, err := NewRunner(c, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	exp := []string{"consul.dc1.example.com:8500", "source-token"}
+	act := []string{config.StringVal(r.config.DestinationConsul.Address),
+		config.StringVal(r.config.DestinationConsul.Token)}
+	if !reflect.DeepEqual(exp, act) {
+		t.Errorf("\nexp: %#v\nact: %#v", exp, act)
+	}
+}
@@ -0,0 +1,101 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: MPL-2.0
+
+package replicate
+
+import (
+	"encoding/json"
+	"log"
+	"strings"
+	"time"
+
+	"github.com/hashicorp/consul-template/config"
+)
+
+// PassDiff is the diff of a pass, with the keys it put into and deleted from
+// the destination.
+type PassDiff struct {
+	// Replicator is the replication group of the prefix, if any.
+	Replicator string `json:"replicator,omitempty"`
+
+	// Source, Datacenter and Destination identify the prefix.
+	Source      string `json:"source"`
+	Datacenter  string `json:"datacenter,omitempty"`
+	Destination string `json:"destination"`
+
+	// Index is the source index that was replicated.
+	Index uint64 `json:"index"`
+
+	// Puts and Deletes are the keys written and deleted, in order.
+	Puts    []*Change `json:"puts"`
+	Deletes []*Change `json:"deletes"`
+
+	// Failures are the keys which could not be written or deleted, and Error
+	// the error which stopped the pass, if any.
+	Failures map[string]string `json:"failures,omitempty"`
+	Error    string            `json:"error,omitempty"`
+
+	// Time is when the pass finished.
+	Time time.Time `json:"time"`
+}
+
+// diffEnabled returns true if the diffs of the passes are written.
+func (r *Runner) diffEnabled() bool {
+	return config.BoolVal(r.config.Diff.Enabled)
+}
+
+// diffConsulPath returns the folder of the diffs in the destination Consul,
+// without a trailing slash, or an empty string if they are not written there.
+func (r *Runner) diffConsulPath() string {
+	if !r.diffEnabled() {
+		return ""
+	}
+	return strings.TrimRight(config.StringVal(r.config.Diff.ConsulPath), "/")
+}
+
+// oldHashes returns true if the hash of the value of every changed key is read
+// from the destination before it is changed, for sinks and diffs.
+func (r *Runner) oldHashes() bool {
+	return len(r.sinks) > 0 || r.diffEnabled()
+}
+
+// writeDiff writes the diff of the pass into the folder and the destination
+// Consul, unless it changed nothing. Failures are logged but do not fail
+// replication.
+func (r *Runner) writeDiff(prefix *PrefixConfig, e *Event) {
+	if !r.diffEnabled() || len(e.Changes) == 0 {
+		return
+	}
+
+	d := &PassDiff{
+		Replicator:  e.Replicator,
+		Source:      e.Source,
+		Datacenter:  e.Datacenter,
+		Destination: e.Destination,
+		Index:       e.Index,
+		Puts:        []*Change{},
+		Deletes:     []*Change{},
+		Failures:    e.Failures,
+		Time:        e.Time,
+	}
+	for _, change := range e.Changes {
+		if change.Op == ChangeDelete {
+			d.Deletes = append(d.Deletes, change)
+		} else {
+			d.Puts = append(d.Puts, change)
+		}
+	}
+	if e.Err != nil {
+		d.Error = e.Err.Error()
+	}
+
+	enc, err := json.MarshalIndent(d, "", "  ")
+	if err != nil {
+		log.Printf("[WARN] (runner) failed to encode the diff of %s: %s", prefix.Dependency, err)
+		return
+	}
+
+	if err := r.writeArchive(r.diffs, r.archiveName(prefix, e.Time, ".json"), enc); err != nil {
+		log.Printf("[WARN] (runner) failed to write the diff of %s: %s", prefix.Dependency, err)
+	}
+}
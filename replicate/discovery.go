@@ -0,0 +1,271 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: MPL-2.0
+
+package replicate
+
+import (
+	"fmt"
+	"log"
+	"sort"
+	"strings"
+
+	"github.com/hashicorp/consul-template/config"
+	"github.com/pkg/errors"
+)
+
+// discover expands wildcard prefixes by listing the matching folders on the
+// source, then starts watching prefixes which appeared and stops watching
+// prefixes which no longer exist.
+func (r *Runner) discover() error {
+	var prefixes []*PrefixConfig
+	for _, prefix := range *r.config.Prefixes {
+		if !prefix.IsWildcard() {
+			prefixes = append(prefixes, prefix)
+			continue
+		}
+
+		expanded, err := r.expand(prefix)
+		if err != nil {
+			return err
+		}
+		prefixes = append(prefixes, expanded...)
+	}
+
+	// Refuse to replicate a prefix into itself
+	if err := r.checkOverlap(prefixes); err != nil {
+		return err
+	}
+
+	// Refuse to replicate several prefixes into the same destination
+	if err := r.checkDestinations(prefixes); err != nil {
+		return err
+	}
+
+	// Only keep the prefixes owned by this instance
+	if r.shardEnabled() {
+		var err error
+		if prefixes, err = r.shard(prefixes); err != nil {
+			return err
+		}
+	}
+
+	r.Lock()
+	defer r.Unlock()
+
+	current := make(map[string]*PrefixConfig, len(r.prefixes))
+	for _, prefix := range r.prefixes {
+		current[prefix.Dependency.String()] = prefix
+	}
+
+	seen := make(map[string]struct{}, len(prefixes))
+	active := make([]*PrefixConfig, 0, len(prefixes))
+	for _, prefix := range prefixes {
+		id := prefix.Dependency.String()
+		if _, ok := seen[id]; ok {
+			continue
+		}
+		seen[id] = struct{}{}
+
+		if existing, ok := current[id]; ok {
+			active = append(active, existing)
+			continue
+		}
+		active = append(active, prefix)
+	}
+
+	// Snapshots, and sources which are not watched, are listed every pass
+	if r.watched() {
+		active = r.watch(active)
+	}
+
+	for id := range current {
+		if _, ok := seen[id]; ok {
+			continue
+		}
+		log.Printf("[INFO] (runner) %s no longer exists, stopped replicating", id)
+	}
+
+	r.prefixes = active
+	return nil
+}
+
+// expand lists the folders on the source matching the wildcard prefix and
+// returns a concrete prefix for each of them.
+func (r *Runner) expand(prefix *PrefixConfig) ([]*PrefixConfig, error) {
+	if prefix.IsDatacenterWildcard() {
+		return r.expandDatacenters(prefix)
+	}
+
+	source := config.StringVal(prefix.Source)
+	base := source[:strings.Index(source, "*")]
+
+	var keys []string
+	if s := r.snapshotFor(prefix); s != nil {
+		keys = s.keys(base)
+	} else {
+		var err error
+		keys, err = r.sourceFor(prefix).Keys(base, config.StringVal(prefix.Datacenter))
+		if err != nil {
+			return nil, errors.Wrapf(err, "discovering %q", source)
+		}
+	}
+	sort.Strings(keys)
+
+	var prefixes []*PrefixConfig
+	for _, key := range keys {
+		// Only folders can match the wildcard
+		if !strings.HasSuffix(key, "/") {
+			continue
+		}
+
+		name := strings.TrimSuffix(strings.TrimPrefix(key, base), "/")
+		if name == "" || strings.Contains(name, "/") {
+			continue
+		}
+
+		expanded, err := prefix.Expand(name)
+		if err != nil {
+			return nil, errors.Wrapf(err, "discovering %q", source)
+		}
+		prefixes = append(prefixes, expanded)
+	}
+
+	log.Printf("[DEBUG] (runner) %q matched %d folder(s)", source, len(prefixes))
+	return prefixes, nil
+}
+
+// expandDatacenters returns a prefix for each source datacenter matching the
+// datacenter wildcard of the prefix, expanding folder wildcards too.
+func (r *Runner) expandDatacenters(prefix *PrefixConfig) ([]*PrefixConfig, error) {
+	dcs, err := r.datacenters(prefix)
+	if err != nil {
+		return nil, err
+	}
+
+	var prefixes []*PrefixConfig
+	for _, dc := range dcs {
+		expanded, err := prefix.ExpandDatacenter(dc)
+		if err != nil {
+			return nil, errors.Wrapf(err, "discovering %q", config.StringVal(prefix.Source)+"@*")
+		}
+
+		if !expanded.IsWildcard() {
+			prefixes = append(prefixes, expanded)
+			continue
+		}
+
+		folders, err := r.expand(expanded)
+		if err != nil {
+			return nil, err
+		}
+		prefixes = append(prefixes, folders...)
+	}
+
+	log.Printf("[DEBUG] (runner) %q matched %d datacenter(s)",
+		config.StringVal(prefix.Source)+"@*", len(dcs))
+	return prefixes, nil
+}
+
+// datacenters returns the source datacenters matching a datacenter wildcard.
+// The datacenter of the local agent is never a source of a Consul destination.
+func (r *Runner) datacenters(prefix *PrefixConfig) ([]string, error) {
+	if r.snapshots != nil {
+		var dcs []string
+		for dc := range r.snapshots {
+			if dc != "" {
+				dcs = append(dcs, dc)
+			}
+		}
+		if len(dcs) == 0 {
+			return nil, fmt.Errorf("discovering %q: datacenter wildcards cannot be "+
+				"replayed from a snapshot file", config.StringVal(prefix.Source)+"@*")
+		}
+		sort.Strings(dcs)
+		return dcs, nil
+	}
+
+	cached, err := r.cache.get("datacenters", func() (interface{}, error) {
+		return r.source.Datacenters()
+	})
+	if err != nil {
+		return nil, errors.Wrap(err, "listing datacenters")
+	}
+	dcs := cached.([]string)
+	if config.StringVal(prefix.Backend) != BackendConsul {
+		return dcs, nil
+	}
+
+	local, err := r.prefixDatacenter(prefix)
+	if err != nil {
+		return nil, err
+	}
+
+	result := make([]string, 0, len(dcs))
+	for _, dc := range dcs {
+		if dc != local {
+			result = append(result, dc)
+		}
+	}
+	return result, nil
+}
+
+// destinationDatacenter returns the configured destination datacenter, or the
+// datacenter of the destination agent, unless it was given when the runner was
+// created.
+func (r *Runner) destinationDatacenter() (string, error) {
+	if r.datacenter != "" {
+		return r.datacenter, nil
+	}
+	if dc := config.StringVal(r.config.Destination.Datacenter); dc != "" {
+		return dc, nil
+	}
+
+	dc, err := r.cache.get("destination-datacenter", func() (interface{}, error) {
+		info, err := r.destinationClients.Consul().Agent().Self()
+		if err != nil {
+			return nil, errors.Wrap(err, "querying agent")
+		}
+		dc, _ := info["Config"]["Datacenter"].(string)
+		return dc, nil
+	})
+	if err != nil {
+		return "", err
+	}
+	return dc.(string), nil
+}
+
+// prefixDatacenter returns the datacenter the prefix is written into, which is
+// its own destination datacenter, if it has one.
+func (r *Runner) prefixDatacenter(prefix *PrefixConfig) (string, error) {
+	if dc := config.StringVal(prefix.DestinationDatacenter); dc != "" {
+		return dc, nil
+	}
+	return r.destinationDatacenter()
+}
+
+// activePrefixes returns the list of concrete prefixes currently replicated.
+func (r *Runner) activePrefixes() []*PrefixConfig {
+	r.RLock()
+	defer r.RUnlock()
+	prefixes := make([]*PrefixConfig, len(r.prefixes))
+	copy(prefixes, r.prefixes)
+	return prefixes
+}
+
+// discovered returns true once the prefixes were discovered, so the active
+// prefixes are complete.
+func (r *Runner) discovered() bool {
+	r.RLock()
+	defer r.RUnlock()
+	return r.prefixes != nil
+}
+
+// hasWildcards returns true if any configured prefix requires discovery.
+func (r *Runner) hasWildcards() bool {
+	for _, prefix := range *r.config.Prefixes {
+		if prefix.IsWildcard() {
+			return true
+		}
+	}
+	return false
+}
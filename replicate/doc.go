@@ -0,0 +1,41 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: MPL-2.0
+
+// Package replicate replicates key-value data from a source Consul datacenter
+// into a destination, and is the engine behind the consul-replicate daemon.
+//
+// It can be embedded in other Go services instead of running the binary:
+//
+//	cfg := replicate.DefaultConfig().Merge(replicate.Must(`
+//	  prefix {
+//	    source     = "global"
+//	    datacenter = "nyc1"
+//	  }
+//	`))
+//
+//	runner, err := replicate.NewRunner(cfg, false)
+//	if err != nil {
+//		return err
+//	}
+//	go runner.Start()
+//	defer runner.Stop()
+//
+//	for {
+//		select {
+//		case e := <-runner.Events():
+//			log.Printf("replicated %d updates from %s", e.Updates, e.Source)
+//		case err := <-runner.ErrCh:
+//			return err
+//		}
+//	}
+//
+// NewRunner finalizes the configuration, so only the values which differ from
+// the defaults need to be given.
+//
+// A Runner replicates the top-level prefixes of a configuration. Replicator
+// blocks are run by a Supervisor, which runs one Runner per group.
+//
+// NewRunnerWithInput creates a runner which reads from another Source and
+// writes into another Backend than Consul. The replicatetest package uses it
+// to test replication against in-memory fakes.
+package replicate
@@ -0,0 +1,281 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: MPL-2.0
+
+package replicate
+
+import (
+	"log"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/hashicorp/consul-template/config"
+	"github.com/hashicorp/consul/api"
+)
+
+const (
+	// driftBufferSize is the number of drifts buffered for slow consumers
+	// before new ones are dropped.
+	driftBufferSize = 100
+
+	// driftRetryInterval is how long the watch of a destination waits before
+	// listing it again after a failure.
+	driftRetryInterval = 10 * time.Second
+)
+
+// Drift is a change of a key under the destination of a prefix which was not
+// made by the replicator, such as an operator editing replicated data
+// directly. It is overwritten or removed by the next pass which replicates the
+// key.
+type Drift struct {
+	Source, Datacenter, Destination string
+
+	// Key is the destination key which changed.
+	Key string
+
+	// Op is ChangePut if the key was created or modified, and ChangeDelete if
+	// it was removed.
+	Op string
+
+	// Index is the modify index of the key, or the index of the destination
+	// once it was removed.
+	Index uint64
+
+	// Time is when the change was seen.
+	Time time.Time
+}
+
+// Drifts returns the channel where every drift of the destinations is
+// published. Drifts are dropped if the channel is not drained.
+func (r *Runner) Drifts() <-chan *Drift {
+	return r.driftCh
+}
+
+// driftEnabled returns true if the destinations are watched for drift, which
+// is never the case in once mode.
+func (r *Runner) driftEnabled() bool {
+	return config.BoolVal(r.config.Drift.Enabled) && !r.once
+}
+
+// driftWatch tracks the keys under the destination of a prefix, as left by the
+// last pass, to tell the changes made by the replicator from the others.
+type driftWatch struct {
+	sync.Mutex
+
+	// passes is the number of passes of the prefix writing into the
+	// destination.
+	passes int
+
+	// keys are the modify indexes of the destination keys, keyed by key, and
+	// index the index of the destination they were listed at. The keys are
+	// nil until they are known.
+	keys  map[string]uint64
+	index uint64
+
+	// started is true once the destination is watched.
+	started bool
+}
+
+// begin records a pass writing into the destination. Its changes are not
+// drift.
+func (w *driftWatch) begin() {
+	w.Lock()
+	defer w.Unlock()
+	w.passes++
+}
+
+// end records the end of a pass, and the keys of the destination it left,
+// listed at the index. The keys are forgotten if they could not be listed.
+func (w *driftWatch) end(keys map[string]uint64, index uint64) {
+	w.Lock()
+	defer w.Unlock()
+	w.passes--
+	w.keys, w.index = keys, index
+}
+
+// diff records the keys of the destination listed at the index, and returns
+// the drifts since the keys it last recorded. Listings made while a pass
+// writes into the destination, and those not newer than the end of the last
+// pass, are ignored, and nothing is returned until the keys are known. Nil
+// keys are not known.
+func (w *driftWatch) diff(keys map[string]uint64, index uint64) []*Drift {
+	w.Lock()
+	defer w.Unlock()
+	if w.passes > 0 || index <= w.index {
+		return nil
+	}
+
+	last := w.keys
+	w.keys, w.index = keys, index
+	if last == nil || keys == nil {
+		return nil
+	}
+
+	var drifts []*Drift
+	for key, modifyIndex := range keys {
+		if prev, ok := last[key]; !ok || modifyIndex > prev {
+			drifts = append(drifts, &Drift{Key: key, Op: ChangePut, Index: modifyIndex})
+		}
+	}
+	for key := range last {
+		if _, ok := keys[key]; !ok {
+			drifts = append(drifts, &Drift{Key: key, Op: ChangeDelete, Index: index})
+		}
+	}
+	sort.Slice(drifts, func(i, j int) bool { return drifts[i].Key < drifts[j].Key })
+	return drifts
+}
+
+// driftKeys returns the modify indexes of the pairs, keyed by key, leaving out
+// the reserved keys of the replicator.
+func (r *Runner) driftKeys(pairs []*api.KVPair) map[string]uint64 {
+	keys := make(map[string]uint64, len(pairs))
+	for _, pair := range pairs {
+		if !r.reserved(pair.Key) {
+			keys[pair.Key] = pair.ModifyIndex
+		}
+	}
+	return keys
+}
+
+// driftBackend returns the destination of the prefix, if it can be watched.
+// Destinations of other backends than Consul, and of routes, cannot.
+func (r *Runner) driftBackend(prefix *PrefixConfig) (listBackend, bool) {
+	backend, ok := bindContext(r.backend(prefix), r.ctx).(listBackend)
+	return backend, ok
+}
+
+// guardDrift records a pass of the prefix writing into the destination, and
+// returns the function to call once it has returned. It lists the keys the
+// pass left in the destination, and starts watching the destination after the
+// first pass.
+func (r *Runner) guardDrift(prefix *PrefixConfig) func() {
+	if !r.driftEnabled() {
+		return func() {}
+	}
+	backend, ok := r.driftBackend(prefix)
+	if !ok {
+		return func() {}
+	}
+
+	id := prefix.Dependency.String()
+	r.driftsLock.Lock()
+	w, ok := r.drifts[id]
+	if !ok {
+		w = &driftWatch{}
+		r.drifts[id] = w
+	}
+	r.driftsLock.Unlock()
+
+	w.begin()
+	return func() {
+		pairs, index, err := backend.list(config.StringVal(prefix.Destination), 0)
+		if err != nil {
+			log.Printf("[WARN] (runner) failed to list the destination of %s for drift: %s",
+				prefix.Dependency, err)
+			w.end(nil, 0)
+		} else {
+			w.end(r.driftKeys(pairs), index)
+		}
+
+		w.Lock()
+		start := !w.started
+		w.started = true
+		w.Unlock()
+		if start {
+			go r.watchDrift(prefix, backend, w)
+		}
+	}
+}
+
+// watchDrift lists the destination of the prefix every time it changes, and
+// reports the drifts, until the runner is stopped or the prefix is no longer
+// replicated.
+func (r *Runner) watchDrift(prefix *PrefixConfig, backend listBackend, w *driftWatch) {
+	id := prefix.Dependency.String()
+	destination := config.StringVal(prefix.Destination)
+	log.Printf("[DEBUG] (runner) watching the destination of %s for drift", id)
+
+	var index uint64
+	for {
+		if !r.replicating(id) {
+			r.driftsLock.Lock()
+			delete(r.drifts, id)
+			r.driftsLock.Unlock()
+			w.Lock()
+			w.started = false
+			w.Unlock()
+			log.Printf("[DEBUG] (runner) stopped watching the destination of %s for drift", id)
+			return
+		}
+
+		pairs, next, err := backend.list(destination, index)
+		select {
+		case <-r.stopCh:
+			return
+		default:
+		}
+		if err != nil {
+			log.Printf("[WARN] (runner) failed to watch the destination of %s for drift: %s", id, err)
+			select {
+			case <-time.After(driftRetryInterval):
+			case <-r.stopCh:
+				return
+			}
+			continue
+		}
+
+		// Only the leader writes to the destination, so the writes of a new
+		// leader are not drift either
+		keys := r.driftKeys(pairs)
+		if r.haEnabled() && !r.isLeader() {
+			keys = nil
+		}
+		r.reportDrift(prefix, w.diff(keys, next))
+
+		// Like a blocking query, an index going backwards starts over
+		if next < index {
+			next = 0
+		}
+		index = next
+	}
+}
+
+// replicating returns true if the prefix with the id is active.
+func (r *Runner) replicating(id string) bool {
+	for _, prefix := range r.activePrefixes() {
+		if prefix.Dependency.String() == id {
+			return true
+		}
+	}
+	return false
+}
+
+// reportDrift logs and publishes the drifts of the destination of the prefix.
+func (r *Runner) reportDrift(prefix *PrefixConfig, drifts []*Drift) {
+	if len(drifts) == 0 {
+		return
+	}
+
+	e := &Event{
+		Replicator:  r.replicator,
+		Labels:      r.labels,
+		Source:      config.StringVal(prefix.Source),
+		Datacenter:  config.StringVal(prefix.Datacenter),
+		Destination: config.StringVal(prefix.Destination),
+	}
+	now := time.Now().UTC()
+	for _, d := range drifts {
+		d.Source, d.Datacenter, d.Destination = e.Source, e.Datacenter, e.Destination
+		d.Time = now
+		log.Printf("[WARN] (runner) %q under the destination of %s was changed (%s) "+
+			"by something other than the replicator", d.Key, prefix.Dependency, d.Op)
+		r.telemetry.drift(e, d.Op)
+
+		select {
+		case r.driftCh <- d:
+		default:
+			log.Printf("[DEBUG] (runner) drift buffer full, dropping drift of %q", d.Key)
+		}
+	}
+}
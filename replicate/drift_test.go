@@ -0,0 +1,97 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: MPL-2.0
+
+package replicate
+
+import (
+	"fmt"
+	"reflect"
+	"testing"
+)
+
+func TestDriftWatch_diff(t *testing.T) {
+	baseline := map[string]uint64{"global/a": 10, "global/b": 12}
+
+	cases := []struct {
+		name    string
+		w       *driftWatch
+		keys    map[string]uint64
+		index   uint64
+		exp     []*Drift
+		expKeys map[string]uint64
+	}{
+		{
+			"unchanged",
+			&driftWatch{keys: baseline, index: 12},
+			map[string]uint64{"global/a": 10, "global/b": 12},
+			13,
+			nil,
+			map[string]uint64{"global/a": 10, "global/b": 12},
+		},
+		{
+			"put",
+			&driftWatch{keys: baseline, index: 12},
+			map[string]uint64{"global/a": 14, "global/b": 12, "global/c": 15},
+			15,
+			[]*Drift{
+				{Key: "global/a", Op: ChangePut, Index: 14},
+				{Key: "global/c", Op: ChangePut, Index: 15},
+			},
+			map[string]uint64{"global/a": 14, "global/b": 12, "global/c": 15},
+		},
+		{
+			"delete",
+			&driftWatch{keys: baseline, index: 12},
+			map[string]uint64{"global/a": 10},
+			16,
+			[]*Drift{
+				{Key: "global/b", Op: ChangeDelete, Index: 16},
+			},
+			map[string]uint64{"global/a": 10},
+		},
+		{
+			"during_pass",
+			&driftWatch{keys: baseline, index: 12, passes: 1},
+			map[string]uint64{"global/a": 14},
+			14,
+			nil,
+			baseline,
+		},
+		{
+			"before_end_of_pass",
+			&driftWatch{keys: baseline, index: 12},
+			map[string]uint64{"global/a": 11, "global/b": 12},
+			12,
+			nil,
+			baseline,
+		},
+		{
+			"unknown",
+			&driftWatch{index: 12},
+			map[string]uint64{"global/a": 14},
+			14,
+			nil,
+			map[string]uint64{"global/a": 14},
+		},
+		{
+			"not_leader",
+			&driftWatch{keys: baseline, index: 12},
+			nil,
+			14,
+			nil,
+			nil,
+		},
+	}
+
+	for i, tc := range cases {
+		t.Run(fmt.Sprintf("%d_%s", i, tc.name), func(t *testing.T) {
+			act := tc.w.diff(tc.keys, tc.index)
+			if !reflect.DeepEqual(tc.exp, act) {
+				t.Errorf("\nexp: %#v\nact: %#v", tc.exp, act)
+			}
+			if !reflect.DeepEqual(tc.expKeys, tc.w.keys) {
+				t.Errorf("\nexp: %#v\nact: %#v", tc.expKeys, tc.w.keys)
+			}
+		})
+	}
+}
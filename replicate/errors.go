@@ -0,0 +1,39 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: MPL-2.0
+
+package replicate
+
+import (
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// responseCodeRe matches the status code in errors returned by the Consul API
+// and the Kubernetes backend.
+var responseCodeRe = regexp.MustCompile(`(?:Unexpected response code: |kubernetes: \S+ \S+: )(\d+)`)
+
+// responseCode returns the HTTP status code of an error response, or zero if
+// the error is not a response.
+func responseCode(err error) int {
+	m := responseCodeRe.FindStringSubmatch(err.Error())
+	if m == nil {
+		return 0
+	}
+	code, _ := strconv.Atoi(m[1])
+	return code
+}
+
+// keyError returns true if the error only concerns the key being written or
+// deleted, such as a key the token may not write or a value which is too
+// large, so the remaining keys can still be replicated. Any other error, such
+// as an unreachable destination or an unknown token, fails the whole pass.
+func keyError(err error) bool {
+	switch responseCode(err) {
+	case 400, 413, 422:
+		return true
+	case 403:
+		return !strings.Contains(err.Error(), "ACL not found")
+	}
+	return false
+}
@@ -0,0 +1,56 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: MPL-2.0
+
+package replicate
+
+import (
+	"fmt"
+	"testing"
+)
+
+func TestKeyError(t *testing.T) {
+	cases := []struct {
+		name string
+		err  error
+		exp  bool
+	}{
+		{
+			"permission_denied",
+			fmt.Errorf("Unexpected response code: 403 (Permission denied)"),
+			true,
+		},
+		{
+			"acl_not_found",
+			fmt.Errorf("Unexpected response code: 403 (ACL not found)"),
+			false,
+		},
+		{
+			"too_large",
+			fmt.Errorf("Unexpected response code: 413 (Value exceeds 524288 byte limit)"),
+			true,
+		},
+		{
+			"kubernetes_invalid",
+			fmt.Errorf("kubernetes: PUT /api/v1/namespaces/default/configmaps/app: 422 Unprocessable Entity: invalid"),
+			true,
+		},
+		{
+			"unavailable",
+			fmt.Errorf("Unexpected response code: 500 (No cluster leader)"),
+			false,
+		},
+		{
+			"connection",
+			fmt.Errorf("dial tcp 127.0.0.1:8500: connect: connection refused"),
+			false,
+		},
+	}
+
+	for i, tc := range cases {
+		t.Run(fmt.Sprintf("%d_%s", i, tc.name), func(t *testing.T) {
+			if act := keyError(tc.err); act != tc.exp {
+				t.Errorf("\nexp: %#v\nact: %#v", tc.exp, act)
+			}
+		})
+	}
+}
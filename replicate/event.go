@@ -0,0 +1,142 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: MPL-2.0
+
+package replicate
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"log"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// eventBufferSize is the number of events buffered for slow consumers before
+// new events are dropped.
+const eventBufferSize = 100
+
+// Event describes the outcome of a single replication pass of a prefix.
+type Event struct {
+	// Replicator and Labels identify the replication group, and are empty
+	// outside of replicator blocks.
+	Replicator string
+	Labels     map[string]string
+
+	// Source, Datacenter and Destination identify the prefix.
+	Source, Datacenter, Destination string
+
+	// Scanned is the number of source keys read by the pass.
+	Scanned int
+
+	// Updates and Deletes are the number of keys written and deleted.
+	Updates, Deletes int
+
+	// Excluded is the number of source keys the prefix does not replicate,
+	// such as by their flags, the allow list, an exclude or a middleware, and
+	// Kept the number of destination keys missing from the source which were
+	// not deleted, because they are excluded or not owned by a replicator.
+	Excluded, Kept int
+
+	// Index is the source index that was replicated.
+	Index uint64
+
+	// Coalesced is the number of source updates of the prefix which were
+	// received since the last pass, and coalesced into this one. It is zero
+	// for passes which were not triggered by the source, such as retries.
+	Coalesced int
+
+	// Changes are the individual keys written and deleted, in order.
+	Changes []*Change
+
+	// Failures are the keys which could not be written or deleted, and why.
+	// They do not fail the pass, and are retried in the next one.
+	Failures map[string]string
+
+	// Skipped are the source keys which were rejected by the key rules of the
+	// prefix, and why.
+	Skipped map[string]string
+
+	// Err is the error which stopped the pass, if any.
+	Err error
+
+	// Time is when the pass finished, and Duration how long it took.
+	Time     time.Time
+	Duration time.Duration
+}
+
+// Summary returns the outcome of the pass as space separated key=value
+// fields, which always come in the same order so they can be parsed from the
+// logs. String values are quoted.
+func (e *Event) Summary() string {
+	var b strings.Builder
+	if e.Replicator != "" {
+		fmt.Fprintf(&b, "replicator=%s ", strconv.Quote(e.Replicator))
+	}
+	fmt.Fprintf(&b, "source=%s datacenter=%s destination=%s",
+		strconv.Quote(e.Source), strconv.Quote(e.Datacenter), strconv.Quote(e.Destination))
+
+	status := "success"
+	if e.Err != nil {
+		status = "failure"
+	}
+	fmt.Fprintf(&b, " status=%s index=%d scanned=%d puts=%d deletes=%d excluded=%d "+
+		"kept=%d skipped=%d failed=%d coalesced=%d duration_ms=%d", status, e.Index,
+		e.Scanned, e.Updates, e.Deletes, e.Excluded, e.Kept, len(e.Skipped),
+		len(e.Failures), e.Coalesced, e.Duration.Milliseconds())
+	if e.Err != nil {
+		fmt.Fprintf(&b, " error=%s", strconv.Quote(e.Err.Error()))
+	}
+	return b.String()
+}
+
+const (
+	// ChangePut and ChangeDelete are the operations of a Change.
+	ChangePut    = "put"
+	ChangeDelete = "delete"
+)
+
+// Change is a single key applied to a destination.
+type Change struct {
+	// Source and Datacenter identify the prefix the key was replicated from.
+	Source     string `json:"source"`
+	Datacenter string `json:"datacenter,omitempty"`
+
+	// Key is the destination key.
+	Key string `json:"key"`
+
+	// Op is either ChangePut or ChangeDelete.
+	Op string `json:"op"`
+
+	// OldHash and NewHash are the hashes of the value before and after the
+	// change. OldHash is only populated when sinks, diffs or verify_before_write
+	// are configured, and is empty if the key did not exist.
+	OldHash string `json:"old_hash,omitempty"`
+	NewHash string `json:"new_hash,omitempty"`
+
+	// Index is the modify index of the source key, or the replicated index for
+	// deletes.
+	Index uint64 `json:"index"`
+}
+
+// valueHash returns the hash of a value as used in a Change.
+func valueHash(v []byte) string {
+	sum := sha256.Sum256(v)
+	return "sha256:" + hex.EncodeToString(sum[:])
+}
+
+// Events returns the channel where an Event is published after every
+// replication pass. Events are dropped if the channel is not drained.
+func (r *Runner) Events() <-chan *Event {
+	return r.eventCh
+}
+
+// emit publishes the event without blocking the replication pass.
+func (r *Runner) emit(e *Event) {
+	select {
+	case r.eventCh <- e:
+	default:
+		log.Printf("[DEBUG] (runner) event buffer full, dropping event for %q", e.Source)
+	}
+}
@@ -0,0 +1,62 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: MPL-2.0
+
+package replicate
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+)
+
+func TestEvent_Summary(t *testing.T) {
+	cases := []struct {
+		name string
+		e    *Event
+		exp  string
+	}{
+		{
+			"success",
+			&Event{
+				Source:      "global",
+				Datacenter:  "dc1",
+				Destination: "global",
+				Index:       42,
+				Scanned:     10,
+				Updates:     3,
+				Deletes:     1,
+				Excluded:    4,
+				Kept:        2,
+				Skipped:     map[string]string{"global/bad key": "invalid"},
+				Coalesced:   2,
+				Duration:    1500 * time.Millisecond,
+			},
+			`source="global" datacenter="dc1" destination="global" status=success ` +
+				`index=42 scanned=10 puts=3 deletes=1 excluded=4 kept=2 skipped=1 failed=0 ` +
+				`coalesced=2 duration_ms=1500`,
+		},
+		{
+			"failure",
+			&Event{
+				Replicator:  "east",
+				Source:      "global",
+				Destination: "replicated/global",
+				Err:         errors.New(`failed to list "global": 403`),
+				Duration:    20 * time.Millisecond,
+			},
+			`replicator="east" source="global" datacenter="" destination="replicated/global" ` +
+				`status=failure index=0 scanned=0 puts=0 deletes=0 excluded=0 kept=0 ` +
+				`skipped=0 failed=0 coalesced=0 duration_ms=20 ` +
+				`error="failed to list \"global\": 403"`,
+		},
+	}
+
+	for i, tc := range cases {
+		t.Run(fmt.Sprintf("%d_%s", i, tc.name), func(t *testing.T) {
+			if act := tc.e.Summary(); act != tc.exp {
+				t.Errorf("\nexp: %#v\nact: %#v", tc.exp, act)
+			}
+		})
+	}
+}
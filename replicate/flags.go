@@ -0,0 +1,38 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: MPL-2.0
+
+package replicate
+
+import (
+	"fmt"
+
+	"github.com/hashicorp/consul-template/config"
+)
+
+// checkFlags returns an error if the flag masks of the prefix are invalid.
+func checkFlags(prefix *PrefixConfig) error {
+	if config.IntVal(prefix.IncludeFlags) < 0 {
+		return fmt.Errorf("prefix %q: include_flags cannot be negative",
+			config.StringVal(prefix.Source))
+	}
+	if config.IntVal(prefix.ExcludeFlags) < 0 {
+		return fmt.Errorf("prefix %q: exclude_flags cannot be negative",
+			config.StringVal(prefix.Source))
+	}
+	return nil
+}
+
+// flagMasks returns true if the prefix selects source keys by their flags.
+func flagMasks(prefix *PrefixConfig) bool {
+	return config.IntVal(prefix.IncludeFlags) != 0 || config.IntVal(prefix.ExcludeFlags) != 0
+}
+
+// flagsMatch returns true if the flags of a source key are selected by the
+// flag masks of the prefix. The flags are given without the markers
+// replicators stamp on them.
+func flagsMatch(prefix *PrefixConfig, flags uint64) bool {
+	if include := uint64(config.IntVal(prefix.IncludeFlags)); include != 0 && flags&include == 0 {
+		return false
+	}
+	return flags&uint64(config.IntVal(prefix.ExcludeFlags)) == 0
+}
@@ -0,0 +1,70 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: MPL-2.0
+
+package replicate
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/hashicorp/consul-template/config"
+)
+
+func TestFlagsMatch(t *testing.T) {
+	cases := []struct {
+		name    string
+		include int
+		exclude int
+		flags   uint64
+		exp     bool
+	}{
+		{"no_masks", 0, 0, 5, true},
+		{"include_match", 6, 0, 2, true},
+		{"include_no_match", 6, 0, 1, false},
+		{"include_no_flags", 6, 0, 0, false},
+		{"exclude_match", 0, 8, 9, false},
+		{"exclude_no_match", 0, 8, 1, true},
+		{"include_and_exclude", 2, 8, 10, false},
+		{"high_bits", 0, 0xffff, identityMarker("node1"), true},
+		{"high_bits_excluded", 0, 0xffff << 32, identityMarker("node1"), false},
+	}
+
+	for i, tc := range cases {
+		t.Run(fmt.Sprintf("%d_%s", i, tc.name), func(t *testing.T) {
+			prefix := &PrefixConfig{
+				IncludeFlags: config.Int(tc.include),
+				ExcludeFlags: config.Int(tc.exclude),
+			}
+			if act := flagsMatch(prefix, tc.flags); act != tc.exp {
+				t.Errorf("\nexp: %#v\nact: %#v", tc.exp, act)
+			}
+		})
+	}
+}
+
+func TestCheckFlags(t *testing.T) {
+	cases := []struct {
+		name    string
+		include int
+		exclude int
+		err     bool
+	}{
+		{"valid", 1, 2, false},
+		{"negative_include", -1, 0, true},
+		{"negative_exclude", 0, -1, true},
+	}
+
+	for i, tc := range cases {
+		t.Run(fmt.Sprintf("%d_%s", i, tc.name), func(t *testing.T) {
+			prefix := &PrefixConfig{
+				Source:       config.String("global"),
+				IncludeFlags: config.Int(tc.include),
+				ExcludeFlags: config.Int(tc.exclude),
+			}
+			err := checkFlags(prefix)
+			if (err != nil) != tc.err {
+				t.Fatal(err)
+			}
+		})
+	}
+}
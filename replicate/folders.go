@@ -0,0 +1,92 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: MPL-2.0
+
+package replicate
+
+import (
+	"fmt"
+	"log"
+	"sort"
+
+	"github.com/hashicorp/consul-template/config"
+	"github.com/hashicorp/consul/api"
+)
+
+// folderKeys returns the keys of the intermediate folders of the given
+// destination keys, from the destination of the prefix down, sorted so parents
+// come before their children.
+func folderKeys(destination string, keys map[string]struct{}) []string {
+	folders := make(map[string]struct{})
+	for key := range keys {
+		for i := 0; i < len(key)-1; i++ {
+			if key[i] == '/' && i+1 >= len(destination) {
+				folders[key[:i+1]] = struct{}{}
+			}
+		}
+	}
+
+	result := make([]string, 0, len(folders))
+	for folder := range folders {
+		result = append(result, folder)
+	}
+	sort.Strings(result)
+	return result
+}
+
+// createFolders writes an empty folder key for every intermediate folder of
+// the keys of the prefix which does not exist in the destination yet, and
+// marks the folders as used so they are only deleted once they are empty.
+func (r *Runner) createFolders(backend Backend, prefix *PrefixConfig, existing []string,
+	usedKeys map[string]struct{}, tree map[string][]byte, failures map[string]string) error {
+	present := make(map[string]struct{}, len(existing))
+	for _, key := range existing {
+		present[key] = struct{}{}
+	}
+
+	created := 0
+	for _, folder := range folderKeys(config.StringVal(prefix.Destination), usedKeys) {
+		if r.reserved(folder) {
+			continue
+		}
+		if _, ok := usedKeys[folder]; ok {
+			continue
+		}
+		usedKeys[folder] = struct{}{}
+		tree[folder] = []byte{}
+		if _, ok := present[folder]; ok {
+			continue
+		}
+
+		if err := backend.Put(&api.KVPair{
+			Key:   folder,
+			Flags: r.stampIdentity(0),
+		}); err != nil {
+			if !keyError(err) {
+				return fmt.Errorf("failed to create folder %q: %s", folder, err)
+			}
+			log.Printf("[WARN] (runner) failed to create folder %q, continuing: %s", folder, err)
+			failures[folder] = err.Error()
+			continue
+		}
+		created++
+
+		// The folder is owned like the keys under it, so it is deleted once
+		// they are gone
+		if r.writerRecorded(prefix) {
+			if err := r.writeMetadata(backend, folder, &KeyMetadata{
+				ValueHash: valueHash(nil),
+				Writer:    r.identityName(),
+			}); err != nil {
+				if !keyError(err) {
+					return fmt.Errorf("failed to write metadata of %q: %s", folder, err)
+				}
+				log.Printf("[WARN] (runner) failed to write metadata of %q, continuing: %s", folder, err)
+			}
+		}
+	}
+
+	if created > 0 {
+		log.Printf("[DEBUG] (runner) created %d folder(s) of %q", created, prefix.Dependency)
+	}
+	return nil
+}
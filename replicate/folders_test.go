@@ -0,0 +1,70 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: MPL-2.0
+
+package replicate
+
+import (
+	"fmt"
+	"reflect"
+	"testing"
+)
+
+func TestFolderKeys(t *testing.T) {
+	cases := []struct {
+		name        string
+		destination string
+		keys        []string
+		exp         []string
+	}{
+		{
+			"folder",
+			"global/",
+			[]string{"global/a", "global/b/c/d", "global/b/e"},
+			[]string{"global/", "global/b/", "global/b/c/"},
+		},
+		{
+			"prefix",
+			"global",
+			[]string{"global/a", "globalize/b"},
+			[]string{"global/", "globalize/"},
+		},
+		{
+			"parents_of_destination",
+			"team/app/",
+			[]string{"team/app/a/b"},
+			[]string{"team/app/", "team/app/a/"},
+		},
+		{
+			"folder_key",
+			"global/",
+			[]string{"global/a/"},
+			[]string{"global/"},
+		},
+		{
+			"root",
+			"",
+			[]string{"a", "b/c"},
+			[]string{"b/"},
+		},
+		{
+			"empty",
+			"global/",
+			nil,
+			[]string{},
+		},
+	}
+
+	for i, tc := range cases {
+		t.Run(fmt.Sprintf("%d_%s", i, tc.name), func(t *testing.T) {
+			keys := make(map[string]struct{}, len(tc.keys))
+			for _, key := range tc.keys {
+				keys[key] = struct{}{}
+			}
+
+			act := folderKeys(tc.destination, keys)
+			if !reflect.DeepEqual(tc.exp, act) {
+				t.Errorf("\nexp: %#v\nact: %#v", tc.exp, act)
+			}
+		})
+	}
+}
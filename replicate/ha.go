@@ -0,0 +1,181 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: MPL-2.0
+
+package replicate
+
+import (
+	"context"
+	"log"
+	"strings"
+	"time"
+
+	"github.com/hashicorp/consul-template/config"
+	"github.com/hashicorp/consul/api"
+)
+
+// haRetryInterval is how long to wait before campaigning again after an error.
+const haRetryInterval = 5 * time.Second
+
+// haEnabled returns true if the runner only replicates while holding the lock.
+// Leader election does not apply to single passes.
+func (r *Runner) haEnabled() bool {
+	return config.BoolVal(r.config.HA.Enabled) && !r.once && r.snapshots == nil
+}
+
+// lockKey returns the key of the HA lock.
+func (r *Runner) lockKey() string {
+	if key := config.StringVal(r.config.HA.LockKey); key != "" {
+		return key
+	}
+	return strings.TrimRight(config.StringVal(r.config.StatusDir), "/") + "/leader"
+}
+
+// isLeader returns true if the runner holds the lock.
+func (r *Runner) isLeader() bool {
+	r.RLock()
+	defer r.RUnlock()
+	return r.leader
+}
+
+// setLeader records whether the runner holds the lock. Losing it cancels the
+// passes in flight, so a runner which is not the leader anymore stops writing
+// before campaigning again.
+func (r *Runner) setLeader(leader bool) {
+	r.Lock()
+	defer r.Unlock()
+	r.leader = leader
+
+	if r.leaderCancel != nil {
+		r.leaderCancel()
+	}
+	if leader {
+		r.leaderCtx, r.leaderCancel = context.WithCancel(r.ctx)
+	}
+}
+
+// passContext returns the context of replication passes, which is cancelled
+// when the runner is stopped or, with HA, loses the lock.
+func (r *Runner) passContext() context.Context {
+	r.RLock()
+	defer r.RUnlock()
+	if r.leaderCtx != nil {
+		return r.leaderCtx
+	}
+	return r.ctx
+}
+
+// elect campaigns for the lock until the runner is stopped, and notifies
+// leaderCh every time it is acquired. A session handed over by the process
+// this one replaced already holds the lock, so it is acquired without an
+// election. The lock only renews the sessions it creates, so that session is
+// renewed here until the lock is released or lost.
+func (r *Runner) elect() {
+	key := r.lockKey()
+	opts := &api.LockOptions{
+		Key:            key,
+		SessionName:    "consul-replicate",
+		MonitorRetries: 3,
+	}
+
+	var renewCh chan struct{}
+	if session := r.inheritedSession(); session != "" {
+		log.Printf("[INFO] (runner) taking over lock %q with session %s", key, session)
+		opts.Session = session
+		renewCh = make(chan struct{})
+		go func(doneCh chan struct{}) {
+			err := r.destinationClients.Consul().Session().RenewPeriodic(api.DefaultLockSessionTTL, session, nil, doneCh)
+			if err != nil {
+				log.Printf("[WARN] (runner) failed to renew session %s: %s", session, err)
+			}
+		}(renewCh)
+	}
+
+	lock, err := r.destinationClients.Consul().LockOpts(opts)
+	if err != nil {
+		select {
+		case r.ErrCh <- err:
+		case <-r.stopCh:
+		}
+		return
+	}
+
+	// Once the inherited session is gone, the lock is campaigned for with a
+	// session of its own, which the renewal destroys once stopped
+	forget := func() error {
+		if renewCh == nil {
+			return nil
+		}
+		close(renewCh)
+		renewCh = nil
+
+		opts.Session = ""
+		lock, err = r.destinationClients.Consul().LockOpts(opts)
+		return err
+	}
+
+	for {
+		log.Printf("[INFO] (runner) standing by for lock %q", key)
+		lostCh, err := lock.Lock(r.stopCh)
+		if err != nil {
+			log.Printf("[ERR] (runner) failed to acquire lock %q: %s", key, err)
+			if err := forget(); err != nil {
+				select {
+				case r.ErrCh <- err:
+				case <-r.stopCh:
+				}
+				return
+			}
+			select {
+			case <-time.After(haRetryInterval):
+				continue
+			case <-r.stopCh:
+				return
+			}
+		}
+
+		// The runner was stopped
+		if lostCh == nil {
+			return
+		}
+
+		log.Printf("[INFO] (runner) acquired lock %q, replicating", key)
+		r.setLeader(true)
+		select {
+		case r.leaderCh <- struct{}{}:
+		default:
+		}
+
+		select {
+		case <-lostCh:
+			log.Printf("[WARN] (runner) lost lock %q", key)
+			r.setLeader(false)
+
+			// The lock must be released before it can be acquired again
+			lock.Unlock()
+			if err := forget(); err != nil {
+				select {
+				case r.ErrCh <- err:
+				case <-r.stopCh:
+				}
+				return
+			}
+		case <-r.stopCh:
+			r.setLeader(false)
+
+			// The process replacing this one takes over the lock and its
+			// session, which the lock keeps renewing until this one exits
+			if r.handingOver() {
+				log.Printf("[INFO] (runner) handing over lock %q", key)
+				return
+			}
+
+			if err := lock.Unlock(); err != nil {
+				log.Printf("[WARN] (runner) failed to release lock %q: %s", key, err)
+			}
+			if renewCh != nil {
+				close(renewCh)
+			}
+			return
+		}
+	}
+}
@@ -0,0 +1,179 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: MPL-2.0
+
+package replicate
+
+import (
+	"log"
+
+	"github.com/hashicorp/consul/api"
+)
+
+// HandoverState is what a replicator hands over to the process of a new binary
+// replacing it, so the new process neither waits for another leader election
+// nor reads every prefix again before it replicates.
+type HandoverState struct {
+	// Groups are the states of the replication groups, keyed by name.
+	Groups map[string]*GroupHandover `json:"groups"`
+}
+
+// GroupHandover is the state of a replication group handed over.
+type GroupHandover struct {
+	// Session is the Consul session holding the HA lock of the group, if its
+	// runner was the leader. The new process holds the lock with it, and
+	// renews it from then on.
+	Session string `json:"session,omitempty"`
+
+	// Indexes are the source indexes the prefixes of the group were
+	// replicated up to, keyed by watch. The first query of a watch waits for a
+	// change since its index, instead of returning right away.
+	Indexes map[string]uint64 `json:"indexes,omitempty"`
+
+	// release releases the HA lock held with the session, for when the state
+	// cannot be handed over.
+	release func() error
+}
+
+// handover returns the state of the runner to hand over, and marks the runner
+// as handing over, so stopping it does not release the HA lock.
+func (r *Runner) handover() *GroupHandover {
+	h := &GroupHandover{Indexes: make(map[string]uint64)}
+
+	if r.haEnabled() && r.isLeader() {
+		pair, _, err := r.destinationClients.Consul().KV().Get(r.lockKey(), nil)
+		if err != nil {
+			log.Printf("[WARN] (runner) could not read lock %q to hand it over: %s", r.lockKey(), err)
+		} else if pair != nil && pair.Session != "" {
+			h.Session = pair.Session
+
+			client, key := r.destinationClients.Consul(), r.lockKey()
+			h.release = func() error {
+				// Releasing writes the flags, which must still mark a lock
+				_, _, err := client.KV().Release(&api.KVPair{
+					Key:     key,
+					Flags:   api.LockFlagValue,
+					Session: pair.Session,
+				}, nil)
+				return err
+			}
+		}
+	}
+
+	// The prefixes of a coalesced watch were replicated up to the index of
+	// the one furthest behind
+	if r.watched() {
+		for _, prefix := range r.activePrefixes() {
+			status, err := r.getStatus(prefix)
+			if err != nil {
+				log.Printf("[WARN] (runner) could not read the status of %s to hand it over: %s",
+					prefix.Dependency, err)
+				continue
+			}
+
+			id := prefix.Dependency.String()
+			r.RLock()
+			if d, ok := r.watches[id]; ok {
+				id = d.String()
+			}
+			r.RUnlock()
+			if index, ok := h.Indexes[id]; !ok || status.LastReplicated < index {
+				h.Indexes[id] = status.LastReplicated
+			}
+		}
+	}
+
+	r.Lock()
+	r.handedOver = true
+	r.Unlock()
+	return h
+}
+
+// inherit records the state handed over by the process this runner replaces.
+// It must be called before the runner is started.
+func (r *Runner) inherit(h *GroupHandover) {
+	r.inherited = h
+}
+
+// inheritedSession returns the session handed over with the HA lock, once.
+func (r *Runner) inheritedSession() string {
+	r.Lock()
+	defer r.Unlock()
+	if r.inherited == nil {
+		return ""
+	}
+	session := r.inherited.Session
+	r.inherited.Session = ""
+	return session
+}
+
+// inheritedIndex returns the index the prefixes of the watch with the given
+// ID were replicated up to by the process this runner replaces, if any.
+func (r *Runner) inheritedIndex(id string) uint64 {
+	if r.inherited == nil {
+		return 0
+	}
+	return r.inherited.Indexes[id]
+}
+
+// handingOver returns true if the runner hands its state over to another
+// process.
+func (r *Runner) handingOver() bool {
+	r.RLock()
+	defer r.RUnlock()
+	return r.handedOver
+}
+
+// Handover stops every group without releasing their HA locks or removing the
+// PID file, and returns their state for the process replacing this one.
+func (s *Supervisor) Handover() *HandoverState {
+	log.Printf("[INFO] (supervisor) handing over")
+
+	s.Lock()
+	defer s.Unlock()
+
+	state := &HandoverState{Groups: make(map[string]*GroupHandover)}
+	for name, g := range s.groups {
+		if r := g.currentRunner(); r != nil {
+			state.Groups[name] = r.handover()
+		}
+		s.stopGroup(g)
+		delete(s.groups, name)
+	}
+	return state
+}
+
+// Release releases the HA locks of the state, once it could not be handed
+// over, so the new process does not wait for their sessions to expire before
+// it replicates.
+func (s *HandoverState) Release() {
+	for name, g := range s.Groups {
+		if g.release == nil {
+			continue
+		}
+		if err := g.release(); err != nil {
+			log.Printf("[WARN] (supervisor) failed to release the lock of group %q: %s", name, err)
+			continue
+		}
+		log.Printf("[INFO] (supervisor) released the lock of group %q", name)
+	}
+}
+
+// Inherit records the state handed over by the process this supervisor
+// replaces. It must be called before the supervisor is started.
+func (s *Supervisor) Inherit(state *HandoverState) {
+	s.inheritedLock.Lock()
+	defer s.inheritedLock.Unlock()
+	s.inherited = state
+}
+
+// inheritedGroup returns the state handed over for the named group, once.
+func (s *Supervisor) inheritedGroup(name string) *GroupHandover {
+	s.inheritedLock.Lock()
+	defer s.inheritedLock.Unlock()
+	if s.inherited == nil {
+		return nil
+	}
+	h := s.inherited.Groups[name]
+	delete(s.inherited.Groups, name)
+	return h
+}
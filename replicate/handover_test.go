@@ -0,0 +1,39 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: MPL-2.0
+
+package replicate
+
+import (
+	"testing"
+)
+
+func TestRunner_inheritedSession(t *testing.T) {
+	r := &Runner{}
+	if act := r.inheritedSession(); act != "" {
+		t.Errorf("\nexp: %#v\nact: %#v", "", act)
+	}
+
+	// The session is only taken over by the first election
+	r.inherit(&GroupHandover{Session: "abcd"})
+	for _, exp := range []string{"abcd", ""} {
+		if act := r.inheritedSession(); act != exp {
+			t.Errorf("\nexp: %#v\nact: %#v", exp, act)
+		}
+	}
+}
+
+func TestSupervisor_inheritedGroup(t *testing.T) {
+	s := &Supervisor{}
+	if act := s.inheritedGroup("a"); act != nil {
+		t.Errorf("\nexp: %#v\nact: %#v", nil, act)
+	}
+
+	// A group restarted after a failure starts fresh
+	h := &GroupHandover{Session: "abcd"}
+	s.Inherit(&HandoverState{Groups: map[string]*GroupHandover{"a": h}})
+	for _, exp := range []*GroupHandover{h, nil} {
+		if act := s.inheritedGroup("a"); act != exp {
+			t.Errorf("\nexp: %#v\nact: %#v", exp, act)
+		}
+	}
+}
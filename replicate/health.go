@@ -0,0 +1,166 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: MPL-2.0
+
+package replicate
+
+import (
+	"log"
+	"strings"
+	"time"
+
+	"github.com/hashicorp/consul-template/config"
+	dep "github.com/hashicorp/consul-template/dependency"
+)
+
+// prefixHealth is the supervision state of a prefix which failed.
+type prefixHealth struct {
+	// failures is the number of consecutive failures, and err the last one.
+	failures int
+	err      error
+
+	// retryAt is when the prefix is replicated again.
+	retryAt time.Time
+}
+
+// restartEnabled returns true if failed prefixes are retried while the others
+// keep replicating, instead of stopping the runner. Single passes always stop
+// at the first failure, so it is reported.
+func (r *Runner) restartEnabled() bool {
+	return config.BoolVal(r.config.Restart.Enabled) && !r.once && r.watched()
+}
+
+// fail records a failure of the prefix, and returns the delay before it is
+// retried, which doubles with every consecutive failure.
+func (r *Runner) fail(prefix *PrefixConfig, err error) time.Duration {
+	r.healthLock.Lock()
+	defer r.healthLock.Unlock()
+
+	id := prefix.Dependency.String()
+	h, ok := r.health[id]
+	if !ok {
+		h = &prefixHealth{}
+		r.health[id] = h
+	}
+	h.failures++
+	h.err = err
+
+	delay := restartBackoff(r.config.Restart, h.failures)
+	h.retryAt = time.Now().Add(delay)
+	log.Printf("[WARN] (runner) %s failed %d time(s) in a row, retrying in %s: %s",
+		id, h.failures, delay, err)
+	return delay
+}
+
+// restartBackoff returns the delay before a prefix is retried after the given
+// number of consecutive failures.
+func restartBackoff(c *RestartConfig, failures int) time.Duration {
+	delay, max := config.TimeDurationVal(c.Backoff), config.TimeDurationVal(c.MaxBackoff)
+	for i := 1; i < failures && delay < max; i++ {
+		delay *= 2
+	}
+	if delay > max {
+		delay = max
+	}
+	return delay
+}
+
+// healthy clears the failures of the prefix once it replicated successfully.
+func (r *Runner) healthy(prefix *PrefixConfig) {
+	r.healthLock.Lock()
+	defer r.healthLock.Unlock()
+
+	id := prefix.Dependency.String()
+	if h, ok := r.health[id]; ok {
+		log.Printf("[INFO] (runner) %s recovered after %d failure(s)", id, h.failures)
+		delete(r.health, id)
+	}
+}
+
+// backingOff returns true if the prefix failed and is not due to be retried.
+func (r *Runner) backingOff(prefix *PrefixConfig) bool {
+	r.healthLock.Lock()
+	defer r.healthLock.Unlock()
+
+	h, ok := r.health[prefix.Dependency.String()]
+	return ok && time.Now().Before(h.retryAt)
+}
+
+// healthOf returns the number of consecutive failures of the prefix, and the
+// last one.
+func (r *Runner) healthOf(prefix *PrefixConfig) (int, error) {
+	r.healthLock.Lock()
+	defer r.healthLock.Unlock()
+
+	h, ok := r.health[prefix.Dependency.String()]
+	if !ok {
+		return 0, nil
+	}
+	return h.failures, h.err
+}
+
+// retryAfter runs a replication pass after the delay, to retry the prefixes
+// which failed.
+func (r *Runner) retryAfter(delay time.Duration) {
+	time.AfterFunc(delay, func() {
+		select {
+		case r.retryCh <- struct{}{}:
+		default:
+		}
+	})
+}
+
+// restartWatch handles an error of the watcher, which stopped the watch that
+// failed. The prefixes of the watch are marked as failed, and the watch is
+// restarted after their backoff. It returns false if the error cannot be
+// attributed to a watch.
+func (r *Runner) restartWatch(err error) bool {
+	var d *dep.KVListQuery
+	var prefixes []*PrefixConfig
+
+	// Errors of a query are prefixed with the query
+	r.RLock()
+	for _, prefix := range r.prefixes {
+		w, ok := r.watches[prefix.Dependency.String()]
+		if ok && strings.HasPrefix(err.Error(), w.String()+":") {
+			d = w
+			prefixes = append(prefixes, prefix)
+		}
+	}
+	r.RUnlock()
+	if d == nil {
+		return false
+	}
+
+	var delay time.Duration
+	for _, prefix := range prefixes {
+		if backoff := r.fail(prefix, err); backoff > delay {
+			delay = backoff
+		}
+	}
+
+	time.AfterFunc(delay, func() {
+		r.Lock()
+		defer r.Unlock()
+
+		select {
+		case <-r.stopCh:
+			return
+		default:
+		}
+
+		// The watch may have been stopped by discovery in the meantime
+		for _, w := range r.watches {
+			if w.String() != d.String() {
+				continue
+			}
+
+			log.Printf("[INFO] (runner) restarting watch %s", d)
+			r.watcher.Remove(d)
+			if _, err := r.watcher.Add(r.blockingQuery(d, watchBudget(d, r.prefixes, r.watches), watchPartition(d, r.prefixes))); err != nil {
+				log.Printf("[ERR] (runner) failed to restart watch %s: %s", d, err)
+			}
+			return
+		}
+	})
+	return true
+}
@@ -0,0 +1,96 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: MPL-2.0
+
+package replicate
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/hashicorp/consul-template/config"
+)
+
+func TestRestartBackoff(t *testing.T) {
+	c := &RestartConfig{
+		Backoff:    config.TimeDuration(1 * time.Second),
+		MaxBackoff: config.TimeDuration(5 * time.Second),
+	}
+
+	cases := []struct {
+		failures int
+		exp      time.Duration
+	}{
+		{1, 1 * time.Second},
+		{2, 2 * time.Second},
+		{3, 4 * time.Second},
+		{4, 5 * time.Second},
+		{100, 5 * time.Second},
+	}
+
+	for i, tc := range cases {
+		t.Run(fmt.Sprintf("%d_%d", i, tc.failures), func(t *testing.T) {
+			if act := restartBackoff(c, tc.failures); act != tc.exp {
+				t.Errorf("\nexp: %#v\nact: %#v", tc.exp, act)
+			}
+		})
+	}
+}
+
+func TestRunner_restartWatch(t *testing.T) {
+	var prefixes PrefixConfigs
+	for _, s := range []string{"a@dc1", "b@dc1"} {
+		prefix, err := ParsePrefixConfig(s)
+		if err != nil {
+			t.Fatal(err)
+		}
+		prefixes = append(prefixes, prefix)
+	}
+
+	c := DefaultConfig().Merge(&Config{
+		Prefixes: &prefixes,
+		Restart: &RestartConfig{
+			Backoff: config.TimeDuration(1 * time.Hour),
+		},
+	})
+	c.Finalize()
+
+	r, err := NewRunner(c, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Stop()
+	r.prefixes = *c.Prefixes
+	r.watches = r.watchesFor(r.prefixes)
+
+	if !r.restartEnabled() {
+		t.Fatal("expected restarts to be enabled")
+	}
+
+	// Errors of other queries are not attributed to a watch
+	if r.restartWatch(errors.New("kv.list(c@dc1): Unexpected response code: 403")) {
+		t.Errorf("expected the error not to be attributed")
+	}
+
+	err = errors.New("kv.list(a@dc1): Unexpected response code: 403 (Permission denied)")
+	if !r.restartWatch(err) {
+		t.Fatal("expected the error to be attributed")
+	}
+
+	a, b := r.prefixes[0], r.prefixes[1]
+	if !r.backingOff(a) || r.backingOff(b) {
+		t.Errorf("expected only the failed prefix to back off")
+	}
+
+	failures, lastErr := r.healthOf(a)
+	if failures != 1 || lastErr != err {
+		t.Errorf("expected 1 failure with %q, got %d with %v", err, failures, lastErr)
+	}
+
+	// A successful pass clears the failures
+	r.healthy(a)
+	if failures, _ := r.healthOf(a); failures != 0 || r.backingOff(a) {
+		t.Errorf("expected the prefix to be healthy")
+	}
+}
@@ -0,0 +1,125 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: MPL-2.0
+
+package replicate
+
+import (
+	"fmt"
+	"hash/fnv"
+	"net/http"
+	"os"
+
+	"github.com/hashicorp/consul-replicate/version"
+	"github.com/hashicorp/consul-template/config"
+)
+
+const (
+	// identityShift is the position of the identity marker in the flags of a
+	// key, below the origin marker.
+	identityShift = 32
+
+	// identityMask covers the bits of the flags which hold the identity marker.
+	identityMask uint64 = 0xffff << identityShift
+)
+
+// identityMarker returns the marker stamped on the flags of keys written by
+// the named instance. A marker is never zero, which is left for keys which
+// were not stamped.
+func identityMarker(name string) uint64 {
+	h := fnv.New32a()
+	h.Write([]byte(name))
+	marker := uint64(h.Sum32() & 0xffff)
+	if marker == 0 {
+		marker = 1
+	}
+	return marker << identityShift
+}
+
+// identityName returns the name of the instance, which defaults to the
+// hostname.
+func (r *Runner) identityName() string {
+	if name := config.StringVal(r.config.Identity.Name); name != "" {
+		return name
+	}
+	hostname, _ := os.Hostname()
+	return hostname
+}
+
+// userAgent returns the User-Agent header of every request to the clusters.
+func (r *Runner) userAgent() string {
+	if ua := config.StringVal(r.config.Identity.UserAgent); ua != "" {
+		return ua
+	}
+	name := version.Name
+	if name == "" {
+		name = "consul-replicate"
+	}
+	return fmt.Sprintf("%s/%s (%s)", name, version.Version, r.identityName())
+}
+
+// stampFlagsEnabled returns true if replicated keys are stamped with the
+// identity marker of the instance.
+func (r *Runner) stampFlagsEnabled() bool {
+	return config.BoolVal(r.config.Identity.StampFlags)
+}
+
+// applicationFlags returns the flags of the source key without the identity
+// marker of the replicator which wrote it. A replicator stamping its marker
+// records itself as the writer in the metadata of the value, so any other
+// flags in the bits of the marker belong to applications.
+func applicationFlags(flags uint64, value []byte, m *KeyMetadata) uint64 {
+	if m.describes(value) && m.Writer != "" {
+		return flags &^ identityMask
+	}
+	return flags
+}
+
+// checkIdentityFlags returns an error if stamping the identity marker would
+// overwrite flags applications set in its bits. Locks and semaphores are never
+// stamped.
+func (r *Runner) checkIdentityFlags(flags uint64, value []byte, m *KeyMetadata) error {
+	if !r.stampFlagsEnabled() || lockFlags(flags) {
+		return nil
+	}
+	if applicationFlags(flags, value, m)&identityMask != 0 {
+		return fmt.Errorf("flags %#x use bits 32-47, which hold the identity marker", flags)
+	}
+	return nil
+}
+
+// stampIdentity returns the flags with the identity marker of the instance,
+// replacing any marker of the replicator which wrote the source key, if
+// identity stamping is enabled.
+func (r *Runner) stampIdentity(flags uint64) uint64 {
+	if !r.stampFlagsEnabled() {
+		return flags
+	}
+	return flags&^identityMask | identityMarker(r.identityName())
+}
+
+// userAgentTransport sets the User-Agent header of every request.
+type userAgentTransport struct {
+	base      http.RoundTripper
+	userAgent string
+}
+
+func (t *userAgentTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	req = req.Clone(req.Context())
+	req.Header.Set("User-Agent", t.userAgent)
+	return t.base.RoundTrip(req)
+}
+
+// setUserAgent makes the HTTP client of a Consul client send the given
+// User-Agent header. The Consul API client has no option for headers, so the
+// transport of the HTTP client is wrapped in place.
+func setUserAgent(hc *http.Client, userAgent string) {
+	if t, ok := hc.Transport.(*userAgentTransport); ok {
+		t.userAgent = userAgent
+		return
+	}
+	base := hc.Transport
+	if base == nil {
+		base = http.DefaultTransport
+	}
+	hc.Transport = &userAgentTransport{base: base, userAgent: userAgent}
+}
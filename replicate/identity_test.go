@@ -0,0 +1,153 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: MPL-2.0
+
+package replicate
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/hashicorp/consul-template/config"
+	"github.com/hashicorp/consul/api"
+)
+
+func TestStampIdentity(t *testing.T) {
+	cases := []struct {
+		name  string
+		stamp bool
+		flags uint64
+		exp   uint64
+	}{
+		{
+			"disabled",
+			false,
+			identityMarker("b") | 42,
+			identityMarker("b") | 42,
+		},
+		{
+			"unmarked",
+			true,
+			42,
+			identityMarker("a") | 42,
+		},
+		{
+			"replaces_marker",
+			true,
+			identityMarker("b") | 42 | 1<<48,
+			identityMarker("a") | 42 | 1<<48,
+		},
+	}
+
+	for i, tc := range cases {
+		t.Run(fmt.Sprintf("%d_%s", i, tc.name), func(t *testing.T) {
+			r := &Runner{config: &Config{Identity: &IdentityConfig{
+				Name:       config.String("a"),
+				StampFlags: config.Bool(tc.stamp),
+			}}}
+			act := r.stampIdentity(tc.flags)
+			if act != tc.exp {
+				t.Errorf("\nexp: %#v\nact: %#v", tc.exp, act)
+			}
+		})
+	}
+}
+
+func TestCheckIdentityFlags(t *testing.T) {
+	stamped := &KeyMetadata{ValueHash: valueHash([]byte("v")), Writer: "b"}
+	cases := []struct {
+		name  string
+		stamp bool
+		flags uint64
+		value string
+		meta  *KeyMetadata
+		err   bool
+	}{
+		{
+			"disabled",
+			false,
+			1 << 40,
+			"v",
+			nil,
+			false,
+		},
+		{
+			"free",
+			true,
+			42,
+			"v",
+			nil,
+			false,
+		},
+		{
+			"application_flags",
+			true,
+			1<<40 | 42,
+			"v",
+			nil,
+			true,
+		},
+		{
+			"stamped",
+			true,
+			identityMarker("b") | 42,
+			"v",
+			stamped,
+			false,
+		},
+		{
+			"written_again",
+			true,
+			identityMarker("b") | 42,
+			"w",
+			stamped,
+			true,
+		},
+		{
+			"lock",
+			true,
+			api.LockFlagValue,
+			"v",
+			nil,
+			false,
+		},
+	}
+
+	for i, tc := range cases {
+		t.Run(fmt.Sprintf("%d_%s", i, tc.name), func(t *testing.T) {
+			r := &Runner{config: &Config{Identity: &IdentityConfig{
+				Name:       config.String("a"),
+				StampFlags: config.Bool(tc.stamp),
+			}}}
+			err := r.checkIdentityFlags(tc.flags, []byte(tc.value), tc.meta)
+			if (err != nil) != tc.err {
+				t.Fatal(err)
+			}
+		})
+	}
+}
+
+func TestSetUserAgent(t *testing.T) {
+	var act string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		act = r.Header.Get("User-Agent")
+		w.Write([]byte(`"127.0.0.1:8300"`))
+	}))
+	defer srv.Close()
+
+	hc := &http.Client{}
+	client, err := api.NewClient(&api.Config{Address: srv.URL, HttpClient: hc})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, ua := range []string{"replicator/1", "replicator/2"} {
+		setUserAgent(hc, ua)
+		if _, err := client.Status().Leader(); err != nil {
+			t.Fatal(err)
+		}
+		if act != ua {
+			t.Errorf("\nexp: %#v\nact: %#v", ua, act)
+		}
+	}
+}
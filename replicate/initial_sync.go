@@ -0,0 +1,103 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: MPL-2.0
+
+package replicate
+
+import (
+	"encoding/json"
+	"log"
+	"strings"
+	"time"
+
+	"github.com/hashicorp/consul-template/config"
+	"github.com/hashicorp/consul/api"
+)
+
+// InitialSync is the barrier key written once the initial sync of a prefix is
+// complete, so deployment automation can wait for the destination to be
+// primed before starting applications.
+type InitialSync struct {
+	// Source, Datacenter and Destination identify the prefix.
+	Source, Datacenter, Destination string
+
+	// SourceIndex is the index of the source the initial sync replicated, and
+	// KeyCount the number of keys it replicated into the destination.
+	SourceIndex uint64
+	KeyCount    int
+
+	// Timestamp is the time the initial sync completed.
+	Timestamp time.Time
+}
+
+// markSynced writes the barrier key of the prefix after a pass which
+// replicated every key, unless it was already written.
+func (r *Runner) markSynced(prefix *PrefixConfig, index uint64, keys int) error {
+	id := prefix.Dependency.String()
+	r.syncedLock.Lock()
+	_, ok := r.synced[id]
+	r.syncedLock.Unlock()
+	if ok {
+		return nil
+	}
+
+	backend := r.statusBackend(prefix)
+	pair, err := backend.Get(r.initialSyncPath(prefix))
+	if err != nil {
+		return err
+	}
+	if pair == nil {
+		enc, err := json.MarshalIndent(&InitialSync{
+			Source:      config.StringVal(prefix.Source),
+			Datacenter:  config.StringVal(prefix.Datacenter),
+			Destination: config.StringVal(prefix.Destination),
+			SourceIndex: index,
+			KeyCount:    keys,
+			Timestamp:   time.Now().UTC(),
+		}, "", "  ")
+		if err != nil {
+			return err
+		}
+		if err := backend.Put(&api.KVPair{
+			Key:   r.initialSyncPath(prefix),
+			Value: enc,
+		}); err != nil {
+			return err
+		}
+		log.Printf("[INFO] (runner) initial sync of %s complete", id)
+	}
+
+	r.syncedLock.Lock()
+	r.synced[id] = struct{}{}
+	r.syncedLock.Unlock()
+	return nil
+}
+
+// initialSyncComplete returns true if the barrier key of the prefix was
+// written, by this runner or an earlier one.
+func (r *Runner) initialSyncComplete(prefix *PrefixConfig) (bool, error) {
+	id := prefix.Dependency.String()
+	r.syncedLock.Lock()
+	_, ok := r.synced[id]
+	r.syncedLock.Unlock()
+	if ok {
+		return true, nil
+	}
+
+	pair, err := r.statusBackend(prefix).Get(r.initialSyncPath(prefix))
+	if err != nil || pair == nil {
+		return false, err
+	}
+
+	r.syncedLock.Lock()
+	r.synced[id] = struct{}{}
+	r.syncedLock.Unlock()
+	return true, nil
+}
+
+// initialSyncPath returns the path of the barrier key of a prefix, which lives
+// next to the status key of the prefix.
+func (r *Runner) initialSyncPath(prefix *PrefixConfig) string {
+	status := r.statusPath(prefix)
+	i := strings.LastIndex(status, "/")
+	return status[:i] + "/initial-sync-complete" + status[i:]
+}
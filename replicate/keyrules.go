@@ -0,0 +1,60 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: MPL-2.0
+
+package replicate
+
+import (
+	"fmt"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/hashicorp/consul-template/config"
+	"golang.org/x/text/unicode/norm"
+)
+
+// applyKeyRules normalizes the destination key according to the rules of the
+// prefix. Only the part of the key below the destination is normalized, so the
+// key stays within the destination. A rejected key returns an error with the
+// reason.
+func applyKeyRules(prefix *PrefixConfig, key string) (string, error) {
+	rules := prefix.KeyRules
+	if !rules.Enabled() {
+		return key, nil
+	}
+
+	dest := config.StringVal(prefix.Destination)
+	if !strings.HasPrefix(key, dest) {
+		dest = ""
+	}
+	rel := key[len(dest):]
+
+	if config.BoolVal(rules.RejectControl) {
+		if !utf8.ValidString(rel) {
+			return "", fmt.Errorf("invalid UTF-8")
+		}
+		if i := strings.IndexFunc(rel, unicode.IsControl); i >= 0 {
+			return "", fmt.Errorf("control character %q at byte %d", rel[i], i)
+		}
+	}
+
+	if config.BoolVal(rules.NFC) {
+		rel = norm.NFC.String(rel)
+	}
+	if config.BoolVal(rules.Lowercase) {
+		rel = strings.ToLower(rel)
+	}
+	if config.BoolVal(rules.CollapseSlashes) {
+		for strings.Contains(rel, "//") {
+			rel = strings.Replace(rel, "//", "/", -1)
+		}
+	}
+
+	for _, reject := range rules.RejectPrefixes {
+		if strings.HasPrefix(strings.TrimLeft(rel, "/"), reject) {
+			return "", fmt.Errorf("rejected prefix %q", reject)
+		}
+	}
+
+	return dest + rel, nil
+}
@@ -0,0 +1,96 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: MPL-2.0
+
+package replicate
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/hashicorp/consul-template/config"
+)
+
+func TestApplyKeyRules(t *testing.T) {
+	cases := []struct {
+		name  string
+		rules *KeyRulesConfig
+		key   string
+		exp   string
+		err   bool
+	}{
+		{
+			"disabled",
+			&KeyRulesConfig{},
+			"Dest/A//B",
+			"Dest/A//B",
+			false,
+		},
+		{
+			"lowercase",
+			&KeyRulesConfig{Lowercase: config.Bool(true)},
+			"Dest/A/B",
+			"Dest/a/b",
+			false,
+		},
+		{
+			"collapse_slashes",
+			&KeyRulesConfig{CollapseSlashes: config.Bool(true)},
+			"Dest/a///b//c",
+			"Dest/a/b/c",
+			false,
+		},
+		{
+			"nfc",
+			&KeyRulesConfig{NFC: config.Bool(true)},
+			"Dest/cafe\u0301",
+			"Dest/caf\u00e9",
+			false,
+		},
+		{
+			"control",
+			&KeyRulesConfig{RejectControl: config.Bool(true)},
+			"Dest/a\tb",
+			"",
+			true,
+		},
+		{
+			"invalid_utf8",
+			&KeyRulesConfig{RejectControl: config.Bool(true)},
+			"Dest/a\xffb",
+			"",
+			true,
+		},
+		{
+			"reject_prefix",
+			&KeyRulesConfig{RejectPrefixes: []string{"tmp/"}},
+			"Dest/tmp/a",
+			"",
+			true,
+		},
+		{
+			"reject_prefix_other",
+			&KeyRulesConfig{RejectPrefixes: []string{"tmp/"}},
+			"Dest/app/tmp/a",
+			"Dest/app/tmp/a",
+			false,
+		},
+	}
+
+	for i, tc := range cases {
+		t.Run(fmt.Sprintf("%d_%s", i, tc.name), func(t *testing.T) {
+			tc.rules.Finalize()
+			prefix := &PrefixConfig{
+				Destination: config.String("Dest"),
+				KeyRules:    tc.rules,
+			}
+
+			act, err := applyKeyRules(prefix, tc.key)
+			if (err != nil) != tc.err {
+				t.Fatal(err)
+			}
+			if act != tc.exp {
+				t.Errorf("\nexp: %#v\nact: %#v", tc.exp, act)
+			}
+		})
+	}
+}
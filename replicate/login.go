@@ -0,0 +1,188 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: MPL-2.0
+
+package replicate
+
+import (
+	"fmt"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/hashicorp/consul-template/config"
+	"github.com/hashicorp/consul/api"
+	"github.com/pkg/errors"
+)
+
+const (
+	// loginMinInterval is the minimum time between logins, so a token which
+	// already expired is not renewed in a busy loop.
+	loginMinInterval = 1 * time.Second
+
+	// loginRetryInterval is the maximum time between logins after a failed
+	// one.
+	loginRetryInterval = 10 * time.Second
+)
+
+// login obtains the ACL token of a client by logging in to an auth method of
+// its cluster, and swaps every renewed token into the token transport of the
+// client, so watches, sessions and the HA lock are kept.
+type login struct {
+	name      string
+	client    *api.Client
+	config    *LoginClientConfig
+	transport *tokenTransport
+
+	sync.Mutex
+
+	// current and previous are the secrets of the current token and of the
+	// token it replaced, which requests in flight may still use, and expires
+	// is the expiration time of the current token, if any.
+	current  string
+	previous string
+	expires  time.Time
+}
+
+// login logs in with the bearer token, which is read again so it can be
+// rotated, and swaps the token it obtains into the transport. The token
+// replaced by the previous login is logged out.
+func (l *login) login() error {
+	method := config.StringVal(l.config.AuthMethod)
+	bearer, err := readToken(config.StringVal(l.config.BearerTokenFile))
+	if err != nil {
+		return errors.Wrap(err, "reading bearer token")
+	}
+
+	token, _, err := l.client.ACL().Login(&api.ACLLoginParams{
+		AuthMethod:  method,
+		BearerToken: bearer,
+		Meta:        l.config.Meta,
+	}, nil)
+	if err != nil {
+		return errors.Wrapf(err, "logging in to auth method %q", method)
+	}
+
+	l.Lock()
+	stale := l.previous
+	l.previous, l.current = l.current, token.SecretID
+	l.expires = time.Time{}
+	if token.ExpirationTime != nil {
+		l.expires = *token.ExpirationTime
+	}
+	l.Unlock()
+
+	l.transport.set(token.SecretID)
+	log.Printf("[INFO] (runner) logged in to auth method %q of the %s cluster "+
+		"with token %q", method, l.name, token.AccessorID)
+
+	// Requests made with the token before the previous login are long done
+	if stale != "" {
+		if _, err := l.client.ACL().Logout(&api.WriteOptions{Token: stale}); err != nil {
+			log.Printf("[WARN] (runner) could not log out a previous %s token: %s",
+				l.name, err)
+		}
+	}
+	return nil
+}
+
+// next returns the time until the next login, which is the interval, or half
+// of the remaining lifetime of the current token if it expires sooner.
+func (l *login) next(now time.Time) time.Duration {
+	l.Lock()
+	expires := l.expires
+	l.Unlock()
+
+	next := config.TimeDurationVal(l.config.Interval)
+	if !expires.IsZero() {
+		if half := expires.Sub(now) / 2; half < next {
+			next = half
+		}
+	}
+	if next < loginMinInterval {
+		next = loginMinInterval
+	}
+	return next
+}
+
+// newLogin creates the login of the client, if login is enabled, and makes
+// the client send the tokens it obtains in place of the configured token.
+func newLogin(name string, clients *clientSet, c *LoginClientConfig, token, file *string) (*login, error) {
+	if !config.BoolVal(c.Enabled) {
+		return nil, nil
+	}
+	if config.StringVal(c.AuthMethod) == "" {
+		return nil, fmt.Errorf("login: the %s auth method is missing", name)
+	}
+	if config.StringVal(file) != "" {
+		return nil, fmt.Errorf("login: the %s token cannot be both obtained by "+
+			"login and read from a token rotation file", name)
+	}
+
+	transport := setTokens(clients.httpClient, config.StringVal(token))
+	return &login{name: name, client: clients.Consul(), config: c, transport: transport}, nil
+}
+
+// initLogins prepares the logins of the clients, if any. The clients only log
+// in when the runner starts.
+func (r *Runner) initLogins() error {
+	var err error
+	if r.sourceLogin, err = newLogin("source", r.clients, r.config.Login.Source,
+		r.config.Consul.Token, r.config.TokenRotation.SourceFile); err != nil {
+		return err
+	}
+	if r.destinationLogin, err = newLogin("destination", r.destinationClients,
+		r.config.Login.Destination, r.config.DestinationConsul.Token,
+		r.config.TokenRotation.DestinationFile); err != nil {
+		return err
+	}
+	return nil
+}
+
+// logins returns the logins of the clients.
+func (r *Runner) logins() []*login {
+	var logins []*login
+	for _, l := range []*login{r.sourceLogin, r.destinationLogin} {
+		if l != nil {
+			logins = append(logins, l)
+		}
+	}
+	return logins
+}
+
+// Login logs the clients in to their auth methods, if login is enabled.
+func (r *Runner) Login() error {
+	for _, l := range r.logins() {
+		if err := l.login(); err != nil {
+			return fmt.Errorf("login: %s: %s", l.name, err)
+		}
+	}
+	return nil
+}
+
+// renewLogin logs the client in again before its token expires, keeping the
+// current token while login fails. This function blocks until the runner is
+// stopped.
+func (r *Runner) renewLogin(l *login) {
+	var failed bool
+	for {
+		wait := l.next(time.Now())
+		if failed && wait > loginRetryInterval {
+			wait = loginRetryInterval
+		}
+
+		timer := time.NewTimer(wait)
+		select {
+		case <-timer.C:
+		case <-r.stopCh:
+			timer.Stop()
+			return
+		}
+
+		failed = false
+		if err := l.login(); err != nil {
+			log.Printf("[WARN] (runner) failed to renew the %s token, keeping the "+
+				"current one: %s", l.name, err)
+			failed = true
+		}
+	}
+}
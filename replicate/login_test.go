@@ -0,0 +1,233 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: MPL-2.0
+
+package replicate
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/hashicorp/consul-template/config"
+	"github.com/hashicorp/consul/api"
+)
+
+func TestLogin_login(t *testing.T) {
+	var (
+		mu      sync.Mutex
+		logins  int
+		bearers []string
+		logouts []string
+		act     string
+	)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+
+		switch r.URL.Path {
+		case "/v1/acl/login":
+			var params api.ACLLoginParams
+			if err := json.NewDecoder(r.Body).Decode(&params); err != nil {
+				t.Error(err)
+			}
+			logins++
+			bearers = append(bearers, params.BearerToken)
+			fmt.Fprintf(w, `{"AccessorID":"accessor-%d","SecretID":"secret-%d"}`, logins, logins)
+		case "/v1/acl/logout":
+			logouts = append(logouts, r.Header.Get("X-Consul-Token"))
+		default:
+			act = r.Header.Get("X-Consul-Token")
+			fmt.Fprint(w, `"10.0.0.1:8300"`)
+		}
+	}))
+	defer srv.Close()
+
+	cc := config.DefaultConsulConfig()
+	cc.Address = config.String(srv.URL)
+	cc.Finalize()
+	clients, err := newClientSet(cc, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	path := filepath.Join(t.TempDir(), "token")
+	c := &LoginClientConfig{
+		AuthMethod:      config.String("kubernetes"),
+		BearerTokenFile: config.String(path),
+	}
+	c.Finalize()
+	l, err := newLogin("source", clients, c, config.String(""), config.String(""))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cases := []struct {
+		name    string
+		bearer  string
+		err     bool
+		exp     string
+		logouts []string
+	}{
+		{
+			"first",
+			"jwt-1\n",
+			false,
+			"secret-1",
+			nil,
+		},
+		{
+			"renewed",
+			"jwt-2",
+			false,
+			"secret-2",
+			nil,
+		},
+		{
+			"stale_logged_out",
+			"jwt-3",
+			false,
+			"secret-3",
+			[]string{"secret-1"},
+		},
+		{
+			"empty_bearer",
+			"",
+			true,
+			"secret-3",
+			[]string{"secret-1"},
+		},
+	}
+
+	for i, tc := range cases {
+		t.Run(fmt.Sprintf("%d_%s", i, tc.name), func(t *testing.T) {
+			if err := os.WriteFile(path, []byte(tc.bearer), 0o600); err != nil {
+				t.Fatal(err)
+			}
+
+			err := l.login()
+			if (err != nil) != tc.err {
+				t.Fatal(err)
+			}
+			if _, err := clients.Consul().Status().Leader(); err != nil {
+				t.Fatal(err)
+			}
+
+			mu.Lock()
+			defer mu.Unlock()
+			if act != tc.exp {
+				t.Errorf("\nexp: %#v\nact: %#v", tc.exp, act)
+			}
+			if !reflect.DeepEqual(tc.logouts, logouts) {
+				t.Errorf("\nexp: %#v\nact: %#v", tc.logouts, logouts)
+			}
+		})
+	}
+
+	if exp := []string{"jwt-1", "jwt-2", "jwt-3"}; !reflect.DeepEqual(exp, bearers) {
+		t.Errorf("\nexp: %#v\nact: %#v", exp, bearers)
+	}
+}
+
+func TestLogin_next(t *testing.T) {
+	now := time.Now()
+
+	cases := []struct {
+		name    string
+		expires time.Time
+		exp     time.Duration
+	}{
+		{
+			"no_expiration",
+			time.Time{},
+			DefaultLoginInterval,
+		},
+		{
+			"expires_later",
+			now.Add(4 * time.Hour),
+			DefaultLoginInterval,
+		},
+		{
+			"expires_sooner",
+			now.Add(10 * time.Minute),
+			5 * time.Minute,
+		},
+		{
+			"expired",
+			now.Add(-time.Minute),
+			loginMinInterval,
+		},
+	}
+
+	for i, tc := range cases {
+		t.Run(fmt.Sprintf("%d_%s", i, tc.name), func(t *testing.T) {
+			c := DefaultLoginClientConfig()
+			c.Finalize()
+			l := &login{config: c, expires: tc.expires}
+
+			if act := l.next(now); act != tc.exp {
+				t.Errorf("\nexp: %#v\nact: %#v", tc.exp, act)
+			}
+		})
+	}
+}
+
+func TestNewLogin(t *testing.T) {
+	cases := []struct {
+		name string
+		c    *LoginClientConfig
+		file string
+		err  bool
+	}{
+		{
+			"disabled",
+			&LoginClientConfig{},
+			"",
+			false,
+		},
+		{
+			"enabled",
+			&LoginClientConfig{AuthMethod: config.String("kubernetes")},
+			"",
+			false,
+		},
+		{
+			"missing_auth_method",
+			&LoginClientConfig{Enabled: config.Bool(true)},
+			"",
+			true,
+		},
+		{
+			"token_file",
+			&LoginClientConfig{AuthMethod: config.String("kubernetes")},
+			"/etc/consul/token",
+			true,
+		},
+	}
+
+	for i, tc := range cases {
+		t.Run(fmt.Sprintf("%d_%s", i, tc.name), func(t *testing.T) {
+			cc := config.DefaultConsulConfig()
+			cc.Finalize()
+			clients, err := newClientSet(cc, nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			tc.c.Finalize()
+
+			l, err := newLogin("source", clients, tc.c, config.String(""), config.String(tc.file))
+			if (err != nil) != tc.err {
+				t.Fatal(err)
+			}
+			if exp := config.BoolVal(tc.c.Enabled) && !tc.err; (l != nil) != exp {
+				t.Errorf("\nexp: %#v\nact: %#v", exp, l != nil)
+			}
+		})
+	}
+}
@@ -0,0 +1,99 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: MPL-2.0
+
+package replicate
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/hashicorp/consul-replicate/version"
+	"github.com/hashicorp/consul/api"
+)
+
+// Manifest is written after every replication pass of a prefix so consumers
+// and monitoring can verify they are reading a complete, consistent copy.
+type Manifest struct {
+	// Source and Destination are the given and final destination.
+	Source, Destination string
+
+	// KeyCount is the number of keys replicated into the destination.
+	KeyCount int
+
+	// TreeHash is the hash of the replicated tree, as computed by TreeHash.
+	TreeHash string
+
+	// SourceIndex is the index of the source data the pass replicated.
+	SourceIndex uint64
+
+	// Version is the version of Consul Replicate which wrote the manifest.
+	Version string
+
+	// Timestamp is the time the pass completed.
+	Timestamp time.Time
+}
+
+// TreeHash returns a hash of the given destination keys and values. Keys are
+// sorted, and each key and value is followed by a NUL byte, so the hash can be
+// recomputed by reading the destination prefix.
+func TreeHash(tree map[string][]byte) string {
+	keys := make([]string, 0, len(tree))
+	for k := range tree {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	h := sha256.New()
+	for _, k := range keys {
+		h.Write([]byte(k))
+		h.Write([]byte{0})
+		h.Write(tree[k])
+		h.Write([]byte{0})
+	}
+	return "sha256:" + hex.EncodeToString(h.Sum(nil))
+}
+
+// setManifest is used to write the manifest of a pass.
+func (r *Runner) setManifest(prefix *PrefixConfig, m *Manifest) error {
+	m.Version = version.Version
+
+	enc, err := json.MarshalIndent(m, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	return r.statusBackend(prefix).Put(&api.KVPair{
+		Key:   r.manifestPath(prefix),
+		Value: enc,
+	})
+}
+
+// getManifest is used to read the manifest of the last pass of a prefix. It
+// returns nil if the prefix was never replicated.
+func (r *Runner) getManifest(prefix *PrefixConfig) (*Manifest, error) {
+	pair, err := r.statusBackend(prefix).Get(r.manifestPath(prefix))
+	if err != nil {
+		return nil, err
+	}
+	if pair == nil {
+		return nil, nil
+	}
+
+	var m Manifest
+	if err := json.Unmarshal(pair.Value, &m); err != nil {
+		return nil, err
+	}
+	return &m, nil
+}
+
+// manifestPath returns the path of the manifest key, which lives next to the
+// status key of the prefix.
+func (r *Runner) manifestPath(prefix *PrefixConfig) string {
+	status := r.statusPath(prefix)
+	i := strings.LastIndex(status, "/")
+	return status[:i] + "/manifests" + status[i:]
+}
@@ -0,0 +1,34 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: MPL-2.0
+
+package replicate
+
+import (
+	"testing"
+)
+
+func TestTreeHash(t *testing.T) {
+	a := TreeHash(map[string][]byte{
+		"foo/a": []byte("1"),
+		"foo/b": []byte("2"),
+	})
+
+	if a != TreeHash(map[string][]byte{
+		"foo/b": []byte("2"),
+		"foo/a": []byte("1"),
+	}) {
+		t.Error("expected hash to be independent of insertion order")
+	}
+
+	// Moving bytes between a key and its value must change the hash
+	if a == TreeHash(map[string][]byte{
+		"foo/a1": []byte(""),
+		"foo/b":  []byte("2"),
+	}) {
+		t.Error("expected different trees to hash differently")
+	}
+
+	if TreeHash(nil) != "sha256:e3b0c44298fc1c149afbf4c8996fb92427ae41e4649b934ca495991b7852b855" {
+		t.Errorf("bad empty hash: %s", TreeHash(nil))
+	}
+}
@@ -1,7 +1,7 @@
 // Copyright (c) HashiCorp, Inc.
 // SPDX-License-Identifier: MPL-2.0
 
-package main
+package replicate
 
 import (
 	"reflect"
@@ -56,7 +56,7 @@ func MapToPrefixConfigFunc() mapstructure.DecodeHookFunc {
 			return data, nil
 		}
 
-		for _, v := range []string{"dc", "datacenter"} {
+		for _, v := range []string{"dc", "datacenter", "source_dc"} {
 			if dc, ok := d[v].(string); ok {
 				source = source + "@" + dc
 				break
@@ -73,10 +73,53 @@ func MapToPrefixConfigFunc() mapstructure.DecodeHookFunc {
 		if err != nil {
 			return data, err
 		}
+
+		// Decode any remaining options onto the parsed prefix
+		if err := decodePrefixOptions(d, p); err != nil {
+			return data, err
+		}
 		return p, nil
 	}
 }
 
+// decodePrefixOptions decodes the keys of a prefix stanza which are not part
+// of the "source@dc:destination" string onto the given prefix.
+func decodePrefixOptions(d map[string]interface{}, p *PrefixConfig) error {
+	opts := make(map[string]interface{}, len(d))
+	for k, v := range d {
+		switch k {
+		case "source", "dc", "datacenter", "source_dc", "destination":
+		default:
+			opts[k] = v
+		}
+	}
+
+	if len(opts) == 0 {
+		return nil
+	}
+
+	// Nested stanzas are decoded by HCL as lists of maps
+	flattenKeys(opts, []string{"canary", "key_rules", "validate"})
+	if middlewares, ok := opts["middleware"].([]map[string]interface{}); ok {
+		for _, m := range middlewares {
+			flattenKeys(m, []string{"options"})
+		}
+	}
+
+	decoder, err := mapstructure.NewDecoder(&mapstructure.DecoderConfig{
+		DecodeHook: mapstructure.ComposeDecodeHookFunc(
+			mapstructure.StringToSliceHookFunc(","),
+			mapstructure.StringToTimeDurationHookFunc(),
+		),
+		ErrorUnused: true,
+		Result:      p,
+	})
+	if err != nil {
+		return err
+	}
+	return decoder.Decode(opts)
+}
+
 // StringToExcludeConfigFunc returns a function that converts strings to
 // *ExcludeConfig value. This is designed to be used with mapstructure.
 func StringToExcludeConfigFunc() mapstructure.DecodeHookFunc {
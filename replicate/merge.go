@@ -0,0 +1,88 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: MPL-2.0
+
+package replicate
+
+import (
+	"fmt"
+
+	"github.com/hashicorp/consul-template/config"
+	dep "github.com/hashicorp/consul-template/dependency"
+)
+
+// mergeSource is the source data of one of the prefixes replicated in a pass.
+type mergeSource struct {
+	prefix *PrefixConfig
+	pairs  []*dep.KeyPair
+}
+
+// checkMerge returns an error if the prefix cannot be merged.
+func checkMerge(prefix *PrefixConfig) error {
+	if config.BoolVal(prefix.Merged) && config.BoolVal(prefix.Canary.Enabled) {
+		return fmt.Errorf("merged prefix %q cannot use a canary",
+			config.StringVal(prefix.Source))
+	}
+	return nil
+}
+
+// mergeGroup returns the merged prefixes with the same destination as the
+// given one, in the order of the configuration.
+func (r *Runner) mergeGroup(prefix *PrefixConfig) []*PrefixConfig {
+	var group []*PrefixConfig
+	for _, p := range r.activePrefixes() {
+		if !config.BoolVal(p.Merged) ||
+			config.StringVal(p.Backend) != config.StringVal(prefix.Backend) ||
+			config.StringVal(p.Destination) != config.StringVal(prefix.Destination) {
+			continue
+		}
+		group = append(group, p)
+	}
+	return group
+}
+
+// mergedInto returns true if the prefix is replicated by the pass of another
+// prefix of its merge group, which is the first one in the configuration.
+func (r *Runner) mergedInto(prefix *PrefixConfig) bool {
+	if !config.BoolVal(prefix.Merged) {
+		return false
+	}
+
+	group := r.mergeGroup(prefix)
+	return len(group) > 0 && group[0].Dependency.String() != prefix.Dependency.String()
+}
+
+// mergeSources returns the source data of every prefix merged with the given
+// one, whose pairs are given, from the last prefix in the configuration to
+// the first. It returns false if a watch has not returned data yet, as the
+// keys of that prefix would otherwise be deleted.
+func (r *Runner) mergeSources(prefix *PrefixConfig, pairs []*dep.KeyPair) ([]*mergeSource, bool, error) {
+	group := r.mergeGroup(prefix)
+	if len(group) < 2 || group[0].Dependency.String() != prefix.Dependency.String() {
+		return []*mergeSource{{prefix: prefix, pairs: pairs}}, true, nil
+	}
+
+	// The prefix replicating the group comes first in the configuration, so
+	// its keys are overridden by every other prefix
+	sources := make([]*mergeSource, 0, len(group))
+	for i := len(group) - 1; i > 0; i-- {
+		pairs, _, ok, err := r.sourcePairs(group[i])
+		if err != nil || !ok {
+			return nil, false, err
+		}
+		if err := checkLimits(group[i], pairs); err != nil {
+			return nil, false, err
+		}
+		sources = append(sources, &mergeSource{prefix: group[i], pairs: pairs})
+	}
+	sources = append(sources, &mergeSource{prefix: prefix, pairs: pairs})
+	return sources, true, nil
+}
+
+// countPairs returns the number of pairs of every source.
+func countPairs(sources []*mergeSource) int {
+	n := 0
+	for _, s := range sources {
+		n += len(s.pairs)
+	}
+	return n
+}
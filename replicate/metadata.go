@@ -0,0 +1,133 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: MPL-2.0
+
+package replicate
+
+import (
+	"encoding/json"
+	"log"
+	"strings"
+	"time"
+
+	"github.com/hashicorp/consul-template/config"
+	"github.com/hashicorp/consul/api"
+)
+
+// KeyMetadata is written into the sidecar metadata key of every replicated key,
+// so the provenance of its value can be audited at the destination.
+type KeyMetadata struct {
+	// SourceDatacenter and SourceKey are where the value was read from.
+	SourceDatacenter string
+	SourceKey        string
+
+	// SourceModifyIndex is the modify index of the source key.
+	SourceModifyIndex uint64
+
+	// ValueHash is the hash of the value written into the destination, as
+	// computed for events.
+	ValueHash string
+
+	// ReplicatedAt is the time the value was written.
+	ReplicatedAt time.Time
+
+	// Origin is the datacenter the value was first written in, with loop
+	// detection. Replicators chained after this one read it from their source
+	// to skip keys which originated in their destination.
+	Origin string `json:",omitempty"`
+
+	// Writer is the name of the instance which wrote the value, with identity
+	// stamping or delete_owned_only. Replicators chained after this one read it
+	// from their source to tell its marker from the flags of applications, and
+	// it marks the keys the replicators own at the destination.
+	Writer string `json:",omitempty"`
+}
+
+// describes returns true if the metadata was written along with the value, and
+// not for a value the key held before it was written again.
+func (m *KeyMetadata) describes(value []byte) bool {
+	return m != nil && m.ValueHash == valueHash(value)
+}
+
+// metadataEnabled returns true if replicated keys have a metadata key.
+func (r *Runner) metadataEnabled() bool {
+	return config.BoolVal(r.config.Metadata.Enabled)
+}
+
+// metadataWritten returns true if the keys of the prefix have a metadata key,
+// either for auditing, to record their origin for loop detection or to record
+// their writer.
+func (r *Runner) metadataWritten(prefix *PrefixConfig) bool {
+	return r.metadataEnabled() || r.loopDetectionEnabled() || r.writerRecorded(prefix)
+}
+
+// writerRecorded returns true if the metadata of the keys of the prefix names
+// the instance which wrote them, for identity stamping or to tell the keys it
+// owns.
+func (r *Runner) writerRecorded(prefix *PrefixConfig) bool {
+	return r.stampFlagsEnabled() || config.BoolVal(prefix.DeleteOwnedOnly)
+}
+
+// metadataReserved returns true if the keys of any prefix have a metadata key.
+func (r *Runner) metadataReserved() bool {
+	if r.metadataEnabled() || r.loopDetectionEnabled() || r.stampFlagsEnabled() {
+		return true
+	}
+	for _, prefix := range *r.config.Prefixes {
+		if config.BoolVal(prefix.DeleteOwnedOnly) {
+			return true
+		}
+	}
+	return false
+}
+
+// metadataDir returns the folder of the metadata keys, without a trailing
+// slash.
+func (r *Runner) metadataDir() string {
+	return strings.TrimRight(config.StringVal(r.config.Metadata.Dir), "/")
+}
+
+// metadataKey returns the metadata key of the destination key.
+func (r *Runner) metadataKey(key string) string {
+	return joinDestination(r.metadataDir(), key)
+}
+
+// writeMetadata writes the metadata of the destination key.
+func (r *Runner) writeMetadata(backend Backend, key string, m *KeyMetadata) error {
+	m.ReplicatedAt = time.Now().UTC()
+	enc, err := json.MarshalIndent(m, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	return backend.Put(&api.KVPair{
+		Key:   r.metadataKey(key),
+		Value: enc,
+	})
+}
+
+// sourceMetadata returns the metadata the replicators writing into the source
+// datacenter of the prefix left there, keyed by source key. Metadata which
+// cannot be decoded is ignored.
+func (r *Runner) sourceMetadata(prefix *PrefixConfig) (map[string]*KeyMetadata, error) {
+	source := strings.Trim(config.StringVal(prefix.Source), "/")
+	pairs, _, err := r.sourceFor(prefix).List(r.metadataKey(source), config.StringVal(prefix.Datacenter))
+	if err != nil {
+		return nil, err
+	}
+
+	metadata := make(map[string]*KeyMetadata, len(pairs))
+	for _, pair := range pairs {
+		var m KeyMetadata
+		if err := json.Unmarshal([]byte(pair.Value), &m); err != nil {
+			log.Printf("[WARN] (runner) ignoring the metadata at %q: %s", pair.Path, err)
+			continue
+		}
+		metadata[joinDestination(source, pair.Key)] = &m
+	}
+	return metadata, nil
+}
+
+// deleteMetadata removes the metadata of the deleted destination key.
+func (r *Runner) deleteMetadata(backend Backend, key string) error {
+	return backend.Delete(r.metadataKey(key))
+}
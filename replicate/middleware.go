@@ -0,0 +1,316 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: MPL-2.0
+
+package replicate
+
+import (
+	"bufio"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"os/exec"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/hashicorp/consul-template/config"
+	"github.com/mattn/go-shellwords"
+	"github.com/pkg/errors"
+)
+
+// Meta describes the source of a key passed through a Middleware.
+type Meta struct {
+	// Source, Datacenter and Destination identify the prefix being replicated.
+	Source, Datacenter, Destination string
+
+	// Path is the full key on the source.
+	Path string
+
+	// Flags and ModifyIndex are copied from the source key.
+	Flags, ModifyIndex uint64
+}
+
+// Middleware filters, transforms or enriches keys before they are written to
+// the destination. Prefixes are replicated in parallel, so implementations
+// must be safe for concurrent use.
+type Middleware interface {
+	// Process is given the destination key and value and returns the key and
+	// value to write in their place. If skip is true, the key is not
+	// replicated at all.
+	Process(key string, value []byte, meta *Meta) (string, []byte, bool, error)
+
+	// Close releases the resources of the middleware, such as the process of
+	// a plugin, once the runner is stopped.
+	Close() error
+}
+
+// MiddlewareFactory creates a Middleware from the options of its stanza.
+type MiddlewareFactory func(options map[string]string) (Middleware, error)
+
+var (
+	middlewaresLock sync.RWMutex
+	middlewares     = map[string]MiddlewareFactory{
+		"base64": newBase64Middleware,
+		"exec":   newExecMiddleware,
+	}
+)
+
+// RegisterMiddleware makes a middleware available to prefix stanzas under the
+// given name. It is intended to be called from init functions of packages
+// which embed the runner, and panics if the name is already taken.
+func RegisterMiddleware(name string, f MiddlewareFactory) {
+	middlewaresLock.Lock()
+	defer middlewaresLock.Unlock()
+
+	if _, ok := middlewares[name]; ok {
+		panic(fmt.Sprintf("middleware %q registered twice", name))
+	}
+	middlewares[name] = f
+}
+
+// newMiddleware creates the middleware for the given stanza.
+func newMiddleware(c *MiddlewareConfig) (Middleware, error) {
+	middlewaresLock.RLock()
+	f, ok := middlewares[config.StringVal(c.Name)]
+	middlewaresLock.RUnlock()
+
+	if !ok {
+		return nil, fmt.Errorf("unknown middleware %q", config.StringVal(c.Name))
+	}
+	return f(c.Options)
+}
+
+// middlewareID returns a string identifying the middleware stanza, so that
+// identical stanzas, for example of expanded wildcard prefixes, share one
+// instance.
+func middlewareID(c *MiddlewareConfig) string {
+	keys := make([]string, 0, len(c.Options))
+	for k := range c.Options {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	id := config.StringVal(c.Name)
+	for _, k := range keys {
+		id += fmt.Sprintf("\x00%s=%s", k, c.Options[k])
+	}
+	return id
+}
+
+// initMiddlewares creates every middleware referenced by the configuration.
+func (r *Runner) initMiddlewares() error {
+	r.middlewares = make(map[string]Middleware)
+	for _, prefix := range *r.config.Prefixes {
+		for _, c := range *prefix.Middlewares {
+			id := middlewareID(c)
+			if _, ok := r.middlewares[id]; ok {
+				continue
+			}
+
+			m, err := newMiddleware(c)
+			if err != nil {
+				return errors.Wrapf(err, "prefix %q", config.StringVal(prefix.Source))
+			}
+			r.middlewares[id] = m
+		}
+	}
+	return nil
+}
+
+// closeMiddlewares closes every middleware of the runner. Failures are
+// logged.
+func (r *Runner) closeMiddlewares() {
+	for _, m := range r.middlewares {
+		if err := m.Close(); err != nil {
+			log.Printf("[WARN] (runner) could not close middleware: %s", err)
+		}
+	}
+}
+
+// process runs the key through the middleware of the prefix, in order.
+func (r *Runner) process(prefix *PrefixConfig, key string, value []byte, meta *Meta) (string, []byte, bool, error) {
+	for _, c := range *prefix.Middlewares {
+		m := r.middlewares[middlewareID(c)]
+
+		var skip bool
+		var err error
+		key, value, skip, err = m.Process(key, value, meta)
+		if err != nil {
+			return "", nil, false, errors.Wrapf(err, "middleware %q", config.StringVal(c.Name))
+		}
+		if skip {
+			return key, value, true, nil
+		}
+	}
+	return key, value, false, nil
+}
+
+// base64Middleware encodes values to base64, or decodes them, for
+// destinations and consumers which are not binary-safe. Values are otherwise
+// replicated byte for byte.
+type base64Middleware struct {
+	decode bool
+}
+
+func newBase64Middleware(options map[string]string) (Middleware, error) {
+	switch options["mode"] {
+	case "", "encode":
+		return &base64Middleware{}, nil
+	case "decode":
+		return &base64Middleware{decode: true}, nil
+	default:
+		return nil, fmt.Errorf("base64: invalid mode %q", options["mode"])
+	}
+}
+
+func (m *base64Middleware) Process(key string, value []byte, meta *Meta) (string, []byte, bool, error) {
+	if !m.decode {
+		return key, []byte(base64.StdEncoding.EncodeToString(value)), false, nil
+	}
+
+	decoded, err := base64.StdEncoding.DecodeString(string(value))
+	if err != nil {
+		return "", nil, false, errors.Wrap(err, "base64: decoding")
+	}
+	return key, decoded, false, nil
+}
+
+func (m *base64Middleware) Close() error {
+	return nil
+}
+
+// execMiddleware is an out-of-process middleware. The command is started once
+// and exchanges one JSON object per line over its stdin and stdout for every
+// key, so plugins can be written in any language.
+type execMiddleware struct {
+	sync.Mutex
+
+	command []string
+
+	// closed is true once the middleware is closed, after which the command
+	// is not started again.
+	closed bool
+
+	cmd *exec.Cmd
+	in  io.WriteCloser
+	out *bufio.Scanner
+}
+
+// execRequest and execResponse are the messages exchanged with an exec
+// middleware. Values are base64-encoded by encoding/json.
+type execRequest struct {
+	Key   string `json:"key"`
+	Value []byte `json:"value"`
+	Meta  *Meta  `json:"meta"`
+}
+
+type execResponse struct {
+	Key   string `json:"key"`
+	Value []byte `json:"value"`
+	Skip  bool   `json:"skip"`
+	Error string `json:"error"`
+}
+
+func newExecMiddleware(options map[string]string) (Middleware, error) {
+	command, err := shellwords.Parse(options["command"])
+	if err != nil {
+		return nil, errors.Wrap(err, "exec: parsing command")
+	}
+	if len(command) == 0 {
+		return nil, fmt.Errorf("exec: missing command")
+	}
+	return &execMiddleware{command: command}, nil
+}
+
+func (m *execMiddleware) Process(key string, value []byte, meta *Meta) (string, []byte, bool, error) {
+	m.Lock()
+	defer m.Unlock()
+
+	if m.closed {
+		return "", nil, false, fmt.Errorf("exec: middleware is closed")
+	}
+	if m.cmd == nil {
+		if err := m.start(); err != nil {
+			return "", nil, false, err
+		}
+	}
+
+	enc, err := json.Marshal(&execRequest{Key: key, Value: value, Meta: meta})
+	if err != nil {
+		return "", nil, false, err
+	}
+
+	if _, err := m.in.Write(append(enc, '\n')); err != nil {
+		m.stop()
+		return "", nil, false, errors.Wrap(err, "exec: writing request")
+	}
+
+	if !m.out.Scan() {
+		err := m.out.Err()
+		if err == nil {
+			err = io.ErrUnexpectedEOF
+		}
+		m.stop()
+		return "", nil, false, errors.Wrap(err, "exec: reading response")
+	}
+
+	var resp execResponse
+	if err := json.Unmarshal(m.out.Bytes(), &resp); err != nil {
+		return "", nil, false, errors.Wrap(err, "exec: decoding response")
+	}
+
+	if resp.Error != "" {
+		return "", nil, false, fmt.Errorf("exec: %s", resp.Error)
+	}
+	return resp.Key, resp.Value, resp.Skip, nil
+}
+
+// Close terminates the command.
+func (m *execMiddleware) Close() error {
+	m.Lock()
+	defer m.Unlock()
+
+	m.closed = true
+	m.stop()
+	return nil
+}
+
+// start launches the command. The caller must hold the lock.
+func (m *execMiddleware) start() error {
+	cmd := exec.Command(m.command[0], m.command[1:]...)
+	cmd.Stderr = os.Stderr
+
+	in, err := cmd.StdinPipe()
+	if err != nil {
+		return err
+	}
+	out, err := cmd.StdoutPipe()
+	if err != nil {
+		return err
+	}
+
+	if err := cmd.Start(); err != nil {
+		return errors.Wrapf(err, "exec: starting %q", strings.Join(m.command, " "))
+	}
+
+	scanner := bufio.NewScanner(out)
+	scanner.Buffer(make([]byte, 64*1024), 64*1024*1024)
+
+	m.cmd, m.in, m.out = cmd, in, scanner
+	return nil
+}
+
+// stop terminates the command so it is restarted on the next key. The caller
+// must hold the lock.
+func (m *execMiddleware) stop() {
+	if m.cmd == nil {
+		return
+	}
+	m.in.Close()
+	m.cmd.Process.Kill()
+	m.cmd.Wait()
+	m.cmd = nil
+}
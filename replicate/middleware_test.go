@@ -0,0 +1,150 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: MPL-2.0
+
+package replicate
+
+import (
+	"bytes"
+	"fmt"
+	"math/rand"
+	"strings"
+	"testing"
+
+	"github.com/hashicorp/consul-template/config"
+)
+
+type upperMiddleware struct{}
+
+func (upperMiddleware) Process(key string, value []byte, meta *Meta) (string, []byte, bool, error) {
+	if strings.HasSuffix(key, "/secret") {
+		return key, value, true, nil
+	}
+	return strings.ToUpper(key), value, false, nil
+}
+
+func (upperMiddleware) Close() error {
+	return nil
+}
+
+func init() {
+	RegisterMiddleware("upper", func(map[string]string) (Middleware, error) {
+		return upperMiddleware{}, nil
+	})
+}
+
+func TestRunner_process(t *testing.T) {
+	prefix := &PrefixConfig{
+		Middlewares: &MiddlewareConfigs{
+			&MiddlewareConfig{
+				Name:    config.String("exec"),
+				Options: map[string]string{"command": "cat"},
+			},
+			&MiddlewareConfig{
+				Name: config.String("upper"),
+			},
+		},
+	}
+
+	r := &Runner{config: &Config{Prefixes: &PrefixConfigs{prefix}}}
+	if err := r.initMiddlewares(); err != nil {
+		t.Fatal(err)
+	}
+
+	cases := []struct {
+		name  string
+		key   string
+		value string
+		exp   string
+		skip  bool
+	}{
+		{
+			"transformed",
+			"foo/bar",
+			"baz",
+			"FOO/BAR",
+			false,
+		},
+		{
+			"binary",
+			"foo/bin",
+			"\x00\xff",
+			"FOO/BIN",
+			false,
+		},
+		{
+			"skipped",
+			"foo/secret",
+			"baz",
+			"foo/secret",
+			true,
+		},
+	}
+
+	for i, tc := range cases {
+		t.Run(fmt.Sprintf("%d_%s", i, tc.name), func(t *testing.T) {
+			key, value, skip, err := r.process(prefix, tc.key, []byte(tc.value), &Meta{Path: tc.key})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if key != tc.exp || skip != tc.skip || string(value) != tc.value {
+				t.Errorf("\nexp: %q %q %v\nact: %q %q %v", tc.exp, tc.value, tc.skip, key, value, skip)
+			}
+		})
+	}
+}
+
+func TestRunner_initMiddlewares_unknown(t *testing.T) {
+	r := &Runner{config: &Config{Prefixes: &PrefixConfigs{
+		&PrefixConfig{
+			Source: config.String("foo"),
+			Middlewares: &MiddlewareConfigs{
+				&MiddlewareConfig{Name: config.String("nope")},
+			},
+		},
+	}}}
+	if err := r.initMiddlewares(); err == nil {
+		t.Fatal("expected error")
+	}
+}
+
+func TestBase64Middleware(t *testing.T) {
+	encode, err := newBase64Middleware(map[string]string{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	decode, err := newBase64Middleware(map[string]string{"mode": "decode"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Random payloads, including NUL bytes and invalid UTF-8, survive a round
+	// trip byte for byte
+	rnd := rand.New(rand.NewSource(42))
+	for i := 0; i < 100; i++ {
+		value := make([]byte, rnd.Intn(1024))
+		rnd.Read(value)
+
+		_, encoded, _, err := encode.Process("foo", value, &Meta{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if bytes.ContainsAny(encoded, "\x00\n") {
+			t.Fatalf("expected %q to be printable", encoded)
+		}
+
+		_, decoded, _, err := decode.Process("foo", encoded, &Meta{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(value, decoded) {
+			t.Fatalf("\nexp: %#v\nact: %#v", value, decoded)
+		}
+	}
+
+	if _, _, _, err := decode.Process("foo", []byte("not base64!"), &Meta{}); err == nil {
+		t.Errorf("expected an error")
+	}
+	if _, err := newBase64Middleware(map[string]string{"mode": "nope"}); err == nil {
+		t.Errorf("expected an error")
+	}
+}
@@ -0,0 +1,253 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: MPL-2.0
+
+package replicate
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	"github.com/hashicorp/consul-replicate/version"
+	"github.com/hashicorp/consul-template/config"
+	"github.com/hashicorp/consul/api"
+	"github.com/pkg/errors"
+)
+
+// MigrateSentinel is written next to the status of every prefix when a
+// migration cuts over, marking its destination authoritative.
+type MigrateSentinel struct {
+	// Source, Datacenter and Destination identify the prefix.
+	Source, Datacenter, Destination string
+
+	// SourceIndex, KeyCount and TreeHash describe the verified destination,
+	// like the manifest of its last pass.
+	SourceIndex uint64
+	KeyCount    int
+	TreeHash    string
+
+	// Version is the version of Consul Replicate which cut over.
+	Version string
+
+	// Timestamp is the time of the cutover.
+	Timestamp time.Time
+}
+
+// MigrateResult is the outcome of the final verification of a prefix by a
+// migration.
+type MigrateResult struct {
+	// Source, Datacenter and Destination identify the prefix.
+	Source, Datacenter, Destination string
+
+	// KeyCount is the number of keys of the verified destination, and
+	// SourceIndex the index of the source they were replicated from.
+	KeyCount    int
+	SourceIndex uint64
+
+	// Err is why the verification failed, if it did.
+	Err error
+}
+
+// Migrate replicates every prefix for a one-time migration of the source into
+// the destination. It replicates a full pass, then catches up with passes
+// until one changes nothing, and fails if the source still changes once the
+// catch-up timeout passes. It then verifies that the destination of every
+// prefix holds exactly what the last pass replicated, and that the source has
+// not changed since. Only if every prefix is verified does it cut over, by
+// writing a sentinel next to the status of every prefix. Prefixes which were
+// already cut over are never migrated again. The runner must be in once mode.
+func (r *Runner) Migrate(catchUp time.Duration) ([]*MigrateResult, error) {
+	if !r.once {
+		return nil, fmt.Errorf("migrate: the runner must be in once mode")
+	}
+
+	if err := r.checkMigrated(); err != nil {
+		return nil, err
+	}
+
+	deadline := time.Now().Add(catchUp)
+	for passes := 1; ; passes++ {
+		changes, err := r.migratePass()
+		if err != nil {
+			return nil, errors.Wrap(err, "migrate")
+		}
+		if changes == 0 {
+			log.Printf("[INFO] (runner) caught up with the source after %d passes", passes)
+			break
+		}
+		if time.Now().After(deadline) {
+			return nil, fmt.Errorf("migrate: the source still changes after %s of "+
+				"catch-up, not cutting over", catchUp)
+		}
+		log.Printf("[INFO] (runner) migration pass %d changed %d keys, catching up",
+			passes, changes)
+	}
+
+	var failed int
+	var results []*MigrateResult
+	var sentinels []*MigrateSentinel
+	prefixes := r.activePrefixes()
+	for _, prefix := range prefixes {
+		result, sentinel := r.verifyPrefix(prefix)
+		if result.Err != nil {
+			failed++
+		}
+		results = append(results, result)
+		sentinels = append(sentinels, sentinel)
+	}
+	if failed > 0 {
+		return results, fmt.Errorf("migrate: %d prefixes failed the verification, "+
+			"not cutting over", failed)
+	}
+
+	for i, prefix := range prefixes {
+		if err := r.setMigrated(prefix, sentinels[i]); err != nil {
+			return results, errors.Wrapf(err, "migrate: cutting over %s", prefix.Dependency)
+		}
+		log.Printf("[INFO] (runner) %s cut over", prefix.Dependency)
+	}
+	return results, nil
+}
+
+// migratePass replicates a single pass of every prefix, and returns the
+// number of keys it wrote, deleted or failed to replicate.
+func (r *Runner) migratePass() (int, error) {
+	go r.Start()
+
+	select {
+	case <-r.DoneCh:
+	case err := <-r.ErrCh:
+		return 0, err
+	}
+
+	changes := 0
+	for {
+		select {
+		case e := <-r.eventCh:
+			changes += e.Updates + e.Deletes + len(e.Failures)
+		default:
+			return changes, nil
+		}
+	}
+}
+
+// checkMigrated returns an error if any configured prefix was already cut
+// over.
+func (r *Runner) checkMigrated() error {
+	for _, prefix := range *r.config.Prefixes {
+		prefixes := []*PrefixConfig{prefix}
+		if prefix.IsWildcard() {
+			var err error
+			if prefixes, err = r.expand(prefix); err != nil {
+				return err
+			}
+		}
+
+		for _, p := range prefixes {
+			pair, err := r.statusBackend(p).Get(r.migratedPath(p))
+			if err != nil {
+				return errors.Wrapf(err, "migrate: reading the sentinel of %s", p.Dependency)
+			}
+			if pair != nil {
+				return fmt.Errorf("migrate: %s was already cut over", p.Dependency)
+			}
+		}
+	}
+	return nil
+}
+
+// verifyPrefix checks that the last pass of the prefix replicated every key,
+// that the destination still holds exactly what it replicated, and that the
+// source has not changed since. It returns the sentinel to write when the
+// prefix is cut over.
+func (r *Runner) verifyPrefix(prefix *PrefixConfig) (*MigrateResult, *MigrateSentinel) {
+	result := &MigrateResult{
+		Source:      config.StringVal(prefix.Source),
+		Datacenter:  config.StringVal(prefix.Datacenter),
+		Destination: config.StringVal(prefix.Destination),
+	}
+
+	status, err := r.getStatus(prefix)
+	if err != nil {
+		result.Err = fmt.Errorf("reading status: %s", err)
+		return result, nil
+	}
+	if len(status.Failures) > 0 {
+		result.Err = fmt.Errorf("%d keys failed to replicate", len(status.Failures))
+		return result, nil
+	}
+
+	m, err := r.getManifest(prefix)
+	if err != nil {
+		result.Err = fmt.Errorf("reading manifest: %s", err)
+		return result, nil
+	}
+	if m == nil {
+		result.Err = fmt.Errorf("never replicated")
+		return result, nil
+	}
+
+	// The index of an empty prefix is the index of the whole store, which
+	// changes with any other prefix
+	pairs, index, err := r.sourceFor(prefix).List(result.Source, result.Datacenter)
+	if err != nil {
+		result.Err = fmt.Errorf("reading source: %s", err)
+		return result, nil
+	}
+	if index > m.SourceIndex && (len(pairs) > 0 || m.KeyCount > 0) {
+		result.Err = fmt.Errorf("source changed at index %d, after the replicated index %d",
+			index, m.SourceIndex)
+		return result, nil
+	}
+
+	tree, err := r.destinationTree(r.backend(prefix), prefix)
+	if err != nil {
+		result.Err = fmt.Errorf("reading destination: %s", err)
+		return result, nil
+	}
+	for key := range tree {
+		if r.reserved(key) {
+			delete(tree, key)
+		}
+	}
+	if hash := TreeHash(tree); hash != m.TreeHash {
+		result.Err = fmt.Errorf("destination has %d keys with hash %s, but %d keys "+
+			"with hash %s were replicated", len(tree), hash, m.KeyCount, m.TreeHash)
+		return result, nil
+	}
+
+	result.KeyCount, result.SourceIndex = m.KeyCount, m.SourceIndex
+	return result, &MigrateSentinel{
+		Source:      result.Source,
+		Datacenter:  result.Datacenter,
+		Destination: result.Destination,
+		SourceIndex: m.SourceIndex,
+		KeyCount:    m.KeyCount,
+		TreeHash:    m.TreeHash,
+	}
+}
+
+// setMigrated writes the sentinel of a prefix which was cut over.
+func (r *Runner) setMigrated(prefix *PrefixConfig, s *MigrateSentinel) error {
+	s.Version, s.Timestamp = version.Version, time.Now().UTC()
+
+	enc, err := json.MarshalIndent(s, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	return r.statusBackend(prefix).Put(&api.KVPair{
+		Key:   r.migratedPath(prefix),
+		Value: enc,
+	})
+}
+
+// migratedPath returns the path of the sentinel of a prefix which was cut
+// over, which lives next to the status key of the prefix.
+func (r *Runner) migratedPath(prefix *PrefixConfig) string {
+	status := r.statusPath(prefix)
+	i := strings.LastIndex(status, "/")
+	return status[:i] + "/migrated" + status[i:]
+}
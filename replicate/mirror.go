@@ -0,0 +1,451 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: MPL-2.0
+
+package replicate
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"log"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/hashicorp/consul-template/config"
+	"github.com/hashicorp/consul/api"
+	"github.com/pkg/errors"
+)
+
+const (
+	// mirrorCompactMin is the minimum number of entries of a journal before it
+	// is rewritten, once it holds more than twice as many entries as keys.
+	mirrorCompactMin = 1024
+
+	// mirrorMaxEntry is the maximum size of an entry of a journal, which holds
+	// the largest Consul value once encoded.
+	mirrorMaxEntry = 4 * 1024 * 1024
+)
+
+const (
+	mirrorPut    = "put"
+	mirrorDelete = "delete"
+	mirrorCommit = "commit"
+)
+
+// mirrorEntry is a line of the journal of a mirror.
+type mirrorEntry struct {
+	Op    string `json:"op"`
+	Key   string `json:"key,omitempty"`
+	Flags uint64 `json:"flags,omitempty"`
+	Value []byte `json:"value,omitempty"`
+
+	// Index is the applied index of the prefix of a commit.
+	Index uint64 `json:"index,omitempty"`
+}
+
+// mirror is the local copy of the destination keys of a prefix, as last
+// written by the replicator. Every change is appended to a journal on disk, so
+// the copy survives restarts, and the journal is committed with the applied
+// index of the prefix before its status, so a copy is only used if it matches
+// the status.
+type mirror struct {
+	sync.Mutex
+	path string
+	file *os.File
+	w    *bufio.Writer
+
+	// primed is true once the copy holds every destination key, and index is
+	// the applied index it was last committed at.
+	primed bool
+	index  uint64
+	pairs  map[string]*api.KVPair
+
+	// entries is the number of entries of the journal.
+	entries int
+}
+
+// mirrorEnabled returns true if the destination keys of the prefixes are
+// mirrored on disk.
+func (r *Runner) mirrorEnabled() bool {
+	return config.BoolVal(r.config.Mirror.Enabled)
+}
+
+// mirrorPath returns the path of the journal of the mirror of the prefix.
+func (r *Runner) mirrorPath(prefix *PrefixConfig) string {
+	return filepath.Join(config.StringVal(r.config.Mirror.Dir), path.Base(r.statusPath(prefix))+".journal")
+}
+
+// mirrorFor returns the mirror of the prefix, loading its journal the first
+// time. The mirror is primed from the destination if it was never primed, if
+// it was committed at another index than the last replicated index of the
+// status, such as after passes without a mirror, or on a resync.
+func (r *Runner) mirrorFor(prefix *PrefixConfig, backend Backend, index uint64) (*mirror, error) {
+	p := r.mirrorPath(prefix)
+	r.mirrorsLock.Lock()
+	m, ok := r.mirrors[p]
+	if !ok {
+		var err error
+		if m, err = openMirror(p); err != nil {
+			r.mirrorsLock.Unlock()
+			return nil, err
+		}
+		r.mirrors[p] = m
+	}
+	r.mirrorsLock.Unlock()
+
+	m.Lock()
+	defer m.Unlock()
+	if m.primed && m.index == index && !r.resync {
+		return m, nil
+	}
+
+	keys, err := r.destinationKeys(backend, prefix)
+	if err != nil {
+		return nil, errors.Wrap(err, "listing keys")
+	}
+	pairs := make(map[string]*api.KVPair, len(keys))
+	for _, key := range keys {
+		pair, err := backend.Get(key)
+		if err != nil {
+			return nil, errors.Wrapf(err, "reading %q", key)
+		}
+		if pair != nil {
+			pairs[key] = &api.KVPair{Key: key, Flags: pair.Flags, Value: pair.Value}
+		}
+	}
+	m.pairs = pairs
+	if err := m.rewrite(index); err != nil {
+		return nil, err
+	}
+	log.Printf("[DEBUG] (runner) primed the mirror of %s with %d keys", prefix.Dependency, len(pairs))
+	return m, nil
+}
+
+// commitMirror commits the mirror of the prefix at the applied index, before
+// the status is, if the prefix has a mirror.
+func (r *Runner) commitMirror(prefix *PrefixConfig, index uint64) error {
+	if !r.mirrorEnabled() {
+		return nil
+	}
+	r.mirrorsLock.Lock()
+	m := r.mirrors[r.mirrorPath(prefix)]
+	r.mirrorsLock.Unlock()
+	if m == nil {
+		return nil
+	}
+	return m.commit(index)
+}
+
+// dropMirror discards the mirror of the prefix, after its destination was
+// changed by anything else than a pass, so it is primed again.
+func (r *Runner) dropMirror(prefix *PrefixConfig) error {
+	if !r.mirrorEnabled() {
+		return nil
+	}
+	p := r.mirrorPath(prefix)
+	r.mirrorsLock.Lock()
+	m := r.mirrors[p]
+	r.mirrorsLock.Unlock()
+	if m != nil {
+		m.Lock()
+		m.primed = false
+		m.Unlock()
+	}
+	if err := os.Remove(p); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return nil
+}
+
+// closeMirrors flushes and closes the journals of the mirrors.
+func (r *Runner) closeMirrors() {
+	r.mirrorsLock.Lock()
+	defer r.mirrorsLock.Unlock()
+	for p, m := range r.mirrors {
+		if err := m.close(); err != nil {
+			log.Printf("[WARN] (runner) could not close the mirror %q: %s", p, err)
+		}
+	}
+}
+
+// openMirror loads the journal at the given path. A journal which ends with a
+// partial entry, left by a crash, is rewritten without it, and a journal which
+// cannot be read is ignored, so the mirror is primed again.
+func openMirror(p string) (*mirror, error) {
+	m := &mirror{path: p, pairs: make(map[string]*api.KVPair)}
+
+	f, err := os.Open(p)
+	if os.IsNotExist(err) {
+		return m, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	truncated := false
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(make([]byte, 64*1024), mirrorMaxEntry)
+	for scanner.Scan() {
+		if truncated {
+			log.Printf("[WARN] (runner) mirror %q is corrupt, priming it again", p)
+			return &mirror{path: p, pairs: make(map[string]*api.KVPair)}, nil
+		}
+
+		var e mirrorEntry
+		if err := json.Unmarshal(scanner.Bytes(), &e); err != nil {
+			truncated = true
+			continue
+		}
+		m.apply(&e)
+		m.entries++
+	}
+	if err := scanner.Err(); err != nil {
+		log.Printf("[WARN] (runner) mirror %q cannot be read, priming it again: %s", p, err)
+		return &mirror{path: p, pairs: make(map[string]*api.KVPair)}, nil
+	}
+
+	if !m.primed {
+		return m, nil
+	}
+	if truncated {
+		if err := m.rewrite(m.index); err != nil {
+			return nil, err
+		}
+		return m, nil
+	}
+	if m.file, err = os.OpenFile(p, os.O_WRONLY|os.O_APPEND, 0o600); err != nil {
+		return nil, err
+	}
+	m.w = bufio.NewWriter(m.file)
+	return m, nil
+}
+
+// apply applies an entry of the journal to the copy.
+func (m *mirror) apply(e *mirrorEntry) {
+	switch e.Op {
+	case mirrorPut:
+		m.pairs[e.Key] = &api.KVPair{Key: e.Key, Flags: e.Flags, Value: e.Value}
+	case mirrorDelete:
+		delete(m.pairs, e.Key)
+	case mirrorCommit:
+		m.primed, m.index = true, e.Index
+	}
+}
+
+// append applies the entry and appends it to the journal. Errors are kept by
+// the writer and returned by the next commit.
+func (m *mirror) append(e *mirrorEntry) {
+	m.apply(e)
+	if m.w == nil {
+		return
+	}
+	enc, err := json.Marshal(e)
+	if err != nil {
+		return
+	}
+	m.w.Write(append(enc, '\n'))
+	m.entries++
+}
+
+// get returns a copy of the pair at the key, or nil if it does not exist.
+func (m *mirror) get(key string) *api.KVPair {
+	m.Lock()
+	defer m.Unlock()
+	pair, ok := m.pairs[key]
+	if !ok {
+		return nil
+	}
+	return &api.KVPair{Key: key, Flags: pair.Flags, Value: pair.Value}
+}
+
+// keys returns the sorted keys under the prefix.
+func (m *mirror) keys(prefix string) []string {
+	m.Lock()
+	defer m.Unlock()
+	var keys []string
+	for key := range m.pairs {
+		if strings.HasPrefix(key, prefix) {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// put records the pair written into the destination.
+func (m *mirror) put(pair *api.KVPair) {
+	m.Lock()
+	defer m.Unlock()
+	m.append(&mirrorEntry{
+		Op:    mirrorPut,
+		Key:   pair.Key,
+		Flags: pair.Flags,
+		Value: bytes.Clone(pair.Value),
+	})
+}
+
+// delete records the key deleted from the destination.
+func (m *mirror) delete(key string) {
+	m.Lock()
+	defer m.Unlock()
+	m.append(&mirrorEntry{Op: mirrorDelete, Key: key})
+}
+
+// commit appends a commit at the applied index and syncs the journal, which
+// is rewritten once it mostly holds changes overwritten since. A mirror which
+// cannot be committed is primed again.
+func (m *mirror) commit(index uint64) error {
+	m.Lock()
+	defer m.Unlock()
+	if !m.primed || m.w == nil {
+		return nil
+	}
+
+	m.append(&mirrorEntry{Op: mirrorCommit, Index: index})
+	if m.entries > mirrorCompactMin && m.entries > 2*len(m.pairs) {
+		return m.rewrite(index)
+	}
+	if err := m.w.Flush(); err != nil {
+		m.primed = false
+		return errors.Wrapf(err, "writing %q", m.path)
+	}
+	if err := m.file.Sync(); err != nil {
+		m.primed = false
+		return errors.Wrapf(err, "syncing %q", m.path)
+	}
+	return nil
+}
+
+// rewrite replaces the journal with the keys of the copy, committed at the
+// applied index, and reopens it for the next changes.
+func (m *mirror) rewrite(index uint64) error {
+	if err := m.close(); err != nil {
+		log.Printf("[WARN] (runner) could not close the mirror %q: %s", m.path, err)
+	}
+	m.primed = false
+
+	if err := os.MkdirAll(filepath.Dir(m.path), 0o700); err != nil {
+		return err
+	}
+	f, err := os.OpenFile(m.path+".tmp", os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0o600)
+	if err != nil {
+		return err
+	}
+
+	keys := make([]string, 0, len(m.pairs))
+	for key := range m.pairs {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	w := bufio.NewWriter(f)
+	enc := json.NewEncoder(w)
+	for _, key := range keys {
+		pair := m.pairs[key]
+		if err := enc.Encode(&mirrorEntry{Op: mirrorPut, Key: key, Flags: pair.Flags, Value: pair.Value}); err != nil {
+			f.Close()
+			return err
+		}
+	}
+	if err := enc.Encode(&mirrorEntry{Op: mirrorCommit, Index: index}); err != nil {
+		f.Close()
+		return err
+	}
+	if err := w.Flush(); err != nil {
+		f.Close()
+		return errors.Wrapf(err, "writing %q", m.path)
+	}
+	if err := f.Sync(); err != nil {
+		f.Close()
+		return errors.Wrapf(err, "syncing %q", m.path)
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	if err := os.Rename(m.path+".tmp", m.path); err != nil {
+		return err
+	}
+
+	if m.file, err = os.OpenFile(m.path, os.O_WRONLY|os.O_APPEND, 0o600); err != nil {
+		return err
+	}
+	m.w = bufio.NewWriter(m.file)
+	m.primed, m.index, m.entries = true, index, len(keys)+1
+	return nil
+}
+
+// close flushes and closes the journal.
+func (m *mirror) close() error {
+	if m.file == nil {
+		return nil
+	}
+	err := m.w.Flush()
+	if cerr := m.file.Close(); err == nil {
+		err = cerr
+	}
+	m.file, m.w = nil, nil
+	return err
+}
+
+// mirroredBackend is a Backend which reads the keys of the destinations of a
+// prefix from its mirror, and records the keys written into them.
+type mirroredBackend struct {
+	Backend
+	mirror *mirror
+
+	// scopes are the destinations of the prefix and its routes.
+	scopes []string
+}
+
+func (b *mirroredBackend) withStale(maxStale time.Duration) Backend {
+	return &mirroredBackend{Backend: allowStale(b.Backend, maxStale), mirror: b.mirror, scopes: b.scopes}
+}
+
+func (b *mirroredBackend) covers(key string) bool {
+	for _, scope := range b.scopes {
+		if strings.HasPrefix(key, scope) {
+			return true
+		}
+	}
+	return false
+}
+
+func (b *mirroredBackend) Get(key string) (*api.KVPair, error) {
+	if !b.covers(key) {
+		return b.Backend.Get(key)
+	}
+	return b.mirror.get(key), nil
+}
+
+func (b *mirroredBackend) Keys(prefix string) ([]string, error) {
+	if !b.covers(prefix) {
+		return b.Backend.Keys(prefix)
+	}
+	return b.mirror.keys(prefix), nil
+}
+
+func (b *mirroredBackend) Put(pair *api.KVPair) error {
+	if err := b.Backend.Put(pair); err != nil {
+		return err
+	}
+	if b.covers(pair.Key) {
+		b.mirror.put(pair)
+	}
+	return nil
+}
+
+func (b *mirroredBackend) Delete(key string) error {
+	if err := b.Backend.Delete(key); err != nil {
+		return err
+	}
+	if b.covers(key) {
+		b.mirror.delete(key)
+	}
+	return nil
+}
@@ -0,0 +1,104 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: MPL-2.0
+
+package replicate
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestOpenMirror(t *testing.T) {
+	cases := []struct {
+		name    string
+		journal string
+		primed  bool
+		index   uint64
+		pairs   map[string]string
+	}{
+		{
+			"missing",
+			"",
+			false,
+			0,
+			map[string]string{},
+		},
+		{
+			"committed",
+			`{"op":"put","key":"global/a","value":"MQ=="}` + "\n" +
+				`{"op":"put","key":"global/b","value":"Mg=="}` + "\n" +
+				`{"op":"commit","index":5}` + "\n" +
+				`{"op":"delete","key":"global/b"}` + "\n",
+			true,
+			5,
+			map[string]string{"global/a": "1"},
+		},
+		{
+			"truncated",
+			`{"op":"put","key":"global/a","value":"MQ=="}` + "\n" +
+				`{"op":"commit","index":5}` + "\n" +
+				`{"op":"put","key":"glo`,
+			true,
+			5,
+			map[string]string{"global/a": "1"},
+		},
+		{
+			"corrupt",
+			`{"op":"put","key":"global/a","value":"MQ=="}` + "\n" +
+				`{"op":"commit","index":5}` + "\n" +
+				`{"op":"put","key":"glo` + "\n" +
+				`{"op":"commit","index":6}` + "\n",
+			false,
+			0,
+			map[string]string{},
+		},
+	}
+
+	for i, tc := range cases {
+		t.Run(fmt.Sprintf("%d_%s", i, tc.name), func(t *testing.T) {
+			p := filepath.Join(t.TempDir(), "mirror.journal")
+			if tc.journal != "" {
+				if err := os.WriteFile(p, []byte(tc.journal), 0o600); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			m, err := openMirror(p)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer m.close()
+
+			if m.primed != tc.primed || m.index != tc.index {
+				t.Errorf("expected primed %t at %d, got %t at %d", tc.primed, tc.index, m.primed, m.index)
+			}
+			act := make(map[string]string, len(m.pairs))
+			for key, pair := range m.pairs {
+				act[key] = string(pair.Value)
+			}
+			if !reflect.DeepEqual(tc.pairs, act) {
+				t.Errorf("\nexp: %#v\nact: %#v", tc.pairs, act)
+			}
+
+			// A journal ending with a partial entry is rewritten without it, so
+			// the next entries are appended after a complete one
+			if m.primed {
+				m.put(m.pairs["global/a"])
+				if err := m.commit(6); err != nil {
+					t.Fatal(err)
+				}
+				reopened, err := openMirror(p)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if !reopened.primed || reopened.index != 6 {
+					t.Errorf("expected the journal to be committed at 6, got %t at %d",
+						reopened.primed, reopened.index)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,52 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: MPL-2.0
+
+package replicate
+
+import (
+	"github.com/hashicorp/consul-template/config"
+	"github.com/hashicorp/consul/api"
+)
+
+// loopDetectionEnabled returns true if the metadata of replicated keys records
+// their origin.
+func (r *Runner) loopDetectionEnabled() bool {
+	return config.BoolVal(r.config.LoopDetection)
+}
+
+// localOrigin returns the datacenter the prefix is written into, which is the
+// origin of the keys which must not be replicated back into it.
+func (r *Runner) localOrigin(prefix *PrefixConfig) (string, error) {
+	if dc := config.StringVal(prefix.DestinationDatacenter); dc != "" {
+		return dc, nil
+	}
+
+	r.originLock.Lock()
+	defer r.originLock.Unlock()
+
+	if r.origin == "" {
+		dc, err := r.destinationDatacenter()
+		if err != nil {
+			return "", err
+		}
+		r.origin = dc
+	}
+	return r.origin, nil
+}
+
+// originOf returns the datacenter the source key was first written in. It is
+// the origin recorded in the metadata a replicator wrote along with the key,
+// unless the key was written again since, in which case the key originated in
+// the datacenter of the prefix like a key which was never replicated.
+func originOf(prefix *PrefixConfig, value []byte, m *KeyMetadata) string {
+	if m.describes(value) && m.Origin != "" {
+		return m.Origin
+	}
+	return config.StringVal(prefix.Datacenter)
+}
+
+// lockFlags returns true if the flags mark a lock or a semaphore, which Consul
+// recognizes by the exact value of the flags, so they are never stamped.
+func lockFlags(flags uint64) bool {
+	return flags == api.LockFlagValue || flags == api.SemaphoreFlagValue
+}
@@ -0,0 +1,79 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: MPL-2.0
+
+package replicate
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/hashicorp/consul-template/config"
+	"github.com/hashicorp/consul/api"
+)
+
+func TestOriginOf(t *testing.T) {
+	cases := []struct {
+		name  string
+		value string
+		meta  *KeyMetadata
+		exp   string
+	}{
+		{
+			"no_metadata",
+			"v",
+			nil,
+			"dc1",
+		},
+		{
+			"recorded",
+			"v",
+			&KeyMetadata{ValueHash: valueHash([]byte("v")), Origin: "dc0"},
+			"dc0",
+		},
+		{
+			"written_again",
+			"w",
+			&KeyMetadata{ValueHash: valueHash([]byte("v")), Origin: "dc0"},
+			"dc1",
+		},
+		{
+			"no_origin",
+			"v",
+			&KeyMetadata{ValueHash: valueHash([]byte("v"))},
+			"dc1",
+		},
+	}
+
+	for i, tc := range cases {
+		t.Run(fmt.Sprintf("%d_%s", i, tc.name), func(t *testing.T) {
+			prefix := &PrefixConfig{Datacenter: config.String("dc1")}
+			act := originOf(prefix, []byte(tc.value), tc.meta)
+			if act != tc.exp {
+				t.Errorf("\nexp: %#v\nact: %#v", tc.exp, act)
+			}
+		})
+	}
+}
+
+func TestLockFlags(t *testing.T) {
+	cases := []struct {
+		name  string
+		flags uint64
+		exp   bool
+	}{
+		{"none", 0, false},
+		{"lock", api.LockFlagValue, true},
+		{"semaphore", api.SemaphoreFlagValue, true},
+		{"high_bits", 0xffff << 48, false},
+		{"lock_with_bits", api.LockFlagValue | 1<<32, false},
+	}
+
+	for i, tc := range cases {
+		t.Run(fmt.Sprintf("%d_%s", i, tc.name), func(t *testing.T) {
+			act := lockFlags(tc.flags)
+			if act != tc.exp {
+				t.Errorf("\nexp: %#v\nact: %#v", tc.exp, act)
+			}
+		})
+	}
+}
@@ -0,0 +1,130 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: MPL-2.0
+
+package replicate
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/hashicorp/consul-template/config"
+)
+
+// checkOverlap returns an error if the destination of any of the prefixes, or
+// of one of its routes, overlaps its source in the same datacenter and admin
+// partition. Every key written to such a destination is read back from the
+// source and replicated again, and keys of the source are deleted as missing
+// from the destination.
+func (r *Runner) checkOverlap(prefixes []*PrefixConfig) error {
+	// Snapshots are replayed once, so they cannot loop
+	if config.BoolVal(r.config.AllowOverlap) || r.snapshots != nil {
+		return nil
+	}
+
+	for _, prefix := range prefixes {
+		if config.StringVal(prefix.Backend) != BackendConsul ||
+			partitionName(prefix.Partition) != partitionName(prefix.DestinationPartition) {
+			continue
+		}
+
+		for _, dest := range routeDestinations(prefix) {
+			if !overlaps(config.StringVal(prefix.Source), dest) || r.excluded(dest) {
+				continue
+			}
+
+			// The agent is only queried once an overlapping path is found
+			destination, err := r.prefixDatacenter(prefix)
+			if err != nil {
+				return err
+			}
+			if config.StringVal(prefix.Datacenter) != destination {
+				continue
+			}
+
+			return fmt.Errorf("%s overlaps its destination %q in the same datacenter, "+
+				"which would replicate every change again (set allow_overlap to allow it)",
+				prefix.Dependency, dest)
+		}
+	}
+	return nil
+}
+
+// overlaps returns true if either path is a prefix of the other. Consul lists
+// keys by string prefix, so "global" also overlaps "global2".
+func overlaps(source, destination string) bool {
+	return strings.HasPrefix(source, destination) || strings.HasPrefix(destination, source)
+}
+
+// checkDestinations returns an error if two of the prefixes write into
+// overlapping destinations of the same backend, datacenter and admin partition,
+// where they would overwrite and delete each other's keys. Merged prefixes share their
+// destination on purpose, and a destination nested in another one may be
+// excluded from the source of the outer prefix, which then leaves it alone.
+func (r *Runner) checkDestinations(prefixes []*PrefixConfig) error {
+	for i, a := range prefixes {
+		for _, b := range prefixes[i+1:] {
+			if config.StringVal(a.Backend) != config.StringVal(b.Backend) ||
+				partitionName(a.DestinationPartition) != partitionName(b.DestinationPartition) ||
+				a.Dependency.String() == b.Dependency.String() {
+				continue
+			}
+			if config.BoolVal(a.Merged) && config.BoolVal(b.Merged) &&
+				config.StringVal(a.Destination) == config.StringVal(b.Destination) {
+				continue
+			}
+
+			for _, destA := range routeDestinations(a) {
+				for _, destB := range routeDestinations(b) {
+					if !overlaps(destA, destB) || r.yields(a, destA, destB) || r.yields(b, destB, destA) {
+						continue
+					}
+
+					same, err := r.sameDestinationDatacenter(a, b)
+					if err != nil {
+						return err
+					}
+					if !same {
+						continue
+					}
+					return fmt.Errorf("%s and %s write into the overlapping destinations "+
+						"%q and %q, and would overwrite each other (merge them, or "+
+						"exclude the nested destination from the outer prefix)",
+						a.Dependency, b.Dependency, destA, destB)
+				}
+			}
+		}
+	}
+	return nil
+}
+
+// sameDestinationDatacenter returns true if the prefixes write into the same
+// datacenter. The agent is only queried when a single one of them names the
+// datacenter it writes into.
+func (r *Runner) sameDestinationDatacenter(a, b *PrefixConfig) (bool, error) {
+	dcA, dcB := config.StringVal(a.DestinationDatacenter), config.StringVal(b.DestinationDatacenter)
+	if dcA == dcB || dcA != "" && dcB != "" {
+		return dcA == dcB, nil
+	}
+
+	local, err := r.destinationDatacenter()
+	if err != nil {
+		return false, err
+	}
+	if dcA == "" {
+		dcA = local
+	}
+	if dcB == "" {
+		dcB = local
+	}
+	return dcA == dcB, nil
+}
+
+// yields returns true if the nested destination lies under the destination of
+// the outer prefix, and the matching path of its source is excluded.
+func (r *Runner) yields(outer *PrefixConfig, destination, nested string) bool {
+	if destination != config.StringVal(outer.Destination) ||
+		nested == destination || !strings.HasPrefix(nested, destination) {
+		return false
+	}
+	return r.excluded(config.StringVal(outer.Source) + strings.TrimPrefix(nested, destination))
+}
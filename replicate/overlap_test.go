@@ -0,0 +1,336 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: MPL-2.0
+
+package replicate
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/hashicorp/consul-template/config"
+)
+
+func TestRunner_checkOverlap(t *testing.T) {
+	agent := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		fmt.Fprint(w, `{"Config": {"Datacenter": "dc1"}}`)
+	}))
+	defer agent.Close()
+
+	cases := []struct {
+		name   string
+		config string
+		err    bool
+	}{
+		{
+			"disjoint",
+			`prefix {
+				source      = "global@dc1"
+				destination = "replica"
+			}`,
+			false,
+		},
+		{
+			"nested_destination",
+			`prefix {
+				source      = "global@dc1"
+				destination = "global/replica"
+			}`,
+			true,
+		},
+		{
+			"nested_source",
+			`prefix {
+				source      = "global/a@dc1"
+				destination = "global"
+			}`,
+			true,
+		},
+		{
+			"string_prefix",
+			`prefix {
+				source      = "global@dc1"
+				destination = "global2"
+			}`,
+			true,
+		},
+		{
+			"route",
+			`prefix {
+				source      = "global@dc1"
+				destination = "replica"
+
+				route {
+					pattern     = "^secret/"
+					destination = "global-secret"
+				}
+			}`,
+			true,
+		},
+		{
+			"other_datacenter",
+			`prefix {
+				source      = "global@dc2"
+				destination = "global/replica"
+			}`,
+			false,
+		},
+		{
+			"source_dc",
+			`prefix {
+				source      = "global"
+				source_dc   = "dc1"
+				destination = "global/replica"
+			}`,
+			true,
+		},
+		{
+			"other_destination_datacenter",
+			`prefix {
+				source         = "global"
+				source_dc      = "dc1"
+				destination    = "global/replica"
+				destination_dc = "dc2"
+			}`,
+			false,
+		},
+		{
+			"same_destination_datacenter",
+			`prefix {
+				source         = "global@dc2"
+				destination    = "global/replica"
+				destination_dc = "dc2"
+			}`,
+			true,
+		},
+		{
+			"other_partition",
+			`prefix {
+				source                = "global@dc1"
+				destination_partition = "edge-apps"
+			}`,
+			false,
+		},
+		{
+			"same_partition",
+			`prefix {
+				source                = "global@dc1"
+				partition             = "edge-apps"
+				destination_partition = "edge-apps"
+			}`,
+			true,
+		},
+		{
+			"excluded",
+			`prefix {
+				source      = "global@dc1"
+				destination = "global/replica"
+			}
+			exclude {
+				source = "global/replica"
+			}`,
+			false,
+		},
+		{
+			"allowed",
+			`allow_overlap = true
+			prefix {
+				source      = "global@dc1"
+				destination = "global/replica"
+			}`,
+			false,
+		},
+	}
+
+	for i, tc := range cases {
+		t.Run(fmt.Sprintf("%d_%s", i, tc.name), func(t *testing.T) {
+			c, err := Parse(tc.config)
+			if err != nil {
+				t.Fatal(err)
+			}
+			c = DefaultConfig().Merge(c)
+			c.DestinationConsul.Address = config.String(strings.TrimPrefix(agent.URL, "http://"))
+			c.Finalize()
+
+			r, err := NewRunner(c, true)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			err = r.checkOverlap(*c.Prefixes)
+			if (err != nil) != tc.err {
+				t.Errorf("\nexp: %t\nact: %s", tc.err, err)
+			}
+		})
+	}
+}
+
+func TestRunner_checkDestinations(t *testing.T) {
+	cases := []struct {
+		name   string
+		config string
+		err    bool
+	}{
+		{
+			"disjoint",
+			`prefix {
+				source = "global@dc1"
+			}
+			prefix {
+				source = "local@dc2"
+			}`,
+			false,
+		},
+		{
+			"same_destination",
+			`prefix {
+				source      = "global@dc1"
+				destination = "replica"
+			}
+			prefix {
+				source      = "global@dc2"
+				destination = "replica"
+			}`,
+			true,
+		},
+		{
+			"nested_destination",
+			`prefix {
+				source = "global@dc1"
+			}
+			prefix {
+				source      = "local@dc2"
+				destination = "global/local"
+			}`,
+			true,
+		},
+		{
+			"string_prefix",
+			`prefix {
+				source = "global@dc1"
+			}
+			prefix {
+				source = "global2@dc2"
+			}`,
+			true,
+		},
+		{
+			"route",
+			`prefix {
+				source = "global@dc1"
+
+				route {
+					pattern     = "^secret/"
+					destination = "secret"
+				}
+			}
+			prefix {
+				source = "secret@dc2"
+			}`,
+			true,
+		},
+		{
+			"merged",
+			`prefix {
+				source      = "defaults@dc1"
+				destination = "effective"
+				merge       = true
+			}
+			prefix {
+				source      = "overrides@dc1"
+				destination = "effective"
+				merge       = true
+			}`,
+			false,
+		},
+		{
+			"excluded",
+			`prefix {
+				source = "global@dc1"
+			}
+			prefix {
+				source      = "local@dc2"
+				destination = "global/local"
+			}
+			exclude {
+				source = "global/local"
+			}`,
+			false,
+		},
+		{
+			"other_partition",
+			`prefix {
+				source = "global@dc1"
+			}
+			prefix {
+				source                = "global@dc2"
+				destination_partition = "edge-apps"
+			}`,
+			false,
+		},
+		{
+			"other_backend",
+			`prefix {
+				source = "global@dc1"
+			}
+			prefix {
+				source  = "global@dc2"
+				backend = "kubernetes"
+			}`,
+			false,
+		},
+		{
+			"other_datacenter",
+			`prefix {
+				source = "global@dc1"
+			}
+			prefix {
+				source         = "global@dc2"
+				destination_dc = "dc3"
+			}`,
+			false,
+		},
+		{
+			"other_datacenters",
+			`prefix {
+				source         = "global@dc1"
+				destination_dc = "dc2"
+			}
+			prefix {
+				source         = "global@dc2"
+				destination_dc = "dc1"
+			}`,
+			false,
+		},
+		{
+			"local_datacenter",
+			`prefix {
+				source = "global@dc1"
+			}
+			prefix {
+				source         = "global@dc2"
+				destination_dc = "dc0"
+			}`,
+			true,
+		},
+	}
+
+	for i, tc := range cases {
+		t.Run(fmt.Sprintf("%d_%s", i, tc.name), func(t *testing.T) {
+			c, err := Parse(tc.config)
+			if err != nil {
+				t.Fatal(err)
+			}
+			c = DefaultConfig().Merge(c)
+			c.Finalize()
+
+			r := &Runner{config: c, datacenter: "dc0"}
+			err = r.checkDestinations(*c.Prefixes)
+			if (err != nil) != tc.err {
+				t.Errorf("\nexp: %t\nact: %s", tc.err, err)
+			}
+		})
+	}
+}
@@ -0,0 +1,44 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: MPL-2.0
+
+package replicate
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+
+	"github.com/hashicorp/consul-template/config"
+	"github.com/hashicorp/consul/api"
+)
+
+// checkOwnership returns an error if the destination of the prefix cannot
+// hold the metadata of the keys it owns.
+func checkOwnership(prefix *PrefixConfig) error {
+	if config.BoolVal(prefix.DeleteOwnedOnly) && config.StringVal(prefix.Backend) != BackendConsul {
+		return fmt.Errorf("prefix %q: delete_owned_only requires the %q backend",
+			config.StringVal(prefix.Source), BackendConsul)
+	}
+	return nil
+}
+
+// owned returns true if the destination key was written by a replicator, which
+// recorded itself as the writer in the metadata of its current value. A key
+// written by hand since is not owned anymore.
+func (r *Runner) owned(backend Backend, pair *api.KVPair) (bool, error) {
+	if pair == nil {
+		return false, nil
+	}
+
+	metaPair, err := backend.Get(r.metadataKey(pair.Key))
+	if err != nil || metaPair == nil {
+		return false, err
+	}
+
+	var m KeyMetadata
+	if err := json.Unmarshal(metaPair.Value, &m); err != nil {
+		log.Printf("[WARN] (runner) ignoring the metadata of %q: %s", pair.Key, err)
+		return false, nil
+	}
+	return m.describes(pair.Value) && m.Writer != "", nil
+}
@@ -0,0 +1,99 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: MPL-2.0
+
+package replicate
+
+import (
+	"crypto/md5"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/hashicorp/consul-template/config"
+	"github.com/hashicorp/consul/api"
+)
+
+// ownerTimeout is how long the claim of a destination is held without being
+// renewed by a pass, after which another prefix may take the destination over.
+const ownerTimeout = 24 * time.Hour
+
+// Owner is the claim of a destination by the prefix which replicates into
+// it, kept in the status dir.
+type Owner struct {
+	Source      string
+	Datacenter  string
+	Replicator  string
+	LastUpdated time.Time
+}
+
+// claimDestinationsEnabled returns true if the destinations of the prefixes
+// are claimed before they are written.
+func (r *Runner) claimDestinationsEnabled() bool {
+	return config.BoolVal(r.config.ClaimDestinations)
+}
+
+// claimDestination claims the destination for the prefix, or returns an error
+// if it is owned by another prefix which renewed its claim recently. Both
+// prefixes would otherwise overwrite and delete each other's keys. The claim
+// is only written if it did not change since it was read, so of two prefixes
+// claiming the destination at once, one fails.
+func (r *Runner) claimDestination(prefix *PrefixConfig, destination string) error {
+	if !r.claimDestinationsEnabled() {
+		return nil
+	}
+
+	backend := r.statusBackend(prefix)
+	key := r.ownerPath(destination)
+
+	pair, err := backend.Get(key)
+	if err != nil {
+		return fmt.Errorf("failed to read owner of %q: %s", destination, err)
+	}
+	if pair != nil {
+		var owner Owner
+		if err := json.Unmarshal(pair.Value, &owner); err != nil {
+			return fmt.Errorf("failed to decode owner of %q: %s", destination, err)
+		}
+
+		if (owner.Source != config.StringVal(prefix.Source) ||
+			owner.Datacenter != config.StringVal(prefix.Datacenter)) &&
+			time.Since(owner.LastUpdated) < ownerTimeout {
+			return fmt.Errorf("destination %q is owned by %s@%s of replicator %q, "+
+				"which would overwrite the keys of %s", destination, owner.Source,
+				owner.Datacenter, owner.Replicator, prefix.Dependency)
+		}
+	}
+
+	enc, err := json.MarshalIndent(&Owner{
+		Source:      config.StringVal(prefix.Source),
+		Datacenter:  config.StringVal(prefix.Datacenter),
+		Replicator:  r.replicator,
+		LastUpdated: time.Now().UTC(),
+	}, "", "  ")
+	if err != nil {
+		return err
+	}
+	claim := &api.KVPair{Key: key, Value: enc}
+	if pair != nil {
+		claim.ModifyIndex = pair.ModifyIndex
+	}
+	ok, err := putCAS(backend, claim)
+	if err != nil {
+		return fmt.Errorf("failed to claim %q: %s", destination, err)
+	}
+	if !ok {
+		return fmt.Errorf("failed to claim %q: its owner changed while it was claimed",
+			destination)
+	}
+	return nil
+}
+
+// ownerPath returns the path of the claim of the destination, which lives in
+// the status dir.
+func (r *Runner) ownerPath(destination string) string {
+	hash := md5.Sum([]byte(destination))
+	return strings.TrimRight(config.StringVal(r.config.StatusDir), "/") +
+		"/owners/" + hex.EncodeToString(hash[:])
+}
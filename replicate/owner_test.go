@@ -0,0 +1,149 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: MPL-2.0
+
+package replicate
+
+import (
+	"encoding/json"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/hashicorp/consul-template/config"
+	"github.com/hashicorp/consul/api"
+)
+
+func TestRunner_claimDestination(t *testing.T) {
+	cases := []struct {
+		name   string
+		owner  *Owner
+		racing bool
+		err    bool
+	}{
+		{
+			"unclaimed",
+			nil,
+			false,
+			false,
+		},
+		{
+			"owned",
+			&Owner{Source: "global", Datacenter: "dc1", LastUpdated: time.Now()},
+			false,
+			false,
+		},
+		{
+			"other_datacenter",
+			&Owner{Source: "global", Datacenter: "dc2", LastUpdated: time.Now()},
+			false,
+			true,
+		},
+		{
+			"other_source",
+			&Owner{Source: "local", Datacenter: "dc1", LastUpdated: time.Now()},
+			false,
+			true,
+		},
+		{
+			"abandoned",
+			&Owner{Source: "local", Datacenter: "dc1", LastUpdated: time.Now().Add(-2 * ownerTimeout)},
+			false,
+			false,
+		},
+		{
+			"unclaimed_racing",
+			nil,
+			true,
+			true,
+		},
+		{
+			"abandoned_racing",
+			&Owner{Source: "local", Datacenter: "dc1", LastUpdated: time.Now().Add(-2 * ownerTimeout)},
+			true,
+			true,
+		},
+	}
+
+	for i, tc := range cases {
+		t.Run(fmt.Sprintf("%d_%s", i, tc.name), func(t *testing.T) {
+			c := DefaultConfig().Merge(&Config{ClaimDestinations: config.Bool(true)})
+			c.Finalize()
+
+			store := &racingBackend{
+				memoryBackend: &memoryBackend{pairs: make(map[string][]byte)},
+				index:         1,
+				racing:        tc.racing,
+			}
+			r := &Runner{config: c, statusStore: store, replicator: "r1"}
+
+			prefix, err := ParsePrefixConfig("global@dc1")
+			if err != nil {
+				t.Fatal(err)
+			}
+			key := r.ownerPath(config.StringVal(prefix.Destination))
+
+			if tc.owner != nil {
+				enc, err := json.Marshal(tc.owner)
+				if err != nil {
+					t.Fatal(err)
+				}
+				store.Put(&api.KVPair{Key: key, Value: enc})
+			}
+
+			err = r.claimDestination(prefix, config.StringVal(prefix.Destination))
+			if (err != nil) != tc.err {
+				t.Fatalf("\nexp: %t\nact: %s", tc.err, err)
+			}
+			if err != nil {
+				return
+			}
+
+			pair, err := store.Get(key)
+			if err != nil || pair == nil {
+				t.Fatalf("expected a claim, got %v", err)
+			}
+			var owner Owner
+			if err := json.Unmarshal(pair.Value, &owner); err != nil {
+				t.Fatal(err)
+			}
+			if owner.Source != "global" || owner.Datacenter != "dc1" || owner.Replicator != "r1" {
+				t.Errorf("\nexp: %#v\nact: %#v", "global@dc1 of r1", owner)
+			}
+		})
+	}
+}
+
+// racingBackend is a memoryBackend whose keys are all at the same modify
+// index, which is never zero. If it is racing, another prefix claims every key right before it is
+// written with check-and-set.
+type racingBackend struct {
+	*memoryBackend
+	index  uint64
+	racing bool
+}
+
+func (b *racingBackend) Get(key string) (*api.KVPair, error) {
+	pair, err := b.memoryBackend.Get(key)
+	if pair != nil {
+		pair.ModifyIndex = b.index
+	}
+	return pair, err
+}
+
+func (b *racingBackend) cas(pair *api.KVPair) (bool, error) {
+	if b.racing {
+		enc, err := json.Marshal(&Owner{Source: "other", Datacenter: "dc1", LastUpdated: time.Now()})
+		if err != nil {
+			return false, err
+		}
+		b.index++
+		b.memoryBackend.Put(&api.KVPair{Key: pair.Key, Value: enc})
+	}
+
+	_, exists := b.pairs[pair.Key]
+	if (exists && pair.ModifyIndex != b.index) || (!exists && pair.ModifyIndex != 0) {
+		return false, nil
+	}
+	b.index++
+	return true, b.memoryBackend.Put(pair)
+}
@@ -0,0 +1,130 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: MPL-2.0
+
+package replicate
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+
+	"github.com/hashicorp/consul-template/config"
+	dep "github.com/hashicorp/consul-template/dependency"
+)
+
+// DefaultPartition is the admin partition requests are made in when none is
+// given, unless the token belongs to another one.
+const DefaultPartition = "default"
+
+// partitionKey is the key of the admin partition in the context of a request.
+type partitionKey struct{}
+
+// withPartition returns the context of requests to the given admin partition.
+// The Consul API client has no option for partitions, so the partition is
+// carried by the context to the transport, which adds it to the request.
+func withPartition(ctx context.Context, partition string) context.Context {
+	if partition == "" {
+		return ctx
+	}
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	return context.WithValue(ctx, partitionKey{}, partition)
+}
+
+// partitionTransport adds the admin partition of the context of every request
+// to its query.
+type partitionTransport struct {
+	base http.RoundTripper
+}
+
+func (t *partitionTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	partition, _ := req.Context().Value(partitionKey{}).(string)
+	if partition == "" {
+		return t.base.RoundTrip(req)
+	}
+
+	req = req.Clone(req.Context())
+	q := req.URL.Query()
+	q.Set("partition", partition)
+	req.URL.RawQuery = q.Encode()
+	return t.base.RoundTrip(req)
+}
+
+// setPartitions makes the client send the admin partition of the context of
+// its requests.
+func setPartitions(hc *http.Client) {
+	if _, ok := hc.Transport.(*partitionTransport); ok {
+		return
+	}
+	base := hc.Transport
+	if base == nil {
+		base = http.DefaultTransport
+	}
+	hc.Transport = &partitionTransport{base: base}
+}
+
+// partitionName returns the name of the given partition, which defaults to
+// the default partition.
+func partitionName(partition *string) string {
+	if p := config.StringVal(partition); p != "" {
+		return p
+	}
+	return DefaultPartition
+}
+
+// partitionSuffix describes the given partition in messages, or returns the
+// empty string if there is none.
+func partitionSuffix(partition *string) string {
+	if p := config.StringVal(partition); p != "" {
+		return fmt.Sprintf(" of partition %q", p)
+	}
+	return ""
+}
+
+// checkPartitions returns an error if the source or destination of the prefix
+// cannot be in an admin partition.
+func (r *Runner) checkPartitions(prefix *PrefixConfig) error {
+	source := config.StringVal(prefix.Source)
+	if config.StringVal(prefix.Partition) != "" && r.snapshots == nil {
+		if _, ok := r.source.(partitionSource); !ok {
+			return fmt.Errorf("prefix %q: the source does not support partitions", source)
+		}
+	}
+	if config.StringVal(prefix.DestinationPartition) != "" && config.StringVal(prefix.Backend) != BackendConsul {
+		return fmt.Errorf("prefix %q: destination_partition requires the %q backend",
+			source, BackendConsul)
+	}
+	return nil
+}
+
+// partitionSource is a Source whose reads can be made in an admin partition.
+type partitionSource interface {
+	Source
+
+	// inPartition returns a copy of the source which reads the partition.
+	inPartition(partition string) Source
+}
+
+// sourceFor returns the source of the prefix, in its admin partition if it
+// has one.
+func (r *Runner) sourceFor(prefix *PrefixConfig) Source {
+	if p := config.StringVal(prefix.Partition); p != "" {
+		if s, ok := r.source.(partitionSource); ok {
+			return s.inPartition(p)
+		}
+	}
+	return r.source
+}
+
+// watchPartition returns the admin partition of the prefixes watched by the
+// dependency. Prefixes in a partition are never coalesced, so a coalesced
+// watch is always in the default partition.
+func watchPartition(d *dep.KVListQuery, prefixes []*PrefixConfig) string {
+	for _, prefix := range prefixes {
+		if prefix.Dependency.String() == d.String() {
+			return config.StringVal(prefix.Partition)
+		}
+	}
+	return ""
+}
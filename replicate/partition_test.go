@@ -0,0 +1,95 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: MPL-2.0
+
+package replicate
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/hashicorp/consul-template/config"
+	"github.com/hashicorp/consul/api"
+)
+
+func TestSetPartitions(t *testing.T) {
+	var act string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		act = r.URL.Query().Get("partition")
+		http.NotFound(w, r)
+	}))
+	defer srv.Close()
+
+	hc := &http.Client{}
+	client, err := api.NewClient(&api.Config{Address: srv.URL, HttpClient: hc})
+	if err != nil {
+		t.Fatal(err)
+	}
+	setUserAgent(hc, "replicator/1")
+	setPartitions(hc)
+	backend := bindContext(newConsulBackend(client), context.Background()).(*consulBackend)
+
+	cases := []struct {
+		name    string
+		backend Backend
+		exp     string
+	}{
+		{
+			"none",
+			backend,
+			"",
+		},
+		{
+			"partition",
+			backend.inPartition("edge-apps"),
+			"edge-apps",
+		},
+		{
+			"token",
+			backend.inPartition("edge-apps").withToken("abcd"),
+			"edge-apps",
+		},
+		{
+			"context",
+			bindContext(backend.inPartition("edge-apps"), context.Background()),
+			"edge-apps",
+		},
+	}
+
+	for i, tc := range cases {
+		t.Run(fmt.Sprintf("%d_%s", i, tc.name), func(t *testing.T) {
+			act = "unset"
+			if _, err := tc.backend.Get("global/a"); err != nil {
+				t.Fatal(err)
+			}
+			if act != tc.exp {
+				t.Errorf("\nexp: %#v\nact: %#v", tc.exp, act)
+			}
+		})
+	}
+}
+
+func TestRunner_statusPath_partition(t *testing.T) {
+	r := &Runner{config: &Config{StatusDir: config.String("service/consul-replicate/statuses")}}
+
+	prefix, err := ParsePrefixConfig("global@dc1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	plain := r.statusPath(prefix)
+
+	partitioned := prefix.Copy()
+	partitioned.DestinationPartition = config.String("edge-apps")
+	if act := r.statusPath(partitioned); act == plain {
+		t.Errorf("expected a status of its own, got %q", act)
+	}
+
+	explicit := prefix.Copy()
+	explicit.Partition = config.String(DefaultPartition)
+	explicit.DestinationPartition = config.String(DefaultPartition)
+	if r.statusPath(explicit) == r.statusPath(partitioned) {
+		t.Errorf("expected the partitions to be part of the status path")
+	}
+}
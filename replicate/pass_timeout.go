@@ -0,0 +1,79 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: MPL-2.0
+
+package replicate
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"runtime"
+
+	"github.com/hashicorp/consul-template/config"
+)
+
+// runPass runs a replication pass of the prefix. With a replication timeout, a
+// pass which does not finish in time has its context cancelled, which aborts
+// its requests to the destination, and the goroutines are dumped to the log.
+// The pass then fails without waiting for it, so it is retried, and later
+// passes of the prefix fail until the cancelled one has returned.
+func (r *Runner) runPass(prefix *PrefixConfig, pass func(ctx context.Context) error) error {
+	parent := r.passContext()
+	timeout := config.TimeDurationVal(r.config.ReplicationTimeout)
+	if timeout <= 0 {
+		return pass(parent)
+	}
+
+	id := prefix.Dependency.String()
+	r.stuckLock.Lock()
+	_, stuck := r.stuck[id]
+	r.stuckLock.Unlock()
+	if stuck {
+		return fmt.Errorf("cancelled pass of %s is still running", id)
+	}
+
+	ctx, cancel := context.WithTimeout(parent, timeout)
+	defer cancel()
+
+	doneCh := make(chan error, 1)
+	go func() {
+		doneCh <- pass(ctx)
+	}()
+
+	select {
+	case err := <-doneCh:
+		return err
+	case <-ctx.Done():
+	}
+	if parent.Err() != nil {
+		return parent.Err()
+	}
+
+	log.Printf("[ERR] (runner) pass of %s did not finish within %s, cancelling. "+
+		"Goroutines:\n\n%s", id, timeout, stacks())
+
+	r.stuckLock.Lock()
+	r.stuck[id] = struct{}{}
+	r.stuckLock.Unlock()
+	go func() {
+		err := <-doneCh
+		log.Printf("[INFO] (runner) cancelled pass of %s returned: %v", id, err)
+		r.stuckLock.Lock()
+		delete(r.stuck, id)
+		r.stuckLock.Unlock()
+	}()
+
+	return fmt.Errorf("pass of %s timed out after %s", id, timeout)
+}
+
+// stacks returns the stack traces of every goroutine.
+func stacks() []byte {
+	buf := make([]byte, 1<<16)
+	for {
+		n := runtime.Stack(buf, true)
+		if n < len(buf) {
+			return buf[:n]
+		}
+		buf = make([]byte, 2*len(buf))
+	}
+}
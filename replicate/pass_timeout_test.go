@@ -0,0 +1,93 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: MPL-2.0
+
+package replicate
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/hashicorp/consul-template/config"
+)
+
+func TestRunner_runPass(t *testing.T) {
+	prefix, err := ParsePrefixConfig("global@dc1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	r := &Runner{
+		config: &Config{ReplicationTimeout: config.TimeDuration(50 * time.Millisecond)},
+		stuck:  make(map[string]struct{}),
+		ctx:    context.Background(),
+	}
+
+	// A pass which ignores the cancellation is abandoned
+	releaseCh := make(chan struct{})
+	err = r.runPass(prefix, func(ctx context.Context) error {
+		<-ctx.Done()
+		<-releaseCh
+		return ctx.Err()
+	})
+	if err == nil || !strings.Contains(err.Error(), "timed out") {
+		t.Fatalf("expected a timeout, got %v", err)
+	}
+
+	// The prefix is not replicated again until it returns
+	err = r.runPass(prefix, func(ctx context.Context) error { return nil })
+	if err == nil || !strings.Contains(err.Error(), "still running") {
+		t.Fatalf("expected the pass to be running, got %v", err)
+	}
+
+	close(releaseCh)
+	for i := 0; ; i++ {
+		if err = r.runPass(prefix, func(ctx context.Context) error { return nil }); err == nil {
+			break
+		}
+		if i == 100 {
+			t.Fatal(err)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestRunner_runPassLostLock(t *testing.T) {
+	prefix, err := ParsePrefixConfig("global@dc1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	r := &Runner{
+		config: &Config{},
+		ctx:    context.Background(),
+	}
+	r.setLeader(true)
+
+	// Losing the lock cancels the pass in flight
+	startedCh := make(chan struct{})
+	errCh := make(chan error, 1)
+	go func() {
+		errCh <- r.runPass(prefix, func(ctx context.Context) error {
+			close(startedCh)
+			<-ctx.Done()
+			return ctx.Err()
+		})
+	}()
+	<-startedCh
+	r.setLeader(false)
+
+	select {
+	case err := <-errCh:
+		if err != context.Canceled {
+			t.Errorf("\nexp: %#v\nact: %#v", context.Canceled, err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("the pass was not cancelled")
+	}
+
+	// The passes of the next term run again
+	r.setLeader(true)
+	if err := r.runPass(prefix, func(ctx context.Context) error { return ctx.Err() }); err != nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,214 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: MPL-2.0
+
+package replicate
+
+import (
+	"fmt"
+	"log"
+	"math"
+	"time"
+
+	"github.com/hashicorp/consul-template/config"
+	dep "github.com/hashicorp/consul-template/dependency"
+	"github.com/hashicorp/consul/api"
+)
+
+// pendingWrite is a write of the pipeline which was not acknowledged yet.
+type pendingWrite struct {
+	// index is the source index of the written key.
+	index uint64
+
+	// ack is called with the result of the write.
+	ack func(error) error
+
+	doneCh chan struct{}
+	err    error
+}
+
+// writePipeline issues the writes of a pass without waiting for the previous
+// ones, keeping up to window writes in flight, and acknowledges them in the
+// order they were issued. With a window of one, every write is acknowledged
+// before the next one is issued.
+type writePipeline struct {
+	backend Backend
+	window  int
+	pending []*pendingWrite
+}
+
+func newWritePipeline(backend Backend, window int) *writePipeline {
+	if window < 1 {
+		window = 1
+	}
+	return &writePipeline{backend: backend, window: window}
+}
+
+// put issues the write of the pair, whose source index is given. The oldest
+// writes are acknowledged first while the window is full. ack is called with
+// the result of the write once every earlier write was acknowledged, and an
+// error it returns is returned by the call which acknowledged it.
+func (p *writePipeline) put(pair *api.KVPair, index uint64, ack func(error) error) error {
+	for len(p.pending) >= p.window {
+		if err := p.ackNext(); err != nil {
+			return err
+		}
+	}
+
+	w := &pendingWrite{index: index, ack: ack, doneCh: make(chan struct{})}
+	p.pending = append(p.pending, w)
+	if p.window == 1 {
+		w.err = p.backend.Put(pair)
+		close(w.doneCh)
+		return p.ackNext()
+	}
+
+	go func() {
+		w.err = p.backend.Put(pair)
+		close(w.doneCh)
+	}()
+	return nil
+}
+
+// ackNext waits for the oldest pending write and acknowledges it.
+func (p *writePipeline) ackNext() error {
+	w := p.pending[0]
+	<-w.doneCh
+	p.pending = p.pending[1:]
+	return w.ack(w.err)
+}
+
+// flush acknowledges every pending write, in order.
+func (p *writePipeline) flush() error {
+	for len(p.pending) > 0 {
+		if err := p.ackNext(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// wait waits for the pending writes without acknowledging them, so no write
+// is left in flight once a pass fails.
+func (p *writePipeline) wait() {
+	for _, w := range p.pending {
+		<-w.doneCh
+	}
+	p.pending = nil
+}
+
+// applied returns the highest index all of whose keys were acknowledged,
+// given the lowest index of the keys which were not issued yet.
+func (p *writePipeline) applied(next uint64) uint64 {
+	for _, w := range p.pending {
+		if w.index < next {
+			next = w.index
+		}
+	}
+	return next - 1
+}
+
+// pipelineEnabled returns true if the writes into the destination are
+// pipelined.
+func (r *Runner) pipelineEnabled() bool {
+	return config.BoolVal(r.config.Pipeline.Enabled)
+}
+
+// writePipeline returns the pipeline of the writes of a pass of the prefix.
+// Only the consul backend is pipelined, as the other backends read and write
+// whole objects, so concurrent writes of their keys would overwrite each
+// other.
+func (r *Runner) writePipeline(backend Backend, prefix *PrefixConfig) *writePipeline {
+	if !r.pipelineEnabled() || config.StringVal(prefix.Backend) != BackendConsul {
+		return newWritePipeline(backend, 1)
+	}
+	return newWritePipeline(backend, config.IntVal(r.config.Pipeline.Window))
+}
+
+// checkpointer periodically commits the applied index of a pass, so a pass
+// which is interrupted, for example by a restart during the initial sync of a
+// large prefix, resumes from the last checkpoint.
+type checkpointer struct {
+	r        *Runner
+	prefix   *PrefixConfig
+	status   *Status
+	interval time.Duration
+	last     time.Time
+
+	// next holds, for every pair of the pass, the lowest index of the pairs
+	// from it onward.
+	next []uint64
+}
+
+// checkpointer returns the checkpointer of a pass of the prefix which writes
+// the given pairs, or nil if the pass is only committed at its end. Merged
+// prefixes compare every key with the destination, and snapshots may be older
+// than the status, so their passes are never checkpointed.
+func (r *Runner) checkpointer(prefix *PrefixConfig, status *Status, pairs []*dep.KeyPair, snap *snapshot) *checkpointer {
+	interval := config.TimeDurationVal(r.config.Pipeline.CheckpointInterval)
+	if !r.pipelineEnabled() || interval <= 0 || config.BoolVal(prefix.Merged) || snap != nil {
+		return nil
+	}
+
+	next := make([]uint64, len(pairs)+1)
+	next[len(pairs)] = math.MaxUint64
+	for i := len(pairs) - 1; i >= 0; i-- {
+		next[i] = next[i+1]
+		if pairs[i].ModifyIndex < next[i] {
+			next[i] = pairs[i].ModifyIndex
+		}
+	}
+
+	return &checkpointer{
+		r:        r,
+		prefix:   prefix,
+		status:   status,
+		interval: interval,
+		last:     time.Now(),
+		next:     next,
+	}
+}
+
+// checkpoint commits the applied index before the i-th pair of the pass, if
+// the interval elapsed since the last checkpoint. The failures of the pass so
+// far are committed along with the failures of the previous pass, which may
+// not have been retried yet.
+func (c *checkpointer) checkpoint(i int, p *writePipeline, lastIndex uint64, failures map[string]string) error {
+	if c == nil || time.Since(c.last) < c.interval {
+		return nil
+	}
+	c.last = time.Now()
+
+	applied := p.applied(c.next[i])
+	if applied > lastIndex {
+		applied = lastIndex
+	}
+	if applied <= c.status.LastReplicated {
+		return nil
+	}
+
+	status := *c.status
+	status.LastReplicated = applied
+	status.Source = config.StringVal(c.prefix.Source)
+	status.Destination = config.StringVal(c.prefix.Destination)
+	if len(failures) > 0 {
+		status.Failures = make(map[string]string, len(c.status.Failures)+len(failures))
+		for key, reason := range c.status.Failures {
+			status.Failures[key] = reason
+		}
+		for key, reason := range failures {
+			status.Failures[key] = reason
+		}
+	}
+
+	if err := c.r.commitMirror(c.prefix, applied); err != nil {
+		return fmt.Errorf("failed to commit mirror: %s", err)
+	}
+	c.r.statusLock.Lock()
+	err := c.r.setStatus(c.prefix, &status)
+	c.r.statusLock.Unlock()
+	if err != nil {
+		return fmt.Errorf("failed to checkpoint status: %s", err)
+	}
+	log.Printf("[DEBUG] (runner) checkpointed %s at index %d", c.prefix.Dependency, applied)
+	return nil
+}
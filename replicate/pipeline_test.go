@@ -0,0 +1,99 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: MPL-2.0
+
+package replicate
+
+import (
+	"fmt"
+	"reflect"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/hashicorp/consul/api"
+)
+
+// slowBackend completes the writes of lower indexes last.
+type slowBackend struct {
+	memoryBackend
+
+	mu      sync.Mutex
+	running int
+	peak    int
+}
+
+func (b *slowBackend) Put(pair *api.KVPair) error {
+	b.mu.Lock()
+	b.running++
+	if b.running > b.peak {
+		b.peak = b.running
+	}
+	b.mu.Unlock()
+
+	index, _ := strconv.Atoi(pair.Key)
+	time.Sleep(time.Duration(10-index) * 5 * time.Millisecond)
+
+	b.mu.Lock()
+	b.running--
+	b.mu.Unlock()
+	return b.memoryBackend.Put(pair)
+}
+
+func TestWritePipeline(t *testing.T) {
+	cases := []struct {
+		name    string
+		window  int
+		peak    int
+		applied uint64
+	}{
+		{
+			"sequential",
+			1,
+			1,
+			8,
+		},
+		{
+			"pipelined",
+			4,
+			4,
+			4,
+		},
+	}
+
+	for i, tc := range cases {
+		t.Run(fmt.Sprintf("%d_%s", i, tc.name), func(t *testing.T) {
+			backend := &slowBackend{memoryBackend: memoryBackend{pairs: map[string][]byte{}}}
+			p := newWritePipeline(backend, tc.window)
+
+			var acked []uint64
+			for index := uint64(1); index <= 8; index++ {
+				index := index
+				if err := p.put(&api.KVPair{Key: fmt.Sprint(index)}, index, func(err error) error {
+					acked = append(acked, index)
+					return err
+				}); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			// Nothing from the oldest pending write onward is applied
+			if act := p.applied(9); act != tc.applied {
+				t.Errorf("\nexp: %#v\nact: %#v", tc.applied, act)
+			}
+
+			if err := p.flush(); err != nil {
+				t.Fatal(err)
+			}
+			if exp := []uint64{1, 2, 3, 4, 5, 6, 7, 8}; !reflect.DeepEqual(exp, acked) {
+				t.Errorf("\nexp: %#v\nact: %#v", exp, acked)
+			}
+			if backend.peak != tc.peak {
+				t.Errorf("\nexp: %#v\nact: %#v", tc.peak, backend.peak)
+			}
+			if act := p.applied(9); act != 8 {
+				t.Errorf("\nexp: %#v\nact: %#v", 8, act)
+			}
+		})
+	}
+}
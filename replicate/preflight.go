@@ -0,0 +1,101 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: MPL-2.0
+
+package replicate
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"math"
+
+	"github.com/hashicorp/consul-template/config"
+	"github.com/hashicorp/consul/api"
+	multierror "github.com/hashicorp/go-multierror"
+)
+
+// preflight probes that the source token can read every active prefix, and
+// that the destination token can write its destination and status keys, unless
+// the statuses are stored elsewhere, so missing permissions are reported up
+// front instead of deep inside a pass. Every missing permission is reported,
+// not only the first.
+func (r *Runner) preflight() error {
+	if !config.BoolVal(r.config.Preflight) {
+		return nil
+	}
+
+	var errs *multierror.Error
+	for _, prefix := range r.activePrefixes() {
+		// Snapshots are replayed without reading the source
+		if r.snapshots == nil {
+			if err := r.probeRead(prefix); err != nil {
+				errs = multierror.Append(errs, err)
+			}
+		}
+
+		if _, ok := r.backends[config.StringVal(prefix.Backend)].(*consulBackend); !ok {
+			continue
+		}
+		keys := []string{config.StringVal(prefix.Destination)}
+		if r.statusStore == nil {
+			keys = append(keys, r.statusPath(prefix))
+		}
+		for _, key := range keys {
+			if err := r.probeWrite(prefix, key, ""); err != nil {
+				errs = multierror.Append(errs, err)
+			}
+		}
+
+		// Routes are written with their own token, if any
+		for _, route := range *prefix.Routes {
+			if err := r.probeWrite(prefix, config.StringVal(route.Destination),
+				config.StringVal(route.Token)); err != nil {
+				errs = multierror.Append(errs, err)
+			}
+		}
+	}
+
+	if err := errs.ErrorOrNil(); err != nil {
+		return fmt.Errorf("preflight: %s", err)
+	}
+	log.Printf("[DEBUG] (runner) preflight passed")
+	return nil
+}
+
+// probeRead lists the keys directly under the source prefix.
+func (r *Runner) probeRead(prefix *PrefixConfig) error {
+	source, dc := config.StringVal(prefix.Source), config.StringVal(prefix.Datacenter)
+	_, err := r.sourceFor(prefix).Keys(source, dc)
+	if err == nil {
+		return nil
+	}
+	if responseCode(err) == 403 {
+		return fmt.Errorf("source token cannot read %q in %q%s, it needs "+
+			"key_prefix %q { policy = \"read\" }", source, dc,
+			partitionSuffix(prefix.Partition), source)
+	}
+	return fmt.Errorf("probing %s: %s", prefix.Dependency, err)
+}
+
+// probeWrite checks the destination token, or the given one if set, can write
+// the key, without changing it: the check-and-set index never matches, but
+// permissions are checked first.
+func (r *Runner) probeWrite(prefix *PrefixConfig, key, token string) error {
+	ctx := withPartition(context.Background(), config.StringVal(prefix.DestinationPartition))
+	_, _, err := r.destinationClients.Consul().KV().CAS(&api.KVPair{
+		Key:         key,
+		ModifyIndex: math.MaxUint64,
+	}, (&api.WriteOptions{
+		Token:      token,
+		Datacenter: config.StringVal(r.config.Destination.Datacenter),
+	}).WithContext(ctx))
+	if err == nil {
+		return nil
+	}
+	if responseCode(err) == 403 {
+		return fmt.Errorf("destination token cannot write %q%s for %s, it needs "+
+			"key_prefix %q { policy = \"write\" }", key,
+			partitionSuffix(prefix.DestinationPartition), prefix.Dependency, key)
+	}
+	return fmt.Errorf("probing %q: %s", key, err)
+}
@@ -0,0 +1,110 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: MPL-2.0
+
+package replicate
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/hashicorp/consul-template/config"
+)
+
+func TestRunner_preflight(t *testing.T) {
+	// The token may read "readable" and write "writable" and the status dir
+	consul := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		key := strings.TrimPrefix(req.URL.Path, "/v1/kv/")
+		switch {
+		case req.Method == http.MethodGet && strings.HasPrefix(key, "readable"):
+			fmt.Fprint(w, `[]`)
+		case req.Method == http.MethodPut && strings.HasPrefix(key, "writable"),
+			req.Method == http.MethodPut && strings.HasPrefix(key, DefaultStatusDir):
+			fmt.Fprint(w, `false`)
+		default:
+			w.WriteHeader(http.StatusForbidden)
+			fmt.Fprint(w, "Permission denied")
+		}
+	}))
+	defer consul.Close()
+
+	cases := []struct {
+		name      string
+		prefix    string
+		preflight bool
+		err       []string
+	}{
+		{
+			"allowed",
+			"readable@dc1:writable",
+			true,
+			nil,
+		},
+		{
+			"source",
+			"other@dc1:writable",
+			true,
+			[]string{`source token cannot read "other" in "dc1"`},
+		},
+		{
+			"destination",
+			"readable@dc1:other",
+			true,
+			[]string{`destination token cannot write "other"`},
+		},
+		{
+			"both",
+			"other@dc1:other",
+			true,
+			[]string{"cannot read", "cannot write"},
+		},
+		{
+			"disabled",
+			"other@dc1:other",
+			false,
+			nil,
+		},
+	}
+
+	for i, tc := range cases {
+		t.Run(fmt.Sprintf("%d_%s", i, tc.name), func(t *testing.T) {
+			prefix, err := ParsePrefixConfig(tc.prefix)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			address := config.String(strings.TrimPrefix(consul.URL, "http://"))
+			c := DefaultConfig().Merge(&Config{
+				Consul:            &config.ConsulConfig{Address: address},
+				DestinationConsul: &config.ConsulConfig{Address: address},
+				Preflight:         config.Bool(tc.preflight),
+				Prefixes:          &PrefixConfigs{prefix},
+			})
+			c.Finalize()
+
+			r, err := NewRunner(c, true)
+			if err != nil {
+				t.Fatal(err)
+			}
+			r.prefixes = *c.Prefixes
+
+			err = r.preflight()
+			if len(tc.err) == 0 {
+				if err != nil {
+					t.Fatal(err)
+				}
+				return
+			}
+			if err == nil {
+				t.Fatal("expected error")
+			}
+			for _, exp := range tc.err {
+				if !strings.Contains(err.Error(), exp) {
+					t.Errorf("expected %q to contain %q", err, exp)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,238 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: MPL-2.0
+
+package replicate
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/hashicorp/consul-template/config"
+	dep "github.com/hashicorp/consul-template/dependency"
+	"github.com/pkg/errors"
+)
+
+// recordFrame is a snapshot of the keys of a source prefix at an index, as
+// appended to the record file.
+type recordFrame struct {
+	Time       time.Time
+	Source     string
+	Datacenter string `json:",omitempty"`
+	Index      uint64
+	Pairs      []*bundleEntry
+}
+
+// recorder appends a frame to the record file whenever the index of a source
+// prefix changes.
+type recorder struct {
+	sync.Mutex
+	file    *os.File
+	enc     *json.Encoder
+	indexes map[string]uint64
+}
+
+func newRecorder(path string) (*recorder, error) {
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0o600)
+	if err != nil {
+		return nil, errors.Wrap(err, "record")
+	}
+	log.Printf("[INFO] (runner) recording the source prefixes to %q", path)
+	return &recorder{file: f, enc: json.NewEncoder(f), indexes: make(map[string]uint64)}, nil
+}
+
+// record appends a frame with the pairs of the prefix, unless its index did
+// not change since the last frame. Failing to record never fails a pass.
+func (r *recorder) record(prefix *PrefixConfig, pairs []*dep.KeyPair, index uint64) {
+	if r == nil {
+		return
+	}
+	r.Lock()
+	defer r.Unlock()
+
+	id := prefix.Dependency.String()
+	if last, ok := r.indexes[id]; ok && last == index {
+		return
+	}
+
+	frame := &recordFrame{
+		Time:       time.Now().UTC(),
+		Source:     config.StringVal(prefix.Source),
+		Datacenter: config.StringVal(prefix.Datacenter),
+		Index:      index,
+		Pairs:      make([]*bundleEntry, 0, len(pairs)),
+	}
+	for _, pair := range pairs {
+		frame.Pairs = append(frame.Pairs, &bundleEntry{
+			Key:         pair.Path,
+			Value:       []byte(pair.Value),
+			Flags:       pair.Flags,
+			CreateIndex: pair.CreateIndex,
+			ModifyIndex: pair.ModifyIndex,
+		})
+	}
+	if err := r.enc.Encode(frame); err != nil {
+		log.Printf("[WARN] (runner) could not record %s at index %d: %s",
+			prefix.Dependency, index, err)
+		return
+	}
+	r.indexes[id] = index
+}
+
+func (r *recorder) close() error {
+	if r == nil {
+		return nil
+	}
+	r.Lock()
+	defer r.Unlock()
+	return r.file.Close()
+}
+
+// replaySource is a Source which holds the keys of the frames replayed so
+// far, so every prefix is listed as it was at the time of the last frame of
+// its source.
+type replaySource struct {
+	// snapshots hold the keys of every datacenter, and indexes the index of
+	// the last frame of every source, keyed by datacenter and source.
+	snapshots map[string]*snapshot
+	indexes   map[string]uint64
+}
+
+func newReplaySource() *replaySource {
+	return &replaySource{
+		snapshots: make(map[string]*snapshot),
+		indexes:   make(map[string]uint64),
+	}
+}
+
+// apply replaces the keys of the source of the frame.
+func (s *replaySource) apply(frame *recordFrame) {
+	snap, ok := s.snapshots[frame.Datacenter]
+	if !ok {
+		snap = &snapshot{}
+		s.snapshots[frame.Datacenter] = snap
+	}
+
+	pairs := snap.pairs[:0:0]
+	for _, pair := range snap.pairs {
+		if !strings.HasPrefix(pair.Path, frame.Source) {
+			pairs = append(pairs, pair)
+		}
+	}
+	for _, e := range frame.Pairs {
+		pairs = append(pairs, &dep.KeyPair{
+			Path:        e.Key,
+			Key:         e.Key,
+			Value:       string(e.Value),
+			CreateIndex: e.CreateIndex,
+			ModifyIndex: e.ModifyIndex,
+			Flags:       e.Flags,
+		})
+	}
+	sort.Slice(pairs, func(i, j int) bool {
+		return pairs[i].Path < pairs[j].Path
+	})
+	snap.pairs = pairs
+
+	if frame.Index > snap.index {
+		snap.index = frame.Index
+	}
+	s.indexes[frame.Datacenter+"/"+frame.Source] = frame.Index
+}
+
+func (s *replaySource) List(path, datacenter string) ([]*dep.KeyPair, uint64, error) {
+	snap, ok := s.snapshots[datacenter]
+	if !ok {
+		return nil, 0, fmt.Errorf("replay: no frame recorded for datacenter %q", datacenter)
+	}
+	index, ok := s.indexes[datacenter+"/"+path]
+	if !ok {
+		index = snap.index
+	}
+	return snap.list(path), index, nil
+}
+
+func (s *replaySource) Keys(path, datacenter string) ([]string, error) {
+	snap, ok := s.snapshots[datacenter]
+	if !ok {
+		return nil, nil
+	}
+	return snap.keys(path), nil
+}
+
+func (s *replaySource) Datacenters() ([]string, error) {
+	var dcs []string
+	for dc := range s.snapshots {
+		if dc != "" {
+			dcs = append(dcs, dc)
+		}
+	}
+	sort.Strings(dcs)
+	return dcs, nil
+}
+
+// Replay reads the frames of a record file and replicates them in order,
+// running a pass of the prefix of every frame once its keys are applied, so
+// the changes of the source are replayed into the destination exactly as
+// they were recorded.
+func (r *Runner) Replay(rd io.Reader) error {
+	source := newReplaySource()
+	r.source = source
+
+	dec := json.NewDecoder(rd)
+	for n := 1; ; n++ {
+		var frame recordFrame
+		if err := dec.Decode(&frame); err == io.EOF {
+			break
+		} else if err != nil {
+			return errors.Wrapf(err, "replay: decoding frame %d", n)
+		}
+		source.apply(&frame)
+
+		if err := r.discover(); err != nil {
+			return errors.Wrapf(err, "replay: frame %d", n)
+		}
+		prefix := r.replayPrefix(&frame)
+		if prefix == nil {
+			log.Printf("[DEBUG] (runner) replay: no prefix for %q in frame %d, skipping",
+				frame.Source, n)
+			continue
+		}
+
+		log.Printf("[DEBUG] (runner) replay: frame %d of %s at index %d",
+			n, prefix.Dependency, frame.Index)
+		doneCh := make(chan struct{}, 1)
+		errCh := make(chan error, 1)
+		r.replicate(prefix, r.config.Excludes, doneCh, errCh)
+		select {
+		case <-doneCh:
+		case err := <-errCh:
+			return fmt.Errorf("replay: frame %d of %s at index %d: %s",
+				n, prefix.Dependency, frame.Index, err)
+		}
+	}
+	return nil
+}
+
+// replayPrefix returns the prefix which replicates the source of the frame,
+// which for merged prefixes is the first prefix of their group, or nil if no
+// prefix replicates it.
+func (r *Runner) replayPrefix(frame *recordFrame) *PrefixConfig {
+	for _, prefix := range r.activePrefixes() {
+		if config.StringVal(prefix.Source) != frame.Source ||
+			config.StringVal(prefix.Datacenter) != frame.Datacenter {
+			continue
+		}
+		if r.mergedInto(prefix) {
+			return r.mergeGroup(prefix)[0]
+		}
+		return prefix
+	}
+	return nil
+}
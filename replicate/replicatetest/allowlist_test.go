@@ -0,0 +1,104 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: MPL-2.0
+
+package replicatetest
+
+import (
+	"fmt"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/hashicorp/consul-replicate/replicate"
+)
+
+func TestAllowList_empty(t *testing.T) {
+	cases := []struct {
+		name    string
+		allowed []string
+		empty   bool
+		exp     map[string]string
+		err     bool
+	}{
+		{
+			"allowed",
+			[]string{"global/a"},
+			false,
+			map[string]string{"replica/a": "1"},
+			false,
+		},
+		{
+			// The folder was deleted, or the token cannot list it
+			"empty",
+			nil,
+			false,
+			map[string]string{"replica/a": "0", "replica/b": "0"},
+			true,
+		},
+		{
+			"allow_empty",
+			nil,
+			true,
+			map[string]string{},
+			false,
+		},
+	}
+
+	for i, tc := range cases {
+		t.Run(fmt.Sprintf("%d_%s", i, tc.name), func(t *testing.T) {
+			s := NewServer(t)
+			source := s.Consul.Datacenter("dc1")
+			destination := s.Consul.Datacenter(Datacenter)
+			source.Set("global/a", "1")
+			source.Set("global/b", "2")
+			destination.Set("replica/a", "0")
+			destination.Set("replica/b", "0")
+			for _, key := range tc.allowed {
+				destination.Set("security/allow/"+key, "")
+			}
+
+			r, err := replicate.NewRunner(replicate.Must(fmt.Sprintf(`
+				consul {
+					address = %q
+				}
+				destination_consul {
+					address = %q
+				}
+				destination {
+					datacenter = %q
+				}
+				allow_list {
+					path        = "security/allow"
+					allow_empty = %t
+				}
+				prefix {
+					source      = "global"
+					datacenter  = "dc1"
+					destination = "replica"
+				}
+			`, s.Address(), s.Address(), Datacenter, tc.empty)), true)
+			if err != nil {
+				t.Fatal(err)
+			}
+			t.Cleanup(r.Stop)
+			go r.Start()
+
+			select {
+			case err := <-r.ErrCh:
+				if !tc.err {
+					t.Fatal(err)
+				}
+			case <-r.DoneCh:
+				if tc.err {
+					t.Fatal("expected an error")
+				}
+			case <-time.After(5 * time.Second):
+				t.Fatal("timed out")
+			}
+
+			if act := destination.Values("replica/"); !reflect.DeepEqual(tc.exp, act) {
+				t.Errorf("\nexp: %#v\nact: %#v", tc.exp, act)
+			}
+		})
+	}
+}
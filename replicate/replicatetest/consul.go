@@ -0,0 +1,199 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: MPL-2.0
+
+package replicatetest
+
+import (
+	"sort"
+	"sync"
+
+	dep "github.com/hashicorp/consul-template/dependency"
+	"github.com/hashicorp/consul/api"
+)
+
+// Consul is an in-memory fake of the KV stores and catalogs of a federation of
+// Consul datacenters. It implements replicate.Source and
+// replicate.CatalogSource, so it can be the source of a runner.
+type Consul struct {
+	sync.Mutex
+
+	datacenters map[string]*KV
+
+	// services are the registered service instances, keyed by datacenter.
+	services map[string][]*api.ServiceEntry
+
+	// errs are the errors returned for reads of a datacenter, set with Fail.
+	errs map[string]error
+}
+
+// NewConsul creates a federation without any datacenters.
+func NewConsul() *Consul {
+	return &Consul{
+		datacenters: make(map[string]*KV),
+		services:    make(map[string][]*api.ServiceEntry),
+		errs:        make(map[string]error),
+	}
+}
+
+// Datacenter returns the KV store of the named datacenter, creating it if it
+// does not exist.
+func (c *Consul) Datacenter(name string) *KV {
+	c.Lock()
+	defer c.Unlock()
+
+	kv, ok := c.datacenters[name]
+	if !ok {
+		kv = NewKV()
+		c.datacenters[name] = kv
+	}
+	return kv
+}
+
+// Fail makes every read of the named datacenter return the given error, as if
+// it were unreachable, until it is called again with a nil error.
+func (c *Consul) Fail(name string, err error) {
+	c.Lock()
+	defer c.Unlock()
+
+	if err == nil {
+		delete(c.errs, name)
+		return
+	}
+	c.errs[name] = err
+}
+
+// List returns the pairs under the given path in the datacenter, and the
+// index of the datacenter.
+func (c *Consul) List(path, datacenter string) ([]*dep.KeyPair, uint64, error) {
+	kv, err := c.datacenter(datacenter)
+	if err != nil {
+		return nil, 0, err
+	}
+	pairs, index := kv.list(path)
+	return pairs, index, nil
+}
+
+// Keys returns the keys and folders directly under the given path in the
+// datacenter.
+func (c *Consul) Keys(path, datacenter string) ([]string, error) {
+	kv, err := c.datacenter(datacenter)
+	if err != nil {
+		return nil, err
+	}
+	return kv.folders(path), nil
+}
+
+// Datacenters returns the sorted names of the datacenters.
+func (c *Consul) Datacenters() ([]string, error) {
+	c.Lock()
+	defer c.Unlock()
+
+	var names []string
+	for name := range c.datacenters {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names, nil
+}
+
+// datacenter returns the KV store of an existing datacenter, or the error
+// Consul returns for an unknown one.
+func (c *Consul) datacenter(name string) (*KV, error) {
+	c.Lock()
+	defer c.Unlock()
+
+	if err := c.errs[name]; err != nil {
+		return nil, err
+	}
+	kv, ok := c.datacenters[name]
+	if !ok {
+		return nil, ResponseError(500, "No path to datacenter")
+	}
+	return kv, nil
+}
+
+// Register adds a service instance, with its node and checks, to the catalog
+// of the named datacenter, replacing any instance with the same node and ID.
+func (c *Consul) Register(datacenter string, entry *api.ServiceEntry) {
+	c.Deregister(datacenter, entry.Node.Node, entry.Service.ID)
+
+	c.Lock()
+	defer c.Unlock()
+	c.services[datacenter] = append(c.services[datacenter], entry)
+}
+
+// Deregister removes a service instance from the catalog of the named
+// datacenter.
+func (c *Consul) Deregister(datacenter, node, id string) {
+	c.Lock()
+	defer c.Unlock()
+
+	entries := c.services[datacenter][:0]
+	for _, entry := range c.services[datacenter] {
+		if entry.Node.Node != node || entry.Service.ID != id {
+			entries = append(entries, entry)
+		}
+	}
+	c.services[datacenter] = entries
+}
+
+// Services returns the sorted names of the services registered in the
+// datacenter.
+func (c *Consul) Services(datacenter string) ([]string, error) {
+	if _, err := c.datacenter(datacenter); err != nil {
+		return nil, err
+	}
+
+	c.Lock()
+	defer c.Unlock()
+
+	seen := make(map[string]struct{})
+	var names []string
+	for _, entry := range c.services[datacenter] {
+		if _, ok := seen[entry.Service.Service]; !ok {
+			seen[entry.Service.Service] = struct{}{}
+			names = append(names, entry.Service.Service)
+		}
+	}
+	sort.Strings(names)
+	return names, nil
+}
+
+// ServiceInstances returns the registered instances of the service in the
+// datacenter.
+func (c *Consul) ServiceInstances(service, datacenter string) ([]*api.ServiceEntry, error) {
+	if _, err := c.datacenter(datacenter); err != nil {
+		return nil, err
+	}
+
+	c.Lock()
+	defer c.Unlock()
+
+	var entries []*api.ServiceEntry
+	for _, entry := range c.services[datacenter] {
+		if entry.Service.Service == service {
+			entries = append(entries, entry)
+		}
+	}
+	return entries, nil
+}
+
+// Nodes returns the nodes of the registered instances in the datacenter.
+func (c *Consul) Nodes(datacenter string) ([]*api.Node, error) {
+	if _, err := c.datacenter(datacenter); err != nil {
+		return nil, err
+	}
+
+	c.Lock()
+	defer c.Unlock()
+
+	seen := make(map[string]struct{})
+	var nodes []*api.Node
+	for _, entry := range c.services[datacenter] {
+		if _, ok := seen[entry.Node.Node]; !ok {
+			seen[entry.Node.Node] = struct{}{}
+			nodes = append(nodes, entry.Node)
+		}
+	}
+	return nodes, nil
+}
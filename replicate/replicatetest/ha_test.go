@@ -0,0 +1,235 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: MPL-2.0
+
+package replicatetest
+
+import (
+	"fmt"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/hashicorp/consul-replicate/replicate"
+	"github.com/hashicorp/consul/api"
+)
+
+// lockKey is the key of the HA lock of the runners of haConfig.
+const lockKey = "replicate/leader"
+
+// haConfig returns the configuration of a runner which replicates global@dc1
+// through the server, with leader election.
+func haConfig(s *Server) *replicate.Config {
+	return replicate.TestConfig(replicate.Must(fmt.Sprintf(`
+		consul {
+			address = %q
+		}
+		destination_consul {
+			address = %q
+		}
+		destination {
+			datacenter = %q
+		}
+		wait {
+			min = "0s"
+			max = "0s"
+		}
+		ha {
+			enabled  = true
+			lock_key = %q
+		}
+		prefix {
+			source      = "global"
+			datacenter  = "dc1"
+			destination = "replica"
+		}
+	`, s.Address(), s.Address(), Datacenter, lockKey)))
+}
+
+// startSupervisor starts a supervisor which inherits the given state, if any.
+// It is stopped when the test finishes.
+func startSupervisor(t *testing.T, c *replicate.Config, state *replicate.HandoverState) *replicate.Supervisor {
+	t.Helper()
+
+	s := replicate.NewSupervisor(c, false)
+	if state != nil {
+		s.Inherit(state)
+	}
+	t.Cleanup(s.Stop)
+	go s.Start()
+	go func() {
+		for err := range s.ErrCh {
+			t.Errorf("supervisor: %s", err)
+		}
+	}()
+	return s
+}
+
+// startRunner starts a runner, and returns it with the function which stops
+// it. It is stopped when the test finishes, unless the test stopped it first.
+func startRunner(t *testing.T, c *replicate.Config) (*replicate.Runner, func()) {
+	t.Helper()
+
+	r, err := replicate.NewRunner(c, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var once sync.Once
+	stop := func() { once.Do(r.Stop) }
+	t.Cleanup(stop)
+	go r.Start()
+	go func() {
+		for err := range r.ErrCh {
+			t.Errorf("runner: %s", err)
+		}
+	}()
+	return r, stop
+}
+
+// eventually fails the test unless the condition holds within a few seconds.
+func eventually(t *testing.T, what string, cond func() bool) {
+	t.Helper()
+
+	deadline := time.Now().Add(5 * time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatalf("timed out waiting for %s", what)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+// replicated returns a condition which holds once the destination key has the
+// value.
+func replicated(kv *KV, key, value string) func() bool {
+	return func() bool {
+		v, ok := kv.Value(key)
+		return ok && v == value
+	}
+}
+
+func TestHA_handover(t *testing.T) {
+	s := NewServer(t)
+	source := s.Consul.Datacenter("dc1")
+	destination := s.Consul.Datacenter(Datacenter)
+	source.Set("global/a", "1")
+
+	old := startSupervisor(t, haConfig(s), nil)
+	eventually(t, "the old process to replicate", replicated(destination, "replica/a", "1"))
+	session := s.Holder(lockKey)
+	if session == "" {
+		t.Fatal("the old process does not hold the lock")
+	}
+
+	state := old.Handover()
+	if h := state.Groups[""]; h == nil || h.Session != session {
+		t.Fatalf("\nexp: %#v\nact: %#v", session, h)
+	}
+	if act := s.Holder(lockKey); act != session {
+		t.Fatalf("lock released by the handover\nexp: %#v\nact: %#v", session, act)
+	}
+
+	// The new process replicates with the session of the old one, so no
+	// other session is created and the lock never changes hands
+	startSupervisor(t, haConfig(s), state)
+	source.Set("global/b", "2")
+	eventually(t, "the new process to replicate", replicated(destination, "replica/b", "2"))
+
+	if act := s.Holder(lockKey); act != session {
+		t.Errorf("\nexp: %#v\nact: %#v", session, act)
+	}
+	if exp, act := []string{session}, s.Sessions(); !reflect.DeepEqual(exp, act) {
+		t.Errorf("\nexp: %#v\nact: %#v", exp, act)
+	}
+}
+
+func TestHA_handoverLockLost(t *testing.T) {
+	s := NewServer(t)
+	source := s.Consul.Datacenter("dc1")
+	destination := s.Consul.Datacenter(Datacenter)
+	source.Set("global/a", "1")
+
+	old := startSupervisor(t, haConfig(s), nil)
+	eventually(t, "the old process to replicate", replicated(destination, "replica/a", "1"))
+	state := old.Handover()
+	session := state.Groups[""].Session
+
+	startSupervisor(t, haConfig(s), state)
+	source.Set("global/b", "2")
+	eventually(t, "the new process to replicate", replicated(destination, "replica/b", "2"))
+
+	// Once the inherited session loses the lock, it is no longer renewed but
+	// destroyed, and the lock is acquired again with a session of its own
+	destination.SetPair(&api.KVPair{Key: lockKey, Flags: api.LockFlagValue})
+	eventually(t, "the inherited session to be destroyed", func() bool {
+		for _, id := range s.Sessions() {
+			if id == session {
+				return false
+			}
+		}
+		return true
+	})
+	eventually(t, "the lock to be acquired again", func() bool {
+		holder := s.Holder(lockKey)
+		return holder != "" && holder != session
+	})
+
+	source.Set("global/c", "3")
+	eventually(t, "the new process to replicate again", replicated(destination, "replica/c", "3"))
+}
+
+func TestHA_handoverReleased(t *testing.T) {
+	s := NewServer(t)
+	source := s.Consul.Datacenter("dc1")
+	destination := s.Consul.Datacenter(Datacenter)
+	source.Set("global/a", "1")
+
+	old := startSupervisor(t, haConfig(s), nil)
+	eventually(t, "the old process to replicate", replicated(destination, "replica/a", "1"))
+	state := old.Handover()
+
+	// A state which could not be handed over releases the lock, so the new
+	// process, starting fresh, acquires it without waiting for the session of
+	// the old one to expire
+	state.Release()
+	if act := s.Holder(lockKey); act != "" {
+		t.Fatalf("\nexp: %#v\nact: %#v", "", act)
+	}
+
+	startSupervisor(t, haConfig(s), nil)
+	source.Set("global/b", "2")
+	eventually(t, "the new process to replicate", replicated(destination, "replica/b", "2"))
+}
+
+func TestHA_failover(t *testing.T) {
+	s := NewServer(t)
+	source := s.Consul.Datacenter("dc1")
+	destination := s.Consul.Datacenter(Datacenter)
+	source.Set("global/a", "1")
+
+	first, stopFirst := startRunner(t, haConfig(s))
+	eventually(t, "the first runner to replicate", replicated(destination, "replica/a", "1"))
+	eventually(t, "the first runner to lead", func() bool { return first.State().Leader })
+	leader := s.Holder(lockKey)
+
+	// The second runner stands by while the first one holds the lock
+	second, _ := startRunner(t, haConfig(s))
+	eventually(t, "the second runner to campaign", func() bool { return len(s.Sessions()) == 2 })
+	source.Set("global/b", "2")
+	eventually(t, "the first runner to replicate again", replicated(destination, "replica/b", "2"))
+	if second.State().Leader {
+		t.Errorf("expected the second runner to stand by")
+	}
+	if act := s.Holder(lockKey); act != leader {
+		t.Errorf("\nexp: %#v\nact: %#v", leader, act)
+	}
+
+	// Once the first runner stops, the second one takes over
+	stopFirst()
+	eventually(t, "the second runner to lead", func() bool { return second.State().Leader })
+	if act := s.Holder(lockKey); act == "" || act == leader {
+		t.Errorf("expected the lock to change hands, got %q", act)
+	}
+	source.Set("global/c", "3")
+	eventually(t, "the second runner to replicate", replicated(destination, "replica/c", "3"))
+}
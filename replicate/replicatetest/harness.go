@@ -0,0 +1,92 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: MPL-2.0
+
+// Package replicatetest provides in-memory fakes of Consul and a harness to
+// test replication deterministically, without a live cluster:
+//
+//	h := replicatetest.New(t, replicate.Must(`prefix = "global@dc1"`))
+//	h.Consul.Datacenter("dc1").Set("global/a", "1")
+//
+//	if _, err := h.Sync(); err != nil {
+//		t.Fatal(err)
+//	}
+//	if v, _ := h.Destination.Value("global/a"); v != "1" {
+//		t.Errorf("expected global/a to be replicated")
+//	}
+//
+// The fakes read and write keys only, so features which need sessions, such
+// as ha and shard, are not supported.
+package replicatetest
+
+import (
+	"testing"
+
+	"github.com/hashicorp/consul-replicate/replicate"
+)
+
+// Datacenter is the name of the destination datacenter of a harness.
+const Datacenter = "dc0"
+
+// Harness replicates from the datacenters of a fake Consul into one of them.
+type Harness struct {
+	// Consul is the federation replicated from. Source keys are set in its
+	// datacenters.
+	Consul *Consul
+
+	// Destination is the KV store of the destination datacenter, which is
+	// also the datacenter of Consul named Datacenter.
+	Destination *KV
+
+	// Runner replicates from Consul into Destination.
+	Runner *replicate.Runner
+}
+
+// New creates a harness which replicates the prefixes of the configuration.
+// The runner is stopped when the test finishes.
+func New(t testing.TB, c *replicate.Config) *Harness {
+	t.Helper()
+
+	consul := NewConsul()
+	destination := consul.Datacenter(Datacenter)
+
+	r, err := replicate.NewRunnerWithInput(&replicate.NewRunnerInput{
+		Config:      c,
+		Once:        true,
+		Source:      consul,
+		Destination: destination,
+		Datacenter:  Datacenter,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(r.Stop)
+
+	return &Harness{
+		Consul:      consul,
+		Destination: destination,
+		Runner:      r,
+	}
+}
+
+// Sync discovers the prefixes and replicates them in a single pass. It returns
+// the events of the pass, one per replicated prefix, and the error which
+// stopped it, if any.
+func (h *Harness) Sync() ([]*replicate.Event, error) {
+	go h.Runner.Start()
+
+	var err error
+	select {
+	case <-h.Runner.DoneCh:
+	case err = <-h.Runner.ErrCh:
+	}
+
+	var events []*replicate.Event
+	for {
+		select {
+		case e := <-h.Runner.Events():
+			events = append(events, e)
+		default:
+			return events, err
+		}
+	}
+}
@@ -0,0 +1,1934 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: MPL-2.0
+
+package replicatetest
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"math/rand"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/hashicorp/consul-replicate/replicate"
+	"github.com/hashicorp/consul-template/config"
+	"github.com/hashicorp/consul/api"
+)
+
+func TestHarness_Sync(t *testing.T) {
+	cases := []struct {
+		name   string
+		config string
+		source map[string]map[string]string
+		exp    map[string]string
+	}{
+		{
+			"prefix",
+			`prefix = "global@dc1"`,
+			map[string]map[string]string{
+				"dc1": {"global/a": "1", "global/b/c": "2", "other/d": "3"},
+			},
+			map[string]string{"global/a": "1", "global/b/c": "2"},
+		},
+		{
+			"destination",
+			`prefix { source = "global" datacenter = "dc1" destination = "backup" }`,
+			map[string]map[string]string{
+				"dc1": {"global/a": "1"},
+			},
+			map[string]string{"backup/a": "1"},
+		},
+		{
+			"destination_root",
+			`destination_root = "mirror"
+			 prefix { source = "global" datacenter = "dc1" }
+			 prefix { source = "apps" datacenter = "dc1" destination = "backup/apps" }`,
+			map[string]map[string]string{
+				"dc1": {"global/a": "1", "apps/b": "2"},
+			},
+			map[string]string{"mirror/global/a": "1", "mirror/backup/apps/b": "2"},
+		},
+		{
+			"exclude",
+			`prefix = "global@dc1"
+			 exclude { source = "global/private" }`,
+			map[string]map[string]string{
+				"dc1": {"global/a": "1", "global/private/b": "2"},
+			},
+			map[string]string{"global/a": "1"},
+		},
+		{
+			"disabled",
+			`prefix { source = "global" datacenter = "dc1" }
+			 prefix { source = "other" datacenter = "dc1" enabled = false }`,
+			map[string]map[string]string{
+				"dc1": {"global/a": "1", "other/b": "2"},
+			},
+			map[string]string{"global/a": "1"},
+		},
+		{
+			"blackout",
+			`prefix { source = "global" datacenter = "dc1" }
+			 prefix {
+				source = "other"
+				datacenter = "dc1"
+				blackout { schedule = "* * * * *" duration = "1h" }
+			 }`,
+			map[string]map[string]string{
+				"dc1": {"global/a": "1", "other/b": "2"},
+			},
+			map[string]string{"global/a": "1"},
+		},
+		{
+			"wildcard",
+			`prefix { source = "apps/*/config" datacenter = "dc1" }`,
+			map[string]map[string]string{
+				"dc1": {"apps/a/config/x": "1", "apps/b/config/y": "2", "apps/b/secret": "3"},
+			},
+			map[string]string{"apps/a/config/x": "1", "apps/b/config/y": "2"},
+		},
+		{
+			"datacenter_wildcard",
+			`prefix { source = "global" datacenter = "*" destination = "{{ source_dc }}/global" }`,
+			map[string]map[string]string{
+				"dc1": {"global/a": "1"},
+				"dc2": {"global/a": "2"},
+			},
+			map[string]string{"dc1/global/a": "1", "dc2/global/a": "2"},
+		},
+		{
+			"source_dc_wildcard",
+			`prefix { source = "global" source_dc = "*" }`,
+			map[string]map[string]string{
+				"dc1": {"global/a": "1"},
+				"dc2": {"global/a": "2"},
+			},
+			map[string]string{"dc1/global/a": "1", "dc2/global/a": "2"},
+		},
+	}
+
+	for i, tc := range cases {
+		t.Run(fmt.Sprintf("%d_%s", i, tc.name), func(t *testing.T) {
+			h := New(t, replicate.Must(tc.config))
+			for dc, keys := range tc.source {
+				for key, value := range keys {
+					h.Consul.Datacenter(dc).Set(key, value)
+				}
+			}
+
+			if _, err := h.Sync(); err != nil {
+				t.Fatal(err)
+			}
+
+			// Status and manifest keys are not compared
+			act := h.Destination.Values("")
+			for key := range act {
+				if strings.HasPrefix(key, "service/") {
+					delete(act, key)
+				}
+			}
+			if !reflect.DeepEqual(tc.exp, act) {
+				t.Errorf("\nexp: %#v\nact: %#v", tc.exp, act)
+			}
+		})
+	}
+}
+
+func TestHarness_passes(t *testing.T) {
+	h := New(t, replicate.Must(`prefix = "global@dc1"`))
+	source := h.Consul.Datacenter("dc1")
+	source.Set("global/a", "1")
+	source.Set("global/b", "2")
+
+	events, err := h.Sync()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(events) != 1 || events[0].Updates != 2 {
+		t.Fatalf("expected 2 updates, got %#v", events)
+	}
+
+	// Only changes are replicated in the next pass
+	source.Set("global/a", "3")
+	source.Remove("global/b")
+	if events, err = h.Sync(); err != nil {
+		t.Fatal(err)
+	}
+	if len(events) != 1 || events[0].Updates != 1 || events[0].Deletes != 1 {
+		t.Fatalf("expected 1 update and 1 delete, got %#v", events)
+	}
+
+	exp := map[string]string{"global/a": "3"}
+	if act := h.Destination.Values("global/"); !reflect.DeepEqual(exp, act) {
+		t.Errorf("\nexp: %#v\nact: %#v", exp, act)
+	}
+}
+
+func TestHarness_failures(t *testing.T) {
+	h := New(t, replicate.Must(`prefix = "global@dc1"`))
+	source := h.Consul.Datacenter("dc1")
+	source.Set("global/a", "1")
+	source.Set("global/b", "2")
+
+	// A key which cannot be written does not stop the others
+	h.Destination.Fail("global/b", ResponseError(413, "Value exceeds 524288 byte limit"))
+	events, err := h.Sync()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := events[0].Failures["global/b"]; !ok {
+		t.Errorf("expected global/b to fail, got %#v", events[0].Failures)
+	}
+	if _, ok := h.Destination.Value("global/a"); !ok {
+		t.Errorf("expected global/a to be replicated")
+	}
+
+	// The failed key is retried in the next pass
+	h.Destination.Fail("global/b", nil)
+	if _, err := h.Sync(); err != nil {
+		t.Fatal(err)
+	}
+	if v, _ := h.Destination.Value("global/b"); v != "2" {
+		t.Errorf("expected global/b to be retried, got %q", v)
+	}
+
+	// An unreachable source fails the pass
+	h.Consul.Fail("dc1", ResponseError(500, "rpc error"))
+	if _, err := h.Sync(); err == nil {
+		t.Errorf("expected an error")
+	}
+}
+
+func TestHarness_onSourceEmpty(t *testing.T) {
+	cases := []struct {
+		name   string
+		action string
+		exp    bool
+		err    bool
+	}{
+		{
+			"delete",
+			replicate.OnSourceEmptyDelete,
+			false,
+			false,
+		},
+		{
+			"keep",
+			replicate.OnSourceEmptyKeep,
+			true,
+			false,
+		},
+		{
+			"fail",
+			replicate.OnSourceEmptyFail,
+			true,
+			true,
+		},
+	}
+
+	for i, tc := range cases {
+		t.Run(fmt.Sprintf("%d_%s", i, tc.name), func(t *testing.T) {
+			h := New(t, replicate.Must(fmt.Sprintf(`
+				prefix {
+					source          = "global"
+					datacenter      = "dc1"
+					on_source_empty = %q
+				}
+			`, tc.action)))
+			source := h.Consul.Datacenter("dc1")
+			source.Set("global/a", "1")
+			if _, err := h.Sync(); err != nil {
+				t.Fatal(err)
+			}
+
+			// The whole source prefix disappears
+			source.Remove("global/a")
+			_, err := h.Sync()
+			if (err != nil) != tc.err {
+				t.Fatal(err)
+			}
+			if _, act := h.Destination.Value("global/a"); act != tc.exp {
+				t.Errorf("\nexp: %#v\nact: %#v", tc.exp, act)
+			}
+		})
+	}
+}
+
+func TestHarness_maxTreeBytes(t *testing.T) {
+	h := New(t, replicate.Must(`
+		prefix {
+			source         = "large"
+			datacenter     = "dc1"
+			max_tree_bytes = 16
+		}
+		prefix {
+			source     = "small"
+			datacenter = "dc1"
+		}
+	`))
+	source := h.Consul.Datacenter("dc1")
+	source.Set("large/a", "0123456789")
+	source.Set("small/a", "1")
+
+	// The prefix over its budget fails, while the other one is replicated
+	_, err := h.Sync()
+	if err == nil || !strings.Contains(err.Error(), "max_tree_bytes") {
+		t.Errorf("expected the budget to be exceeded, got %v", err)
+	}
+	if _, ok := h.Destination.Value("large/a"); ok {
+		t.Errorf("expected large/a not to be replicated")
+	}
+	if v, _ := h.Destination.Value("small/a"); v != "1" {
+		t.Errorf("expected small/a to be replicated, got %q", v)
+	}
+}
+
+func TestHarness_limits(t *testing.T) {
+	h := New(t, replicate.Must(`
+		prefix {
+			source     = "wide"
+			datacenter = "dc1"
+			max_keys   = 2
+		}
+		prefix {
+			source     = "deep"
+			datacenter = "dc1"
+			max_depth  = 2
+		}
+		prefix {
+			source     = "small"
+			datacenter = "dc1"
+			max_keys   = 2
+			max_depth  = 2
+		}
+	`))
+	source := h.Consul.Datacenter("dc1")
+	source.Set("wide/a", "1")
+	source.Set("wide/b", "2")
+	source.Set("wide/c", "3")
+	source.Set("deep/a/b/c", "1")
+	source.Set("small/a/b", "1")
+
+	// The prefixes over their limits fail, while the other one is replicated
+	_, err := h.Sync()
+	if err == nil || !strings.Contains(err.Error(), "max_keys") ||
+		!strings.Contains(err.Error(), "max_depth") {
+		t.Errorf("expected the limits to be exceeded, got %v", err)
+	}
+	if act := h.Destination.Values("wide/"); len(act) != 0 {
+		t.Errorf("expected wide not to be replicated, got %#v", act)
+	}
+	if act := h.Destination.Values("deep/"); len(act) != 0 {
+		t.Errorf("expected deep not to be replicated, got %#v", act)
+	}
+	if v, _ := h.Destination.Value("small/a/b"); v != "1" {
+		t.Errorf("expected small/a/b to be replicated, got %q", v)
+	}
+}
+
+func TestHarness_indexReset(t *testing.T) {
+	h := New(t, replicate.Must(`prefix = "global@dc1"`))
+	source := h.Consul.Datacenter("dc1")
+	source.Set("global/a", "1")
+	source.Set("global/b", "2")
+	source.Set("global/c", "3")
+	source.Set("global/d", "4")
+
+	if _, err := h.Sync(); err != nil {
+		t.Fatal(err)
+	}
+
+	// Restore a snapshot taken before c and d were written, then write a key
+	// at an index which was already replicated
+	source.Restore(2)
+	source.Set("global/a", "5")
+
+	events, err := h.Sync()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(events) != 1 || events[0].Updates != 2 || events[0].Deletes != 2 {
+		t.Fatalf("expected 2 updates and 2 deletes, got %#v", events)
+	}
+
+	exp := map[string]string{"global/a": "5", "global/b": "2"}
+	if act := h.Destination.Values("global/"); !reflect.DeepEqual(exp, act) {
+		t.Errorf("\nexp: %#v\nact: %#v", exp, act)
+	}
+
+	// Later passes only replicate changes again
+	source.Set("global/b", "6")
+	if events, err = h.Sync(); err != nil {
+		t.Fatal(err)
+	}
+	if len(events) != 1 || events[0].Updates != 1 {
+		t.Fatalf("expected 1 update, got %#v", events)
+	}
+}
+
+func TestHarness_catalog(t *testing.T) {
+	h := New(t, replicate.Must(`prefix = "global@dc1"
+		catalog {
+			datacenter  = "dc1"
+			destination = "catalog/dc1"
+			nodes       = true
+		}`))
+	h.Consul.Datacenter("dc1").Set("global/a", "1")
+
+	node1 := &api.Node{Node: "node1", Address: "10.0.0.1", Meta: map[string]string{"rack": "r1"}}
+	node2 := &api.Node{Node: "node2", Address: "10.0.0.2"}
+	h.Consul.Register("dc1", &api.ServiceEntry{
+		Node:    node2,
+		Service: &api.AgentService{ID: "web", Service: "web", Port: 80},
+		Checks:  api.HealthChecks{{Status: api.HealthCritical}},
+	})
+	h.Consul.Register("dc1", &api.ServiceEntry{
+		Node:    node1,
+		Service: &api.AgentService{ID: "web", Service: "web", Port: 80, Tags: []string{"v1"}},
+		Checks:  api.HealthChecks{{Status: api.HealthPassing}},
+	})
+	if _, err := h.Sync(); err != nil {
+		t.Fatal(err)
+	}
+
+	var instances []*replicate.CatalogInstance
+	value, _ := h.Destination.Value("catalog/dc1/services/web")
+	if err := json.Unmarshal([]byte(value), &instances); err != nil {
+		t.Fatal(err)
+	}
+	exp := []*replicate.CatalogInstance{
+		{ID: "web", Node: "node1", Address: "10.0.0.1", Port: 80, Tags: []string{"v1"}, Health: api.HealthPassing},
+		{ID: "web", Node: "node2", Address: "10.0.0.2", Port: 80, Health: api.HealthCritical},
+	}
+	if !reflect.DeepEqual(exp, instances) {
+		t.Errorf("\nexp: %#v\nact: %#v", exp, instances)
+	}
+
+	var node replicate.CatalogNode
+	value, _ = h.Destination.Value("catalog/dc1/nodes/node1")
+	if err := json.Unmarshal([]byte(value), &node); err != nil {
+		t.Fatal(err)
+	}
+	if exp := (replicate.CatalogNode{Node: "node1", Address: "10.0.0.1", Meta: node1.Meta}); !reflect.DeepEqual(exp, node) {
+		t.Errorf("\nexp: %#v\nact: %#v", exp, node)
+	}
+
+	// Instances which are gone are removed
+	h.Consul.Deregister("dc1", "node1", "web")
+	h.Consul.Deregister("dc1", "node2", "web")
+	if _, err := h.Sync(); err != nil {
+		t.Fatal(err)
+	}
+	if keys := h.Destination.Values("catalog/"); len(keys) > 0 {
+		t.Errorf("expected the catalog to be empty, got %#v", keys)
+	}
+}
+
+func TestHarness_waitForClusters(t *testing.T) {
+	cases := []struct {
+		name    string
+		timeout string
+		err     bool
+	}{
+		{
+			"ready",
+			"5s",
+			false,
+		},
+		{
+			"timeout",
+			"50ms",
+			true,
+		},
+	}
+
+	for i, tc := range cases {
+		t.Run(fmt.Sprintf("%d_%s", i, tc.name), func(t *testing.T) {
+			h := New(t, replicate.Must(fmt.Sprintf(`prefix = "global@dc1"
+				wait_for_clusters {
+					interval = "10ms"
+					timeout  = %q
+				}`, tc.timeout)))
+			h.Consul.Datacenter("dc1").Set("global/a", "1")
+
+			// The source datacenter is unreachable while it boots
+			h.Consul.Fail("dc1", ResponseError(500, "No cluster leader"))
+			timer := time.AfterFunc(200*time.Millisecond, func() { h.Consul.Fail("dc1", nil) })
+			defer timer.Stop()
+
+			_, err := h.Sync()
+			if (err != nil) != tc.err {
+				t.Fatal(err)
+			}
+			if _, ok := h.Destination.Value("global/a"); ok == tc.err {
+				t.Errorf("expected global/a to be replicated: %t", !tc.err)
+			}
+		})
+	}
+}
+
+func TestHarness_merge(t *testing.T) {
+	h := New(t, replicate.Must(`
+		prefix { source = "defaults/" datacenter = "dc1" destination = "effective/" merge = true }
+		prefix { source = "overrides/" datacenter = "dc1" destination = "effective/" merge = true }
+	`))
+	source := h.Consul.Datacenter("dc1")
+	source.Set("defaults/a", "1")
+	source.Set("defaults/b", "2")
+	source.Set("overrides/b", "3")
+	source.Set("overrides/c", "4")
+
+	events, err := h.Sync()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(events) != 1 || events[0].Updates != 3 {
+		t.Fatalf("expected a single pass with 3 updates, got %#v", events)
+	}
+
+	exp := map[string]string{"effective/a": "1", "effective/b": "3", "effective/c": "4"}
+	if act := h.Destination.Values("effective/"); !reflect.DeepEqual(exp, act) {
+		t.Errorf("\nexp: %#v\nact: %#v", exp, act)
+	}
+
+	// Removing an override reveals the default again, and keys only written
+	// by an override are deleted
+	source.Remove("overrides/b")
+	source.Remove("overrides/c")
+	source.Set("defaults/a", "5")
+	if events, err = h.Sync(); err != nil {
+		t.Fatal(err)
+	}
+	if len(events) != 1 || events[0].Updates != 2 || events[0].Deletes != 1 {
+		t.Fatalf("expected 2 updates and 1 delete, got %#v", events)
+	}
+
+	exp = map[string]string{"effective/a": "5", "effective/b": "2"}
+	if act := h.Destination.Values("effective/"); !reflect.DeepEqual(exp, act) {
+		t.Errorf("\nexp: %#v\nact: %#v", exp, act)
+	}
+}
+
+func TestHarness_routes(t *testing.T) {
+	h := New(t, replicate.Must(`
+		prefix {
+			source = "config/"
+			datacenter = "dc1"
+			route { pattern = "(^|/)secret" destination = "config-secret/" }
+			route { pattern = "^public/" destination = "config-public/" }
+		}
+	`))
+	source := h.Consul.Datacenter("dc1")
+	source.Set("config/public/a", "1")
+	source.Set("config/public/secret", "2")
+	source.Set("config/db/secret", "3")
+	source.Set("config/other", "4")
+
+	if _, err := h.Sync(); err != nil {
+		t.Fatal(err)
+	}
+
+	exp := map[string]string{
+		"config-public/public/a":      "1",
+		"config-secret/public/secret": "2",
+		"config-secret/db/secret":     "3",
+		"config/other":                "4",
+	}
+	act := h.Destination.Values("config")
+	if !reflect.DeepEqual(exp, act) {
+		t.Errorf("\nexp: %#v\nact: %#v", exp, act)
+	}
+
+	// Keys removed from the source are deleted from their route
+	source.Remove("config/db/secret")
+	source.Remove("config/public/a")
+	if _, err := h.Sync(); err != nil {
+		t.Fatal(err)
+	}
+
+	exp = map[string]string{
+		"config-secret/public/secret": "2",
+		"config/other":                "4",
+	}
+	if act := h.Destination.Values("config"); !reflect.DeepEqual(exp, act) {
+		t.Errorf("\nexp: %#v\nact: %#v", exp, act)
+	}
+}
+
+func TestHarness_pruneStatus(t *testing.T) {
+	h := New(t, replicate.Must(`prefix = "global@dc1"`))
+	h.Consul.Datacenter("dc1").Set("global/a", "1")
+	if _, err := h.Sync(); err != nil {
+		t.Fatal(err)
+	}
+
+	old := time.Now().Add(-48 * time.Hour).UTC().Format(time.RFC3339)
+	recent := time.Now().UTC().Format(time.RFC3339)
+	dir := replicate.DefaultStatusDir + "/"
+	h.Destination.Set(dir+"old", `{"LastUpdated": "`+old+`"}`)
+	h.Destination.Set(dir+"recent", `{"LastUpdated": "`+recent+`"}`)
+	h.Destination.Set(dir+"legacy", `{"LastReplicated": 1}`)
+	h.Destination.Set(dir+"manifests/legacy", `{"Timestamp": "`+old+`"}`)
+	h.Destination.Set(dir+"manifests/old", `{"Timestamp": "`+old+`"}`)
+	h.Destination.Set(dir+"members/gone", "")
+	h.Destination.Set(dir+"team-a/old", `{"LastUpdated": "`+old+`"}`)
+
+	exp := []string{
+		dir + "legacy",
+		dir + "manifests/legacy",
+		dir + "manifests/old",
+		dir + "members/gone",
+		dir + "old",
+	}
+	before := h.Destination.Values(dir)
+
+	// A dry run removes nothing
+	act, err := h.Runner.PruneStatus(24*time.Hour, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sort.Strings(act)
+	if !reflect.DeepEqual(exp, act) {
+		t.Errorf("\nexp: %#v\nact: %#v", exp, act)
+	}
+	if after := h.Destination.Values(dir); !reflect.DeepEqual(before, after) {
+		t.Errorf("expected a dry run to remove nothing, got %#v", after)
+	}
+
+	if act, err = h.Runner.PruneStatus(24*time.Hour, false); err != nil {
+		t.Fatal(err)
+	}
+	sort.Strings(act)
+	if !reflect.DeepEqual(exp, act) {
+		t.Errorf("\nexp: %#v\nact: %#v", exp, act)
+	}
+	for _, key := range exp {
+		if _, ok := h.Destination.Value(key); ok {
+			t.Errorf("expected %q to be removed", key)
+		}
+	}
+
+	// The status of the configured prefix and fresh entries are kept
+	if n := len(h.Destination.Values(dir)); n != len(before)-len(exp) {
+		t.Errorf("expected %d keys to be kept, got %d", len(before)-len(exp), n)
+	}
+	if _, ok := h.Destination.Value(dir + "recent"); !ok {
+		t.Errorf("expected the recent status to be kept")
+	}
+}
+
+func TestHarness_statusBackend(t *testing.T) {
+	cases := []struct {
+		name    string
+		backend string
+		files   bool
+	}{
+		{
+			"file",
+			replicate.StatusBackendFile,
+			true,
+		},
+		{
+			"none",
+			replicate.StatusBackendNone,
+			false,
+		},
+	}
+
+	for i, tc := range cases {
+		t.Run(fmt.Sprintf("%d_%s", i, tc.name), func(t *testing.T) {
+			dir := t.TempDir()
+			h := New(t, replicate.Must(fmt.Sprintf(`prefix = "global@dc1"
+				status_backend = %q
+				status_path = %q`, tc.backend, dir)))
+			h.Consul.Datacenter("dc1").Set("global/a", "1")
+			if _, err := h.Sync(); err != nil {
+				t.Fatal(err)
+			}
+
+			if _, ok := h.Destination.Value("global/a"); !ok {
+				t.Errorf("expected global/a to be replicated")
+			}
+			if keys := h.Destination.Values(replicate.DefaultStatusDir); len(keys) > 0 {
+				t.Errorf("expected no status in the destination, got %#v", keys)
+			}
+
+			statuses, err := h.Runner.Status()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(statuses) != 1 || statuses[0].LastReplicated == 0 {
+				t.Errorf("expected the status to be recorded, got %#v", statuses)
+			}
+
+			files, err := filepath.Glob(filepath.Join(dir, replicate.DefaultStatusDir, "*"))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if act := len(files) > 0; act != tc.files {
+				t.Errorf("\nexp: %#v\nact: %#v", tc.files, act)
+			}
+		})
+	}
+}
+
+func TestHarness_verifyBeforeWrite(t *testing.T) {
+	cases := []struct {
+		name   string
+		verify bool
+		exp    int
+	}{
+		{
+			"write_through",
+			false,
+			2,
+		},
+		{
+			"verify",
+			true,
+			1,
+		},
+	}
+
+	for i, tc := range cases {
+		t.Run(fmt.Sprintf("%d_%s", i, tc.name), func(t *testing.T) {
+			c := replicate.Must(`prefix = "global@dc1"`)
+			c.VerifyBeforeWrite = config.Bool(tc.verify)
+
+			h := New(t, c)
+			source := h.Consul.Datacenter("dc1")
+			source.Set("global/a", "1")
+			source.Set("global/b", "2")
+
+			// The destination already has one of the keys
+			h.Destination.Set("global/a", "1")
+
+			events, err := h.Sync()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if act := events[0].Updates; act != tc.exp {
+				t.Errorf("\nexp: %#v\nact: %#v", tc.exp, act)
+			}
+		})
+	}
+}
+
+func TestHarness_pipeline(t *testing.T) {
+	h := New(t, replicate.Must(`
+		prefix = "global@dc1"
+		pipeline {
+			window = 4
+		}
+	`))
+	source := h.Consul.Datacenter("dc1")
+	exp := make(map[string]string)
+	for i := 0; i < 20; i++ {
+		key := fmt.Sprintf("global/%02d", i)
+		source.Set(key, fmt.Sprint(i))
+		exp[key] = fmt.Sprint(i)
+	}
+
+	// A key which cannot be written does not stop the writes in flight
+	h.Destination.Fail("global/07", ResponseError(413, "Value exceeds 524288 byte limit"))
+	delete(exp, "global/07")
+	events, err := h.Sync()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if act := events[0].Updates; act != 19 {
+		t.Errorf("\nexp: %#v\nact: %#v", 19, act)
+	}
+	if _, ok := events[0].Failures["global/07"]; !ok {
+		t.Errorf("expected global/07 to fail, got %#v", events[0].Failures)
+	}
+	if act := h.Destination.Values("global/"); !reflect.DeepEqual(exp, act) {
+		t.Errorf("\nexp: %#v\nact: %#v", exp, act)
+	}
+
+	// Any other error fails the pass once the write is acknowledged
+	source.Set("global/03", "changed")
+	h.Destination.Fail("global/03", ResponseError(500, "rpc error"))
+	if _, err := h.Sync(); err == nil {
+		t.Errorf("expected an error")
+	}
+}
+
+func TestHarness_root(t *testing.T) {
+	h := New(t, replicate.Must(`prefix { source = "" datacenter = "dc1" }`))
+	source := h.Consul.Datacenter("dc1")
+	source.Set("a", "1")
+	source.Set("b/c", "2")
+
+	// The status directory of the source is not replicated, and keys only in
+	// the destination are deleted, except for its own status directory
+	source.Set("service/consul-replicate/statuses/remote", "1")
+	h.Destination.Set("service/consul-replicate/statuses/local", "2")
+	h.Destination.Set("d", "3")
+
+	for i := 0; i < 2; i++ {
+		if _, err := h.Sync(); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	act := h.Destination.Values("")
+	for key := range act {
+		if strings.HasPrefix(key, "service/consul-replicate/statuses/") &&
+			!strings.HasSuffix(key, "/local") && !strings.HasSuffix(key, "/remote") {
+			delete(act, key)
+		}
+	}
+	exp := map[string]string{
+		"a":   "1",
+		"b/c": "2",
+		"service/consul-replicate/statuses/local": "2",
+	}
+	if !reflect.DeepEqual(exp, act) {
+		t.Errorf("\nexp: %#v\nact: %#v", exp, act)
+	}
+}
+
+func TestHarness_tombstone(t *testing.T) {
+	h := New(t, replicate.Must(`
+		prefix    = "global@dc1"
+		tombstone { retention = "1h" }
+	`))
+	source := h.Consul.Datacenter("dc1")
+	source.Set("global/a", "1")
+	source.Set("global/b", "2")
+	h.Destination.Set("global/c.deleted", "2000-01-01T00:00:00Z")
+	if _, err := h.Sync(); err != nil {
+		t.Fatal(err)
+	}
+
+	// The expired tombstone is removed, and a deleted key leaves one
+	source.Remove("global/b")
+	if _, err := h.Sync(); err != nil {
+		t.Fatal(err)
+	}
+	act := h.Destination.Values("global/")
+	if _, ok := act["global/b.deleted"]; !ok {
+		t.Fatalf("expected a tombstone of global/b: %#v", act)
+	}
+	if _, err := time.Parse(time.RFC3339, act["global/b.deleted"]); err != nil {
+		t.Error(err)
+	}
+	delete(act, "global/b.deleted")
+	exp := map[string]string{"global/a": "1"}
+	if !reflect.DeepEqual(exp, act) {
+		t.Errorf("\nexp: %#v\nact: %#v", exp, act)
+	}
+
+	// The tombstone is removed once the key is written again
+	source.Set("global/b", "3")
+	if _, err := h.Sync(); err != nil {
+		t.Fatal(err)
+	}
+	act = h.Destination.Values("global/")
+	exp = map[string]string{"global/a": "1", "global/b": "3"}
+	if !reflect.DeepEqual(exp, act) {
+		t.Errorf("\nexp: %#v\nact: %#v", exp, act)
+	}
+}
+
+func TestHarness_metadata(t *testing.T) {
+	h := New(t, replicate.Must(`
+		prefix   = "global@dc1"
+		metadata { enabled = true }
+	`))
+	source := h.Consul.Datacenter("dc1")
+	source.Set("global/a", "1")
+	source.Set("global/b", "2")
+	if _, err := h.Sync(); err != nil {
+		t.Fatal(err)
+	}
+
+	act := h.Destination.Values("_meta/")
+	if len(act) != 2 {
+		t.Fatalf("expected the metadata of 2 keys, got %#v", act)
+	}
+	var meta replicate.KeyMetadata
+	if err := json.Unmarshal([]byte(act["_meta/global/a"]), &meta); err != nil {
+		t.Fatal(err)
+	}
+	if meta.SourceDatacenter != "dc1" || meta.SourceKey != "global/a" ||
+		meta.SourceModifyIndex == 0 || meta.ValueHash == "" || meta.ReplicatedAt.IsZero() {
+		t.Errorf("unexpected metadata: %#v", meta)
+	}
+
+	// The metadata is removed along with the key
+	source.Remove("global/b")
+	if _, err := h.Sync(); err != nil {
+		t.Fatal(err)
+	}
+	act = h.Destination.Values("_meta/")
+	if _, ok := act["_meta/global/b"]; ok || len(act) != 1 {
+		t.Errorf("expected only the metadata of global/a, got %#v", act)
+	}
+}
+
+func TestHarness_loopDetection(t *testing.T) {
+	h := New(t, replicate.Must(`
+		prefix         = "global@dc1"
+		loop_detection = true
+	`))
+	source := h.Consul.Datacenter("dc1")
+	setMeta := func(key, value, origin string) {
+		sum := sha256.Sum256([]byte(value))
+		b, err := json.Marshal(&replicate.KeyMetadata{
+			ValueHash: "sha256:" + hex.EncodeToString(sum[:]),
+			Origin:    origin,
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		source.SetPair(&api.KVPair{Key: "_meta/" + key, Value: b})
+	}
+
+	// A key which came from the destination is not replicated back, unless it
+	// was written again since
+	source.Set("global/back", "1")
+	setMeta("global/back", "1", "dc0")
+	source.Set("global/changed", "2")
+	setMeta("global/changed", "0", "dc0")
+	source.Set("global/other", "3")
+	setMeta("global/other", "3", "dc2")
+	source.Set("global/local", "4")
+	source.SetPair(&api.KVPair{Key: "global/lock", Value: []byte("5"), Flags: api.LockFlagValue})
+	if _, err := h.Sync(); err != nil {
+		t.Fatal(err)
+	}
+
+	exp := map[string]string{
+		"global/changed": "2",
+		"global/other":   "3",
+		"global/local":   "4",
+		"global/lock":    "5",
+	}
+	if act := h.Destination.Values("global/"); !reflect.DeepEqual(exp, act) {
+		t.Errorf("\nexp: %#v\nact: %#v", exp, act)
+	}
+
+	// The origin is recorded in the metadata of the replicated keys
+	origins := map[string]string{
+		"global/changed": "dc1",
+		"global/other":   "dc2",
+		"global/local":   "dc1",
+		"global/lock":    "dc1",
+	}
+	for key, origin := range origins {
+		value, _ := h.Destination.Value("_meta/" + key)
+		var meta replicate.KeyMetadata
+		if err := json.Unmarshal([]byte(value), &meta); err != nil {
+			t.Fatalf("%s: %s", key, err)
+		}
+		if meta.Origin != origin {
+			t.Errorf("%s: expected origin %q, got %q", key, origin, meta.Origin)
+		}
+	}
+
+	// The flags of a lock are replicated unchanged
+	if pair, _ := h.Destination.Get("global/lock"); pair == nil || pair.Flags != api.LockFlagValue {
+		t.Errorf("expected the flags of the lock to be kept, got %#v", pair)
+	}
+}
+
+func TestHarness_stampFlags(t *testing.T) {
+	h := New(t, replicate.Must(`
+		prefix = "global@dc1"
+		identity {
+			name        = "replicator-2"
+			stamp_flags = true
+		}
+	`))
+	source := h.Consul.Datacenter("dc1")
+	source.SetPair(&api.KVPair{Key: "global/a", Value: []byte("1"), Flags: 42})
+	source.SetPair(&api.KVPair{Key: "global/b", Value: []byte("2"), Flags: 1 << 40})
+
+	// The marker of a replicator recorded as the writer is replaced
+	sum := sha256.Sum256([]byte("3"))
+	b, err := json.Marshal(&replicate.KeyMetadata{
+		ValueHash: "sha256:" + hex.EncodeToString(sum[:]),
+		Writer:    "replicator-1",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	source.SetPair(&api.KVPair{Key: "_meta/global/c", Value: b})
+	source.SetPair(&api.KVPair{Key: "global/c", Value: []byte("3"), Flags: 1<<40 | 42})
+	if _, err := h.Sync(); err != nil {
+		t.Fatal(err)
+	}
+
+	// The key whose flags the marker would overwrite is skipped
+	exp := map[string]string{"global/a": "1", "global/c": "3"}
+	if act := h.Destination.Values("global/"); !reflect.DeepEqual(exp, act) {
+		t.Errorf("\nexp: %#v\nact: %#v", exp, act)
+	}
+
+	a, _ := h.Destination.Get("global/a")
+	c, _ := h.Destination.Get("global/c")
+	if a == nil || c == nil || a.Flags&^(0xffff<<32) != 42 || a.Flags == 42 || c.Flags != a.Flags {
+		t.Errorf("expected the marker of replicator-2 on both keys, got %#v and %#v", a, c)
+	}
+
+	value, _ := h.Destination.Value("_meta/global/a")
+	var meta replicate.KeyMetadata
+	if err := json.Unmarshal([]byte(value), &meta); err != nil {
+		t.Fatal(err)
+	}
+	if meta.Writer != "replicator-2" {
+		t.Errorf("expected writer replicator-2, got %q", meta.Writer)
+	}
+}
+
+func TestHarness_createFolders(t *testing.T) {
+	h := New(t, replicate.Must(`
+		prefix         = "global@dc1"
+		create_folders = true
+	`))
+	source := h.Consul.Datacenter("dc1")
+	source.Set("global/a", "1")
+	source.Set("global/app/b", "2")
+	source.Set("global/app/web/c", "3")
+	if _, err := h.Sync(); err != nil {
+		t.Fatal(err)
+	}
+
+	exp := map[string]string{
+		"global/":          "",
+		"global/a":         "1",
+		"global/app/":      "",
+		"global/app/b":     "2",
+		"global/app/web/":  "",
+		"global/app/web/c": "3",
+	}
+	if act := h.Destination.Values("global"); !reflect.DeepEqual(exp, act) {
+		t.Errorf("\nexp: %#v\nact: %#v", exp, act)
+	}
+
+	// Folders are removed once they are empty
+	source.Remove("global/app/web/c")
+	if _, err := h.Sync(); err != nil {
+		t.Fatal(err)
+	}
+	exp = map[string]string{
+		"global/":      "",
+		"global/a":     "1",
+		"global/app/":  "",
+		"global/app/b": "2",
+	}
+	if act := h.Destination.Values("global"); !reflect.DeepEqual(exp, act) {
+		t.Errorf("\nexp: %#v\nact: %#v", exp, act)
+	}
+}
+
+func TestHarness_flags(t *testing.T) {
+	h := New(t, replicate.Must(`
+		prefix {
+			source        = "global@dc1"
+			include_flags = 6
+			exclude_flags = 8
+		}
+	`))
+	source := h.Consul.Datacenter("dc1")
+	source.SetPair(&api.KVPair{Key: "global/a", Value: []byte("1"), Flags: 2})
+	source.SetPair(&api.KVPair{Key: "global/b", Value: []byte("2"), Flags: 4})
+	source.SetPair(&api.KVPair{Key: "global/c", Value: []byte("3"), Flags: 1})
+	source.SetPair(&api.KVPair{Key: "global/d", Value: []byte("4"), Flags: 2 | 8})
+	events, err := h.Sync()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(events) != 1 || events[0].Excluded != 2 {
+		t.Errorf("expected 2 excluded keys, got %#v", events)
+	}
+
+	exp := map[string]string{"global/a": "1", "global/b": "2"}
+	if act := h.Destination.Values("global/"); !reflect.DeepEqual(exp, act) {
+		t.Errorf("\nexp: %#v\nact: %#v", exp, act)
+	}
+
+	// A key marked local-only is deleted from the destination
+	source.SetPair(&api.KVPair{Key: "global/b", Value: []byte("2"), Flags: 4 | 8})
+	if _, err := h.Sync(); err != nil {
+		t.Fatal(err)
+	}
+	exp = map[string]string{"global/a": "1"}
+	if act := h.Destination.Values("global/"); !reflect.DeepEqual(exp, act) {
+		t.Errorf("\nexp: %#v\nact: %#v", exp, act)
+	}
+}
+
+func TestHarness_diff(t *testing.T) {
+	dir := t.TempDir()
+	h := New(t, replicate.Must(fmt.Sprintf(`
+		prefix = "global@dc1"
+		diff {
+			dir         = %q
+			consul_path = "diffs"
+		}
+	`, dir)))
+	source := h.Consul.Datacenter("dc1")
+	source.Set("global/a", "1")
+	source.Set("global/b", "2")
+	h.Destination.Set("global/c", "3")
+
+	// An expired diff is removed
+	h.Destination.Set("diffs/20000101T000000.000Z-old.json", "{}")
+	if err := os.WriteFile(filepath.Join(dir, "20000101T000000.000Z-old.json"), []byte("{}"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := h.Sync(); err != nil {
+		t.Fatal(err)
+	}
+
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 1 {
+		t.Fatalf("expected a single diff, got %d", len(entries))
+	}
+	b, err := os.ReadFile(filepath.Join(dir, entries[0].Name()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var d replicate.PassDiff
+	if err := json.Unmarshal(b, &d); err != nil {
+		t.Fatal(err)
+	}
+
+	var puts, deletes []string
+	for _, change := range d.Puts {
+		puts = append(puts, change.Key)
+	}
+	for _, change := range d.Deletes {
+		deletes = append(deletes, change.Key)
+		if change.OldHash == "" {
+			t.Errorf("expected the hash of the deleted value of %q", change.Key)
+		}
+	}
+	sort.Strings(puts)
+	if exp := []string{"global/a", "global/b"}; !reflect.DeepEqual(exp, puts) {
+		t.Errorf("\nexp: %#v\nact: %#v", exp, puts)
+	}
+	if exp := []string{"global/c"}; !reflect.DeepEqual(exp, deletes) {
+		t.Errorf("\nexp: %#v\nact: %#v", exp, deletes)
+	}
+
+	exp := map[string]string{"diffs/" + entries[0].Name(): string(b)}
+	if act := h.Destination.Values("diffs/"); !reflect.DeepEqual(exp, act) {
+		t.Errorf("\nexp: %#v\nact: %#v", exp, act)
+	}
+
+	// A pass which changes nothing has no diff
+	if _, err := h.Sync(); err != nil {
+		t.Fatal(err)
+	}
+	if act := h.Destination.Values("diffs/"); len(act) != 1 {
+		t.Errorf("expected a single diff, got %#v", act)
+	}
+}
+
+func TestHarness_backup(t *testing.T) {
+	dir := t.TempDir()
+	h := New(t, replicate.Must(fmt.Sprintf(`
+		prefix = "global@dc1"
+		backup {
+			dir         = %q
+			consul_path = "backups"
+		}
+	`, dir)))
+	source := h.Consul.Datacenter("dc1")
+	source.Set("global/a", "1")
+	h.Destination.SetPair(&api.KVPair{Key: "global/c", Value: []byte("3"), Flags: 42})
+
+	// A pass which deletes nothing is not backed up
+	source.Set("global/c", "3")
+	if _, err := h.Sync(); err != nil {
+		t.Fatal(err)
+	}
+	if entries, err := os.ReadDir(dir); err != nil || len(entries) != 0 {
+		t.Fatalf("expected no backup, got %v (%v)", entries, err)
+	}
+
+	source.Remove("global/c")
+	source.Set("global/b", "2")
+	if _, err := h.Sync(); err != nil {
+		t.Fatal(err)
+	}
+
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 1 {
+		t.Fatalf("expected a single backup, got %d", len(entries))
+	}
+	b, err := os.ReadFile(filepath.Join(dir, entries[0].Name()))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The backup is in the format of "consul kv export", and holds the keys
+	// deleted by the pass
+	var pairs []struct {
+		Key   string `json:"key"`
+		Flags uint64 `json:"flags"`
+		Value []byte `json:"value"`
+	}
+	if err := json.Unmarshal(b, &pairs); err != nil {
+		t.Fatal(err)
+	}
+	act := make(map[string]string, len(pairs))
+	for _, pair := range pairs {
+		act[pair.Key] = string(pair.Value)
+	}
+	exp := map[string]string{"global/c": "3"}
+	if !reflect.DeepEqual(exp, act) {
+		t.Errorf("\nexp: %#v\nact: %#v", exp, act)
+	}
+
+	exp = map[string]string{"backups/" + entries[0].Name(): string(b)}
+	if act := h.Destination.Values("backups/"); !reflect.DeepEqual(exp, act) {
+		t.Errorf("\nexp: %#v\nact: %#v", exp, act)
+	}
+	exp = map[string]string{"global/a": "1", "global/b": "2"}
+	if act := h.Destination.Values("global/"); !reflect.DeepEqual(exp, act) {
+		t.Errorf("\nexp: %#v\nact: %#v", exp, act)
+	}
+}
+
+func TestHarness_backupParts(t *testing.T) {
+	h := New(t, replicate.Must(`
+		prefix = "global@dc1"
+		backup {
+			consul_path = "backups"
+		}
+	`))
+
+	// The deleted keys are larger than a single Consul value
+	value := strings.Repeat("x", 100*1024)
+	for i := 0; i < 10; i++ {
+		h.Destination.Set(fmt.Sprintf("global/%d", i), value)
+	}
+	h.Consul.Datacenter("dc1").Set("global/a", "1")
+
+	if _, err := h.Sync(); err != nil {
+		t.Fatal(err)
+	}
+	exp := map[string]string{"global/a": "1"}
+	if act := h.Destination.Values("global/"); !reflect.DeepEqual(exp, act) {
+		t.Errorf("\nexp: %#v\nact: %#v", exp, act)
+	}
+
+	// Every part is importable on its own, and together they hold every key
+	parts := h.Destination.Values("backups/")
+	if len(parts) < 2 {
+		t.Fatalf("expected several parts, got %d", len(parts))
+	}
+	keys := make(map[string]struct{})
+	for name, part := range parts {
+		var pairs []struct {
+			Key string `json:"key"`
+		}
+		if err := json.Unmarshal([]byte(part), &pairs); err != nil {
+			t.Fatalf("%s: %s", name, err)
+		}
+		for _, pair := range pairs {
+			keys[pair.Key] = struct{}{}
+		}
+	}
+	if len(keys) != 10 {
+		t.Errorf("expected 10 keys to be backed up, got %d", len(keys))
+	}
+}
+
+func TestHarness_initialSync(t *testing.T) {
+	h := New(t, replicate.Must(`prefix = "global@dc1"`))
+	source := h.Consul.Datacenter("dc1")
+	source.Set("global/a", "1")
+	source.Set("global/b", "2")
+	barriers := replicate.DefaultStatusDir + "/initial-sync-complete/"
+
+	// The initial sync is not complete while a key fails
+	h.Destination.Fail("global/b", ResponseError(413, "Value exceeds 524288 byte limit"))
+	if _, err := h.Sync(); err != nil {
+		t.Fatal(err)
+	}
+	if act := h.Destination.Values(barriers); len(act) != 0 {
+		t.Fatalf("expected no barrier, got %#v", act)
+	}
+	statuses, err := h.Runner.Status()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if statuses[0].InitialSyncComplete {
+		t.Error("expected the initial sync not to be complete")
+	}
+
+	h.Destination.Fail("global/b", nil)
+	if _, err := h.Sync(); err != nil {
+		t.Fatal(err)
+	}
+	values := h.Destination.Values(barriers)
+	if len(values) != 1 {
+		t.Fatalf("expected a single barrier, got %#v", values)
+	}
+	for _, v := range values {
+		var s replicate.InitialSync
+		if err := json.Unmarshal([]byte(v), &s); err != nil {
+			t.Fatal(err)
+		}
+		if s.Source != "global" || s.KeyCount != 2 || s.Timestamp.IsZero() {
+			t.Errorf("bad barrier: %#v", s)
+		}
+	}
+	if statuses, err = h.Runner.Status(); err != nil {
+		t.Fatal(err)
+	}
+	if !statuses[0].InitialSyncComplete {
+		t.Error("expected the initial sync to be complete")
+	}
+
+	// The barrier is written once
+	source.Set("global/c", "3")
+	if _, err := h.Sync(); err != nil {
+		t.Fatal(err)
+	}
+	if act := h.Destination.Values(barriers); !reflect.DeepEqual(values, act) {
+		t.Errorf("\nexp: %#v\nact: %#v", values, act)
+	}
+}
+
+func TestHarness_migrate(t *testing.T) {
+	h := New(t, replicate.Must(`prefix = "global@dc1"`))
+	source := h.Consul.Datacenter("dc1")
+	source.Set("global/a", "1")
+	source.Set("global/b", "2")
+
+	// A key which keeps failing is never caught up, and nothing is cut over
+	h.Destination.Fail("global/b", ResponseError(413, "Value exceeds 524288 byte limit"))
+	if _, err := h.Runner.Migrate(0); err == nil {
+		t.Fatal("expected an error")
+	}
+	sentinels := replicate.DefaultStatusDir + "/migrated/"
+	if act := h.Destination.Values(sentinels); len(act) != 0 {
+		t.Fatalf("expected no sentinel, got %#v", act)
+	}
+
+	h.Destination.Fail("global/b", nil)
+	results, err := h.Runner.Migrate(time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(results) != 1 || results[0].Err != nil || results[0].KeyCount != 2 {
+		t.Fatalf("bad results: %#v", results)
+	}
+
+	exp := map[string]string{"global/a": "1", "global/b": "2"}
+	if act := h.Destination.Values("global/"); !reflect.DeepEqual(exp, act) {
+		t.Errorf("\nexp: %#v\nact: %#v", exp, act)
+	}
+
+	values := h.Destination.Values(sentinels)
+	if len(values) != 1 {
+		t.Fatalf("expected a single sentinel, got %#v", values)
+	}
+	for _, v := range values {
+		var s replicate.MigrateSentinel
+		if err := json.Unmarshal([]byte(v), &s); err != nil {
+			t.Fatal(err)
+		}
+		if s.Source != "global" || s.KeyCount != 2 || s.Timestamp.IsZero() {
+			t.Errorf("bad sentinel: %#v", s)
+		}
+	}
+
+	// A prefix which was cut over is never migrated again
+	if _, err := h.Runner.Migrate(time.Minute); err == nil ||
+		!strings.Contains(err.Error(), "already cut over") {
+		t.Errorf("expected the prefix to be cut over, got %v", err)
+	}
+}
+
+func TestHarness_mirror(t *testing.T) {
+	dir := t.TempDir()
+	conf := fmt.Sprintf(`
+		prefix = "global@dc1"
+		mirror {
+			dir = %q
+		}
+	`, dir)
+	h := New(t, replicate.Must(conf))
+	source := h.Consul.Datacenter("dc1")
+	source.Set("global/a", "1")
+	source.Set("global/b", "2")
+	h.Destination.Set("global/c", "3")
+	if _, err := h.Sync(); err != nil {
+		t.Fatal(err)
+	}
+	if entries, err := os.ReadDir(dir); err != nil || len(entries) != 1 {
+		t.Fatalf("expected a single journal, got %v (%v)", entries, err)
+	}
+
+	// A restarted replicator compares the source with its mirror, so the
+	// destination keys which did not change are never read again
+	r, err := replicate.NewRunnerWithInput(&replicate.NewRunnerInput{
+		Config:      replicate.Must(conf),
+		Once:        true,
+		Source:      h.Consul,
+		Destination: h.Destination,
+		Datacenter:  Datacenter,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(r.Stop)
+	h.Runner = r
+
+	h.Destination.Fail("global/a", ResponseError(500, "Internal Server Error"))
+	source.Set("global/a", "1")
+	source.Set("global/b", "4")
+	events, err := h.Sync()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(events) != 1 || events[0].Updates != 1 || events[0].Deletes != 0 {
+		t.Fatalf("expected a single update, got %#v", events)
+	}
+
+	exp := map[string]string{"global/a": "1", "global/b": "4"}
+	if act := h.Destination.Values("global/"); !reflect.DeepEqual(exp, act) {
+		t.Errorf("\nexp: %#v\nact: %#v", exp, act)
+	}
+}
+
+func TestHarness_rollback(t *testing.T) {
+	h := New(t, replicate.Must(`
+		prefix = "global@dc1"
+		undo { passes = 2 }
+	`))
+	source := h.Consul.Datacenter("dc1")
+	h.Destination.SetPair(&api.KVPair{Key: "global/x", Value: []byte("0"), Flags: 42})
+	source.Set("global/a", "1")
+	source.Set("global/b", "2")
+	if _, err := h.Sync(); err != nil {
+		t.Fatal(err)
+	}
+	first := h.Destination.Values("global/")
+
+	// A bad upstream change is replicated
+	source.Set("global/a", "bad")
+	source.Remove("global/b")
+	source.Set("global/c", "3")
+	if _, err := h.Sync(); err != nil {
+		t.Fatal(err)
+	}
+
+	results, err := h.Runner.Rollback(1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(results) != 1 || results[0].Restored != 2 || results[0].Deleted != 1 {
+		t.Errorf("unexpected results: %#v", results[0])
+	}
+	if act := h.Destination.Values("global/"); !reflect.DeepEqual(first, act) {
+		t.Errorf("\nexp: %#v\nact: %#v", first, act)
+	}
+
+	// Rolling back the first pass restores the key it deleted, with its flags
+	if _, err := h.Runner.Rollback(1); err != nil {
+		t.Fatal(err)
+	}
+	exp := map[string]string{"global/x": "0"}
+	if act := h.Destination.Values("global/"); !reflect.DeepEqual(exp, act) {
+		t.Errorf("\nexp: %#v\nact: %#v", exp, act)
+	}
+	if pair, _ := h.Destination.Get("global/x"); pair == nil || pair.Flags != 42 {
+		t.Errorf("expected the flags of global/x to be restored, got %#v", pair)
+	}
+
+	// Nothing is left to roll back
+	if _, err := h.Runner.Rollback(1); err == nil || !strings.Contains(err.Error(), "only 0 passes") {
+		t.Errorf("expected the rollback to fail, got %v", err)
+	}
+}
+
+func TestHarness_rollbackLimits(t *testing.T) {
+	cases := []struct {
+		name string
+		undo string
+		exp  string
+	}{
+		{"passes", `undo { passes = 1 }`, "only 1 passes"},
+		{"max_bytes", `undo { max_bytes = 10 }`, "only 0 passes"},
+	}
+
+	for i, tc := range cases {
+		t.Run(fmt.Sprintf("%d_%s", i, tc.name), func(t *testing.T) {
+			h := New(t, replicate.Must(`prefix = "global@dc1"`+"\n"+tc.undo))
+			source := h.Consul.Datacenter("dc1")
+			for _, v := range []string{"1", "2"} {
+				source.Set("global/a", v)
+				if _, err := h.Sync(); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			if _, err := h.Runner.Rollback(2); err == nil || !strings.Contains(err.Error(), tc.exp) {
+				t.Errorf("\nexp: %#v\nact: %#v", tc.exp, err)
+			}
+		})
+	}
+}
+
+func TestHarness_deleteOwnedOnly(t *testing.T) {
+	h := New(t, replicate.Must(`
+		prefix {
+			source            = "global@dc1"
+			delete_owned_only = true
+		}
+	`))
+	source := h.Consul.Datacenter("dc1")
+	source.Set("global/a", "1")
+	source.Set("global/b", "2")
+	source.Set("global/d", "4")
+	h.Destination.Set("global/c", "by hand")
+	h.Destination.SetPair(&api.KVPair{Key: "global/e", Value: []byte("by hand"), Flags: 0xffff << 32})
+	if _, err := h.Sync(); err != nil {
+		t.Fatal(err)
+	}
+
+	// Only the replicated key is deleted, and a replicated key written by hand
+	// since is kept
+	h.Destination.Set("global/d", "by hand")
+	source.Remove("global/b")
+	source.Remove("global/d")
+	events, err := h.Sync()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(events) != 1 || events[0].Deletes != 1 || events[0].Kept != 3 {
+		t.Errorf("expected 1 deleted and 3 kept keys, got %#v", events)
+	}
+	act := h.Destination.Values("global/")
+	exp := map[string]string{
+		"global/a": "1",
+		"global/c": "by hand",
+		"global/d": "by hand",
+		"global/e": "by hand",
+	}
+	if !reflect.DeepEqual(exp, act) {
+		t.Errorf("\nexp: %#v\nact: %#v", exp, act)
+	}
+}
+
+func TestHarness_canary(t *testing.T) {
+	accept := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer accept.Close()
+	reject := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "invalid", http.StatusUnprocessableEntity)
+	}))
+	defer reject.Close()
+
+	cases := []struct {
+		name   string
+		canary string
+		err    bool
+	}{
+		{
+			"no_hooks",
+			``,
+			false,
+		},
+		{
+			"command_accepts",
+			`command = "true"`,
+			false,
+		},
+		{
+			"command_rejects",
+			`command = "false"`,
+			true,
+		},
+		{
+			"url_accepts",
+			fmt.Sprintf(`url = %q`, accept.URL),
+			false,
+		},
+		{
+			"url_rejects",
+			fmt.Sprintf(`url = %q`, reject.URL),
+			true,
+		},
+	}
+
+	for i, tc := range cases {
+		t.Run(fmt.Sprintf("%d_%s", i, tc.name), func(t *testing.T) {
+			h := New(t, replicate.Must(fmt.Sprintf(`prefix {
+				source = "global"
+				datacenter = "dc1"
+				canary {
+					enabled = true
+					%s
+				}
+			}`, tc.canary)))
+			h.Consul.Datacenter("dc1").Set("global/a", "1")
+			h.Destination.Set("global/b", "2")
+
+			events, err := h.Sync()
+			if (err != nil) != tc.err {
+				t.Fatal(err)
+			}
+
+			// Changes are always staged, but only promoted once validated
+			if v, _ := h.Destination.Value("global-staging/a"); v != "1" {
+				t.Errorf("expected global-staging/a to be staged")
+			}
+
+			exp := map[string]string{"global/a": "1"}
+			if tc.err {
+				exp = map[string]string{"global/b": "2"}
+			}
+			act := h.Destination.Values("global/")
+			if !reflect.DeepEqual(exp, act) {
+				t.Errorf("\nexp: %#v\nact: %#v", exp, act)
+			}
+
+			if !tc.err && (events[0].Updates != 1 || events[0].Deletes != 1) {
+				t.Errorf("expected 1 update and 1 delete, got %#v", events[0])
+			}
+		})
+	}
+}
+
+func TestHarness_validate(t *testing.T) {
+	schema := filepath.Join(t.TempDir(), "schema.json")
+	if err := os.WriteFile(schema, []byte(`{"type": "object", "required": ["port"]}`), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	cases := []struct {
+		name     string
+		validate string
+		exp      map[string]string
+		skipped  []string
+	}{
+		{
+			"json",
+			`json = true`,
+			map[string]string{"global/a": `{"port": 80}`, "global/b": `{}`, "global/c": "stale"},
+			[]string{"global/c"},
+		},
+		{
+			"json_schema",
+			fmt.Sprintf(`json_schema = %q`, schema),
+			map[string]string{"global/a": `{"port": 80}`, "global/c": "stale"},
+			[]string{"global/b", "global/c"},
+		},
+	}
+
+	for i, tc := range cases {
+		t.Run(fmt.Sprintf("%d_%s", i, tc.name), func(t *testing.T) {
+			h := New(t, replicate.Must(fmt.Sprintf(`prefix {
+				source = "global"
+				datacenter = "dc1"
+				validate {
+					%s
+				}
+			}`, tc.validate)))
+			source := h.Consul.Datacenter("dc1")
+			source.Set("global/a", `{"port": 80}`)
+			source.Set("global/b", `{}`)
+			source.Set("global/c", `{"port": `)
+
+			// Invalid values leave the destination untouched
+			h.Destination.Set("global/c", "stale")
+
+			events, err := h.Sync()
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if act := h.Destination.Values("global/"); !reflect.DeepEqual(tc.exp, act) {
+				t.Errorf("\nexp: %#v\nact: %#v", tc.exp, act)
+			}
+			for _, key := range tc.skipped {
+				if _, ok := events[0].Skipped[key]; !ok {
+					t.Errorf("expected %q to be skipped, got %#v", key, events[0].Skipped)
+				}
+			}
+		})
+	}
+}
+
+func TestHarness_binary(t *testing.T) {
+	cases := []struct {
+		name   string
+		config string
+		encode bool
+	}{
+		{
+			"prefix",
+			`prefix = "global@dc1"`,
+			false,
+		},
+		{
+			"verify_before_write",
+			`prefix = "global@dc1"
+			 verify_before_write = true`,
+			false,
+		},
+		{
+			"canary",
+			`prefix {
+				source = "global"
+				datacenter = "dc1"
+				canary { enabled = true }
+			}`,
+			false,
+		},
+		{
+			"base64",
+			`prefix {
+				source = "global"
+				datacenter = "dc1"
+				middleware { name = "base64" }
+			}`,
+			true,
+		},
+	}
+
+	for i, tc := range cases {
+		t.Run(fmt.Sprintf("%d_%s", i, tc.name), func(t *testing.T) {
+			h := New(t, replicate.Must(tc.config))
+			source := h.Consul.Datacenter("dc1")
+
+			// Random payloads, including NUL bytes and invalid UTF-8
+			rnd := rand.New(rand.NewSource(int64(i)))
+			exp := make(map[string]string)
+			for j := 0; j < 50; j++ {
+				value := make([]byte, rnd.Intn(4096))
+				rnd.Read(value)
+
+				key := fmt.Sprintf("global/%d", j)
+				source.Set(key, string(value))
+				exp[key] = string(value)
+				if tc.encode {
+					exp[key] = base64.StdEncoding.EncodeToString(value)
+				}
+			}
+
+			if _, err := h.Sync(); err != nil {
+				t.Fatal(err)
+			}
+
+			act := h.Destination.Values("global/")
+			if !reflect.DeepEqual(exp, act) {
+				t.Errorf("values were not replicated byte for byte")
+			}
+		})
+	}
+}
+
+func TestHarness_replay(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "record.json")
+	h := New(t, replicate.Must(fmt.Sprintf(`
+		prefix = "global@dc1"
+		record_file = %q
+	`, path)))
+	source := h.Consul.Datacenter("dc1")
+	source.Set("global/a", "1")
+	source.Set("global/b", "2")
+	if _, err := h.Sync(); err != nil {
+		t.Fatal(err)
+	}
+	source.Set("global/a", "changed")
+	source.Remove("global/b")
+	if _, err := h.Sync(); err != nil {
+		t.Fatal(err)
+	}
+
+	// An unchanged source is not recorded again
+	if _, err := h.Sync(); err != nil {
+		t.Fatal(err)
+	}
+	b, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if act := bytes.Count(b, []byte("\n")); act != 2 {
+		t.Errorf("\nexp: %#v\nact: %#v", 2, act)
+	}
+
+	// The replayed destination ends up like the recorded one
+	replay := New(t, replicate.Must(`prefix = "global@dc1"`))
+	if err := replay.Runner.Replay(bytes.NewReader(b)); err != nil {
+		t.Fatal(err)
+	}
+	exp := map[string]string{"global/a": "changed"}
+	if act := replay.Destination.Values("global/"); !reflect.DeepEqual(exp, act) {
+		t.Errorf("\nexp: %#v\nact: %#v", exp, act)
+	}
+}
+
+func TestHarness_reserved(t *testing.T) {
+	// The destination datacenter is replicated into itself, so the statuses
+	// the replicator writes are read back from the source by the next pass
+	h := New(t, replicate.Must(`
+		allow_overlap        = true
+		config_consul_path   = "config/replicate"
+		prefixes_consul_path = "config/prefixes"
+
+		prefix {
+			source      = ""
+			datacenter  = "`+Datacenter+`"
+			destination = "mirror/"
+		}
+		exclude {
+			source = "mirror/"
+		}
+	`))
+	h.Destination.Set("app/a", "1")
+	h.Destination.Set("config/replicate", `log_level = "debug"`)
+	h.Destination.Set("config/prefixes/team-a", `{"source": "team-a", "datacenter": "dc1"}`)
+	h.Destination.Set("service/consul-replicate/statuses/other", "{}")
+
+	// Copies of the bookkeeping made before are removed
+	h.Destination.Set("mirror/service/consul-replicate/statuses/other", "{}")
+
+	for i := 0; i < 3; i++ {
+		if _, err := h.Sync(); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	exp := map[string]string{"mirror/app/a": "1"}
+	if act := h.Destination.Values("mirror/"); !reflect.DeepEqual(exp, act) {
+		t.Errorf("\nexp: %#v\nact: %#v", exp, act)
+	}
+	if act := h.Destination.Values("service/consul-replicate/statuses/"); len(act) < 2 {
+		t.Errorf("expected the statuses to be kept, got %#v", act)
+	}
+}
+
+func TestHarness_ttl(t *testing.T) {
+	h := New(t, replicate.Must(`prefix { source = "global" datacenter = "dc1" ttl = "10ms" }`))
+	source := h.Consul.Datacenter("dc1")
+	source.Set("global/a", "1")
+	source.Set("global/b", "2")
+
+	if _, err := h.Sync(); err != nil {
+		t.Fatal(err)
+	}
+
+	// The keys expire once the source has been unreachable for the TTL
+	h.Consul.Fail("dc1", ResponseError(500, "rpc error"))
+	time.Sleep(20 * time.Millisecond)
+	if err := h.Runner.Reap(); err != nil {
+		t.Fatal(err)
+	}
+	if act := h.Destination.Values("global/"); len(act) != 0 {
+		t.Fatalf("expected the keys to expire, got %#v", act)
+	}
+
+	// Unmodified keys are restored once the source is back
+	h.Consul.Fail("dc1", nil)
+	if _, err := h.Sync(); err != nil {
+		t.Fatal(err)
+	}
+	exp := map[string]string{"global/a": "1", "global/b": "2"}
+	if act := h.Destination.Values("global/"); !reflect.DeepEqual(exp, act) {
+		t.Errorf("\nexp: %#v\nact: %#v", exp, act)
+	}
+}
+
+func TestHarness_ttlOwnedOnly(t *testing.T) {
+	h := New(t, replicate.Must(`
+		prefix {
+			source            = "global@dc1"
+			ttl               = "10ms"
+			delete_owned_only = true
+		}
+	`))
+	source := h.Consul.Datacenter("dc1")
+	source.Set("global/a", "1")
+	h.Destination.Set("global/b", "by hand")
+	if _, err := h.Sync(); err != nil {
+		t.Fatal(err)
+	}
+
+	// Only the replicated key expires
+	h.Consul.Fail("dc1", ResponseError(500, "rpc error"))
+	time.Sleep(20 * time.Millisecond)
+	if err := h.Runner.Reap(); err != nil {
+		t.Fatal(err)
+	}
+	exp := map[string]string{"global/b": "by hand"}
+	if act := h.Destination.Values("global/"); !reflect.DeepEqual(exp, act) {
+		t.Errorf("\nexp: %#v\nact: %#v", exp, act)
+	}
+}
+
+func TestHarness_middlewareClose(t *testing.T) {
+	pidFile := filepath.Join(t.TempDir(), "pid")
+	consul := NewConsul()
+	consul.Datacenter("dc1").Set("global/a", "1")
+	r, err := replicate.NewRunnerWithInput(&replicate.NewRunnerInput{
+		Config: replicate.Must(fmt.Sprintf(`
+			prefix {
+				source     = "global"
+				datacenter = "dc1"
+				middleware {
+					name    = "exec"
+					options = { command = "sh -c 'echo $$ > %s; exec cat'" }
+				}
+			}
+		`, pidFile)),
+		Once:        true,
+		Source:      consul,
+		Destination: consul.Datacenter(Datacenter),
+		Datacenter:  Datacenter,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	go r.Start()
+	select {
+	case <-r.DoneCh:
+	case err := <-r.ErrCh:
+		t.Fatal(err)
+	}
+	b, err := os.ReadFile(pidFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pid, err := strconv.Atoi(strings.TrimSpace(string(b)))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The plugin exits with the runner
+	r.Stop()
+	if err := syscall.Kill(pid, 0); err != syscall.ESRCH {
+		t.Errorf("expected the plugin to exit, got %v", err)
+	}
+}
@@ -0,0 +1,299 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: MPL-2.0
+
+package replicatetest
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+
+	dep "github.com/hashicorp/consul-template/dependency"
+	"github.com/hashicorp/consul/api"
+)
+
+// maxValueSize is the largest value Consul accepts.
+const maxValueSize = 512 * 1024
+
+// KV is an in-memory fake of the KV store of a single Consul datacenter. It
+// implements replicate.Backend, so it can be the destination of a runner.
+// Every write advances the index of the datacenter, like a Raft index.
+type KV struct {
+	sync.Mutex
+
+	index uint64
+	pairs map[string]*api.KVPair
+
+	// errs are the errors returned for operations on a key, set with Fail.
+	errs map[string]error
+
+	// changed is closed on the next write, to wake up blocking queries.
+	changed chan struct{}
+}
+
+// NewKV creates an empty KV store.
+func NewKV() *KV {
+	return &KV{
+		pairs: make(map[string]*api.KVPair),
+		errs:  make(map[string]error),
+	}
+}
+
+// ResponseError returns an error like the one the Consul API client returns
+// for a response with the given HTTP status code, so the runner classifies it
+// like the real one.
+func ResponseError(code int, body string) error {
+	return fmt.Errorf("Unexpected response code: %d (%s)", code, body)
+}
+
+// Get returns the pair at the given key, or nil if it does not exist.
+func (kv *KV) Get(key string) (*api.KVPair, error) {
+	kv.Lock()
+	defer kv.Unlock()
+
+	if err := kv.errs[key]; err != nil {
+		return nil, err
+	}
+	pair, ok := kv.pairs[key]
+	if !ok {
+		return nil, nil
+	}
+	return copyPair(pair), nil
+}
+
+// Keys returns the sorted list of keys under the given prefix.
+func (kv *KV) Keys(prefix string) ([]string, error) {
+	kv.Lock()
+	defer kv.Unlock()
+
+	var keys []string
+	for key := range kv.pairs {
+		if strings.HasPrefix(key, prefix) {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+	return keys, nil
+}
+
+// Put writes the given pair. Like Consul, it refuses values over 512 KiB.
+func (kv *KV) Put(pair *api.KVPair) error {
+	kv.Lock()
+	defer kv.Unlock()
+
+	if err := kv.errs[pair.Key]; err != nil {
+		return err
+	}
+	if len(pair.Value) > maxValueSize {
+		return ResponseError(413, fmt.Sprintf("Value exceeds %d byte limit", maxValueSize))
+	}
+	kv.put(pair)
+	return nil
+}
+
+// Delete removes the given key.
+func (kv *KV) Delete(key string) error {
+	kv.Lock()
+	defer kv.Unlock()
+
+	if err := kv.errs[key]; err != nil {
+		return err
+	}
+
+	kv.index++
+	delete(kv.pairs, key)
+	kv.notifyLocked()
+	return nil
+}
+
+// Set writes the value of the key, ignoring errors set with Fail.
+func (kv *KV) Set(key, value string) {
+	kv.SetPair(&api.KVPair{Key: key, Value: []byte(value)})
+}
+
+// SetPair writes the pair, ignoring errors set with Fail.
+func (kv *KV) SetPair(pair *api.KVPair) {
+	kv.Lock()
+	defer kv.Unlock()
+	kv.put(pair)
+}
+
+// Remove deletes the key, ignoring errors set with Fail.
+func (kv *KV) Remove(key string) {
+	kv.Lock()
+	defer kv.Unlock()
+
+	kv.index++
+	delete(kv.pairs, key)
+	kv.notifyLocked()
+}
+
+// Value returns the value of the key, and whether it exists.
+func (kv *KV) Value(key string) (string, bool) {
+	kv.Lock()
+	defer kv.Unlock()
+
+	pair, ok := kv.pairs[key]
+	if !ok {
+		return "", false
+	}
+	return string(pair.Value), true
+}
+
+// Values returns the values of every key under the given prefix.
+func (kv *KV) Values(prefix string) map[string]string {
+	kv.Lock()
+	defer kv.Unlock()
+
+	values := make(map[string]string)
+	for key, pair := range kv.pairs {
+		if strings.HasPrefix(key, prefix) {
+			values[key] = string(pair.Value)
+		}
+	}
+	return values
+}
+
+// Fail makes every operation on the key return the given error, until it is
+// called again with a nil error.
+func (kv *KV) Fail(key string, err error) {
+	kv.Lock()
+	defer kv.Unlock()
+
+	if err == nil {
+		delete(kv.errs, key)
+		return
+	}
+	kv.errs[key] = err
+}
+
+// Restore rewinds the datacenter to the given index, like a snapshot restore.
+// Keys written after the index are removed, and the next writes reuse the
+// indexes after it.
+func (kv *KV) Restore(index uint64) {
+	kv.Lock()
+	defer kv.Unlock()
+
+	for key, pair := range kv.pairs {
+		if pair.ModifyIndex > index {
+			delete(kv.pairs, key)
+		}
+	}
+	kv.index = index
+	kv.notifyLocked()
+}
+
+// Index returns the index of the last write.
+func (kv *KV) Index() uint64 {
+	kv.Lock()
+	defer kv.Unlock()
+	return kv.index
+}
+
+// list returns the pairs under the path, like a recursive KV list, and the
+// index of the datacenter.
+func (kv *KV) list(path string) ([]*dep.KeyPair, uint64) {
+	kv.Lock()
+	defer kv.Unlock()
+
+	var pairs []*dep.KeyPair
+	for key, pair := range kv.pairs {
+		if !strings.HasPrefix(key, path) {
+			continue
+		}
+		pairs = append(pairs, &dep.KeyPair{
+			Path:        key,
+			Key:         strings.TrimLeft(strings.TrimPrefix(key, path), "/"),
+			Value:       string(pair.Value),
+			CreateIndex: pair.CreateIndex,
+			ModifyIndex: pair.ModifyIndex,
+			LockIndex:   pair.LockIndex,
+			Flags:       pair.Flags,
+			Session:     pair.Session,
+		})
+	}
+	sort.Slice(pairs, func(i, j int) bool {
+		return pairs[i].Path < pairs[j].Path
+	})
+	return pairs, kv.index
+}
+
+// folders returns the keys and folders directly under the path, like a KV
+// keys listing with a "/" separator.
+func (kv *KV) folders(path string) []string {
+	keys, _ := kv.Keys(path)
+
+	var result []string
+	seen := make(map[string]struct{})
+	for _, key := range keys {
+		if i := strings.Index(key[len(path):], "/"); i >= 0 {
+			key = key[:len(path)+i+1]
+		}
+		if _, ok := seen[key]; !ok {
+			seen[key] = struct{}{}
+			result = append(result, key)
+		}
+	}
+	return result
+}
+
+// put writes a copy of the pair at the next index, keeping the create index
+// of an existing key.
+func (kv *KV) put(pair *api.KVPair) {
+	kv.index++
+	p := copyPair(pair)
+	p.CreateIndex, p.ModifyIndex = kv.index, kv.index
+	if existing, ok := kv.pairs[pair.Key]; ok {
+		p.CreateIndex = existing.CreateIndex
+	}
+	kv.pairs[pair.Key] = p
+	kv.notifyLocked()
+}
+
+// changedLocked returns the channel closed on the next write. The caller must
+// hold the lock.
+func (kv *KV) changedLocked() <-chan struct{} {
+	if kv.changed == nil {
+		kv.changed = make(chan struct{})
+	}
+	return kv.changed
+}
+
+// notifyLocked wakes up the blocking queries. The caller must hold the lock.
+func (kv *KV) notifyLocked() {
+	if kv.changed != nil {
+		close(kv.changed)
+		kv.changed = nil
+	}
+}
+
+// keysLocked returns the sorted keys under the prefix, up to the first
+// separator after it if one is given. The caller must hold the lock.
+func (kv *KV) keysLocked(prefix, separator string) []string {
+	seen := make(map[string]struct{})
+	var keys []string
+	for key := range kv.pairs {
+		if !strings.HasPrefix(key, prefix) {
+			continue
+		}
+		if separator != "" {
+			if i := strings.Index(key[len(prefix):], separator); i >= 0 {
+				key = key[:len(prefix)+i+len(separator)]
+			}
+		}
+		if _, ok := seen[key]; !ok {
+			seen[key] = struct{}{}
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// copyPair returns a copy of the pair which does not share its value.
+func copyPair(pair *api.KVPair) *api.KVPair {
+	p := *pair
+	p.Value = append([]byte(nil), pair.Value...)
+	return &p
+}
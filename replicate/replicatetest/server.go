@@ -0,0 +1,374 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: MPL-2.0
+
+package replicatetest
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/hashicorp/consul/api"
+)
+
+// maxBlockingWait is the longest a blocking query of a Server waits for a
+// change, so clients loop quickly and the server closes promptly.
+const maxBlockingWait = 100 * time.Millisecond
+
+// Server serves the HTTP API of Consul over the datacenters of a fake Consul,
+// with the sessions and blocking queries the in-memory fakes lack, so runners
+// which elect a leader or watch their source can run against it. Requests
+// without a "dc" query parameter go to the datacenter named Datacenter.
+//
+// Only the endpoints the runner uses are served: KV reads, listings and
+// writes, with their cas, acquire and release options, sessions, and the agent
+// and catalog datacenter lookups.
+type Server struct {
+	*httptest.Server
+
+	// Consul holds the KV stores of the datacenters.
+	Consul *Consul
+
+	t testing.TB
+
+	sync.Mutex
+	sessions map[string]struct{}
+	nextID   int
+	closeCh  chan struct{}
+}
+
+// NewServer starts a server for a new fake Consul. It is closed when the test
+// finishes.
+func NewServer(t testing.TB) *Server {
+	t.Helper()
+
+	s := &Server{
+		Consul:   NewConsul(),
+		t:        t,
+		sessions: make(map[string]struct{}),
+		closeCh:  make(chan struct{}),
+	}
+	s.Consul.Datacenter(Datacenter)
+	s.Server = httptest.NewServer(http.HandlerFunc(s.serve))
+	t.Cleanup(func() {
+		close(s.closeCh)
+		s.Server.Close()
+	})
+	return s
+}
+
+// Address returns the address of the server, as given to a consul block.
+func (s *Server) Address() string {
+	return strings.TrimPrefix(s.URL, "http://")
+}
+
+// Sessions returns the IDs of the live sessions, sorted.
+func (s *Server) Sessions() []string {
+	s.Lock()
+	defer s.Unlock()
+
+	ids := make([]string, 0, len(s.sessions))
+	for id := range s.sessions {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+	return ids
+}
+
+// Holder returns the session holding the lock of the key in the destination
+// datacenter, if any.
+func (s *Server) Holder(key string) string {
+	kv := s.Consul.Datacenter(Datacenter)
+	kv.Lock()
+	defer kv.Unlock()
+
+	if pair, ok := kv.pairs[key]; ok {
+		return pair.Session
+	}
+	return ""
+}
+
+func (s *Server) serve(w http.ResponseWriter, req *http.Request) {
+	path := req.URL.Path
+	switch {
+	case strings.HasPrefix(path, "/v1/kv/"):
+		s.serveKV(w, req, strings.TrimPrefix(path, "/v1/kv/"))
+	case strings.HasPrefix(path, "/v1/session/"):
+		s.serveSession(w, req, strings.TrimPrefix(path, "/v1/session/"))
+	case path == "/v1/agent/self":
+		writeJSON(w, 0, map[string]interface{}{
+			"Config": map[string]interface{}{
+				"Datacenter": Datacenter,
+				"NodeName":   "fake",
+				"Version":    "1.16.0",
+			},
+		})
+	case path == "/v1/catalog/datacenters":
+		names, _ := s.Consul.Datacenters()
+		writeJSON(w, 0, names)
+	case path == "/v1/status/leader":
+		writeJSON(w, 0, s.Address())
+	default:
+		s.t.Logf("fake consul: unsupported request %s %s", req.Method, req.URL)
+		http.NotFound(w, req)
+	}
+}
+
+// serveKV serves the KV endpoints of the datacenter of the request.
+func (s *Server) serveKV(w http.ResponseWriter, req *http.Request, key string) {
+	q := req.URL.Query()
+	dc := q.Get("dc")
+	if dc == "" {
+		dc = Datacenter
+	}
+	kv, err := s.Consul.datacenter(dc)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	switch req.Method {
+	case http.MethodGet:
+		s.block(kv, q)
+		kv.Lock()
+		defer kv.Unlock()
+
+		index := kv.index
+		if index == 0 {
+			index = 1
+		}
+		if _, ok := q["keys"]; ok {
+			keys := kv.keysLocked(key, q.Get("separator"))
+			if len(keys) == 0 {
+				writeIndex(w, index)
+				http.NotFound(w, req)
+				return
+			}
+			writeJSON(w, index, keys)
+			return
+		}
+
+		var pairs []*api.KVPair
+		for k, pair := range kv.pairs {
+			_, recurse := q["recurse"]
+			if k == key || (recurse && strings.HasPrefix(k, key)) {
+				pairs = append(pairs, copyPair(pair))
+			}
+		}
+		if len(pairs) == 0 {
+			writeIndex(w, index)
+			http.NotFound(w, req)
+			return
+		}
+		sort.Slice(pairs, func(i, j int) bool { return pairs[i].Key < pairs[j].Key })
+		writeJSON(w, index, pairs)
+
+	case http.MethodPut:
+		value, err := io.ReadAll(req.Body)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		flags, _ := strconv.ParseUint(q.Get("flags"), 10, 64)
+		ok, err := s.put(kv, &api.KVPair{Key: key, Value: value, Flags: flags}, q)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		writeJSON(w, 0, ok)
+
+	case http.MethodDelete:
+		kv.Lock()
+		defer kv.Unlock()
+
+		if err := kv.errs[key]; err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		if cas := q.Get("cas"); cas != "" {
+			index, _ := strconv.ParseUint(cas, 10, 64)
+			if pair, ok := kv.pairs[key]; !ok || pair.ModifyIndex != index {
+				writeJSON(w, 0, false)
+				return
+			}
+		}
+		_, recurse := q["recurse"]
+		for k := range kv.pairs {
+			if k == key || (recurse && strings.HasPrefix(k, key)) {
+				delete(kv.pairs, k)
+			}
+		}
+		kv.index++
+		kv.notifyLocked()
+		writeJSON(w, 0, true)
+
+	default:
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+// put writes the pair with the cas, acquire and release options of the query,
+// and returns false if an option prevented the write.
+func (s *Server) put(kv *KV, pair *api.KVPair, q map[string][]string) (bool, error) {
+	get := func(name string) string {
+		if v := q[name]; len(v) > 0 {
+			return v[0]
+		}
+		return ""
+	}
+
+	acquire, release := get("acquire"), get("release")
+	if acquire != "" && !s.live(acquire) {
+		return false, fmt.Errorf("invalid session %q", acquire)
+	}
+
+	kv.Lock()
+	defer kv.Unlock()
+
+	if err := kv.errs[pair.Key]; err != nil {
+		return false, err
+	}
+	if len(pair.Value) > maxValueSize {
+		return false, ResponseError(413, fmt.Sprintf("Value exceeds %d byte limit", maxValueSize))
+	}
+
+	existing, exists := kv.pairs[pair.Key]
+	if cas := get("cas"); cas != "" {
+		index, _ := strconv.ParseUint(cas, 10, 64)
+		if (index == 0 && exists) || (index != 0 && (!exists || existing.ModifyIndex != index)) {
+			return false, nil
+		}
+	}
+
+	session, lockIndex := "", uint64(0)
+	if exists {
+		session, lockIndex = existing.Session, existing.LockIndex
+	}
+	switch {
+	case acquire != "":
+		if session != "" && session != acquire {
+			return false, nil
+		}
+		if session != acquire {
+			lockIndex++
+		}
+		session = acquire
+	case release != "":
+		if session != release {
+			return false, nil
+		}
+		session = ""
+	}
+
+	kv.put(pair)
+	kv.pairs[pair.Key].Session, kv.pairs[pair.Key].LockIndex = session, lockIndex
+	return true, nil
+}
+
+// serveSession serves the session endpoints.
+func (s *Server) serveSession(w http.ResponseWriter, req *http.Request, path string) {
+	op, id := path, ""
+	if i := strings.Index(path, "/"); i >= 0 {
+		op, id = path[:i], path[i+1:]
+	}
+
+	switch op {
+	case "create":
+		s.Lock()
+		s.nextID++
+		id = fmt.Sprintf("00000000-0000-0000-0000-%012d", s.nextID)
+		s.sessions[id] = struct{}{}
+		s.Unlock()
+		writeJSON(w, 0, map[string]string{"ID": id})
+	case "renew", "info":
+		if !s.live(id) {
+			if op == "renew" {
+				http.NotFound(w, req)
+				return
+			}
+			writeJSON(w, 1, []interface{}{})
+			return
+		}
+		writeJSON(w, 1, []*api.SessionEntry{{ID: id, TTL: "15s", Behavior: "release"}})
+	case "destroy":
+		s.destroy(id)
+		writeJSON(w, 0, true)
+	default:
+		s.t.Logf("fake consul: unsupported request %s %s", req.Method, req.URL)
+		http.NotFound(w, req)
+	}
+}
+
+// live returns true if the session exists.
+func (s *Server) live(id string) bool {
+	s.Lock()
+	defer s.Unlock()
+	_, ok := s.sessions[id]
+	return ok
+}
+
+// destroy removes the session and releases the locks it holds, like a session
+// with the release behavior.
+func (s *Server) destroy(id string) {
+	s.Lock()
+	delete(s.sessions, id)
+	s.Unlock()
+
+	names, _ := s.Consul.Datacenters()
+	for _, name := range names {
+		kv := s.Consul.Datacenter(name)
+		kv.Lock()
+		for _, pair := range kv.pairs {
+			if pair.Session == id {
+				kv.index++
+				pair.Session, pair.ModifyIndex = "", kv.index
+			}
+		}
+		kv.notifyLocked()
+		kv.Unlock()
+	}
+}
+
+// block waits until the datacenter changes past the index of the query, for
+// at most maxBlockingWait.
+func (s *Server) block(kv *KV, q map[string][]string) {
+	v := q["index"]
+	if len(v) == 0 {
+		return
+	}
+	index, _ := strconv.ParseUint(v[0], 10, 64)
+
+	kv.Lock()
+	if kv.index > index || index == 0 {
+		kv.Unlock()
+		return
+	}
+	changed := kv.changedLocked()
+	kv.Unlock()
+
+	select {
+	case <-changed:
+	case <-time.After(maxBlockingWait):
+	case <-s.closeCh:
+	}
+}
+
+func writeIndex(w http.ResponseWriter, index uint64) {
+	if index > 0 {
+		w.Header().Set("X-Consul-Index", strconv.FormatUint(index, 10))
+	}
+}
+
+func writeJSON(w http.ResponseWriter, index uint64, v interface{}) {
+	writeIndex(w, index)
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(v)
+}
@@ -0,0 +1,105 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: MPL-2.0
+
+package replicatetest
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/hashicorp/consul-replicate/replicate"
+)
+
+func TestSupervisor_onceStopped(t *testing.T) {
+	s := NewServer(t)
+
+	// The source datacenter does not exist, so the pass waits for the
+	// clusters until it is stopped
+	c := replicate.TestConfig(replicate.Must(fmt.Sprintf(`
+		consul {
+			address = %q
+		}
+		destination_consul {
+			address = %q
+		}
+		wait_for_clusters {
+			enabled  = true
+			interval = "1m"
+		}
+		prefix {
+			source      = "global"
+			datacenter  = "dc1"
+			destination = "replica"
+		}
+	`, s.Address(), s.Address())))
+
+	sup := replicate.NewSupervisor(c, true)
+	go sup.Start()
+	time.Sleep(100 * time.Millisecond)
+	go sup.Stop()
+
+	select {
+	case err := <-sup.ErrCh:
+		if exp := "stopped before its pass finished"; !strings.Contains(err.Error(), exp) {
+			t.Errorf("\nexp: %#v\nact: %#v", exp, err.Error())
+		}
+	case <-sup.DoneCh:
+		t.Errorf("expected an error")
+	case <-time.After(5 * time.Second):
+		t.Fatal("timed out waiting for the result of the pass")
+	}
+}
+
+func TestSupervisor_onceDone(t *testing.T) {
+	s := NewServer(t)
+	s.Consul.Datacenter("dc1").Set("global/a", "1")
+
+	pidFile := filepath.Join(t.TempDir(), "pid")
+	c := replicate.TestConfig(replicate.Must(fmt.Sprintf(`
+		consul {
+			address = %q
+		}
+		destination_consul {
+			address = %q
+		}
+		prefix {
+			source      = "global"
+			datacenter  = "dc1"
+			destination = "replica"
+			middleware {
+				name    = "exec"
+				options = { command = "sh -c 'echo $$ > %s; exec cat'" }
+			}
+		}
+	`, s.Address(), s.Address(), pidFile)))
+
+	sup := replicate.NewSupervisor(c, true)
+	go sup.Start()
+
+	select {
+	case err := <-sup.ErrCh:
+		t.Fatal(err)
+	case <-sup.DoneCh:
+	case <-time.After(5 * time.Second):
+		t.Fatal("timed out waiting for the result of the pass")
+	}
+
+	// The runner which finished its pass is stopped, so its plugin exits
+	b, err := os.ReadFile(pidFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pid, err := strconv.Atoi(strings.TrimSpace(string(b)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := syscall.Kill(pid, 0); err != syscall.ESRCH {
+		t.Errorf("expected the plugin to exit, got %v", err)
+	}
+}
@@ -0,0 +1,55 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: MPL-2.0
+
+package replicate
+
+import (
+	"strings"
+
+	"github.com/hashicorp/consul-template/config"
+)
+
+// reserved returns true if the key belongs to the replicator itself. Reserved
+// keys are never written nor deleted by replication, so that a prefix which
+// replicates the root of the KV store does not overwrite or remove the status
+// of the replicator. They are never read from the source either, even when
+// they are not excluded, so the bookkeeping of a replicator is never copied
+// into another destination, such as by a prefix which replicates a datacenter
+// into itself. They are the status directory, which also holds the manifests
+// and the default HA and shard keys, the HA lock, the shard membership keys,
+// the Consul configuration key, the folder of prefixes, the allow list, and the
+// folders of metadata keys, diffs and backups.
+func (r *Runner) reserved(key string) bool {
+	if dir := strings.TrimRight(config.StringVal(r.config.StatusDir), "/"); dir != "" {
+		if strings.HasPrefix(key, dir+"/") {
+			return true
+		}
+	}
+
+	if key == r.lockKey() || strings.HasPrefix(key, r.membersPrefix()) {
+		return true
+	}
+
+	if path := config.StringVal(r.config.ConfigConsulPath); path != "" && key == path {
+		return true
+	}
+
+	if r.metadataReserved() && strings.HasPrefix(key, r.metadataDir()+"/") {
+		return true
+	}
+
+	if p := r.diffConsulPath(); p != "" && strings.HasPrefix(key, p+"/") {
+		return true
+	}
+
+	if p := r.backupConsulPath(); p != "" && strings.HasPrefix(key, p+"/") {
+		return true
+	}
+
+	if r.allowListEnabled() && strings.HasPrefix(key, r.allowListPath()+"/") {
+		return true
+	}
+
+	path := strings.TrimRight(config.StringVal(r.config.PrefixesConsulPath), "/")
+	return path != "" && (key == path || strings.HasPrefix(key, path+"/"))
+}
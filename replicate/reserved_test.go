@@ -0,0 +1,54 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: MPL-2.0
+
+package replicate
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/hashicorp/consul-template/config"
+)
+
+func TestRunner_reserved(t *testing.T) {
+	c := DefaultConfig()
+	c.HA.LockKey = config.String("locks/replicate")
+	c.Shard.MembersPrefix = config.String("pool")
+	c.ConfigConsulPath = config.String("config/replicate")
+	c.PrefixesConsulPath = config.String("config/prefixes/")
+	c.Metadata.Dir = config.String("_meta/")
+	c.AllowList.Path = config.String("security/allow/")
+	c.Finalize()
+	r := &Runner{config: c}
+
+	cases := []struct {
+		key string
+		exp bool
+	}{
+		{"service/consul-replicate/statuses/6f0e4e0c", true},
+		{"service/consul-replicate/statuses/manifests/6f0e4e0c", true},
+		{"service/consul-replicate/statuses", false},
+		{"locks/replicate", true},
+		{"locks/other", false},
+		{"pool/node1", true},
+		{"pool", false},
+		{"config/replicate", true},
+		{"config/replicate/other", false},
+		{"config/prefixes", true},
+		{"config/prefixes/team-a", true},
+		{"config/prefixes-old", false},
+		{"_meta/global/a", true},
+		{"_meta", false},
+		{"security/allow/global/a", true},
+		{"security/allow", false},
+		{"global/a", false},
+	}
+
+	for i, tc := range cases {
+		t.Run(fmt.Sprintf("%d_%s", i, tc.key), func(t *testing.T) {
+			if act := r.reserved(tc.key); act != tc.exp {
+				t.Errorf("\nexp: %#v\nact: %#v", tc.exp, act)
+			}
+		})
+	}
+}
@@ -0,0 +1,216 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: MPL-2.0
+
+package replicate
+
+import (
+	"context"
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/hashicorp/consul-template/config"
+	dep "github.com/hashicorp/consul-template/dependency"
+	"github.com/hashicorp/consul/api"
+	"github.com/pkg/errors"
+)
+
+// checkRoutes returns an error if a route of the prefix is invalid.
+func checkRoutes(prefix *PrefixConfig) error {
+	if len(*prefix.Routes) == 0 {
+		return nil
+	}
+
+	source := config.StringVal(prefix.Source)
+	if config.BoolVal(prefix.Canary.Enabled) {
+		return fmt.Errorf("prefix %q with routes cannot use a canary", source)
+	}
+	if config.BoolVal(prefix.Merged) {
+		return fmt.Errorf("prefix %q with routes cannot be merged", source)
+	}
+
+	for _, route := range *prefix.Routes {
+		if _, err := regexp.Compile(config.StringVal(route.Pattern)); err != nil {
+			return errors.Wrapf(err, "route of prefix %q", source)
+		}
+		if config.StringVal(route.Destination) == "" {
+			return fmt.Errorf("route of prefix %q: missing destination", source)
+		}
+		if config.StringVal(route.Token) != "" && config.StringVal(prefix.Backend) != BackendConsul {
+			return fmt.Errorf("route of prefix %q: a token requires the %q backend",
+				source, BackendConsul)
+		}
+	}
+	return nil
+}
+
+// initRoutes compiles the patterns of the routes of the configuration.
+func (r *Runner) initRoutes() error {
+	r.routes = make(map[string]*regexp.Regexp)
+	for _, prefix := range *r.config.Prefixes {
+		for _, route := range *prefix.Routes {
+			pattern := config.StringVal(route.Pattern)
+			if _, ok := r.routes[pattern]; ok {
+				continue
+			}
+
+			re, err := regexp.Compile(pattern)
+			if err != nil {
+				return errors.Wrapf(err, "route of prefix %q", config.StringVal(prefix.Source))
+			}
+			r.routes[pattern] = re
+		}
+	}
+	return nil
+}
+
+// destinationKey returns the destination key of the source pair. Keys matching
+// a route of the prefix are written under the destination of the first one,
+// with the pattern matched against the key relative to the source.
+func (r *Runner) destinationKey(prefix *PrefixConfig, pair *dep.KeyPair) string {
+	source := config.StringVal(prefix.Source)
+	if len(*prefix.Routes) > 0 {
+		rel := strings.TrimLeft(strings.TrimPrefix(pair.Path, source), "/")
+		for _, route := range *prefix.Routes {
+			if r.routes[config.StringVal(route.Pattern)].MatchString(rel) {
+				return joinDestination(config.StringVal(route.Destination), rel)
+			}
+		}
+	}
+	return config.StringVal(prefix.Destination) + strings.TrimPrefix(pair.Path, source)
+}
+
+// routeDestinations returns the distinct destinations of the prefix and its
+// routes.
+func routeDestinations(prefix *PrefixConfig) []string {
+	destinations := []string{config.StringVal(prefix.Destination)}
+	seen := map[string]struct{}{destinations[0]: {}}
+	for _, route := range *prefix.Routes {
+		d := config.StringVal(route.Destination)
+		if _, ok := seen[d]; ok {
+			continue
+		}
+		seen[d] = struct{}{}
+		destinations = append(destinations, d)
+	}
+	return destinations
+}
+
+// destinationKeys returns the keys of the destinations of the prefix and its
+// routes, each listed once.
+func (r *Runner) destinationKeys(backend Backend, prefix *PrefixConfig) ([]string, error) {
+	if len(*prefix.Routes) == 0 {
+		return backend.Keys(config.StringVal(prefix.Destination))
+	}
+
+	var keys []string
+	seen := make(map[string]struct{})
+	for _, destination := range routeDestinations(prefix) {
+		list, err := backend.Keys(destination)
+		if err != nil {
+			return nil, err
+		}
+		for _, key := range list {
+			if _, ok := seen[key]; !ok {
+				seen[key] = struct{}{}
+				keys = append(keys, key)
+			}
+		}
+	}
+	return keys, nil
+}
+
+// routedBackend is a Backend which accesses the destinations of the routes of
+// a prefix with their own tokens, and every other key with the default one.
+type routedBackend struct {
+	Backend
+
+	// routes are the backends of the route destinations, longest first.
+	routes []*routeBackend
+}
+
+type routeBackend struct {
+	destination string
+	backend     Backend
+}
+
+// newRoutedBackend returns the backend of the prefix, which is the given one
+// unless a route has its own token.
+func newRoutedBackend(backend Backend, prefix *PrefixConfig) Backend {
+	c, ok := backend.(*consulBackend)
+	if !ok {
+		return backend
+	}
+
+	var routes []*routeBackend
+	for _, route := range *prefix.Routes {
+		if token := config.StringVal(route.Token); token != "" {
+			routes = append(routes, &routeBackend{
+				destination: config.StringVal(route.Destination),
+				backend:     c.withToken(token),
+			})
+		}
+	}
+	if len(routes) == 0 {
+		return backend
+	}
+
+	sort.SliceStable(routes, func(i, j int) bool {
+		return len(routes[i].destination) > len(routes[j].destination)
+	})
+	return &routedBackend{Backend: backend, routes: routes}
+}
+
+func (b *routedBackend) withContext(ctx context.Context) Backend {
+	routes := make([]*routeBackend, len(b.routes))
+	for i, route := range b.routes {
+		routes[i] = &routeBackend{
+			destination: route.destination,
+			backend:     bindContext(route.backend, ctx),
+		}
+	}
+	return &routedBackend{Backend: bindContext(b.Backend, ctx), routes: routes}
+}
+
+func (b *routedBackend) withStale(maxStale time.Duration) Backend {
+	routes := make([]*routeBackend, len(b.routes))
+	for i, route := range b.routes {
+		routes[i] = &routeBackend{
+			destination: route.destination,
+			backend:     allowStale(route.backend, maxStale),
+		}
+	}
+	return &routedBackend{Backend: allowStale(b.Backend, maxStale), routes: routes}
+}
+
+// backendFor returns the backend of the key.
+func (b *routedBackend) backendFor(key string) Backend {
+	for _, route := range b.routes {
+		if strings.HasPrefix(key, route.destination) {
+			return route.backend
+		}
+	}
+	return b.Backend
+}
+
+func (b *routedBackend) Get(key string) (*api.KVPair, error) {
+	return b.backendFor(key).Get(key)
+}
+
+func (b *routedBackend) Keys(prefix string) ([]string, error) {
+	return b.backendFor(prefix).Keys(prefix)
+}
+
+func (b *routedBackend) Put(pair *api.KVPair) error {
+	return b.backendFor(pair.Key).Put(pair)
+}
+
+func (b *routedBackend) cas(pair *api.KVPair) (bool, error) {
+	return putCAS(b.backendFor(pair.Key), pair)
+}
+
+func (b *routedBackend) Delete(key string) error {
+	return b.backendFor(key).Delete(key)
+}
@@ -0,0 +1,2099 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: MPL-2.0
+
+package replicate
+
+import (
+	"bytes"
+	"context"
+	"crypto/md5"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"regexp"
+	"sort"
+	"sync"
+	"time"
+
+	"strings"
+
+	"github.com/hashicorp/consul-template/config"
+	dep "github.com/hashicorp/consul-template/dependency"
+	"github.com/hashicorp/consul-template/watch"
+	"github.com/hashicorp/consul/api"
+	"github.com/hashicorp/go-multierror"
+	"github.com/pkg/errors"
+)
+
+// Regexp for invalid characters in keys
+var InvalidRegexp = regexp.MustCompile(`[^a-zA-Z0-9_]`)
+
+// Status is an internal struct that is responsible for marshaling and
+// unmarshaling JSON responses into keys.
+type Status struct {
+	// LastReplicated is the last time the replication occurred.
+	LastReplicated uint64
+
+	// Source and Destination are the given and final destination.
+	Source, Destination string
+
+	// LastRefreshed is the last time the source was known to be reachable. It
+	// is only maintained for prefixes with a TTL.
+	LastRefreshed time.Time
+
+	// LastUpdated is the last time the status was written. Statuses of
+	// prefixes which are no longer configured are garbage collected once it is
+	// older than the maximum age of the status_gc configuration.
+	LastUpdated time.Time
+
+	// Failures are the destination keys which could not be written or deleted
+	// in the last pass, and why. They are retried in the next pass.
+	Failures map[string]string `json:",omitempty"`
+
+	// Skipped are the source keys which were rejected by the key rules of the
+	// prefix in the last pass, and why.
+	Skipped map[string]string `json:",omitempty"`
+
+	// ConfigChecksum is the checksum of the configuration of the replicator
+	// which last wrote the status.
+	ConfigChecksum string `json:",omitempty"`
+}
+
+// PrefixStatus is the replication status of an active prefix.
+type PrefixStatus struct {
+	Source, Datacenter, Destination string
+
+	// LastReplicated is the source index which was last replicated.
+	LastReplicated uint64
+
+	// Failures are the keys which failed in the last pass, and why.
+	Failures map[string]string
+
+	// BytesRead is the number of bytes read from the source by the watch of
+	// the prefix, and BytesWritten the number of bytes written into the
+	// destination, since the runner started.
+	BytesRead    uint64
+	BytesWritten uint64
+
+	// Healthy is false while the prefix is failing, in which case it is
+	// retried with an exponential backoff. ConsecutiveFailures is the number of
+	// times in a row it failed, and LastError is the last error.
+	Healthy             bool
+	ConsecutiveFailures int
+	LastError           string
+
+	// CredentialsInvalid is true while the destination denies the token, in
+	// which case every prefix is held back with a long backoff.
+	CredentialsInvalid bool
+
+	// InitialSyncComplete is true once a pass of the prefix replicated every
+	// key, and its initial sync barrier key was written.
+	InitialSyncComplete bool
+}
+
+type Runner struct {
+	sync.RWMutex
+
+	// // Prefix is the KeyPrefixDependency associated with this Runner.
+	// Prefix *dependency.StoreKeyPrefix
+
+	// ErrCh and DoneCh are channels where errors and finish notifications occur.
+	ErrCh  chan error
+	DoneCh chan struct{}
+
+	// eventCh is where the outcome of every replication pass is published.
+	eventCh chan *Event
+
+	// stopCh is closed when the runner is stopped, to halt background
+	// goroutines.
+	stopCh chan struct{}
+
+	// ctx is cancelled when the runner is stopped, which aborts the requests
+	// of replication passes and blocking queries in flight.
+	ctx    context.Context
+	cancel context.CancelFunc
+
+	// statusLock serializes read-modify-write cycles of the status keys between
+	// replication passes and background goroutines.
+	statusLock sync.Mutex
+
+	// config is the Config that created this Runner. It is used internally to
+	// construct other objects and pass data.
+	config *Config
+
+	// checksum is the checksum of the configuration, recorded in the statuses.
+	checksum string
+
+	// client is the consul/api client.
+	clients *clientSet
+
+	destinationClients *clientSet
+
+	// source is where prefixes are read from, which is watched if it is the
+	// source Consul.
+	source Source
+
+	// datacenter is the datacenter of the destination, if it was given instead
+	// of being asked of the destination agent.
+	datacenter string
+
+	// backends are the destination backends, keyed by name.
+	backends map[string]Backend
+
+	// statusStore is where the statuses are stored, or nil if they are stored
+	// in the destination of each prefix.
+	statusStore Backend
+
+	// prefixes is the list of concrete prefixes being replicated, which
+	// includes the expansions of any wildcard prefixes.
+	prefixes []*PrefixConfig
+
+	// middlewares are the middleware instances, keyed by middlewareID.
+	middlewares map[string]Middleware
+
+	// schemas are the JSON Schemas values are validated against, keyed by path.
+	schemas map[string]*jsonSchema
+
+	// routes are the compiled patterns of the routes, keyed by pattern.
+	routes map[string]*regexp.Regexp
+
+	// leader is true while the runner holds the HA lock, and leaderCh is
+	// notified every time it is acquired. leaderCtx is cancelled when the lock
+	// is lost, which aborts the passes in flight.
+	leader       bool
+	leaderCh     chan struct{}
+	leaderCtx    context.Context
+	leaderCancel context.CancelFunc
+
+	// shardCh is notified every time the runner joins the shard pool.
+	shardCh chan struct{}
+
+	// resyncCh is notified to replicate every key again, and resync is true
+	// during that pass.
+	resyncCh chan struct{}
+	resync   bool
+
+	// health is the supervision state of the prefixes which failed, keyed by
+	// the String() of the prefix dependency, and retryCh is notified when
+	// one of them is due to be retried, or when a blackout window closes.
+	health     map[string]*prefixHealth
+	healthLock sync.Mutex
+	retryCh    chan struct{}
+
+	// denied is the credentials invalid state, while the destination denies
+	// the token.
+	denied *deniedState
+
+	// synced are the prefixes whose initial sync is known to be complete,
+	// keyed by dependency.
+	synced     map[string]struct{}
+	syncedLock sync.Mutex
+
+	// mirrors are the local copies of the destination keys of the prefixes,
+	// keyed by the path of their journal.
+	mirrors     map[string]*mirror
+	mirrorsLock sync.Mutex
+
+	// drifts are the watches of the destinations of the prefixes for drift,
+	// keyed by the String() of the prefix dependency, and driftCh is where the
+	// drifts they find are published.
+	drifts     map[string]*driftWatch
+	driftsLock sync.Mutex
+	driftCh    chan *Drift
+
+	// allowed are the source keys of the allow list, nil until it is read.
+	allowed     map[string]struct{}
+	allowedLock sync.RWMutex
+
+	// inherited is the state handed over by the process this one replaced,
+	// and handedOver is true once the runner hands its own state over to the
+	// process replacing it.
+	inherited  *GroupHandover
+	handedOver bool
+
+	// stuck holds the prefixes whose pass timed out and is still running,
+	// keyed by the String() of the prefix dependency.
+	stuck     map[string]struct{}
+	stuckLock sync.Mutex
+
+	// meters count the bytes read by the watches and written by the passes of
+	// the prefixes, keyed by the String() of their dependency.
+	meters     map[string]*meter
+	metersLock sync.Mutex
+
+	// sourceInfo and destinationInfo are the versions of the clusters, or nil
+	// if they are unknown.
+	sourceInfo      *clusterInfo
+	destinationInfo *clusterInfo
+
+	// recorder appends the snapshots of the source prefixes to the record
+	// file, if one is configured.
+	recorder *recorder
+
+	// diffs keeps the diffs of the passes, and backups the destination keys of
+	// prefixes before passes deleted any of them, if enabled.
+	diffs   *archive
+	backups *archive
+
+	// telemetry emits the metrics of the passes, if a sink is configured.
+	telemetry *telemetry
+
+	// alerts evaluates the alert rules against the passes, if enabled.
+	alerts *alerter
+
+	// history keeps the summaries of the last passes of every prefix, unless
+	// the stats history is disabled.
+	history *passHistory
+
+	// sourceToken and destinationToken swap the tokens of the clients when
+	// the token files change.
+	sourceToken      *tokenTransport
+	destinationToken *tokenTransport
+
+	// sourceLogin and destinationLogin obtain and renew the tokens of the
+	// clients from their auth methods.
+	sourceLogin      *login
+	destinationLogin *login
+
+	// sourceCerts and destinationCerts swap the transports of the clients when
+	// the certificate files change.
+	sourceCerts      *certTransport
+	destinationCerts *certTransport
+
+	// replicator and labels identify the replication group of the runner.
+	replicator string
+	labels     map[string]string
+
+	// sinks are the streams where applied changes are published.
+	sinks []Sink
+
+	// snapshots are the snapshots replayed instead of watching the source,
+	// keyed by datacenter. A snapshot file is stored under the empty string.
+	snapshots map[string]*snapshot
+
+	// data is the internal storage engine for this runner with the key being the
+	// String() for the dependency and the result being the view that holds the
+	// data.
+	data map[string]*watch.View
+
+	// backoffs are the adaptive backoffs of the blocking queries, keyed by
+	// datacenter.
+	backoffs    map[string]*backoff
+	backoffLock sync.Mutex
+
+	// origin is the datacenter of the destination, once resolved, for loop
+	// detection.
+	origin     string
+	originLock sync.Mutex
+
+	// cache holds the results of non-blocking reads while servers are talked
+	// to directly. It is nil otherwise, which reads through.
+	cache *cache
+
+	// watches is the dependency watched for each prefix, keyed by the String()
+	// of the prefix dependency. It differs from the prefix dependency when
+	// watches are coalesced.
+	watches map[string]*dep.KVListQuery
+
+	// once indicates the runner should get data exactly one time and then stop.
+	once bool
+
+	// minTimer and maxTimer are used for quiescence.
+	minTimer, maxTimer <-chan time.Time
+
+	// updates is the number of source updates received per watch since the
+	// last pass, and passUpdates those coalesced into the current pass.
+	updates, passUpdates map[string]int
+
+	// outStream and errStream are the io.Writer streams where the runner will
+	// write information.
+	outStream, errStream io.Writer
+
+	// watcher is the watcher this runner is using.
+	watcher *watch.Watcher
+}
+
+// NewRunner accepts a config, command, and boolean value for once mode.
+func NewRunner(config *Config, once bool) (*Runner, error) {
+	return NewRunnerWithInput(&NewRunnerInput{
+		Config: config,
+		Once:   once,
+	})
+}
+
+// NewRunnerInput is used as input to the NewRunnerWithInput function.
+type NewRunnerInput struct {
+	// Config is the configuration of the runner.
+	Config *Config
+
+	// Once indicates the runner should replicate one time and then stop.
+	Once bool
+
+	// Source, if given, is read instead of the source Consul. It is listed in
+	// every replication pass instead of being watched, so Start replicates a
+	// single pass and stops, like a snapshot replay.
+	Source Source
+
+	// Destination, if given, is written instead of the destination Consul, for
+	// prefixes of the consul backend.
+	Destination Backend
+
+	// Datacenter is the datacenter of the destination. It is asked of the
+	// destination agent if empty.
+	Datacenter string
+}
+
+// NewRunnerWithInput creates a runner which may read and write other stores
+// than Consul, such as the fakes of the replicatetest package.
+func NewRunnerWithInput(i *NewRunnerInput) (*Runner, error) {
+	log.Printf("[INFO] (runner) creating new runner (once: %v)", i.Once)
+
+	runner := &Runner{
+		config:     i.Config,
+		once:       i.Once,
+		source:     i.Source,
+		datacenter: i.Datacenter,
+	}
+
+	if err := runner.init(); err != nil {
+		return nil, err
+	}
+
+	if i.Destination != nil {
+		runner.backends[BackendConsul] = i.Destination
+	}
+
+	return runner, nil
+}
+
+// Start creates a new runner and begins watching dependencies and quiescence
+// timers. This is the main event loop and will block until finished.
+func (r *Runner) Start() {
+	log.Printf("[INFO] (runner) starting")
+
+	// Create the pid before doing anything.
+	if err := r.storePid(); err != nil {
+		r.ErrCh <- err
+		return
+	}
+
+	// Load the snapshots to replay, if any
+	if err := r.loadSnapshots(); err != nil {
+		r.ErrCh <- err
+		return
+	}
+
+	// Log in to the auth methods, and renew the tokens for as long as the
+	// runner runs
+	if err := r.Login(); err != nil {
+		r.ErrCh <- err
+		return
+	}
+	if !r.once {
+		for _, l := range r.logins() {
+			go r.renewLogin(l)
+		}
+	}
+
+	// Pick up rotated tokens for as long as the runner runs, including while
+	// waiting for the clusters
+	if r.tokenRotationEnabled() && !r.once {
+		go r.rotateTokens()
+	}
+	if r.certRotationEnabled() && !r.once {
+		go r.rotateCerts()
+	}
+	if r.alerts != nil && !r.once {
+		go r.watchAlerts()
+	}
+	if r.allowListEnabled() && !r.once {
+		go r.watchAllowList()
+	}
+
+	// Hold back the first pass until both clusters are healthy, so boot order
+	// races are waited out instead of reported
+	if r.waitForClustersEnabled() {
+		ready, err := r.waitForClusters()
+		if err != nil {
+			r.ErrCh <- err
+			return
+		}
+		if !ready {
+			return
+		}
+	}
+
+	// Adapt to the versions of the clusters, and report what they lack
+	if r.versionCheckEnabled() {
+		if err := r.checkVersions(); err != nil {
+			r.ErrCh <- err
+			return
+		}
+		if config.TimeDurationVal(r.config.VersionCheck.Interval) > 0 && !r.once {
+			go r.watchVersions()
+		}
+	}
+
+	// Campaign for leadership. A warm standby watches the source like the
+	// leader and only holds back writes, so it can replicate from its cached
+	// data the moment it acquires the lock. A cold standby only starts
+	// watching once it is the leader.
+	if r.haEnabled() {
+		go r.elect()
+
+		if !config.BoolVal(r.config.HA.WarmStandby) {
+			select {
+			case <-r.leaderCh:
+			case <-r.stopCh:
+				return
+			}
+		}
+	}
+
+	// Join the shard pool. Until the instance is a member it owns no
+	// prefixes, and they are discovered again once it has joined.
+	if r.shardEnabled() {
+		go r.joinShard()
+	}
+
+	// Add the dependencies to the watcher, expanding any wildcards
+	if err := r.discover(); err != nil {
+		r.ErrCh <- err
+		return
+	}
+
+	// Report missing permissions before anything is replicated
+	if err := r.preflight(); err != nil {
+		r.ErrCh <- err
+		return
+	}
+
+	// Snapshots, and sources which are not watched, are replicated in a
+	// single pass
+	if !r.watched() {
+		if err := r.Run(); err != nil {
+			r.ErrCh <- err
+			return
+		}
+		if err := r.materializeCatalogs(); err != nil {
+			r.ErrCh <- err
+			return
+		}
+		if r.snapshots != nil {
+			log.Printf("[INFO] (runner) snapshot replayed, exiting")
+		} else {
+			log.Printf("[INFO] (runner) source replicated, exiting")
+		}
+		r.DoneCh <- struct{}{}
+		return
+	}
+
+	// Periodically re-expand wildcards so folders which come and go on the
+	// source are picked up, and rebalance shards as members come and go
+	var discoveryCh <-chan time.Time
+	if (r.hasWildcards() || r.shardEnabled()) && !r.once {
+		ticker := time.NewTicker(config.TimeDurationVal(r.config.DiscoveryInterval))
+		defer ticker.Stop()
+		discoveryCh = ticker.C
+	}
+
+	// Expire keys of prefixes with a TTL when the source is unreachable
+	if r.hasTTLs() && !r.once {
+		go r.reap()
+	}
+
+	// Remove stale entries from the status dir
+	if r.statusGCEnabled() && !r.once {
+		go r.collectStatus()
+	}
+
+	// Materialize the catalogs of the source datacenters
+	if !r.once {
+		for _, c := range *r.config.Catalogs {
+			go r.watchCatalog(c)
+		}
+	}
+
+	// If once mode is on, wait until we get data back from all the views before proceeding
+	onceCh := make(chan struct{}, 1)
+	if r.once {
+		for i := 0; i < r.watcher.Size(); i++ {
+			select {
+			case view := <-r.watcher.DataCh():
+				r.Receive(view)
+			case err := <-r.watcher.ErrCh():
+				r.ErrCh <- err
+				return
+			}
+		}
+		onceCh <- struct{}{}
+	}
+
+	for {
+		select {
+		case view := <-r.watcher.DataCh():
+			r.Receive(view)
+
+			// Drain all views that have data
+		OUTER:
+			for {
+				select {
+				case view = <-r.watcher.DataCh():
+					r.Receive(view)
+				default:
+					break OUTER
+				}
+			}
+
+			// If we are waiting for quiescence, setup the timers
+			if *r.config.Wait.Min != 0 && *r.config.Wait.Max != 0 {
+				log.Printf("[INFO] (runner) quiescence timers starting")
+				r.minTimer = time.After(*r.config.Wait.Min)
+				if r.maxTimer == nil {
+					r.maxTimer = time.After(r.batchDelay())
+				}
+				continue
+			}
+		case <-r.minTimer:
+			log.Printf("[INFO] (runner) quiescence minTimer fired")
+			r.minTimer, r.maxTimer = nil, nil
+		case <-r.maxTimer:
+			log.Printf("[INFO] (runner) quiescence maxTimer fired")
+			r.minTimer, r.maxTimer = nil, nil
+		case <-r.leaderCh:
+			log.Printf("[INFO] (runner) became leader, replicating cached data")
+		case <-r.resyncCh:
+			log.Printf("[INFO] (runner) resyncing every prefix")
+			r.resync = true
+		case <-r.retryCh:
+			log.Printf("[INFO] (runner) retrying failed or deferred prefixes")
+		case <-r.shardCh:
+			if err := r.discover(); err != nil {
+				log.Printf("[WARN] (runner) failed to discover prefixes: %s", err)
+			}
+			continue
+		case <-discoveryCh:
+			if err := r.discover(); err != nil {
+				log.Printf("[WARN] (runner) failed to discover prefixes: %s", err)
+			}
+			continue
+		case err := <-r.watcher.ErrCh():
+			// A watch which died is restarted while the others keep going
+			if r.restartEnabled() && r.restartWatch(err) {
+				continue
+			}
+			log.Printf("[ERR] (runner) watcher reported error: %s", err)
+			r.ErrCh <- err
+		case <-r.DoneCh:
+			log.Printf("[INFO] (runner) received finish")
+			return
+		case <-onceCh:
+		}
+
+		// If we got this far, that means we got new data or one of the timers
+		// fired, so attempt to run.
+		err := r.Run()
+		r.resync = false
+		if err != nil {
+			// Passes interrupted by a stop are not errors
+			if r.ctx.Err() != nil {
+				return
+			}
+			r.ErrCh <- err
+			return
+		}
+
+		if r.once {
+			if err := r.materializeCatalogs(); err != nil {
+				r.ErrCh <- err
+				return
+			}
+			log.Printf("[INFO] (runner) run finished and -once is set, exiting")
+			r.DoneCh <- struct{}{}
+			return
+		}
+	}
+}
+
+// Stop halts the execution of this runner and its subprocesses.
+func (r *Runner) Stop() {
+	log.Printf("[INFO] (runner) stopping")
+	close(r.stopCh)
+	r.cancel()
+	r.watcher.Stop()
+	r.clients.Stop()
+	r.destinationClients.Stop()
+	if err := r.recorder.close(); err != nil {
+		log.Printf("[WARN] (runner) could not close the record file: %s", err)
+	}
+	r.telemetry.shutdown()
+	r.closeMirrors()
+	r.closeMiddlewares()
+	if err := r.deletePid(); err != nil {
+		log.Printf("[WARN] (runner) could not remove pid at %q: %s",
+			*r.config.PidFile, err)
+	}
+	close(r.DoneCh)
+}
+
+// Status returns the replication status of every active prefix.
+func (r *Runner) Status() ([]*PrefixStatus, error) {
+	var result []*PrefixStatus
+	for _, prefix := range r.activePrefixes() {
+		status, err := r.getStatus(prefix)
+		if err != nil {
+			return nil, errors.Wrapf(err, "reading status of %s", prefix.Dependency)
+		}
+
+		synced, err := r.initialSyncComplete(prefix)
+		if err != nil {
+			return nil, errors.Wrapf(err, "reading initial sync of %s", prefix.Dependency)
+		}
+
+		failures, lastErr := r.healthOf(prefix)
+		denied := r.deniedOf()
+		if denied != nil {
+			lastErr = denied
+		}
+		read, written := r.bytesOf(prefix)
+		ps := &PrefixStatus{
+			Source:              config.StringVal(prefix.Source),
+			Datacenter:          config.StringVal(prefix.Datacenter),
+			Destination:         config.StringVal(prefix.Destination),
+			LastReplicated:      status.LastReplicated,
+			Failures:            status.Failures,
+			BytesRead:           read,
+			BytesWritten:        written,
+			Healthy:             failures == 0 && denied == nil,
+			ConsecutiveFailures: failures,
+			InitialSyncComplete: synced,
+			CredentialsInvalid:  denied != nil,
+		}
+		if lastErr != nil {
+			ps.LastError = lastErr.Error()
+		}
+		result = append(result, ps)
+	}
+	return result, nil
+}
+
+// Resync starts a pass which replicates every key again, regardless of what
+// was last replicated.
+func (r *Runner) Resync() {
+	select {
+	case r.resyncCh <- struct{}{}:
+	default:
+	}
+}
+
+// Receive accepts data from Consul and maps that data to the prefix.
+func (r *Runner) Receive(view *watch.View) {
+	r.Lock()
+	defer r.Unlock()
+	r.data[view.Dependency().String()] = view
+	r.updates[view.Dependency().String()]++
+}
+
+// batchDelay returns the maximum amount of time quiescence may delay a pass.
+func (r *Runner) batchDelay() time.Duration {
+	delay := config.TimeDurationVal(r.config.Wait.Max)
+	if max := config.TimeDurationVal(r.config.MaxBatchDelay); max > 0 && max < delay {
+		delay = max
+	}
+	return delay
+}
+
+// takeUpdates starts a pass, coalescing the source updates received since the
+// last one into it, and returns their total.
+func (r *Runner) takeUpdates() int {
+	r.Lock()
+	defer r.Unlock()
+
+	total := 0
+	for _, n := range r.updates {
+		total += n
+	}
+	r.passUpdates, r.updates = r.updates, make(map[string]int)
+	return total
+}
+
+// coalesced returns the number of source updates of the prefix coalesced into
+// the current pass.
+func (r *Runner) coalesced(prefix *PrefixConfig) int {
+	r.RLock()
+	defer r.RUnlock()
+
+	d, ok := r.watches[prefix.Dependency.String()]
+	if !ok {
+		return 0
+	}
+	return r.passUpdates[d.String()]
+}
+
+// Run invokes a single pass of the runner.
+func (r *Runner) Run() error {
+	if r.haEnabled() && !r.isLeader() {
+		log.Printf("[DEBUG] (runner) standing by, not replicating")
+		return nil
+	}
+
+	log.Printf("[INFO] (runner) running")
+	if n := r.takeUpdates(); n > 1 {
+		log.Printf("[INFO] (runner) coalesced %d source updates into this pass", n)
+	}
+
+	// Nothing is written while the destination denies the token, until the
+	// prefixes are due to be retried
+	if r.holdingBack() {
+		log.Printf("[DEBUG] (runner) the destination denies the token, skipping")
+		return nil
+	}
+
+	// Replicate each priority class in turn, and the prefixes of a class in
+	// parallel, so high priority prefixes are not held back by bulk data
+	// Prefixes which failed are held back until they are due to be retried
+	active := r.activePrefixes()
+	if r.restartEnabled() {
+		ready := active[:0]
+		for _, prefix := range active {
+			if r.backingOff(prefix) {
+				log.Printf("[DEBUG] (runner) %s is backing off, skipping", prefix.Dependency)
+				continue
+			}
+			ready = append(ready, prefix)
+		}
+		active = ready
+	}
+
+	// Prefixes in a blackout window are replicated once it closes
+	now := time.Now()
+	open := active[:0]
+	for _, prefix := range active {
+		if end, ok := blackoutEnd(prefix, now); ok {
+			log.Printf("[INFO] (runner) %s is in a blackout window until %s, deferring",
+				prefix.Dependency, end.Format(time.RFC3339))
+			r.retryAfter(end.Sub(now))
+			continue
+		}
+		open = append(open, prefix)
+	}
+	active = open
+
+	// Merged prefixes are replicated by the first prefix of their group
+	unmerged := active[:0]
+	for _, prefix := range active {
+		if r.mergedInto(prefix) {
+			continue
+		}
+		unmerged = append(unmerged, prefix)
+	}
+	active = unmerged
+
+	var errs *multierror.Error
+	for _, prefixes := range priorityClasses(active) {
+		doneCh := make(chan struct{}, len(prefixes))
+		errCh := make(chan error, len(prefixes))
+
+		for _, prefix := range prefixes {
+			go r.replicate(prefix, r.config.Excludes, doneCh, errCh)
+		}
+
+		for i := 0; i < len(prefixes); i++ {
+			select {
+			case <-doneCh:
+				// OK
+			case err := <-errCh:
+				errs = multierror.Append(errs, err)
+			}
+		}
+	}
+
+	return errs.ErrorOrNil()
+}
+
+// priorityClasses groups the prefixes by priority, from highest to lowest.
+func priorityClasses(prefixes []*PrefixConfig) [][]*PrefixConfig {
+	sorted := make([]*PrefixConfig, len(prefixes))
+	copy(sorted, prefixes)
+	sort.SliceStable(sorted, func(i, j int) bool {
+		return config.IntVal(sorted[i].Priority) > config.IntVal(sorted[j].Priority)
+	})
+
+	var classes [][]*PrefixConfig
+	for i, prefix := range sorted {
+		if i == 0 || config.IntVal(prefix.Priority) != config.IntVal(sorted[i-1].Priority) {
+			classes = append(classes, nil)
+		}
+		classes[len(classes)-1] = append(classes[len(classes)-1], prefix)
+	}
+	return classes
+}
+
+// init creates the Runner's underlying data structures and returns an error if
+// any problems occur.
+func (r *Runner) init() error {
+	// Ensure default configuration values
+	r.config = DefaultConfig().Merge(r.config)
+	r.config.Finalize()
+
+	checksum, err := r.config.Checksum()
+	if err != nil {
+		return fmt.Errorf("runner: %s", err)
+	}
+	r.checksum = checksum
+	log.Printf("[INFO] (runner) configuration checksum %s", checksum)
+
+	// Requests bound to the context are aborted when the runner is stopped
+	r.ctx, r.cancel = context.WithCancel(context.Background())
+
+	// Disabled prefixes are kept in the configuration, but never replicated
+	enabled := make(PrefixConfigs, 0, len(*r.config.Prefixes))
+	for _, prefix := range *r.config.Prefixes {
+		if !config.BoolVal(prefix.Enabled) {
+			log.Printf("[INFO] (runner) prefix %q is disabled, skipping",
+				config.StringVal(prefix.Source)+"@"+config.StringVal(prefix.Datacenter))
+			continue
+		}
+		enabled = append(enabled, prefix)
+	}
+	r.config.Prefixes = &enabled
+
+	// Every destination is within the destination root
+	if root := config.StringVal(r.config.DestinationRoot); root != "" {
+		for i, prefix := range enabled {
+			prefix = prefix.Copy()
+			prefix.Destination = config.String(joinDestination(root, config.StringVal(prefix.Destination)))
+			for _, route := range *prefix.Routes {
+				route.Destination = config.String(joinDestination(root, config.StringVal(route.Destination)))
+			}
+			enabled[i] = prefix
+		}
+		for _, c := range *r.config.Catalogs {
+			if d := config.StringVal(c.Destination); d != "" {
+				c.Destination = config.String(joinDestination(root, d))
+			}
+		}
+	}
+
+	// Print the final config for debugging
+	result, err := json.MarshalIndent(r.config, "", "  ")
+	if err != nil {
+		return err
+	}
+	log.Printf("[DEBUG] (runner) final config (tokens suppressed):\n\n%s\n\n",
+		result)
+
+	// The destination is a datacenter of the cluster of the source, reached
+	// with the same client configuration
+	if config.BoolVal(r.config.Destination.SameCluster) {
+		if service := config.StringVal(r.config.Destination.Service); service != "" {
+			return fmt.Errorf("runner: destination service %q cannot be combined "+
+				"with same_cluster", service)
+		}
+		r.config.DestinationConsul = r.config.Consul.Copy()
+		r.config.DestinationConsulTokens = r.config.ConsulTokens.Copy()
+	}
+
+	// Create the client
+	clients, err := newServerClientSet(r.config.Consul, r.config.HTTP.Source,
+		r.config.Servers.Source)
+	if err != nil {
+		return fmt.Errorf("runner: %s", err)
+	}
+	r.clients = clients
+	if r.sourceCerts, err = r.initCerts(clients.httpClient, r.config.Consul.SSL); err != nil {
+		return fmt.Errorf("runner: %s", err)
+	}
+	if r.source == nil {
+		r.source = newConsulSource(r.ctx, clients.Consul())
+	}
+
+	destinationClients, err := r.newDestinationClientSet(clients.Consul())
+	if err != nil {
+		return fmt.Errorf("runner: %s", err)
+	}
+	r.destinationClients = destinationClients
+
+	// Stamp every request with the identity of the replicator, and with the
+	// admin partition it is made in
+	userAgent := r.userAgent()
+	for _, hc := range []*http.Client{clients.httpClient, destinationClients.httpClient} {
+		setUserAgent(hc, userAgent)
+		setPartitions(hc)
+	}
+
+	// Count the bytes read from the source and written into the destination
+	setBandwidth(clients.httpClient, config.IntVal(r.config.Bandwidth.SourceLimit), false)
+	setBandwidth(destinationClients.httpClient, config.IntVal(r.config.Bandwidth.DestinationLimit), true)
+
+	// Record the source prefixes, so their changes can be replayed later
+	if path := config.StringVal(r.config.RecordFile); path != "" && r.recorder == nil {
+		if r.recorder, err = newRecorder(path); err != nil {
+			return fmt.Errorf("runner: %s", err)
+		}
+	}
+
+	// Emit the metrics of the passes
+	if r.telemetry == nil {
+		if r.telemetry, err = newTelemetry(r.config.Telemetry); err != nil {
+			return fmt.Errorf("runner: %s", err)
+		}
+	}
+
+	// Keep the last passes of every prefix for the stats command
+	if r.history == nil {
+		r.history = newPassHistory(config.IntVal(r.config.StatsHistory))
+	}
+
+	// Notify when replication falls behind or fails
+	if r.alerts == nil {
+		if r.alerts, err = newAlerter(r.config.Alerts); err != nil {
+			return fmt.Errorf("runner: %s", err)
+		}
+	}
+
+	// Keep the diffs of the passes for incident reviews
+	r.diffs = &archive{
+		dir:        config.StringVal(r.config.Diff.Dir),
+		consulPath: r.diffConsulPath(),
+		retention:  config.TimeDurationVal(r.config.Diff.Retention),
+	}
+
+	// Back up the destination before deletes
+	r.backups = &archive{
+		dir:        config.StringVal(r.config.Backup.Dir),
+		consulPath: r.backupConsulPath(),
+		retention:  config.TimeDurationVal(r.config.Backup.Retention),
+	}
+
+	// Mirror the destination keys on disk, so restarts do not read them again
+	if r.mirrorEnabled() && config.StringVal(r.config.Mirror.Dir) == "" {
+		return fmt.Errorf("runner: the mirror requires a folder")
+	}
+
+	// Only destinations in Consul can be watched for drift
+	if r.driftEnabled() {
+		for _, prefix := range *r.config.Prefixes {
+			if config.StringVal(prefix.Backend) != BackendConsul || len(*prefix.Routes) > 0 {
+				log.Printf("[WARN] (runner) the destination of %q is not watched for drift, "+
+					"as it has routes or is not in Consul", config.StringVal(prefix.Source))
+			}
+		}
+	}
+
+	// Inject failures to validate alerting and recovery in staging
+	if err := r.initChaos(); err != nil {
+		return fmt.Errorf("runner: %s", err)
+	}
+
+	// Read the tokens from their files, so they can be rotated at runtime
+	if err := r.initTokens(); err != nil {
+		return fmt.Errorf("runner: %s", err)
+	}
+
+	// Obtain the tokens from auth methods instead, so no secret is configured
+	if err := r.initLogins(); err != nil {
+		return fmt.Errorf("runner: %s", err)
+	}
+
+	// Send the token of every datacenter which has one, over the others
+	if err := r.initDatacenterTokens(); err != nil {
+		return fmt.Errorf("runner: %s", err)
+	}
+	log.Printf("[DEBUG] (runner) using user agent %q", userAgent)
+
+	// Without a local agent to answer them, non-blocking reads are cached
+	if r.config.Servers.Enabled() {
+		r.cache = newCache(config.TimeDurationVal(r.config.Servers.CacheTTL))
+	}
+
+	// Create the destination backends
+	r.backends = map[string]Backend{
+		BackendConsul: bindContext(newConsulBackend(destinationClients.Consul()).
+			inDatacenter(config.StringVal(r.config.Destination.Datacenter)), r.ctx),
+	}
+	if r.statusStore, err = newStatusBackend(r.config); err != nil {
+		return fmt.Errorf("runner: %s", err)
+	}
+	if err := r.checkCatalogs(); err != nil {
+		return fmt.Errorf("runner: %s", err)
+	}
+	if err := checkBlockQuery(r.config.BlockQuery); err != nil {
+		return fmt.Errorf("runner: %s", err)
+	}
+
+	for _, prefix := range *r.config.Prefixes {
+		switch config.StringVal(prefix.OnSourceEmpty) {
+		case OnSourceEmptyDelete, OnSourceEmptyKeep, OnSourceEmptyFail:
+		default:
+			return fmt.Errorf("runner: invalid on_source_empty %q for prefix %q",
+				config.StringVal(prefix.OnSourceEmpty), config.StringVal(prefix.Source))
+		}
+
+		if err := checkCanary(prefix); err != nil {
+			return fmt.Errorf("runner: %s", err)
+		}
+
+		if err := checkBlackouts(prefix); err != nil {
+			return fmt.Errorf("runner: %s", err)
+		}
+
+		if err := checkMerge(prefix); err != nil {
+			return fmt.Errorf("runner: %s", err)
+		}
+
+		if err := checkRoutes(prefix); err != nil {
+			return fmt.Errorf("runner: %s", err)
+		}
+
+		if err := checkOwnership(prefix); err != nil {
+			return fmt.Errorf("runner: %s", err)
+		}
+
+		if err := checkFlags(prefix); err != nil {
+			return fmt.Errorf("runner: %s", err)
+		}
+
+		if err := r.checkPartitions(prefix); err != nil {
+			return fmt.Errorf("runner: %s", err)
+		}
+
+		name := config.StringVal(prefix.Backend)
+		if _, ok := r.backends[name]; ok {
+			continue
+		}
+
+		switch name {
+		case BackendKubernetes:
+			b, err := newKubernetesBackend(r.config.Kubernetes)
+			if err != nil {
+				return fmt.Errorf("runner: %s", err)
+			}
+			b.userAgent = userAgent
+			b.ctx = r.ctx
+			r.backends[name] = b
+		default:
+			return fmt.Errorf("runner: unknown backend %q for prefix %q",
+				name, config.StringVal(prefix.Source))
+		}
+	}
+
+	// Create the sinks
+	for _, c := range *r.config.Sinks {
+		s, err := newSink(c)
+		if err != nil {
+			return fmt.Errorf("runner: %s", err)
+		}
+		r.sinks = append(r.sinks, s)
+	}
+
+	// Create the middleware
+	if err := r.initMiddlewares(); err != nil {
+		return fmt.Errorf("runner: %s", err)
+	}
+
+	// Load the JSON Schemas
+	if err := r.initSchemas(); err != nil {
+		return fmt.Errorf("runner: %s", err)
+	}
+
+	// Compile the routes
+	if err := r.initRoutes(); err != nil {
+		return fmt.Errorf("runner: %s", err)
+	}
+
+	// Create the watcher
+	// The queries read with the client set of the runner, so the watcher has
+	// no clients of its own
+	watcher, err := newWatcher(r.config, dep.NewClientSet(), r.once)
+	if err != nil {
+		return fmt.Errorf("runner: %s", err)
+	}
+	r.watcher = watcher
+
+	r.data = make(map[string]*watch.View)
+	r.updates = make(map[string]int)
+
+	r.outStream = os.Stdout
+	r.errStream = os.Stderr
+
+	r.ErrCh = make(chan error)
+	r.DoneCh = make(chan struct{})
+	r.eventCh = make(chan *Event, eventBufferSize)
+	r.stopCh = make(chan struct{})
+	r.leaderCh = make(chan struct{}, 1)
+	r.shardCh = make(chan struct{}, 1)
+	r.resyncCh = make(chan struct{}, 1)
+	r.health = make(map[string]*prefixHealth)
+	r.synced = make(map[string]struct{})
+	r.mirrors = make(map[string]*mirror)
+	r.drifts = make(map[string]*driftWatch)
+	r.driftCh = make(chan *Drift, driftBufferSize)
+	r.denied = &deniedState{}
+	r.stuck = make(map[string]struct{})
+	r.retryCh = make(chan struct{}, 1)
+
+	return nil
+}
+
+// get returns the data for a particular view in the watcher.
+func (r *Runner) get(prefix *PrefixConfig) (*watch.View, bool) {
+	r.RLock()
+	defer r.RUnlock()
+	d, ok := r.watches[prefix.Dependency.String()]
+	if !ok {
+		return nil, false
+	}
+	result, ok := r.data[d.String()]
+	return result, ok
+}
+
+// replicate performs replication into the current datacenter from the given
+// prefix. This function is designed to be called via a goroutine since it is
+// expensive and needs to be parallelized.
+func (r *Runner) replicate(prefix *PrefixConfig, excludes *ExcludeConfigs, doneCh chan struct{}, errCh chan error) {
+	start := time.Now()
+	event := &Event{
+		Replicator:  r.replicator,
+		Labels:      r.labels,
+		Source:      config.StringVal(prefix.Source),
+		Datacenter:  config.StringVal(prefix.Datacenter),
+		Destination: config.StringVal(prefix.Destination),
+		Coalesced:   r.coalesced(prefix),
+	}
+
+	err := r.runPass(prefix, func(ctx context.Context) error {
+		defer r.guardDrift(prefix)()
+		ctx = withMeter(ctx, r.meter(prefix.Dependency.String()))
+		if config.BoolVal(prefix.Canary.Enabled) {
+			return r.replicateCanary(ctx, prefix, excludes, event)
+		}
+		return r.replicatePrefix(ctx, prefix, excludes, event)
+	})
+	if err != nil && r.ctx.Err() != nil {
+		log.Printf("[DEBUG] (runner) pass of %s interrupted by stop", prefix.Dependency)
+		doneCh <- struct{}{}
+		return
+	}
+	if err != nil && r.haEnabled() && !r.isLeader() {
+		log.Printf("[WARN] (runner) pass of %s interrupted by the loss of the lock", prefix.Dependency)
+		doneCh <- struct{}{}
+		return
+	}
+	event.Err = err
+	event.Time = time.Now().UTC()
+	event.Duration = time.Since(start)
+	log.Printf("[INFO] (runner) pass %s", event.Summary())
+	r.publish(event)
+	r.emit(event)
+	r.writeDiff(prefix, event)
+	r.telemetry.pass(event, start)
+	r.history.add(prefix.Dependency.String(), event)
+	r.alerts.observe(prefix.Dependency.String(), event)
+
+	denied := err != nil && r.deniedEnabled() && deniedError(err)
+	r.telemetry.credentials(event, denied)
+
+	if err == nil {
+		r.healthy(prefix)
+		r.allow()
+	} else if denied {
+		// Every prefix is held back until the token works again
+		r.deny(err)
+	} else if r.restartEnabled() {
+		// The prefix is retried later, while the others keep replicating
+		r.retryAfter(r.fail(prefix, err))
+	} else {
+		errCh <- err
+		return
+	}
+	doneCh <- struct{}{}
+}
+
+// replicatePrefix performs a single replication pass of the prefix, recording
+// the outcome in the given event. The pass stops once the context is
+// cancelled.
+func (r *Runner) replicatePrefix(ctx context.Context, prefix *PrefixConfig, excludes *ExcludeConfigs, event *Event) error {
+	backend := bindContext(r.backend(prefix), ctx)
+
+	// Get the last status
+	status, err := r.getStatus(prefix)
+	if err != nil {
+		return fmt.Errorf("failed to read replication status: %s", err)
+	}
+
+	// Compare the source with the mirror of the destination, which is only
+	// read again if the mirror does not match the status
+	if r.mirrorEnabled() {
+		m, err := r.mirrorFor(prefix, backend, status.LastReplicated)
+		if err != nil {
+			return fmt.Errorf("failed to load mirror: %s", err)
+		}
+		backend = &mirroredBackend{Backend: backend, mirror: m, scopes: routeDestinations(prefix)}
+	}
+
+	// Reads comparing the destination with the source may be answered by any
+	// destination server, while writes still go to the leader. The values the
+	// undo log restores are read from the leader, so a rollback does not
+	// restore an outdated value.
+	compare := backend
+	if maxStale := config.TimeDurationVal(r.config.Destination.MaxStale); maxStale > 0 {
+		compare = allowStale(backend, maxStale)
+	}
+	previousOf := compare
+	if r.undoEnabled() {
+		previousOf = backend
+	}
+
+	// A resync writes every key again
+	if r.resync {
+		status.LastReplicated = 0
+	}
+
+	// Get the prefix data
+	snap := r.snapshotFor(prefix)
+	if snap != nil {
+		// A snapshot may be older than what was last replicated, so every key
+		// is written
+		status.LastReplicated = 0
+	}
+	pairs, lastIndex, ok, err := r.sourcePairs(prefix)
+	if err != nil || !ok {
+		return err
+	}
+	lastIndex = r.chaosIndex(prefix, lastIndex)
+	if err := checkLimits(prefix, pairs); err != nil {
+		return err
+	}
+
+	// Merged prefixes are replicated together, from the last one to the
+	// first, so keys of later prefixes take precedence
+	sources := []*mergeSource{{prefix: prefix, pairs: pairs}}
+	if config.BoolVal(prefix.Merged) {
+		if sources, ok, err = r.mergeSources(prefix, pairs); err != nil || !ok {
+			return err
+		}
+	}
+
+	// Refuse to write into destinations owned by another prefix
+	for _, destination := range routeDestinations(prefix) {
+		if err := r.claimDestination(prefix, destination); err != nil {
+			return err
+		}
+	}
+
+	// The index of the source only goes backwards if it was reset, such as by
+	// a snapshot restore. Keys written since then may have indexes which were
+	// already replicated, so every key is written again.
+	if snap == nil && lastIndex < status.LastReplicated {
+		log.Printf("[WARN] (runner) index of %q went backwards from %d to %d, "+
+			"resyncing", prefix.Dependency, status.LastReplicated, lastIndex)
+		status.LastReplicated = 0
+	}
+
+	// Decide what to do if the entire source prefix has disappeared. Delta
+	// bundles only contain changed keys, so they are expected to be empty.
+	if countPairs(sources) == 0 && (snap == nil || !snap.delta) {
+		switch config.StringVal(prefix.OnSourceEmpty) {
+		case OnSourceEmptyKeep:
+			log.Printf("[WARN] (runner) source prefix %q is empty, keeping "+
+				"destination keys", prefix.Dependency)
+			return nil
+		case OnSourceEmptyFail:
+			return &emptySourceError{fmt.Sprintf("source prefix %q is empty", prefix.Dependency)}
+		}
+	}
+
+	// Keys which originated in the destination datacenter are not replicated
+	// back into it
+	var origin string
+	if r.loopDetectionEnabled() {
+		if origin, err = r.localOrigin(prefix); err != nil {
+			return fmt.Errorf("failed to resolve origin: %s", err)
+		}
+	}
+
+	// Only the keys of the allow list are replicated, if it is enabled
+	allowed, err := r.allowedKeys()
+	if err != nil {
+		return err
+	}
+
+	// Keys which fail on their own are recorded and retried in the next pass,
+	// while the remaining keys are still replicated
+	failures := make(map[string]string)
+
+	// Keys rejected by the key rules are reported, and keys which normalize to
+	// the same destination key are only written once, from the first source key
+	skipped := make(map[string]string)
+
+	// The keys of merged prefixes are compared with the destination instead
+	// of the index, as a key may have to be written again from another prefix
+	merged := config.BoolVal(prefix.Merged)
+
+	// Writes may be in flight at once, but are acknowledged in order, and the
+	// applied index is only committed once every earlier write succeeded
+	pipeline := r.writePipeline(backend, prefix)
+	defer pipeline.wait()
+	checkpointer := r.checkpointer(prefix, status, pairs, snap)
+
+	// Update keys to the most recent versions
+	updates, excludedKeys := 0, 0
+	undo := &UndoPass{}
+	usedKeys := make(map[string]struct{}, len(pairs))
+	tree := make(map[string][]byte, len(pairs))
+	for _, source := range sources {
+		prefix := source.prefix
+		used := make(map[string]struct{}, len(source.pairs))
+		owners := make(map[string]string)
+
+		// The origins and writers of the source keys are in the metadata
+		// written along with them
+		var sourceMeta map[string]*KeyMetadata
+		if origin != "" || r.stampFlagsEnabled() || flagMasks(prefix) {
+			if sourceMeta, err = r.sourceMetadata(prefix); err != nil {
+				return fmt.Errorf("failed to read the metadata of %s: %s", prefix.Dependency, err)
+			}
+		}
+
+		for i, pair := range source.pairs {
+			if err := ctx.Err(); err != nil {
+				return fmt.Errorf("pass cancelled: %s", err)
+			}
+			if err := checkpointer.checkpoint(i, pipeline, lastIndex, failures); err != nil {
+				return err
+			}
+
+			// Never replicate the keys of a replicator. They are not used, so
+			// copies of them at the destination are deleted.
+			if r.reserved(pair.Path) {
+				excludedKeys++
+				continue
+			}
+
+			// Keys deselected by their flags are local to the source, so copies
+			// of them at the destination are deleted too
+			appFlags := applicationFlags(pair.Flags, []byte(pair.Value), sourceMeta[pair.Path])
+			if !flagsMatch(prefix, appFlags) {
+				excludedKeys++
+				continue
+			}
+
+			// Keys missing from the allow list are not replicated, so copies of
+			// them at the destination are deleted when they are removed from it
+			if allowed != nil {
+				if _, ok := allowed[pair.Path]; !ok {
+					excludedKeys++
+					continue
+				}
+			}
+
+			key := r.destinationKey(prefix, pair)
+			used[key] = struct{}{}
+
+			// Ignore if the key came back from the destination datacenter
+			keyOrigin := originOf(prefix, []byte(pair.Value), sourceMeta[pair.Path])
+			if origin != "" && keyOrigin == origin {
+				excludedKeys++
+				continue
+			}
+
+			// Ignore if the key falls under an excluded prefix
+			if len(*excludes) > 0 {
+				excluded := false
+				for _, exclude := range *excludes {
+					if strings.HasPrefix(pair.Path, config.StringVal(exclude.Source)) {
+						excluded = true
+					}
+				}
+
+				if excluded {
+					excludedKeys++
+					continue
+				}
+			}
+
+			// Run the key through the middleware of the prefix
+			value := []byte(pair.Value)
+			if len(*prefix.Middlewares) > 0 {
+				newKey, newValue, skip, err := r.process(prefix, key, value, &Meta{
+					Source:      config.StringVal(prefix.Source),
+					Datacenter:  config.StringVal(prefix.Datacenter),
+					Destination: config.StringVal(prefix.Destination),
+					Path:        pair.Path,
+					Flags:       pair.Flags,
+					ModifyIndex: pair.ModifyIndex,
+				})
+				if err != nil {
+					return fmt.Errorf("failed to process %q: %s", pair.Path, err)
+				}
+
+				delete(used, key)
+				if skip {
+					excludedKeys++
+					continue
+				}
+				key, value = newKey, newValue
+				used[key] = struct{}{}
+			}
+
+			// Normalize and validate the destination key
+			if prefix.KeyRules.Enabled() {
+				newKey, err := applyKeyRules(prefix, key)
+				if err == nil {
+					if owner, ok := owners[newKey]; ok {
+						err = fmt.Errorf("normalizes to %q like %q", newKey, owner)
+					}
+				}
+
+				if _, ok := owners[key]; !ok {
+					delete(used, key)
+				}
+				if err != nil {
+					log.Printf("[WARN] (runner) key %q skipped: %s", pair.Path, err)
+					skipped[pair.Path] = err.Error()
+					continue
+				}
+				key = newKey
+				owners[key] = pair.Path
+				used[key] = struct{}{}
+			}
+
+			// Keys of the merged prefixes after this one take precedence
+			if _, ok := usedKeys[key]; ok {
+				excludedKeys++
+				continue
+			}
+
+			// Never overwrite the keys of the replicator
+			if r.reserved(key) {
+				excludedKeys++
+				continue
+			}
+
+			// Keys are skipped, leaving the destination key untouched, if the
+			// identity marker would overwrite their flags
+			if err := r.checkIdentityFlags(pair.Flags, []byte(pair.Value), sourceMeta[pair.Path]); err != nil {
+				log.Printf("[WARN] (runner) key %q skipped: %s", pair.Path, err)
+				skipped[pair.Path] = err.Error()
+				continue
+			}
+
+			// Invalid values are skipped, leaving the destination key untouched
+			if err := r.validateValue(prefix, value); err != nil {
+				log.Printf("[WARN] (runner) key %q skipped: %s", pair.Path, err)
+				skipped[pair.Path] = err.Error()
+				continue
+			}
+			tree[key] = value
+
+			// Ignore if the modify index is old, unless the key failed before
+			if _, retry := status.Failures[key]; pair.ModifyIndex <= status.LastReplicated && !retry && !merged {
+				continue
+			}
+
+			flags := pair.Flags
+			if !lockFlags(flags) {
+				flags = r.stampIdentity(flags)
+			}
+
+			// Check if lock
+			if pair.Flags == api.LockFlagValue {
+				log.Printf("[WARN] (runner) lock in use at %q, but sessions cannot be "+
+					"replicated across datacenters", key)
+			}
+
+			// Check if semaphore
+			if pair.Flags == api.SemaphoreFlagValue {
+				log.Printf("[WARN] (runner) semaphore in use at %q, but sessions cannot "+
+					"be replicated across datacenters", key)
+			}
+
+			// Check if session attached
+			if pair.Session != "" {
+				log.Printf("[WARN] (runner) %q has attached session, but sessions "+
+					"cannot be replicated across datacenters", key)
+			}
+
+			change := &Change{
+				Source:     config.StringVal(prefix.Source),
+				Datacenter: config.StringVal(prefix.Datacenter),
+				Key:        key,
+				Op:         ChangePut,
+				NewHash:    valueHash(value),
+				Index:      pair.ModifyIndex,
+			}
+
+			// Read the destination key for sinks, diffs and the undo log, and to
+			// skip identical writes, which is free with a mirror
+			verify := config.BoolVal(r.config.VerifyBeforeWrite) || merged || r.mirrorEnabled()
+			var previous *api.KVPair
+			if r.oldHashes() || verify || r.undoEnabled() {
+				current, err := previousOf.Get(key)
+				if err != nil {
+					return fmt.Errorf("failed to read %q: %s", key, err)
+				}
+				previous = current
+				if current != nil {
+					change.OldHash = valueHash(current.Value)
+
+					if verify && current.Flags == flags && bytes.Equal(current.Value, value) {
+						continue
+					}
+				}
+			}
+
+			meta := &KeyMetadata{
+				SourceDatacenter:  config.StringVal(prefix.Datacenter),
+				SourceKey:         pair.Path,
+				SourceModifyIndex: pair.ModifyIndex,
+				ValueHash:         change.NewHash,
+			}
+			if origin != "" {
+				meta.Origin = keyOrigin
+			}
+			if r.writerRecorded(prefix) {
+				meta.Writer = r.identityName()
+			}
+
+			if err := pipeline.put(&api.KVPair{
+				Key:   key,
+				Flags: flags,
+				Value: value,
+			}, pair.ModifyIndex, func(err error) error {
+				if err != nil {
+					if !keyError(err) {
+						return fmt.Errorf("failed to write %q: %s", key, err)
+					}
+					log.Printf("[WARN] (runner) failed to write %q, continuing: %s", key, err)
+					failures[key] = err.Error()
+					delete(tree, key)
+					return nil
+				}
+				event.Changes = append(event.Changes, change)
+				updates++
+				if r.undoEnabled() {
+					undo.add(key, previous)
+				}
+
+				if r.metadataWritten(prefix) {
+					if err := r.writeMetadata(backend, key, meta); err != nil {
+						if !keyError(err) {
+							return fmt.Errorf("failed to write metadata of %q: %s", key, err)
+						}
+						log.Printf("[WARN] (runner) failed to write metadata of %q, continuing: %s", key, err)
+					}
+				}
+				return nil
+			}); err != nil {
+				return err
+			}
+		}
+
+		// The keys of the prefix are not deleted, and take precedence over the
+		// merged prefixes before it in the configuration
+		for key := range used {
+			usedKeys[key] = struct{}{}
+		}
+	}
+	if err := pipeline.flush(); err != nil {
+		return err
+	}
+
+	// Handle deletes
+	deletes, kept := 0, 0
+	var localKeys []string
+	if snap != nil && snap.delta {
+		localKeys, err = r.deltaDeletes(prefix, snap)
+	} else {
+		localKeys, err = r.destinationKeys(compare, prefix)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to list keys: %s", err)
+	}
+
+	// Folders are kept for as long as they contain a key
+	if config.BoolVal(r.config.CreateFolders) {
+		existing := localKeys
+		if snap != nil && snap.delta {
+			if existing, err = r.destinationKeys(compare, prefix); err != nil {
+				return fmt.Errorf("failed to list keys: %s", err)
+			}
+		}
+		if err := r.createFolders(backend, prefix, existing, usedKeys, tree, failures); err != nil {
+			return err
+		}
+	}
+
+	// Keys which are neither replicated nor excluded are deleted
+	var deleting []string
+	for _, key := range localKeys {
+		if err := ctx.Err(); err != nil {
+			return fmt.Errorf("pass cancelled: %s", err)
+		}
+		if r.reserved(key) {
+			continue
+		}
+		if _, ok := usedKeys[key]; ok {
+			continue
+		}
+
+		// Tombstones are kept until their key is written again or they expire
+		if r.isTombstone(key) {
+			if err := r.pruneTombstone(backend, key, usedKeys); err != nil {
+				if !keyError(err) {
+					return fmt.Errorf("failed to prune tombstone %q: %s", key, err)
+				}
+				log.Printf("[WARN] (runner) failed to prune tombstone %q, continuing: %s", key, err)
+			}
+			continue
+		}
+
+		// Ignore if the key falls under an excluded prefix
+		excluded := false
+		if len(*excludes) > 0 {
+			sourceKey := strings.Replace(key, config.StringVal(prefix.Destination), config.StringVal(prefix.Source), -1)
+			for _, exclude := range *excludes {
+				if strings.HasPrefix(sourceKey, config.StringVal(exclude.Source)) {
+					excluded = true
+				}
+			}
+		}
+		if excluded {
+			kept++
+		} else {
+			deleting = append(deleting, key)
+		}
+	}
+
+	// The keys are backed up before they are deleted, and nothing is deleted
+	// if they cannot be
+	if r.backupEnabled() && len(deleting) > 0 {
+		if err := r.backup(backend, prefix, deleting, time.Now()); err != nil {
+			return fmt.Errorf("failed to back up %s: %s", prefix.Dependency, err)
+		}
+	}
+
+	for _, key := range deleting {
+		if err := ctx.Err(); err != nil {
+			return fmt.Errorf("pass cancelled: %s", err)
+		}
+
+		// Keys written by hand are kept
+		if config.BoolVal(prefix.DeleteOwnedOnly) {
+			current, err := compare.Get(key)
+			if err != nil {
+				return fmt.Errorf("failed to read %q: %s", key, err)
+			}
+			if ok, err := r.owned(backend, current); err != nil {
+				return fmt.Errorf("failed to read metadata of %q: %s", key, err)
+			} else if !ok {
+				kept++
+				continue
+			}
+		}
+
+		change := &Change{
+			Source:     config.StringVal(prefix.Source),
+			Datacenter: config.StringVal(prefix.Datacenter),
+			Key:        key,
+			Op:         ChangeDelete,
+			Index:      lastIndex,
+		}
+		var previous *api.KVPair
+		if r.oldHashes() || r.undoEnabled() {
+			if previous, err = previousOf.Get(key); err != nil {
+				return fmt.Errorf("failed to read %q: %s", key, err)
+			}
+			if previous != nil && r.oldHashes() {
+				change.OldHash = valueHash(previous.Value)
+			}
+		}
+
+		if err := backend.Delete(key); err != nil {
+			if !keyError(err) {
+				return fmt.Errorf("failed to delete %q: %s", key, err)
+			}
+			log.Printf("[WARN] (runner) failed to delete %q, continuing: %s", key, err)
+			failures[key] = err.Error()
+			continue
+		}
+		event.Changes = append(event.Changes, change)
+		deletes++
+		if r.undoEnabled() {
+			undo.add(key, previous)
+		}
+
+		if r.metadataWritten(prefix) {
+			if err := r.deleteMetadata(backend, key); err != nil {
+				if !keyError(err) {
+					return fmt.Errorf("failed to delete metadata of %q: %s", key, err)
+				}
+				log.Printf("[WARN] (runner) failed to delete metadata of %q, continuing: %s", key, err)
+			}
+		}
+
+		if r.tombstonesEnabled() {
+			if err := r.writeTombstone(backend, key); err != nil {
+				if !keyError(err) {
+					return fmt.Errorf("failed to write tombstone of %q: %s", key, err)
+				}
+				log.Printf("[WARN] (runner) failed to write tombstone of %q, continuing: %s", key, err)
+			}
+		}
+	}
+
+	// A token which may no longer write any key is denied like an unknown one,
+	// instead of retrying every write
+	if r.deniedEnabled() {
+		if err := passDenied(failures, status.Failures, updates+deletes); err != nil {
+			return err
+		}
+	}
+
+	// Keep what a rollback of the pass needs
+	if r.undoEnabled() && len(undo.Entries) > 0 {
+		undo.Index, undo.Time = lastIndex, time.Now().UTC()
+		if err := r.saveUndo(prefix, undo); err != nil {
+			return fmt.Errorf("failed to write undo log: %s", err)
+		}
+	}
+
+	// Update our status
+	status.LastReplicated = lastIndex
+	status.Source = config.StringVal(prefix.Source)
+	status.Destination = config.StringVal(prefix.Destination)
+	status.Failures, status.Skipped = nil, nil
+	if len(failures) > 0 {
+		status.Failures = failures
+	}
+	if len(skipped) > 0 {
+		status.Skipped = skipped
+	}
+	if config.TimeDurationVal(prefix.TTL) > 0 {
+		status.LastRefreshed = time.Now().UTC()
+	}
+	if err := r.commitMirror(prefix, lastIndex); err != nil {
+		return fmt.Errorf("failed to commit mirror: %s", err)
+	}
+	r.statusLock.Lock()
+	err = r.setStatus(prefix, status)
+	r.statusLock.Unlock()
+	if err != nil {
+		return fmt.Errorf("failed to checkpoint status: %s", err)
+	}
+
+	// A delta only describes part of the tree, so the manifest is computed
+	// from the destination
+	if snap != nil && snap.delta {
+		if tree, err = r.destinationTree(backend, prefix); err != nil {
+			return fmt.Errorf("failed to read destination: %s", err)
+		}
+	}
+
+	// Record what the destination should now contain
+	if err := r.setManifest(prefix, &Manifest{
+		Source:      status.Source,
+		Destination: status.Destination,
+		KeyCount:    len(tree),
+		TreeHash:    TreeHash(tree),
+		SourceIndex: lastIndex,
+		Timestamp:   time.Now().UTC(),
+	}); err != nil {
+		return fmt.Errorf("failed to write manifest: %s", err)
+	}
+
+	// The initial sync is complete once a pass replicated every key
+	if len(failures) == 0 {
+		if err := r.markSynced(prefix, lastIndex, len(tree)); err != nil {
+			return fmt.Errorf("failed to write initial sync barrier: %s", err)
+		}
+	}
+
+	event.Scanned = countPairs(sources)
+	event.Updates, event.Deletes, event.Index = updates, deletes, lastIndex
+	event.Excluded, event.Kept = excludedKeys, kept
+	event.Failures, event.Skipped = status.Failures, status.Skipped
+	if len(failures) > 0 {
+		log.Printf("[WARN] (runner) %d keys of %q failed and will be retried",
+			len(failures), prefix.Dependency)
+	}
+	if len(skipped) > 0 {
+		log.Printf("[WARN] (runner) %d keys of %q were skipped by the key rules",
+			len(skipped), prefix.Dependency)
+	}
+
+	// We are done!
+	return nil
+}
+
+// sourcePairs returns the current pairs of the source of the prefix and their
+// index, or false if the watch of the prefix has not returned data yet.
+func (r *Runner) sourcePairs(prefix *PrefixConfig) ([]*dep.KeyPair, uint64, bool, error) {
+	if snap := r.snapshotFor(prefix); snap != nil {
+		return snap.list(config.StringVal(prefix.Source)), snap.index, true, nil
+	}
+
+	if !r.watched() {
+		pairs, lastIndex, err := r.sourceFor(prefix).List(config.StringVal(prefix.Source),
+			config.StringVal(prefix.Datacenter))
+		if err != nil {
+			return nil, 0, false, fmt.Errorf("failed to list %q: %s", prefix.Dependency, err)
+		}
+		r.recorder.record(prefix, pairs, lastIndex)
+		return pairs, lastIndex, true, nil
+	}
+
+	view, ok := r.get(prefix)
+	if !ok {
+		log.Printf("[INFO] (runner) no data for %q", prefix.Dependency)
+		return nil, 0, false, nil
+	}
+
+	// Get the data from the view
+	data, lastIndex := view.DataAndLastIndex()
+	pairs, ok := data.([]*dep.KeyPair)
+	if !ok {
+		return nil, 0, false, fmt.Errorf("could not convert watch data")
+	}
+
+	// A coalesced watch also holds the keys of other prefixes
+	if view.Dependency().String() != prefix.Dependency.String() {
+		pairs = splitPairs(pairs, config.StringVal(prefix.Source))
+	}
+	r.recorder.record(prefix, pairs, lastIndex)
+	return pairs, lastIndex, true, nil
+}
+
+// backend returns the destination backend for the given prefix.
+func (r *Runner) backend(prefix *PrefixConfig) Backend {
+	backend := r.backends[config.StringVal(prefix.Backend)]
+	if p := config.StringVal(prefix.DestinationPartition); p != "" {
+		if b, ok := backend.(*consulBackend); ok {
+			backend = b.inPartition(p)
+		}
+	}
+	if dc := config.StringVal(prefix.DestinationDatacenter); dc != "" {
+		if b, ok := backend.(*consulBackend); ok {
+			backend = b.inDatacenter(dc)
+		}
+	}
+	if len(*prefix.Routes) > 0 {
+		return newRoutedBackend(backend, prefix)
+	}
+	return backend
+}
+
+// getStatus is used to read the last replication status.
+func (r *Runner) getStatus(prefix *PrefixConfig) (*Status, error) {
+	pair, err := r.statusBackend(prefix).Get(r.statusPath(prefix))
+	if err != nil {
+		return nil, err
+	}
+
+	status := &Status{}
+	if pair != nil {
+		if err := json.Unmarshal(pair.Value, &status); err != nil {
+			return nil, err
+		}
+	}
+	return status, nil
+}
+
+// setStatus is used to update the last replication status.
+func (r *Runner) setStatus(prefix *PrefixConfig, status *Status) error {
+	status.LastUpdated = time.Now().UTC()
+	status.ConfigChecksum = r.checksum
+
+	// Encode the JSON as pretty so operators can easily view it in the Consul UI.
+	enc, err := json.MarshalIndent(status, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	// Put the key to the status backend.
+	return r.statusBackend(prefix).Put(&api.KVPair{
+		Key:   r.statusPath(prefix),
+		Value: enc,
+	})
+}
+
+func (r *Runner) statusPath(prefix *PrefixConfig) string {
+	plain := fmt.Sprintf("%s-%s", config.StringVal(prefix.Source), config.StringVal(prefix.Destination))
+
+	// Prefixes in admin partitions have their own status, while the status of
+	// other prefixes keeps its path
+	source, destination := config.StringVal(prefix.Partition), config.StringVal(prefix.DestinationPartition)
+	if source != "" || destination != "" {
+		plain = fmt.Sprintf("%s-%s-%s", plain, partitionName(prefix.Partition),
+			partitionName(prefix.DestinationPartition))
+	}
+	hash := md5.Sum([]byte(plain))
+	enc := hex.EncodeToString(hash[:])
+	return strings.TrimRight(config.StringVal(r.config.StatusDir), "/") + "/" + enc
+}
+
+// storePid is used to write out a PID file to disk.
+func (r *Runner) storePid() error {
+	return storePid(config.StringVal(r.config.PidFile))
+}
+
+// deletePid is used to remove the PID on exit.
+func (r *Runner) deletePid() error {
+	return deletePid(config.StringVal(r.config.PidFile))
+}
+
+// storePid writes the PID of the process to the given path, if any.
+func storePid(path string) error {
+	if path == "" {
+		return nil
+	}
+
+	log.Printf("[INFO] creating pid file at %q", path)
+
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0666)
+	if err != nil {
+		return fmt.Errorf("runner: could not open pid file: %s", err)
+	}
+	defer f.Close()
+
+	pid := os.Getpid()
+	_, err = f.WriteString(fmt.Sprintf("%d", pid))
+	if err != nil {
+		return fmt.Errorf("runner: could not write to pid file: %s", err)
+	}
+	return nil
+}
+
+// deletePid removes the PID file at the given path, if any.
+func deletePid(path string) error {
+	if path == "" {
+		return nil
+	}
+
+	log.Printf("[DEBUG] removing pid file at %q", path)
+
+	stat, err := os.Stat(path)
+	if err != nil {
+		return fmt.Errorf("runner: could not remove pid file: %s", err)
+	}
+	if stat.IsDir() {
+		return fmt.Errorf("runner: specified pid file path is directory")
+	}
+
+	err = os.Remove(path)
+	if err != nil {
+		return fmt.Errorf("runner: could not remove pid file: %s", err)
+	}
+	return nil
+}
+
+// clientSet holds the Consul client of a cluster, which makes its requests
+// with an HTTP client of its own, so its transport can be wrapped to tune or
+// observe them.
+type clientSet struct {
+	consul *api.Client
+
+	// httpClient is the HTTP client of the Consul client.
+	httpClient *http.Client
+
+	// transport is the transport under the wrappers of the HTTP client.
+	transport *http.Transport
+}
+
+// Consul returns the Consul client.
+func (c *clientSet) Consul() *api.Client {
+	return c.consul
+}
+
+// Stop closes the idle connections of the client set.
+func (c *clientSet) Stop() {
+	if c == nil {
+		return
+	}
+	c.transport.CloseIdleConnections()
+}
+
+// newClientSet creates a new client set from the given config, whose transport
+// is tuned by the given HTTP config, if any.
+func newClientSet(c *config.ConsulConfig, h *HTTPClientConfig) (*clientSet, error) {
+	transport, err := newTransport(c, h)
+	if err != nil {
+		return nil, fmt.Errorf("runner: %s", err)
+	}
+	hc := &http.Client{Transport: transport}
+
+	ac := &api.Config{
+		Address:    config.StringVal(c.Address),
+		Token:      config.StringVal(c.Token),
+		HttpClient: hc,
+	}
+	if path, ok := unixSocket(ac.Address); ok {
+		// The Consul client would replace the HTTP client of a unix socket
+		// address, and the transport already dials the socket
+		ac.Address = path
+	}
+	if config.BoolVal(c.Auth.Enabled) {
+		ac.HttpAuth = &api.HttpBasicAuth{
+			Username: config.StringVal(c.Auth.Username),
+			Password: config.StringVal(c.Auth.Password),
+		}
+	}
+	if config.BoolVal(c.SSL.Enabled) {
+		ac.Scheme = "https"
+	}
+	client, err := api.NewClient(ac)
+	if err != nil {
+		return nil, fmt.Errorf("runner: %s", err)
+	}
+	return &clientSet{consul: client, httpClient: hc, transport: transport}, nil
+}
+
+// newServerClientSet creates a client set which talks to the first of the
+// servers which has a leader, bypassing the local agent. Without servers, it
+// talks to the configured address.
+func newServerClientSet(c *config.ConsulConfig, h *HTTPClientConfig, servers []string) (*clientSet, error) {
+	if len(servers) == 0 {
+		return newClientSet(c, h)
+	}
+
+	for _, server := range servers {
+		sc := c.Copy()
+		sc.Address = config.String(server)
+		clients, err := newClientSet(sc, h)
+		if err != nil {
+			return nil, err
+		}
+
+		leader, err := clients.Consul().Status().Leader()
+		if err != nil || leader == "" {
+			log.Printf("[WARN] (runner) server %q is unavailable (leader: %q): %v",
+				server, leader, err)
+			continue
+		}
+		log.Printf("[INFO] (runner) talking to server %q", server)
+		return clients, nil
+	}
+	return nil, fmt.Errorf("runner: none of the servers %q is available", servers)
+}
+
+// newWatcher creates a new watcher.
+func newWatcher(c *Config, clients *dep.ClientSet, once bool) (*watch.Watcher, error) {
+	log.Printf("[INFO] (runner) creating watcher")
+
+	w, err := watch.NewWatcher(&watch.NewWatcherInput{
+		Clients:          clients,
+		MaxStale:         config.TimeDurationVal(c.MaxStale),
+		Once:             once,
+		RetryFuncConsul:  watch.RetryFunc(c.Consul.Retry.RetryFunc()),
+		RetryFuncDefault: nil,
+	})
+	if err != nil {
+		return nil, errors.Wrap(err, "runner")
+	}
+	return w, nil
+}
@@ -0,0 +1,175 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: MPL-2.0
+
+package replicate
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/hashicorp/consul-template/config"
+	dep "github.com/hashicorp/consul-template/dependency"
+)
+
+func TestPriorityClasses(t *testing.T) {
+	cases := []struct {
+		name       string
+		priorities []int
+		exp        [][]string
+	}{
+		{
+			"empty",
+			nil,
+			nil,
+		},
+		{
+			"default",
+			[]int{0, 0},
+			[][]string{{"p0", "p1"}},
+		},
+		{
+			"ordered",
+			[]int{0, 10, -1, 10},
+			[][]string{{"p1", "p3"}, {"p0"}, {"p2"}},
+		},
+	}
+
+	for i, tc := range cases {
+		t.Run(fmt.Sprintf("%d_%s", i, tc.name), func(t *testing.T) {
+			var prefixes []*PrefixConfig
+			for j, priority := range tc.priorities {
+				prefixes = append(prefixes, &PrefixConfig{
+					Source:   config.String(fmt.Sprintf("p%d", j)),
+					Priority: config.Int(priority),
+				})
+			}
+
+			var act [][]string
+			for _, class := range priorityClasses(prefixes) {
+				var sources []string
+				for _, prefix := range class {
+					sources = append(sources, config.StringVal(prefix.Source))
+				}
+				act = append(act, sources)
+			}
+
+			if !reflect.DeepEqual(tc.exp, act) {
+				t.Errorf("\nexp: %#v\nact: %#v", tc.exp, act)
+			}
+		})
+	}
+}
+
+func TestNewServerClientSet(t *testing.T) {
+	down := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		w.WriteHeader(http.StatusInternalServerError)
+	}))
+	defer down.Close()
+
+	noLeader := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		fmt.Fprint(w, `""`)
+	}))
+	defer noLeader.Close()
+
+	up := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		fmt.Fprint(w, `"10.0.0.1:8300"`)
+	}))
+	defer up.Close()
+
+	address := func(s *httptest.Server) string {
+		return strings.TrimPrefix(s.URL, "http://")
+	}
+
+	c := config.DefaultConsulConfig()
+	c.Finalize()
+
+	clients, err := newServerClientSet(c, nil, []string{address(down), address(noLeader), address(up)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if leader, err := clients.Consul().Status().Leader(); err != nil || leader != "10.0.0.1:8300" {
+		t.Errorf("expected the available server, got %q (%v)", leader, err)
+	}
+
+	if _, err := newServerClientSet(c, nil, []string{address(down)}); err == nil {
+		t.Errorf("expected error")
+	}
+}
+
+func TestRunner_batchDelay(t *testing.T) {
+	cases := []struct {
+		name string
+		max  time.Duration
+		exp  time.Duration
+	}{
+		{
+			"wait_max",
+			0,
+			20 * time.Second,
+		},
+		{
+			"capped",
+			5 * time.Second,
+			5 * time.Second,
+		},
+		{
+			"above_wait_max",
+			time.Minute,
+			20 * time.Second,
+		},
+	}
+
+	for i, tc := range cases {
+		t.Run(fmt.Sprintf("%d_%s", i, tc.name), func(t *testing.T) {
+			r := &Runner{config: &Config{
+				MaxBatchDelay: config.TimeDuration(tc.max),
+				Wait: &config.WaitConfig{
+					Min: config.TimeDuration(5 * time.Second),
+					Max: config.TimeDuration(20 * time.Second),
+				},
+			}}
+			if act := r.batchDelay(); act != tc.exp {
+				t.Errorf("\nexp: %#v\nact: %#v", tc.exp, act)
+			}
+		})
+	}
+}
+
+func TestRunner_coalesced(t *testing.T) {
+	foo, err := ParsePrefixConfig("foo@dc1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	bar, err := ParsePrefixConfig("bar@dc1")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	r := &Runner{
+		updates: map[string]int{foo.Dependency.String(): 3},
+		watches: map[string]*dep.KVListQuery{
+			foo.Dependency.String(): foo.Dependency,
+			bar.Dependency.String(): bar.Dependency,
+		},
+	}
+
+	if n := r.takeUpdates(); n != 3 {
+		t.Errorf("expected 3 updates, got %d", n)
+	}
+	if n := r.coalesced(foo); n != 3 {
+		t.Errorf("expected 3 updates of foo, got %d", n)
+	}
+	if n := r.coalesced(bar); n != 0 {
+		t.Errorf("expected no updates of bar, got %d", n)
+	}
+
+	// The next pass starts from scratch
+	if n := r.takeUpdates(); n != 0 {
+		t.Errorf("expected no updates, got %d", n)
+	}
+}
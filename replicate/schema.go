@@ -0,0 +1,322 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: MPL-2.0
+
+package replicate
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"math"
+	"reflect"
+	"regexp"
+	"sort"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/hashicorp/consul-template/config"
+	"github.com/pkg/errors"
+)
+
+// jsonSchema is a compiled JSON Schema. Only the validation keywords most
+// configuration schemas rely on are supported: type, enum, const, properties,
+// required, additionalProperties, items, minItems, maxItems, minLength,
+// maxLength, pattern, minimum and maximum. Other keywords are ignored.
+type jsonSchema struct {
+	// never is set for the "false" schema, which matches nothing.
+	never bool
+
+	types    []string
+	enum     []interface{}
+	constant interface{}
+	hasConst bool
+
+	properties           map[string]*jsonSchema
+	required             []string
+	additionalProperties *jsonSchema
+
+	items              *jsonSchema
+	minItems, maxItems *int
+
+	minLength, maxLength *int
+	pattern              *regexp.Regexp
+
+	minimum, maximum *float64
+}
+
+// loadJSONSchema reads and compiles the JSON Schema file at the path.
+func loadJSONSchema(path string) (*jsonSchema, error) {
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, errors.Wrap(err, "json schema")
+	}
+
+	var v interface{}
+	if err := json.Unmarshal(b, &v); err != nil {
+		return nil, errors.Wrapf(err, "json schema: parsing %q", path)
+	}
+
+	s, err := compileJSONSchema(v)
+	if err != nil {
+		return nil, errors.Wrapf(err, "json schema: %q", path)
+	}
+	return s, nil
+}
+
+// compileJSONSchema compiles a decoded JSON Schema.
+func compileJSONSchema(v interface{}) (*jsonSchema, error) {
+	m, ok := v.(map[string]interface{})
+	if !ok {
+		if b, ok := v.(bool); ok {
+			return &jsonSchema{never: !b}, nil
+		}
+		return nil, fmt.Errorf("schema must be an object or a boolean")
+	}
+
+	s := &jsonSchema{}
+	switch t := m["type"].(type) {
+	case nil:
+	case string:
+		s.types = []string{t}
+	case []interface{}:
+		for _, v := range t {
+			name, ok := v.(string)
+			if !ok {
+				return nil, fmt.Errorf("type must be a string or a list of strings")
+			}
+			s.types = append(s.types, name)
+		}
+	default:
+		return nil, fmt.Errorf("type must be a string or a list of strings")
+	}
+
+	if enum, ok := m["enum"].([]interface{}); ok {
+		s.enum = enum
+	}
+	s.constant, s.hasConst = m["const"]
+
+	if props, ok := m["properties"].(map[string]interface{}); ok {
+		s.properties = make(map[string]*jsonSchema, len(props))
+		for name, v := range props {
+			p, err := compileJSONSchema(v)
+			if err != nil {
+				return nil, errors.Wrapf(err, "properties: %q", name)
+			}
+			s.properties[name] = p
+		}
+	}
+	if required, ok := m["required"].([]interface{}); ok {
+		for _, v := range required {
+			if name, ok := v.(string); ok {
+				s.required = append(s.required, name)
+			}
+		}
+	}
+	if v, ok := m["additionalProperties"]; ok {
+		p, err := compileJSONSchema(v)
+		if err != nil {
+			return nil, errors.Wrap(err, "additionalProperties")
+		}
+		s.additionalProperties = p
+	}
+
+	if v, ok := m["items"]; ok {
+		p, err := compileJSONSchema(v)
+		if err != nil {
+			return nil, errors.Wrap(err, "items")
+		}
+		s.items = p
+	}
+	s.minItems, s.maxItems = schemaInt(m["minItems"]), schemaInt(m["maxItems"])
+
+	s.minLength, s.maxLength = schemaInt(m["minLength"]), schemaInt(m["maxLength"])
+	if pattern, ok := m["pattern"].(string); ok {
+		re, err := regexp.Compile(pattern)
+		if err != nil {
+			return nil, errors.Wrap(err, "pattern")
+		}
+		s.pattern = re
+	}
+
+	if min, ok := m["minimum"].(float64); ok {
+		s.minimum = &min
+	}
+	if max, ok := m["maximum"].(float64); ok {
+		s.maximum = &max
+	}
+	return s, nil
+}
+
+// schemaInt returns the keyword as an integer, or nil if it is not a number.
+func schemaInt(v interface{}) *int {
+	f, ok := v.(float64)
+	if !ok {
+		return nil
+	}
+	i := int(f)
+	return &i
+}
+
+// validate returns an error describing the first violation of the schema by
+// the decoded value, at the given JSON pointer.
+func (s *jsonSchema) validate(v interface{}, path string) error {
+	if s.never {
+		return fmt.Errorf("%s: not allowed", schemaPath(path))
+	}
+
+	if len(s.types) > 0 {
+		matched := false
+		for _, t := range s.types {
+			if schemaType(v, t) {
+				matched = true
+				break
+			}
+		}
+		if !matched {
+			return fmt.Errorf("%s: expected %s", schemaPath(path), strings.Join(s.types, " or "))
+		}
+	}
+
+	if s.enum != nil {
+		matched := false
+		for _, e := range s.enum {
+			if reflect.DeepEqual(v, e) {
+				matched = true
+				break
+			}
+		}
+		if !matched {
+			return fmt.Errorf("%s: not one of the allowed values", schemaPath(path))
+		}
+	}
+	if s.hasConst && !reflect.DeepEqual(v, s.constant) {
+		return fmt.Errorf("%s: not the allowed value", schemaPath(path))
+	}
+
+	switch v := v.(type) {
+	case map[string]interface{}:
+		for _, name := range s.required {
+			if _, ok := v[name]; !ok {
+				return fmt.Errorf("%s: missing property %q", schemaPath(path), name)
+			}
+		}
+
+		// Properties are checked in order, so the reported error is stable
+		names := make([]string, 0, len(v))
+		for name := range v {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		for _, name := range names {
+			p, ok := s.properties[name]
+			if !ok {
+				p = s.additionalProperties
+			}
+			if p == nil {
+				continue
+			}
+			if err := p.validate(v[name], path+"/"+name); err != nil {
+				return err
+			}
+		}
+	case []interface{}:
+		if s.minItems != nil && len(v) < *s.minItems {
+			return fmt.Errorf("%s: fewer than %d items", schemaPath(path), *s.minItems)
+		}
+		if s.maxItems != nil && len(v) > *s.maxItems {
+			return fmt.Errorf("%s: more than %d items", schemaPath(path), *s.maxItems)
+		}
+		if s.items != nil {
+			for i, item := range v {
+				if err := s.items.validate(item, fmt.Sprintf("%s/%d", path, i)); err != nil {
+					return err
+				}
+			}
+		}
+	case string:
+		n := utf8.RuneCountInString(v)
+		if s.minLength != nil && n < *s.minLength {
+			return fmt.Errorf("%s: shorter than %d characters", schemaPath(path), *s.minLength)
+		}
+		if s.maxLength != nil && n > *s.maxLength {
+			return fmt.Errorf("%s: longer than %d characters", schemaPath(path), *s.maxLength)
+		}
+		if s.pattern != nil && !s.pattern.MatchString(v) {
+			return fmt.Errorf("%s: does not match %q", schemaPath(path), s.pattern)
+		}
+	case float64:
+		if s.minimum != nil && v < *s.minimum {
+			return fmt.Errorf("%s: less than %v", schemaPath(path), *s.minimum)
+		}
+		if s.maximum != nil && v > *s.maximum {
+			return fmt.Errorf("%s: greater than %v", schemaPath(path), *s.maximum)
+		}
+	}
+	return nil
+}
+
+// schemaType returns true if the decoded value is of the JSON Schema type.
+func schemaType(v interface{}, t string) bool {
+	switch v := v.(type) {
+	case nil:
+		return t == "null"
+	case bool:
+		return t == "boolean"
+	case float64:
+		return t == "number" || (t == "integer" && v == math.Trunc(v))
+	case string:
+		return t == "string"
+	case []interface{}:
+		return t == "array"
+	case map[string]interface{}:
+		return t == "object"
+	}
+	return false
+}
+
+// schemaPath returns the JSON pointer to report, which is "/" for the root.
+func schemaPath(path string) string {
+	if path == "" {
+		return "/"
+	}
+	return path
+}
+
+// initSchemas loads the JSON Schema files referenced by the configuration.
+func (r *Runner) initSchemas() error {
+	r.schemas = make(map[string]*jsonSchema)
+	for _, prefix := range *r.config.Prefixes {
+		path := config.StringVal(prefix.Validate.JSONSchema)
+		if _, ok := r.schemas[path]; ok || path == "" {
+			continue
+		}
+
+		s, err := loadJSONSchema(path)
+		if err != nil {
+			return errors.Wrapf(err, "prefix %q", config.StringVal(prefix.Source))
+		}
+		r.schemas[path] = s
+	}
+	return nil
+}
+
+// validateValue returns an error if the value does not pass the validation of
+// the prefix.
+func (r *Runner) validateValue(prefix *PrefixConfig, value []byte) error {
+	c := prefix.Validate
+	if !c.Enabled() {
+		return nil
+	}
+
+	var v interface{}
+	if err := json.Unmarshal(value, &v); err != nil {
+		return fmt.Errorf("invalid JSON: %s", err)
+	}
+
+	if path := config.StringVal(c.JSONSchema); path != "" {
+		if err := r.schemas[path].validate(v, ""); err != nil {
+			return fmt.Errorf("does not match %q: %s", path, err)
+		}
+	}
+	return nil
+}
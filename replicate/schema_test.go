@@ -0,0 +1,107 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: MPL-2.0
+
+package replicate
+
+import (
+	"encoding/json"
+	"fmt"
+	"testing"
+)
+
+func TestJSONSchema_validate(t *testing.T) {
+	schema := `{
+		"type": "object",
+		"required": ["name"],
+		"properties": {
+			"name": {"type": "string", "minLength": 1, "pattern": "^[a-z]+$"},
+			"port": {"type": "integer", "minimum": 1, "maximum": 65535},
+			"mode": {"enum": ["active", "standby"]},
+			"tags": {"type": "array", "items": {"type": "string"}, "maxItems": 2}
+		},
+		"additionalProperties": false
+	}`
+
+	cases := []struct {
+		name  string
+		value string
+		err   string
+	}{
+		{
+			"valid",
+			`{"name": "web", "port": 80, "mode": "active", "tags": ["a"]}`,
+			"",
+		},
+		{
+			"wrong_type",
+			`[]`,
+			"/: expected object",
+		},
+		{
+			"missing_property",
+			`{"port": 80}`,
+			`/: missing property "name"`,
+		},
+		{
+			"pattern",
+			`{"name": "Web"}`,
+			`/name: does not match "^[a-z]+$"`,
+		},
+		{
+			"integer",
+			`{"name": "web", "port": 80.5}`,
+			"/port: expected integer",
+		},
+		{
+			"maximum",
+			`{"name": "web", "port": 70000}`,
+			"/port: greater than 65535",
+		},
+		{
+			"enum",
+			`{"name": "web", "mode": "passive"}`,
+			"/mode: not one of the allowed values",
+		},
+		{
+			"items",
+			`{"name": "web", "tags": ["a", 1]}`,
+			"/tags/1: expected string",
+		},
+		{
+			"max_items",
+			`{"name": "web", "tags": ["a", "b", "c"]}`,
+			"/tags: more than 2 items",
+		},
+		{
+			"additional_property",
+			`{"name": "web", "other": true}`,
+			"/other: not allowed",
+		},
+	}
+
+	var v interface{}
+	if err := json.Unmarshal([]byte(schema), &v); err != nil {
+		t.Fatal(err)
+	}
+	s, err := compileJSONSchema(v)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for i, tc := range cases {
+		t.Run(fmt.Sprintf("%d_%s", i, tc.name), func(t *testing.T) {
+			var value interface{}
+			if err := json.Unmarshal([]byte(tc.value), &value); err != nil {
+				t.Fatal(err)
+			}
+
+			var act string
+			if err := s.validate(value, ""); err != nil {
+				act = err.Error()
+			}
+			if act != tc.err {
+				t.Errorf("\nexp: %#v\nact: %#v", tc.err, act)
+			}
+		})
+	}
+}
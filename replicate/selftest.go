@@ -0,0 +1,168 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: MPL-2.0
+
+package replicate
+
+import (
+	"fmt"
+	"log"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/hashicorp/consul-template/config"
+	"github.com/hashicorp/consul/api"
+)
+
+// selfTestPollInterval is how often the destination is read while waiting for
+// a self-test key to be replicated.
+const selfTestPollInterval = 250 * time.Millisecond
+
+// SelfTestResult is the outcome of the self-test of a prefix.
+type SelfTestResult struct {
+	// Source, Datacenter and Destination identify the prefix.
+	Source, Datacenter, Destination string
+
+	// Key is the scratch key written into the source.
+	Key string
+
+	// Latency is how long the scratch key took to appear at the destination.
+	Latency time.Duration
+
+	// Err is why the self-test failed, if it did.
+	Err error
+}
+
+// SelfTest writes a scratch key into the source of every configured prefix,
+// and waits up to the timeout for a running replicator to write it into the
+// destination. The key is then deleted from the source, and the delete must be
+// replicated within the timeout too. Scratch keys are removed from both ends
+// when the test finishes, even if it failed. Prefixes are tested in parallel.
+func (r *Runner) SelfTest(timeout time.Duration) ([]*SelfTestResult, error) {
+	prefixes, err := r.concretePrefixes()
+	if err != nil {
+		return nil, err
+	}
+
+	name := fmt.Sprintf("consul-replicate-selftest-%d", time.Now().UnixNano())
+	results := make([]*SelfTestResult, len(prefixes))
+
+	var wg sync.WaitGroup
+	for i, prefix := range prefixes {
+		wg.Add(1)
+		go func(i int, prefix *PrefixConfig) {
+			defer wg.Done()
+			results[i] = r.selfTestPrefix(prefix, name, timeout)
+		}(i, prefix)
+	}
+	wg.Wait()
+
+	return results, nil
+}
+
+// selfTestPrefix runs the self-test of a single prefix.
+func (r *Runner) selfTestPrefix(prefix *PrefixConfig, name string, timeout time.Duration) *SelfTestResult {
+	source := config.StringVal(prefix.Source)
+	dc := config.StringVal(prefix.Datacenter)
+
+	key := name
+	if folder := strings.TrimSuffix(source, "/"); folder != "" {
+		key = folder + "/" + name
+	}
+	destKey := config.StringVal(prefix.Destination) + strings.TrimPrefix(key, source)
+
+	result := &SelfTestResult{
+		Source:      source,
+		Datacenter:  dc,
+		Destination: config.StringVal(prefix.Destination),
+		Key:         key,
+	}
+	if r.excluded(key) {
+		result.Err = fmt.Errorf("scratch key %q is excluded", key)
+		return result
+	}
+
+	kv := r.clients.Consul().KV()
+	backend := r.backend(prefix)
+	value := []byte(time.Now().UTC().Format(time.RFC3339Nano))
+
+	start := time.Now()
+	if _, err := kv.Put(&api.KVPair{Key: key, Value: value}, &api.WriteOptions{
+		Datacenter: dc,
+	}); err != nil {
+		result.Err = fmt.Errorf("writing %q: %s", key, err)
+		return result
+	}
+
+	// Leave nothing behind, whatever the outcome
+	defer func() {
+		if _, err := kv.Delete(key, &api.WriteOptions{Datacenter: dc}); err != nil {
+			log.Printf("[WARN] (runner) failed to remove scratch key %q: %s", key, err)
+		}
+		if result.Err == nil {
+			return
+		}
+		if err := backend.Delete(destKey); err != nil {
+			log.Printf("[WARN] (runner) failed to remove scratch key %q: %s", destKey, err)
+		}
+	}()
+
+	if err := waitFor(timeout, func() (bool, error) {
+		pair, err := backend.Get(destKey)
+		return pair != nil && string(pair.Value) == string(value), err
+	}); err != nil {
+		result.Err = fmt.Errorf("waiting for %q: %s", destKey, err)
+		return result
+	}
+	result.Latency = time.Since(start)
+	log.Printf("[INFO] (runner) %s replicated %q in %s", prefix.Dependency, key, result.Latency)
+
+	if _, err := kv.Delete(key, &api.WriteOptions{Datacenter: dc}); err != nil {
+		result.Err = fmt.Errorf("deleting %q: %s", key, err)
+		return result
+	}
+	if err := waitFor(timeout, func() (bool, error) {
+		pair, err := backend.Get(destKey)
+		return pair == nil, err
+	}); err != nil {
+		result.Err = fmt.Errorf("waiting for the delete of %q: %s", destKey, err)
+	}
+	return result
+}
+
+// concretePrefixes returns the configured prefixes, with wildcards expanded.
+func (r *Runner) concretePrefixes() ([]*PrefixConfig, error) {
+	var prefixes []*PrefixConfig
+	for _, prefix := range *r.config.Prefixes {
+		if !prefix.IsWildcard() {
+			prefixes = append(prefixes, prefix)
+			continue
+		}
+
+		expanded, err := r.expand(prefix)
+		if err != nil {
+			return nil, err
+		}
+		prefixes = append(prefixes, expanded...)
+	}
+	return prefixes, nil
+}
+
+// waitFor polls the condition until it is true, or fails once the timeout
+// passes. Errors are retried until then, and the last one is returned.
+func waitFor(timeout time.Duration, cond func() (bool, error)) error {
+	deadline := time.Now().Add(timeout)
+	for {
+		ok, err := cond()
+		if ok && err == nil {
+			return nil
+		}
+		if time.Now().After(deadline) {
+			if err != nil {
+				return err
+			}
+			return fmt.Errorf("not replicated within %s", timeout)
+		}
+		time.Sleep(selfTestPollInterval)
+	}
+}
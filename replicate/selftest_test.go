@@ -0,0 +1,118 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: MPL-2.0
+
+package replicate
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/hashicorp/consul-template/config"
+	"github.com/hashicorp/consul/api"
+)
+
+func TestRunner_SelfTest(t *testing.T) {
+	cases := []struct {
+		name      string
+		replicate bool
+		err       string
+	}{
+		{
+			"replicated",
+			true,
+			"",
+		},
+		{
+			"not_replicated",
+			false,
+			"not replicated within",
+		},
+	}
+
+	for i, tc := range cases {
+		t.Run(fmt.Sprintf("%d_%s", i, tc.name), func(t *testing.T) {
+			// A KV store in which writes to "global" are replicated into
+			// "backup", like a running replicator would
+			var lock sync.Mutex
+			kv := make(map[string][]byte)
+			consul := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+				lock.Lock()
+				defer lock.Unlock()
+
+				key := strings.TrimPrefix(req.URL.Path, "/v1/kv/")
+				keys := []string{key}
+				if tc.replicate && strings.HasPrefix(key, "global/") {
+					keys = append(keys, "backup/"+strings.TrimPrefix(key, "global/"))
+				}
+
+				switch req.Method {
+				case http.MethodGet:
+					value, ok := kv[key]
+					if !ok {
+						w.WriteHeader(http.StatusNotFound)
+						return
+					}
+					json.NewEncoder(w).Encode([]*api.KVPair{{Key: key, Value: value}})
+				case http.MethodPut:
+					value, _ := io.ReadAll(req.Body)
+					for _, key := range keys {
+						kv[key] = value
+					}
+					fmt.Fprint(w, `true`)
+				case http.MethodDelete:
+					for _, key := range keys {
+						delete(kv, key)
+					}
+					fmt.Fprint(w, `true`)
+				}
+			}))
+			defer consul.Close()
+
+			prefix, err := ParsePrefixConfig("global/@dc1:backup/")
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			address := config.String(strings.TrimPrefix(consul.URL, "http://"))
+			c := DefaultConfig().Merge(&Config{
+				Consul:            &config.ConsulConfig{Address: address},
+				DestinationConsul: &config.ConsulConfig{Address: address},
+				Prefixes:          &PrefixConfigs{prefix},
+			})
+			c.Finalize()
+
+			r, err := NewRunner(c, true)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			results, err := r.SelfTest(500 * time.Millisecond)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(results) != 1 {
+				t.Fatalf("expected 1 result, got %d", len(results))
+			}
+
+			result := results[0]
+			if tc.err == "" && result.Err != nil {
+				t.Fatal(result.Err)
+			}
+			if tc.err != "" && (result.Err == nil || !strings.Contains(result.Err.Error(), tc.err)) {
+				t.Errorf("expected %v to contain %q", result.Err, tc.err)
+			}
+
+			// Scratch keys are removed either way
+			if len(kv) != 0 {
+				t.Errorf("expected scratch keys to be removed, got %#v", kv)
+			}
+		})
+	}
+}
@@ -0,0 +1,148 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: MPL-2.0
+
+package replicate
+
+import (
+	"hash/fnv"
+	"log"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/hashicorp/consul-template/config"
+	"github.com/hashicorp/consul/api"
+	"github.com/pkg/errors"
+)
+
+// shardSessionTTL is the TTL of the membership session. A member which stops
+// renewing it leaves the pool, and its prefixes move to the other members.
+const shardSessionTTL = 15 * time.Second
+
+// shardEnabled returns true if prefixes are spread across a pool of instances.
+func (r *Runner) shardEnabled() bool {
+	return config.BoolVal(r.config.Shard.Enabled) && r.snapshots == nil
+}
+
+// membersPrefix returns the prefix of the membership keys.
+func (r *Runner) membersPrefix() string {
+	if prefix := config.StringVal(r.config.Shard.MembersPrefix); prefix != "" {
+		return strings.TrimRight(prefix, "/") + "/"
+	}
+	return strings.TrimRight(config.StringVal(r.config.StatusDir), "/") + "/members/"
+}
+
+// joinShard registers the instance in the pool by acquiring its membership
+// key with a session, which is renewed until the runner is stopped. If the
+// session is lost, the instance joins again. shardCh is notified every time the
+// instance joins.
+func (r *Runner) joinShard() {
+	client := r.destinationClients.Consul()
+	key := r.membersPrefix() + config.StringVal(r.config.Shard.ID)
+
+	for {
+		session, err := r.acquireMembership(client, key)
+		if err == nil {
+			log.Printf("[INFO] (runner) joined shard pool as %q", key)
+			select {
+			case r.shardCh <- struct{}{}:
+			default:
+			}
+
+			// RenewPeriodic destroys the session once the runner is stopped
+			err = client.Session().RenewPeriodic(shardSessionTTL.String(), session, nil, r.stopCh)
+			if err == nil {
+				return
+			}
+		}
+
+		log.Printf("[WARN] (runner) shard membership %q lost: %s", key, err)
+		select {
+		case <-time.After(haRetryInterval):
+		case <-r.stopCh:
+			return
+		}
+	}
+}
+
+// acquireMembership creates a session and acquires the membership key with it.
+func (r *Runner) acquireMembership(client *api.Client, key string) (string, error) {
+	session, _, err := client.Session().Create(&api.SessionEntry{
+		Name:     "consul-replicate-shard",
+		TTL:      shardSessionTTL.String(),
+		Behavior: api.SessionBehaviorDelete,
+	}, nil)
+	if err != nil {
+		return "", errors.Wrap(err, "creating session")
+	}
+
+	ok, _, err := client.KV().Acquire(&api.KVPair{
+		Key:     key,
+		Value:   []byte(config.StringVal(r.config.Shard.ID)),
+		Session: session,
+	}, nil)
+	if err == nil && !ok {
+		err = errors.New("id is in use by another instance")
+	}
+	if err != nil {
+		client.Session().Destroy(session, nil)
+		return "", err
+	}
+	return session, nil
+}
+
+// members returns the IDs of the instances in the pool, in order.
+func (r *Runner) members() ([]string, error) {
+	prefix := r.membersPrefix()
+	pairs, _, err := r.destinationClients.Consul().KV().List(prefix, nil)
+	if err != nil {
+		return nil, errors.Wrap(err, "listing shard members")
+	}
+
+	var members []string
+	for _, pair := range pairs {
+		if pair.Session == "" {
+			continue
+		}
+		members = append(members, strings.TrimPrefix(pair.Key, prefix))
+	}
+	sort.Strings(members)
+	return members, nil
+}
+
+// shard returns the prefixes owned by this instance.
+func (r *Runner) shard(prefixes []*PrefixConfig) ([]*PrefixConfig, error) {
+	members, err := r.members()
+	if err != nil {
+		return nil, err
+	}
+
+	id := config.StringVal(r.config.Shard.ID)
+	var owned []*PrefixConfig
+	for _, prefix := range prefixes {
+		if shardOwner(members, prefix.Dependency.String()) == id {
+			owned = append(owned, prefix)
+		}
+	}
+
+	log.Printf("[DEBUG] (runner) %d member(s) in shard pool, owning %d of %d prefix(es)",
+		len(members), len(owned), len(prefixes))
+	return owned, nil
+}
+
+// shardOwner returns the member responsible for the prefix using rendezvous
+// hashing, so only the prefixes of members which join or leave move.
+func shardOwner(members []string, prefix string) string {
+	var owner string
+	var max uint64
+	for _, member := range members {
+		h := fnv.New64a()
+		h.Write([]byte(member))
+		h.Write([]byte{0})
+		h.Write([]byte(prefix))
+		if sum := h.Sum64(); owner == "" || sum > max {
+			owner, max = member, sum
+		}
+	}
+	return owner
+}
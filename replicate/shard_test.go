@@ -0,0 +1,59 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: MPL-2.0
+
+package replicate
+
+import (
+	"fmt"
+	"testing"
+)
+
+func TestShardOwner(t *testing.T) {
+	prefixes := make([]string, 100)
+	for i := range prefixes {
+		prefixes[i] = fmt.Sprintf("kv.list(service/%d@dc1)", i)
+	}
+
+	members := []string{"a", "b", "c"}
+	owners := make(map[string]string)
+	counts := make(map[string]int)
+	for _, prefix := range prefixes {
+		owner := shardOwner(members, prefix)
+		owners[prefix] = owner
+		counts[owner]++
+	}
+
+	t.Run("spread", func(t *testing.T) {
+		for _, member := range members {
+			if counts[member] == 0 {
+				t.Errorf("expected %q to own prefixes: %#v", member, counts)
+			}
+		}
+	})
+
+	t.Run("no_members", func(t *testing.T) {
+		if owner := shardOwner(nil, prefixes[0]); owner != "" {
+			t.Errorf("\nexp: %#v\nact: %#v", "", owner)
+		}
+	})
+
+	t.Run("member_leaves", func(t *testing.T) {
+		// Only the prefixes of the member which left move
+		for _, prefix := range prefixes {
+			owner := shardOwner([]string{"a", "c"}, prefix)
+			if owners[prefix] != "b" && owner != owners[prefix] {
+				t.Errorf("%s moved from %q to %q", prefix, owners[prefix], owner)
+			}
+		}
+	})
+
+	t.Run("member_joins", func(t *testing.T) {
+		// Prefixes only move to the member which joined
+		for _, prefix := range prefixes {
+			owner := shardOwner([]string{"a", "b", "c", "d"}, prefix)
+			if owner != "d" && owner != owners[prefix] {
+				t.Errorf("%s moved from %q to %q", prefix, owners[prefix], owner)
+			}
+		}
+	})
+}
@@ -0,0 +1,372 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: MPL-2.0
+
+package replicate
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/tls"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/hashicorp/consul-template/config"
+	"github.com/pkg/errors"
+)
+
+// sinkTimeout is the maximum amount of time publishing a batch may take.
+const sinkTimeout = 10 * time.Second
+
+// Sink is a stream where applied changes are published.
+type Sink interface {
+	// Publish delivers the changes of a single replication pass.
+	Publish(changes []*Change) error
+}
+
+// newSink creates the sink for the given configuration.
+func newSink(c *SinkConfig) (Sink, error) {
+	if config.StringVal(c.Address) == "" {
+		return nil, fmt.Errorf("sink: missing address")
+	}
+
+	var tlsConfig *tls.Config
+	if c.SSL != nil && config.BoolVal(c.SSL.Enabled) {
+		var err error
+		if tlsConfig, err = newTLSConfig(c.SSL); err != nil {
+			return nil, errors.Wrap(err, "sink")
+		}
+	}
+
+	var user, pass string
+	if c.Auth != nil && config.BoolVal(c.Auth.Enabled) {
+		user, pass = config.StringVal(c.Auth.Username), config.StringVal(c.Auth.Password)
+	}
+
+	switch config.StringVal(c.Type) {
+	case SinkKafka:
+		client := &http.Client{Timeout: sinkTimeout}
+		if tlsConfig != nil {
+			transport := http.DefaultTransport.(*http.Transport).Clone()
+			transport.TLSClientConfig = tlsConfig
+			client.Transport = transport
+		}
+		return &kafkaSink{
+			address: strings.TrimRight(config.StringVal(c.Address), "/"),
+			topic:   config.StringVal(c.Topic),
+			client:  client,
+			user:    user,
+			pass:    pass,
+			token:   config.StringVal(c.Token),
+		}, nil
+	case SinkNATS:
+		return &natsSink{
+			address: config.StringVal(c.Address),
+			subject: config.StringVal(c.Topic),
+			tls:     tlsConfig,
+			connect: natsConnect{
+				TLSRequired: tlsConfig != nil,
+				Name:        "consul-replicate",
+				User:        user,
+				Pass:        pass,
+				AuthToken:   config.StringVal(c.Token),
+			},
+		}, nil
+	default:
+		return nil, fmt.Errorf("sink: unknown type %q", config.StringVal(c.Type))
+	}
+}
+
+// publish delivers the changes of the event to every sink. Failures are
+// logged but do not fail replication.
+func (r *Runner) publish(e *Event) {
+	if len(e.Changes) == 0 {
+		return
+	}
+
+	for _, s := range r.sinks {
+		if err := s.Publish(e.Changes); err != nil {
+			log.Printf("[WARN] (runner) failed to publish %d changes for %q: %s",
+				len(e.Changes), e.Source, err)
+		}
+	}
+}
+
+// kafkaSink produces records through the Kafka REST proxy, keyed by the
+// destination key so changes to one key stay ordered within a partition.
+type kafkaSink struct {
+	address, topic string
+	client         *http.Client
+
+	// user and pass are the basic authentication of the proxy, and token its
+	// bearer token.
+	user, pass, token string
+}
+
+type kafkaRecord struct {
+	Key   string  `json:"key"`
+	Value *Change `json:"value"`
+}
+
+// kafkaResponse is the response of the REST proxy, with the offset of every
+// record. The proxy answers 200 even when some of the records failed, which
+// only their error code reports.
+type kafkaResponse struct {
+	Offsets []struct {
+		ErrorCode *int   `json:"error_code"`
+		Error     string `json:"error"`
+	} `json:"offsets"`
+}
+
+func (s *kafkaSink) Publish(changes []*Change) error {
+	records := make([]*kafkaRecord, len(changes))
+	for i, c := range changes {
+		records[i] = &kafkaRecord{Key: c.Key, Value: c}
+	}
+
+	body, err := json.Marshal(map[string]interface{}{"records": records})
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequest(http.MethodPost,
+		s.address+"/topics/"+url.PathEscape(s.topic), bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/vnd.kafka.json.v2+json")
+	req.Header.Set("Accept", "application/vnd.kafka.v2+json")
+	if s.user != "" || s.pass != "" {
+		req.SetBasicAuth(s.user, s.pass)
+	}
+	if s.token != "" {
+		req.Header.Set("Authorization", "Bearer "+s.token)
+	}
+
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return errors.Wrap(err, "kafka")
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		b, _ := ioutil.ReadAll(resp.Body)
+		return fmt.Errorf("kafka: unexpected response code %d: %s",
+			resp.StatusCode, strings.TrimSpace(string(b)))
+	}
+
+	var result kafkaResponse
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return errors.Wrap(err, "kafka: decoding response")
+	}
+	for i, offset := range result.Offsets {
+		if offset.ErrorCode != nil && *offset.ErrorCode != 0 && i < len(changes) {
+			return fmt.Errorf("kafka: failed to produce the record of %q: error code %d: %s",
+				changes[i].Key, *offset.ErrorCode, offset.Error)
+		}
+	}
+	return nil
+}
+
+// natsSink publishes one message per change to a NATS subject using the NATS
+// client protocol. The connection is established lazily and re-established
+// after any error.
+type natsSink struct {
+	sync.Mutex
+
+	address, subject string
+
+	// tls upgrades the connection to TLS when set.
+	tls     *tls.Config
+	connect natsConnect
+
+	conn *natsConn
+}
+
+// natsConnect is the CONNECT message the client sends after the greeting of
+// the server, with its credentials.
+type natsConnect struct {
+	Verbose     bool   `json:"verbose"`
+	Pedantic    bool   `json:"pedantic"`
+	TLSRequired bool   `json:"tls_required"`
+	Name        string `json:"name"`
+	User        string `json:"user,omitempty"`
+	Pass        string `json:"pass,omitempty"`
+	AuthToken   string `json:"auth_token,omitempty"`
+}
+
+func (s *natsSink) Publish(changes []*Change) error {
+	s.Lock()
+	defer s.Unlock()
+
+	if s.conn == nil {
+		conn, err := dialNATS(s.address, s.tls, &s.connect)
+		if err != nil {
+			return errors.Wrap(err, "nats")
+		}
+		s.conn = conn
+	}
+
+	var buf bytes.Buffer
+	for _, c := range changes {
+		b, err := json.Marshal(c)
+		if err != nil {
+			return err
+		}
+		fmt.Fprintf(&buf, "PUB %s %d\r\n%s\r\n", s.subject, len(b), b)
+	}
+
+	// A trailing PING flushes the batch: the server answers with PONG once
+	// every preceding message has been processed.
+	buf.WriteString("PING\r\n")
+
+	if err := s.conn.roundTrip(buf.Bytes()); err != nil {
+		s.conn.close()
+		s.conn = nil
+		return errors.Wrap(err, "nats")
+	}
+	return nil
+}
+
+// natsConn is a connection to a NATS server which answers server PINGs in the
+// background.
+type natsConn struct {
+	conn      net.Conn
+	writeLock sync.Mutex
+
+	pongCh chan struct{}
+	errCh  chan error
+}
+
+func dialNATS(address string, tlsConfig *tls.Config, connect *natsConnect) (*natsConn, error) {
+	conn, err := net.DialTimeout("tcp", address, sinkTimeout)
+	if err != nil {
+		return nil, err
+	}
+
+	// The server certificate is verified against the host of the address
+	// unless the configuration names another server.
+	if tlsConfig != nil && tlsConfig.ServerName == "" {
+		tlsConfig = tlsConfig.Clone()
+		if tlsConfig.ServerName, _, err = net.SplitHostPort(address); err != nil {
+			tlsConfig.ServerName = address
+		}
+	}
+	return newNATSConn(conn, tlsConfig, connect)
+}
+
+func newNATSConn(conn net.Conn, tlsConfig *tls.Config, connect *natsConnect) (*natsConn, error) {
+	r := bufio.NewReader(conn)
+
+	// The server greets every client with its INFO
+	conn.SetReadDeadline(time.Now().Add(sinkTimeout))
+	line, err := r.ReadString('\n')
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+	if !strings.HasPrefix(line, "INFO ") {
+		conn.Close()
+		return nil, fmt.Errorf("unexpected greeting %q", strings.TrimSpace(line))
+	}
+
+	// The server expects the TLS handshake right after its greeting
+	if tlsConfig != nil {
+		tlsConn := tls.Client(conn, tlsConfig)
+		if err := tlsConn.Handshake(); err != nil {
+			conn.Close()
+			return nil, errors.Wrap(err, "tls handshake")
+		}
+		conn = tlsConn
+		r = bufio.NewReader(conn)
+	}
+	conn.SetReadDeadline(time.Time{})
+
+	b, err := json.Marshal(connect)
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+	if _, err := fmt.Fprintf(conn, "CONNECT %s\r\n", b); err != nil {
+		conn.Close()
+		return nil, err
+	}
+
+	c := &natsConn{
+		conn:   conn,
+		pongCh: make(chan struct{}, 1),
+		errCh:  make(chan error, 1),
+	}
+	go c.read(r)
+	return c, nil
+}
+
+// read handles server messages until the connection is closed.
+func (c *natsConn) read(r *bufio.Reader) {
+	for {
+		line, err := r.ReadString('\n')
+		if err != nil {
+			c.fail(err)
+			return
+		}
+		line = strings.TrimSpace(line)
+
+		switch {
+		case line == "PING":
+			c.writeLock.Lock()
+			_, err := c.conn.Write([]byte("PONG\r\n"))
+			c.writeLock.Unlock()
+			if err != nil {
+				c.fail(err)
+				return
+			}
+		case line == "PONG":
+			select {
+			case c.pongCh <- struct{}{}:
+			default:
+			}
+		case strings.HasPrefix(line, "-ERR"):
+			c.fail(fmt.Errorf("server error: %s", strings.TrimSpace(strings.TrimPrefix(line, "-ERR"))))
+		}
+	}
+}
+
+// fail records the first error of the connection.
+func (c *natsConn) fail(err error) {
+	select {
+	case c.errCh <- err:
+	default:
+	}
+}
+
+// roundTrip writes the batch, which must end in a PING, and waits for the
+// server's PONG.
+func (c *natsConn) roundTrip(b []byte) error {
+	c.writeLock.Lock()
+	c.conn.SetWriteDeadline(time.Now().Add(sinkTimeout))
+	_, err := c.conn.Write(b)
+	c.writeLock.Unlock()
+	if err != nil {
+		return err
+	}
+
+	select {
+	case <-c.pongCh:
+		return nil
+	case err := <-c.errCh:
+		return err
+	case <-time.After(sinkTimeout):
+		return fmt.Errorf("timeout waiting for server acknowledgement")
+	}
+}
+
+func (c *natsConn) close() {
+	c.conn.Close()
+}
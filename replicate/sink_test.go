@@ -0,0 +1,280 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: MPL-2.0
+
+package replicate
+
+import (
+	"bufio"
+	"crypto/tls"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/hashicorp/consul-template/config"
+)
+
+var testChanges = []*Change{
+	{Source: "foo", Key: "bar/a", Op: ChangePut, NewHash: valueHash([]byte("1")), Index: 7},
+	{Source: "foo", Key: "bar/b", Op: ChangeDelete, OldHash: valueHash([]byte("2")), Index: 8},
+}
+
+func TestKafkaSink_Publish(t *testing.T) {
+	var path, contentType string
+	var body struct {
+		Records []struct {
+			Key   string  `json:"key"`
+			Value *Change `json:"value"`
+		} `json:"records"`
+	}
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		path, contentType = r.URL.Path, r.Header.Get("Content-Type")
+		if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+			t.Error(err)
+		}
+		fmt.Fprint(w, `{"offsets":[{"partition":0,"offset":1,"error_code":null,"error":null},`+
+			`{"partition":0,"offset":2,"error_code":null,"error":null}]}`)
+	}))
+	defer srv.Close()
+
+	s, err := newSink(&SinkConfig{
+		Address: config.String(srv.URL),
+		Topic:   config.String("changes"),
+		Type:    config.String(SinkKafka),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := s.Publish(testChanges); err != nil {
+		t.Fatal(err)
+	}
+
+	if path != "/topics/changes" || contentType != "application/vnd.kafka.json.v2+json" {
+		t.Errorf("bad request: %s %s", path, contentType)
+	}
+	if len(body.Records) != 2 || body.Records[1].Key != "bar/b" ||
+		!reflect.DeepEqual(body.Records[1].Value, testChanges[1]) {
+		t.Errorf("bad records: %#v", body.Records)
+	}
+}
+
+func TestKafkaSink_PublishErrorCode(t *testing.T) {
+	// The proxy answers 200 even when a record failed
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, `{"offsets":[{"partition":0,"offset":1,"error_code":null,"error":null},`+
+			`{"partition":null,"offset":null,"error_code":50002,"error":"not leader"}]}`)
+	}))
+	defer srv.Close()
+
+	s, err := newSink(&SinkConfig{
+		Address: config.String(srv.URL),
+		Topic:   config.String("changes"),
+		Type:    config.String(SinkKafka),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = s.Publish(testChanges)
+	if err == nil || !strings.Contains(err.Error(), `"bar/b"`) || !strings.Contains(err.Error(), "50002") {
+		t.Fatalf("expected the record of bar/b to fail, got %v", err)
+	}
+}
+
+func TestNATSSink_Publish(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+
+	// A minimal server which records the published subjects and payloads
+	msgCh := make(chan string, 10)
+	go func() {
+		conn, err := ln.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+
+		fmt.Fprint(conn, "INFO {}\r\n")
+		r := bufio.NewReader(conn)
+		for {
+			line, err := r.ReadString('\n')
+			if err != nil {
+				return
+			}
+
+			switch f := strings.Fields(line); f[0] {
+			case "PUB":
+				payload, _ := r.ReadString('\n')
+				msgCh <- f[1] + " " + strings.TrimSpace(payload)
+			case "PING":
+				fmt.Fprint(conn, "PONG\r\n")
+			}
+		}
+	}()
+
+	s, err := newSink(&SinkConfig{
+		Address: config.String(ln.Addr().String()),
+		Topic:   config.String("config.changes"),
+		Type:    config.String(SinkNATS),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := s.Publish(testChanges); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, c := range testChanges {
+		b, _ := json.Marshal(c)
+		if exp, act := "config.changes "+string(b), <-msgCh; exp != act {
+			t.Errorf("\nexp: %s\nact: %s", exp, act)
+		}
+	}
+}
+
+func TestNATSSink_PublishTLS(t *testing.T) {
+	dir := t.TempDir()
+	certFile, keyFile := filepath.Join(dir, "cert.pem"), filepath.Join(dir, "key.pem")
+	writeServerCert(t, certFile, keyFile)
+	cert, err := tls.LoadX509KeyPair(certFile, keyFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+
+	// The server requires TLS after its greeting and records the CONNECT
+	connectCh := make(chan string, 1)
+	msgCh := make(chan string, 10)
+	go func() {
+		conn, err := ln.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+
+		fmt.Fprint(conn, `INFO {"tls_required":true}`+"\r\n")
+		tlsConn := tls.Server(conn, &tls.Config{Certificates: []tls.Certificate{cert}})
+		if err := tlsConn.Handshake(); err != nil {
+			t.Error(err)
+			return
+		}
+
+		r := bufio.NewReader(tlsConn)
+		for {
+			line, err := r.ReadString('\n')
+			if err != nil {
+				return
+			}
+
+			switch f := strings.Fields(line); f[0] {
+			case "CONNECT":
+				connectCh <- strings.TrimSpace(strings.TrimPrefix(line, "CONNECT"))
+			case "PUB":
+				payload, _ := r.ReadString('\n')
+				msgCh <- f[1] + " " + strings.TrimSpace(payload)
+			case "PING":
+				fmt.Fprint(tlsConn, "PONG\r\n")
+			}
+		}
+	}()
+
+	c := &SinkConfig{
+		Address: config.String(ln.Addr().String()),
+		Auth: &config.AuthConfig{
+			Username: config.String("replicate"),
+			Password: config.String("secret"),
+		},
+		SSL: &config.SSLConfig{
+			CaCert: config.String(certFile),
+		},
+		Token: config.String("s3cr3t"),
+		Topic: config.String("config.changes"),
+		Type:  config.String(SinkNATS),
+	}
+	c.Finalize()
+
+	s, err := newSink(c)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := s.Publish(testChanges); err != nil {
+		t.Fatal(err)
+	}
+
+	var connect natsConnect
+	if err := json.Unmarshal([]byte(<-connectCh), &connect); err != nil {
+		t.Fatal(err)
+	}
+	exp := natsConnect{
+		TLSRequired: true,
+		Name:        "consul-replicate",
+		User:        "replicate",
+		Pass:        "secret",
+		AuthToken:   "s3cr3t",
+	}
+	if !reflect.DeepEqual(exp, connect) {
+		t.Errorf("\nexp: %#v\nact: %#v", exp, connect)
+	}
+	if act := <-msgCh; !strings.HasPrefix(act, "config.changes ") {
+		t.Errorf("bad message: %s", act)
+	}
+}
+
+func TestKafkaSink_PublishAuth(t *testing.T) {
+	var user, pass string
+	var ok bool
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		user, pass, ok = r.BasicAuth()
+		fmt.Fprint(w, `{"offsets":[]}`)
+	}))
+	defer srv.Close()
+
+	c := &SinkConfig{
+		Address: config.String(srv.URL),
+		Auth: &config.AuthConfig{
+			Username: config.String("replicate"),
+			Password: config.String("secret"),
+		},
+		Topic: config.String("changes"),
+		Type:  config.String(SinkKafka),
+	}
+	c.Finalize()
+
+	s, err := newSink(c)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := s.Publish(testChanges); err != nil {
+		t.Fatal(err)
+	}
+	if !ok || user != "replicate" || pass != "secret" {
+		t.Errorf("bad basic auth: %q %q %t", user, pass, ok)
+	}
+}
+
+func TestNewSink_invalid(t *testing.T) {
+	if _, err := newSink(&SinkConfig{
+		Address: config.String("127.0.0.1:4222"),
+		Type:    config.String("carrier-pigeon"),
+	}); err == nil {
+		t.Fatal("expected error")
+	}
+}
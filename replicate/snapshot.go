@@ -0,0 +1,321 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: MPL-2.0
+
+package replicate
+
+import (
+	"archive/tar"
+	"bufio"
+	"compress/gzip"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"os"
+	"sort"
+	"strings"
+
+	"github.com/hashicorp/consul-template/config"
+	dep "github.com/hashicorp/consul-template/dependency"
+	"github.com/hashicorp/consul/api"
+	"github.com/hashicorp/go-msgpack/codec"
+	"github.com/pkg/errors"
+)
+
+// SnapshotConsul is the value of the snapshot option which fetches snapshots
+// from the source datacenters through the snapshot API instead of a file.
+const SnapshotConsul = "consul"
+
+const (
+	// snapshotState is the name of the archive member with the Raft state.
+	snapshotState = "state.bin"
+
+	// snapshotSums is the name of the archive member with the checksums.
+	snapshotSums = "SHA256SUMS"
+
+	// kvsRequestType is the Raft message type of KV entries.
+	kvsRequestType = 2
+
+	// ignoreUnknownTypeFlag is set on message types older servers may skip.
+	ignoreUnknownTypeFlag = 128
+)
+
+// snapshotHandle matches the msgpack encoding used by Consul servers.
+var snapshotHandle = &codec.MsgpackHandle{
+	RawToString: true,
+}
+
+// snapshot is the KV contents of a Consul snapshot.
+type snapshot struct {
+	// index is the Raft index the snapshot was taken at.
+	index uint64
+
+	// pairs are the KV entries, sorted by path.
+	pairs []*dep.KeyPair
+
+	// delta is true if pairs are only the keys changed since the previous
+	// export, in which case deleted are the paths removed since then.
+	delta   bool
+	deleted []string
+}
+
+// snapshotKV is the subset of a Consul KV entry read from a snapshot.
+// CreateIndex and ModifyIndex are part of an embedded struct on the server,
+// which msgpack encodes inline.
+type snapshotKV struct {
+	LockIndex   uint64
+	Key         string
+	Flags       uint64
+	Value       []byte
+	Session     string
+	CreateIndex uint64
+	ModifyIndex uint64
+}
+
+// readSnapshotFile reads the snapshot at the given path.
+func readSnapshotFile(path string) (*snapshot, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	s, err := readSnapshot(f)
+	if err != nil {
+		return nil, errors.Wrapf(err, "snapshot %q", path)
+	}
+	return s, nil
+}
+
+// fetchSnapshot saves a snapshot of the datacenter through the API.
+func fetchSnapshot(client *api.Client, dc string) (*snapshot, error) {
+	rc, _, err := client.Snapshot().Save(&api.QueryOptions{Datacenter: dc})
+	if err != nil {
+		return nil, errors.Wrapf(err, "saving snapshot of %q", dc)
+	}
+	defer rc.Close()
+
+	s, err := readSnapshot(rc)
+	if err != nil {
+		return nil, errors.Wrapf(err, "snapshot of %q", dc)
+	}
+	return s, nil
+}
+
+// readSnapshot reads a snapshot archive, as written by "consul snapshot save",
+// and verifies the checksum of its state.
+func readSnapshot(r io.Reader) (*snapshot, error) {
+	gz, err := gzip.NewReader(r)
+	if err != nil {
+		return nil, errors.Wrap(err, "decompressing")
+	}
+	defer gz.Close()
+
+	var s *snapshot
+	var sum, sums []byte
+	archive := tar.NewReader(gz)
+	for {
+		hdr, err := archive.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, errors.Wrap(err, "reading archive")
+		}
+
+		switch hdr.Name {
+		case snapshotState:
+			h := sha256.New()
+			if s, err = readSnapshotState(io.TeeReader(archive, h)); err != nil {
+				return nil, err
+			}
+
+			// Hash any trailing bytes the decoder did not consume
+			if _, err := io.Copy(h, archive); err != nil {
+				return nil, errors.Wrap(err, "reading state")
+			}
+			sum = h.Sum(nil)
+		case snapshotSums:
+			if sums, err = ioutil.ReadAll(archive); err != nil {
+				return nil, errors.Wrap(err, "reading checksums")
+			}
+		}
+	}
+
+	if s == nil {
+		return nil, fmt.Errorf("missing %s", snapshotState)
+	}
+	if sums == nil {
+		return nil, fmt.Errorf("missing %s", snapshotSums)
+	}
+
+	// The checksums are in the format of sha256sum(1)
+	expected := ""
+	for _, line := range strings.Split(string(sums), "\n") {
+		if f := strings.Fields(line); len(f) == 2 && f[1] == snapshotState {
+			expected = f[0]
+		}
+	}
+	if expected != hex.EncodeToString(sum) {
+		return nil, fmt.Errorf("checksum mismatch for %s", snapshotState)
+	}
+
+	return s, nil
+}
+
+// readSnapshotState decodes the Raft state, keeping only KV entries.
+func readSnapshotState(r io.Reader) (*snapshot, error) {
+	br := bufio.NewReader(r)
+	dec := codec.NewDecoder(br, snapshotHandle)
+
+	var header struct {
+		LastIndex uint64
+	}
+	if err := dec.Decode(&header); err != nil {
+		return nil, errors.Wrap(err, "decoding header")
+	}
+
+	s := &snapshot{index: header.LastIndex}
+	for {
+		msgType, err := br.ReadByte()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, errors.Wrap(err, "reading state")
+		}
+
+		if msgType&^ignoreUnknownTypeFlag != kvsRequestType {
+			var skip interface{}
+			if err := dec.Decode(&skip); err != nil {
+				return nil, errors.Wrapf(err, "decoding message type %d", msgType)
+			}
+			continue
+		}
+
+		var kv snapshotKV
+		if err := dec.Decode(&kv); err != nil {
+			return nil, errors.Wrap(err, "decoding KV entry")
+		}
+		s.pairs = append(s.pairs, &dep.KeyPair{
+			Path:        kv.Key,
+			Key:         kv.Key,
+			Value:       string(kv.Value),
+			CreateIndex: kv.CreateIndex,
+			ModifyIndex: kv.ModifyIndex,
+			LockIndex:   kv.LockIndex,
+			Flags:       kv.Flags,
+			Session:     kv.Session,
+		})
+	}
+
+	sort.Slice(s.pairs, func(i, j int) bool {
+		return s.pairs[i].Path < s.pairs[j].Path
+	})
+	return s, nil
+}
+
+// list returns the entries under the prefix, like a recursive KV list.
+func (s *snapshot) list(prefix string) []*dep.KeyPair {
+	i := sort.Search(len(s.pairs), func(i int) bool {
+		return s.pairs[i].Path >= prefix
+	})
+
+	var pairs []*dep.KeyPair
+	for ; i < len(s.pairs) && strings.HasPrefix(s.pairs[i].Path, prefix); i++ {
+		p := *s.pairs[i]
+		p.Key = strings.TrimLeft(strings.TrimPrefix(p.Path, prefix), "/")
+		pairs = append(pairs, &p)
+	}
+	return pairs
+}
+
+// keys returns the keys and folders directly under the prefix, like a KV keys
+// listing with a "/" separator.
+func (s *snapshot) keys(prefix string) []string {
+	var paths []string
+	for _, p := range s.list(prefix) {
+		paths = append(paths, p.Path)
+	}
+
+	// Folders which only had deletions must still be expanded
+	for _, path := range s.deleted {
+		if strings.HasPrefix(path, prefix) {
+			paths = append(paths, path)
+		}
+	}
+	sort.Strings(paths)
+
+	var keys []string
+	seen := make(map[string]struct{})
+	for _, key := range paths {
+		if i := strings.Index(key[len(prefix):], "/"); i >= 0 {
+			key = key[:len(prefix)+i+1]
+		}
+		if _, ok := seen[key]; !ok {
+			seen[key] = struct{}{}
+			keys = append(keys, key)
+		}
+	}
+	return keys
+}
+
+// loadSnapshots reads the configured snapshot file, or takes a snapshot of
+// every source datacenter.
+func (r *Runner) loadSnapshots() error {
+	path := config.StringVal(r.config.Snapshot)
+	if path == "" {
+		return nil
+	}
+
+	snapshots := make(map[string]*snapshot)
+	if path != SnapshotConsul {
+		s, err := readSnapshotFile(path)
+		if err != nil {
+			return err
+		}
+		snapshots[""] = s
+	} else {
+		for _, prefix := range *r.config.Prefixes {
+			dcs := []string{config.StringVal(prefix.Datacenter)}
+			if prefix.IsDatacenterWildcard() {
+				var err error
+				if dcs, err = r.datacenters(prefix); err != nil {
+					return err
+				}
+			}
+
+			for _, dc := range dcs {
+				if _, ok := snapshots[dc]; ok {
+					continue
+				}
+
+				s, err := fetchSnapshot(r.clients.Consul(), dc)
+				if err != nil {
+					return err
+				}
+				snapshots[dc] = s
+			}
+		}
+	}
+
+	for dc, s := range snapshots {
+		log.Printf("[INFO] (runner) replaying snapshot of %q at index %d with %d keys",
+			dc, s.index, len(s.pairs))
+	}
+	r.snapshots = snapshots
+	return nil
+}
+
+// snapshotFor returns the snapshot to replay for the prefix, or nil if the
+// source is watched.
+func (r *Runner) snapshotFor(prefix *PrefixConfig) *snapshot {
+	if s, ok := r.snapshots[config.StringVal(prefix.Datacenter)]; ok {
+		return s
+	}
+
+	// A snapshot file is replayed for every datacenter
+	return r.snapshots[""]
+}
@@ -0,0 +1,139 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: MPL-2.0
+
+package replicate
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"crypto/sha256"
+	"fmt"
+	"io"
+	"reflect"
+	"testing"
+
+	dep "github.com/hashicorp/consul-template/dependency"
+	"github.com/hashicorp/consul/api"
+	"github.com/hashicorp/go-msgpack/codec"
+)
+
+// encodeSnapshot writes a snapshot archive in the format of "consul snapshot
+// save" with the given KV entries, preceded by a node registration.
+func encodeSnapshot(w io.Writer, index uint64, pairs []*api.KVPair) error {
+	var state bytes.Buffer
+	enc := codec.NewEncoder(&state, snapshotHandle)
+	if err := enc.Encode(map[string]uint64{"LastIndex": index}); err != nil {
+		return err
+	}
+
+	state.WriteByte(0)
+	if err := enc.Encode(map[string]interface{}{
+		"Node":    "node1",
+		"Address": "127.0.0.1",
+		"Meta":    map[string]string{"rack": "a"},
+	}); err != nil {
+		return err
+	}
+
+	for _, p := range pairs {
+		state.WriteByte(kvsRequestType)
+		if err := enc.Encode(map[string]interface{}{
+			"Key":         p.Key,
+			"Flags":       p.Flags,
+			"Value":       p.Value,
+			"CreateIndex": p.CreateIndex,
+			"ModifyIndex": p.ModifyIndex,
+		}); err != nil {
+			return err
+		}
+	}
+
+	sum := sha256.Sum256(state.Bytes())
+	files := []struct {
+		name string
+		data []byte
+	}{
+		{"meta.json", []byte(fmt.Sprintf(`{"Index":%d}`, index))},
+		{snapshotState, state.Bytes()},
+		{snapshotSums, []byte(fmt.Sprintf("%x  %s\n", sum, snapshotState))},
+	}
+
+	gz := gzip.NewWriter(w)
+	archive := tar.NewWriter(gz)
+	for _, f := range files {
+		if err := archive.WriteHeader(&tar.Header{
+			Name: f.name,
+			Mode: 0600,
+			Size: int64(len(f.data)),
+		}); err != nil {
+			return err
+		}
+		if _, err := archive.Write(f.data); err != nil {
+			return err
+		}
+	}
+	if err := archive.Close(); err != nil {
+		return err
+	}
+	return gz.Close()
+}
+
+func TestReadSnapshot(t *testing.T) {
+	var buf bytes.Buffer
+	if err := encodeSnapshot(&buf, 42, []*api.KVPair{
+		{Key: "foo/b", Value: []byte("2"), Flags: 3, CreateIndex: 5, ModifyIndex: 6},
+		{Key: "foo/a/x", Value: []byte("1"), CreateIndex: 7, ModifyIndex: 8},
+		{Key: "foobar", Value: []byte("3")},
+		{Key: "other", Value: []byte("4")},
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	s, err := readSnapshot(bytes.NewReader(buf.Bytes()))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if s.index != 42 {
+		t.Errorf("bad index: %d", s.index)
+	}
+
+	expected := []*dep.KeyPair{
+		{Path: "foo/a/x", Key: "a/x", Value: "1", CreateIndex: 7, ModifyIndex: 8},
+		{Path: "foo/b", Key: "b", Value: "2", Flags: 3, CreateIndex: 5, ModifyIndex: 6},
+	}
+	if act := s.list("foo/"); !reflect.DeepEqual(expected, act) {
+		t.Errorf("\nexp: %#v\nact: %#v", expected, act)
+	}
+
+	if exp, act := []string{"foo/a/", "foo/b"}, s.keys("foo/"); !reflect.DeepEqual(exp, act) {
+		t.Errorf("\nexp: %#v\nact: %#v", exp, act)
+	}
+
+	// Corrupting the state must fail the checksum
+	raw := buf.Bytes()
+	var corrupt bytes.Buffer
+	gz, _ := gzip.NewReader(bytes.NewReader(raw))
+	archive := tar.NewReader(gz)
+	gzw := gzip.NewWriter(&corrupt)
+	tw := tar.NewWriter(gzw)
+	for {
+		hdr, err := archive.Next()
+		if err == io.EOF {
+			break
+		}
+		data, _ := io.ReadAll(archive)
+		if hdr.Name == snapshotSums {
+			data = bytes.Replace(data, data[:4], []byte("0000"), 1)
+		}
+		tw.WriteHeader(hdr)
+		tw.Write(data)
+	}
+	tw.Close()
+	gzw.Close()
+
+	if _, err := readSnapshot(&corrupt); err == nil {
+		t.Error("expected checksum error")
+	}
+}
@@ -0,0 +1,85 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: MPL-2.0
+
+package replicate
+
+import (
+	"context"
+	"strings"
+
+	dep "github.com/hashicorp/consul-template/dependency"
+	"github.com/hashicorp/consul/api"
+)
+
+// Source is the KV store of the datacenters prefixes are replicated from.
+type Source interface {
+	// List returns the pairs under the given path in the datacenter, and the
+	// index they were read at. The Key of every pair is relative to the path.
+	List(path, datacenter string) ([]*dep.KeyPair, uint64, error)
+
+	// Keys returns the keys and folders directly under the given path in the
+	// datacenter, like a KV keys listing with a "/" separator.
+	Keys(path, datacenter string) ([]string, error)
+
+	// Datacenters returns the list of known datacenters.
+	Datacenters() ([]string, error)
+}
+
+// consulSource is a Source that reads from a Consul cluster. Prefixes of a
+// Consul source are watched with blocking queries instead of being listed.
+type consulSource struct {
+	client    *api.Client
+	ctx       context.Context
+	partition string
+}
+
+func newConsulSource(ctx context.Context, client *api.Client) *consulSource {
+	return &consulSource{client: client, ctx: ctx}
+}
+
+func (s *consulSource) inPartition(partition string) Source {
+	return &consulSource{client: s.client, ctx: s.ctx, partition: partition}
+}
+
+func (s *consulSource) List(path, datacenter string) ([]*dep.KeyPair, uint64, error) {
+	list, qm, err := s.client.KV().List(path, (&api.QueryOptions{
+		Datacenter: datacenter,
+	}).WithContext(withPartition(s.ctx, s.partition)))
+	if err != nil {
+		return nil, 0, err
+	}
+
+	pairs := make([]*dep.KeyPair, 0, len(list))
+	for _, pair := range list {
+		pairs = append(pairs, &dep.KeyPair{
+			Path:        pair.Key,
+			Key:         strings.TrimLeft(strings.TrimPrefix(pair.Key, path), "/"),
+			Value:       string(pair.Value),
+			CreateIndex: pair.CreateIndex,
+			ModifyIndex: pair.ModifyIndex,
+			LockIndex:   pair.LockIndex,
+			Flags:       pair.Flags,
+			Session:     pair.Session,
+		})
+	}
+	return pairs, qm.LastIndex, nil
+}
+
+func (s *consulSource) Keys(path, datacenter string) ([]string, error) {
+	keys, _, err := s.client.KV().Keys(path, "/", (&api.QueryOptions{
+		AllowStale: true,
+		Datacenter: datacenter,
+	}).WithContext(withPartition(s.ctx, s.partition)))
+	return keys, err
+}
+
+func (s *consulSource) Datacenters() ([]string, error) {
+	return s.client.Catalog().Datacenters()
+}
+
+// watched returns true if the source is watched with blocking queries. Other
+// sources, and snapshots, are listed once per replication pass instead.
+func (r *Runner) watched() bool {
+	_, ok := r.source.(*consulSource)
+	return ok && r.snapshots == nil
+}
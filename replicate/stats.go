@@ -0,0 +1,139 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: MPL-2.0
+
+package replicate
+
+import (
+	"sync"
+	"time"
+
+	"github.com/hashicorp/consul-template/config"
+)
+
+// PassStats is the summary of a pass, as kept in the history of its prefix.
+type PassStats struct {
+	// Time is when the pass finished, and Duration how long it took.
+	Time     time.Time
+	Duration time.Duration
+
+	// Index is the source index that was replicated.
+	Index uint64
+
+	// Scanned, Updates, Deletes, Skipped, Failed and Coalesced are the number
+	// of source keys read, keys written, keys deleted, source keys rejected by
+	// the key rules, keys which failed and source updates coalesced into the
+	// pass.
+	Scanned, Updates, Deletes, Skipped, Failed, Coalesced int
+
+	// Error is the error which stopped the pass, if any.
+	Error string
+}
+
+// PrefixStats is the history of the last passes of a prefix.
+type PrefixStats struct {
+	Source, Datacenter, Destination string
+
+	// Passes are the summaries of the last passes, oldest first.
+	Passes []*PassStats
+}
+
+// passRing is a ring buffer of the summaries of the last passes of a prefix.
+type passRing struct {
+	passes []*PassStats
+	next   int
+	full   bool
+}
+
+// add adds the summary of a pass, dropping the oldest one when full.
+func (r *passRing) add(p *PassStats) {
+	r.passes[r.next] = p
+	r.next = (r.next + 1) % len(r.passes)
+	if r.next == 0 {
+		r.full = true
+	}
+}
+
+// list returns the summaries of the passes, oldest first.
+func (r *passRing) list() []*PassStats {
+	if !r.full {
+		return append([]*PassStats(nil), r.passes[:r.next]...)
+	}
+	result := make([]*PassStats, 0, len(r.passes))
+	result = append(result, r.passes[r.next:]...)
+	return append(result, r.passes[:r.next]...)
+}
+
+// passHistory keeps the summaries of the last passes of every prefix in
+// memory, so they can be queried without a metrics stack.
+type passHistory struct {
+	sync.Mutex
+	size     int
+	prefixes map[string]*passRing
+}
+
+// newPassHistory returns a history of the given number of passes per prefix,
+// or nil if size is not positive.
+func newPassHistory(size int) *passHistory {
+	if size <= 0 {
+		return nil
+	}
+	return &passHistory{size: size, prefixes: make(map[string]*passRing)}
+}
+
+// add adds the summary of the pass of the event to the history of the prefix.
+func (h *passHistory) add(id string, e *Event) {
+	if h == nil {
+		return
+	}
+
+	p := &PassStats{
+		Time:      e.Time,
+		Duration:  e.Duration,
+		Index:     e.Index,
+		Scanned:   e.Scanned,
+		Updates:   e.Updates,
+		Deletes:   e.Deletes,
+		Skipped:   len(e.Skipped),
+		Failed:    len(e.Failures),
+		Coalesced: e.Coalesced,
+	}
+	if e.Err != nil {
+		p.Error = e.Err.Error()
+	}
+
+	h.Lock()
+	defer h.Unlock()
+	ring, ok := h.prefixes[id]
+	if !ok {
+		ring = &passRing{passes: make([]*PassStats, h.size)}
+		h.prefixes[id] = ring
+	}
+	ring.add(p)
+}
+
+// get returns the summaries of the last passes of the prefix, oldest first.
+func (h *passHistory) get(id string) []*PassStats {
+	if h == nil {
+		return nil
+	}
+	h.Lock()
+	defer h.Unlock()
+	if ring, ok := h.prefixes[id]; ok {
+		return ring.list()
+	}
+	return nil
+}
+
+// Stats returns the summaries of the last passes of every active prefix.
+func (r *Runner) Stats() []*PrefixStats {
+	var result []*PrefixStats
+	for _, prefix := range r.activePrefixes() {
+		result = append(result, &PrefixStats{
+			Source:      config.StringVal(prefix.Source),
+			Datacenter:  config.StringVal(prefix.Datacenter),
+			Destination: config.StringVal(prefix.Destination),
+			Passes:      r.history.get(prefix.Dependency.String()),
+		})
+	}
+	return result
+}
@@ -0,0 +1,97 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: MPL-2.0
+
+package replicate
+
+import (
+	"errors"
+	"fmt"
+	"reflect"
+	"testing"
+)
+
+func TestPassHistory(t *testing.T) {
+	cases := []struct {
+		name   string
+		size   int
+		passes int
+		exp    []uint64
+	}{
+		{
+			"disabled",
+			0,
+			3,
+			nil,
+		},
+		{
+			"empty",
+			3,
+			0,
+			nil,
+		},
+		{
+			"partial",
+			3,
+			2,
+			[]uint64{1, 2},
+		},
+		{
+			"full",
+			3,
+			3,
+			[]uint64{1, 2, 3},
+		},
+		{
+			"wraps",
+			3,
+			7,
+			[]uint64{5, 6, 7},
+		},
+	}
+
+	for i, tc := range cases {
+		t.Run(fmt.Sprintf("%d_%s", i, tc.name), func(t *testing.T) {
+			h := newPassHistory(tc.size)
+			for n := 1; n <= tc.passes; n++ {
+				h.add("global", &Event{Index: uint64(n)})
+				h.add("other", &Event{Index: uint64(n * 10)})
+			}
+
+			var act []uint64
+			for _, p := range h.get("global") {
+				act = append(act, p.Index)
+			}
+			if !reflect.DeepEqual(tc.exp, act) {
+				t.Errorf("\nexp: %#v\nact: %#v", tc.exp, act)
+			}
+		})
+	}
+}
+
+func TestPassHistory_summary(t *testing.T) {
+	h := newPassHistory(1)
+	h.add("global", &Event{
+		Index:     12,
+		Scanned:   5,
+		Updates:   2,
+		Deletes:   1,
+		Coalesced: 3,
+		Skipped:   map[string]string{"global/a": "too large"},
+		Failures:  map[string]string{"global/b": "denied"},
+		Err:       errors.New("boom"),
+	})
+
+	exp := []*PassStats{{
+		Index:     12,
+		Scanned:   5,
+		Updates:   2,
+		Deletes:   1,
+		Skipped:   1,
+		Failed:    1,
+		Coalesced: 3,
+		Error:     "boom",
+	}}
+	if act := h.get("global"); !reflect.DeepEqual(exp, act) {
+		t.Errorf("\nexp: %#v\nact: %#v", exp, act)
+	}
+}
@@ -0,0 +1,155 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: MPL-2.0
+
+package replicate
+
+import (
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/hashicorp/consul-template/config"
+	"github.com/hashicorp/consul/api"
+)
+
+// newStatusBackend returns the backend the statuses are stored in, or nil if
+// they are stored in the destination of each prefix.
+func newStatusBackend(c *Config) (Backend, error) {
+	switch name := config.StringVal(c.StatusBackend); name {
+	case StatusBackendConsul:
+		return nil, nil
+	case StatusBackendFile:
+		root := config.StringVal(c.StatusPath)
+		if root == "" {
+			return nil, fmt.Errorf("the %q status backend requires a status path", name)
+		}
+		if err := os.MkdirAll(root, 0700); err != nil {
+			return nil, err
+		}
+		return &fileBackend{root: root}, nil
+	case StatusBackendNone:
+		return &memoryBackend{pairs: make(map[string][]byte)}, nil
+	default:
+		return nil, fmt.Errorf("unknown status backend %q", name)
+	}
+}
+
+// statusBackend returns the backend the status keys of the prefix are stored
+// in.
+func (r *Runner) statusBackend(prefix *PrefixConfig) Backend {
+	if r.statusStore != nil {
+		return r.statusStore
+	}
+	return r.backend(prefix)
+}
+
+// fileBackend is a Backend that stores each key in a file of the same path
+// under a local directory.
+type fileBackend struct {
+	root string
+}
+
+func (b *fileBackend) path(key string) string {
+	return filepath.Join(b.root, filepath.FromSlash(key))
+}
+
+func (b *fileBackend) Get(key string) (*api.KVPair, error) {
+	value, err := os.ReadFile(b.path(key))
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &api.KVPair{Key: key, Value: value}, nil
+}
+
+func (b *fileBackend) Keys(prefix string) ([]string, error) {
+	var keys []string
+	err := filepath.WalkDir(b.root, func(p string, d fs.DirEntry, err error) error {
+		if err != nil || d.IsDir() || strings.HasSuffix(p, ".tmp") {
+			return err
+		}
+		rel, err := filepath.Rel(b.root, p)
+		if err != nil {
+			return err
+		}
+		if key := filepath.ToSlash(rel); strings.HasPrefix(key, prefix) {
+			keys = append(keys, key)
+		}
+		return nil
+	})
+	return keys, err
+}
+
+// Put writes the value to a temporary file first, so a crash never leaves a
+// partial status behind.
+func (b *fileBackend) Put(pair *api.KVPair) error {
+	p := b.path(pair.Key)
+	if err := os.MkdirAll(filepath.Dir(p), 0700); err != nil {
+		return err
+	}
+	if err := os.WriteFile(p+".tmp", pair.Value, 0600); err != nil {
+		return err
+	}
+	return os.Rename(p+".tmp", p)
+}
+
+func (b *fileBackend) Delete(key string) error {
+	if err := os.Remove(b.path(key)); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return nil
+}
+
+// memoryBackend is a Backend that only keeps the keys in memory, so they are
+// lost when the process exits.
+type memoryBackend struct {
+	sync.Mutex
+	pairs map[string][]byte
+}
+
+func (b *memoryBackend) Get(key string) (*api.KVPair, error) {
+	b.Lock()
+	defer b.Unlock()
+
+	value, ok := b.pairs[key]
+	if !ok {
+		return nil, nil
+	}
+	return &api.KVPair{Key: key, Value: value}, nil
+}
+
+func (b *memoryBackend) Keys(prefix string) ([]string, error) {
+	b.Lock()
+	defer b.Unlock()
+
+	var keys []string
+	for key := range b.pairs {
+		if strings.HasPrefix(key, prefix) {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+	return keys, nil
+}
+
+func (b *memoryBackend) Put(pair *api.KVPair) error {
+	b.Lock()
+	defer b.Unlock()
+
+	b.pairs[pair.Key] = pair.Value
+	return nil
+}
+
+func (b *memoryBackend) Delete(key string) error {
+	b.Lock()
+	defer b.Unlock()
+
+	delete(b.pairs, key)
+	return nil
+}
@@ -0,0 +1,226 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: MPL-2.0
+
+package replicate
+
+import (
+	"encoding/json"
+	"log"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/hashicorp/consul-template/config"
+	"github.com/pkg/errors"
+)
+
+// statusGCEnabled returns true if the status dir is garbage collected.
+func (r *Runner) statusGCEnabled() bool {
+	return config.BoolVal(r.config.StatusGC.Enabled)
+}
+
+// collectStatus periodically removes stale entries from the status dir. This
+// function blocks until the runner is stopped.
+func (r *Runner) collectStatus() {
+	ticker := time.NewTicker(config.TimeDurationVal(r.config.StatusGC.Interval))
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+		case <-r.stopCh:
+			return
+		}
+
+		// Only the leader writes to the destination
+		if r.haEnabled() && !r.isLeader() {
+			continue
+		}
+
+		keys, err := r.PruneStatus(config.TimeDurationVal(r.config.StatusGC.MaxAge), false)
+		if err != nil {
+			log.Printf("[WARN] (runner) failed to collect the status dir: %s", err)
+			continue
+		}
+		if len(keys) > 0 {
+			log.Printf("[INFO] (runner) removed %d stale keys from the status dir", len(keys))
+		}
+	}
+}
+
+// PruneStatus removes stale entries from the status dir of every destination
+// backend, or of the status backend if the statuses are stored elsewhere, and
+// returns their keys. The status, manifest, undo log and initial sync barrier
+// of a prefix are stale if the prefix is no longer configured and the status
+// was last updated longer than maxAge ago, and so is the claim of a destination
+// no configured prefix writes to. Shard membership keys are stale if the
+// session of their instance is gone. With dryRun, the stale keys are only
+// returned.
+func (r *Runner) PruneStatus(maxAge time.Duration, dryRun bool) ([]string, error) {
+	known, err := r.statusPaths()
+	if err != nil {
+		return nil, errors.Wrap(err, "status gc")
+	}
+
+	dir := strings.TrimRight(config.StringVal(r.config.StatusDir), "/") + "/"
+	manifests := dir + "manifests/"
+	undos := dir + "undo/"
+	barriers := dir + "initial-sync-complete/"
+	owners := dir + "owners/"
+	members := r.membersPrefix()
+	cutoff := time.Now().Add(-maxAge)
+
+	backends := r.backends
+	if r.statusStore != nil {
+		backends = map[string]Backend{config.StringVal(r.config.StatusBackend): r.statusStore}
+	}
+	names := make([]string, 0, len(backends))
+	for name := range backends {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var pruned []string
+	for _, name := range names {
+		backend := backends[name]
+		keys, err := backend.Keys(dir)
+		if err != nil {
+			return pruned, errors.Wrapf(err, "status gc: listing %q", dir)
+		}
+
+		for _, key := range keys {
+			var stale bool
+			switch {
+			case strings.HasPrefix(key, members):
+				pair, err := backend.Get(key)
+				if err != nil {
+					return pruned, errors.Wrapf(err, "status gc: reading %q", key)
+				}
+				stale = pair != nil && pair.Session == ""
+			case strings.HasPrefix(key, owners):
+				if _, ok := known[key]; ok {
+					continue
+				}
+
+				var o Owner
+				if stale, err = staleEntry(backend, key, &o, &o.LastUpdated, cutoff); err != nil {
+					return pruned, err
+				}
+			case strings.HasPrefix(key, manifests):
+				if strings.Contains(strings.TrimPrefix(key, manifests), "/") {
+					continue
+				}
+				if _, ok := known[dir+strings.TrimPrefix(key, manifests)]; ok {
+					continue
+				}
+
+				var m Manifest
+				if stale, err = staleEntry(backend, key, &m, &m.Timestamp, cutoff); err != nil {
+					return pruned, err
+				}
+			case strings.HasPrefix(key, undos):
+				if strings.Contains(strings.TrimPrefix(key, undos), "/") {
+					continue
+				}
+				if _, ok := known[dir+strings.TrimPrefix(key, undos)]; ok {
+					continue
+				}
+
+				var u UndoLog
+				if stale, err = staleEntry(backend, key, &u, &u.LastUpdated, cutoff); err != nil {
+					return pruned, err
+				}
+			case strings.HasPrefix(key, barriers):
+				if strings.Contains(strings.TrimPrefix(key, barriers), "/") {
+					continue
+				}
+				if _, ok := known[dir+strings.TrimPrefix(key, barriers)]; ok {
+					continue
+				}
+
+				var s InitialSync
+				if stale, err = staleEntry(backend, key, &s, &s.Timestamp, cutoff); err != nil {
+					return pruned, err
+				}
+			default:
+				// Only status keys and the HA lock live directly in the status dir
+				if strings.Contains(strings.TrimPrefix(key, dir), "/") || key == r.lockKey() {
+					continue
+				}
+				if _, ok := known[key]; ok {
+					continue
+				}
+
+				// Statuses written by older versions have no time, but their
+				// manifest has
+				var s Status
+				if stale, err = staleEntry(backend, key, &s, &s.LastUpdated, cutoff); err != nil {
+					return pruned, err
+				}
+				if s.LastUpdated.IsZero() {
+					var m Manifest
+					manifest := manifests + strings.TrimPrefix(key, dir)
+					if stale, err = staleEntry(backend, manifest, &m, &m.Timestamp, cutoff); err != nil {
+						return pruned, err
+					}
+				}
+			}
+			if !stale {
+				continue
+			}
+
+			if !dryRun {
+				if err := backend.Delete(key); err != nil {
+					return pruned, errors.Wrapf(err, "status gc: deleting %q", key)
+				}
+				log.Printf("[DEBUG] (runner) removed stale key %q", key)
+			}
+			pruned = append(pruned, key)
+		}
+	}
+	return pruned, nil
+}
+
+// statusPaths returns the status keys of every configured prefix, and the
+// claims of their destinations, expanding wildcard prefixes. Canary prefixes
+// also have the status of their staging destination.
+func (r *Runner) statusPaths() (map[string]struct{}, error) {
+	paths := make(map[string]struct{})
+	for _, prefix := range *r.config.Prefixes {
+		prefixes := []*PrefixConfig{prefix}
+		if prefix.IsWildcard() {
+			var err error
+			if prefixes, err = r.expand(prefix); err != nil {
+				return nil, err
+			}
+		}
+
+		for _, p := range prefixes {
+			paths[r.statusPath(p)] = struct{}{}
+			for _, destination := range routeDestinations(p) {
+				paths[r.ownerPath(destination)] = struct{}{}
+			}
+			if config.BoolVal(p.Canary.Enabled) {
+				staged := p.Copy()
+				staged.Destination = config.String(stagingDestination(p))
+				paths[r.statusPath(staged)] = struct{}{}
+				paths[r.ownerPath(stagingDestination(p))] = struct{}{}
+			}
+		}
+	}
+	return paths, nil
+}
+
+// staleEntry decodes the JSON at the key into v, and returns true if the time
+// it holds is before the cutoff. Keys which cannot be decoded, or without a
+// time, are never stale.
+func staleEntry(backend Backend, key string, v interface{}, t *time.Time, cutoff time.Time) (bool, error) {
+	pair, err := backend.Get(key)
+	if err != nil {
+		return false, errors.Wrapf(err, "status gc: reading %q", key)
+	}
+	if pair == nil || json.Unmarshal(pair.Value, v) != nil || t.IsZero() {
+		return false, nil
+	}
+	return t.Before(cutoff), nil
+}
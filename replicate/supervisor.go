@@ -0,0 +1,599 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: MPL-2.0
+
+package replicate
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"reflect"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/hashicorp/consul-template/config"
+	multierror "github.com/hashicorp/go-multierror"
+)
+
+// groupRestartDelay is how long a failed replication group waits before it
+// is restarted.
+const groupRestartDelay = 10 * time.Second
+
+// Supervisor runs the replication groups of a configuration, each with its
+// own runner. Top-level prefixes form an unnamed group, and every replicator
+// block a named group. When replicator blocks are configured, a failing group
+// is restarted without affecting the others; otherwise the error of the only
+// group is reported on ErrCh, like a Runner.
+type Supervisor struct {
+	sync.Mutex
+
+	// ErrCh and DoneCh are channels where errors and finish notifications occur.
+	ErrCh  chan error
+	DoneCh chan struct{}
+
+	config *Config
+	once   bool
+
+	// groups are the running groups, keyed by name.
+	groups map[string]*group
+
+	// resultCh receives the outcome of every group in once mode.
+	resultCh chan error
+
+	// inherited is the state handed over by the process this one replaced,
+	// which the first runner of every group takes over.
+	inherited     *HandoverState
+	inheritedLock sync.Mutex
+}
+
+// group is a replication group and its runner.
+type group struct {
+	name   string
+	labels map[string]string
+	config *Config
+	paused bool
+
+	// isolated is true if failures are restarted instead of reported.
+	isolated bool
+
+	// stopCh is closed to stop the group, and doneCh is closed once it has.
+	stopCh, doneCh chan struct{}
+
+	// runner is the running runner of the group, if any.
+	runner     *Runner
+	runnerLock sync.Mutex
+}
+
+// ReplicatorStatus is the status of a replication group.
+type ReplicatorStatus struct {
+	Name     string
+	Labels   map[string]string
+	Paused   bool
+	Prefixes []*PrefixStatus
+
+	// ConfigChecksum is the checksum of the configuration of the running
+	// runner of the group.
+	ConfigChecksum string
+}
+
+// ReplicatorStats is the history of the last passes of the prefixes of a
+// group.
+type ReplicatorStats struct {
+	Name     string
+	Labels   map[string]string
+	Prefixes []*PrefixStats
+}
+
+// ReplicatorCost is the load the prefixes of a group put on the Consul
+// servers.
+type ReplicatorCost struct {
+	Name     string
+	Labels   map[string]string
+	Prefixes []*PrefixCost
+}
+
+// PendingPrefix is a prefix whose initial sync is not complete. Source,
+// Datacenter and Destination are empty for a group which is not running or has
+// not discovered its prefixes yet.
+type PendingPrefix struct {
+	Replicator                      string
+	Source, Datacenter, Destination string
+}
+
+// NewSupervisor creates a supervisor for the given finalized configuration.
+func NewSupervisor(c *Config, once bool) *Supervisor {
+	return &Supervisor{
+		ErrCh:  make(chan error, 1),
+		DoneCh: make(chan struct{}, 1),
+		config: c,
+
+		// Snapshots are replayed in a single pass
+		once: once || config.StringVal(c.Snapshot) != "",
+
+		groups:   make(map[string]*group),
+		resultCh: make(chan error),
+	}
+}
+
+// Start starts every group. In once mode it waits for every group to finish a
+// single pass.
+func (s *Supervisor) Start() {
+	log.Printf("[INFO] (supervisor) starting")
+
+	if err := storePid(config.StringVal(s.config.PidFile)); err != nil {
+		s.ErrCh <- err
+		return
+	}
+
+	s.Lock()
+	groups := newGroups(s.config)
+	for _, g := range groups {
+		s.startGroup(g)
+	}
+	s.Unlock()
+
+	if !s.once {
+		return
+	}
+
+	var errs *multierror.Error
+	for _, g := range groups {
+		if g.paused {
+			continue
+		}
+		if err := <-s.resultCh; err != nil {
+			errs = multierror.Append(errs, err)
+		}
+	}
+	if err := errs.ErrorOrNil(); err != nil {
+		s.ErrCh <- err
+		return
+	}
+	s.DoneCh <- struct{}{}
+}
+
+// Stop stops every group.
+func (s *Supervisor) Stop() {
+	log.Printf("[INFO] (supervisor) stopping")
+
+	s.Lock()
+	for name, g := range s.groups {
+		s.stopGroup(g)
+		delete(s.groups, name)
+	}
+	s.Unlock()
+
+	if err := deletePid(config.StringVal(s.config.PidFile)); err != nil {
+		log.Printf("[WARN] (supervisor) could not remove pid at %q: %s",
+			config.StringVal(s.config.PidFile), err)
+	}
+}
+
+// Reload applies a new finalized configuration. Only groups whose
+// configuration changed are restarted, so other groups are not interrupted.
+func (s *Supervisor) Reload(c *Config) {
+	s.Lock()
+	defer s.Unlock()
+
+	groups := newGroups(c)
+
+	for name, g := range s.groups {
+		n, ok := groups[name]
+		if ok && n.paused == g.paused && n.isolated == g.isolated &&
+			reflect.DeepEqual(n.labels, g.labels) &&
+			n.config.GoString() == g.config.GoString() {
+			// Unchanged groups keep running
+			delete(groups, name)
+			continue
+		}
+
+		log.Printf("[INFO] (supervisor) reloading replicator %q", name)
+		s.stopGroup(g)
+		delete(s.groups, name)
+	}
+
+	for _, g := range groups {
+		s.startGroup(g)
+	}
+	s.config = c
+}
+
+// Pause stops replication of the named group until it is resumed.
+func (s *Supervisor) Pause(name string) error {
+	s.Lock()
+	defer s.Unlock()
+
+	g, ok := s.groups[name]
+	if !ok {
+		return fmt.Errorf("supervisor: unknown replicator %q", name)
+	}
+	if g.paused {
+		return nil
+	}
+
+	log.Printf("[INFO] (supervisor) pausing replicator %q", name)
+	s.stopGroup(g)
+	g.paused = true
+	s.startGroup(g)
+	return nil
+}
+
+// Resume restarts replication of the named paused group.
+func (s *Supervisor) Resume(name string) error {
+	s.Lock()
+	defer s.Unlock()
+
+	g, ok := s.groups[name]
+	if !ok {
+		return fmt.Errorf("supervisor: unknown replicator %q", name)
+	}
+	if !g.paused {
+		return nil
+	}
+
+	log.Printf("[INFO] (supervisor) resuming replicator %q", name)
+	g.paused = false
+	s.startGroup(g)
+	return nil
+}
+
+// Replicators returns the names of the groups and whether they are paused.
+func (s *Supervisor) Replicators() map[string]bool {
+	s.Lock()
+	defer s.Unlock()
+
+	result := make(map[string]bool, len(s.groups))
+	for name, g := range s.groups {
+		result[name] = g.paused
+	}
+	return result
+}
+
+// Status returns the status of every group, in order of name.
+func (s *Supervisor) Status() ([]*ReplicatorStatus, error) {
+	s.Lock()
+	result := make([]*ReplicatorStatus, 0, len(s.groups))
+	runners := make(map[string]*Runner, len(s.groups))
+	for name, g := range s.groups {
+		result = append(result, &ReplicatorStatus{
+			Name:   name,
+			Labels: g.labels,
+			Paused: g.paused,
+		})
+		runners[name] = g.currentRunner()
+	}
+	s.Unlock()
+
+	sort.Slice(result, func(i, j int) bool {
+		return result[i].Name < result[j].Name
+	})
+
+	// Statuses are read from the destinations without holding the lock
+	for _, status := range result {
+		runner := runners[status.Name]
+		if runner == nil {
+			continue
+		}
+
+		prefixes, err := runner.Status()
+		if err != nil {
+			return nil, fmt.Errorf("supervisor: replicator %q: %s", status.Name, err)
+		}
+		status.Prefixes = prefixes
+		status.ConfigChecksum = runner.checksum
+	}
+	return result, nil
+}
+
+// Pending returns the prefixes of every group whose initial sync is not
+// complete, and the groups which are not running or have not discovered their
+// prefixes yet. Paused groups are never pending.
+func (s *Supervisor) Pending() ([]*PendingPrefix, error) {
+	s.Lock()
+	names := make([]string, 0, len(s.groups))
+	runners := make(map[string]*Runner, len(s.groups))
+	for name, g := range s.groups {
+		if g.paused {
+			continue
+		}
+		names = append(names, name)
+		runners[name] = g.currentRunner()
+	}
+	s.Unlock()
+	sort.Strings(names)
+
+	var result []*PendingPrefix
+	for _, name := range names {
+		runner := runners[name]
+		if runner == nil || !runner.discovered() {
+			result = append(result, &PendingPrefix{Replicator: name})
+			continue
+		}
+
+		prefixes, err := runner.Status()
+		if err != nil {
+			return nil, fmt.Errorf("supervisor: replicator %q: %s", name, err)
+		}
+		for _, p := range prefixes {
+			if p.InitialSyncComplete {
+				continue
+			}
+			result = append(result, &PendingPrefix{
+				Replicator:  name,
+				Source:      p.Source,
+				Datacenter:  p.Datacenter,
+				Destination: p.Destination,
+			})
+		}
+	}
+	return result, nil
+}
+
+// Stats returns the history of the last passes of every group. Groups which
+// are not running have no prefixes.
+func (s *Supervisor) Stats() []*ReplicatorStats {
+	s.Lock()
+	result := make([]*ReplicatorStats, 0, len(s.groups))
+	for name, g := range s.groups {
+		stats := &ReplicatorStats{Name: name, Labels: g.labels}
+		if runner := g.currentRunner(); runner != nil {
+			stats.Prefixes = runner.Stats()
+		}
+		result = append(result, stats)
+	}
+	s.Unlock()
+
+	sort.Slice(result, func(i, j int) bool {
+		return result[i].Name < result[j].Name
+	})
+	return result
+}
+
+// Cost returns the load the prefixes of every group put on the Consul servers
+// since their runner started. Groups which are not running have no prefixes.
+func (s *Supervisor) Cost() []*ReplicatorCost {
+	s.Lock()
+	result := make([]*ReplicatorCost, 0, len(s.groups))
+	for name, g := range s.groups {
+		cost := &ReplicatorCost{Name: name, Labels: g.labels}
+		if runner := g.currentRunner(); runner != nil {
+			cost.Prefixes = runner.Cost()
+		}
+		result = append(result, cost)
+	}
+	s.Unlock()
+
+	sort.Slice(result, func(i, j int) bool {
+		return result[i].Name < result[j].Name
+	})
+	return result
+}
+
+// Resync replicates every key of the named group again.
+func (s *Supervisor) Resync(name string) error {
+	s.Lock()
+	g, ok := s.groups[name]
+	s.Unlock()
+	if !ok {
+		return fmt.Errorf("supervisor: unknown replicator %q", name)
+	}
+
+	runner := g.currentRunner()
+	if runner == nil {
+		return fmt.Errorf("supervisor: replicator %q is not running", name)
+	}
+
+	log.Printf("[INFO] (supervisor) resyncing replicator %q", name)
+	runner.Resync()
+	return nil
+}
+
+// RotateTokens reads the token files of every running group again, and swaps
+// the tokens which changed.
+func (s *Supervisor) RotateTokens() error {
+	return s.eachRunner((*Runner).RotateTokens)
+}
+
+// RotateCerts reads the certificate files of every running group again, and
+// swaps the transports whose certificates changed.
+func (s *Supervisor) RotateCerts() error {
+	return s.eachRunner((*Runner).RotateCerts)
+}
+
+// eachRunner calls fn with the current runner of every running group, in the
+// order of their names, and returns the errors of every call.
+func (s *Supervisor) eachRunner(fn func(*Runner) error) error {
+	s.Lock()
+	names := make([]string, 0, len(s.groups))
+	runners := make(map[string]*Runner, len(s.groups))
+	for name, g := range s.groups {
+		if runner := g.currentRunner(); runner != nil {
+			names = append(names, name)
+			runners[name] = runner
+		}
+	}
+	s.Unlock()
+	sort.Strings(names)
+
+	var errs *multierror.Error
+	for _, name := range names {
+		if err := fn(runners[name]); err != nil {
+			errs = multierror.Append(errs, fmt.Errorf("replicator %q: %s", name, err))
+		}
+	}
+	return errs.ErrorOrNil()
+}
+
+// SetPrefixes replaces the prefixes of the named group, or of the top-level
+// group if the name is empty, and restarts it. The prefixes are kept until the
+// configuration is next reloaded.
+func (s *Supervisor) SetPrefixes(name string, prefixes *PrefixConfigs) error {
+	s.Lock()
+	c := s.config.Copy()
+	s.Unlock()
+
+	if name == "" {
+		c.Prefixes = prefixes
+	} else {
+		var found bool
+		for _, rc := range *c.Replicators {
+			if config.StringVal(rc.Name) == name {
+				rc.Config.Prefixes = prefixes
+				found = true
+			}
+		}
+		if !found {
+			return fmt.Errorf("supervisor: unknown replicator %q", name)
+		}
+	}
+	c.Finalize()
+
+	log.Printf("[INFO] (supervisor) setting %d prefix(es) of replicator %q",
+		len(*prefixes), name)
+	s.Reload(c)
+	return nil
+}
+
+// newGroups returns the groups of the configuration, keyed by name.
+func newGroups(c *Config) map[string]*group {
+	isolated := len(*c.Replicators) > 0
+
+	groups := make(map[string]*group)
+	if !isolated || len(*c.Prefixes) > 0 {
+		d := c.Copy()
+		d.PidFile = config.String("")
+		d.Replicators = DefaultReplicatorConfigs()
+		groups[""] = &group{config: d, isolated: isolated}
+	}
+
+	for _, rc := range *c.Replicators {
+		name := config.StringVal(rc.Name)
+		groups[name] = &group{
+			name:     name,
+			labels:   rc.Labels,
+			config:   c.Replicator(rc),
+			paused:   config.BoolVal(rc.Paused),
+			isolated: isolated,
+		}
+	}
+	return groups
+}
+
+// startGroup starts the group. The caller must hold the lock.
+func (s *Supervisor) startGroup(g *group) {
+	g.stopCh, g.doneCh = make(chan struct{}), make(chan struct{})
+	s.groups[g.name] = g
+
+	if g.paused {
+		log.Printf("[INFO] (supervisor) replicator %q is paused", g.name)
+		close(g.doneCh)
+		return
+	}
+	go s.run(g)
+}
+
+// stopGroup stops the group and waits for its runner to exit. The caller must
+// hold the lock.
+func (s *Supervisor) stopGroup(g *group) {
+	close(g.stopCh)
+	<-g.doneCh
+}
+
+// run runs the group, restarting its runner after failures if groups are
+// isolated.
+func (s *Supervisor) run(g *group) {
+	defer close(g.doneCh)
+
+	for {
+		runner, err := NewRunner(g.config, s.once)
+		if err != nil {
+			// Configuration errors are not transient, so they are never
+			// restarted
+			s.report(g, err)
+			return
+		}
+
+		if h := s.inheritedGroup(g.name); h != nil {
+			runner.inherit(h)
+		}
+
+		err = s.runOnce(g, runner)
+		if err == nil {
+			return
+		}
+
+		if s.once || !g.isolated {
+			s.report(g, err)
+			return
+		}
+
+		log.Printf("[ERR] (supervisor) replicator %q failed, restarting in %s: %s",
+			g.name, groupRestartDelay, err)
+		select {
+		case <-time.After(groupRestartDelay):
+		case <-g.stopCh:
+			return
+		}
+	}
+}
+
+// runOnce runs a runner for the group until it fails, finishes or the group is
+// stopped. A nil error means the group should not be restarted.
+func (s *Supervisor) runOnce(g *group, runner *Runner) error {
+	runner.replicator, runner.labels = g.name, g.labels
+	g.setRunner(runner)
+	defer g.setRunner(nil)
+	go runner.Start()
+
+	select {
+	case err := <-runner.ErrCh:
+		runner.Stop()
+		return err
+	case <-runner.DoneCh:
+		runner.Stop()
+		if s.once {
+			s.resultCh <- nil
+		}
+		return nil
+	case <-g.stopCh:
+		runner.Stop()
+		// Start waits for the result of every group in once mode
+		if s.once {
+			s.report(g, errors.New("stopped before its pass finished"))
+		}
+		return nil
+	}
+}
+
+// report reports the error which stopped the group.
+func (s *Supervisor) report(g *group, err error) {
+	if g.name != "" {
+		err = fmt.Errorf("replicator %q: %s", g.name, err)
+	}
+
+	if s.once {
+		s.resultCh <- err
+		return
+	}
+
+	select {
+	case s.ErrCh <- err:
+	default:
+		log.Printf("[ERR] (supervisor) %s", err)
+	}
+}
+
+// currentRunner returns the running runner of the group, if any.
+func (g *group) currentRunner() *Runner {
+	g.runnerLock.Lock()
+	defer g.runnerLock.Unlock()
+	return g.runner
+}
+
+// setRunner records the running runner of the group.
+func (g *group) setRunner(r *Runner) {
+	g.runnerLock.Lock()
+	defer g.runnerLock.Unlock()
+	g.runner = r
+}
@@ -0,0 +1,241 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: MPL-2.0
+
+package replicate
+
+import (
+	"fmt"
+	"log"
+	"net"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	metrics "github.com/armon/go-metrics"
+	"github.com/hashicorp/consul-template/config"
+	"github.com/pkg/errors"
+)
+
+// dogstatsdSink sends metrics to a dogstatsd agent, with their labels and the
+// global tags as tags.
+type dogstatsdSink struct {
+	conn net.Conn
+	tags []string
+}
+
+func newDogstatsdSink(addr string, tags []string) (*dogstatsdSink, error) {
+	conn, err := net.Dial("udp", addr)
+	if err != nil {
+		return nil, errors.Wrap(err, "dogstatsd")
+	}
+	return &dogstatsdSink{conn: conn, tags: tags}, nil
+}
+
+var (
+	// dogstatsdReplacer replaces the characters the dogstatsd protocol
+	// reserves in names, and dogstatsdValueReplacer in the values of tags.
+	dogstatsdReplacer      = strings.NewReplacer(":", "_", "|", "_", "@", "_", ",", "_", "#", "_", " ", "_")
+	dogstatsdValueReplacer = strings.NewReplacer("|", "_", ",", "_", "#", "_")
+)
+
+// send sends a single metric, without waiting for the agent. Metrics which
+// cannot be sent are dropped.
+func (s *dogstatsdSink) send(key []string, val float32, kind string, labels []metrics.Label) {
+	var b strings.Builder
+	b.WriteString(dogstatsdReplacer.Replace(strings.Join(key, ".")))
+	b.WriteString(":")
+	b.WriteString(strconv.FormatFloat(float64(val), 'f', -1, 32))
+	b.WriteString("|")
+	b.WriteString(kind)
+
+	tags := append([]string{}, s.tags...)
+	for _, l := range labels {
+		tags = append(tags, dogstatsdReplacer.Replace(l.Name)+":"+
+			dogstatsdValueReplacer.Replace(l.Value))
+	}
+	if len(tags) > 0 {
+		b.WriteString("|#")
+		b.WriteString(strings.Join(tags, ","))
+	}
+
+	if _, err := s.conn.Write([]byte(b.String())); err != nil {
+		log.Printf("[DEBUG] (runner) could not send metric %q to dogstatsd: %s",
+			strings.Join(key, "."), err)
+	}
+}
+
+func (s *dogstatsdSink) SetGauge(key []string, val float32) {
+	s.send(key, val, "g", nil)
+}
+
+func (s *dogstatsdSink) SetGaugeWithLabels(key []string, val float32, labels []metrics.Label) {
+	s.send(key, val, "g", labels)
+}
+
+func (s *dogstatsdSink) EmitKey(key []string, val float32) {
+	s.send(key, val, "g", nil)
+}
+
+func (s *dogstatsdSink) IncrCounter(key []string, val float32) {
+	s.send(key, val, "c", nil)
+}
+
+func (s *dogstatsdSink) IncrCounterWithLabels(key []string, val float32, labels []metrics.Label) {
+	s.send(key, val, "c", labels)
+}
+
+func (s *dogstatsdSink) AddSample(key []string, val float32) {
+	s.send(key, val, "ms", nil)
+}
+
+func (s *dogstatsdSink) AddSampleWithLabels(key []string, val float32, labels []metrics.Label) {
+	s.send(key, val, "ms", labels)
+}
+
+func (s *dogstatsdSink) Shutdown() {
+	s.conn.Close()
+}
+
+// telemetry emits the metrics of the replication passes of a runner.
+type telemetry struct {
+	metrics      *metrics.Metrics
+	sinks        []interface{ Shutdown() }
+	prefixLabels bool
+}
+
+// newTelemetry creates the sinks of the telemetry config, if any.
+func newTelemetry(c *TelemetryConfig) (*telemetry, error) {
+	if !c.Enabled() {
+		return nil, nil
+	}
+
+	for _, tag := range c.DogstatsdTags {
+		if !strings.Contains(tag, ":") || strings.ContainsAny(tag, "|,#") {
+			return nil, fmt.Errorf("telemetry: invalid dogstatsd tag %q, expected "+
+				"\"key:value\"", tag)
+		}
+	}
+
+	t := &telemetry{prefixLabels: config.BoolVal(c.PrefixLabels)}
+	var fanout metrics.FanoutSink
+	if addr := config.StringVal(c.StatsdAddress); addr != "" {
+		sink, err := metrics.NewStatsdSink(addr)
+		if err != nil {
+			return nil, errors.Wrap(err, "telemetry: statsd")
+		}
+		fanout = append(fanout, sink)
+		t.sinks = append(t.sinks, sink)
+	}
+	if addr := config.StringVal(c.DogstatsdAddr); addr != "" {
+		sink, err := newDogstatsdSink(addr, c.DogstatsdTags)
+		if err != nil {
+			t.shutdown()
+			return nil, errors.Wrap(err, "telemetry")
+		}
+		fanout = append(fanout, sink)
+		t.sinks = append(t.sinks, sink)
+	}
+
+	// Runtime metrics are collected by a goroutine which cannot be stopped,
+	// and would leak on every reload
+	mc := metrics.DefaultConfig(config.StringVal(c.MetricsPrefix))
+	mc.EnableHostname = !config.BoolVal(c.DisableHostname)
+	mc.EnableRuntimeMetrics = false
+	m, err := metrics.New(mc, fanout)
+	if err != nil {
+		t.shutdown()
+		return nil, errors.Wrap(err, "telemetry")
+	}
+	t.metrics = m
+	return t, nil
+}
+
+// pass emits the metrics of the replication pass of the event, which started
+// at the given time. They are labeled with the replicator and its labels.
+func (t *telemetry) pass(e *Event, start time.Time) {
+	if t == nil {
+		return
+	}
+
+	labels := t.labels(e)
+	status := "success"
+	if e.Err != nil {
+		status = "failure"
+	}
+	t.metrics.IncrCounterWithLabels([]string{"passes"}, 1,
+		append(labels[:len(labels):len(labels)], metrics.Label{Name: "status", Value: status}))
+	t.metrics.MeasureSinceWithLabels([]string{"pass", "time"}, start, labels)
+	if e.Err != nil {
+		return
+	}
+
+	t.metrics.IncrCounterWithLabels([]string{"keys", "updated"}, float32(e.Updates), labels)
+	t.metrics.IncrCounterWithLabels([]string{"keys", "deleted"}, float32(e.Deletes), labels)
+	t.metrics.IncrCounterWithLabels([]string{"keys", "failed"}, float32(len(e.Failures)), labels)
+	if t.prefixLabels {
+		t.metrics.SetGaugeWithLabels([]string{"index"}, float32(e.Index), labels)
+	}
+}
+
+// credentials emits whether the destination denied the token in the pass of
+// the event, as 1 while the runner is in the credentials invalid state and 0
+// otherwise.
+func (t *telemetry) credentials(e *Event, denied bool) {
+	if t == nil {
+		return
+	}
+
+	var value float32
+	if denied {
+		value = 1
+	}
+	t.metrics.SetGaugeWithLabels([]string{"credentials", "invalid"}, value, t.labels(e))
+}
+
+// drift counts a destination key of the prefix of the event changed by
+// something other than the replicator, labeled with the op of the change.
+func (t *telemetry) drift(e *Event, op string) {
+	if t == nil {
+		return
+	}
+
+	labels := t.labels(e)
+	t.metrics.IncrCounterWithLabels([]string{"keys", "drifted"}, 1,
+		append(labels[:len(labels):len(labels)], metrics.Label{Name: "op", Value: op}))
+}
+
+// labels returns the labels of the metrics of the pass of the event: the
+// replicator and its labels, and the prefix if enabled.
+func (t *telemetry) labels(e *Event) []metrics.Label {
+	var labels []metrics.Label
+	if e.Replicator != "" {
+		labels = append(labels, metrics.Label{Name: "replicator", Value: e.Replicator})
+	}
+	keys := make([]string, 0, len(e.Labels))
+	for k := range e.Labels {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		labels = append(labels, metrics.Label{Name: k, Value: e.Labels[k]})
+	}
+	if t.prefixLabels {
+		labels = append(labels, metrics.Label{Name: "source", Value: e.Source})
+		if e.Datacenter != "" {
+			labels = append(labels, metrics.Label{Name: "datacenter", Value: e.Datacenter})
+		}
+		labels = append(labels, metrics.Label{Name: "destination", Value: e.Destination})
+	}
+	return labels
+}
+
+// shutdown flushes and closes the sinks.
+func (t *telemetry) shutdown() {
+	if t == nil {
+		return
+	}
+	for _, sink := range t.sinks {
+		sink.Shutdown()
+	}
+}
@@ -0,0 +1,171 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: MPL-2.0
+
+package replicate
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/hashicorp/consul-template/config"
+)
+
+func TestTelemetry_pass(t *testing.T) {
+	cases := []struct {
+		name         string
+		prefixLabels bool
+		event        *Event
+		exp          []string
+	}{
+		{
+			"success",
+			true,
+			&Event{
+				Replicator:  "dc2",
+				Labels:      map[string]string{"team": "infra"},
+				Source:      "global/",
+				Datacenter:  "dc1",
+				Destination: "global/",
+				Updates:     3,
+				Deletes:     1,
+				Index:       42,
+			},
+			[]string{
+				"test.index:42|g|#env:test,replicator:dc2,team:infra,source:global/,datacenter:dc1,destination:global/",
+				"test.keys.deleted:1|c|#env:test,replicator:dc2,team:infra,source:global/,datacenter:dc1,destination:global/",
+				"test.keys.failed:0|c|#env:test,replicator:dc2,team:infra,source:global/,datacenter:dc1,destination:global/",
+				"test.keys.updated:3|c|#env:test,replicator:dc2,team:infra,source:global/,datacenter:dc1,destination:global/",
+				"test.pass.time:*|ms|#env:test,replicator:dc2,team:infra,source:global/,datacenter:dc1,destination:global/",
+				"test.passes:1|c|#env:test,replicator:dc2,team:infra,source:global/,datacenter:dc1,destination:global/,status:success",
+			},
+		},
+		{
+			"failure",
+			true,
+			&Event{
+				Source:      "global/",
+				Destination: "global/",
+				Err:         errors.New("boom"),
+			},
+			[]string{
+				"test.pass.time:*|ms|#env:test,source:global/,destination:global/",
+				"test.passes:1|c|#env:test,source:global/,destination:global/,status:failure",
+			},
+		},
+		{
+			"no_prefix_labels",
+			false,
+			&Event{
+				Source:      "global/",
+				Destination: "global/",
+				Updates:     2,
+			},
+			[]string{
+				"test.keys.deleted:0|c|#env:test",
+				"test.keys.failed:0|c|#env:test",
+				"test.keys.updated:2|c|#env:test",
+				"test.pass.time:*|ms|#env:test",
+				"test.passes:1|c|#env:test,status:success",
+			},
+		},
+	}
+
+	for i, tc := range cases {
+		t.Run(fmt.Sprintf("%d_%s", i, tc.name), func(t *testing.T) {
+			conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer conn.Close()
+
+			c := &TelemetryConfig{
+				DisableHostname: config.Bool(true),
+				DogstatsdAddr:   config.String(conn.LocalAddr().String()),
+				DogstatsdTags:   []string{"env:test"},
+				MetricsPrefix:   config.String("test"),
+				PrefixLabels:    config.Bool(tc.prefixLabels),
+			}
+			c.Finalize()
+			tel, err := newTelemetry(c)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer tel.shutdown()
+
+			tel.pass(tc.event, time.Now())
+
+			var act []string
+			buf := make([]byte, 1024)
+			for range tc.exp {
+				conn.SetReadDeadline(time.Now().Add(time.Second))
+				n, _, err := conn.ReadFrom(buf)
+				if err != nil {
+					t.Fatal(err)
+				}
+				metric := string(buf[:n])
+
+				// The duration of the pass varies
+				if strings.HasPrefix(metric, "test.pass.time:") {
+					metric = "test.pass.time:*" + metric[strings.Index(metric, "|"):]
+				}
+				act = append(act, metric)
+			}
+			sort.Strings(act)
+
+			if !reflect.DeepEqual(tc.exp, act) {
+				t.Errorf("\nexp: %#v\nact: %#v", tc.exp, act)
+			}
+		})
+	}
+}
+
+func TestNewTelemetry(t *testing.T) {
+	cases := []struct {
+		name string
+		c    *TelemetryConfig
+		nil  bool
+		err  bool
+	}{
+		{
+			"disabled",
+			&TelemetryConfig{},
+			true,
+			false,
+		},
+		{
+			"statsd",
+			&TelemetryConfig{StatsdAddress: config.String("127.0.0.1:8125")},
+			false,
+			false,
+		},
+		{
+			"invalid_tag",
+			&TelemetryConfig{
+				DogstatsdAddr: config.String("127.0.0.1:8125"),
+				DogstatsdTags: []string{"production"},
+			},
+			true,
+			true,
+		},
+	}
+
+	for i, tc := range cases {
+		t.Run(fmt.Sprintf("%d_%s", i, tc.name), func(t *testing.T) {
+			tc.c.Finalize()
+			tel, err := newTelemetry(tc.c)
+			if (err != nil) != tc.err {
+				t.Fatal(err)
+			}
+			defer tel.shutdown()
+			if (tel == nil) != tc.nil {
+				t.Errorf("\nexp: %#v\nact: %#v", tc.nil, tel == nil)
+			}
+		})
+	}
+}
@@ -0,0 +1,226 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: MPL-2.0
+
+package replicate
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/hashicorp/consul-template/config"
+	multierror "github.com/hashicorp/go-multierror"
+	"github.com/pkg/errors"
+)
+
+// tokenTransport replaces the token the client was created with by the
+// current token, read from a file, so the token can be rotated without
+// creating a new client, which would drop the watches and sessions made with
+// it. Requests made with another token, such as the token of a route, are
+// left alone.
+type tokenTransport struct {
+	sync.RWMutex
+
+	base       http.RoundTripper
+	configured string
+	current    string
+}
+
+func (t *tokenTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	t.RLock()
+	current := t.current
+	t.RUnlock()
+
+	if current == "" || req.Header.Get("X-Consul-Token") != t.configured {
+		return t.base.RoundTrip(req)
+	}
+
+	req = req.Clone(req.Context())
+	req.Header.Set("X-Consul-Token", current)
+	return t.base.RoundTrip(req)
+}
+
+// set replaces the current token, and returns true if it changed.
+func (t *tokenTransport) set(token string) bool {
+	t.Lock()
+	defer t.Unlock()
+
+	if token == t.current {
+		return false
+	}
+	t.current = token
+	return true
+}
+
+// setTokens makes the client send the current token of the returned transport
+// in place of the configured one.
+func setTokens(hc *http.Client, configured string) *tokenTransport {
+	if t, ok := hc.Transport.(*tokenTransport); ok {
+		return t
+	}
+	base := hc.Transport
+	if base == nil {
+		base = http.DefaultTransport
+	}
+	t := &tokenTransport{base: base, configured: configured}
+	hc.Transport = t
+	return t
+}
+
+// datacenterTokenTransport sends the token of the datacenter a request is
+// made in, if one is configured, in place of the configured token. Requests
+// which name no datacenter are made in the datacenter of the agent and keep
+// their token, as do requests made with another token, such as the token of a
+// route.
+type datacenterTokenTransport struct {
+	base       http.RoundTripper
+	configured string
+	tokens     DatacenterTokens
+}
+
+func (t *datacenterTokenTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	token, ok := t.tokens[req.URL.Query().Get("dc")]
+	if !ok || req.Header.Get("X-Consul-Token") != t.configured {
+		return t.base.RoundTrip(req)
+	}
+
+	req = req.Clone(req.Context())
+	req.Header.Set("X-Consul-Token", token)
+	return t.base.RoundTrip(req)
+}
+
+// setDatacenterTokens makes the client send the tokens of the datacenters in
+// place of the configured token. The token files and logins replace the
+// configured token too, so their transports must be set first for the tokens
+// of the datacenters to take precedence.
+func setDatacenterTokens(hc *http.Client, configured string, tokens DatacenterTokens) error {
+	if len(tokens) == 0 {
+		return nil
+	}
+	for dc, token := range tokens {
+		if dc == "" {
+			return fmt.Errorf("a token has no datacenter")
+		}
+		if token == "" {
+			return fmt.Errorf("the token of datacenter %q is empty", dc)
+		}
+	}
+
+	base := hc.Transport
+	if base == nil {
+		base = http.DefaultTransport
+	}
+	hc.Transport = &datacenterTokenTransport{
+		base:       base,
+		configured: configured,
+		tokens:     tokens.Copy(),
+	}
+	return nil
+}
+
+// initDatacenterTokens makes the clients send the tokens of the datacenters,
+// if any.
+func (r *Runner) initDatacenterTokens() error {
+	if err := setDatacenterTokens(r.clients.httpClient,
+		config.StringVal(r.config.Consul.Token), r.config.ConsulTokens); err != nil {
+		return errors.Wrap(err, "source tokens")
+	}
+	if err := setDatacenterTokens(r.destinationClients.httpClient,
+		config.StringVal(r.config.DestinationConsul.Token), r.config.DestinationConsulTokens); err != nil {
+		return errors.Wrap(err, "destination tokens")
+	}
+	return nil
+}
+
+// tokenRotationEnabled returns true if the tokens are read from files.
+func (r *Runner) tokenRotationEnabled() bool {
+	return config.BoolVal(r.config.TokenRotation.Enabled)
+}
+
+// initTokens makes the clients send the tokens of the token files, if any.
+func (r *Runner) initTokens() error {
+	if !r.tokenRotationEnabled() {
+		return nil
+	}
+
+	r.sourceToken = setTokens(r.clients.httpClient, config.StringVal(r.config.Consul.Token))
+	r.destinationToken = setTokens(r.destinationClients.httpClient,
+		config.StringVal(r.config.DestinationConsul.Token))
+	return r.RotateTokens()
+}
+
+// RotateTokens reads the token files again, and swaps the tokens of the
+// clients if they changed. Watches, sessions and the HA lock are kept, as
+// requests in flight finish with the previous token.
+func (r *Runner) RotateTokens() error {
+	if !r.tokenRotationEnabled() {
+		return nil
+	}
+
+	var errs *multierror.Error
+	for _, t := range []struct {
+		name      string
+		path      string
+		transport *tokenTransport
+	}{
+		{"source", config.StringVal(r.config.TokenRotation.SourceFile), r.sourceToken},
+		{"destination", config.StringVal(r.config.TokenRotation.DestinationFile), r.destinationToken},
+	} {
+		if t.path == "" || t.transport == nil {
+			continue
+		}
+
+		token, err := readToken(t.path)
+		if err != nil {
+			errs = multierror.Append(errs, errors.Wrapf(err, "%s token", t.name))
+			continue
+		}
+		if t.transport.set(token) {
+			log.Printf("[INFO] (runner) rotated the %s token from %q", t.name, t.path)
+
+			// A rotated token may work where the previous one was denied
+			if t.transport == r.destinationToken {
+				r.retryDenied()
+			}
+		}
+	}
+	return errs.ErrorOrNil()
+}
+
+// readToken reads the token in the file. An empty file is an error, so a
+// token which is being rewritten is not replaced by the anonymous token.
+func readToken(path string) (string, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return "", err
+	}
+	token := strings.TrimSpace(string(b))
+	if token == "" {
+		return "", fmt.Errorf("%q is empty", path)
+	}
+	return token, nil
+}
+
+// rotateTokens periodically reads the token files again. This function blocks
+// until the runner is stopped.
+func (r *Runner) rotateTokens() {
+	ticker := time.NewTicker(config.TimeDurationVal(r.config.TokenRotation.Interval))
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+		case <-r.stopCh:
+			return
+		}
+
+		// The previous token is kept until the file can be read again
+		if err := r.RotateTokens(); err != nil {
+			log.Printf("[WARN] (runner) failed to rotate the tokens: %s", err)
+		}
+	}
+}
@@ -0,0 +1,164 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: MPL-2.0
+
+package replicate
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/hashicorp/consul-template/config"
+	"github.com/hashicorp/consul/api"
+)
+
+func TestRunner_RotateTokens(t *testing.T) {
+	var act string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		act = r.Header.Get("X-Consul-Token")
+		http.NotFound(w, r)
+	}))
+	defer srv.Close()
+
+	hc := &http.Client{}
+	client, err := api.NewClient(&api.Config{Address: srv.URL, Token: "configured", HttpClient: hc})
+	if err != nil {
+		t.Fatal(err)
+	}
+	transport := setTokens(hc, "configured")
+
+	path := filepath.Join(t.TempDir(), "token")
+	c := DefaultConfig().Merge(&Config{
+		TokenRotation: &TokenRotationConfig{
+			SourceFile: config.String(path),
+		},
+	})
+	c.Finalize()
+	r := &Runner{config: c, sourceToken: transport}
+
+	cases := []struct {
+		name  string
+		file  string
+		token string
+		err   bool
+		exp   string
+	}{
+		{
+			"rotated",
+			"first\n",
+			"",
+			false,
+			"first",
+		},
+		{
+			"rotated_again",
+			"second",
+			"",
+			false,
+			"second",
+		},
+		{
+			"other_token",
+			"second",
+			"route",
+			false,
+			"route",
+		},
+		{
+			"empty_file",
+			"",
+			"",
+			true,
+			"second",
+		},
+	}
+
+	for i, tc := range cases {
+		t.Run(fmt.Sprintf("%d_%s", i, tc.name), func(t *testing.T) {
+			if err := os.WriteFile(path, []byte(tc.file), 0600); err != nil {
+				t.Fatal(err)
+			}
+			if err := r.RotateTokens(); (err != nil) != tc.err {
+				t.Fatalf("\nexp: %t\nact: %s", tc.err, err)
+			}
+
+			if _, _, err := client.KV().Get("global/a", &api.QueryOptions{Token: tc.token}); err != nil {
+				t.Fatal(err)
+			}
+			if act != tc.exp {
+				t.Errorf("\nexp: %#v\nact: %#v", tc.exp, act)
+			}
+		})
+	}
+}
+
+func TestSetDatacenterTokens(t *testing.T) {
+	var act string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		act = r.Header.Get("X-Consul-Token")
+		http.NotFound(w, r)
+	}))
+	defer srv.Close()
+
+	hc := &http.Client{}
+	client, err := api.NewClient(&api.Config{Address: srv.URL, Token: "configured", HttpClient: hc})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The token of a datacenter takes precedence over a rotated token
+	transport := setTokens(hc, "configured")
+	transport.set("rotated")
+	if err := setDatacenterTokens(hc, "configured", DatacenterTokens{
+		"dc2": "dc2-token",
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	cases := []struct {
+		name       string
+		datacenter string
+		token      string
+		exp        string
+	}{
+		{
+			"datacenter",
+			"dc2",
+			"",
+			"dc2-token",
+		},
+		{
+			"other_datacenter",
+			"dc3",
+			"",
+			"rotated",
+		},
+		{
+			"agent_datacenter",
+			"",
+			"",
+			"rotated",
+		},
+		{
+			"other_token",
+			"dc2",
+			"route",
+			"route",
+		},
+	}
+
+	for i, tc := range cases {
+		t.Run(fmt.Sprintf("%d_%s", i, tc.name), func(t *testing.T) {
+			opts := &api.QueryOptions{Datacenter: tc.datacenter, Token: tc.token}
+			if _, _, err := client.KV().Get("global/a", opts); err != nil {
+				t.Fatal(err)
+			}
+			if act != tc.exp {
+				t.Errorf("\nexp: %#v\nact: %#v", tc.exp, act)
+			}
+		})
+	}
+}
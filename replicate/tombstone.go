@@ -0,0 +1,60 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: MPL-2.0
+
+package replicate
+
+import (
+	"log"
+	"strings"
+	"time"
+
+	"github.com/hashicorp/consul-template/config"
+	"github.com/hashicorp/consul/api"
+)
+
+// tombstonesEnabled returns true if deleted keys leave a tombstone.
+func (r *Runner) tombstonesEnabled() bool {
+	return config.BoolVal(r.config.Tombstone.Enabled)
+}
+
+// isTombstone returns true if the destination key is the tombstone of a
+// deleted key.
+func (r *Runner) isTombstone(key string) bool {
+	return r.tombstonesEnabled() &&
+		strings.HasSuffix(key, config.StringVal(r.config.Tombstone.Suffix))
+}
+
+// writeTombstone records the deletion of the key in its tombstone, whose value
+// is the time of the deletion.
+func (r *Runner) writeTombstone(backend Backend, key string) error {
+	return backend.Put(&api.KVPair{
+		Key:   key + config.StringVal(r.config.Tombstone.Suffix),
+		Value: []byte(time.Now().UTC().Format(time.RFC3339)),
+	})
+}
+
+// pruneTombstone removes the tombstone if its key was written again, or if it
+// is older than the retention.
+func (r *Runner) pruneTombstone(backend Backend, key string, used map[string]struct{}) error {
+	if _, ok := used[strings.TrimSuffix(key, config.StringVal(r.config.Tombstone.Suffix))]; !ok {
+		pair, err := backend.Get(key)
+		if err != nil || pair == nil {
+			return err
+		}
+
+		deleted, err := time.Parse(time.RFC3339, string(pair.Value))
+		if err != nil {
+			log.Printf("[DEBUG] (runner) %q is not a tombstone, keeping", key)
+			return nil
+		}
+		if time.Since(deleted) <= config.TimeDurationVal(r.config.Tombstone.Retention) {
+			return nil
+		}
+	}
+
+	if err := backend.Delete(key); err != nil {
+		return err
+	}
+	log.Printf("[DEBUG] (runner) removed tombstone %q", key)
+	return nil
+}
@@ -0,0 +1,104 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: MPL-2.0
+
+package replicate
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/hashicorp/consul-template/config"
+)
+
+// unixSocket returns the path of the socket of a "unix://" address, and false
+// for any other address.
+func unixSocket(address string) (string, bool) {
+	if !strings.HasPrefix(address, "unix://") {
+		return "", false
+	}
+	return strings.TrimPrefix(address, "unix://"), true
+}
+
+// newTransport creates the transport of the HTTP client of a Consul client
+// from the transport and ssl config, tuned by the HTTP config, if any. The
+// transport of a unix socket keeps its connections alive and never goes
+// through the HTTP proxy of the environment.
+func newTransport(c *config.ConsulConfig, h *HTTPClientConfig) (*http.Transport, error) {
+	dialer := &net.Dialer{
+		Timeout:   config.TimeDurationVal(c.Transport.DialTimeout),
+		KeepAlive: config.TimeDurationVal(c.Transport.DialKeepAlive),
+	}
+	t := &http.Transport{
+		Proxy:               http.ProxyFromEnvironment,
+		DialContext:         dialer.DialContext,
+		DisableKeepAlives:   config.BoolVal(c.Transport.DisableKeepAlives),
+		IdleConnTimeout:     config.TimeDurationVal(c.Transport.IdleConnTimeout),
+		MaxIdleConns:        config.IntVal(c.Transport.MaxIdleConns),
+		MaxIdleConnsPerHost: config.IntVal(c.Transport.MaxIdleConnsPerHost),
+		TLSHandshakeTimeout: config.TimeDurationVal(c.Transport.TLSHandshakeTimeout),
+	}
+
+	if config.BoolVal(c.SSL.Enabled) {
+		tlsConfig, err := newTLSConfig(c.SSL)
+		if err != nil {
+			return nil, fmt.Errorf("consul: %s", err)
+		}
+		if tlsConfig.InsecureSkipVerify {
+			log.Printf("[WARN] (clients) disabling consul SSL verification")
+		}
+		t.TLSClientConfig = tlsConfig
+	}
+
+	if path, ok := unixSocket(config.StringVal(c.Address)); ok {
+		if h != nil && config.StringVal(h.Proxy) != "" {
+			return nil, fmt.Errorf("unix socket %q cannot be reached "+
+				"through a proxy", path)
+		}
+		t.DialContext = func(ctx context.Context, _, _ string) (net.Conn, error) {
+			return dialer.DialContext(ctx, "unix", path)
+		}
+		t.Proxy = nil
+	}
+
+	if h == nil {
+		return t, nil
+	}
+	t.MaxConnsPerHost = config.IntVal(h.MaxConnsPerHost)
+
+	// Without a proxy, the transport keeps the proxy of the environment
+	if s := config.StringVal(h.Proxy); s != "" {
+		u, err := parseProxy(s)
+		if err != nil {
+			return nil, err
+		}
+		t.Proxy = http.ProxyURL(u)
+	}
+
+	// The transport only negotiates HTTP/2 by default without a custom dialer
+	// and TLS config, which are always set
+	t.ForceAttemptHTTP2 = config.BoolVal(h.HTTP2)
+	return t, nil
+}
+
+// parseProxy parses the URL of an HTTP or SOCKS5 proxy.
+func parseProxy(s string) (*url.URL, error) {
+	u, err := url.Parse(s)
+	if err != nil {
+		return nil, fmt.Errorf("invalid proxy %q: %s", s, err)
+	}
+	switch u.Scheme {
+	case "http", "https", "socks5", "socks5h":
+	default:
+		return nil, fmt.Errorf("invalid proxy %q: unsupported scheme %q, expected "+
+			"http, https, socks5 or socks5h", s, u.Scheme)
+	}
+	if u.Host == "" {
+		return nil, fmt.Errorf("invalid proxy %q: missing host", s)
+	}
+	return u, nil
+}
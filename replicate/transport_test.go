@@ -0,0 +1,177 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: MPL-2.0
+
+package replicate
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+
+	"github.com/hashicorp/consul-template/config"
+)
+
+func TestNewClientSet_http(t *testing.T) {
+	var act int
+	srv := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		act = r.ProtoMajor
+		fmt.Fprint(w, `"10.0.0.1:8300"`)
+	}))
+	srv.EnableHTTP2 = true
+	srv.StartTLS()
+	defer srv.Close()
+
+	cases := []struct {
+		name string
+		h    *HTTPClientConfig
+		exp  int
+	}{
+		{
+			"nil",
+			nil,
+			1,
+		},
+		{
+			"http1",
+			&HTTPClientConfig{},
+			1,
+		},
+		{
+			"http2",
+			&HTTPClientConfig{HTTP2: config.Bool(true)},
+			2,
+		},
+	}
+
+	for i, tc := range cases {
+		t.Run(fmt.Sprintf("%d_%s", i, tc.name), func(t *testing.T) {
+			c := config.DefaultConsulConfig()
+			c.Address = config.String(srv.Listener.Addr().String())
+			c.SSL.Enabled = config.Bool(true)
+			c.SSL.Verify = config.Bool(false)
+			c.Finalize()
+			if tc.h != nil {
+				tc.h.Finalize()
+			}
+
+			clients, err := newClientSet(c, tc.h)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if _, err := clients.Consul().Status().Leader(); err != nil {
+				t.Fatal(err)
+			}
+			if act != tc.exp {
+				t.Errorf("\nexp: %#v\nact: %#v", tc.exp, act)
+			}
+		})
+	}
+}
+
+func TestNewClientSet_unix(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "consul.sock")
+	l, err := net.Listen("unix", path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var conns int32
+	srv := &http.Server{
+		Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			fmt.Fprint(w, `"10.0.0.1:8300"`)
+		}),
+		ConnState: func(_ net.Conn, state http.ConnState) {
+			if state == http.StateNew {
+				atomic.AddInt32(&conns, 1)
+			}
+		},
+	}
+	go srv.Serve(l)
+	defer srv.Close()
+
+	c := config.DefaultConsulConfig()
+	c.Address = config.String("unix://" + path)
+	c.Finalize()
+	clients, err := newClientSet(c, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Connections to the socket are kept alive between requests
+	for i := 0; i < 3; i++ {
+		leader, err := clients.Consul().Status().Leader()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if leader != "10.0.0.1:8300" {
+			t.Errorf("\nexp: %#v\nact: %#v", "10.0.0.1:8300", leader)
+		}
+	}
+	if act := atomic.LoadInt32(&conns); act != 1 {
+		t.Errorf("\nexp: %#v\nact: %#v", 1, act)
+	}
+}
+
+func TestNewClientSet_proxy(t *testing.T) {
+	var act string
+	proxy := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// Requests to a proxy carry the absolute URL of the destination
+		act = r.URL.Host
+		fmt.Fprint(w, `"10.0.0.1:8300"`)
+	}))
+	defer proxy.Close()
+
+	cases := []struct {
+		name  string
+		proxy string
+		exp   string
+		err   bool
+	}{
+		{
+			"http",
+			proxy.URL,
+			"consul.invalid:8500",
+			false,
+		},
+		{
+			"scheme",
+			"ftp://bastion:21",
+			"",
+			true,
+		},
+		{
+			"host",
+			"socks5://",
+			"",
+			true,
+		},
+	}
+
+	for i, tc := range cases {
+		t.Run(fmt.Sprintf("%d_%s", i, tc.name), func(t *testing.T) {
+			act = ""
+			c := config.DefaultConsulConfig()
+			c.Address = config.String("consul.invalid:8500")
+			c.Finalize()
+			h := &HTTPClientConfig{Proxy: config.String(tc.proxy)}
+			h.Finalize()
+
+			clients, err := newClientSet(c, h)
+			if (err != nil) != tc.err {
+				t.Fatal(err)
+			}
+			if err != nil {
+				return
+			}
+			if _, err := clients.Consul().Status().Leader(); err != nil {
+				t.Fatal(err)
+			}
+			if act != tc.exp {
+				t.Errorf("\nexp: %#v\nact: %#v", tc.exp, act)
+			}
+		})
+	}
+}
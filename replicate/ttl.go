@@ -0,0 +1,192 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: MPL-2.0
+
+package replicate
+
+import (
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	"github.com/hashicorp/consul-template/config"
+	"github.com/hashicorp/go-multierror"
+)
+
+// minReapInterval is the lower bound on how often prefixes with a TTL are
+// checked, regardless of how short the TTL is.
+const minReapInterval = 1 * time.Second
+
+// hasTTLs returns true if any configured prefix has a TTL.
+func (r *Runner) hasTTLs() bool {
+	for _, prefix := range *r.config.Prefixes {
+		if config.TimeDurationVal(prefix.TTL) > 0 {
+			return true
+		}
+	}
+	return false
+}
+
+// reapInterval returns how often prefixes are checked, which is a quarter of
+// the shortest TTL so keys never outlive it by much.
+func (r *Runner) reapInterval() time.Duration {
+	var interval time.Duration
+	for _, prefix := range *r.config.Prefixes {
+		ttl := config.TimeDurationVal(prefix.TTL)
+		if ttl > 0 && (interval == 0 || ttl/4 < interval) {
+			interval = ttl / 4
+		}
+	}
+
+	if interval < minReapInterval {
+		interval = minReapInterval
+	}
+	return interval
+}
+
+// reap periodically probes the source of every prefix with a TTL. While the
+// source is reachable the prefix's status is refreshed; once it has not been
+// refreshed within the TTL, the replicated keys are removed from the
+// destination. This function blocks until the runner is stopped.
+func (r *Runner) reap() {
+	ticker := time.NewTicker(r.reapInterval())
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+		case <-r.stopCh:
+			return
+		}
+
+		// Only the leader writes to the destination
+		if r.haEnabled() && !r.isLeader() {
+			continue
+		}
+
+		if err := r.Reap(); err != nil {
+			log.Printf("[WARN] (runner) %s", err)
+		}
+	}
+}
+
+// Reap checks every prefix with a TTL once, refreshing the prefixes whose
+// source is reachable and expiring the keys of the others.
+func (r *Runner) Reap() error {
+	var errs *multierror.Error
+	for _, prefix := range r.activePrefixes() {
+		if config.TimeDurationVal(prefix.TTL) <= 0 {
+			continue
+		}
+
+		if err := r.reapPrefix(prefix); err != nil {
+			errs = multierror.Append(errs, fmt.Errorf("failed to check ttl for %s: %s",
+				prefix.Dependency, err))
+		}
+	}
+	return errs.ErrorOrNil()
+}
+
+// reapPrefix refreshes or expires a single prefix.
+func (r *Runner) reapPrefix(prefix *PrefixConfig) error {
+	backend := r.backend(prefix)
+
+	r.statusLock.Lock()
+	defer r.statusLock.Unlock()
+
+	status, err := r.getStatus(prefix)
+	if err != nil {
+		return err
+	}
+
+	// Probe the source with a cheap, non-blocking query
+	_, err = r.sourceFor(prefix).Keys(config.StringVal(prefix.Source), config.StringVal(prefix.Datacenter))
+	if err == nil {
+		status.LastRefreshed = time.Now().UTC()
+		return r.setStatus(prefix, status)
+	}
+	log.Printf("[WARN] (runner) source of %s is unreachable: %s", prefix.Dependency, err)
+
+	// Keys which were never replicated with a TTL are left alone
+	if status.LastRefreshed.IsZero() {
+		return nil
+	}
+
+	ttl := config.TimeDurationVal(prefix.TTL)
+	if time.Since(status.LastRefreshed) <= ttl {
+		return nil
+	}
+
+	destination := config.StringVal(prefix.Destination)
+	keys, err := r.destinationKeys(backend, prefix)
+	if err != nil {
+		return err
+	}
+
+	// The expired keys are not drift
+	defer r.guardDrift(prefix)()
+
+	expired := 0
+	for _, key := range keys {
+		sourceKey := config.StringVal(prefix.Source) + strings.TrimPrefix(key, destination)
+		if r.excluded(sourceKey) || r.reserved(key) {
+			continue
+		}
+
+		// Keys written by hand are kept
+		if config.BoolVal(prefix.DeleteOwnedOnly) {
+			current, err := backend.Get(key)
+			if err != nil {
+				return err
+			}
+			ok, err := r.owned(backend, current)
+			if err != nil {
+				return err
+			}
+			if !ok {
+				continue
+			}
+		}
+
+		if err := backend.Delete(key); err != nil {
+			return err
+		}
+		expired++
+
+		if r.metadataWritten(prefix) {
+			if err := r.deleteMetadata(backend, key); err != nil {
+				return err
+			}
+		}
+	}
+
+	if expired > 0 {
+		if err := r.dropMirror(prefix); err != nil {
+			return err
+		}
+		log.Printf("[WARN] (runner) %s was not refreshed within %s, expired %d keys",
+			prefix.Dependency, ttl, expired)
+	}
+
+	// The expired keys are written again once the source is back, even those
+	// which were not modified since they were last replicated
+	if status.LastReplicated != 0 {
+		status.LastReplicated = 0
+		return r.setStatus(prefix, status)
+	}
+	return nil
+}
+
+// excluded returns true if the source key falls under an excluded prefix, or
+// is reserved.
+func (r *Runner) excluded(sourceKey string) bool {
+	if r.reserved(sourceKey) {
+		return true
+	}
+	for _, exclude := range *r.config.Excludes {
+		if strings.HasPrefix(sourceKey, config.StringVal(exclude.Source)) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,236 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: MPL-2.0
+
+package replicate
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	"github.com/hashicorp/consul-template/config"
+	"github.com/hashicorp/consul/api"
+	"github.com/pkg/errors"
+)
+
+// UndoEntry is the state of a destination key before a pass changed it.
+type UndoEntry struct {
+	Key string `json:"key"`
+
+	// Existed is false if the pass created the key, which is then deleted by a
+	// rollback. Otherwise the key is restored with its previous value and flags.
+	Existed bool   `json:"existed"`
+	Value   []byte `json:"value,omitempty"`
+	Flags   uint64 `json:"flags,omitempty"`
+}
+
+// UndoPass is what a rollback needs to restore the destination to its state
+// before a pass.
+type UndoPass struct {
+	// Index is the source index the pass replicated, and Time when it
+	// finished.
+	Index uint64    `json:"index"`
+	Time  time.Time `json:"time"`
+
+	// Entries are the keys the pass changed, in order.
+	Entries []*UndoEntry `json:"entries"`
+
+	keys map[string]struct{}
+}
+
+// UndoLog is kept next to the status of a prefix, with its last passes from
+// the oldest to the most recent.
+type UndoLog struct {
+	Source, Destination string
+	Passes              []*UndoPass
+
+	// LastUpdated is the last time the undo log was written.
+	LastUpdated time.Time
+}
+
+// RollbackResult is the outcome of the rollback of a prefix.
+type RollbackResult struct {
+	Source, Datacenter, Destination string
+
+	// Passes is the number of passes rolled back, and Restored and Deleted the
+	// number of keys restored to their previous value and deleted because the
+	// passes created them.
+	Passes, Restored, Deleted int
+}
+
+// undoEnabled returns true if the previous values of changed keys are kept.
+func (r *Runner) undoEnabled() bool {
+	return config.BoolVal(r.config.Undo.Enabled)
+}
+
+// undoPath returns the path of the undo log of the prefix, which lives next to
+// its status key.
+func (r *Runner) undoPath(prefix *PrefixConfig) string {
+	status := r.statusPath(prefix)
+	i := strings.LastIndex(status, "/")
+	return status[:i] + "/undo" + status[i:]
+}
+
+// add records the previous state of a key changed by the pass. Only the first
+// change of a key in a pass is kept, which is its state before the pass.
+func (p *UndoPass) add(key string, previous *api.KVPair) {
+	if _, ok := p.keys[key]; ok {
+		return
+	}
+	if p.keys == nil {
+		p.keys = make(map[string]struct{})
+	}
+	p.keys[key] = struct{}{}
+
+	entry := &UndoEntry{Key: key}
+	if previous != nil {
+		entry.Existed = true
+		entry.Value = previous.Value
+		entry.Flags = previous.Flags
+	}
+	p.Entries = append(p.Entries, entry)
+}
+
+// getUndo reads the undo log of the prefix, which is empty if there is none.
+func (r *Runner) getUndo(prefix *PrefixConfig) (*UndoLog, error) {
+	u := &UndoLog{
+		Source:      config.StringVal(prefix.Source),
+		Destination: config.StringVal(prefix.Destination),
+	}
+
+	pair, err := r.statusBackend(prefix).Get(r.undoPath(prefix))
+	if err != nil {
+		return nil, err
+	}
+	if pair == nil {
+		return u, nil
+	}
+	if err := json.Unmarshal(pair.Value, u); err != nil {
+		return nil, errors.Wrap(err, "decoding undo log")
+	}
+	return u, nil
+}
+
+// setUndo writes the undo log of the prefix, dropping its oldest passes to
+// stay within the number of passes and the size of the undo config.
+func (r *Runner) setUndo(prefix *PrefixConfig, u *UndoLog) error {
+	if max := config.IntVal(r.config.Undo.Passes); len(u.Passes) > max {
+		u.Passes = u.Passes[len(u.Passes)-max:]
+	}
+
+	u.LastUpdated = time.Now().UTC()
+	maxBytes := config.IntVal(r.config.Undo.MaxBytes)
+	for {
+		enc, err := json.Marshal(u)
+		if err != nil {
+			return err
+		}
+		if len(enc) <= maxBytes || len(u.Passes) == 0 {
+			return r.statusBackend(prefix).Put(&api.KVPair{
+				Key:   r.undoPath(prefix),
+				Value: enc,
+			})
+		}
+
+		// Older passes cannot be rolled back without rolling back the most
+		// recent one first
+		if len(u.Passes) == 1 {
+			log.Printf("[WARN] (runner) the last pass of %s is over the undo max_bytes "+
+				"of %d, and cannot be rolled back", prefix.Dependency, maxBytes)
+		}
+		u.Passes = u.Passes[1:]
+	}
+}
+
+// saveUndo appends the pass to the undo log of the prefix.
+func (r *Runner) saveUndo(prefix *PrefixConfig, p *UndoPass) error {
+	u, err := r.getUndo(prefix)
+	if err != nil {
+		return err
+	}
+	u.Passes = append(u.Passes, p)
+	return r.setUndo(prefix, u)
+}
+
+// Rollback restores the destination of every configured prefix to its state
+// before its last passes, most recent first, and removes them from its undo
+// log. A prefix without enough passes in its undo log fails the rollback
+// before anything is restored.
+func (r *Runner) Rollback(passes int) ([]*RollbackResult, error) {
+	if passes < 1 {
+		return nil, fmt.Errorf("rollback: expected at least one pass, got %d", passes)
+	}
+
+	type rollback struct {
+		prefix *PrefixConfig
+		log    *UndoLog
+	}
+
+	seen := make(map[string]struct{})
+	var rollbacks []*rollback
+	for _, prefix := range *r.config.Prefixes {
+		if prefix.IsWildcard() {
+			return nil, fmt.Errorf("rollback: %q is a wildcard, name one of its folders instead",
+				config.StringVal(prefix.Source))
+		}
+		if _, ok := seen[r.undoPath(prefix)]; ok {
+			continue
+		}
+		seen[r.undoPath(prefix)] = struct{}{}
+
+		u, err := r.getUndo(prefix)
+		if err != nil {
+			return nil, errors.Wrapf(err, "rollback: reading undo log of %s", prefix.Dependency)
+		}
+		if len(u.Passes) < passes {
+			return nil, fmt.Errorf("rollback: only %d passes of %s can be rolled back",
+				len(u.Passes), prefix.Dependency)
+		}
+		rollbacks = append(rollbacks, &rollback{prefix: prefix, log: u})
+	}
+
+	results := make([]*RollbackResult, 0, len(rollbacks))
+	for _, rb := range rollbacks {
+		prefix, u := rb.prefix, rb.log
+		backend := r.backend(prefix)
+		result := &RollbackResult{
+			Source:      config.StringVal(prefix.Source),
+			Datacenter:  config.StringVal(prefix.Datacenter),
+			Destination: config.StringVal(prefix.Destination),
+			Passes:      passes,
+		}
+
+		undone := u.Passes[len(u.Passes)-passes:]
+		for i := len(undone) - 1; i >= 0; i-- {
+			entries := undone[i].Entries
+			for j := len(entries) - 1; j >= 0; j-- {
+				e := entries[j]
+				if !e.Existed {
+					if err := backend.Delete(e.Key); err != nil {
+						return results, errors.Wrapf(err, "rollback: deleting %q", e.Key)
+					}
+					result.Deleted++
+					continue
+				}
+				if err := backend.Put(&api.KVPair{Key: e.Key, Value: e.Value, Flags: e.Flags}); err != nil {
+					return results, errors.Wrapf(err, "rollback: restoring %q", e.Key)
+				}
+				result.Restored++
+			}
+		}
+
+		if err := r.dropMirror(prefix); err != nil {
+			return results, errors.Wrapf(err, "rollback: discarding mirror of %s", prefix.Dependency)
+		}
+
+		u.Passes = u.Passes[:len(u.Passes)-passes]
+		if err := r.setUndo(prefix, u); err != nil {
+			return results, errors.Wrapf(err, "rollback: writing undo log of %s", prefix.Dependency)
+		}
+		log.Printf("[INFO] (runner) rolled back %d passes of %s", passes, prefix.Dependency)
+		results = append(results, result)
+	}
+	return results, nil
+}
@@ -0,0 +1,254 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: MPL-2.0
+
+package replicate
+
+import (
+	"fmt"
+	"log"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/hashicorp/consul-template/config"
+	"github.com/hashicorp/consul/api"
+	multierror "github.com/hashicorp/go-multierror"
+	"github.com/pkg/errors"
+)
+
+// consulFeature is a feature of Consul which older versions, or the community
+// edition, do not support.
+type consulFeature struct {
+	name       string
+	since      [3]int
+	enterprise bool
+}
+
+var (
+	featureTxn        = &consulFeature{name: "transactions", since: [3]int{0, 7, 0}}
+	featureNamespaces = &consulFeature{name: "namespaces", since: [3]int{1, 7, 0}, enterprise: true}
+	featureStreaming  = &consulFeature{name: "streaming", since: [3]int{1, 10, 0}}
+	featurePartitions = &consulFeature{name: "admin partitions", since: [3]int{1, 11, 0}, enterprise: true}
+
+	// consulFeatures are the features reported for every cluster.
+	consulFeatures = []*consulFeature{featureTxn, featureNamespaces, featureStreaming, featurePartitions}
+)
+
+// String returns the name of the feature and the first version supporting it.
+func (f *consulFeature) String() string {
+	edition := "Consul"
+	if f.enterprise {
+		edition = "Consul Enterprise"
+	}
+	return fmt.Sprintf("%s (%s %d.%d.%d or later)", f.name, edition,
+		f.since[0], f.since[1], f.since[2])
+}
+
+// clusterInfo is the version of a cluster, as reported by the agent the
+// replicator talks to, which is assumed to run the version of its servers.
+type clusterInfo struct {
+	version    string
+	enterprise bool
+	parsed     [3]int
+}
+
+// parseVersion parses a Consul version such as "1.15.2+ent" into its major,
+// minor and patch numbers. Pre-release and build metadata are ignored.
+func parseVersion(s string) ([3]int, error) {
+	var v [3]int
+	s = strings.TrimPrefix(s, "v")
+	if i := strings.IndexAny(s, "-+"); i >= 0 {
+		s = s[:i]
+	}
+	parts := strings.Split(s, ".")
+	if len(parts) > 3 || parts[0] == "" {
+		return v, fmt.Errorf("invalid version %q", s)
+	}
+	for i, part := range parts {
+		n, err := strconv.Atoi(part)
+		if err != nil || n < 0 {
+			return v, fmt.Errorf("invalid version %q", s)
+		}
+		v[i] = n
+	}
+	return v, nil
+}
+
+// clusterVersion returns the version of the cluster of the client.
+func clusterVersion(client *api.Client) (*clusterInfo, error) {
+	self, err := client.Agent().Self()
+	if err != nil {
+		return nil, errors.Wrap(err, "querying agent")
+	}
+
+	version, _ := self["Config"]["Version"].(string)
+	metadata, _ := self["Config"]["VersionMetadata"].(string)
+	parsed, err := parseVersion(version)
+	if err != nil {
+		return nil, err
+	}
+	return &clusterInfo{
+		version:    version,
+		enterprise: metadata == "ent" || strings.Contains(version, "+ent"),
+		parsed:     parsed,
+	}, nil
+}
+
+// supports returns true if the cluster supports the feature. A cluster whose
+// version is unknown is assumed to support every feature.
+func (c *clusterInfo) supports(f *consulFeature) bool {
+	if c == nil {
+		return true
+	}
+	if f.enterprise && !c.enterprise {
+		return false
+	}
+	for i := range c.parsed {
+		if c.parsed[i] != f.since[i] {
+			return c.parsed[i] > f.since[i]
+		}
+	}
+	return true
+}
+
+// String returns the version of the cluster and the features it supports.
+func (c *clusterInfo) String() string {
+	if c == nil {
+		return "unknown"
+	}
+
+	var features []string
+	for _, f := range consulFeatures {
+		if c.supports(f) {
+			features = append(features, f.name)
+		}
+	}
+	version := c.version
+	if c.enterprise && !strings.Contains(version, "+ent") {
+		version += "+ent"
+	}
+	if len(features) == 0 {
+		return version
+	}
+	return fmt.Sprintf("%s (%s)", version, strings.Join(features, ", "))
+}
+
+// skewed returns true if the clusters do not run the same major and minor
+// version.
+func skewed(a, b *clusterInfo) bool {
+	if a == nil || b == nil {
+		return false
+	}
+	return a.parsed[0] != b.parsed[0] || a.parsed[1] != b.parsed[1]
+}
+
+// versionCheckEnabled returns true if the versions of the clusters are
+// checked.
+func (r *Runner) versionCheckEnabled() bool {
+	return config.BoolVal(r.config.VersionCheck.Enabled)
+}
+
+// destinationSupports returns true if the destination cluster supports the
+// feature, or if its version is unknown.
+func (r *Runner) destinationSupports(f *consulFeature) bool {
+	r.RLock()
+	defer r.RUnlock()
+	return r.destinationInfo.supports(f)
+}
+
+// checkVersions detects the versions of the source and destination clusters,
+// so features they lack are worked around or reported up front instead of
+// failing deep inside a pass with a 404. A cluster whose version cannot be
+// read, for example because the token cannot read the agent, is assumed to
+// support every feature. Snapshots and other sources are never checked.
+func (r *Runner) checkVersions() error {
+	var source, destination *clusterInfo
+	if s, ok := r.source.(*consulSource); ok && r.snapshots == nil {
+		info, err := clusterVersion(s.client)
+		if err != nil {
+			log.Printf("[WARN] (runner) cannot detect the version of the source "+
+				"cluster, assuming it supports every feature: %s", err)
+		}
+		source = info
+	}
+	if _, ok := r.backends[BackendConsul].(*consulBackend); ok {
+		info, err := clusterVersion(r.destinationClients.Consul())
+		if err != nil {
+			log.Printf("[WARN] (runner) cannot detect the version of the destination "+
+				"cluster, assuming it supports every feature: %s", err)
+		}
+		destination = info
+	}
+
+	r.Lock()
+	previousSource, previousDestination := r.sourceInfo, r.destinationInfo
+	r.sourceInfo, r.destinationInfo = source, destination
+	r.Unlock()
+
+	if source != nil && source.String() != previousSource.String() {
+		log.Printf("[INFO] (runner) source cluster runs Consul %s", source)
+	}
+	if destination != nil && destination.String() != previousDestination.String() {
+		log.Printf("[INFO] (runner) destination cluster runs Consul %s", destination)
+	}
+	if skewed(source, destination) {
+		log.Printf("[WARN] (runner) version skew between the source cluster "+
+			"(Consul %s) and the destination cluster (Consul %s), features of the "+
+			"source may not be replicated", source.version, destination.version)
+	}
+
+	var errs *multierror.Error
+	for _, prefix := range *r.config.Prefixes {
+		if p := config.StringVal(prefix.Partition); p != "" && !source.supports(featurePartitions) {
+			errs = multierror.Append(errs, fmt.Errorf("%s reads from partition %q, but "+
+				"the source cluster runs Consul %s, which does not support %s",
+				prefix.Dependency, p, source.version, featurePartitions))
+		}
+
+		if config.StringVal(prefix.Backend) != BackendConsul {
+			continue
+		}
+		if p := config.StringVal(prefix.DestinationPartition); p != "" && !destination.supports(featurePartitions) {
+			errs = multierror.Append(errs, fmt.Errorf("%s writes into partition %q, "+
+				"but the destination cluster runs Consul %s, which does not support %s",
+				prefix.Dependency, p, destination.version, featurePartitions))
+		}
+
+		// Promotions fall back to writing the keys one by one
+		if config.BoolVal(prefix.Canary.Enabled) && !destination.supports(featureTxn) {
+			log.Printf("[WARN] (runner) promotions of %s are not atomic, as the "+
+				"destination cluster runs Consul %s, which does not support %s",
+				prefix.Dependency, destination.version, featureTxn)
+		}
+	}
+
+	if err := errs.ErrorOrNil(); err != nil {
+		return fmt.Errorf("version check: %s", err)
+	}
+	return nil
+}
+
+// watchVersions checks the versions of the clusters every interval, so
+// upgrades and downgrades of either cluster are picked up while running.
+func (r *Runner) watchVersions() {
+	ticker := time.NewTicker(config.TimeDurationVal(r.config.VersionCheck.Interval))
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+		case <-r.stopCh:
+			return
+		}
+
+		if err := r.checkVersions(); err != nil {
+			log.Printf("[ERR] (runner) %s", err)
+		}
+	}
+}
+
+// nonTxnBackend hides the transactions of a backend whose cluster does not
+// support them.
+type nonTxnBackend struct {
+	Backend
+}
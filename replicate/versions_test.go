@@ -0,0 +1,105 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: MPL-2.0
+
+package replicate
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+
+	"github.com/hashicorp/consul-template/config"
+)
+
+func TestClusterVersion(t *testing.T) {
+	cases := []struct {
+		name     string
+		self     string
+		exp      *clusterInfo
+		features []*consulFeature
+		err      bool
+	}{
+		{
+			"community",
+			`{"Config":{"Version":"1.10.3","VersionMetadata":""}}`,
+			&clusterInfo{version: "1.10.3", parsed: [3]int{1, 10, 3}},
+			[]*consulFeature{featureTxn, featureStreaming},
+			false,
+		},
+		{
+			"enterprise",
+			`{"Config":{"Version":"1.15.2","VersionMetadata":"ent"}}`,
+			&clusterInfo{version: "1.15.2", enterprise: true, parsed: [3]int{1, 15, 2}},
+			[]*consulFeature{featureTxn, featureNamespaces, featureStreaming, featurePartitions},
+			false,
+		},
+		{
+			"enterprise_old",
+			`{"Config":{"Version":"1.8.0+ent"}}`,
+			&clusterInfo{version: "1.8.0+ent", enterprise: true, parsed: [3]int{1, 8, 0}},
+			[]*consulFeature{featureTxn, featureNamespaces},
+			false,
+		},
+		{
+			"no_txn",
+			`{"Config":{"Version":"0.6.4"}}`,
+			&clusterInfo{version: "0.6.4", parsed: [3]int{0, 6, 4}},
+			nil,
+			false,
+		},
+		{
+			"prerelease",
+			`{"Config":{"Version":"1.11.0-beta1"}}`,
+			&clusterInfo{version: "1.11.0-beta1", parsed: [3]int{1, 11, 0}},
+			[]*consulFeature{featureTxn, featureStreaming},
+			false,
+		},
+		{
+			"invalid",
+			`{"Config":{"Version":"dev"}}`,
+			nil,
+			nil,
+			true,
+		},
+	}
+
+	for i, tc := range cases {
+		t.Run(fmt.Sprintf("%d_%s", i, tc.name), func(t *testing.T) {
+			srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				fmt.Fprint(w, tc.self)
+			}))
+			defer srv.Close()
+
+			c := config.DefaultConsulConfig()
+			c.Address = config.String(srv.URL)
+			c.Finalize()
+			clients, err := newClientSet(c, nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			act, err := clusterVersion(clients.Consul())
+			if (err != nil) != tc.err {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(tc.exp, act) {
+				t.Errorf("\nexp: %#v\nact: %#v", tc.exp, act)
+			}
+			if act == nil {
+				return
+			}
+
+			var features []*consulFeature
+			for _, f := range consulFeatures {
+				if act.supports(f) {
+					features = append(features, f)
+				}
+			}
+			if !reflect.DeepEqual(tc.features, features) {
+				t.Errorf("\nexp: %s\nact: %s", tc.features, features)
+			}
+		})
+	}
+}
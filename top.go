@@ -0,0 +1,188 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: MPL-2.0
+
+package main
+
+import (
+	"fmt"
+	"io"
+	"text/tabwriter"
+	"time"
+
+	"github.com/hashicorp/consul-replicate/control"
+)
+
+// clearScreen moves the cursor home and clears the terminal, so every frame of
+// the dashboard is drawn over the previous one.
+const clearScreen = "\x1b[H\x1b[2J"
+
+// maxErrorWidth is the number of characters of the last error of a prefix
+// shown on the dashboard.
+const maxErrorWidth = 60
+
+// dashboard renders the prefixes of a running instance, as read through its
+// control API, for the top command.
+type dashboard struct {
+	address string
+
+	// bytes are the bytes read and written by every prefix at the last frame,
+	// which the throughput is computed from. They start over when a
+	// replicator is restarted.
+	bytes     map[string][2]uint64
+	bytesTime time.Time
+}
+
+func newDashboard(address string) *dashboard {
+	return &dashboard{address: address, bytes: make(map[string][2]uint64)}
+}
+
+// render writes a frame of the dashboard from the status and stats of the
+// instance at now. The throughput is computed since the previous frame, so
+// it is unknown in the first one.
+func (d *dashboard) render(w io.Writer, status *control.StatusResponse, stats *control.StatsResponse, now time.Time) {
+	passes := make(map[string][]*control.PassStats)
+	for _, r := range stats.Replicators {
+		for _, p := range r.Prefixes {
+			passes[dashboardKey(r.Name, p.Source, p.Datacenter, p.Destination)] = p.Passes
+		}
+	}
+
+	var total, failing int
+	for _, r := range status.Replicators {
+		for _, p := range r.Prefixes {
+			total++
+			if !p.Healthy {
+				failing++
+			}
+		}
+	}
+
+	fmt.Fprintf(w, "consul-replicate top - %s - %s - %d prefixes, %d failing\n\n",
+		d.address, now.UTC().Format(time.RFC3339), total, failing)
+
+	elapsed := now.Sub(d.bytesTime).Seconds()
+	bytes := make(map[string][2]uint64, len(d.bytes))
+
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "REPLICATOR\tPREFIX\tHEALTH\tINDEX\tLAST PASS\tLAG\tKEYS/MIN\tREAD/S\tWRITE/S\tERROR")
+	for _, r := range status.Replicators {
+		name := r.Name
+		if name == "" {
+			name = "-"
+		}
+		if r.Paused {
+			name += " (paused)"
+		}
+
+		for _, p := range r.Prefixes {
+			key := dashboardKey(r.Name, p.Source, p.Datacenter, p.Destination)
+			history := passes[key]
+
+			health := "ok"
+			if p.CredentialsInvalid {
+				health = "denied"
+			} else if !p.Healthy {
+				health = fmt.Sprintf("failing (%d)", p.ConsecutiveFailures)
+			}
+
+			lastPass := "-"
+			if len(history) > 0 {
+				lastPass = ago(now, history[len(history)-1].TimeMs)
+			}
+
+			read, written := "-", "-"
+			if last, ok := d.bytes[key]; ok && elapsed > 0 &&
+				p.BytesRead >= last[0] && p.BytesWritten >= last[1] {
+				read = formatRate(p.BytesRead-last[0], elapsed)
+				written = formatRate(p.BytesWritten-last[1], elapsed)
+			}
+			bytes[key] = [2]uint64{p.BytesRead, p.BytesWritten}
+
+			lastErr := "-"
+			if p.LastError != "" {
+				lastErr = truncate(p.LastError, maxErrorWidth)
+			}
+
+			fmt.Fprintf(tw, "%s\t%s@%s:%s\t%s\t%d\t%s\t%s\t%d\t%s\t%s\t%s\n",
+				name, p.Source, p.Datacenter, p.Destination, health, p.LastReplicated,
+				lastPass, lag(history, now), keysPerMinute(history, now), read, written,
+				lastErr)
+		}
+	}
+	tw.Flush()
+
+	d.bytes, d.bytesTime = bytes, now
+}
+
+// dashboardKey identifies a prefix of a replicator across the status and stats
+// of an instance.
+func dashboardKey(replicator, source, datacenter, destination string) string {
+	return fmt.Sprintf("%s\x00%s@%s:%s", replicator, source, datacenter, destination)
+}
+
+// lag returns how long the prefix has been behind its source, counted from
+// its first failed pass after its last successful one, like the max_lag alert
+// rule. It is "0s" while the last pass succeeded, or "-" without passes.
+func lag(passes []*control.PassStats, now time.Time) string {
+	if len(passes) == 0 {
+		return "-"
+	}
+
+	since := int64(0)
+	for i := len(passes) - 1; i >= 0 && passes[i].Error != ""; i-- {
+		since = passes[i].TimeMs
+	}
+	if since == 0 {
+		return "0s"
+	}
+	return ago(now, since)
+}
+
+// keysPerMinute returns the number of keys written and deleted by the passes
+// which finished within the last minute.
+func keysPerMinute(passes []*control.PassStats, now time.Time) uint32 {
+	var keys uint32
+	since := now.Add(-time.Minute).UnixMilli()
+	for _, p := range passes {
+		if p.TimeMs > since {
+			keys += p.Puts + p.Deletes
+		}
+	}
+	return keys
+}
+
+// ago returns how long before now the given time in milliseconds since the
+// Unix epoch was, rounded to the second.
+func ago(now time.Time, ms int64) string {
+	d := now.Sub(time.UnixMilli(ms)).Round(time.Second)
+	if d < 0 {
+		d = 0
+	}
+	return d.String()
+}
+
+// formatRate returns the given bytes over the given seconds, in human readable
+// units per second.
+func formatRate(bytes uint64, seconds float64) string {
+	return formatBytes(float64(bytes) / seconds)
+}
+
+// formatBytes returns the given number of bytes in human readable units.
+func formatBytes(n float64) string {
+	for _, unit := range []string{"B", "KB", "MB"} {
+		if n < 1024 {
+			return fmt.Sprintf("%.1f %s", n, unit)
+		}
+		n /= 1024
+	}
+	return fmt.Sprintf("%.1f GB", n)
+}
+
+// truncate shortens s to at most n characters, marking that it was shortened.
+func truncate(s string, n int) string {
+	r := []rune(s)
+	if len(r) <= n {
+		return s
+	}
+	return string(r[:n-3]) + "..."
+}
@@ -0,0 +1,117 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: MPL-2.0
+
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/hashicorp/consul-replicate/control"
+)
+
+func TestDashboard_render(t *testing.T) {
+	now := time.Date(2026, 10, 16, 8, 0, 0, 0, time.UTC)
+	ms := func(d time.Duration) int64 {
+		return now.Add(-d).UnixMilli()
+	}
+
+	status := func(read, written uint64) *control.StatusResponse {
+		return &control.StatusResponse{
+			Replicators: []*control.ReplicatorStatus{
+				{
+					Prefixes: []*control.PrefixStatus{
+						{
+							Source:         "global",
+							Datacenter:     "dc1",
+							Destination:    "global",
+							LastReplicated: 42,
+							Healthy:        true,
+							BytesRead:      read,
+							BytesWritten:   written,
+						},
+					},
+				},
+				{
+					Name:   "edge",
+					Paused: true,
+					Prefixes: []*control.PrefixStatus{
+						{
+							Source:              "edge",
+							Datacenter:          "dc2",
+							Destination:         "edge",
+							LastReplicated:      7,
+							ConsecutiveFailures: 2,
+							LastError:           "Unexpected response code: 500",
+						},
+					},
+				},
+			},
+		}
+	}
+	stats := &control.StatsResponse{
+		Replicators: []*control.ReplicatorStats{
+			{
+				Prefixes: []*control.PrefixStats{
+					{
+						Source:      "global",
+						Datacenter:  "dc1",
+						Destination: "global",
+						Passes: []*control.PassStats{
+							{TimeMs: ms(2 * time.Minute), Puts: 100},
+							{TimeMs: ms(30 * time.Second), Puts: 3, Deletes: 1},
+							{TimeMs: ms(5 * time.Second), Puts: 1},
+						},
+					},
+				},
+			},
+			{
+				Name: "edge",
+				Prefixes: []*control.PrefixStats{
+					{
+						Source:      "edge",
+						Datacenter:  "dc2",
+						Destination: "edge",
+						Passes: []*control.PassStats{
+							{TimeMs: ms(3 * time.Minute)},
+							{TimeMs: ms(90 * time.Second), Error: "boom"},
+							{TimeMs: ms(20 * time.Second), Error: "Unexpected response code: 500"},
+						},
+					},
+				},
+			},
+		},
+	}
+
+	d := newDashboard("127.0.0.1:8600")
+	d.render(&bytes.Buffer{}, status(1024, 0), stats, now.Add(-2*time.Second))
+
+	var buf bytes.Buffer
+	d.render(&buf, status(3072, 512), stats, now)
+
+	exp := "consul-replicate top - 127.0.0.1:8600 - 2026-10-16T08:00:00Z - 2 prefixes, 1 failing\n" +
+		"\n" +
+		"REPLICATOR     PREFIX             HEALTH       INDEX  LAST PASS  LAG    KEYS/MIN  READ/S  WRITE/S  ERROR\n" +
+		"-              global@dc1:global  ok           42     5s         0s     5         1.0 KB  256.0 B  -\n" +
+		"edge (paused)  edge@dc2:edge      failing (2)  7      20s        1m30s  0         0.0 B   0.0 B    Unexpected response code: 500\n"
+
+	cases := []struct {
+		name string
+		exp  string
+		act  string
+	}{
+		{"frame", exp, buf.String()},
+		{"truncate", "abcdefg...", truncate("abcdefghijklmnop", 10)},
+		{"rate", "1.5 MB", formatRate(3*1024*1024, 2)},
+	}
+
+	for i, tc := range cases {
+		t.Run(fmt.Sprintf("%d_%s", i, tc.name), func(t *testing.T) {
+			if tc.act != tc.exp {
+				t.Errorf("\nexp: %#v\nact: %#v", tc.exp, tc.act)
+			}
+		})
+	}
+}
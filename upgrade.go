@@ -0,0 +1,293 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: MPL-2.0
+
+package main
+
+import (
+	"archive/zip"
+	"bufio"
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/ProtonMail/go-crypto/openpgp"
+)
+
+const (
+	// releasesURL is where the releases of the binary are published.
+	releasesURL = "https://releases.hashicorp.com"
+
+	// releaseName is the name of the binary in the releases.
+	releaseName = "consul-replicate"
+
+	// releaseTimeout is how long the download of every file of a release may
+	// take.
+	releaseTimeout = 5 * time.Minute
+)
+
+// release is a version of the binary published on a releases server.
+type release struct {
+	baseURL, version string
+
+	client *http.Client
+}
+
+// newRelease returns the release of the version published on the server at
+// the base URL.
+func newRelease(baseURL, version string) *release {
+	return &release{
+		baseURL: strings.TrimSuffix(baseURL, "/"),
+		version: strings.TrimPrefix(version, "v"),
+		client:  &http.Client{Timeout: releaseTimeout},
+	}
+}
+
+// sumsFile, archiveFile and url return the names of the files of the release,
+// and where they are downloaded from.
+func (r *release) sumsFile() string {
+	return fmt.Sprintf("%s_%s_SHA256SUMS", releaseName, r.version)
+}
+
+func (r *release) archiveFile(platform string) string {
+	return fmt.Sprintf("%s_%s_%s.zip", releaseName, r.version, platform)
+}
+
+func (r *release) url(file string) string {
+	return fmt.Sprintf("%s/%s/%s/%s", r.baseURL, releaseName, r.version, file)
+}
+
+// download returns the contents of the file of the release.
+func (r *release) download(file string) ([]byte, error) {
+	resp, err := r.client.Get(r.url(file))
+	if err != nil {
+		return nil, fmt.Errorf("upgrade: downloading %s: %s", file, err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("upgrade: downloading %s: unexpected response code %d",
+			file, resp.StatusCode)
+	}
+	b, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, fmt.Errorf("upgrade: downloading %s: %s", file, err)
+	}
+	return b, nil
+}
+
+// binary downloads the checksums of the release and their signature, verifies
+// the signature with the keyring, and returns the binary of the release for
+// the platform once its archive matches its checksum.
+func (r *release) binary(keyring openpgp.EntityList, platform string) ([]byte, error) {
+	sums, err := r.download(r.sumsFile())
+	if err != nil {
+		return nil, err
+	}
+	sig, err := r.download(r.sumsFile() + ".sig")
+	if err != nil {
+		return nil, err
+	}
+	if err := verifySums(keyring, sums, sig); err != nil {
+		return nil, err
+	}
+
+	checksums, err := parseSums(sums)
+	if err != nil {
+		return nil, err
+	}
+	file := r.archiveFile(platform)
+	expected, ok := checksums[file]
+	if !ok {
+		return nil, fmt.Errorf("upgrade: no release of %s for %s, only for %s",
+			r.version, platform, strings.Join(sumsPlatforms(checksums, r), ", "))
+	}
+
+	archive, err := r.download(file)
+	if err != nil {
+		return nil, err
+	}
+	if actual := sha256.Sum256(archive); hex.EncodeToString(actual[:]) != expected {
+		return nil, fmt.Errorf("upgrade: checksum of %s does not match %s", file, r.sumsFile())
+	}
+	return extractBinary(archive, platform)
+}
+
+// releasePlatform returns the platform of the running binary, as named in the
+// archives of the releases.
+func releasePlatform() string {
+	return runtime.GOOS + "_" + runtime.GOARCH
+}
+
+// readKeyring reads the armored public keys the releases are signed with.
+func readKeyring(path string) (openpgp.EntityList, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("upgrade: %s", err)
+	}
+	defer f.Close()
+
+	keyring, err := openpgp.ReadArmoredKeyRing(f)
+	if err != nil {
+		return nil, fmt.Errorf("upgrade: reading key %q: %s", path, err)
+	}
+	return keyring, nil
+}
+
+// verifySums returns an error unless the signature of the checksums was made
+// with one of the keys of the keyring.
+func verifySums(keyring openpgp.EntityList, sums, sig []byte) error {
+	if _, err := openpgp.CheckDetachedSignature(keyring, bytes.NewReader(sums), bytes.NewReader(sig), nil); err != nil {
+		return fmt.Errorf("upgrade: verifying the signature of the checksums: %s", err)
+	}
+	return nil
+}
+
+// parseSums returns the checksums of a SHA256SUMS file, keyed by file name.
+func parseSums(b []byte) (map[string]string, error) {
+	sums := make(map[string]string)
+	scanner := bufio.NewScanner(bytes.NewReader(b))
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) == 0 {
+			continue
+		}
+		if len(fields) != 2 {
+			return nil, fmt.Errorf("upgrade: malformed checksum line %q", scanner.Text())
+		}
+		sums[strings.TrimPrefix(fields[1], "*")] = strings.ToLower(fields[0])
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("upgrade: reading checksums: %s", err)
+	}
+	return sums, nil
+}
+
+// sumsPlatforms returns the sorted platforms the release has archives for.
+func sumsPlatforms(sums map[string]string, r *release) []string {
+	prefix := fmt.Sprintf("%s_%s_", releaseName, r.version)
+	var platforms []string
+	for file := range sums {
+		if strings.HasPrefix(file, prefix) && strings.HasSuffix(file, ".zip") {
+			platforms = append(platforms, strings.TrimSuffix(strings.TrimPrefix(file, prefix), ".zip"))
+		}
+	}
+	sort.Strings(platforms)
+	return platforms
+}
+
+// extractBinary returns the binary in the archive of a release for the
+// platform.
+func extractBinary(archive []byte, platform string) ([]byte, error) {
+	z, err := zip.NewReader(bytes.NewReader(archive), int64(len(archive)))
+	if err != nil {
+		return nil, fmt.Errorf("upgrade: reading archive: %s", err)
+	}
+
+	name := releaseName
+	if strings.HasPrefix(platform, "windows_") {
+		name += ".exe"
+	}
+	for _, f := range z.File {
+		if f.Name != name {
+			continue
+		}
+		rc, err := f.Open()
+		if err != nil {
+			return nil, fmt.Errorf("upgrade: reading archive: %s", err)
+		}
+		defer rc.Close()
+		b, err := io.ReadAll(rc)
+		if err != nil {
+			return nil, fmt.Errorf("upgrade: reading archive: %s", err)
+		}
+		return b, nil
+	}
+	return nil, fmt.Errorf("upgrade: no %s in archive", name)
+}
+
+// installBinary replaces the binary at the path with the given one, keeping a
+// copy of the replaced binary next to it with the .old suffix. The new binary
+// must print the expected version, which also fails if it was built for
+// another architecture.
+func installBinary(path string, b []byte, version string) error {
+	if resolved, err := filepath.EvalSymlinks(path); err == nil {
+		path = resolved
+	}
+	info, err := os.Stat(path)
+	if err != nil {
+		return fmt.Errorf("upgrade: %s", err)
+	}
+
+	// The new binary is written next to the old one, so renaming it over the
+	// old one is atomic
+	tmp := filepath.Join(filepath.Dir(path), "."+filepath.Base(path)+".new")
+	if err := os.WriteFile(tmp, b, info.Mode().Perm()); err != nil {
+		return fmt.Errorf("upgrade: %s", err)
+	}
+	defer os.Remove(tmp)
+
+	out, err := exec.Command(tmp, "-version").CombinedOutput()
+	if err != nil {
+		return fmt.Errorf("upgrade: new binary does not run on this host: %s", err)
+	}
+	if !strings.Contains(string(out), " v"+version+" ") {
+		return fmt.Errorf("upgrade: new binary reports %q, expected v%s",
+			strings.TrimSpace(string(out)), version)
+	}
+
+	backup := path + ".old"
+	old, err := os.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("upgrade: %s", err)
+	}
+	if err := os.WriteFile(backup, old, info.Mode().Perm()); err != nil {
+		return fmt.Errorf("upgrade: keeping the replaced binary: %s", err)
+	}
+
+	// Windows cannot replace a running binary, but can rename it
+	if err := os.Rename(tmp, path); err != nil {
+		if err := os.Rename(path, backup); err != nil {
+			return fmt.Errorf("upgrade: %s", err)
+		}
+		if err := os.Rename(tmp, path); err != nil {
+			os.Rename(backup, path)
+			return fmt.Errorf("upgrade: %s", err)
+		}
+	}
+	log.Printf("[INFO] (cli) installed v%s at %s, the replaced binary is kept at %s",
+		version, path, backup)
+	return nil
+}
+
+// signalPid sends the signal to the process whose PID is in the file.
+func signalPid(path string, sig os.Signal) (int, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return 0, fmt.Errorf("upgrade: reading pid file: %s", err)
+	}
+	pid, err := strconv.Atoi(strings.TrimSpace(string(b)))
+	if err != nil {
+		return 0, fmt.Errorf("upgrade: reading pid file %q: %s", path, err)
+	}
+
+	p, err := os.FindProcess(pid)
+	if err != nil {
+		return 0, fmt.Errorf("upgrade: %s", err)
+	}
+	if err := p.Signal(sig); err != nil {
+		return 0, fmt.Errorf("upgrade: signaling pid %d: %s", pid, err)
+	}
+	return pid, nil
+}
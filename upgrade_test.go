@@ -0,0 +1,161 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: MPL-2.0
+
+package main
+
+import (
+	"archive/zip"
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/ProtonMail/go-crypto/openpgp"
+)
+
+func TestParseSums(t *testing.T) {
+	cases := []struct {
+		name string
+		sums string
+		exp  map[string]string
+		err  bool
+	}{
+		{
+			"empty",
+			"",
+			map[string]string{},
+			false,
+		},
+		{
+			"files",
+			"AB12  consul-replicate_0.5.0_linux_amd64.zip\n" +
+				"cd34 *consul-replicate_0.5.0_linux_arm64.zip\n\n",
+			map[string]string{
+				"consul-replicate_0.5.0_linux_amd64.zip": "ab12",
+				"consul-replicate_0.5.0_linux_arm64.zip": "cd34",
+			},
+			false,
+		},
+		{
+			"malformed",
+			"ab12 consul-replicate_0.5.0_linux_amd64.zip extra\n",
+			nil,
+			true,
+		},
+	}
+
+	for i, tc := range cases {
+		t.Run(fmt.Sprintf("%d_%s", i, tc.name), func(t *testing.T) {
+			act, err := parseSums([]byte(tc.sums))
+			if (err != nil) != tc.err {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(tc.exp, act) {
+				t.Errorf("\nexp: %#v\nact: %#v", tc.exp, act)
+			}
+		})
+	}
+}
+
+func TestRelease_binary(t *testing.T) {
+	signer, err := openpgp.NewEntity("releases", "", "releases@example.com", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	other, err := openpgp.NewEntity("other", "", "other@example.com", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var archive bytes.Buffer
+	z := zip.NewWriter(&archive)
+	w, err := z.Create(releaseName)
+	if err != nil {
+		t.Fatal(err)
+	}
+	w.Write([]byte("binary"))
+	if err := z.Close(); err != nil {
+		t.Fatal(err)
+	}
+	sum := sha256.Sum256(archive.Bytes())
+
+	cases := []struct {
+		name     string
+		keyring  openpgp.EntityList
+		platform string
+		sum      string
+		err      string
+	}{
+		{
+			"verified",
+			openpgp.EntityList{signer},
+			"linux_arm64",
+			hex.EncodeToString(sum[:]),
+			"",
+		},
+		{
+			"unknown_key",
+			openpgp.EntityList{other},
+			"linux_arm64",
+			hex.EncodeToString(sum[:]),
+			"verifying the signature",
+		},
+		{
+			"checksum_mismatch",
+			openpgp.EntityList{signer},
+			"linux_arm64",
+			strings.Repeat("0", 64),
+			"does not match",
+		},
+		{
+			"unknown_platform",
+			openpgp.EntityList{signer},
+			"plan9_mips",
+			hex.EncodeToString(sum[:]),
+			"only for linux_arm64",
+		},
+	}
+
+	for i, tc := range cases {
+		t.Run(fmt.Sprintf("%d_%s", i, tc.name), func(t *testing.T) {
+			sums := []byte(tc.sum + "  consul-replicate_0.5.0_linux_arm64.zip\n")
+			var sig bytes.Buffer
+			if err := openpgp.DetachSign(&sig, signer, bytes.NewReader(sums), nil); err != nil {
+				t.Fatal(err)
+			}
+			files := map[string][]byte{
+				"/consul-replicate/0.5.0/consul-replicate_0.5.0_SHA256SUMS":      sums,
+				"/consul-replicate/0.5.0/consul-replicate_0.5.0_SHA256SUMS.sig":  sig.Bytes(),
+				"/consul-replicate/0.5.0/consul-replicate_0.5.0_linux_arm64.zip": archive.Bytes(),
+			}
+			srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				b, ok := files[r.URL.Path]
+				if !ok {
+					http.NotFound(w, r)
+					return
+				}
+				w.Write(b)
+			}))
+			defer srv.Close()
+
+			b, err := newRelease(srv.URL+"/", "v0.5.0").binary(tc.keyring, tc.platform)
+			if tc.err != "" {
+				if err == nil || !strings.Contains(err.Error(), tc.err) {
+					t.Fatalf("expected error containing %q, got %v", tc.err, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(b) != "binary" {
+				t.Errorf("\nexp: %#v\nact: %#v", "binary", string(b))
+			}
+		})
+	}
+}